@@ -21,6 +21,12 @@ CAPDEFS = [
         url="https://ircv3.net/specs/extensions/account-notify-3.1.html",
         standard="IRCv3",
     ),
+    CapDef(
+        identifier="AccountRegistration",
+        name="draft/account-registration",
+        url="https://github.com/ircv3/ircv3-specifications/pull/435",
+        standard="proposed IRCv3",
+    ),
     CapDef(
         identifier="AccountTag",
         name="account-tag",
@@ -87,18 +93,42 @@ CAPDEFS = [
         url="https://oragono.io/maxline-2",
         standard="Oragono-specific",
     ),
+    CapDef(
+        identifier="MessageRedaction",
+        name="draft/message-redaction",
+        url="https://github.com/ircv3/ircv3-specifications/pull/524",
+        standard="proposed IRCv3",
+    ),
     CapDef(
         identifier="MessageTags",
         name="message-tags",
         url="https://ircv3.net/specs/extensions/message-tags.html",
         standard="IRCv3",
     ),
+    CapDef(
+        identifier="Metadata",
+        name="draft/metadata-2",
+        url="https://ircv3.net/specs/extensions/metadata",
+        standard="proposed IRCv3",
+    ),
     CapDef(
         identifier="MultiPrefix",
         name="multi-prefix",
         url="https://ircv3.net/specs/extensions/multi-prefix-3.1.html",
         standard="IRCv3",
     ),
+    CapDef(
+        identifier="ReadMarker",
+        name="draft/read-marker",
+        url="https://github.com/ircv3/ircv3-specifications/pull/489",
+        standard="proposed IRCv3",
+    ),
+    CapDef(
+        identifier="Relaymsg",
+        name="draft/relaymsg",
+        url="https://github.com/ircv3/ircv3-specifications/pull/417",
+        standard="proposed IRCv3",
+    ),
     CapDef(
         identifier="Rename",
         name="draft/rename",
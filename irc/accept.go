@@ -0,0 +1,134 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+const (
+	// a +g client is notified about blocked messages at most once per this interval
+	callerIDNotifyInterval = time.Minute
+)
+
+// AcceptsMessagesFrom returns whether `client` will receive private messages from `sender`;
+// this is only false if `client` has caller-ID (+g) set and hasn't accepted `sender`.
+func (client *Client) AcceptsMessagesFrom(sender *Client) bool {
+	if client == sender || !client.HasMode(modes.CallerID) {
+		return true
+	}
+	return client.hasAccepted(sender)
+}
+
+// hasAccepted returns whether `sender` is on `client`'s accept list, by nickname
+// or by account.
+func (client *Client) hasAccepted(sender *Client) bool {
+	senderNick := sender.NickCasefolded()
+	senderAccount := sender.Account()
+
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.accepted[senderNick] || (senderAccount != "" && client.accepted[senderAccount])
+}
+
+// AcceptList returns the (casefolded) nicknames on the client's accept list.
+func (client *Client) AcceptList() (result []string) {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	for cfnick := range client.accepted {
+		result = append(result, cfnick)
+	}
+	return
+}
+
+// AddAccept adds a casefolded nickname to the client's accept list.
+func (client *Client) AddAccept(cfnick string, limit int) (added bool, err error) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	if client.accepted[cfnick] {
+		return false, nil
+	}
+	if limit <= len(client.accepted) {
+		return false, errAcceptListFull
+	}
+	if client.accepted == nil {
+		client.accepted = make(map[string]bool)
+	}
+	client.accepted[cfnick] = true
+	return true, nil
+}
+
+// RemoveAccept removes a casefolded nickname from the client's accept list.
+func (client *Client) RemoveAccept(cfnick string) (removed bool) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	removed = client.accepted[cfnick]
+	delete(client.accepted, cfnick)
+	return
+}
+
+// shouldNotifyCallerID returns whether a +g client should be told about a blocked message,
+// rate-limiting these notifications so they can't be used for flooding.
+func (client *Client) shouldNotifyCallerID() bool {
+	now := client.server.Clock().Now()
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	if now.Sub(client.callerIDNotified) < callerIDNotifyInterval {
+		return false
+	}
+	client.callerIDNotified = now
+	return true
+}
+
+// checkCallerID determines whether `client` can send a private message to `target`.
+// if it can't, and `notify` is set, the appropriate numerics are sent to both parties
+// (this should be disabled for NOTICE, which must never generate automatic replies).
+func checkCallerID(server *Server, client, target *Client, notify bool, rb *ResponseBuffer) bool {
+	if target.AcceptsMessagesFrom(client) {
+		return true
+	}
+
+	if notify {
+		cnick := client.Nick()
+		tnick := target.Nick()
+		rb.Add(nil, server.name, ERR_TARGUMODEG, cnick, tnick, client.t("is in +g mode (server-side ignore)"))
+		if target.shouldNotifyCallerID() {
+			target.Send(nil, server.name, RPL_UMODEGMSG, tnick, cnick, fmt.Sprintf("%s@%s", client.Username(), client.Hostname()), fmt.Sprintf(target.t("is messaging you, and you have user mode +g set. Use /ACCEPT %s to allow."), cnick))
+			rb.Add(nil, server.name, RPL_TARGNOTIFY, cnick, tnick, client.t("has been informed that you messaged them"))
+		}
+	}
+	return false
+}
+
+// persistAcceptList stores `client`'s accept list with their account, if they're logged in.
+func persistAcceptList(server *Server, client *Client) {
+	account := client.Account()
+	if account == "" {
+		return
+	}
+	err := server.accounts.StoreAcceptList(account, client.AcceptList())
+	if err != nil {
+		server.logger.Error("internal", "couldn't store accept list", account, err.Error())
+	}
+}
+
+// restoreAcceptList adds the accept list stored with `client`'s account to their current list.
+func restoreAcceptList(server *Server, client *Client) {
+	account := client.Account()
+	if account == "" {
+		return
+	}
+	limit := server.Limits().AcceptEntries
+	for _, cfnick := range server.accounts.LoadAcceptList(account) {
+		if _, err := client.AddAccept(cfnick, limit); err != nil {
+			break
+		}
+	}
+}
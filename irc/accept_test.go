@@ -0,0 +1,112 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+func TestAccept(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	alice.Send("MODE", "alice", "+g")
+	alice.Expect("MODE")
+	bob := h.Register("bob")
+
+	bob.Send("PRIVMSG", "alice", "hi")
+	bob.Expect(ERR_TARGUMODEG)
+
+	alice.Send("ACCEPT", "bob")
+	alice.Send("ACCEPT", "bob")
+	alice.Expect(ERR_ACCEPTEXIST)
+	alice.Send("ACCEPT", "*")
+	if msg := alice.Expect(RPL_ACCEPTLIST); msg.Params[1] != "bob" {
+		t.Errorf("unexpected accept list: %v", msg)
+	}
+	alice.Expect(RPL_ENDOFACCEPT)
+	bob.Send("PRIVMSG", "alice", "hi again")
+	if msg := alice.Expect("PRIVMSG"); msg.Params[1] != "hi again" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+
+	alice.Send("ACCEPT", "-bob")
+	alice.Send("ACCEPT", "-bob")
+	alice.Expect(ERR_ACCEPTNOT)
+	alice.Send("ACCEPT", "*")
+	alice.Expect(RPL_ENDOFACCEPT)
+	alice.Lock()
+	if prev := alice.lines[alice.read-2]; prev.Command == RPL_ACCEPTLIST {
+		t.Errorf("accept list should be empty: %v", prev)
+	}
+	alice.Unlock()
+	bob.Send("PRIVMSG", "alice", "still there?")
+	bob.Expect(ERR_TARGUMODEG)
+}
+
+func TestAcceptAccount(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	if err := h.server.accounts.Register(nil, "bob", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.accounts.Verify(nil, "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := h.Register("alice")
+	alice.Send("MODE", "alice", "+g")
+	alice.Expect("MODE")
+	alice.Send("ACCEPT", "bob")
+	alice.Sync()
+
+	// accepting an account name lets its user through under any nick
+	bob := h.Register("robert")
+	bob.Send("PRIVMSG", "alice", "hi")
+	bob.Expect(ERR_TARGUMODEG)
+	bob.Send("NS", "IDENTIFY", "bob", "hunter2")
+	if msg := bob.Expect("NOTICE"); !strings.Contains(msg.Params[1], "now logged in") {
+		t.Fatalf("couldn't log in: %v", msg)
+	}
+	bob.Send("PRIVMSG", "alice", "hi again")
+	if msg := alice.Expect("PRIVMSG"); !strings.HasPrefix(msg.Prefix, "robert!") || msg.Params[1] != "hi again" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+}
+
+func TestCallerIDNotify(t *testing.T) {
+	clock := utils.NewFakeClock(time.Now())
+	h := newTestHarness(t, clock, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	alice.Send("MODE", "alice", "+g")
+	alice.Expect("MODE")
+	bob := h.Register("bob")
+
+	bob.Send("PRIVMSG", "alice", "hi")
+	bob.Expect(ERR_TARGUMODEG)
+	bob.Expect(RPL_TARGNOTIFY)
+	if msg := alice.Expect(RPL_UMODEGMSG); msg.Params[1] != "bob" {
+		t.Errorf("unexpected notification: %v", msg)
+	}
+
+	// the target is only notified once per interval
+	bob.Send("PRIVMSG", "alice", "hi again")
+	bob.Expect(ERR_TARGUMODEG)
+	if msg, ok := alice.ExpectWithin(RPL_UMODEGMSG, 100*time.Millisecond); ok {
+		t.Errorf("alice shouldn't have been notified again: %v", msg)
+	}
+
+	clock.Advance(callerIDNotifyInterval)
+	bob.Send("PRIVMSG", "alice", "are you there?")
+	bob.Expect(RPL_TARGNOTIFY)
+	alice.Expect(RPL_UMODEGMSG)
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/oragono/oragono/irc/utils"
+	"github.com/tidwall/buntdb"
+)
+
+// Users can export everything the server stores about their accounts, and can
+// have their accounts erased: this unregisters the account (with everything
+// stored alongside it) and deletes the messages they sent from the stored
+// history. Erasure takes effect after a grace period, during which it can be
+// cancelled. Exports and erasures are logged with the "audit" log type.
+
+const (
+	// how often to look for accounts that are due to be erased
+	accountErasureCheckInterval = time.Minute
+	// maximum length in bytes of a line of NickServ EXPORT output
+	accountDataExportLineWidth = 400
+)
+
+var (
+	errErasureNotScheduled = errors.New("Account erasure was not requested")
+)
+
+// AccountErasureConfig controls whether users can have their accounts erased.
+type AccountErasureConfig struct {
+	Enabled bool
+	// erasure takes effect this long after it's requested
+	GracePeriodString string `yaml:"grace-period"`
+	gracePeriod       time.Duration
+}
+
+func (conf *AccountErasureConfig) prepare() (err error) {
+	if !conf.Enabled || conf.GracePeriodString == "" {
+		return nil
+	}
+	conf.gracePeriod, err = custime.ParseDuration(conf.GracePeriodString)
+	if err != nil || conf.gracePeriod < 0 {
+		return fmt.Errorf("Could not parse account erasure grace period: %s", conf.GracePeriodString)
+	}
+	return nil
+}
+
+// AccountDataExport is everything stored about an account, as exported with
+// NickServ EXPORT or the API.
+type AccountDataExport struct {
+	Name            string                `json:"name"`
+	RegisteredAt    time.Time             `json:"registered_at"`
+	Verified        bool                  `json:"verified"`
+	Callback        string                `json:"callback,omitempty"`
+	CertFP          string                `json:"certfp,omitempty"`
+	HasPassphrase   bool                  `json:"has_passphrase"`
+	AdditionalNicks []string              `json:"additional_nicks,omitempty"`
+	VHost           VHostInfo             `json:"vhost"`
+	Channels        []string              `json:"registered_channels,omitempty"`
+	Monitor         []string              `json:"monitor,omitempty"`
+	Accept          []string              `json:"accept,omitempty"`
+	Settings        AccountSettings       `json:"settings"`
+	ReadMarkers     readMarkers           `json:"read_markers,omitempty"`
+	Personas        map[string]Persona    `json:"personas,omitempty"`
+	Metadata        map[string]string     `json:"metadata,omitempty"`
+	APITokens       []AccountDataAPIToken `json:"api_tokens,omitempty"`
+	AJoin           []AJoinEntry          `json:"ajoin,omitempty"`
+	LastLogin       *time.Time            `json:"last_login,omitempty"`
+	LastQuit        *time.Time            `json:"last_quit,omitempty"`
+	Suspended       bool                  `json:"suspended"`
+	SuspendReason   string                `json:"suspend_reason,omitempty"`
+	ErasureAt       *time.Time            `json:"erasure_at,omitempty"`
+	// messages sent from the account that are still in the stored history
+	History []AccountDataHistoryItem `json:"history"`
+}
+
+// AccountDataAPIToken describes one of the account's API tokens (but not its secret).
+type AccountDataAPIToken struct {
+	Name         string     `json:"name"`
+	Capabilities []string   `json:"capabilities"`
+	Channels     []string   `json:"channels,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsed     *time.Time `json:"last_used,omitempty"`
+}
+
+// AccountDataHistoryItem is a stored message sent from an account.
+type AccountDataHistoryItem struct {
+	Target  string    `json:"target"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Nick    string    `json:"nick"`
+	Message string    `json:"message,omitempty"`
+}
+
+// authoredBy returns a history predicate matching the items sent by clients
+// logged into the (casefolded) account.
+func authoredBy(account string) history.Predicate {
+	return func(item history.Item) bool {
+		cfaccount, err := CasefoldName(item.AccountName)
+		return err == nil && cfaccount == account
+	}
+}
+
+// historyCommand returns the IRC command corresponding to a history item.
+func historyCommand(itemType history.ItemType) string {
+	switch itemType {
+	case history.Privmsg:
+		return "PRIVMSG"
+	case history.Notice:
+		return "NOTICE"
+	case history.Tagmsg:
+		return "TAGMSG"
+	case history.Join:
+		return "JOIN"
+	case history.Part:
+		return "PART"
+	case history.Kick:
+		return "KICK"
+	case history.Quit:
+		return "QUIT"
+	case history.Mode:
+		return "MODE"
+	default:
+		return ""
+	}
+}
+
+// authoredHistory returns the stored history items sent from the account, in
+// channels and in direct messages.
+func (server *Server) authoredHistory(account string) (result []AccountDataHistoryItem) {
+	add := func(target string, items []history.Item) {
+		for _, item := range items {
+			var message string
+			switch item.Type {
+			case history.Privmsg, history.Notice, history.Part, history.Quit:
+				message = item.Message.Message
+			}
+			result = append(result, AccountDataHistoryItem{
+				Target:  target,
+				Time:    item.Time,
+				Command: historyCommand(item.Type),
+				Nick:    item.Nick,
+				Message: message,
+			})
+		}
+	}
+	predicate := authoredBy(account)
+	for _, channel := range server.channels.Channels() {
+		add(channel.Name(), channel.history.Match(predicate, true, 0))
+	}
+	for _, client := range server.clients.AllClients() {
+		add(client.Nick(), client.history.Match(predicate, true, 0))
+	}
+	return
+}
+
+// deleteHistory deletes the stored history items (in channels and in direct
+// messages) that match the predicate.
+func (server *Server) deleteHistory(predicate history.Predicate) (count int) {
+	for _, channel := range server.channels.Channels() {
+		count += channel.history.Delete(predicate)
+	}
+	for _, client := range server.clients.AllClients() {
+		count += client.history.Delete(predicate)
+	}
+	return
+}
+
+// ExportAccountData collects everything stored about the account.
+func (server *Server) ExportAccountData(accountName string) (result AccountDataExport, err error) {
+	am := server.accounts
+	account, err := CasefoldName(accountName)
+	if err != nil {
+		return result, errAccountDoesNotExist
+	}
+	var raw rawClientAccount
+	var markers readMarkers
+	var personas map[string]Persona
+	var metadata map[string]string
+	var apiTokens map[string]APIToken
+	var aJoin []AJoinEntry
+	var erasureAt time.Time
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		raw, err = am.loadRawAccount(tx, account)
+		markers = am.loadReadMarkers(tx, account)
+		personas = am.loadPersonas(tx, account)
+		metadata = am.loadMetadata(tx, account)
+		apiTokens = am.loadAPITokens(tx, account)
+		aJoin = am.loadAJoinList(tx, account)
+		erasureStr, _ := tx.Get(fmt.Sprintf(keyAccountErasure, account))
+		erasureAt = parseAccountTime(erasureStr)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	clientAccount, err := am.deserializeRawAccount(raw)
+	if err != nil {
+		return
+	}
+
+	result = AccountDataExport{
+		Name:            clientAccount.Name,
+		RegisteredAt:    clientAccount.RegisteredAt.UTC(),
+		Verified:        clientAccount.Verified,
+		Callback:        raw.Callback,
+		CertFP:          clientAccount.Credentials.Certificate,
+		HasPassphrase:   len(clientAccount.Credentials.PassphraseHash) != 0,
+		AdditionalNicks: clientAccount.AdditionalNicks,
+		VHost:           clientAccount.VHost,
+		Channels:        am.ChannelsForAccount(account),
+		Monitor:         am.LoadMonitorList(account),
+		Accept:          am.LoadAcceptList(account),
+		Settings:        am.LoadSettings(account),
+		ReadMarkers:     markers,
+		Personas:        personas,
+		Metadata:        metadata,
+		APITokens:       exportAPITokens(apiTokens),
+		AJoin:           aJoin,
+		LastLogin:       optionalTime(clientAccount.LastLogin),
+		LastQuit:        optionalTime(clientAccount.LastQuit),
+		Suspended:       clientAccount.Suspended,
+		SuspendReason:   clientAccount.SuspendReason,
+		ErasureAt:       optionalTime(erasureAt),
+		History:         server.authoredHistory(account),
+	}
+	if result.History == nil {
+		result.History = make([]AccountDataHistoryItem, 0)
+	}
+	server.logger.Info("audit", "exported data for account", account)
+	return
+}
+
+// ScheduleErasure schedules the (casefolded) account to be erased once the
+// grace period has passed, returning when that will happen. If immediate is
+// set, or there's no grace period, the account is erased right away.
+func (am *AccountManager) ScheduleErasure(account string, immediate bool) (erasureAt time.Time, err error) {
+	config := am.server.AccountConfig().Erasure
+	now := time.Now()
+	if immediate || config.gracePeriod == 0 {
+		return now, am.server.eraseAccount(account)
+	}
+	erasureAt = now.Add(config.gracePeriod)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return errAccountDoesNotExist
+		}
+		// a pending erasure keeps its original date
+		key := fmt.Sprintf(keyAccountErasure, account)
+		existingStr, _ := tx.Get(key)
+		if existing := parseAccountTime(existingStr); !existing.IsZero() {
+			erasureAt = existing
+			return nil
+		}
+		_, _, err := tx.Set(key, strconv.FormatInt(erasureAt.Unix(), 10), nil)
+		return err
+	})
+	if err == nil {
+		am.server.logger.Info("audit", "account erasure requested", account, erasureAt.UTC().Format(time.RFC1123))
+		am.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf("Erasure of account %s was requested", account))
+	}
+	return
+}
+
+// CancelErasure cancels a pending erasure of the (casefolded) account.
+func (am *AccountManager) CancelErasure(account string) (err error) {
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(fmt.Sprintf(keyAccountErasure, account))
+		if err == buntdb.ErrNotFound {
+			return errErasureNotScheduled
+		}
+		return err
+	})
+	if err == nil {
+		am.server.logger.Info("audit", "account erasure cancelled", account)
+		am.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf("Erasure of account %s was cancelled", account))
+	}
+	return
+}
+
+// eraseAccount unregisters the (casefolded) account, and deletes the messages
+// sent from it from the stored history.
+func (server *Server) eraseAccount(account string) error {
+	err := server.accounts.Unregister(account)
+	if err != nil {
+		return err
+	}
+	deleted := server.deleteHistory(authoredBy(account))
+	server.logger.Info("audit", "erased account", account, fmt.Sprintf("%d history items deleted", deleted))
+	server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf("Account %s was erased", account))
+	return nil
+}
+
+// runErasure periodically erases the accounts whose grace period has passed;
+// it runs for the lifetime of the server.
+func (am *AccountManager) runErasure() {
+	for {
+		time.Sleep(accountErasureCheckInterval)
+		am.eraseDueAccounts(time.Now())
+	}
+}
+
+func (am *AccountManager) eraseDueAccounts(now time.Time) {
+	prefix := fmt.Sprintf(keyAccountErasure, "")
+	var due []string
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			if erasureAt := parseAccountTime(value); !erasureAt.After(now) {
+				due = append(due, strings.TrimPrefix(key, prefix))
+			}
+			return true
+		})
+	})
+	for _, account := range due {
+		// if the account was unregistered in the meantime, this just cleans up
+		am.server.eraseAccount(account)
+	}
+}
+
+// marshalAccountDataExport renders an export as indented JSON, wrapped into
+// lines that are short enough to send over IRC.
+func marshalAccountDataExport(export AccountDataExport) (lines []string, err error) {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return
+	}
+	return utils.WordWrap(string(data), accountDataExportLineWidth), nil
+}
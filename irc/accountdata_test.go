@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+func TestAccountErasureConfig(t *testing.T) {
+	conf := AccountErasureConfig{Enabled: true, GracePeriodString: "7d"}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.gracePeriod != 7*24*time.Hour {
+		t.Errorf("incorrect grace period: %v", conf.gracePeriod)
+	}
+
+	conf = AccountErasureConfig{Enabled: true, GracePeriodString: "0"}
+	if err := conf.prepare(); err != nil || conf.gracePeriod != 0 {
+		t.Errorf("zero grace period should be accepted: %v %v", conf.gracePeriod, err)
+	}
+
+	conf = AccountErasureConfig{Enabled: true, GracePeriodString: "soon"}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("invalid grace period should be rejected")
+	}
+}
+
+func TestAuthoredBy(t *testing.T) {
+	buffer := history.NewHistoryBuffer(16)
+	for i, account := range []string{"Shivaram", "dan", "", "shivaram"} {
+		buffer.Add(history.Item{
+			Type:        history.Privmsg,
+			Nick:        "someone!user@host",
+			AccountName: account,
+			Message:     utils.MakeSplitMessage(strings.Repeat("a", i+1)),
+		})
+	}
+
+	predicate := authoredBy("shivaram")
+	if items := buffer.Match(predicate, true, 0); len(items) != 2 {
+		t.Fatalf("expected 2 authored items, got %d", len(items))
+	}
+	if count := buffer.Delete(predicate); count != 2 {
+		t.Errorf("expected to delete 2 items, deleted %d", count)
+	}
+	if items := buffer.Match(predicate, true, 0); len(items) != 0 {
+		t.Errorf("authored items were not deleted: %v", items)
+	}
+	if items := buffer.Match(authoredBy("dan"), true, 0); len(items) != 1 {
+		t.Errorf("other accounts' items should be untouched, got %d", len(items))
+	}
+}
+
+func TestMarshalAccountDataExport(t *testing.T) {
+	export := AccountDataExport{
+		Name: "shivaram",
+		History: []AccountDataHistoryItem{
+			{Target: "#chat", Command: "PRIVMSG", Nick: "shivaram", Message: strings.Repeat("hello ", 200)},
+		},
+	}
+	lines, err := marshalAccountDataExport(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if accountDataExportLineWidth < len(line) {
+			t.Errorf("line too long (%d bytes)", len(line))
+		}
+	}
+	if !strings.Contains(strings.Join(lines, ""), `"name": "shivaram"`) {
+		t.Errorf("export is missing the account name")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// how often to look for dormant accounts
+	accountExpiryCheckInterval = time.Hour
+
+	// when the datastore started recording logins and quits; accounts
+	// registered before then may have been in use without a record of it
+	keyAccountActivitySince = "accounts.activitysince"
+)
+
+// AccountExpirationConfig controls the unregistration of dormant accounts,
+// i.e., accounts that nobody has logged into for a long time.
+type AccountExpirationConfig struct {
+	Enabled        bool
+	DormancyString string `yaml:"dormancy"`
+	dormancy       time.Duration
+	// if set, accounts with an e-mail address are warned this long before they expire
+	WarningString string `yaml:"warning"`
+	warning       time.Duration
+}
+
+func (conf *AccountExpirationConfig) prepare() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	conf.dormancy, err = custime.ParseDuration(conf.DormancyString)
+	if err != nil || conf.dormancy <= 0 {
+		return fmt.Errorf("Could not parse account expiration dormancy: %s", conf.DormancyString)
+	}
+	if conf.WarningString != "" {
+		conf.warning, err = custime.ParseDuration(conf.WarningString)
+		if err != nil || conf.warning < 0 || conf.dormancy <= conf.warning {
+			return fmt.Errorf("Invalid account expiration warning: %s", conf.WarningString)
+		}
+	}
+	return nil
+}
+
+func parseAccountTime(timeStr string) (result time.Time) {
+	if timeInt, err := strconv.ParseInt(timeStr, 10, 64); err == nil && timeInt != 0 {
+		result = time.Unix(timeInt, 0)
+	}
+	return
+}
+
+func (am *AccountManager) recordAccountTime(keyFormat, account string) {
+	if account == "" {
+		return
+	}
+	key := fmt.Sprintf(keyFormat, account)
+	warningKey := fmt.Sprintf(keyAccountExpiryWarning, account)
+	timeStr := strconv.FormatInt(time.Now().Unix(), 10)
+	am.server.store.Update(func(tx *buntdb.Tx) error {
+		// don't resurrect an account that was unregistered in the meantime
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return nil
+		}
+		tx.Set(key, timeStr, nil)
+		// any use of the account resets the expiration clock
+		tx.Delete(warningKey)
+		return nil
+	})
+}
+
+// recordLogin records that someone just logged into the (casefolded) account.
+func (am *AccountManager) recordLogin(account string) {
+	am.recordAccountTime(keyAccountLastLogin, account)
+}
+
+// RecordQuit records that a client logged into the (casefolded) account just disconnected.
+func (am *AccountManager) RecordQuit(account string) {
+	am.recordAccountTime(keyAccountLastQuit, account)
+}
+
+// LastActive returns the last time the account was known to be in use. Logins
+// and quits have only been recorded since `activitySince`, so an account is
+// always considered to have been in use then.
+func (account *ClientAccount) LastActive(activitySince time.Time) (result time.Time) {
+	result = account.RegisteredAt
+	if result.Before(activitySince) {
+		result = activitySince
+	}
+	if result.Before(account.LastLogin) {
+		result = account.LastLogin
+	}
+	if result.Before(account.LastQuit) {
+		result = account.LastQuit
+	}
+	return
+}
+
+// expiryCandidate is a verified account that isn't currently in use,
+// along with the data needed to decide whether it has expired.
+type expiryCandidate struct {
+	account   ClientAccount
+	callback  string
+	warningAt time.Time
+}
+
+// runExpiration periodically checks for dormant accounts; it runs for the lifetime of the server.
+func (am *AccountManager) runExpiration() {
+	for {
+		time.Sleep(accountExpiryCheckInterval)
+		config := am.server.AccountConfig().Expiration
+		if config.Enabled {
+			am.expireDormantAccounts(config, time.Now())
+		}
+	}
+}
+
+// expireDormantAccounts unregisters accounts that have been dormant for longer than
+// the configured period, first sending a warning e-mail if possible.
+func (am *AccountManager) expireDormantAccounts(config AccountExpirationConfig, now time.Time) {
+	activitySince := am.activitySince(now)
+	for _, candidate := range am.loadExpiryCandidates() {
+		account := &candidate.account
+		cfaccount, err := CasefoldName(account.Name)
+		if err != nil || len(am.AccountToClients(cfaccount)) != 0 {
+			continue
+		}
+
+		lastActive := account.LastActive(activitySince)
+		expiresAt := lastActive.Add(config.dormancy)
+		email := strings.TrimPrefix(candidate.callback, "mailto:")
+		canWarn := config.warning != 0 && email != candidate.callback && email != ""
+		if canWarn {
+			if candidate.warningAt.IsZero() {
+				if expiresAt.Add(-config.warning).Before(now) {
+					am.sendExpiryWarning(cfaccount, account, email, lastActive, now.Add(config.warning), now)
+				}
+				continue
+			}
+			// always give the full warning period after the warning was sent
+			if warningExpiresAt := candidate.warningAt.Add(config.warning); expiresAt.Before(warningExpiresAt) {
+				expiresAt = warningExpiresAt
+			}
+		}
+
+		if expiresAt.Before(now) {
+			am.server.logger.Info("accounts", "expiring dormant account", account.Name)
+			if err := am.Unregister(cfaccount); err == nil {
+				am.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf("Dormant account %s has expired", account.Name))
+			}
+		}
+	}
+}
+
+// activitySince returns when the datastore started recording account activity.
+// Datastores are stamped when they're created or upgraded; if this one somehow
+// wasn't, tracking starts now.
+func (am *AccountManager) activitySince(now time.Time) (result time.Time) {
+	am.server.store.Update(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(keyAccountActivitySince)
+		result = parseAccountTime(value)
+		if err != nil || result.IsZero() {
+			result = now
+			tx.Set(keyAccountActivitySince, strconv.FormatInt(now.Unix(), 10), nil)
+		}
+		return nil
+	})
+	return
+}
+
+func (am *AccountManager) loadExpiryCandidates() (candidates []expiryCandidate) {
+	existsPrefix := fmt.Sprintf(keyAccountExists, "")
+	var raws []rawClientAccount
+	var warnings []string
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", existsPrefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, existsPrefix) {
+				return false
+			}
+			account := strings.TrimPrefix(key, existsPrefix)
+			raw, err := am.loadRawAccount(tx, account)
+			if err == nil && raw.Verified {
+				raws = append(raws, raw)
+				warning, _ := tx.Get(fmt.Sprintf(keyAccountExpiryWarning, account))
+				warnings = append(warnings, warning)
+			}
+			return true
+		})
+	})
+
+	for i, raw := range raws {
+		account, err := am.deserializeRawAccount(raw)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, expiryCandidate{
+			account:   account,
+			callback:  raw.Callback,
+			warningAt: parseAccountTime(warnings[i]),
+		})
+	}
+	return
+}
+
+func (am *AccountManager) sendExpiryWarning(cfaccount string, account *ClientAccount, email string, lastActive, expiresAt, now time.Time) {
+	config := am.server.AccountConfig().Registration.Callbacks.Mailto
+	messageStrings := []string{
+		fmt.Sprintf("From: %s\r\n", config.Sender),
+		fmt.Sprintf("To: %s\r\n", email),
+		fmt.Sprintf("Subject: Your account on %s will expire\r\n", am.server.name),
+		"\r\n",
+		fmt.Sprintf("Account: %s\r\n", account.Name),
+		fmt.Sprintf("This account has not been used since %s,\r\n", lastActive.UTC().Format(time.RFC1123)),
+		fmt.Sprintf("and will be unregistered after %s.\r\n", expiresAt.UTC().Format(time.RFC1123)),
+		"To keep the account, log into it before then.\r\n",
+	}
+	if err := am.sendMail(email, messageStrings); err != nil {
+		// try again at the next check
+		return
+	}
+
+	timeStr := strconv.FormatInt(now.Unix(), 10)
+	am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, cfaccount)); err == nil {
+			tx.Set(fmt.Sprintf(keyAccountExpiryWarning, cfaccount), timeStr, nil)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestAccountExpirationConfig(t *testing.T) {
+	conf := AccountExpirationConfig{Enabled: true, DormancyString: "30d", WarningString: "7d"}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.dormancy != 30*24*time.Hour || conf.warning != 7*24*time.Hour {
+		t.Errorf("incorrect durations: %v %v", conf.dormancy, conf.warning)
+	}
+
+	conf = AccountExpirationConfig{Enabled: true, DormancyString: "7d", WarningString: "30d"}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("warning period longer than dormancy should be rejected")
+	}
+
+	conf = AccountExpirationConfig{Enabled: true}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("missing dormancy should be rejected")
+	}
+
+	conf = AccountExpirationConfig{}
+	if err := conf.prepare(); err != nil {
+		t.Errorf("disabled config should not be validated: %v", err)
+	}
+}
+
+func TestAccountLastActive(t *testing.T) {
+	registered := time.Unix(1000, 0)
+	account := ClientAccount{RegisteredAt: registered}
+	if account.LastActive(time.Time{}) != registered {
+		t.Errorf("unused account should be active as of registration")
+	}
+	// activity from before it was tracked is unknown
+	if since := time.Unix(1500, 0); account.LastActive(since) != since {
+		t.Errorf("account should be active as of when activity was first tracked")
+	}
+
+	account.LastLogin = time.Unix(3000, 0)
+	account.LastQuit = time.Unix(2000, 0)
+	if account.LastActive(time.Unix(1500, 0)) != account.LastLogin {
+		t.Errorf("incorrect last active time: %v", account.LastActive(time.Time{}))
+	}
+
+	account.LastQuit = time.Unix(4000, 0)
+	if account.LastActive(time.Unix(1500, 0)) != account.LastQuit {
+		t.Errorf("incorrect last active time: %v", account.LastActive(time.Time{}))
+	}
+}
+
+func TestExpireDormantAccounts(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	am := h.server.accounts
+	if err := am.Register(nil, "alice", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.Verify(nil, "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	// an account registered long ago, before logins and quits were recorded
+	h.server.store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(fmt.Sprintf(keyAccountRegTime, "alice"), "1000", nil)
+		tx.Delete(fmt.Sprintf(keyAccountLastLogin, "alice"))
+		tx.Delete(fmt.Sprintf(keyAccountLastQuit, "alice"))
+		return nil
+	})
+
+	config := AccountExpirationConfig{Enabled: true, DormancyString: "30d"}
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	am.expireDormantAccounts(config, now)
+	if _, err := am.LoadAccount("alice"); err != nil {
+		t.Errorf("account without recorded activity should not expire: %v", err)
+	}
+
+	// once the dormancy period has passed since activity was first tracked, it does
+	am.expireDormantAccounts(config, now.Add(31*24*time.Hour))
+	if _, err := am.LoadAccount("alice"); err != errAccountDoesNotExist {
+		t.Errorf("dormant account should have expired: %v", err)
+	}
+}
@@ -10,12 +10,12 @@ import (
 	"net/smtp"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 	"unicode"
 
 	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/passwd"
 	"github.com/oragono/oragono/irc/utils"
 	"github.com/tidwall/buntdb"
@@ -34,6 +34,19 @@ const (
 	keyAccountVHost            = "account.vhost %s"
 	keyCertToAccount           = "account.creds.certfp %s"
 	keyAccountChannels         = "account.channels %s"
+	keyAccountMonitor          = "account.monitor %s"
+	keyAccountAccept           = "account.accept %s"
+	keyAccountSettings         = "account.settings %s"
+	keyAccountLastLogin        = "account.lastlogin %s"
+	keyAccountLastQuit         = "account.lastquit %s"
+	keyAccountExpiryWarning    = "account.expirywarning %s"
+	keyAccountSuspended        = "account.suspended %s"
+	keyAccountReadMarkers      = "account.readmarkers %s"
+	keyAccountErasure          = "account.erasure %s"
+	keyAccountPersonas         = "account.personas %s"
+	keyAccountMetadata         = "account.metadata %s"
+	keyAccountAPITokens        = "account.apitokens %s"
+	keyAccountAJoin            = "account.ajoin %s"
 
 	keyVHostQueueAcctToId = "vhostQueue %s"
 	vhostRequestIdx       = "vhostQueue"
@@ -47,9 +60,9 @@ type AccountManager struct {
 	vhostRequestID           uint64
 	vhostRequestPendingCount uint64
 
-	sync.RWMutex                      // tier 2
-	serialCacheUpdateMutex sync.Mutex // tier 3
-	vHostUpdateMutex       sync.Mutex // tier 3
+	lockorder.Tier2RWMutex
+	serialCacheUpdateMutex lockorder.Tier3Mutex
+	vHostUpdateMutex       lockorder.Tier3Mutex
 
 	server *Server
 	// track clients logged in to accounts
@@ -57,6 +70,8 @@ type AccountManager struct {
 	nickToAccount     map[string]string
 	skeletonToAccount map[string]string
 	accountToMethod   map[string]NickReservationMethod
+
+	externalAuthCache externalAuthCache
 }
 
 func NewAccountManager(server *Server) *AccountManager {
@@ -70,6 +85,8 @@ func NewAccountManager(server *Server) *AccountManager {
 
 	am.buildNickToAccountIndex()
 	am.initVHostRequestQueue()
+	go am.runExpiration()
+	go am.runErasure()
 	return &am
 }
 
@@ -314,6 +331,10 @@ func (am *AccountManager) Register(client *Client, account string, callbackNames
 		return errFeatureDisabled
 	}
 
+	if am.server.defcon.Level() <= DefconNoRegistrations && callbackNamespace != "admin" {
+		return errTemporarilyDisabled
+	}
+
 	// if nick reservation is enabled, you can only register your current nickname
 	// as an account; this prevents "land-grab" situations where someone else
 	// registers your nick out from under you and then NS GHOSTs you
@@ -498,8 +519,18 @@ func (am *AccountManager) dispatchMailtoCallback(client *Client, casefoldedAccou
 		"\r\n",
 		client.t("To verify your account, issue one of these commands:") + "\r\n",
 		fmt.Sprintf("/MSG NickServ VERIFY %s %s", casefoldedAccount, code) + "\r\n",
+		fmt.Sprintf("/VERIFY %s %s", casefoldedAccount, code) + "\r\n",
 	}
 
+	err = am.sendMail(callbackValue, messageStrings)
+	return
+}
+
+// sendMail sends an e-mail, whose headers and body are given as lines, via the
+// SMTP server configured for the mailto callback.
+func (am *AccountManager) sendMail(recipient string, messageStrings []string) (err error) {
+	config := am.server.AccountConfig().Registration.Callbacks.Mailto
+
 	var message []byte
 	for i := 0; i < len(messageStrings); i++ {
 		message = append(message, []byte(messageStrings[i])...)
@@ -513,7 +544,7 @@ func (am *AccountManager) dispatchMailtoCallback(client *Client, casefoldedAccou
 	// TODO: this will never send the password in plaintext over a nonlocal link,
 	// but it might send the email in plaintext, regardless of the value of
 	// config.TLS.InsecureSkipVerify
-	err = smtp.SendMail(addr, auth, config.Sender, []string{callbackValue}, message)
+	err = smtp.SendMail(addr, auth, config.Sender, []string{recipient}, message)
 	if err != nil {
 		am.server.logger.Error("internal", "Failed to dispatch e-mail", err.Error())
 	}
@@ -745,6 +776,11 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 		return
 	}
 
+	if account.Suspended {
+		err = errAccountSuspended
+		return
+	}
+
 	switch account.Credentials.Version {
 	case 0:
 		err = handleLegacyPasswordV0(am.server, accountName, account.Credentials, passphrase)
@@ -759,7 +795,22 @@ func (am *AccountManager) checkPassphrase(accountName, passphrase string) (accou
 }
 
 func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName string, passphrase string) error {
+	// if this isn't a valid API token, it might still be the password
+	if strings.HasPrefix(passphrase, apiTokenPrefix) && am.server.AccountConfig().APITokens.Enabled {
+		account, token, err := am.checkAPIToken(accountName, passphrase)
+		if err == nil {
+			am.Login(client, account)
+			client.setAPIToken(token)
+			return nil
+		} else if err != errAccountInvalidCredentials {
+			return err
+		}
+	}
+
 	account, err := am.checkPassphrase(accountName, passphrase)
+	if (err == errAccountDoesNotExist || err == errAccountInvalidCredentials) && am.server.AccountConfig().ExternalAuth.Enabled {
+		account, err = am.checkExternalAuth(client, accountName, passphrase)
+	}
 	if err != nil {
 		return err
 	}
@@ -792,6 +843,12 @@ func (am *AccountManager) deserializeRawAccount(raw rawClientAccount) (result Cl
 	result.Name = raw.Name
 	regTimeInt, _ := strconv.ParseInt(raw.RegisteredAt, 10, 64)
 	result.RegisteredAt = time.Unix(regTimeInt, 0)
+	result.LastLogin = parseAccountTime(raw.LastLogin)
+	result.LastQuit = parseAccountTime(raw.LastQuit)
+	if raw.Suspended != "" {
+		result.Suspended = true
+		result.SuspendReason = raw.Suspended
+	}
 	e := json.Unmarshal([]byte(raw.Credentials), &result.Credentials)
 	if e != nil {
 		am.server.logger.Error("internal", "could not unmarshal credentials", e.Error())
@@ -819,6 +876,9 @@ func (am *AccountManager) loadRawAccount(tx *buntdb.Tx, casefoldedAccount string
 	callbackKey := fmt.Sprintf(keyAccountCallback, casefoldedAccount)
 	nicksKey := fmt.Sprintf(keyAccountAdditionalNicks, casefoldedAccount)
 	vhostKey := fmt.Sprintf(keyAccountVHost, casefoldedAccount)
+	lastLoginKey := fmt.Sprintf(keyAccountLastLogin, casefoldedAccount)
+	lastQuitKey := fmt.Sprintf(keyAccountLastQuit, casefoldedAccount)
+	suspendedKey := fmt.Sprintf(keyAccountSuspended, casefoldedAccount)
 
 	_, e := tx.Get(accountKey)
 	if e == buntdb.ErrNotFound {
@@ -832,6 +892,9 @@ func (am *AccountManager) loadRawAccount(tx *buntdb.Tx, casefoldedAccount string
 	result.Callback, _ = tx.Get(callbackKey)
 	result.AdditionalNicks, _ = tx.Get(nicksKey)
 	result.VHost, _ = tx.Get(vhostKey)
+	result.LastLogin, _ = tx.Get(lastLoginKey)
+	result.LastQuit, _ = tx.Get(lastQuitKey)
+	result.Suspended, _ = tx.Get(suspendedKey)
 
 	if _, e = tx.Get(verifiedKey); e == nil {
 		result.Verified = true
@@ -858,13 +921,31 @@ func (am *AccountManager) Unregister(account string) error {
 	vhostKey := fmt.Sprintf(keyAccountVHost, casefoldedAccount)
 	vhostQueueKey := fmt.Sprintf(keyVHostQueueAcctToId, casefoldedAccount)
 	channelsKey := fmt.Sprintf(keyAccountChannels, casefoldedAccount)
+	monitorKey := fmt.Sprintf(keyAccountMonitor, casefoldedAccount)
+	acceptKey := fmt.Sprintf(keyAccountAccept, casefoldedAccount)
+	settingsKey := fmt.Sprintf(keyAccountSettings, casefoldedAccount)
+	lastLoginKey := fmt.Sprintf(keyAccountLastLogin, casefoldedAccount)
+	lastQuitKey := fmt.Sprintf(keyAccountLastQuit, casefoldedAccount)
+	expiryWarningKey := fmt.Sprintf(keyAccountExpiryWarning, casefoldedAccount)
+	suspendedKey := fmt.Sprintf(keyAccountSuspended, casefoldedAccount)
+	readMarkersKey := fmt.Sprintf(keyAccountReadMarkers, casefoldedAccount)
+	erasureKey := fmt.Sprintf(keyAccountErasure, casefoldedAccount)
+	personasKey := fmt.Sprintf(keyAccountPersonas, casefoldedAccount)
+	metadataKey := fmt.Sprintf(keyAccountMetadata, casefoldedAccount)
+	apiTokensKey := fmt.Sprintf(keyAccountAPITokens, casefoldedAccount)
+	aJoinKey := fmt.Sprintf(keyAccountAJoin, casefoldedAccount)
 
 	var clients []*Client
 
 	var registeredChannels []string
-	// on our way out, unregister all the account's channels and delete them from the db
+	// on our way out, unregister all the account's channels (unless they have a
+	// successor) and delete them from the db
 	defer func() {
 		for _, channelName := range registeredChannels {
+			// if the channel has a successor, they become the new founder
+			if am.server.channelRegistry.Succeed(channelName, casefoldedAccount) {
+				continue
+			}
 			info := am.server.channelRegistry.LoadChannel(channelName)
 			if info != nil && info.Founder == casefoldedAccount {
 				am.server.channelRegistry.Delete(channelName, *info)
@@ -899,6 +980,19 @@ func (am *AccountManager) Unregister(account string) error {
 		tx.Delete(vhostKey)
 		channelsStr, _ = tx.Get(channelsKey)
 		tx.Delete(channelsKey)
+		tx.Delete(monitorKey)
+		tx.Delete(acceptKey)
+		tx.Delete(settingsKey)
+		tx.Delete(lastLoginKey)
+		tx.Delete(lastQuitKey)
+		tx.Delete(expiryWarningKey)
+		tx.Delete(suspendedKey)
+		tx.Delete(readMarkersKey)
+		tx.Delete(erasureKey)
+		tx.Delete(personasKey)
+		tx.Delete(metadataKey)
+		tx.Delete(apiTokensKey)
+		tx.Delete(aJoinKey)
 
 		_, err := tx.Delete(vhostQueueKey)
 		am.decrementVHostQueueCount(casefoldedAccount, err)
@@ -951,6 +1045,51 @@ func (am *AccountManager) Unregister(account string) error {
 	return nil
 }
 
+// Suspend prevents anyone from logging into an account, and disconnects
+// any clients that are currently logged into it.
+func (am *AccountManager) Suspend(account string, reason string) error {
+	casefoldedAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	if reason == "" {
+		reason = "No reason given"
+	}
+
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountVerified, casefoldedAccount)); err != nil {
+			return errAccountDoesNotExist
+		}
+		_, _, err := tx.Set(fmt.Sprintf(keyAccountSuspended, casefoldedAccount), reason, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, client := range am.AccountToClients(casefoldedAccount) {
+		client.Quit(client.t("Your account has been suspended"))
+		client.destroy(false)
+	}
+	return nil
+}
+
+// Unsuspend allows an account to be logged into again.
+func (am *AccountManager) Unsuspend(account string) error {
+	casefoldedAccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(fmt.Sprintf(keyAccountSuspended, casefoldedAccount))
+		if err == buntdb.ErrNotFound {
+			return errAccountNotSuspended
+		}
+		return err
+	})
+}
+
 func unmarshalRegisteredChannels(channelsStr string) (result []string) {
 	if channelsStr != "" {
 		result = strings.Split(channelsStr, ",")
@@ -973,6 +1112,56 @@ func (am *AccountManager) ChannelsForAccount(account string) (channels []string)
 	return unmarshalRegisteredChannels(channelStr)
 }
 
+// loadAccountList loads a comma-separated list stored under the given key format.
+func (am *AccountManager) loadAccountList(keyFormat, account string) (result []string) {
+	var listStr string
+	key := fmt.Sprintf(keyFormat, account)
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		listStr, _ = tx.Get(key)
+		return nil
+	})
+	if listStr != "" {
+		result = strings.Split(listStr, ",")
+	}
+	return
+}
+
+// storeAccountList stores a list under the given key format, deleting the key if it's empty.
+func (am *AccountManager) storeAccountList(keyFormat, account string, list []string) error {
+	key := fmt.Sprintf(keyFormat, account)
+	return am.server.store.Update(func(tx *buntdb.Tx) (err error) {
+		if len(list) == 0 {
+			_, err = tx.Delete(key)
+			if err == buntdb.ErrNotFound {
+				err = nil
+			}
+		} else {
+			_, _, err = tx.Set(key, strings.Join(list, ","), nil)
+		}
+		return
+	})
+}
+
+// LoadMonitorList returns the monitor list stored with an account.
+func (am *AccountManager) LoadMonitorList(account string) (nicks []string) {
+	return am.loadAccountList(keyAccountMonitor, account)
+}
+
+// StoreMonitorList stores a monitor list (of casefolded nicks) with an account.
+func (am *AccountManager) StoreMonitorList(account string, nicks []string) error {
+	return am.storeAccountList(keyAccountMonitor, account, nicks)
+}
+
+// LoadAcceptList returns the caller-ID accept list stored with an account.
+func (am *AccountManager) LoadAcceptList(account string) (nicks []string) {
+	return am.loadAccountList(keyAccountAccept, account)
+}
+
+// StoreAcceptList stores a caller-ID accept list (of casefolded nicks) with an account.
+func (am *AccountManager) StoreAcceptList(account string, nicks []string) error {
+	return am.storeAccountList(keyAccountAccept, account, nicks)
+}
+
 func (am *AccountManager) AuthenticateByCertFP(client *Client) error {
 	if client.certfp == "" {
 		return errAccountInvalidCredentials
@@ -1004,6 +1193,9 @@ func (am *AccountManager) AuthenticateByCertFP(client *Client) error {
 	if err != nil {
 		return err
 	}
+	if clientAccount.Suspended {
+		return errAccountSuspended
+	}
 	am.Login(client, clientAccount)
 	return nil
 }
@@ -1222,7 +1414,54 @@ func (am *AccountManager) applyVhostToClients(account string, result VHostInfo)
 	}
 }
 
+// LoadSettings loads the settings (modified with NickServ SET) stored with an account.
+func (am *AccountManager) LoadSettings(account string) (settings AccountSettings) {
+	var settingsStr string
+	key := fmt.Sprintf(keyAccountSettings, account)
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		settingsStr, _ = tx.Get(key)
+		return nil
+	})
+	if settingsStr != "" {
+		json.Unmarshal([]byte(settingsStr), &settings)
+	}
+	return
+}
+
+// ModifySettings atomically modifies the settings stored with an account,
+// then applies the result to all the clients logged into the account.
+func (am *AccountManager) ModifySettings(account string, munger func(settings *AccountSettings) error) (result AccountSettings, err error) {
+	key := fmt.Sprintf(keyAccountSettings, account)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		settingsStr, err := tx.Get(key)
+		if err == nil {
+			json.Unmarshal([]byte(settingsStr), &result)
+		} else if err != buntdb.ErrNotFound {
+			return err
+		}
+		err = munger(&result)
+		if err != nil {
+			return err
+		}
+		settingsBytes, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(settingsBytes), nil)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	for _, client := range am.AccountToClients(account) {
+		client.applyAccountSettings(result)
+	}
+	return
+}
+
 func (am *AccountManager) Login(client *Client, account ClientAccount) {
+	client.setAPIToken(nil)
 	changed := client.SetAccountName(account.Name)
 	if !changed {
 		return
@@ -1230,12 +1469,19 @@ func (am *AccountManager) Login(client *Client, account ClientAccount) {
 
 	client.nickTimer.Touch()
 
+	client.setAccountRegisteredAt(account.RegisteredAt)
 	am.applyVHostInfo(client, account.VHost)
+	restoreMonitorList(am.server, client)
+	restoreAcceptList(am.server, client)
+	client.applyAccountSettings(am.LoadSettings(client.Account()))
 
 	casefoldedAccount := client.Account()
+	am.recordLogin(casefoldedAccount)
 	am.Lock()
-	defer am.Unlock()
 	am.accountToClients[casefoldedAccount] = append(am.accountToClients[casefoldedAccount], client)
+	am.Unlock()
+
+	am.server.autoJoinOnLogin(client)
 }
 
 func (am *AccountManager) Logout(client *Client) {
@@ -1248,6 +1494,8 @@ func (am *AccountManager) Logout(client *Client) {
 	}
 
 	am.logoutOfAccount(client)
+	client.SetDeviceID("")
+	client.SetPersona("")
 
 	clients := am.accountToClients[casefoldedAccount]
 	if len(clients) <= 1 {
@@ -1272,6 +1520,7 @@ var (
 	EnabledSaslMechanisms = map[string]func(*Server, *Client, string, []byte, *ResponseBuffer) bool{
 		"PLAIN":    authPlainHandler,
 		"EXTERNAL": authExternalHandler,
+		"JWT":      authJWTHandler,
 	}
 )
 
@@ -1293,6 +1542,12 @@ type ClientAccount struct {
 	Verified        bool
 	AdditionalNicks []string
 	VHost           VHostInfo
+	// LastLogin and LastQuit are zero if the account was never used.
+	LastLogin time.Time
+	LastQuit  time.Time
+	// suspended accounts can't be logged into
+	Suspended     bool
+	SuspendReason string
 }
 
 // convenience for passing around raw serialized account data
@@ -1304,6 +1559,9 @@ type rawClientAccount struct {
 	Verified        bool
 	AdditionalNicks string
 	VHost           string
+	LastLogin       string
+	LastQuit        string
+	Suspended       string
 }
 
 // logoutOfAccount logs the client out of their current account.
@@ -1314,7 +1572,10 @@ func (am *AccountManager) logoutOfAccount(client *Client) {
 	}
 
 	client.SetAccountName("")
+	client.setAccountRegisteredAt(time.Time{})
+	client.setAPIToken(nil)
 	go client.nickTimer.Touch()
+	client.applyAccountSettings(AccountSettings{})
 
 	// dispatch account-notify
 	// TODO: doing the I/O here is kind of a kludge, let's move this somewhere else
@@ -0,0 +1,243 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+// DirectMessagePolicy controls who can send direct messages to a user.
+type DirectMessagePolicy string
+
+const (
+	// DMPolicyAll accepts direct messages from everyone
+	DMPolicyAll DirectMessagePolicy = ""
+	// DMPolicyRegistered only accepts direct messages from users logged into accounts
+	DMPolicyRegistered DirectMessagePolicy = "registered"
+	// DMPolicyRequests holds direct messages from users who aren't on the accept
+	// list until they're accepted (see dmrequests.go)
+	DMPolicyRequests DirectMessagePolicy = "requests"
+)
+
+// AccountSettings are the per-account preferences that users can modify with
+// NickServ SET. They're stored (as JSON) with the account, and applied to every
+// client that logs into it. The zero value is the default for every setting.
+type AccountSettings struct {
+	Languages            []string      `json:",omitempty"`
+	AutoAway             time.Duration `json:",omitempty"`
+	DisableHistoryReplay bool          `json:",omitempty"`
+	// if set, the account's messages aren't stored in history
+	DisableHistoryStorage bool                `json:",omitempty"`
+	DirectMessages        DirectMessagePolicy `json:",omitempty"`
+	DisableAutoJoin       bool                `json:",omitempty"`
+}
+
+// accountSetting describes one setting that can be modified with NickServ SET.
+type accountSetting struct {
+	// if non-nil, whether the setting is available under the current config
+	enabled func(config *Config) bool
+	// get returns the current value of the setting, for display
+	get func(server *Server, account string, settings *AccountSettings) string
+	// set validates `params` and modifies the setting in `settings`
+	set func(server *Server, settings *AccountSettings, params []string) error
+	// for settings stored outside the AccountSettings record: validates `params`
+	// and stores the setting directly (`set` is ignored)
+	setExternal func(server *Server, account string, params []string) error
+}
+
+func (setting *accountSetting) isEnabled(config *Config) bool {
+	return setting.enabled == nil || setting.enabled(config)
+}
+
+var (
+	accountSettings = map[string]*accountSetting{
+		"language": {
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				if len(settings.Languages) == 0 {
+					return "default"
+				}
+				return strings.Join(server.Languages().Codes(settings.Languages), " ")
+			},
+			set: func(server *Server, settings *AccountSettings, params []string) error {
+				if len(params) == 1 && strings.ToLower(params[0]) == "default" {
+					settings.Languages = nil
+					return nil
+				}
+				languages, unknown := server.Languages().Normalize(params)
+				if unknown != "" {
+					return errUnsupportedLanguage
+				} else if len(languages) == 0 {
+					return errInvalidParams
+				}
+				settings.Languages = languages
+				return nil
+			},
+		},
+		"enforce": {
+			enabled: nsEnforceEnabled,
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				return server.accounts.getStoredEnforcementStatus(account)
+			},
+			setExternal: func(server *Server, account string, params []string) error {
+				method, err := nickReservationFromString(params[0])
+				if err != nil {
+					return errInvalidParams
+				}
+				return server.accounts.SetEnforcementStatus(account, method)
+			},
+		},
+		"autoaway": {
+			enabled: func(config *Config) bool {
+				return config.Accounts.AutoAway.Enabled
+			},
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				if settings.AutoAway == 0 {
+					return "off"
+				}
+				return settings.AutoAway.String()
+			},
+			set: func(server *Server, settings *AccountSettings, params []string) error {
+				if strings.ToLower(params[0]) == "off" {
+					settings.AutoAway = 0
+					return nil
+				}
+				duration, err := time.ParseDuration(params[0])
+				if err != nil || duration < minAutoAwayDuration {
+					return errInvalidAutoAway
+				}
+				settings.AutoAway = duration
+				return nil
+			},
+		},
+		"replay": {
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				return boolToOnOff(!settings.DisableHistoryReplay)
+			},
+			set: func(server *Server, settings *AccountSettings, params []string) error {
+				replay, err := onOffToBool(params[0])
+				if err != nil {
+					return err
+				}
+				settings.DisableHistoryReplay = !replay
+				return nil
+			},
+		},
+		"store-history": {
+			enabled: func(config *Config) bool {
+				return config.History.Enabled
+			},
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				return boolToOnOff(!settings.DisableHistoryStorage)
+			},
+			setExternal: func(server *Server, account string, params []string) error {
+				store, err := onOffToBool(params[0])
+				if err != nil {
+					return err
+				}
+				return server.setHistoryStorage(account, store)
+			},
+		},
+		"allow-dms": {
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				if settings.DirectMessages == DMPolicyAll {
+					return "all"
+				}
+				return string(settings.DirectMessages)
+			},
+			set: func(server *Server, settings *AccountSettings, params []string) error {
+				switch strings.ToLower(params[0]) {
+				case "all":
+					settings.DirectMessages = DMPolicyAll
+				case "registered":
+					settings.DirectMessages = DMPolicyRegistered
+				case "requests":
+					settings.DirectMessages = DMPolicyRequests
+				default:
+					return errInvalidParams
+				}
+				return nil
+			},
+		},
+		"autojoin": {
+			get: func(server *Server, account string, settings *AccountSettings) string {
+				return boolToOnOff(!settings.DisableAutoJoin)
+			},
+			set: func(server *Server, settings *AccountSettings, params []string) error {
+				autoJoin, err := onOffToBool(params[0])
+				if err != nil {
+					return err
+				}
+				settings.DisableAutoJoin = !autoJoin
+				return nil
+			},
+		},
+	}
+
+	// the order in which NickServ GET displays the settings
+	accountSettingNames = []string{"language", "enforce", "autoaway", "replay", "store-history", "allow-dms", "autojoin"}
+)
+
+func boolToOnOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+func onOffToBool(value string) (result bool, err error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes":
+		return true, nil
+	case "off", "false", "no":
+		return false, nil
+	default:
+		return false, errInvalidParams
+	}
+}
+
+// applyAccountSettings applies an account's settings to a client logged into it
+// (or the default settings, to a client that logged out).
+func (client *Client) applyAccountSettings(settings AccountSettings) {
+	client.stateMutex.Lock()
+	client.accountSettings = settings
+	client.stateMutex.Unlock()
+
+	server := client.server
+	lm := server.Languages()
+	var languages []string
+	// skip languages that are no longer supported
+	for _, code := range settings.Languages {
+		if _, exists := lm.Languages[code]; exists {
+			languages = append(languages, code)
+		}
+	}
+	// without a stored preference, keep whatever the client chose with LANGUAGE
+	if len(languages) != 0 {
+		client.SetLanguages(languages)
+	}
+
+	if server.AccountConfig().AutoAway.Enabled {
+		client.SetAutoAway(settings.AutoAway)
+	} else {
+		client.SetAutoAway(0)
+	}
+}
+
+// AccountSettings returns the settings of the account the client is logged into.
+func (client *Client) AccountSettings() (result AccountSettings) {
+	client.stateMutex.RLock()
+	result = client.accountSettings
+	client.stateMutex.RUnlock()
+	return
+}
+
+// requiresRegisteredSender returns whether the client only accepts direct messages
+// from users who are logged into accounts, either because of user mode +R or
+// because of its account settings.
+func (client *Client) requiresRegisteredSender() bool {
+	return client.HasMode(modes.RegisteredOnly) || client.AccountSettings().DirectMessages == DMPolicyRegistered
+}
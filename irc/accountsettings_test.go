@@ -0,0 +1,85 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAccountSettingsValidation(t *testing.T) {
+	var settings AccountSettings
+
+	autoAway := accountSettings["autoaway"]
+	if err := autoAway.set(nil, &settings, []string{"30m"}); err != nil || settings.AutoAway != 30*time.Minute {
+		t.Errorf("valid auto-away should be accepted, got %v %v", err, settings.AutoAway)
+	}
+	if autoAway.set(nil, &settings, []string{"5s"}) != errInvalidAutoAway {
+		t.Error("auto-away below the minimum should be rejected")
+	}
+	if err := autoAway.set(nil, &settings, []string{"OFF"}); err != nil || settings.AutoAway != 0 {
+		t.Error("auto-away should be disabled")
+	}
+
+	replay := accountSettings["replay"]
+	if err := replay.set(nil, &settings, []string{"off"}); err != nil || !settings.DisableHistoryReplay {
+		t.Error("replay should be disabled")
+	}
+	if replay.get(nil, "", &settings) != "off" {
+		t.Error("replay should display as off")
+	}
+	if replay.set(nil, &settings, []string{"maybe"}) != errInvalidParams {
+		t.Error("invalid boolean should be rejected")
+	}
+
+	dms := accountSettings["allow-dms"]
+	if err := dms.set(nil, &settings, []string{"registered"}); err != nil || settings.DirectMessages != DMPolicyRegistered {
+		t.Error("allow-dms should be set to registered")
+	}
+	if dms.set(nil, &settings, []string{"nobody"}) != errInvalidParams {
+		t.Error("invalid allow-dms should be rejected")
+	}
+
+	autoJoin := accountSettings["autojoin"]
+	if autoJoin.get(nil, "", &settings) != "on" {
+		t.Error("auto-join should be enabled by default")
+	}
+	if err := autoJoin.set(nil, &settings, []string{"off"}); err != nil || !settings.DisableAutoJoin {
+		t.Error("auto-join should be disabled")
+	}
+}
+
+func TestAccountSettingsSerialization(t *testing.T) {
+	if data, _ := json.Marshal(AccountSettings{}); string(data) != "{}" {
+		t.Errorf("default settings should serialize to an empty object, got %s", data)
+	}
+
+	settings := AccountSettings{
+		Languages:      []string{"de", "en"},
+		AutoAway:       time.Hour,
+		DirectMessages: DMPolicyRegistered,
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result AccountSettings
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(settings, result) {
+		t.Errorf("settings didn't round-trip: %v != %v", settings, result)
+	}
+
+	for _, name := range accountSettingNames {
+		if accountSettings[name] == nil {
+			t.Errorf("missing definition for setting %s", name)
+		}
+	}
+	if len(accountSettingNames) != len(accountSettings) {
+		t.Errorf("NickServ GET doesn't list every setting: %v", accountSettingNames)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Every account has an auto-join list (NickServ AJOIN): channels, optionally
+// with keys, that its clients join whenever they log in. Unlike the server's
+// auto-join channels (see autojoin.go), the list is the user's own, so it's
+// not affected by NickServ SET AUTOJOIN. AJOIN SAVE replaces the list with
+// the channels the client is currently in.
+
+const (
+	defaultMaxAJoinChannels = 25
+)
+
+var (
+	errAJoinExists   = errors.New("That channel is already on your auto-join list")
+	errNoSuchAJoin   = errors.New("That channel isn't on your auto-join list")
+	errTooManyAJoins = errors.New("Your auto-join list is full")
+)
+
+// AJoinConfig controls NickServ AJOIN.
+type AJoinConfig struct {
+	Enabled     bool
+	MaxChannels int `yaml:"max-channels"`
+}
+
+func (conf *AJoinConfig) prepare() error {
+	if conf.MaxChannels == 0 {
+		conf.MaxChannels = defaultMaxAJoinChannels
+	}
+	return nil
+}
+
+// AJoinEntry is a channel on an account's auto-join list.
+type AJoinEntry struct {
+	Channel string
+	Key     string `json:",omitempty"`
+}
+
+// findAJoin returns the index of the (casefolded) channel in the list, or -1.
+func findAJoin(entries []AJoinEntry, cfchannel string) int {
+	for i, entry := range entries {
+		if cfname, err := CasefoldChannel(entry.Channel); err == nil && cfname == cfchannel {
+			return i
+		}
+	}
+	return -1
+}
+
+func (am *AccountManager) loadAJoinList(tx *buntdb.Tx, account string) (entries []AJoinEntry) {
+	if rawEntries, err := tx.Get(fmt.Sprintf(keyAccountAJoin, account)); err == nil {
+		json.Unmarshal([]byte(rawEntries), &entries)
+	}
+	return
+}
+
+// AJoinList returns the (casefolded) account's auto-join list, in the order
+// the channels are joined.
+func (am *AccountManager) AJoinList(account string) (entries []AJoinEntry) {
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		entries = am.loadAJoinList(tx, account)
+		return nil
+	})
+	return
+}
+
+// modifyAJoinList runs `update` on the (casefolded) account's auto-join list,
+// then stores the result if it succeeds.
+func (am *AccountManager) modifyAJoinList(account string, update func(entries []AJoinEntry) ([]AJoinEntry, error)) error {
+	maxChannels := am.server.AccountConfig().AJoin.MaxChannels
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return errAccountDoesNotExist
+		}
+		entries, err := update(am.loadAJoinList(tx, account))
+		if err != nil {
+			return err
+		} else if maxChannels < len(entries) {
+			return errTooManyAJoins
+		}
+		key := fmt.Sprintf(keyAccountAJoin, account)
+		if len(entries) == 0 {
+			tx.Delete(key)
+			return nil
+		}
+		rawEntries, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(key, string(rawEntries), nil)
+		return err
+	})
+}
+
+// AddAJoin adds a channel (with an optional key) to the end of the
+// (casefolded) account's auto-join list.
+func (am *AccountManager) AddAJoin(account, channel, key string) error {
+	cfchannel, err := CasefoldChannel(channel)
+	if err != nil {
+		return errInvalidChannelName
+	}
+	return am.modifyAJoinList(account, func(entries []AJoinEntry) ([]AJoinEntry, error) {
+		if findAJoin(entries, cfchannel) != -1 {
+			return nil, errAJoinExists
+		}
+		return append(entries, AJoinEntry{Channel: channel, Key: key}), nil
+	})
+}
+
+// DeleteAJoin removes a channel from the (casefolded) account's auto-join list.
+func (am *AccountManager) DeleteAJoin(account, channel string) error {
+	cfchannel, err := CasefoldChannel(channel)
+	if err != nil {
+		return errNoSuchAJoin
+	}
+	return am.modifyAJoinList(account, func(entries []AJoinEntry) ([]AJoinEntry, error) {
+		i := findAJoin(entries, cfchannel)
+		if i == -1 {
+			return nil, errNoSuchAJoin
+		}
+		return append(entries[:i], entries[i+1:]...), nil
+	})
+}
+
+// SaveAJoinChannels replaces the (casefolded) account's auto-join list with
+// the given channels, keeping the keys of the ones that were already on it.
+func (am *AccountManager) SaveAJoinChannels(account string, channels []*Channel) error {
+	return am.modifyAJoinList(account, func(entries []AJoinEntry) ([]AJoinEntry, error) {
+		result := make([]AJoinEntry, len(channels))
+		for i, channel := range channels {
+			result[i].Channel = channel.Name()
+			if j := findAJoin(entries, channel.NameCasefolded()); j != -1 {
+				result[i].Key = entries[j].Key
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Channel < result[j].Channel })
+		return result, nil
+	})
+}
+
+// aJoin joins a client that's logged into an account to the channels on the
+// account's auto-join list.
+func (server *Server) aJoin(client *Client, rb *ResponseBuffer) {
+	account := client.Account()
+	if account == "" || !server.AccountConfig().AJoin.Enabled {
+		return
+	}
+	for _, entry := range server.accounts.AJoinList(account) {
+		server.channels.Join(client, entry.Channel, entry.Key, false, rb)
+	}
+}
+
+func nsAJoinHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	subcommand := "list"
+	if len(params) != 0 {
+		subcommand = strings.ToLower(params[0])
+	}
+
+	var err error
+	switch subcommand {
+	case "list":
+		entries := server.accounts.AJoinList(account)
+		if len(entries) == 0 {
+			nsNotice(rb, client.t("Your auto-join list is empty"))
+			return
+		}
+		nsNotice(rb, client.t("Your auto-join list:"))
+		for _, entry := range entries {
+			if entry.Key != "" {
+				nsNotice(rb, fmt.Sprintf(client.t("%[1]s (key: %[2]s)"), entry.Channel, entry.Key))
+			} else {
+				nsNotice(rb, entry.Channel)
+			}
+		}
+		return
+	case "add", "del":
+		if len(params) < 2 {
+			nsNotice(rb, client.t("Invalid parameters. For usage, do /msg NickServ HELP AJOIN"))
+			return
+		}
+		channel := params[1]
+		if subcommand == "add" {
+			var key string
+			if 2 < len(params) {
+				key = params[2]
+			}
+			err = server.accounts.AddAJoin(account, channel, key)
+			if err == nil {
+				nsNotice(rb, fmt.Sprintf(client.t("Added %s to your auto-join list"), channel))
+			}
+		} else {
+			err = server.accounts.DeleteAJoin(account, channel)
+			if err == nil {
+				nsNotice(rb, fmt.Sprintf(client.t("Removed %s from your auto-join list"), channel))
+			}
+		}
+	case "save":
+		channels := client.Channels()
+		err = server.accounts.SaveAJoinChannels(account, channels)
+		if err == nil {
+			nsNotice(rb, fmt.Sprintf(client.t("Saved %d channels to your auto-join list"), len(channels)))
+		}
+	default:
+		nsNotice(rb, client.t("Invalid parameters. For usage, do /msg NickServ HELP AJOIN"))
+		return
+	}
+
+	switch err {
+	case nil:
+	case errAJoinExists, errNoSuchAJoin, errTooManyAJoins, errInvalidChannelName:
+		nsNotice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "couldn't modify auto-join list", err.Error())
+		nsNotice(rb, client.t("An error occurred"))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAJoin(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Accounts.AJoin.Enabled = true
+		config.Accounts.AJoin.MaxChannels = 2
+	})
+	defer h.Close()
+
+	am := h.server.accounts
+	if err := am.Register(nil, "dan", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.Verify(nil, "dan", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.AddAJoin("dan", "#b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.AddAJoin("dan", "#a", "sesame"); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.AddAJoin("dan", "#A", ""); err != errAJoinExists {
+		t.Errorf("channels should only be added once, got %v", err)
+	}
+	if err := am.AddAJoin("dan", "#c", ""); err != errTooManyAJoins {
+		t.Errorf("the list should be limited, got %v", err)
+	}
+	expected := []AJoinEntry{{Channel: "#b"}, {Channel: "#a", Key: "sesame"}}
+	if entries := am.AJoinList("dan"); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected auto-join list: %v", entries)
+	}
+
+	op := h.Register("op")
+	op.Send("JOIN", "#a")
+	op.Expect(RPL_ENDOFNAMES)
+	op.Send("MODE", "#a", "+k", "sesame")
+	op.Expect("MODE")
+
+	dan := h.Register("dan")
+	dan.Send("NS", "IDENTIFY", "dan", "hunter2")
+	for _, channel := range []string{"#b", "#a"} {
+		if msg := dan.Expect("JOIN"); msg.Params[0] != channel {
+			t.Errorf("expected to join %s, got %v", channel, msg)
+		}
+	}
+
+	dan.Send("PART", "#b")
+	dan.Expect("PART")
+	dan.Send("NS", "AJOIN", "SAVE")
+	dan.Expect("NOTICE")
+	expected = []AJoinEntry{{Channel: "#a", Key: "sesame"}}
+	if entries := am.AJoinList("dan"); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected auto-join list after saving: %v", entries)
+	}
+
+	if err := am.DeleteAJoin("dan", "#A"); err != nil {
+		t.Error(err)
+	}
+	if err := am.DeleteAJoin("dan", "#a"); err != errNoSuchAJoin {
+		t.Errorf("expected %v, got %v", errNoSuchAJoin, err)
+	}
+	if entries := am.AJoinList("dan"); len(entries) != 0 {
+		t.Errorf("unexpected auto-join list after deleting: %v", entries)
+	}
+}
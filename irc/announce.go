@@ -0,0 +1,145 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// ANNOUNCE sends a notice to every user on the network, or to the members of
+// some channels. Since a mistake can't be taken back, the oper first gets a
+// summary of the announcement and who it will reach, and has to confirm it
+// with a code. The notice is then delivered in the background, a batch of
+// clients at a time, so a large network doesn't get a burst of output (and
+// the server doesn't stall) all at once.
+
+const (
+	// announcements are wrapped to lines of this many characters
+	announceLineWidth = 400
+	// delivery pacing
+	announceBatchSize  = 100
+	announceBatchDelay = 250 * time.Millisecond
+	// unconfirmed announcements are discarded after this long
+	announceConfirmTimeout = 5 * time.Minute
+)
+
+// pendingAnnouncement is an announcement waiting for confirmation.
+type pendingAnnouncement struct {
+	target  string // "*" or a comma-separated list of channels
+	message string
+	code    string
+	created time.Time
+}
+
+// announcementRecipients returns the clients an announcement to `target`
+// would reach.
+func (server *Server) announcementRecipients(target string) (recipients []*Client, err error) {
+	if target == "*" {
+		return server.clients.AllClients(), nil
+	}
+	recipientSet := make(ClientSet)
+	for _, name := range strings.Split(target, ",") {
+		channel := server.channels.Get(name)
+		if channel == nil {
+			return nil, errNoSuchChannel
+		}
+		for _, member := range channel.Members() {
+			recipientSet.Add(member)
+		}
+	}
+	for recipient := range recipientSet {
+		recipients = append(recipients, recipient)
+	}
+	return
+}
+
+// announcementLines formats an announcement as a series of notices.
+func announcementLines(message string) []string {
+	lines := utils.WordWrap(message, announceLineWidth)
+	lines[0] = ircfmt.Unescape("$b[Announcement]$b ") + lines[0]
+	return lines
+}
+
+// deliverAnnouncement sends the announcement to the recipients, pacing the
+// output, then tells the oper who sent it.
+func (server *Server) deliverAnnouncement(oper *Client, recipients []*Client, lines []string) {
+	defer atomic.StoreUint32(&server.announcing, 0)
+
+	// every recipient gets the same msgid for each line
+	messages := make([]utils.SplitMessage, len(lines))
+	for i, line := range lines {
+		messages[i] = utils.MakeSplitMessage(line)
+	}
+	for i, recipient := range recipients {
+		if i != 0 && i%announceBatchSize == 0 {
+			time.Sleep(announceBatchDelay)
+		}
+		nick := recipient.Nick()
+		for _, message := range messages {
+			recipient.SendSplitMsgFromServer(server.name, "NOTICE", nick, message)
+		}
+	}
+	oper.Notice(fmt.Sprintf(oper.t("Your announcement was delivered to %d users"), len(recipients)))
+}
+
+// ANNOUNCE <* | #channel{,#channel}> <message>
+// ANNOUNCE CONFIRM <code>
+func announceHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	if strings.ToUpper(msg.Params[0]) == "CONFIRM" {
+		confirmAnnouncement(server, client, msg.Params[1], rb)
+		return false
+	}
+
+	target, message := msg.Params[0], msg.Params[1]
+	recipients, err := server.announcementRecipients(target)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "ANNOUNCE", "INVALID_TARGET", target, client.t("No such channel"))
+		return false
+	}
+	code := utils.GenerateSecretToken()[:8]
+	client.setPendingAnnouncement(&pendingAnnouncement{
+		target:  target,
+		message: message,
+		code:    code,
+		created: time.Now(),
+	})
+
+	for _, line := range announcementLines(message) {
+		rb.Notice(line)
+	}
+	rb.Notice(fmt.Sprintf(client.t("This announcement will be sent to %d users. To confirm, type: /ANNOUNCE CONFIRM %s"), len(recipients), code))
+	return false
+}
+
+func confirmAnnouncement(server *Server, client *Client, code string, rb *ResponseBuffer) {
+	pending := client.pendingAnnouncement()
+	if pending == nil || announceConfirmTimeout < time.Since(pending.created) {
+		rb.Add(nil, server.name, "FAIL", "ANNOUNCE", "NO_PENDING_ANNOUNCEMENT", client.t("You have no announcement to confirm"))
+		return
+	} else if !utils.SecretTokensMatch(pending.code, code) {
+		rb.Add(nil, server.name, "FAIL", "ANNOUNCE", "INVALID_CODE", client.t("Incorrect confirmation code"))
+		return
+	}
+	// the recipients may have changed since the announcement was prepared
+	recipients, err := server.announcementRecipients(pending.target)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "ANNOUNCE", "INVALID_TARGET", pending.target, client.t("No such channel"))
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&server.announcing, 0, 1) {
+		rb.Add(nil, server.name, "FAIL", "ANNOUNCE", "IN_PROGRESS", client.t("Another announcement is being delivered; try again later"))
+		return
+	}
+	client.setPendingAnnouncement(nil)
+
+	server.logger.Info("audit", fmt.Sprintf("Oper %s sent an announcement to %s (%d users): %s", client.Oper().Name, pending.target, len(recipients), pending.message))
+	go server.deliverAnnouncement(client, recipients, announcementLines(pending.message))
+}
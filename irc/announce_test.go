@@ -0,0 +1,110 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnouncementLines(t *testing.T) {
+	lines := announcementLines(strings.Repeat("word ", 200))
+	if len(lines) < 2 {
+		t.Fatalf("long announcement should be wrapped: %v", lines)
+	}
+	if !strings.Contains(lines[0], "[Announcement]") || strings.Contains(lines[1], "[Announcement]") {
+		t.Errorf("only the first line should be labeled: %v", lines)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	staff := h.Register("staff")
+	alice := h.Register("alice")
+	sStaff := h.server.clients.Get("staff")
+	oper := h.server.Config().operators["dan"]
+	sStaff.stateMutex.Lock()
+	sStaff.oper = oper
+	sStaff.stateMutex.Unlock()
+
+	alice.Send("ANNOUNCE", "*", "maintenance tonight")
+	alice.Expect(ERR_NOPRIVILEGES)
+
+	staff.Send("ANNOUNCE", "#nonexistent", "maintenance tonight")
+	if msg := staff.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "INVALID_TARGET" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+
+	staff.Send("ANNOUNCE", "*", "maintenance tonight")
+	staff.Expect("NOTICE")
+	prompt := staff.Expect("NOTICE")
+	fields := strings.Fields(prompt.Params[1])
+	code := fields[len(fields)-1]
+
+	staff.Send("ANNOUNCE", "CONFIRM", "wrong")
+	if msg := staff.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "INVALID_CODE" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+
+	staff.Send("ANNOUNCE", "CONFIRM", code)
+	if msg := alice.Expect("NOTICE"); !strings.Contains(msg.Params[1], "maintenance tonight") {
+		t.Errorf("unexpected announcement: %v", msg)
+	}
+
+	// the code can't be reused
+	staff.Send("ANNOUNCE", "CONFIRM", code)
+	if msg := staff.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "NO_PENDING_ANNOUNCEMENT" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+}
+
+func TestAnnounceMsgid(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	staff := h.Register("staff")
+	sStaff := h.server.clients.Get("staff")
+	oper := h.server.Config().operators["dan"]
+	sStaff.stateMutex.Lock()
+	sStaff.oper = oper
+	sStaff.stateMutex.Unlock()
+
+	var recipients []*testClient
+	for _, nick := range []string{"alice", "bob"} {
+		client := h.Connect()
+		client.nick = nick
+		client.Send("CAP", "REQ", "message-tags server-time")
+		client.Expect("CAP")
+		client.Send("NICK", nick)
+		client.Send("USER", "u", "0", "*", "simulated client")
+		client.Send("CAP", "END")
+		client.Expect(RPL_WELCOME)
+		recipients = append(recipients, client)
+	}
+
+	staff.Send("ANNOUNCE", "*", "maintenance tonight")
+	staff.Expect("NOTICE")
+	prompt := staff.Expect("NOTICE")
+	fields := strings.Fields(prompt.Params[1])
+	staff.Send("ANNOUNCE", "CONFIRM", fields[len(fields)-1])
+
+	// every recipient gets the same msgid, taken from a single SplitMessage
+	var msgids []string
+	for _, client := range recipients {
+		msg := client.Expect("NOTICE")
+		hasMsgid, msgid := msg.GetTag("draft/msgid")
+		if !hasMsgid || msgid == "" {
+			t.Fatalf("announcement should have a msgid: %v", msg)
+		}
+		if !msg.HasTag("time") {
+			t.Errorf("announcement should have a time: %v", msg)
+		}
+		msgids = append(msgids, msgid)
+	}
+	if msgids[0] != msgids[1] {
+		t.Errorf("recipients got different msgids: %v", msgids)
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// The admin API is an HTTP interface for account and ban management, intended for
+// web portals and moderation dashboards. Every endpoint takes and returns JSON;
+// requests must be authorized, either with a bearer token (`Authorization: Bearer ...`)
+// or with a TLS client certificate whose fingerprint is listed in the config.
+
+const (
+	// maximum size of a request body
+	apiMaxBodySize = 1 << 16
+	// name recorded as the "oper" who set klines through the API
+	apiOperName = "api"
+)
+
+// APIConfig controls the admin HTTP API.
+type APIConfig struct {
+	Enabled bool
+	// if empty, the API is only served on TLS listeners with multiplex-http
+	Listener string
+	TLS      *TLSListenConfig
+	// bearer tokens that authorize requests
+	BearerTokens []string `yaml:"bearer-tokens"`
+	// SHA-256 fingerprints of TLS client certificates that authorize requests
+	ClientCertificates []string `yaml:"client-certificates"`
+}
+
+func (conf *APIConfig) prepare() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if len(conf.ClientCertificates) != 0 && conf.TLS == nil && conf.Listener != "" {
+		return fmt.Errorf("API client certificates require TLS")
+	}
+	if len(conf.BearerTokens) == 0 && len(conf.ClientCertificates) == 0 {
+		return fmt.Errorf("API is enabled, but no bearer tokens or client certificates were configured")
+	}
+	for i, certfp := range conf.ClientCertificates {
+		conf.ClientCertificates[i] = strings.ToLower(strings.Replace(certfp, ":", "", -1))
+	}
+	return nil
+}
+
+// apiError is an error with a corresponding HTTP status code.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (err *apiError) Error() string {
+	return err.message
+}
+
+func apiBadRequest(message string) error {
+	return &apiError{status: http.StatusBadRequest, message: message}
+}
+
+func apiNotFound(message string) error {
+	return &apiError{status: http.StatusNotFound, message: message}
+}
+
+// apiHandler handles an authorized request; its result is serialized as JSON.
+type apiHandler func(server *Server, request *http.Request) (result interface{}, err error)
+
+// apiEndpoint describes one endpoint of the API.
+type apiEndpoint struct {
+	method  string
+	handler apiHandler
+}
+
+var (
+	apiEndpoints = map[string]apiEndpoint{
+		"/v1/account/erase":        {method: "POST", handler: apiAccountEraseHandler},
+		"/v1/account/erase/cancel": {method: "POST", handler: apiAccountEraseCancelHandler},
+		"/v1/account/export":       {method: "GET", handler: apiAccountExportHandler},
+		"/v1/account/info":         {method: "GET", handler: apiAccountInfoHandler},
+		"/v1/account/register":     {method: "POST", handler: apiAccountRegisterHandler},
+		"/v1/account/suspend":      {method: "POST", handler: apiAccountSuspendHandler},
+		"/v1/account/unsuspend":    {method: "POST", handler: apiAccountUnsuspendHandler},
+		"/v1/channel/info":         {method: "GET", handler: apiChannelInfoHandler},
+		"/v1/clients":              {method: "GET", handler: apiClientsHandler},
+		"/v1/kline/add":            {method: "POST", handler: apiKlineAddHandler},
+		"/v1/kline/del":            {method: "POST", handler: apiKlineDelHandler},
+		"/v1/klines":               {method: "GET", handler: apiKlinesHandler},
+		"/v1/stats":                {method: "GET", handler: apiStatsHandler},
+	}
+)
+
+// setupAPIListener starts, stops, or restarts the API listener as required by the config.
+func (server *Server) setupAPIListener(config *Config) {
+	apiConfig := config.API
+	if server.apiServer != nil {
+		if !apiConfig.Enabled || apiConfig.Listener != server.apiServer.Addr || !apiTLSConfigEqual(apiConfig.TLS, server.apiTLS) {
+			server.logger.Info("server", "Stopping API listener", server.apiServer.Addr)
+			server.apiServer.Close()
+			server.apiServer = nil
+		}
+	}
+	// without a listener of its own, the API is only served by multiplexed listeners
+	if !apiConfig.Enabled || apiConfig.Listener == "" || server.apiServer != nil {
+		return
+	}
+
+	as := http.Server{
+		Addr:         apiConfig.Listener,
+		Handler:      server.apiHandler(),
+		ReadTimeout:  time.Minute,
+		WriteTimeout: time.Minute,
+	}
+	if apiConfig.TLS != nil {
+		tlsConfig, err := apiConfig.TLS.Config()
+		if err != nil {
+			server.logger.Error("server", "Could not load API TLS certificate", err.Error())
+			return
+		}
+		// client certificates are requested, and checked against the
+		// configured fingerprints
+		as.TLSConfig = tlsConfig
+	}
+
+	go func() {
+		var err error
+		if as.TLSConfig != nil {
+			err = as.ListenAndServeTLS("", "")
+		} else {
+			err = as.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			server.logger.Error("server", "API listener failed", err.Error())
+		}
+	}()
+	server.apiServer = &as
+	server.apiTLS = apiConfig.TLS
+	server.logger.Info("server", "Started API listener", as.Addr)
+}
+
+// apiHandler returns an http.Handler serving all the API endpoints.
+func (server *Server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	for path, endpoint := range apiEndpoints {
+		mux.Handle(path, &apiEndpointServer{server: server, endpoint: endpoint})
+	}
+	return mux
+}
+
+func apiTLSConfigEqual(a, b *TLSListenConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return reflect.DeepEqual(*a, *b)
+}
+
+// apiEndpointServer is the http.Handler for a single endpoint.
+type apiEndpointServer struct {
+	server   *Server
+	endpoint apiEndpoint
+}
+
+func (es *apiEndpointServer) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !apiAuthorized(es.server.Config().API, request) {
+		writeAPIResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	if request.Method != es.endpoint.method {
+		writeAPIResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+		return
+	}
+	request.Body = http.MaxBytesReader(w, request.Body, apiMaxBodySize)
+
+	result, err := es.endpoint.handler(es.server, request)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if apiErr, ok := err.(*apiError); ok {
+			status = apiErr.status
+		} else {
+			es.server.logger.Error("api", "request failed", request.URL.Path, err.Error())
+		}
+		writeAPIResponse(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, result)
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, result interface{}) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// apiAuthorized checks the request's bearer token or TLS client certificate.
+func apiAuthorized(config APIConfig, request *http.Request) bool {
+	if auth := request.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for _, validToken := range config.BearerTokens {
+			if subtle.ConstantTimeCompare(token, []byte(validToken)) == 1 {
+				return true
+			}
+		}
+	}
+	if request.TLS != nil && len(request.TLS.PeerCertificates) != 0 {
+		rawCert := sha256.Sum256(request.TLS.PeerCertificates[0].Raw)
+		fingerprint := hex.EncodeToString(rawCert[:])
+		for _, validFingerprint := range config.ClientCertificates {
+			if fingerprint == validFingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeAPIRequest(request *http.Request, result interface{}) error {
+	if err := json.NewDecoder(request.Body).Decode(result); err != nil {
+		return apiBadRequest("Invalid JSON in request body")
+	}
+	return nil
+}
+
+// apiAccountInfo is the description of an account returned by the API.
+type apiAccountInfo struct {
+	Name            string     `json:"name"`
+	RegisteredAt    time.Time  `json:"registered_at"`
+	LastLogin       *time.Time `json:"last_login,omitempty"`
+	LastQuit        *time.Time `json:"last_quit,omitempty"`
+	AdditionalNicks []string   `json:"additional_nicks"`
+	Channels        []string   `json:"channels"`
+	Suspended       bool       `json:"suspended"`
+	SuspendReason   string     `json:"suspend_reason,omitempty"`
+	Sessions        int        `json:"sessions"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// GET /v1/account/info?name=<account>
+func apiAccountInfoHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	name := request.URL.Query().Get("name")
+	account, err := server.accounts.LoadAccount(name)
+	if err != nil || !account.Verified {
+		return nil, apiNotFound(errAccountDoesNotExist.Error())
+	}
+	return apiAccountInfo{
+		Name:            account.Name,
+		RegisteredAt:    account.RegisteredAt,
+		LastLogin:       optionalTime(account.LastLogin),
+		LastQuit:        optionalTime(account.LastQuit),
+		AdditionalNicks: account.AdditionalNicks,
+		Channels:        server.accounts.ChannelsForAccount(name),
+		Suspended:       account.Suspended,
+		SuspendReason:   account.SuspendReason,
+		Sessions:        len(server.accounts.AccountToClients(name)),
+	}, nil
+}
+
+// POST /v1/account/register {"name": ..., "passphrase": ...}
+func apiAccountRegisterHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Name       string `json:"name"`
+		Passphrase string `json:"passphrase"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+	if params.Name == "" || params.Passphrase == "" {
+		return nil, apiBadRequest("Account name and passphrase are required")
+	}
+
+	err = server.accounts.Register(nil, params.Name, "admin", "", params.Passphrase, "")
+	if err == nil {
+		err = server.accounts.Verify(nil, params.Name, "")
+	}
+	switch err {
+	case nil:
+		server.logger.Info("api", "registered account", params.Name)
+		return map[string]string{"name": params.Name}, nil
+	case errAccountAlreadyRegistered, errAccountAlreadyVerified:
+		return nil, &apiError{status: http.StatusConflict, message: errAccountAlreadyRegistered.Error()}
+	case errAccountBadPassphrase, errAccountCreation:
+		return nil, apiBadRequest(err.Error())
+	default:
+		return nil, err
+	}
+}
+
+// POST /v1/account/suspend {"name": ..., "reason": ...}
+func apiAccountSuspendHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+	err = server.accounts.Suspend(params.Name, params.Reason)
+	if err == errAccountDoesNotExist {
+		return nil, apiNotFound(err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf("Account %s was suspended via the API", params.Name))
+	return map[string]string{"name": params.Name}, nil
+}
+
+// POST /v1/account/unsuspend {"name": ...}
+func apiAccountUnsuspendHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Name string `json:"name"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+	err = server.accounts.Unsuspend(params.Name)
+	if err == errAccountDoesNotExist || err == errAccountNotSuspended {
+		return nil, apiNotFound(err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf("Account %s was unsuspended via the API", params.Name))
+	return map[string]string{"name": params.Name}, nil
+}
+
+// GET /v1/account/export?name=<account>
+func apiAccountExportHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	name := request.URL.Query().Get("name")
+	export, err := server.ExportAccountData(name)
+	if err == errAccountDoesNotExist {
+		return nil, apiNotFound(err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// POST /v1/account/erase {"name": ..., "immediate": ...}
+func apiAccountEraseHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Name      string `json:"name"`
+		Immediate bool   `json:"immediate"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+	account, err := CasefoldName(params.Name)
+	if err != nil {
+		return nil, apiNotFound(errAccountDoesNotExist.Error())
+	}
+	erasureAt, err := server.accounts.ScheduleErasure(account, params.Immediate)
+	if err == errAccountDoesNotExist {
+		return nil, apiNotFound(err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	server.logger.Info("audit", "account erasure requested via the API", account)
+	return map[string]interface{}{"name": params.Name, "erasure_at": erasureAt.UTC()}, nil
+}
+
+// POST /v1/account/erase/cancel {"name": ...}
+func apiAccountEraseCancelHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Name string `json:"name"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+	account, err := CasefoldName(params.Name)
+	if err != nil {
+		return nil, apiNotFound(errAccountDoesNotExist.Error())
+	}
+	err = server.accounts.CancelErasure(account)
+	if err == errErasureNotScheduled {
+		return nil, apiNotFound(err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	return map[string]string{"name": params.Name}, nil
+}
+
+// GET /v1/channel/info?name=<channel>
+func apiChannelInfoHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	cfname, err := CasefoldChannel(request.URL.Query().Get("name"))
+	if err != nil {
+		return nil, apiBadRequest(errInvalidChannelName.Error())
+	}
+	info := server.channelRegistry.LoadChannel(cfname)
+	if info == nil {
+		return nil, apiNotFound("Channel is not registered")
+	}
+
+	var members int
+	if channel := server.channels.Get(cfname); channel != nil {
+		members = len(channel.Members())
+	}
+	return struct {
+		Name           string    `json:"name"`
+		RegisteredAt   time.Time `json:"registered_at"`
+		Founder        string    `json:"founder"`
+		Topic          string    `json:"topic"`
+		Members        int       `json:"members"`
+		VerifiedDomain string    `json:"verified_domain,omitempty"`
+	}{
+		Name:           info.Name,
+		RegisteredAt:   info.RegisteredAt,
+		Founder:        info.Founder,
+		Topic:          info.Topic,
+		Members:        members,
+		VerifiedDomain: info.Verification.VerifiedDomain(),
+	}, nil
+}
+
+// apiClientInfo is the description of a connected client returned by the API.
+type apiClientInfo struct {
+	Nick     string    `json:"nick"`
+	Username string    `json:"username"`
+	Hostname string    `json:"hostname"`
+	IP       string    `json:"ip"`
+	Realname string    `json:"realname"`
+	Account  string    `json:"account,omitempty"`
+	Signon   time.Time `json:"signon"`
+}
+
+// GET /v1/clients
+func apiClientsHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	clients := make([]apiClientInfo, 0)
+	for _, client := range server.clients.AllClients() {
+		if !client.Registered() {
+			continue
+		}
+		details := client.Details()
+		clients = append(clients, apiClientInfo{
+			Nick:     details.nick,
+			Username: details.username,
+			Hostname: details.hostname,
+			IP:       details.ip.String(),
+			Realname: details.realname,
+			Account:  details.accountName,
+			Signon:   time.Unix(client.SignonTime(), 0),
+		})
+	}
+	return clients, nil
+}
+
+// apiStats is the description of the server's statistics returned by the API.
+type apiStats struct {
+	StatsValues
+	Channels int `json:"channels"`
+}
+
+// GET /v1/stats
+func apiStatsHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	return apiStats{StatsValues: server.stats.GetValues(), Channels: server.channels.Len()}, nil
+}
+
+// GET /v1/klines
+func apiKlinesHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	return server.klines.AllBans(), nil
+}
+
+// POST /v1/kline/add {"mask": ..., "duration": ..., "reason": ..., "oper_reason": ...}
+func apiKlineAddHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Mask       string `json:"mask"`
+		Duration   string `json:"duration"`
+		Reason     string `json:"reason"`
+		OperReason string `json:"oper_reason"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+	if params.Mask == "" {
+		return nil, apiBadRequest("A mask is required")
+	}
+	var duration time.Duration
+	if params.Duration != "" {
+		duration, err = custime.ParseDuration(params.Duration)
+		if err != nil {
+			return nil, apiBadRequest("Invalid duration")
+		}
+	}
+	if params.Reason == "" {
+		params.Reason = "No reason given"
+	}
+
+	mask := canonicalizeKlineMask(params.Mask)
+	err = server.klines.AddMask(mask, duration, params.Reason, params.OperReason, apiOperName)
+	if err != nil {
+		return nil, err
+	}
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf("API added K-Line for %s", mask))
+	return map[string]string{"mask": mask}, nil
+}
+
+// POST /v1/kline/del {"mask": ...}
+func apiKlineDelHandler(server *Server, request *http.Request) (result interface{}, err error) {
+	var params struct {
+		Mask string `json:"mask"`
+	}
+	if err = decodeAPIRequest(request, &params); err != nil {
+		return
+	}
+
+	mask := canonicalizeKlineMask(params.Mask)
+	if err = server.klines.RemoveMask(mask); err != nil {
+		return nil, apiNotFound(err.Error())
+	}
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf("API removed K-Line for %s", mask))
+	return map[string]string{"mask": mask}, nil
+}
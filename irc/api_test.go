@@ -0,0 +1,48 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIConfig(t *testing.T) {
+	conf := APIConfig{Enabled: true, Listener: "localhost:8089"}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("API without any credentials should be rejected")
+	}
+
+	conf = APIConfig{Enabled: true, Listener: "localhost:8089", ClientCertificates: []string{"AB:CD"}}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("client certificates without TLS should be rejected")
+	}
+
+	conf.TLS = &TLSListenConfig{Cert: "api.pem", Key: "api.key"}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.ClientCertificates[0] != "abcd" {
+		t.Errorf("fingerprint was not normalized: %s", conf.ClientCertificates[0])
+	}
+}
+
+func TestAPIAuthorized(t *testing.T) {
+	conf := APIConfig{BearerTokens: []string{"hunter2"}}
+
+	request := httptest.NewRequest("GET", "/v1/clients", nil)
+	if apiAuthorized(conf, request) {
+		t.Errorf("request without credentials was authorized")
+	}
+
+	request.Header.Set("Authorization", "Bearer hunter3")
+	if apiAuthorized(conf, request) {
+		t.Errorf("request with an invalid token was authorized")
+	}
+
+	request.Header.Set("Authorization", "Bearer hunter2")
+	if !apiAuthorized(conf, request) {
+		t.Errorf("request with a valid token was not authorized")
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// API tokens let bots log into an account without its password, and with a
+// restricted set of capabilities: for example, a token can allow sending
+// messages in a few channels, but not changing the account's settings. Tokens
+// are created with NickServ TOKEN ADD, which shows the secret once, and are
+// sent as the SASL PLAIN password, in the form apitoken:<name>:<secret>.
+// Revoking a token (NickServ TOKEN DEL) disconnects the clients using it.
+
+const (
+	apiTokenPrefix = "apitoken:"
+
+	// token names follow the same rules as device IDs, see readmarker.go
+	maxAPITokenNameLength = maxDeviceIDLength
+
+	defaultMaxAPITokensPerAccount = 10
+
+	// sending PRIVMSG, NOTICE, TAGMSG, and RELAYMSG, and setting metadata
+	apiTokenCapMessage = "message"
+	// joining channels
+	apiTokenCapJoin = "join"
+	// using NickServ, ChanServ, and HostServ (other than HELP)
+	apiTokenCapServices = "services"
+)
+
+var (
+	apiTokenCapabilities = map[string]bool{
+		apiTokenCapJoin:     true,
+		apiTokenCapMessage:  true,
+		apiTokenCapServices: true,
+	}
+	defaultAPITokenCapabilities = []string{apiTokenCapJoin, apiTokenCapMessage}
+
+	errInvalidAPITokenName = errors.New("Invalid token name")
+	errInvalidAPITokenCap  = errors.New("Invalid token capability")
+	errNoSuchAPIToken      = errors.New("No such token")
+	errAPITokenExists      = errors.New("You already have a token with that name")
+	errTooManyAPITokens    = errors.New("You have too many tokens already")
+	errAPITokenRestricted  = errors.New("Not permitted by the API token you logged in with")
+)
+
+// APITokensConfig controls API tokens.
+type APITokensConfig struct {
+	Enabled       bool
+	MaxPerAccount int `yaml:"max-per-account"`
+}
+
+func (conf *APITokensConfig) prepare() error {
+	if conf.MaxPerAccount == 0 {
+		conf.MaxPerAccount = defaultMaxAPITokensPerAccount
+	}
+	return nil
+}
+
+// APIToken is a token for logging into an account with restricted capabilities.
+type APIToken struct {
+	Name string `json:"-"`
+	// hex-encoded SHA-256 of the secret (which is random, so it needs no salt)
+	Hash         string
+	Capabilities []string
+	// if nonempty, the (casefolded) channels that the token can join and message
+	Channels  []string `json:",omitempty"`
+	CreatedAt time.Time
+	LastUsed  time.Time
+}
+
+// Allows returns whether the token has the given capability.
+func (token *APIToken) Allows(capability string) bool {
+	for _, tokenCap := range token.Capabilities {
+		if tokenCap == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsChannel returns whether the token can be used in the (casefolded) channel.
+func (token *APIToken) AllowsChannel(cfchname string) bool {
+	if len(token.Channels) == 0 {
+		return true
+	}
+	for _, channel := range token.Channels {
+		if channel == cfchname {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPITokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// normalizeAPITokenName validates and lowercases a token name.
+func normalizeAPITokenName(name string) (string, error) {
+	if !isValidDeviceID(name) || maxAPITokenNameLength < len(name) {
+		return "", errInvalidAPITokenName
+	}
+	return strings.ToLower(name), nil
+}
+
+// parseAPITokenCapabilities parses a comma-separated list of capabilities.
+func parseAPITokenCapabilities(str string) (capabilities []string, err error) {
+	seen := make(map[string]bool)
+	for _, capability := range strings.Split(strings.ToLower(str), ",") {
+		if !apiTokenCapabilities[capability] {
+			return nil, errInvalidAPITokenCap
+		}
+		if !seen[capability] {
+			seen[capability] = true
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+	return
+}
+
+// parseAPITokenChannels parses a comma-separated list of channels.
+func parseAPITokenChannels(str string) (channels []string, err error) {
+	for _, chname := range strings.Split(str, ",") {
+		cfchname, err := CasefoldChannel(chname)
+		if err != nil {
+			return nil, errInvalidChannelName
+		}
+		channels = append(channels, cfchname)
+	}
+	return
+}
+
+func (am *AccountManager) loadAPITokens(tx *buntdb.Tx, account string) (tokens map[string]APIToken) {
+	tokens = make(map[string]APIToken)
+	if rawTokens, err := tx.Get(fmt.Sprintf(keyAccountAPITokens, account)); err == nil {
+		json.Unmarshal([]byte(rawTokens), &tokens)
+	}
+	for name, token := range tokens {
+		token.Name = name
+		tokens[name] = token
+	}
+	return
+}
+
+// APITokens returns the (casefolded) account's API tokens.
+func (am *AccountManager) APITokens(account string) (tokens map[string]APIToken) {
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		tokens = am.loadAPITokens(tx, account)
+		return nil
+	})
+	return
+}
+
+// modifyAPITokens runs `update` on the (casefolded) account's API tokens, then
+// stores them if it succeeds.
+func (am *AccountManager) modifyAPITokens(account string, update func(tokens map[string]APIToken) error) error {
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return errAccountDoesNotExist
+		}
+		tokens := am.loadAPITokens(tx, account)
+		if err := update(tokens); err != nil {
+			return err
+		}
+		rawTokens, err := json.Marshal(tokens)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(fmt.Sprintf(keyAccountAPITokens, account), string(rawTokens), nil)
+		return err
+	})
+}
+
+// exportAPITokens describes the tokens for an account data export, sorted by name.
+func exportAPITokens(tokens map[string]APIToken) (result []AccountDataAPIToken) {
+	for name, token := range tokens {
+		result = append(result, AccountDataAPIToken{
+			Name:         name,
+			Capabilities: token.Capabilities,
+			Channels:     token.Channels,
+			CreatedAt:    token.CreatedAt,
+			LastUsed:     optionalTime(token.LastUsed),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return
+}
+
+// AddAPIToken creates an API token for the (casefolded) account, returning
+// the token to log in with.
+func (am *AccountManager) AddAPIToken(account, name string, capabilities, channels []string) (loginToken string, err error) {
+	secret := utils.GenerateSecretToken()
+	maxTokens := am.server.AccountConfig().APITokens.MaxPerAccount
+	err = am.modifyAPITokens(account, func(tokens map[string]APIToken) error {
+		if _, exists := tokens[name]; exists {
+			return errAPITokenExists
+		} else if maxTokens <= len(tokens) {
+			return errTooManyAPITokens
+		}
+		tokens[name] = APIToken{
+			Hash:         hashAPITokenSecret(secret),
+			Capabilities: capabilities,
+			Channels:     channels,
+			CreatedAt:    time.Now().UTC(),
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s:%s", apiTokenPrefix, name, secret), nil
+}
+
+// DeleteAPIToken revokes one of the (casefolded) account's API tokens, and
+// disconnects the clients that are logged in with it.
+func (am *AccountManager) DeleteAPIToken(account, name string) error {
+	err := am.modifyAPITokens(account, func(tokens map[string]APIToken) error {
+		if _, exists := tokens[name]; !exists {
+			return errNoSuchAPIToken
+		}
+		delete(tokens, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, session := range am.AccountToClients(account) {
+		if token := session.APIToken(); token != nil && token.Name == name {
+			session.Quit(session.t("The API token you logged in with was revoked"))
+			session.destroy(false)
+		}
+	}
+	return nil
+}
+
+// checkAPIToken checks a SASL password of the form apitoken:<name>:<secret>
+// against the account's tokens, recording the time the token was used.
+func (am *AccountManager) checkAPIToken(accountName, passphrase string) (account ClientAccount, token *APIToken, err error) {
+	nameAndSecret := strings.SplitN(strings.TrimPrefix(passphrase, apiTokenPrefix), ":", 2)
+	if len(nameAndSecret) != 2 {
+		return account, nil, errAccountInvalidCredentials
+	}
+	name, secret := strings.ToLower(nameAndSecret[0]), nameAndSecret[1]
+
+	account, err = am.LoadAccount(accountName)
+	if err != nil {
+		return
+	}
+	casefoldedAccount, _ := CasefoldName(account.Name)
+	if !account.Verified {
+		return account, nil, errAccountUnverified
+	} else if account.Suspended {
+		return account, nil, errAccountSuspended
+	}
+
+	err = am.modifyAPITokens(casefoldedAccount, func(tokens map[string]APIToken) error {
+		stored, exists := tokens[name]
+		if !exists || !utils.SecretTokensMatch(stored.Hash, hashAPITokenSecret(secret)) {
+			return errAccountInvalidCredentials
+		}
+		stored.LastUsed = time.Now().UTC()
+		tokens[name] = stored
+		token = &stored
+		return nil
+	})
+	return
+}
+
+// apiTokenAllows returns whether the client isn't prevented from using a
+// capability by the API token it logged in with.
+func (client *Client) apiTokenAllows(capability string) bool {
+	token := client.APIToken()
+	return token == nil || token.Allows(capability)
+}
+
+// apiTokenAllowsChannel returns whether the client can join or message the
+// (casefolded) channel, given the API token it logged in with.
+func (client *Client) apiTokenAllowsChannel(cfchname string) bool {
+	token := client.APIToken()
+	return token == nil || token.AllowsChannel(cfchname)
+}
+
+func nsTokenHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	subcommand := "list"
+	if len(params) != 0 {
+		subcommand = strings.ToLower(params[0])
+	}
+
+	var name string
+	switch subcommand {
+	case "add", "del":
+		// otherwise, a token could be used to create a more powerful one
+		if client.APIToken() != nil {
+			nsNotice(rb, client.t("You can't manage API tokens while logged in with one"))
+			return
+		}
+		if len(params) < 2 {
+			nsNotice(rb, client.t("Invalid parameters. For usage, do /msg NickServ HELP TOKEN"))
+			return
+		}
+		var err error
+		name, err = normalizeAPITokenName(params[1])
+		if err != nil {
+			nsNotice(rb, client.t(err.Error()))
+			return
+		}
+	}
+
+	var err error
+	switch subcommand {
+	case "list":
+		tokens := server.accounts.APITokens(account)
+		if len(tokens) == 0 {
+			nsNotice(rb, client.t("You have no API tokens"))
+			return
+		}
+		names := make([]string, 0, len(tokens))
+		for name := range tokens {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			token := tokens[name]
+			channels := strings.Join(token.Channels, " ")
+			if channels == "" {
+				channels = client.t("(any)")
+			}
+			lastUsed := client.t("never")
+			if !token.LastUsed.IsZero() {
+				lastUsed = token.LastUsed.Format(IRCv3TimestampFormat)
+			}
+			nsNotice(rb, fmt.Sprintf(client.t("%[1]s: capabilities: %[2]s, channels: %[3]s, created %[4]s, last used %[5]s"), name, strings.Join(token.Capabilities, ","), channels, token.CreatedAt.Format(IRCv3TimestampFormat), lastUsed))
+		}
+		return
+	case "add":
+		capabilities, channels := defaultAPITokenCapabilities, []string(nil)
+		for _, param := range params[2:] {
+			if strings.HasPrefix(param, "#") {
+				channels, err = parseAPITokenChannels(param)
+			} else {
+				capabilities, err = parseAPITokenCapabilities(param)
+			}
+			if err != nil {
+				nsNotice(rb, client.t(err.Error()))
+				return
+			}
+		}
+		var loginToken string
+		loginToken, err = server.accounts.AddAPIToken(account, name, capabilities, channels)
+		if err == nil {
+			nsNotice(rb, fmt.Sprintf(client.t("Created API token %[1]s. To use it, log in with SASL PLAIN as %[2]s, with this password: %[3]s"), name, client.AccountName(), loginToken))
+			nsNotice(rb, client.t("This is the only time the token will be shown, so store it now"))
+		}
+	case "del":
+		err = server.accounts.DeleteAPIToken(account, name)
+		if err == nil {
+			nsNotice(rb, fmt.Sprintf(client.t("Revoked API token %s"), name))
+		}
+	default:
+		nsNotice(rb, client.t("Invalid parameters. For usage, do /msg NickServ HELP TOKEN"))
+		return
+	}
+
+	switch err {
+	case nil:
+	case errNoSuchAPIToken, errAPITokenExists, errTooManyAPITokens:
+		nsNotice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "couldn't modify API tokens", err.Error())
+		nsNotice(rb, client.t("An error occurred"))
+	}
+}
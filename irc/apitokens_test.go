@@ -0,0 +1,135 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAPITokenCapabilities(t *testing.T) {
+	capabilities, err := parseAPITokenCapabilities("MESSAGE,services,message")
+	if err != nil || !reflect.DeepEqual(capabilities, []string{"message", "services"}) {
+		t.Errorf("unexpected capabilities: %v, %v", capabilities, err)
+	}
+	if _, err := parseAPITokenCapabilities("message,oper"); err != errInvalidAPITokenCap {
+		t.Errorf("unknown capabilities should be rejected")
+	}
+
+	token := APIToken{Capabilities: capabilities, Channels: []string{"#bots"}}
+	if !token.Allows(apiTokenCapMessage) || token.Allows(apiTokenCapJoin) {
+		t.Errorf("incorrect capabilities")
+	}
+	if !token.AllowsChannel("#bots") || token.AllowsChannel("#other") {
+		t.Errorf("incorrect channel restrictions")
+	}
+}
+
+// loginWithAPIToken connects a client that logs into the account with the
+// token, using SASL PLAIN.
+func loginWithAPIToken(h *testHarness, account, loginToken string) *testClient {
+	client := h.Connect()
+	client.nick = account
+	client.Send("CAP", "REQ", "sasl")
+	client.Expect("CAP")
+	client.Send("NICK", account)
+	client.Send("USER", "u", "0", "*", "simulated client")
+	client.Send("AUTHENTICATE", "PLAIN")
+	client.Expect("AUTHENTICATE")
+	client.Send("AUTHENTICATE", base64.StdEncoding.EncodeToString([]byte(account+"\x00"+account+"\x00"+loginToken)))
+	client.Expect(RPL_SASLSUCCESS)
+	client.Send("CAP", "END")
+	client.Expect(RPL_WELCOME)
+	return client
+}
+
+func TestAPITokens(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Accounts.APITokens.Enabled = true
+	})
+	defer h.Close()
+
+	if err := h.server.accounts.Register(nil, "dan", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.accounts.Verify(nil, "dan", ""); err != nil {
+		t.Fatal(err)
+	}
+	loginToken, err := h.server.accounts.AddAPIToken("dan", "bot", defaultAPITokenCapabilities, []string{"#bots"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot := loginWithAPIToken(h, "dan", loginToken)
+
+	if token := h.server.accounts.APITokens("dan")["bot"]; token.LastUsed.IsZero() {
+		t.Errorf("last use wasn't recorded")
+	}
+
+	bot.Send("JOIN", "#bots")
+	bot.Expect(RPL_ENDOFNAMES)
+	bot.Send("JOIN", "#other")
+	if msg := bot.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "TOKEN_RESTRICTED" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+	bot.Send("NS", "TOKEN", "ADD", "another")
+	if msg := bot.Expect("NOTICE"); msg.Params[1] != errAPITokenRestricted.Error() {
+		t.Errorf("token shouldn't allow using services: %v", msg)
+	}
+
+	if err := h.server.accounts.DeleteAPIToken("dan", "bot"); err != nil {
+		t.Fatal(err)
+	}
+	bot.Expect("ERROR")
+}
+
+func TestAPITokenRestrictions(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Accounts.APITokens.Enabled = true
+		config.Server.Metadata.Enabled = true
+		config.Server.Relaymsg = RelaymsgConfig{Enabled: true, Separators: "/", AvailableToChanops: true}
+	})
+	defer h.Close()
+
+	if err := h.server.accounts.Register(nil, "dan", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.accounts.Verify(nil, "dan", ""); err != nil {
+		t.Fatal(err)
+	}
+	loginToken, err := h.server.accounts.AddAPIToken("dan", "bot", []string{apiTokenCapJoin, apiTokenCapServices}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := loginWithAPIToken(h, "dan", loginToken)
+	expectRestricted := func(command string) {
+		if msg := bot.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[0] != command || msg.Params[1] != "TOKEN_RESTRICTED" {
+			t.Errorf("unexpected reply to %s: %v", command, msg)
+		}
+	}
+
+	bot.Send("JOIN", "#bots")
+	bot.Expect(RPL_ENDOFNAMES)
+	bot.Send("RELAYMSG", "#bots", "remote/irc", "hi")
+	expectRestricted("RELAYMSG")
+	bot.Send("METADATA", "*", "SET", "avatar", "https://example.com/bot.png")
+	expectRestricted("METADATA")
+	bot.Send("METADATA", "#bots", "CLEAR")
+	expectRestricted("METADATA")
+
+	// the token can use services, but not to create or revoke tokens
+	bot.Send("NS", "TOKEN", "ADD", "another", "message,join,services")
+	if msg := bot.Expect("NOTICE"); !strings.Contains(msg.Params[1], "logged in with one") {
+		t.Errorf("tokens shouldn't be able to create tokens: %v", msg)
+	}
+	bot.Send("NS", "TOKEN", "DEL", "bot")
+	if msg := bot.Expect("NOTICE"); !strings.Contains(msg.Params[1], "logged in with one") {
+		t.Errorf("tokens shouldn't be able to revoke tokens: %v", msg)
+	}
+	if tokens := h.server.accounts.APITokens("dan"); len(tokens) != 1 {
+		t.Errorf("unexpected tokens: %v", tokens)
+	}
+}
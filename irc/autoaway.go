@@ -0,0 +1,128 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"time"
+
+	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/modes"
+)
+
+const (
+	// the shortest auto-away period a user can choose
+	minAutoAwayDuration = time.Minute
+	// the away message used when none is configured
+	defaultAutoAwayMessage = "Auto-away (idle)"
+)
+
+// AutoAwayConfig controls whether users can have the server mark them as away
+// automatically after a period of inactivity.
+type AutoAwayConfig struct {
+	Enabled bool
+	Message string
+}
+
+// autoAwayMessage returns the away message to use when marking a client away automatically.
+func (conf *AutoAwayConfig) autoAwayMessage() string {
+	if conf.Message != "" {
+		return conf.Message
+	}
+	return defaultAutoAwayMessage
+}
+
+// SetAutoAway sets the period of inactivity after which the client will be
+// marked away automatically; 0 disables auto-away.
+func (client *Client) SetAutoAway(duration time.Duration) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	client.autoAwayDuration = duration
+	if client.autoAwayTimer != nil {
+		client.autoAwayTimer.Stop()
+		client.autoAwayTimer = nil
+	}
+	if duration != 0 {
+		client.autoAwayTimer = timerWheel.AfterFunc(duration, client.checkAutoAway)
+	}
+}
+
+// checkAutoAway is run by the auto-away timer. If the client has been idle for the
+// full auto-away period, it's marked away; otherwise the timer is rescheduled for
+// the remainder of the period. This way, activity doesn't have to reset the timer.
+func (client *Client) checkAutoAway() {
+	setAway := func() bool {
+		client.stateMutex.Lock()
+		defer client.stateMutex.Unlock()
+
+		if client.autoAwayDuration == 0 || client.isDestroyed {
+			return false
+		}
+		idle := time.Since(client.atime)
+		if idle < client.autoAwayDuration {
+			client.autoAwayTimer = timerWheel.AfterFunc(client.autoAwayDuration-idle, client.checkAutoAway)
+			return false
+		}
+		// check again later, in case the user marks themself unaway
+		client.autoAwayTimer = timerWheel.AfterFunc(client.autoAwayDuration, client.checkAutoAway)
+		if client.autoAwaySet || client.flags.HasMode(modes.Away) {
+			// don't clobber an away message set by the user
+			return false
+		}
+		client.autoAwaySet = true
+		return true
+	}()
+
+	if setAway {
+		client.changeAway(true, client.server.AccountConfig().AutoAway.autoAwayMessage())
+	}
+}
+
+// clearAutoAway is called on activity; it marks the client unaway if it was
+// marked away automatically.
+func (client *Client) clearAutoAway() {
+	client.stateMutex.Lock()
+	wasSet := client.autoAwaySet
+	client.autoAwaySet = false
+	client.stateMutex.Unlock()
+
+	if wasSet {
+		client.changeAway(false, "")
+	}
+}
+
+// changeAway marks the client away or unaway outside the context of an AWAY
+// command, notifying the client itself and its friends.
+func (client *Client) changeAway(isAway bool, awayMessage string) {
+	client.SetMode(modes.Away, isAway)
+	client.SetAwayMessage(awayMessage)
+
+	nick := client.Nick()
+	var op modes.ModeOp
+	if isAway {
+		op = modes.Add
+		client.Send(nil, client.server.name, RPL_NOWAWAY, nick, client.t("You have been marked as being away"))
+	} else {
+		op = modes.Remove
+		client.Send(nil, client.server.name, RPL_UNAWAY, nick, client.t("You are no longer marked as being away"))
+	}
+	modech := modes.ModeChanges{modes.ModeChange{
+		Mode: modes.Away,
+		Op:   op,
+	}}
+	client.Send(nil, client.server.name, "MODE", nick, modech.String())
+
+	dispatchAwayNotify(client, isAway, awayMessage)
+}
+
+// dispatchAwayNotify sends away-notify for a client's change in away status.
+func dispatchAwayNotify(client *Client, isAway bool, awayMessage string) {
+	for friend := range client.Friends(caps.AwayNotify) {
+		if isAway {
+			friend.SendFromClient("", client, nil, "AWAY", awayMessage)
+		} else {
+			friend.SendFromClient("", client, nil, "AWAY")
+		}
+	}
+}
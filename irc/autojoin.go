@@ -0,0 +1,69 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+)
+
+// AutoJoinConfig lists the channels that clients are joined to automatically:
+// every client when it connects, clients that log into accounts (either while
+// connecting or afterwards), and opers when they oper up. Users who are logged
+// into accounts can opt out with NickServ SET AUTOJOIN.
+type AutoJoinConfig struct {
+	OnConnect []string `yaml:"on-connect"`
+	OnLogin   []string `yaml:"on-login"`
+	Opers     []string
+}
+
+func (conf *AutoJoinConfig) prepare() error {
+	for _, list := range [][]string{conf.OnConnect, conf.OnLogin, conf.Opers} {
+		for _, channel := range list {
+			if _, err := CasefoldChannel(channel); err != nil {
+				return fmt.Errorf("Invalid auto-join channel: %s", channel)
+			}
+		}
+	}
+	return nil
+}
+
+// autoJoin joins the client to the given channels, unless it opted out.
+// Channels it can't join (because they're invite-only, full, and so on)
+// are skipped; the client sees the usual error for them.
+func (server *Server) autoJoin(client *Client, channels []string, rb *ResponseBuffer) {
+	if len(channels) == 0 || client.AccountSettings().DisableAutoJoin {
+		return
+	}
+	for _, channel := range channels {
+		server.channels.Join(client, channel, "", false, rb)
+	}
+}
+
+// autoJoinOnConnect joins a client that just completed registration to the
+// auto-join channels.
+func (server *Server) autoJoinOnConnect(client *Client) {
+	config := &server.Config().Channels.AutoJoin
+	channels := config.OnConnect
+	if client.LoggedIntoAccount() {
+		channels = append(channels[:len(channels):len(channels)], config.OnLogin...)
+	}
+	rb := NewResponseBuffer(client)
+	server.autoJoin(client, channels, rb)
+	server.aJoin(client, rb)
+	rb.Send(true)
+}
+
+// autoJoinOnLogin joins a client that just logged into an account to the
+// auto-join channels for logged-in clients, and to the account's own auto-join
+// list. Clients that log in before they complete registration are joined to
+// them in autoJoinOnConnect instead.
+func (server *Server) autoJoinOnLogin(client *Client) {
+	if !client.Registered() {
+		return
+	}
+	rb := NewResponseBuffer(client)
+	server.autoJoin(client, server.Config().Channels.AutoJoin.OnLogin, rb)
+	server.aJoin(client, rb)
+	rb.Send(true)
+}
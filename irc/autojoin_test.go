@@ -0,0 +1,24 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestAutoJoinConfig(t *testing.T) {
+	conf := AutoJoinConfig{
+		OnConnect: []string{"#help"},
+		OnLogin:   []string{"#community", "#Help"},
+		Opers:     []string{"#staff"},
+	}
+	if err := conf.prepare(); err != nil {
+		t.Error(err)
+	}
+
+	conf.Opers = append(conf.Opers, "staff")
+	if err := conf.prepare(); err == nil {
+		t.Errorf("invalid channel names should be rejected")
+	}
+}
@@ -0,0 +1,438 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/oragono/oragono/irc/utils"
+	"github.com/tidwall/buntdb"
+)
+
+// Networks can share bans by publishing blocklists: lists of IPs and networks,
+// hostmasks, and accounts, fetched periodically over HTTPS or read from a
+// local file. Each configured feed manages its own set of D-lines, K-lines,
+// and account suspensions, tagged with the feed's name as their source; when
+// an entry disappears from the feed (or the feed is removed from the config),
+// the corresponding ban is lifted. Feeds never override bans set by opers or
+// by other feeds.
+
+const (
+	keyBlocklistAccounts = "bans.blocklist.accounts %s"
+
+	blocklistFormatJSON = "json"
+	blocklistFormatCSV  = "csv"
+
+	blocklistEntryIP      = "ip"
+	blocklistEntryMask    = "mask"
+	blocklistEntryAccount = "account"
+
+	// how often to check whether any feeds are due to be fetched
+	blocklistCheckInterval = time.Minute
+	// feeds larger than this are rejected
+	blocklistMaxSize = 16 * 1024 * 1024
+
+	defaultBlocklistInterval = time.Hour
+	defaultBlocklistTimeout  = 30 * time.Second
+)
+
+// BlocklistConfig describes a blocklist feed.
+type BlocklistConfig struct {
+	Name string
+	// exactly one of these: an https:// URL, or a local file
+	URL  string
+	Path string
+	// json or csv
+	Format   string
+	Interval time.Duration
+	Timeout  time.Duration
+	// the ban reason shown to users, for entries that don't have their own
+	Reason string
+}
+
+func (conf *BlocklistConfig) prepare() error {
+	if conf.Name == "" || strings.ContainsAny(conf.Name, " ,") {
+		return fmt.Errorf("Blocklist names must be nonempty, and can't contain spaces or commas: %s", conf.Name)
+	}
+	if (conf.URL == "") == (conf.Path == "") {
+		return fmt.Errorf("Blocklist %s must have exactly one of url and path", conf.Name)
+	}
+	if conf.URL != "" {
+		parsed, err := url.Parse(conf.URL)
+		if err != nil || parsed.Scheme != "https" {
+			return fmt.Errorf("Blocklist %s must be fetched over https", conf.Name)
+		}
+	}
+	switch conf.Format {
+	case "":
+		conf.Format = blocklistFormatJSON
+	case blocklistFormatJSON, blocklistFormatCSV:
+	default:
+		return fmt.Errorf("Blocklist %s has unknown format %s", conf.Name, conf.Format)
+	}
+	if conf.Interval == 0 {
+		conf.Interval = defaultBlocklistInterval
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultBlocklistTimeout
+	}
+	if conf.Reason == "" {
+		conf.Reason = fmt.Sprintf("Listed in the %s blocklist", conf.Name)
+	}
+	return nil
+}
+
+// blocklistEntries are the contents of a feed, mapping each (normalized)
+// network, mask, or account to its reason, or to "" for the feed's reason.
+type blocklistEntries struct {
+	networks map[string]string
+	masks    map[string]string
+	accounts map[string]string
+}
+
+func newBlocklistEntries() blocklistEntries {
+	return blocklistEntries{
+		networks: make(map[string]string),
+		masks:    make(map[string]string),
+		accounts: make(map[string]string),
+	}
+}
+
+// add adds an entry, returning an error if it's invalid.
+func (entries *blocklistEntries) add(entryType, value, reason string) error {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(strings.TrimSpace(entryType)) {
+	case blocklistEntryIP:
+		network, err := utils.NormalizedNetFromString(value)
+		if err != nil {
+			return err
+		}
+		entries.networks[utils.NetToNormalizedString(network)] = reason
+	case blocklistEntryMask:
+		if value == "" {
+			return fmt.Errorf("empty mask")
+		}
+		entries.masks[canonicalizeKlineMask(value)] = reason
+	case blocklistEntryAccount:
+		account, err := CasefoldName(value)
+		if err != nil {
+			return err
+		}
+		entries.accounts[account] = reason
+	default:
+		return fmt.Errorf("unknown entry type: %s", entryType)
+	}
+	return nil
+}
+
+// parseBlocklist parses a feed. A JSON feed is a list of objects with "type"
+// (ip, mask, or account), "value", and optionally "reason" fields, and a CSV
+// feed has one entry per line, with the same fields in the same order.
+// Invalid entries are skipped and counted; an error is only returned if the
+// feed as a whole can't be parsed, in which case it must not be applied.
+func parseBlocklist(format string, data []byte) (entries blocklistEntries, invalid int, err error) {
+	entries = newBlocklistEntries()
+	switch format {
+	case blocklistFormatCSV:
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return entries, invalid, err
+			}
+			var reason string
+			if len(record) < 2 {
+				invalid++
+				continue
+			} else if 2 < len(record) {
+				reason = record[2]
+			}
+			if entries.add(record[0], record[1], reason) != nil {
+				invalid++
+			}
+		}
+	default:
+		var items []struct {
+			Type   string `json:"type"`
+			Value  string `json:"value"`
+			Reason string `json:"reason"`
+		}
+		if err = json.Unmarshal(data, &items); err != nil {
+			return
+		}
+		for _, item := range items {
+			if entries.add(item.Type, item.Value, item.Reason) != nil {
+				invalid++
+			}
+		}
+	}
+	return entries, invalid, nil
+}
+
+// fetchBlocklist reads the current contents of a feed.
+func fetchBlocklist(conf *BlocklistConfig) (data []byte, err error) {
+	var reader io.Reader
+	if conf.Path != "" {
+		file, err := ioutil.ReadFile(conf.Path)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(file)
+	} else {
+		client := http.Client{Timeout: conf.Timeout}
+		response, err := client.Get(conf.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status: %s", response.Status)
+		}
+		reader = response.Body
+	}
+	data, err = ioutil.ReadAll(io.LimitReader(reader, blocklistMaxSize+1))
+	if err == nil && blocklistMaxSize < len(data) {
+		err = fmt.Errorf("blocklist is larger than %d bytes", blocklistMaxSize)
+	}
+	return
+}
+
+// BlocklistManager periodically fetches the configured feeds and applies them.
+type BlocklistManager struct {
+	lockorder.Tier3Mutex // serializes updates
+	server               *Server
+	lastFetch            map[string]time.Time
+}
+
+// Initialize starts fetching feeds; they're fetched for the lifetime of the server.
+func (bm *BlocklistManager) Initialize(server *Server) {
+	bm.server = server
+	bm.lastFetch = make(map[string]time.Time)
+	go bm.run()
+}
+
+func (bm *BlocklistManager) run() {
+	for {
+		bm.update(time.Now())
+		time.Sleep(blocklistCheckInterval)
+	}
+}
+
+// update fetches every feed that's due, and lifts the bans of feeds that
+// are no longer configured.
+func (bm *BlocklistManager) update(now time.Time) {
+	bm.Lock()
+	defer bm.Unlock()
+
+	configured := make(map[string]bool)
+	for _, conf := range bm.server.Config().Blocklists {
+		configured[conf.Name] = true
+		if now.Sub(bm.lastFetch[conf.Name]) < conf.Interval {
+			continue
+		}
+		bm.lastFetch[conf.Name] = now
+		bm.refresh(conf)
+	}
+
+	for _, source := range bm.sources() {
+		if !configured[source] {
+			delete(bm.lastFetch, source)
+			bm.apply(BlocklistConfig{Name: source}, newBlocklistEntries())
+		}
+	}
+}
+
+// refresh fetches a feed and applies it.
+func (bm *BlocklistManager) refresh(conf BlocklistConfig) {
+	server := bm.server
+	data, err := fetchBlocklist(&conf)
+	if err != nil {
+		server.logger.Error("blocklist", fmt.Sprintf("Couldn't fetch blocklist %s", conf.Name), err.Error())
+		return
+	}
+	entries, invalid, err := parseBlocklist(conf.Format, data)
+	if err != nil {
+		server.logger.Error("blocklist", fmt.Sprintf("Couldn't parse blocklist %s", conf.Name), err.Error())
+		return
+	}
+	if invalid != 0 {
+		server.logger.Warning("blocklist", fmt.Sprintf("Skipped %d invalid entries in blocklist %s", invalid, conf.Name))
+	}
+	bm.apply(conf, entries)
+}
+
+// apply makes the bans managed by a feed match its entries.
+func (bm *BlocklistManager) apply(conf BlocklistConfig, entries blocklistEntries) {
+	server := bm.server
+	added, removed := syncDLines(server.dlines, conf, entries.networks)
+	kAdded, kRemoved := syncKLines(server.klines, conf, entries.masks)
+	aAdded, aRemoved := bm.syncAccounts(conf, entries.accounts)
+	added, removed = added+kAdded+aAdded, removed+kRemoved+aRemoved
+	if added == 0 && removed == 0 {
+		return
+	}
+	message := fmt.Sprintf("Blocklist %[1]s: added %[2]d and removed %[3]d bans", conf.Name, added, removed)
+	server.logger.Info("blocklist", message)
+	server.snomasks.Send(sno.LocalXline, message)
+}
+
+// blocklistBanInfo returns the ban info for an entry of a feed.
+func blocklistBanInfo(conf BlocklistConfig, reason string) IPBanInfo {
+	if reason == "" {
+		reason = conf.Reason
+	}
+	return IPBanInfo{
+		Reason:      reason,
+		OperReason:  fmt.Sprintf("Listed in the %s blocklist", conf.Name),
+		OperName:    fmt.Sprintf("blocklist:%s", conf.Name),
+		TimeCreated: time.Now(),
+		Source:      conf.Name,
+	}
+}
+
+func syncDLines(dm *DLineManager, conf BlocklistConfig, networks map[string]string) (added, removed int) {
+	current := dm.AllBans()
+	for id, info := range current {
+		if _, listed := networks[id]; info.Source == conf.Name && !listed {
+			if network, err := utils.NormalizedNetFromString(id); err == nil && dm.RemoveNetwork(network) == nil {
+				removed++
+			}
+		}
+	}
+	for id, reason := range networks {
+		// don't touch existing bans, whether they're ours or not
+		if _, exists := current[id]; exists {
+			continue
+		}
+		network, err := utils.NormalizedNetFromString(id)
+		if err == nil && dm.addNetwork(network, blocklistBanInfo(conf, reason)) == nil {
+			added++
+		}
+	}
+	return
+}
+
+func syncKLines(km *KLineManager, conf BlocklistConfig, masks map[string]string) (added, removed int) {
+	current := km.AllBans()
+	for mask, info := range current {
+		if _, listed := masks[mask]; info.Source == conf.Name && !listed {
+			if km.RemoveMask(mask) == nil {
+				removed++
+			}
+		}
+	}
+	for mask, reason := range masks {
+		if _, exists := current[mask]; exists {
+			continue
+		}
+		if km.addMask(mask, blocklistBanInfo(conf, reason)) == nil {
+			added++
+		}
+	}
+	return
+}
+
+// syncAccounts suspends the listed accounts, and unsuspends the ones that the
+// feed suspended previously but no longer lists. The accounts suspended by each
+// feed are recorded in the datastore, since suspensions don't have a source.
+func (bm *BlocklistManager) syncAccounts(conf BlocklistConfig, accounts map[string]string) (added, removed int) {
+	server := bm.server
+	key := fmt.Sprintf(keyBlocklistAccounts, conf.Name)
+	var managed []string
+	server.store.View(func(tx *buntdb.Tx) error {
+		if managedStr, _ := tx.Get(key); managedStr != "" {
+			managed = strings.Split(managedStr, ",")
+		}
+		return nil
+	})
+
+	var stillManaged []string
+	isManaged := make(map[string]bool)
+	for _, account := range managed {
+		if _, listed := accounts[account]; listed {
+			stillManaged = append(stillManaged, account)
+			isManaged[account] = true
+		} else if server.accounts.Unsuspend(account) == nil {
+			removed++
+		}
+	}
+	for account, reason := range accounts {
+		// don't take over suspensions by opers, or accounts that don't exist (yet)
+		if isManaged[account] || bm.accountSuspended(account) {
+			continue
+		}
+		if reason == "" {
+			reason = conf.Reason
+		}
+		if server.accounts.Suspend(account, reason) == nil {
+			stillManaged = append(stillManaged, account)
+			added++
+		}
+	}
+
+	server.store.Update(func(tx *buntdb.Tx) error {
+		if len(stillManaged) == 0 {
+			tx.Delete(key)
+		} else {
+			tx.Set(key, strings.Join(stillManaged, ","), nil)
+		}
+		return nil
+	})
+	return
+}
+
+func (bm *BlocklistManager) accountSuspended(account string) (suspended bool) {
+	bm.server.store.View(func(tx *buntdb.Tx) error {
+		_, err := tx.Get(fmt.Sprintf(keyAccountSuspended, account))
+		suspended = err == nil
+		return nil
+	})
+	return
+}
+
+// sources returns the names of all feeds that currently manage bans.
+func (bm *BlocklistManager) sources() (result []string) {
+	server := bm.server
+	seen := make(map[string]bool)
+	add := func(source string) {
+		if source != "" && !seen[source] {
+			seen[source] = true
+			result = append(result, source)
+		}
+	}
+	for _, info := range server.dlines.AllBans() {
+		add(info.Source)
+	}
+	for _, info := range server.klines.AllBans() {
+		add(info.Source)
+	}
+	prefix := fmt.Sprintf(keyBlocklistAccounts, "")
+	server.store.View(func(tx *buntdb.Tx) error {
+		tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			add(strings.TrimPrefix(key, prefix))
+			return true
+		})
+		return nil
+	})
+	return
+}
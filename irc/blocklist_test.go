@@ -0,0 +1,125 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestBlocklistConfig(t *testing.T) {
+	conf := BlocklistConfig{Name: "shared", URL: "https://example.com/bans.json"}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Format != blocklistFormatJSON || conf.Interval != defaultBlocklistInterval || conf.Reason == "" {
+		t.Errorf("incorrect defaults: %#v", conf)
+	}
+
+	invalid := []BlocklistConfig{
+		{URL: "https://example.com/bans.json"},
+		{Name: "shared"},
+		{Name: "shared", URL: "http://example.com/bans.json"},
+		{Name: "shared", URL: "https://example.com/bans.json", Path: "bans.json"},
+		{Name: "shared", Path: "bans.json", Format: "xml"},
+	}
+	for _, conf := range invalid {
+		if err := conf.prepare(); err == nil {
+			t.Errorf("invalid blocklist config was accepted: %#v", conf)
+		}
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	jsonFeed := `[
+		{"type": "ip", "value": "192.0.2.0/24", "reason": "spam"},
+		{"type": "IP", "value": "2001:db8::1"},
+		{"type": "mask", "value": "*@Spam.Example.Com"},
+		{"type": "account", "value": "Spammer"},
+		{"type": "ip", "value": "not an address"},
+		{"type": "something", "value": "else"}
+	]`
+	entries, invalid, err := parseBlocklist(blocklistFormatJSON, []byte(jsonFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 2 {
+		t.Errorf("expected 2 invalid entries, got %d", invalid)
+	}
+	if entries.networks["192.0.2.0/24"] != "spam" || len(entries.networks) != 2 {
+		t.Errorf("incorrect networks: %v", entries.networks)
+	}
+	if _, ok := entries.masks["*@spam.example.com"]; !ok || len(entries.masks) != 1 {
+		t.Errorf("incorrect masks: %v", entries.masks)
+	}
+	if _, ok := entries.accounts["spammer"]; !ok || len(entries.accounts) != 1 {
+		t.Errorf("incorrect accounts: %v", entries.accounts)
+	}
+
+	csvFeed := "# a comment\nip,192.0.2.1\nmask, bad!*@*, \"evil, very\"\nip\n"
+	entries, invalid, err = parseBlocklist(blocklistFormatCSV, []byte(csvFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid != 1 || len(entries.networks) != 1 || entries.masks["bad!*@*"] != "evil, very" {
+		t.Errorf("incorrect csv entries: %d %v %v", invalid, entries.networks, entries.masks)
+	}
+
+	if _, _, err := parseBlocklist(blocklistFormatJSON, []byte("{")); err == nil {
+		t.Errorf("malformed feeds should be rejected")
+	}
+}
+
+func TestSyncBlocklistBans(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := &Server{store: store}
+	dlines := NewDLineManager(server)
+	klines := NewKLineManager(server)
+	conf := BlocklistConfig{Name: "shared", Path: "bans.json"}
+	conf.prepare()
+
+	// bans set by opers are left alone
+	dlines.AddIP(net.ParseIP("192.0.2.1"), 0, "oper ban", "", "dan")
+
+	added, removed := syncDLines(dlines, conf, map[string]string{"192.0.2.1": "", "192.0.2.2": ""})
+	if added != 1 || removed != 0 {
+		t.Errorf("incorrect d-line sync: %d %d", added, removed)
+	}
+	if banned, info := dlines.CheckIP(net.ParseIP("192.0.2.2")); !banned || info.Source != "shared" || info.Reason != conf.Reason {
+		t.Errorf("blocklist d-line was not added: %#v", info)
+	}
+	added, removed = syncDLines(dlines, conf, map[string]string{})
+	if added != 0 || removed != 1 {
+		t.Errorf("incorrect d-line sync: %d %d", added, removed)
+	}
+	if banned, _ := dlines.CheckIP(net.ParseIP("192.0.2.2")); banned {
+		t.Errorf("blocklist d-line should have been removed")
+	}
+	if banned, info := dlines.CheckIP(net.ParseIP("192.0.2.1")); !banned || info.Source != "" {
+		t.Errorf("oper d-line should not have been removed")
+	}
+
+	added, removed = syncKLines(klines, conf, map[string]string{"*!*@spam.example.com": "spam"})
+	if added != 1 || removed != 0 {
+		t.Errorf("incorrect k-line sync: %d %d", added, removed)
+	}
+	if banned, info := klines.CheckMasks("bob!~bob@spam.example.com"); !banned || info.Reason != "spam" {
+		t.Errorf("blocklist k-line was not added: %#v", info)
+	}
+	// a different feed doesn't remove it
+	other := BlocklistConfig{Name: "other", Path: "other.json"}
+	other.prepare()
+	if added, removed = syncKLines(klines, other, map[string]string{}); removed != 0 {
+		t.Errorf("feeds should only remove their own bans")
+	}
+	if added, removed = syncKLines(klines, conf, map[string]string{}); removed != 1 {
+		t.Errorf("blocklist k-line should have been removed")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+const (
+	// botTag is attached to messages sent by clients with the bot user mode (+B)
+	botTag = "draft/bot"
+)
+
+// BotConfig controls who can set the bot user mode.
+type BotConfig struct {
+	// if true, only clients that are logged into accounts can set +B
+	RequireAccount bool `yaml:"require-account"`
+	// if nonempty, only clients logged into one of these accounts can set +B
+	Accounts []string
+	accounts map[string]bool
+}
+
+func (conf *BotConfig) prepare() error {
+	conf.accounts = make(map[string]bool)
+	for _, account := range conf.Accounts {
+		casefoldedAccount, err := CasefoldName(account)
+		if err != nil {
+			return fmt.Errorf("Invalid bot account name: %s", account)
+		}
+		conf.accounts[casefoldedAccount] = true
+	}
+	return nil
+}
+
+// allowed returns whether a client logged into the given (casefolded) account
+// can set the bot mode.
+func (conf *BotConfig) allowed(account string) bool {
+	if len(conf.accounts) != 0 {
+		return conf.accounts[account]
+	}
+	return account != "" || !conf.RequireAccount
+}
+
+// canSetBotMode returns whether the client can set +B on itself.
+func (client *Client) canSetBotMode() bool {
+	return client.server.AccountConfig().Bots.allowed(client.Account())
+}
+
+// messageTags returns the tags to relay along with a message from the client:
+// the client-only tags it sent, and the bot tag if it's a bot.
+func (client *Client) messageTags(clientOnlyTags map[string]string) map[string]string {
+	if !client.HasMode(modes.Bot) {
+		return clientOnlyTags
+	}
+	tags := make(map[string]string, len(clientOnlyTags)+1)
+	for name, value := range clientOnlyTags {
+		tags[name] = value
+	}
+	tags[botTag] = ""
+	return tags
+}
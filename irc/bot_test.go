@@ -0,0 +1,61 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestBotConfig(t *testing.T) {
+	var conf BotConfig
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if !conf.allowed("") || !conf.allowed("dan") {
+		t.Errorf("anyone should be able to set +B by default")
+	}
+
+	conf.RequireAccount = true
+	if conf.allowed("") || !conf.allowed("dan") {
+		t.Errorf("only logged-in clients should be able to set +B")
+	}
+
+	conf.Accounts = []string{"HelperBot"}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.allowed("") || conf.allowed("dan") || !conf.allowed("helperbot") {
+		t.Errorf("only the listed accounts should be able to set +B")
+	}
+
+	conf.Accounts = []string{"invalid name"}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("invalid account names should be rejected")
+	}
+}
+
+func TestBotMessageTags(t *testing.T) {
+	client := &Client{flags: modes.NewModeSet()}
+	clientOnlyTags := map[string]string{"+draft/react": "lol"}
+	if tags := client.messageTags(clientOnlyTags); len(tags) != 1 {
+		t.Errorf("non-bots shouldn't get the bot tag: %v", tags)
+	}
+	if tags := client.messageTags(nil); tags != nil {
+		t.Errorf("non-bots shouldn't get the bot tag: %v", tags)
+	}
+
+	client.SetMode(modes.Bot, true)
+	tags := client.messageTags(clientOnlyTags)
+	if _, ok := tags[botTag]; !ok || tags["+draft/react"] != "lol" {
+		t.Errorf("incorrect bot tags: %v", tags)
+	}
+	if _, ok := clientOnlyTags[botTag]; ok {
+		t.Errorf("the client's tags shouldn't be modified")
+	}
+	if tags := client.messageTags(nil); len(tags) != 1 {
+		t.Errorf("incorrect bot tags: %v", tags)
+	}
+}
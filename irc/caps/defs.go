@@ -7,7 +7,7 @@ package caps
 
 const (
 	// number of recognized capabilities:
-	numCapabs = 21
+	numCapabs = 26
 	// length of the uint64 array that represents the bitset:
 	bitsetLen = 1
 )
@@ -17,6 +17,10 @@ const (
 	// https://ircv3.net/specs/extensions/account-notify-3.1.html
 	AccountNotify Capability = iota
 
+	// AccountRegistration is the proposed IRCv3 capability named "draft/account-registration":
+	// https://github.com/ircv3/ircv3-specifications/pull/435
+	AccountRegistration Capability = iota
+
 	// AccountTag is the IRCv3 capability named "account-tag":
 	// https://ircv3.net/specs/extensions/account-tag-3.2.html
 	AccountTag Capability = iota
@@ -61,14 +65,30 @@ const (
 	// https://oragono.io/maxline-2
 	MaxLine Capability = iota
 
+	// MessageRedaction is the proposed IRCv3 capability named "draft/message-redaction":
+	// https://github.com/ircv3/ircv3-specifications/pull/524
+	MessageRedaction Capability = iota
+
 	// MessageTags is the IRCv3 capability named "message-tags":
 	// https://ircv3.net/specs/extensions/message-tags.html
 	MessageTags Capability = iota
 
+	// Metadata is the proposed IRCv3 capability named "draft/metadata-2":
+	// https://ircv3.net/specs/extensions/metadata
+	Metadata Capability = iota
+
 	// MultiPrefix is the IRCv3 capability named "multi-prefix":
 	// https://ircv3.net/specs/extensions/multi-prefix-3.1.html
 	MultiPrefix Capability = iota
 
+	// ReadMarker is the proposed IRCv3 capability named "draft/read-marker":
+	// https://github.com/ircv3/ircv3-specifications/pull/489
+	ReadMarker Capability = iota
+
+	// Relaymsg is the proposed IRCv3 capability named "draft/relaymsg":
+	// https://github.com/ircv3/ircv3-specifications/pull/417
+	Relaymsg Capability = iota
+
 	// Rename is the proposed IRCv3 capability named "draft/rename":
 	// https://github.com/SaberUK/ircv3-specifications/blob/rename/extensions/rename.md
 	Rename Capability = iota
@@ -102,6 +122,7 @@ const (
 var (
 	capabilityNames = [numCapabs]string{
 		"account-notify",
+		"draft/account-registration",
 		"account-tag",
 		"away-notify",
 		"batch",
@@ -113,8 +134,12 @@ var (
 		"draft/labeled-response",
 		"draft/languages",
 		"oragono.io/maxline-2",
+		"draft/message-redaction",
 		"message-tags",
+		"draft/metadata-2",
 		"multi-prefix",
+		"draft/read-marker",
+		"draft/relaymsg",
 		"draft/rename",
 		"draft/resume-0.3",
 		"sasl",
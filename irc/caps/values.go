@@ -3,11 +3,11 @@
 
 package caps
 
-import "sync"
+import "github.com/oragono/oragono/irc/lockorder"
 
 // Values holds capability values.
 type Values struct {
-	sync.RWMutex
+	lockorder.Tier0RWMutex
 	// values holds our actual capability values.
 	values map[Capability]string
 }
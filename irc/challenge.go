@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+const (
+	// length of the random challenge for CHALLENGE, in bytes
+	operChallengeLen = 32
+	// line length for the encrypted challenge in RPL_RSACHALLENGE2
+	operChallengeLineLen = 60
+)
+
+// operChallenge is a CHALLENGE in progress, awaiting the client's response.
+type operChallenge struct {
+	operName string
+	response string // expected response: base64 of the SHA-1 hash of the challenge
+}
+
+// CanOperFrom returns whether the client satisfies the oper block's
+// restrictions on TLS client certificate and source hostmask.
+func (oper *Oper) CanOperFrom(client *Client) bool {
+	if oper.Fingerprint != "" && oper.Fingerprint != client.certfp {
+		return false
+	}
+	if oper.Hosts != nil {
+		for _, mask := range client.AllNickmasks() {
+			if oper.Hosts.Match(mask) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// startOperChallenge generates a new challenge for the given oper block, and returns
+// it encrypted with the oper's public key, base64-encoded and split into lines.
+func (client *Client) startOperChallenge(oper *Oper) (lines []string, err error) {
+	challenge := make([]byte, operChallengeLen)
+	if _, err = rand.Read(challenge); err != nil {
+		return
+	}
+	encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, oper.PublicKey, challenge, nil)
+	if err != nil {
+		return
+	}
+	digest := sha1.Sum(challenge)
+	client.operChallenge = &operChallenge{
+		operName: oper.Name,
+		response: base64.StdEncoding.EncodeToString(digest[:]),
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(encrypted)
+	for operChallengeLineLen < len(encoded) {
+		lines = append(lines, encoded[:operChallengeLineLen])
+		encoded = encoded[operChallengeLineLen:]
+	}
+	lines = append(lines, encoded)
+	return
+}
+
+// finishOperChallenge checks the client's response to the pending challenge (if any),
+// returning the name of the oper block it was for. Each challenge can only be tried once.
+func (client *Client) finishOperChallenge(response string) (operName string, success bool) {
+	challenge := client.operChallenge
+	client.operChallenge = nil
+	if challenge == nil || !utils.SecretTokensMatch(challenge.response, response) {
+		return "", false
+	}
+	return challenge.operName, true
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestOperChallenge(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oper := &Oper{Name: "dan", PublicKey: &key.PublicKey}
+	client := new(Client)
+
+	lines, err := client.startOperChallenge(oper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, encrypted, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha1.Sum(challenge)
+	response := base64.StdEncoding.EncodeToString(digest[:])
+
+	if _, ok := client.finishOperChallenge("garbage"); ok {
+		t.Errorf("incorrect response was accepted")
+	}
+	// a failed attempt consumes the challenge
+	if _, ok := client.finishOperChallenge(response); ok {
+		t.Errorf("challenge could be retried")
+	}
+
+	lines, _ = client.startOperChallenge(oper)
+	encrypted, _ = base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	challenge, _ = rsa.DecryptOAEP(sha1.New(), rand.Reader, key, encrypted, nil)
+	digest = sha1.Sum(challenge)
+	operName, ok := client.finishOperChallenge(base64.StdEncoding.EncodeToString(digest[:]))
+	if !ok || operName != "dan" {
+		t.Errorf("correct response was rejected")
+	}
+}
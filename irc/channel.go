@@ -6,41 +6,52 @@
 package irc
 
 import (
-	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
-	"sync"
-
 	"github.com/oragono/oragono/irc/caps"
 	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/modes"
 	"github.com/oragono/oragono/irc/utils"
 )
 
 // Channel represents a channel that clients can join.
 type Channel struct {
-	flags             *modes.ModeSet
-	lists             map[modes.Mode]*UserMaskSet
-	key               string
-	members           MemberSet
-	membersCache      []*Client // allow iteration over channel members without holding the lock
-	name              string
-	nameCasefolded    string
-	server            *Server
-	createdTime       time.Time
-	registeredFounder string
-	registeredTime    time.Time
-	stateMutex        sync.RWMutex // tier 1
-	joinPartMutex     sync.Mutex   // tier 3
-	topic             string
-	topicSetBy        string
-	topicSetTime      time.Time
-	userLimit         int
-	accountToUMode    map[string]modes.Mode
-	history           history.Buffer
+	flags               *modes.ModeSet
+	lists               map[modes.Mode]*UserMaskSet
+	key                 string
+	members             MemberSet
+	name                string
+	nameCasefolded      string
+	server              *Server
+	createdTime         time.Time
+	registeredFounder   string
+	registeredSuccessor string
+	registeredTime      time.Time
+	stateMutex          lockorder.Tier1RWMutex
+	joinPartMutex       lockorder.Tier3Mutex
+	topic               string
+	topicSetBy          string
+	topicSetTime        time.Time
+	topicHistory        []TopicHistoryItem
+	topicLock           bool
+	userLimit           int
+	accountToUMode      map[string]modes.Mode
+	entryMsg            string
+	ctcpPolicy          string
+	exitMessagePolicy   ExitMessagePolicy
+	linkPolicy          LinkPolicy
+	linkStates          map[*Client]*memberLinkState
+	metadata            map[string]string
+	tags                []string
+	verification        ChannelVerification
+	joinFloodSettings   JoinFloodSettings
+	joinFlood           joinFloodState
+	lastKnock           time.Time
+	history             history.Buffer
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
@@ -60,11 +71,11 @@ func NewChannel(s *Server, name string, regInfo *RegisteredChannel) *Channel {
 			modes.ExceptMask: NewUserMaskSet(),
 			modes.InviteMask: NewUserMaskSet(),
 		},
-		members:        make(MemberSet),
 		name:           name,
 		nameCasefolded: casefoldedName,
 		server:         s,
 		accountToUMode: make(map[string]modes.Mode),
+		linkStates:     make(map[*Client]*memberLinkState),
 	}
 
 	config := s.Config()
@@ -85,13 +96,24 @@ func NewChannel(s *Server, name string, regInfo *RegisteredChannel) *Channel {
 // read in channel state that was persisted in the DB
 func (channel *Channel) applyRegInfo(chanReg *RegisteredChannel) {
 	channel.registeredFounder = chanReg.Founder
+	channel.registeredSuccessor = chanReg.Successor
 	channel.registeredTime = chanReg.RegisteredAt
 	channel.topic = chanReg.Topic
 	channel.topicSetBy = chanReg.TopicSetBy
 	channel.topicSetTime = chanReg.TopicSetTime
+	channel.topicHistory = chanReg.TopicHistory
+	channel.topicLock = chanReg.TopicLock
 	channel.name = chanReg.Name
 	channel.createdTime = chanReg.RegisteredAt
 	channel.key = chanReg.Key
+	channel.entryMsg = chanReg.EntryMsg
+	channel.ctcpPolicy = chanReg.CTCPPolicy
+	channel.exitMessagePolicy = chanReg.ExitMessagePolicy
+	channel.linkPolicy = chanReg.LinkPolicy
+	channel.metadata = chanReg.Metadata
+	channel.tags = chanReg.Tags
+	channel.verification = chanReg.Verification
+	channel.joinFloodSettings = chanReg.JoinFlood
 
 	for _, mode := range chanReg.Modes {
 		channel.flags.SetMode(mode, true)
@@ -117,12 +139,15 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 
 	info.Name = channel.name
 	info.Founder = channel.registeredFounder
+	info.Successor = channel.registeredSuccessor
 	info.RegisteredAt = channel.registeredTime
 
 	if includeFlags&IncludeTopic != 0 {
 		info.Topic = channel.topic
 		info.TopicSetBy = channel.topicSetBy
 		info.TopicSetTime = channel.topicSetTime
+		info.TopicHistory = make([]TopicHistoryItem, len(channel.topicHistory))
+		copy(info.TopicHistory, channel.topicHistory)
 	}
 
 	if includeFlags&IncludeModes != 0 {
@@ -130,6 +155,18 @@ func (channel *Channel) ExportRegistration(includeFlags uint) (info RegisteredCh
 		info.Modes = channel.flags.AllModes()
 	}
 
+	if includeFlags&IncludeSettings != 0 {
+		info.EntryMsg = channel.entryMsg
+		info.CTCPPolicy = channel.ctcpPolicy
+		info.ExitMessagePolicy = channel.exitMessagePolicy
+		info.LinkPolicy = channel.linkPolicy
+		info.Metadata = copyMetadata(channel.metadata)
+		info.Tags = channel.tags
+		info.Verification = channel.verification
+		info.TopicLock = channel.topicLock
+		info.JoinFlood = channel.joinFloodSettings
+	}
+
 	if includeFlags&IncludeLists != 0 {
 		for mask := range channel.lists[modes.BanMask].masks {
 			info.Banlist = append(info.Banlist, mask)
@@ -172,11 +209,32 @@ func (channel *Channel) SetUnregistered(expectedFounder string) {
 		return
 	}
 	channel.registeredFounder = ""
+	channel.registeredSuccessor = ""
 	var zeroTime time.Time
 	channel.registeredTime = zeroTime
 	channel.accountToUMode = make(map[string]modes.Mode)
 }
 
+// SetSuccessor sets (or, if `successor` is empty, clears) the account that will
+// become the founder if the founder's account is unregistered. Only the founder
+// can choose their successor.
+func (channel *Channel) SetSuccessor(client *Client, successor string) error {
+	account := client.Account()
+	isOperChange := client.HasRoleCapabs("chanreg")
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	if !isOperChange && (account == "" || account != channel.registeredFounder) {
+		return errInsufficientPrivs
+	}
+	if successor != "" && successor == channel.registeredFounder {
+		return errInvalidParams
+	}
+	channel.registeredSuccessor = successor
+	return nil
+}
+
 // IsRegistered returns whether the channel is registered.
 func (channel *Channel) IsRegistered() bool {
 	channel.stateMutex.RLock()
@@ -184,63 +242,36 @@ func (channel *Channel) IsRegistered() bool {
 	return channel.registeredFounder != ""
 }
 
-func (channel *Channel) regenerateMembersCache() {
-	channel.stateMutex.RLock()
-	result := make([]*Client, len(channel.members))
-	i := 0
-	for client := range channel.members {
-		result[i] = client
-		i++
-	}
-	channel.stateMutex.RUnlock()
-
-	channel.stateMutex.Lock()
-	channel.membersCache = result
-	channel.stateMutex.Unlock()
-}
-
 // Names sends the list of users joined to the channel to the given client.
 func (channel *Channel) Names(client *Client, rb *ResponseBuffer) {
 	isMultiPrefix := client.capabilities.Has(caps.MultiPrefix)
 	isUserhostInNames := client.capabilities.Has(caps.UserhostInNames)
 
-	maxNamLen := 480 - len(client.server.name) - len(client.Nick())
-	var namesLines []string
-	var buffer bytes.Buffer
-	for _, target := range channel.Members() {
-		var nick string
+	members := channel.members.SortedMembers()
+	names := make([]string, len(members))
+	for i, member := range members {
+		prefixes := member.prefixes
+		if !isMultiPrefix && 1 < len(prefixes) {
+			prefixes = prefixes[:1]
+		}
 		if isUserhostInNames {
-			nick = target.NickMaskString()
+			names[i] = prefixes + member.client.NickMaskString()
 		} else {
-			nick = target.Nick()
+			names[i] = prefixes + member.client.Nick()
 		}
-		channel.stateMutex.RLock()
-		modes := channel.members[target]
-		channel.stateMutex.RUnlock()
-		if modes == nil {
-			continue
-		}
-		prefix := modes.Prefixes(isMultiPrefix)
-		if buffer.Len()+len(nick)+len(prefix)+1 > maxNamLen {
-			namesLines = append(namesLines, buffer.String())
-			buffer.Reset()
-		}
-		if buffer.Len() > 0 {
-			buffer.WriteString(" ")
-		}
-		buffer.WriteString(prefix)
-		buffer.WriteString(nick)
-	}
-	if buffer.Len() > 0 {
-		namesLines = append(namesLines, buffer.String())
 	}
 
-	for _, line := range namesLines {
-		if buffer.Len() > 0 {
-			rb.Add(nil, client.server.name, RPL_NAMREPLY, client.nick, "=", channel.name, line)
-		}
+	symbol := "="
+	if channel.flags.HasMode(modes.Secret) {
+		symbol = "@"
 	}
-	rb.Add(nil, client.server.name, RPL_ENDOFNAMES, client.nick, channel.name, client.t("End of NAMES list"))
+	nick := client.Nick()
+	// everything but the names: `:server 353 nick = #channel :` and the trailing \r\n
+	maxNamLen := 512 - len(client.server.name) - len(nick) - len(symbol) - len(channel.name) - len(": 353    :\r\n")
+	for _, line := range utils.BuildTokenLines(maxNamLen, names, " ") {
+		rb.Add(nil, client.server.name, RPL_NAMREPLY, nick, symbol, channel.name, line)
+	}
+	rb.Add(nil, client.server.name, RPL_ENDOFNAMES, nick, channel.name, client.t("End of NAMES list"))
 }
 
 func channelUserModeIsAtLeast(clientModes *modes.ModeSet, permission modes.Mode) bool {
@@ -263,28 +294,16 @@ func channelUserModeIsAtLeast(clientModes *modes.ModeSet, permission modes.Mode)
 
 // ClientIsAtLeast returns whether the client has at least the given channel privilege.
 func (channel *Channel) ClientIsAtLeast(client *Client, permission modes.Mode) bool {
-	channel.stateMutex.RLock()
-	clientModes := channel.members[client]
-	channel.stateMutex.RUnlock()
-	return channelUserModeIsAtLeast(clientModes, permission)
+	return channelUserModeIsAtLeast(channel.members.Get(client), permission)
 }
 
 func (channel *Channel) ClientPrefixes(client *Client, isMultiPrefix bool) string {
-	channel.stateMutex.RLock()
-	defer channel.stateMutex.RUnlock()
-	modes, present := channel.members[client]
-	if !present {
-		return ""
-	} else {
-		return modes.Prefixes(isMultiPrefix)
-	}
+	return channel.members.Get(client).Prefixes(isMultiPrefix)
 }
 
 func (channel *Channel) ClientHasPrivsOver(client *Client, target *Client) bool {
-	channel.stateMutex.RLock()
-	clientModes := channel.members[client]
-	targetModes := channel.members[target]
-	channel.stateMutex.RUnlock()
+	clientModes := channel.members.Get(client)
+	targetModes := channel.members.Get(target)
 
 	if clientModes.HasMode(modes.ChannelFounder) {
 		// founder can kick anyone
@@ -305,10 +324,7 @@ func (channel *Channel) ClientHasPrivsOver(client *Client, target *Client) bool
 }
 
 func (channel *Channel) hasClient(client *Client) bool {
-	channel.stateMutex.RLock()
-	defer channel.stateMutex.RUnlock()
-	_, present := channel.members[client]
-	return present
+	return channel.members.Has(client)
 }
 
 // <mode> <mode params>
@@ -347,14 +363,13 @@ func (channel *Channel) modeStrings(client *Client) (result []string) {
 }
 
 func (channel *Channel) IsEmpty() bool {
-	channel.stateMutex.RLock()
-	defer channel.stateMutex.RUnlock()
-	return len(channel.members) == 0
+	return channel.members.Len() == 0
 }
 
 // Join joins the given client to this channel (if they can be joined).
 func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *ResponseBuffer) {
 	details := client.Details()
+	config := client.server.Config()
 
 	channel.stateMutex.RLock()
 	chname := channel.name
@@ -362,10 +377,10 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 	founder := channel.registeredFounder
 	chkey := channel.key
 	limit := channel.userLimit
-	chcount := len(channel.members)
-	_, alreadyJoined := channel.members[client]
-	persistentMode := channel.accountToUMode[details.account]
+	persistentMode := config.effectiveStatusMode(channel.accountToUMode[details.account])
 	channel.stateMutex.RUnlock()
+	chcount := channel.members.Len()
+	alreadyJoined := channel.members.Has(client)
 
 	if alreadyJoined {
 		// no message needs to be sent
@@ -386,19 +401,44 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		return
 	}
 
-	isInvited := client.CheckInvited(chcfname) || channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded)
+	hasInvite := client.CheckInvited(chcfname)
+	isInvited := hasInvite || channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded)
 	if !hasPrivs && channel.flags.HasMode(modes.InviteOnly) && !isInvited {
 		rb.Add(nil, client.server.name, ERR_INVITEONLYCHAN, chname, fmt.Sprintf(client.t("Cannot join channel (+%s)"), "i"))
 		return
 	}
 
+	if !isSajoin && channel.flags.HasMode(modes.SecureOnly) && !client.HasMode(modes.TLS) {
+		rb.Add(nil, client.server.name, ERR_SECUREONLYCHAN, details.nick, chname, fmt.Sprintf(client.t("Cannot join channel (+%s) - you must be connected via TLS"), "z"))
+		return
+	}
+
+	// an actual invitation also overrides +R
+	if !hasPrivs && !hasInvite && channel.flags.HasMode(modes.RegisteredOnly) && details.account == "" {
+		rb.Add(nil, client.server.name, ERR_NEEDREGGEDNICK, details.nick, chname, fmt.Sprintf(client.t("Cannot join channel (+%s) - you must be logged into an account"), "R"))
+		nsLoginHint(client, rb)
+		return
+	}
+
+	// invite exceptions (+I) only override invite-only, but an actual invitation
+	// also overrides bans
 	if !hasPrivs && channel.lists[modes.BanMask].Match(details.nickMaskCasefolded) &&
-		!isInvited &&
+		!hasInvite &&
 		!channel.lists[modes.ExceptMask].Match(details.nickMaskCasefolded) {
 		rb.Add(nil, client.server.name, ERR_BANNEDFROMCHAN, chname, fmt.Sprintf(client.t("Cannot join channel (+%s)"), "b"))
 		return
 	}
 
+	if !hasPrivs && !isInvited && !channel.checkJoinFlood(client, rb) {
+		return
+	}
+
+	// check the DEFCON throttle last, so that joins refused above don't use it up
+	if !isSajoin && client.Oper() == nil && !client.server.defcon.AllowJoin() {
+		rb.Add(nil, client.server.name, ERR_UNAVAILRESOURCE, details.nick, chname, client.t("Joins are temporarily throttled, please try again later"))
+		return
+	}
+
 	client.server.logger.Debug("join", fmt.Sprintf("%s joined channel %s", details.nick, chname))
 
 	givenMode := func() (givenMode modes.Mode) {
@@ -409,8 +449,8 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 			channel.stateMutex.Lock()
 			defer channel.stateMutex.Unlock()
 
-			channel.members.Add(client)
-			firstJoin := len(channel.members) == 1
+			firstJoin := channel.members.Add(client) == 1
+			channel.linkStates[client] = &memberLinkState{joined: time.Now()}
 			newChannel := firstJoin && channel.registeredFounder == ""
 			if newChannel {
 				givenMode = modes.ChannelOperator
@@ -418,12 +458,10 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 				givenMode = persistentMode
 			}
 			if givenMode != 0 {
-				channel.members[client].SetMode(givenMode, true)
+				channel.members.SetMode(client, givenMode, true)
 			}
 		}()
 
-		channel.regenerateMembersCache()
-
 		message := utils.SplitMessage{}
 		message.Msgid = details.realname
 		channel.history.Add(history.Item{
@@ -465,14 +503,32 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 
 	channel.SendTopic(client, rb, false)
 
+	channel.sendMetadata(client, rb)
+
+	if details.account != "" && client.capabilities.Has(caps.ReadMarker) {
+		sendReadMarker(client, chname, client.server.accounts.ReadMarker(details.account, sharedReadMarkers, chcfname), rb)
+	}
+
 	channel.Names(client, rb)
 
+	channel.sendEntryMsg(client, rb)
+
 	// TODO #259 can be implemented as Flush(false) (i.e., nonblocking) while holding joinPartMutex
 	rb.Flush(true)
 
 	replayLimit := channel.server.Config().History.AutoreplayOnJoin
 	if replayLimit > 0 {
-		items := channel.history.Latest(replayLimit)
+		// replay only what this device hasn't seen, if we know that
+		var replayAfter time.Time
+		if details.account != "" {
+			replayAfter = client.server.accounts.ReadMarker(details.account, client.DeviceID(), chcfname)
+		}
+		var items []history.Item
+		if replayAfter.IsZero() {
+			items = channel.history.Latest(replayLimit)
+		} else {
+			items, _ = channel.history.Between(replayAfter, time.Time{}, false, replayLimit)
+		}
 		channel.replayHistoryItems(rb, items)
 		rb.Flush(true)
 	}
@@ -488,6 +544,7 @@ func (channel *Channel) Part(client *Client, message string, rb *ResponseBuffer)
 
 	channel.Quit(client)
 
+	message = channel.filterExitMessage(message)
 	details := client.Details()
 	for _, member := range channel.Members() {
 		member.Send(nil, details.nickMask, "PART", chname, message)
@@ -498,7 +555,7 @@ func (channel *Channel) Part(client *Client, message string, rb *ResponseBuffer)
 		Type:        history.Part,
 		Nick:        details.nickMask,
 		AccountName: details.accountName,
-		Message:     utils.MakeSplitMessage(message, true),
+		Message:     utils.MakeSplitMessage(message),
 	})
 
 	client.server.logger.Debug("part", fmt.Sprintf("%s left channel %s", details.nick, chname))
@@ -508,33 +565,24 @@ func (channel *Channel) Part(client *Client, message string, rb *ResponseBuffer)
 // 1. Replace the old client with the new in the channel's data structures
 // 2. Send JOIN and MODE lines to channel participants (including the new client)
 // 3. Replay missed message history to the client
-func (channel *Channel) Resume(newClient, oldClient *Client, timestamp time.Time) {
-	now := time.Now()
-	channel.resumeAndAnnounce(newClient, oldClient)
-	if !timestamp.IsZero() {
-		channel.replayHistoryForResume(newClient, timestamp, now)
-	}
-}
-
-func (channel *Channel) resumeAndAnnounce(newClient, oldClient *Client) {
+// Resume replaces oldClient with newClient in the channel, announcing this to the
+// other members and sending the channel's state to newClient via `rb`.
+func (channel *Channel) Resume(newClient, oldClient *Client, deferNames bool, rb *ResponseBuffer) {
 	var oldModeSet *modes.ModeSet
 
 	func() {
 		channel.joinPartMutex.Lock()
 		defer channel.joinPartMutex.Unlock()
 
-		defer channel.regenerateMembersCache()
-
 		channel.stateMutex.Lock()
 		defer channel.stateMutex.Unlock()
 
 		newClient.channels[channel] = true
-		oldModeSet = channel.members[oldClient]
-		if oldModeSet == nil {
-			oldModeSet = modes.NewModeSet()
+		oldModeSet = channel.members.Replace(oldClient, newClient)
+		if state := channel.linkStates[oldClient]; state != nil {
+			delete(channel.linkStates, oldClient)
+			channel.linkStates[newClient] = state
 		}
-		channel.members.Remove(oldClient)
-		channel.members[newClient] = oldModeSet
 	}()
 
 	// construct fake modestring if necessary
@@ -564,19 +612,18 @@ func (channel *Channel) resumeAndAnnounce(newClient, oldClient *Client) {
 		}
 	}
 
-	rb := NewResponseBuffer(newClient)
-	// use blocking i/o to synchronize with the later history replay
 	if newClient.capabilities.Has(caps.ExtendedJoin) {
 		rb.Add(nil, nickMask, "JOIN", channel.name, accountName, realName)
 	} else {
 		rb.Add(nil, nickMask, "JOIN", channel.name)
 	}
 	channel.SendTopic(newClient, rb, false)
-	channel.Names(newClient, rb)
+	if !deferNames {
+		channel.Names(newClient, rb)
+	}
 	if 0 < len(oldModes) {
 		rb.Add(nil, newClient.server.name, "MODE", channel.name, oldModes, nick)
 	}
-	rb.Send(true)
 }
 
 func (channel *Channel) replayHistoryForResume(newClient *Client, after time.Time, before time.Time) {
@@ -585,7 +632,7 @@ func (channel *Channel) replayHistoryForResume(newClient *Client, after time.Tim
 	channel.replayHistoryItems(rb, items)
 	if !complete && !newClient.resumeDetails.HistoryIncomplete {
 		// warn here if we didn't warn already
-		rb.Add(nil, "HistServ", "NOTICE", channel.Name(), newClient.t("Some additional message history may have been lost"))
+		rb.AddSplitMessageFromServer("HistServ", "NOTICE", channel.Name(), utils.MakeSplitMessage(newClient.t("Some additional message history may have been lost")))
 	}
 	rb.Send(true)
 }
@@ -674,7 +721,7 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 		return
 	}
 
-	if channel.flags.HasMode(modes.OpOnlyTopic) && !channel.ClientIsAtLeast(client, modes.ChannelOperator) {
+	if channel.flags.HasMode(modes.OpOnlyTopic) && !channel.ClientIsAtLeast(client, modes.Halfop) {
 		rb.Add(nil, client.server.name, ERR_CHANOPRIVSNEEDED, channel.name, client.t("You're not a channel operator"))
 		return
 	}
@@ -685,9 +732,16 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 	}
 
 	channel.stateMutex.Lock()
+	previous := TopicHistoryItem{
+		Topic:   channel.topic,
+		SetBy:   channel.topicSetBy,
+		SetTime: channel.topicSetTime,
+	}
 	channel.topic = topic
 	channel.topicSetBy = client.nickMaskString
 	channel.topicSetTime = time.Now()
+	channel.recordTopic()
+	topicLock := channel.topicLock
 	channel.stateMutex.Unlock()
 
 	for _, member := range channel.Members() {
@@ -698,16 +752,16 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 		}
 	}
 
+	if topicLock && !channel.hasTopicAccess(client) {
+		channel.restoreTopic(previous)
+	}
+
 	go channel.server.channelRegistry.StoreChannel(channel, IncludeTopic)
 }
 
 // CanSpeak returns true if the client can speak on this channel.
 func (channel *Channel) CanSpeak(client *Client) bool {
-	channel.stateMutex.RLock()
-	defer channel.stateMutex.RUnlock()
-
-	_, hasClient := channel.members[client]
-	if channel.flags.HasMode(modes.NoOutside) && !hasClient {
+	if channel.flags.HasMode(modes.NoOutside) && !channel.hasClient(client) {
 		return false
 	}
 	if channel.flags.HasMode(modes.Moderated) && !channel.ClientIsAtLeast(client, modes.Voice) {
@@ -786,6 +840,9 @@ func (channel *Channel) SendSplitMessage(command string, minPrefix *modes.Mode,
 		}
 	}
 
+	if !client.storesHistory(clientOnlyTags) {
+		return
+	}
 	channel.history.Add(history.Item{
 		Type:        histType,
 		Message:     message,
@@ -803,18 +860,16 @@ func (channel *Channel) applyModeToMember(client *Client, mode modes.Mode, op mo
 		return nil
 	}
 
-	channel.stateMutex.Lock()
-	modeset, exists := channel.members[target]
-	if exists {
-		if modeset.SetMode(mode, op == modes.Add) {
-			result = &modes.ModeChange{
-				Op:   op,
-				Mode: mode,
-				Arg:  nick,
-			}
+	// e.g., founders get +o instead of +q if it's disabled
+	mode = channel.server.Config().effectiveStatusMode(mode)
+	exists, changed := channel.members.SetMode(target, mode, op == modes.Add)
+	if changed {
+		result = &modes.ModeChange{
+			Op:   op,
+			Mode: mode,
+			Arg:  nick,
 		}
 	}
-	channel.stateMutex.Unlock()
 
 	if !exists {
 		rb.Add(nil, client.server.name, ERR_USERNOTINCHANNEL, client.Nick(), channel.Name(), client.t("They aren't on that channel"))
@@ -883,10 +938,9 @@ func (channel *Channel) Quit(client *Client) {
 		defer channel.joinPartMutex.Unlock()
 
 		channel.stateMutex.Lock()
-		channel.members.Remove(client)
-		channelEmpty := len(channel.members) == 0
+		channelEmpty := channel.members.Remove(client) == 0
+		delete(channel.linkStates, client)
 		channel.stateMutex.Unlock()
-		channel.regenerateMembersCache()
 		return channelEmpty
 	}()
 
@@ -910,12 +964,20 @@ func (channel *Channel) Kick(client *Client, target *Client, comment string, rb
 		return
 	}
 
-	kicklimit := client.server.Limits().KickLen
+	comment = channel.filterExitMessage(comment)
+	if comment == "" {
+		comment = target.Nick()
+	}
+	channel.kick(client.NickMaskString(), target, comment)
+}
+
+// kick removes the target from the channel, without checking privileges.
+func (channel *Channel) kick(clientMask string, target *Client, comment string) {
+	kicklimit := channel.server.Limits().KickLen
 	if len(comment) > kicklimit {
 		comment = comment[:kicklimit]
 	}
 
-	clientMask := client.NickMaskString()
 	targetNick := target.Nick()
 	for _, member := range channel.Members() {
 		member.Send(nil, clientMask, "KICK", channel.name, targetNick, comment)
@@ -934,6 +996,62 @@ func (channel *Channel) Kick(client *Client, target *Client, comment string, rb
 	channel.Quit(target)
 }
 
+// KickInsecure kicks all members who aren't connected via TLS;
+// this is used when the channel is made secure-only (+z).
+func (channel *Channel) KickInsecure() {
+	for _, member := range channel.Members() {
+		if !member.HasMode(modes.TLS) {
+			channel.kick(channel.server.name, member, "This channel is now secure-only (TLS required)")
+		}
+	}
+}
+
+// recoveryChanges returns what has to be undone to recover a channel that was
+// taken over: the mode changes that remove the modes that lock people out of
+// it (invite-only, key, user limit, moderation) and the bans matching the
+// founder, and the members holding privileges that their persistent modes
+// don't entitle them to.
+func (channel *Channel) recoveryChanges(founder *Client) (changes modes.ModeChanges, imposters []*Client) {
+	channel.stateMutex.RLock()
+	key := channel.key
+	limit := channel.userLimit
+	accountToUMode := make(map[string]modes.Mode, len(channel.accountToUMode))
+	for account, mode := range channel.accountToUMode {
+		accountToUMode[account] = mode
+	}
+	channel.stateMutex.RUnlock()
+
+	for _, mode := range []modes.Mode{modes.InviteOnly, modes.Moderated} {
+		if channel.flags.HasMode(mode) {
+			changes = append(changes, modes.ModeChange{Op: modes.Remove, Mode: mode})
+		}
+	}
+	if key != "" {
+		changes = append(changes, modes.ModeChange{Op: modes.Remove, Mode: modes.Key, Arg: "*"})
+	}
+	if limit != 0 {
+		changes = append(changes, modes.ModeChange{Op: modes.Remove, Mode: modes.UserLimit})
+	}
+	for _, mask := range channel.lists[modes.BanMask].Matching(founder.NickMaskCasefolded()) {
+		changes = append(changes, modes.ModeChange{Op: modes.Remove, Mode: modes.BanMask, Arg: mask})
+	}
+
+	for _, member := range channel.Members() {
+		if member == founder {
+			continue
+		}
+		modeSet := channel.members.Get(member)
+		entitled := accountToUMode[member.Account()]
+		for _, mode := range []modes.Mode{modes.ChannelFounder, modes.ChannelAdmin, modes.ChannelOperator, modes.Halfop} {
+			if modeSet.HasMode(mode) && umodeGreaterThan(mode, entitled) {
+				imposters = append(imposters, member)
+				break
+			}
+		}
+	}
+	return
+}
+
 // Invite invites the given client to the channel, if the inviter can do so.
 func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuffer) {
 	chname := channel.Name()
@@ -947,7 +1065,14 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuf
 		return
 	}
 
-	if channel.flags.HasMode(modes.InviteOnly) {
+	if channel.hasClient(invitee) {
+		rb.Add(nil, inviter.server.name, ERR_USERONCHANNEL, inviter.Nick(), invitee.Nick(), chname, inviter.t("User is already on that channel"))
+		return
+	}
+
+	// an invitation from a channel operator also lets the invitee past +R
+	if channel.flags.HasMode(modes.InviteOnly) ||
+		(channel.flags.HasMode(modes.RegisteredOnly) && channel.ClientIsAtLeast(inviter, modes.ChannelOperator)) {
 		invitee.Invite(channel.NameCasefolded())
 	}
 
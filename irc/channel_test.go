@@ -0,0 +1,71 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegisteredOnlyChannel(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	if err := h.server.accounts.Register(nil, "carol", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.accounts.Verify(nil, "carol", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := h.Register("alice")
+	alice.Send("JOIN", "#chan")
+	alice.Expect(RPL_ENDOFNAMES)
+	alice.Send("MODE", "#chan", "+R")
+	alice.Expect("MODE")
+
+	// users who aren't logged in can't join, and are told how to log in
+	bob := h.Register("bob")
+	bob.Send("JOIN", "#chan")
+	if msg := bob.Expect(ERR_NEEDREGGEDNICK); msg.Params[1] != "#chan" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+	if msg := bob.Expect("NOTICE"); !strings.HasPrefix(msg.Prefix, "NickServ") || !strings.Contains(msg.Params[1], "IDENTIFY") {
+		t.Errorf("expected a login hint: %v", msg)
+	}
+
+	// users who are logged in can join and speak
+	carol := h.Register("carol")
+	carol.Send("NS", "IDENTIFY", "carol", "hunter2")
+	if msg := carol.Expect("NOTICE"); !strings.Contains(msg.Params[1], "now logged in") {
+		t.Fatalf("couldn't log in: %v", msg)
+	}
+	carol.Send("JOIN", "#chan")
+	carol.Expect(RPL_ENDOFNAMES)
+	carol.Send("PRIVMSG", "#chan", "hi")
+	if msg := alice.Expect("PRIVMSG"); !strings.HasPrefix(msg.Prefix, "carol!") {
+		t.Errorf("unexpected message: %v", msg)
+	}
+
+	// an invitation from a channel operator overrides +R
+	alice.Send("INVITE", "bob", "#chan")
+	bob.Expect("INVITE")
+	bob.Send("JOIN", "#chan")
+	bob.Expect(RPL_ENDOFNAMES)
+	// but users who aren't logged in still can't speak
+	bob.Send("PRIVMSG", "#chan", "hi")
+	bob.Expect(ERR_CANNOTSENDTOCHAN)
+	bob.Send("PART", "#chan")
+	bob.Expect("PART")
+
+	// as does an oper's SAJOIN
+	staff := h.Register("staff")
+	oper := h.server.Config().operators["dan"]
+	sStaff := h.server.clients.Get("staff")
+	sStaff.stateMutex.Lock()
+	sStaff.oper = oper
+	sStaff.stateMutex.Unlock()
+	staff.Send("SAJOIN", "bob", "#chan")
+	bob.Expect(RPL_ENDOFNAMES)
+}
@@ -4,7 +4,8 @@
 package irc
 
 import (
-	"sync"
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/modes"
 )
 
 type channelManagerEntry struct {
@@ -19,14 +20,18 @@ type channelManagerEntry struct {
 // providing synchronization for creation of new channels on first join,
 // cleanup of empty channels on last part, and renames.
 type ChannelManager struct {
-	sync.RWMutex // tier 2
-	chans        map[string]*channelManagerEntry
+	lockorder.Tier2RWMutex
+	chans map[string]*channelManagerEntry
+	// maps the skeleton of each channel name to the casefolded name,
+	// so that confusable channel names can be prevented
+	chansSkeletons map[string]string
 }
 
 // NewChannelManager returns a new ChannelManager.
 func NewChannelManager() *ChannelManager {
 	return &ChannelManager{
-		chans: make(map[string]*channelManagerEntry),
+		chans:          make(map[string]*channelManagerEntry),
+		chansSkeletons: make(map[string]string),
 	}
 }
 
@@ -51,6 +56,13 @@ func (cm *ChannelManager) Join(client *Client, name string, key string, isSajoin
 	if err != nil || len(casefoldedName) > server.Limits().ChannelLen {
 		return errNoSuchChannel
 	}
+	skeleton, err := ChannelSkeleton(name)
+	if err != nil {
+		return errNoSuchChannel
+	}
+	if !isSajoin && !(client.apiTokenAllows(apiTokenCapJoin) && client.apiTokenAllowsChannel(casefoldedName)) {
+		return errAPITokenRestricted
+	}
 
 	cm.Lock()
 	entry := cm.chans[casefoldedName]
@@ -61,14 +73,33 @@ func (cm *ChannelManager) Join(client *Client, name string, key string, isSajoin
 		// optimization to avoid that.
 		cm.Unlock()
 		info := client.server.channelRegistry.LoadChannel(casefoldedName)
+		// during a DEFCON lockdown, only opers can create new (unregistered) channels
+		if info == nil && !isSajoin && server.defcon.Level() <= DefconNoChannelCreation && !client.HasMode(modes.Operator) {
+			return errChannelCreationDisabled
+		}
+		if info == nil && !isSajoin && !client.canCreateChannel(&server.AccountConfig().Probation) {
+			return errChannelCreationProbation
+		}
+		// a registered channel that isn't loaded still reserves its skeleton
+		if info == nil {
+			if holder := server.channelRegistry.SkeletonHolder(skeleton); holder != "" && holder != casefoldedName {
+				return errConfusableChannelName
+			}
+		}
 		cm.Lock()
 		entry = cm.chans[casefoldedName]
 		if entry == nil {
+			// don't allow the creation of a channel that's confusable with an existing one
+			if holder, exists := cm.chansSkeletons[skeleton]; exists && holder != casefoldedName {
+				cm.Unlock()
+				return errConfusableChannelName
+			}
 			entry = &channelManagerEntry{
 				channel:      NewChannel(server, name, info),
 				pendingJoins: 0,
 			}
 			cm.chans[casefoldedName] = entry
+			cm.chansSkeletons[skeleton] = casefoldedName
 		}
 	}
 	entry.pendingJoins += 1
@@ -102,6 +133,9 @@ func (cm *ChannelManager) maybeCleanup(channel *Channel, afterJoin bool) {
 		// reread the name, handling the case where the channel was renamed
 		casefoldedName := entry.channel.NameCasefolded()
 		delete(cm.chans, casefoldedName)
+		if skeleton, err := ChannelSkeleton(entry.channel.Name()); err == nil && cm.chansSkeletons[skeleton] == casefoldedName {
+			delete(cm.chansSkeletons, skeleton)
+		}
 		// invalidate the entry (otherwise, a subsequent cleanup attempt could delete
 		// a valid, distinct entry under casefoldedName):
 		entry.channel = nil
@@ -141,19 +175,31 @@ func (cm *ChannelManager) Rename(name string, newname string) error {
 	if err != nil {
 		return errInvalidChannelName
 	}
+	newSkeleton, err := ChannelSkeleton(newname)
+	if err != nil {
+		return errInvalidChannelName
+	}
 
 	cm.Lock()
 	defer cm.Unlock()
 
-	if cm.chans[cfnewname] != nil {
+	// renaming a channel to a different capitalization of its name is allowed
+	if cfnewname != cfname && cm.chans[cfnewname] != nil {
 		return errChannelNameInUse
 	}
+	if holder, exists := cm.chansSkeletons[newSkeleton]; exists && holder != cfname {
+		return errConfusableChannelName
+	}
 	entry := cm.chans[cfname]
 	if entry == nil {
 		return errNoSuchChannel
 	}
+	if oldSkeleton, err := ChannelSkeleton(entry.channel.Name()); err == nil && cm.chansSkeletons[oldSkeleton] == cfname {
+		delete(cm.chansSkeletons, oldSkeleton)
+	}
 	delete(cm.chans, cfname)
 	cm.chans[cfnewname] = entry
+	cm.chansSkeletons[newSkeleton] = cfnewname
 	entry.channel.setName(newname)
 	entry.channel.setNameCasefolded(cfnewname)
 	return nil
@@ -0,0 +1,43 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func addTestChannel(cm *ChannelManager, name string) *Channel {
+	cfname, _ := CasefoldChannel(name)
+	skeleton, _ := ChannelSkeleton(name)
+	channel := &Channel{name: name, nameCasefolded: cfname}
+	cm.chans[cfname] = &channelManagerEntry{channel: channel}
+	cm.chansSkeletons[skeleton] = cfname
+	return channel
+}
+
+func TestChannelManagerRename(t *testing.T) {
+	cm := NewChannelManager()
+	channel := addTestChannel(cm, "#Test")
+	addTestChannel(cm, "#other")
+
+	if err := cm.Rename("#test", "#other"); err != errChannelNameInUse {
+		t.Errorf("renaming onto an existing channel should fail: %v", err)
+	}
+	if err := cm.Rename("#test", "#TEST"); err != nil {
+		t.Errorf("changing the capitalization of a channel name should succeed: %v", err)
+	}
+	if channel.Name() != "#TEST" || cm.Get("#test") != channel {
+		t.Errorf("incorrect state after changing capitalization: %s", channel.Name())
+	}
+	if err := cm.Rename("#test", "#renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if cm.Get("#test") != nil || cm.Get("#Renamed") != channel || channel.NameCasefolded() != "#renamed" {
+		t.Errorf("incorrect state after rename")
+	}
+	// the old name is free again
+	if err := cm.Rename("#other", "#test"); err != nil {
+		t.Errorf("old name should be available after a rename: %v", err)
+	}
+}
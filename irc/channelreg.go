@@ -7,11 +7,11 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"encoding/json"
 
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/modes"
 	"github.com/tidwall/buntdb"
 )
@@ -33,6 +33,21 @@ const (
 	keyChannelPassword       = "channel.key %s"
 	keyChannelModes          = "channel.modes %s"
 	keyChannelAccountToUMode = "channel.accounttoumode %s"
+	keyChannelEntryMsg       = "channel.entrymsg %s"
+	keyChannelJoinFlood      = "channel.joinflood %s"
+	keyChannelCTCPPolicy     = "channel.ctcppolicy %s"
+	keyChannelExitMsgPolicy  = "channel.exitmsgpolicy %s"
+	keyChannelLinkPolicy     = "channel.linkpolicy %s"
+	keyChannelMetadata       = "channel.metadata %s"
+	keyChannelSuccessor      = "channel.successor %s"
+	keyChannelTags           = "channel.tags %s"
+	keyChannelTopicHistory   = "channel.topichistory %s"
+	keyChannelTopicLock      = "channel.topiclock %s"
+	keyChannelVerification   = "channel.verification %s"
+
+	// maps the skeleton of a registered channel's name to its casefolded name;
+	// unlike the keys above, this is keyed by the skeleton
+	keyChannelSkeleton = "channel.skeleton %s"
 )
 
 var (
@@ -50,6 +65,17 @@ var (
 		keyChannelPassword,
 		keyChannelModes,
 		keyChannelAccountToUMode,
+		keyChannelEntryMsg,
+		keyChannelJoinFlood,
+		keyChannelCTCPPolicy,
+		keyChannelExitMsgPolicy,
+		keyChannelLinkPolicy,
+		keyChannelMetadata,
+		keyChannelSuccessor,
+		keyChannelTags,
+		keyChannelTopicHistory,
+		keyChannelTopicLock,
+		keyChannelVerification,
 	}
 )
 
@@ -60,6 +86,7 @@ const (
 	IncludeTopic
 	IncludeModes
 	IncludeLists
+	IncludeSettings
 )
 
 // this is an OR of all possible flags
@@ -75,12 +102,20 @@ type RegisteredChannel struct {
 	RegisteredAt time.Time
 	// Founder indicates the founder of the channel.
 	Founder string
+	// Successor is the account that becomes the founder if the founder's
+	// account is unregistered.
+	Successor string
 	// Topic represents the channel topic.
 	Topic string
 	// TopicSetBy represents the host that set the topic.
 	TopicSetBy string
 	// TopicSetTime represents the time the topic was set.
 	TopicSetTime time.Time
+	// TopicHistory holds the channel's recent topics.
+	TopicHistory []TopicHistoryItem
+	// TopicLock makes ChanServ restore the topic when someone without
+	// persistent operator access changes it.
+	TopicLock bool
 	// Modes represents the channel modes
 	Modes []modes.Mode
 	// Key represents the channel key / password
@@ -93,6 +128,22 @@ type RegisteredChannel struct {
 	Exceptlist []string
 	// Invitelist represents the invite exceptions set on the channel.
 	Invitelist []string
+	// EntryMsg is sent to users when they join the channel.
+	EntryMsg string
+	// JoinFlood is the channel's join flood threshold.
+	JoinFlood JoinFloodSettings
+	// CTCPPolicy overrides the server's policy for CTCP messages to channels.
+	CTCPPolicy string
+	// ExitMessagePolicy adds restrictions on quit, part, and kick messages.
+	ExitMessagePolicy ExitMessagePolicy
+	// LinkPolicy restricts messages containing links (see ChanServ SET LINKS).
+	LinkPolicy LinkPolicy
+	// Metadata is the channel's draft/metadata-2 key-value pairs.
+	Metadata map[string]string
+	// Tags are labels for the channel, e.g., its language (see ChanServ SET TAGS).
+	Tags []string
+	// Verification records the domain the channel belongs to (see ChanServ VERIFY).
+	Verification ChannelVerification
 }
 
 // ChannelRegistry manages registered channels.
@@ -103,8 +154,8 @@ type ChannelRegistry struct {
 	//
 	// We could use the buntdb RW transaction lock for this purpose but we share
 	// that with all the other modules, so let's not.
-	sync.Mutex // tier 2
-	server     *Server
+	lockorder.Tier2Mutex
+	server *Server
 }
 
 // NewChannelRegistry returns a new ChannelRegistry.
@@ -156,16 +207,27 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info *Registered
 		regTime, _ := tx.Get(fmt.Sprintf(keyChannelRegTime, channelKey))
 		regTimeInt, _ := strconv.ParseInt(regTime, 10, 64)
 		founder, _ := tx.Get(fmt.Sprintf(keyChannelFounder, channelKey))
+		successor, _ := tx.Get(fmt.Sprintf(keyChannelSuccessor, channelKey))
 		topic, _ := tx.Get(fmt.Sprintf(keyChannelTopic, channelKey))
 		topicSetBy, _ := tx.Get(fmt.Sprintf(keyChannelTopicSetBy, channelKey))
 		topicSetTime, _ := tx.Get(fmt.Sprintf(keyChannelTopicSetTime, channelKey))
 		topicSetTimeInt, _ := strconv.ParseInt(topicSetTime, 10, 64)
+		topicHistoryString, _ := tx.Get(fmt.Sprintf(keyChannelTopicHistory, channelKey))
+		topicLock, _ := tx.Get(fmt.Sprintf(keyChannelTopicLock, channelKey))
 		password, _ := tx.Get(fmt.Sprintf(keyChannelPassword, channelKey))
 		modeString, _ := tx.Get(fmt.Sprintf(keyChannelModes, channelKey))
 		banlistString, _ := tx.Get(fmt.Sprintf(keyChannelBanlist, channelKey))
 		exceptlistString, _ := tx.Get(fmt.Sprintf(keyChannelExceptlist, channelKey))
 		invitelistString, _ := tx.Get(fmt.Sprintf(keyChannelInvitelist, channelKey))
 		accountToUModeString, _ := tx.Get(fmt.Sprintf(keyChannelAccountToUMode, channelKey))
+		entryMsg, _ := tx.Get(fmt.Sprintf(keyChannelEntryMsg, channelKey))
+		joinFloodString, _ := tx.Get(fmt.Sprintf(keyChannelJoinFlood, channelKey))
+		ctcpPolicy, _ := tx.Get(fmt.Sprintf(keyChannelCTCPPolicy, channelKey))
+		exitMsgPolicyString, _ := tx.Get(fmt.Sprintf(keyChannelExitMsgPolicy, channelKey))
+		linkPolicyString, _ := tx.Get(fmt.Sprintf(keyChannelLinkPolicy, channelKey))
+		metadataString, _ := tx.Get(fmt.Sprintf(keyChannelMetadata, channelKey))
+		tagsString, _ := tx.Get(fmt.Sprintf(keyChannelTags, channelKey))
+		verificationString, _ := tx.Get(fmt.Sprintf(keyChannelVerification, channelKey))
 
 		modeSlice := make([]modes.Mode, len(modeString))
 		for i, mode := range modeString {
@@ -180,20 +242,41 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info *Registered
 		_ = json.Unmarshal([]byte(invitelistString), &invitelist)
 		accountToUMode := make(map[string]modes.Mode)
 		_ = json.Unmarshal([]byte(accountToUModeString), &accountToUMode)
+		joinFlood, _ := ParseJoinFloodSettings(joinFloodString)
+		exitMsgPolicy, _ := ParseExitMessagePolicy(exitMsgPolicyString)
+		linkPolicy, _ := ParseLinkPolicy(linkPolicyString)
+		var topicHistory []TopicHistoryItem
+		_ = json.Unmarshal([]byte(topicHistoryString), &topicHistory)
+		var verification ChannelVerification
+		_ = json.Unmarshal([]byte(verificationString), &verification)
+		var metadata map[string]string
+		_ = json.Unmarshal([]byte(metadataString), &metadata)
+		tags, _ := ParseChannelTags(tagsString)
 
 		info = &RegisteredChannel{
-			Name:           name,
-			RegisteredAt:   time.Unix(regTimeInt, 0),
-			Founder:        founder,
-			Topic:          topic,
-			TopicSetBy:     topicSetBy,
-			TopicSetTime:   time.Unix(topicSetTimeInt, 0),
-			Key:            password,
-			Modes:          modeSlice,
-			Banlist:        banlist,
-			Exceptlist:     exceptlist,
-			Invitelist:     invitelist,
-			AccountToUMode: accountToUMode,
+			Name:              name,
+			RegisteredAt:      time.Unix(regTimeInt, 0),
+			Founder:           founder,
+			Successor:         successor,
+			Topic:             topic,
+			TopicSetBy:        topicSetBy,
+			TopicSetTime:      time.Unix(topicSetTimeInt, 0),
+			TopicHistory:      topicHistory,
+			TopicLock:         topicLock != "",
+			Key:               password,
+			Modes:             modeSlice,
+			Banlist:           banlist,
+			Exceptlist:        exceptlist,
+			Invitelist:        invitelist,
+			AccountToUMode:    accountToUMode,
+			EntryMsg:          entryMsg,
+			JoinFlood:         joinFlood,
+			CTCPPolicy:        ctcpPolicy,
+			ExitMessagePolicy: exitMsgPolicy,
+			LinkPolicy:        linkPolicy,
+			Metadata:          metadata,
+			Tags:              tags,
+			Verification:      verification,
 		}
 		return nil
 	})
@@ -201,6 +284,20 @@ func (reg *ChannelRegistry) LoadChannel(nameCasefolded string) (info *Registered
 	return info
 }
 
+// SkeletonHolder returns the casefolded name of the registered channel whose
+// name has the given skeleton, if there is one.
+func (reg *ChannelRegistry) SkeletonHolder(skeleton string) (casefoldedName string) {
+	if !reg.server.ChannelRegistrationEnabled() {
+		return ""
+	}
+
+	reg.server.store.View(func(tx *buntdb.Tx) error {
+		casefoldedName, _ = tx.Get(fmt.Sprintf(keyChannelSkeleton, skeleton))
+		return nil
+	})
+	return
+}
+
 func (reg *ChannelRegistry) Delete(casefoldedName string, info RegisteredChannel) {
 	if !reg.server.ChannelRegistrationEnabled() {
 		return
@@ -240,6 +337,86 @@ func (reg *ChannelRegistry) Rename(channel *Channel, casefoldedOldName string) {
 	})
 }
 
+// Succeed makes the successor of a channel its founder, because the founder's
+// account is being unregistered. It returns false if the channel has no
+// (valid) successor, in which case the channel should be unregistered.
+func (reg *ChannelRegistry) Succeed(casefoldedName string, founder string) bool {
+	if !reg.server.ChannelRegistrationEnabled() {
+		return false
+	}
+
+	channel := reg.server.channels.Get(casefoldedName)
+	if channel != nil {
+		// the channel is loaded, so the in-memory state is the source of truth
+		successor := channel.Successor()
+		if successor == "" || !reg.accountExists(successor) {
+			return false
+		}
+		if channel.Transfer(founder, successor) != nil {
+			return false
+		}
+		reg.Transfer(channel, founder)
+		channel.updateFounderModes(founder, successor)
+		return true
+	}
+
+	info := reg.LoadChannel(casefoldedName)
+	if info == nil || info.Founder != founder || info.Successor == "" || !reg.accountExists(info.Successor) {
+		return false
+	}
+	reg.setStoredFounder(casefoldedName, info, info.Successor)
+	return true
+}
+
+// setStoredFounder changes the founder of a channel that isn't loaded, given
+// its stored registration.
+func (reg *ChannelRegistry) setStoredFounder(casefoldedName string, info *RegisteredChannel, to string) {
+	from := info.Founder
+	if info.AccountToUMode == nil {
+		info.AccountToUMode = make(map[string]modes.Mode)
+	}
+	delete(info.AccountToUMode, from)
+	info.AccountToUMode[to] = modes.ChannelFounder
+	info.Founder = to
+	if info.Successor == to {
+		info.Successor = ""
+	}
+
+	reg.Lock()
+	defer reg.Unlock()
+
+	reg.server.store.Update(func(tx *buntdb.Tx) error {
+		moveRegisteredChannel(tx, casefoldedName, from, to)
+		reg.saveChannel(tx, casefoldedName, *info, IncludeInitial|IncludeLists)
+		return nil
+	})
+}
+
+func (reg *ChannelRegistry) accountExists(account string) bool {
+	_, err := reg.server.accounts.LoadAccount(account)
+	return err == nil
+}
+
+// moveRegisteredChannel moves a channel from one account's list of registered
+// channels to another's.
+func moveRegisteredChannel(tx *buntdb.Tx, key, from, to string) {
+	fromKey := fmt.Sprintf(keyAccountChannels, from)
+	if fromChannelsStr, err := tx.Get(fromKey); err == nil {
+		var fromChannels []string
+		for _, chname := range unmarshalRegisteredChannels(fromChannelsStr) {
+			if chname != key {
+				fromChannels = append(fromChannels, chname)
+			}
+		}
+		tx.Set(fromKey, strings.Join(fromChannels, ","), nil)
+	}
+
+	toKey := fmt.Sprintf(keyAccountChannels, to)
+	toChannelsStr, _ := tx.Get(toKey)
+	toChannels := append(unmarshalRegisteredChannels(toChannelsStr), key)
+	tx.Set(toKey, strings.Join(toChannels, ","), nil)
+}
+
 // delete a channel, unless it was overwritten by another registration of the same channel
 func (reg *ChannelRegistry) deleteChannel(tx *buntdb.Tx, key string, info RegisteredChannel) {
 	_, err := tx.Get(fmt.Sprintf(keyChannelExists, key))
@@ -251,6 +428,13 @@ func (reg *ChannelRegistry) deleteChannel(tx *buntdb.Tx, key string, info Regist
 
 		// to see if we're deleting the right channel, confirm the founder and the registration time
 		if founder == info.Founder && registeredAt.Unix() == info.RegisteredAt.Unix() {
+			name, _ := tx.Get(fmt.Sprintf(keyChannelName, key))
+			if skeleton, err := ChannelSkeleton(name); err == nil {
+				skeletonKey := fmt.Sprintf(keyChannelSkeleton, skeleton)
+				if holder, _ := tx.Get(skeletonKey); holder == key {
+					tx.Delete(skeletonKey)
+				}
+			}
 			for _, keyFmt := range channelKeyStrings {
 				tx.Delete(fmt.Sprintf(keyFmt, key))
 			}
@@ -294,12 +478,18 @@ func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelKey string, channe
 		tx.Set(fmt.Sprintf(keyChannelName, channelKey), channelInfo.Name, nil)
 		tx.Set(fmt.Sprintf(keyChannelRegTime, channelKey), strconv.FormatInt(channelInfo.RegisteredAt.Unix(), 10), nil)
 		tx.Set(fmt.Sprintf(keyChannelFounder, channelKey), channelInfo.Founder, nil)
+		tx.Set(fmt.Sprintf(keyChannelSuccessor, channelKey), channelInfo.Successor, nil)
+		if skeleton, err := ChannelSkeleton(channelInfo.Name); err == nil {
+			tx.Set(fmt.Sprintf(keyChannelSkeleton, skeleton), channelKey, nil)
+		}
 	}
 
 	if includeFlags&IncludeTopic != 0 {
 		tx.Set(fmt.Sprintf(keyChannelTopic, channelKey), channelInfo.Topic, nil)
 		tx.Set(fmt.Sprintf(keyChannelTopicSetTime, channelKey), strconv.FormatInt(channelInfo.TopicSetTime.Unix(), 10), nil)
 		tx.Set(fmt.Sprintf(keyChannelTopicSetBy, channelKey), channelInfo.TopicSetBy, nil)
+		topicHistoryString, _ := json.Marshal(channelInfo.TopicHistory)
+		tx.Set(fmt.Sprintf(keyChannelTopicHistory, channelKey), string(topicHistoryString), nil)
 	}
 
 	if includeFlags&IncludeModes != 0 {
@@ -321,4 +511,22 @@ func (reg *ChannelRegistry) saveChannel(tx *buntdb.Tx, channelKey string, channe
 		accountToUModeString, _ := json.Marshal(channelInfo.AccountToUMode)
 		tx.Set(fmt.Sprintf(keyChannelAccountToUMode, channelKey), string(accountToUModeString), nil)
 	}
+
+	if includeFlags&IncludeSettings != 0 {
+		tx.Set(fmt.Sprintf(keyChannelEntryMsg, channelKey), channelInfo.EntryMsg, nil)
+		tx.Set(fmt.Sprintf(keyChannelJoinFlood, channelKey), channelInfo.JoinFlood.String(), nil)
+		tx.Set(fmt.Sprintf(keyChannelCTCPPolicy, channelKey), channelInfo.CTCPPolicy, nil)
+		tx.Set(fmt.Sprintf(keyChannelExitMsgPolicy, channelKey), channelInfo.ExitMessagePolicy.String(), nil)
+		tx.Set(fmt.Sprintf(keyChannelLinkPolicy, channelKey), channelInfo.LinkPolicy.String(), nil)
+		var topicLock string
+		if channelInfo.TopicLock {
+			topicLock = "1"
+		}
+		tx.Set(fmt.Sprintf(keyChannelTopicLock, channelKey), topicLock, nil)
+		verificationString, _ := json.Marshal(channelInfo.Verification)
+		tx.Set(fmt.Sprintf(keyChannelVerification, channelKey), string(verificationString), nil)
+		metadataString, _ := json.Marshal(channelInfo.Metadata)
+		tx.Set(fmt.Sprintf(keyChannelMetadata, channelKey), string(metadataString), nil)
+		tx.Set(fmt.Sprintf(keyChannelTags, channelKey), strings.Join(channelInfo.Tags, ","), nil)
+	}
 }
@@ -0,0 +1,71 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestMoveRegisteredChannel(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(fmt.Sprintf(keyAccountChannels, "old"), "#a,#b", nil)
+		tx.Set(fmt.Sprintf(keyAccountChannels, "new"), "#c", nil)
+		moveRegisteredChannel(tx, "#a", "old", "new")
+		// the list of an unregistered account isn't recreated
+		moveRegisteredChannel(tx, "#b", "gone", "new")
+		return nil
+	})
+	store.View(func(tx *buntdb.Tx) error {
+		if channels, _ := tx.Get(fmt.Sprintf(keyAccountChannels, "old")); channels != "#b" {
+			t.Errorf("incorrect channels for the previous founder: %s", channels)
+		}
+		if channels, _ := tx.Get(fmt.Sprintf(keyAccountChannels, "new")); channels != "#c,#a,#b" {
+			t.Errorf("incorrect channels for the new founder: %s", channels)
+		}
+		if _, err := tx.Get(fmt.Sprintf(keyAccountChannels, "gone")); err != buntdb.ErrNotFound {
+			t.Errorf("channel list should not be created for a missing account")
+		}
+		return nil
+	})
+}
+
+func TestRegisteredChannelSkeletons(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	// a registered channel that isn't loaded
+	info := RegisteredChannel{Name: "#Test", Founder: "alice", RegisteredAt: time.Now()}
+	h.server.store.Update(func(tx *buntdb.Tx) error {
+		h.server.channelRegistry.saveChannel(tx, "#test", info, IncludeInitial)
+		return nil
+	})
+	if holder := h.server.channelRegistry.SkeletonHolder("#test"); holder != "#test" {
+		t.Fatalf("skeleton of the registered channel was not stored: %s", holder)
+	}
+
+	alice := h.Register("alice")
+	alice.Send("JOIN", "#tеst") // Cyrillic е
+	alice.Expect(ERR_BADCHANNAME)
+	alice.Send("JOIN", "#other")
+	alice.Expect(RPL_ENDOFNAMES)
+	alice.Send("RENAME", "#other", "#tеst")
+	alice.Expect(ERR_CHANNAMEINUSE)
+	alice.Send("JOIN", "#TEST")
+	alice.Expect(RPL_ENDOFNAMES)
+
+	h.server.channelRegistry.Delete("#test", info)
+	if holder := h.server.channelRegistry.SkeletonHolder("#test"); holder != "" {
+		t.Errorf("skeleton of the unregistered channel was not deleted: %s", holder)
+	}
+}
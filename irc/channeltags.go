@@ -0,0 +1,81 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Channel tags are labels that founders attach to their registered channels
+// (ChanServ SET TAGS), e.g., a language or region ("en", "pt-br") or a topic
+// ("music"), so that users can find channels with LIST L=<tag>.
+
+const (
+	// a channel can have at most this many tags
+	maxChannelTags = 8
+	// each of which is at most this long
+	maxChannelTagLen = 32
+)
+
+var (
+	errInvalidChannelTag  = errors.New("Invalid channel tag")
+	errTooManyChannelTags = errors.New("Too many channel tags")
+)
+
+// isValidChannelTag returns whether a (lowercase) tag is valid: tags are
+// made of ASCII letters, digits, and the characters - _ .
+func isValidChannelTag(tag string) bool {
+	if tag == "" || maxChannelTagLen < len(tag) {
+		return false
+	}
+	for _, r := range tag {
+		if !(('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseChannelTags parses a list of tags separated by commas or spaces,
+// returning them lowercased, deduplicated, and sorted.
+func ParseChannelTags(str string) (tags []string, err error) {
+	fields := strings.FieldsFunc(str, func(r rune) bool { return r == ',' || r == ' ' })
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		tag := strings.ToLower(field)
+		if !isValidChannelTag(tag) {
+			return nil, errInvalidChannelTag
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if maxChannelTags < len(tags) {
+		return nil, errTooManyChannelTags
+	}
+	sort.Strings(tags)
+	return
+}
+
+// HasTags returns whether the channel has all the given (lowercase) tags.
+func (channel *Channel) HasTags(tags []string) bool {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	for _, tag := range tags {
+		found := false
+		for _, channelTag := range channel.tags {
+			if tag == channelTag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChannelTags(t *testing.T) {
+	tags, err := ParseChannelTags("Music, en pt-BR,en")
+	if err != nil || !reflect.DeepEqual(tags, []string{"en", "music", "pt-br"}) {
+		t.Errorf("unexpected tags: %v, %v", tags, err)
+	}
+	if tags, err := ParseChannelTags(""); err != nil || len(tags) != 0 {
+		t.Errorf("empty string should clear the tags: %v, %v", tags, err)
+	}
+	for _, str := range []string{"#en", "émoji", strings.Repeat("a", maxChannelTagLen+1)} {
+		if _, err := ParseChannelTags(str); err != errInvalidChannelTag {
+			t.Errorf("%s should be invalid", str)
+		}
+	}
+	if _, err := ParseChannelTags("a b c d e f g h i"); err != errTooManyChannelTags {
+		t.Errorf("number of tags should be limited")
+	}
+}
+
+func TestListByTag(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	for _, chname := range []string{"#english", "#music"} {
+		alice.Send("JOIN", chname)
+		alice.Expect(RPL_ENDOFNAMES)
+	}
+	h.server.channels.Get("#english").setTags([]string{"en"})
+	h.server.channels.Get("#music").setTags([]string{"en", "music"})
+
+	alice.Send("LIST", "L=EN,L=music")
+	if msg := alice.Expect(RPL_LIST); msg.Params[1] != "#music" {
+		t.Errorf("unexpected channel: %v", msg)
+	}
+	alice.Expect(RPL_LISTEND)
+
+	alice.Send("LIST", "L=de")
+	alice.Expect(RPL_LISTEND)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/goshuirc/irc-go/ircfmt"
 	"github.com/oragono/oragono/irc/modes"
 	"github.com/oragono/oragono/irc/sno"
+	"github.com/oragono/oragono/irc/utils"
 )
 
 const chanservHelp = `ChanServ lets you register and manage channels.
@@ -29,6 +30,10 @@ func chanregEnabled(config *Config) bool {
 	return config.Channels.Registration.Enabled
 }
 
+func csVerifyEnabled(config *Config) bool {
+	return config.Channels.Registration.Enabled && config.Channels.Registration.Verification.Enabled
+}
+
 var (
 	chanservCommands = map[string]*serviceCommand{
 		"op": {
@@ -68,6 +73,109 @@ invoking the command without a code will display the necessary code.`,
 		"drop": {
 			aliasOf: "unregister",
 		},
+		"recover": {
+			handler: csRecoverHandler,
+			help: `Syntax: $bRECOVER #channel$b
+
+RECOVER lets you take back control of a channel you founded, if it has become
+opless or was taken over. Members who hold channel privileges (halfop or
+higher) that weren't granted to them with $bAMODE$b or $bACCESS$b are kicked,
+invite-only, moderation, the channel key and the user limit are removed, along
+with any bans matching you, and you're given founder privileges (if you're in
+the channel).`,
+			helpShort:    `$bRECOVER$b takes back control of a channel you founded.`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    1,
+		},
+		"claim": {
+			aliasOf: "recover",
+		},
+		"set": {
+			handler: csSetHandler,
+			help: `Syntax: $bSET #channel <setting> [value]$b
+
+SET modifies a channel's settings. You can only use this command if you're the
+founder of the channel. The following settings are available:
+
+$bENTRYMSG$b
+A message that is sent to users when they join the channel. If no value is
+given, the entry message is removed. Users can also view it with /RULES.
+
+$bJOINFLOOD$b
+The join flood threshold, as $bjoins:seconds$b: for example, $b5:10s$b means that
+more than 5 joins within 10 seconds is a flood, after which new joiners must
+pass a challenge for a while. $bOFF$b disables join flood detection for the
+channel, and if no value is given, the server's default threshold is used.
+
+$bCTCP$b
+What to do with CTCP messages (other than ACTION) sent to the channel:
+$bALLOW$b them, $bSTRIP$b them from messages, or $bBLOCK$b them. If no value is
+given, the server's default policy is used.
+
+$bEXITMSGS$b
+Restrictions on quit, part, and kick messages in the channel, in addition to
+the server's: any of $bSTRIP-FORMATTING$b, $bBLOCK-URLS$b (drop messages that
+contain URLs), $bMAX-LENGTH=n$b (truncate longer messages), and $bBAN=pattern$b
+(drop messages matching the pattern, e.g., $bBAN=*example.com*$b). If no value
+is given, only the server's restrictions apply.
+
+$bLINKS$b
+Restrictions on messages containing links (URLs) in the channel, for users
+without voice or higher: any of $bACCOUNT$b (only users logged into accounts
+can post links), $bAGE=duration$b (only members who joined at least that long
+ago, e.g., $bAGE=10m$b), and $bRATE=n$b (at most n messages with links per
+member per minute). If no value is given, links aren't restricted.
+
+$bTAGS$b
+Labels for the channel, separated by commas or spaces, such as its language or
+region and what it's about: for example, $ben,music$b. Users can find channels
+with a tag using $b/LIST L=tag$b. If no value is given, the tags are removed.
+
+$bTOPICLOCK$b
+$bON$b or $bOFF$b. If the topic is locked, ChanServ restores it whenever it's
+changed by someone without persistent operator access (see $bHELP AMODE$b).`,
+			helpShort:    `$bSET$b modifies a channel's settings.`,
+			authRequired: true,
+			enabled:      chanregEnabled,
+			minParams:    2,
+		},
+		"topichistory": {
+			handler: csTopicHistoryHandler,
+			help: `Syntax: $bTOPICHISTORY #channel$b
+
+TOPICHISTORY shows the recent topics of a channel, who set them, and when.
+You can only use this command if you're a channel operator.`,
+			helpShort: `$bTOPICHISTORY$b shows the recent topics of a channel.`,
+			enabled:   chanregEnabled,
+			minParams: 1,
+		},
+		"verify": {
+			handler: csVerifyHandler,
+			help: `Syntax: $bVERIFY #channel [domain | CHECK | CLEAR]$b
+
+VERIFY proves that a channel belongs to a domain (say, your project's website).
+$bVERIFY #channel <domain>$b gives you a token to publish, either in a DNS TXT
+record or on a web page on the domain; once it's published,
+$bVERIFY #channel CHECK$b checks it, and the channel is shown as verified in
+LIST. $bVERIFY #channel CLEAR$b removes the verification, and with no other
+parameters, VERIFY shows the channel's status. You can only use this command
+if you're the founder of the channel.`,
+			helpShort:    `$bVERIFY$b proves that a channel belongs to a domain.`,
+			authRequired: true,
+			enabled:      csVerifyEnabled,
+			minParams:    1,
+		},
+		"challenge": {
+			handler: csChallengeHandler,
+			help: `Syntax: $bCHALLENGE #channel <token> [key]$b
+
+While a channel is being flooded with joins, new joiners must answer a
+challenge: ChanServ sends them a token, which they give to CHALLENGE to join
+the channel.`,
+			helpShort: `$bCHALLENGE$b answers a channel's join flood challenge.`,
+			minParams: 2,
+		},
 		"amode": {
 			handler: csAmodeHandler,
 			help: `Syntax: $bAMODE #channel [mode change] [account]$b
@@ -81,12 +189,48 @@ referenced by their registered account names, not their nicknames.`,
 			enabled:   chanregEnabled,
 			minParams: 1,
 		},
+		"transfer": {
+			handler: csTransferHandler,
+			help: `Syntax: $bTRANSFER #channel <username>$b
+        $bTRANSFER ACCEPT #channel$b
+        $bTRANSFER CANCEL #channel$b
+
+TRANSFER proposes making another account the founder of a channel you founded.
+The transfer only takes effect once someone logged into the other account
+accepts it with TRANSFER ACCEPT; proposals expire after a day. Either side can
+call off a pending transfer with TRANSFER CANCEL. Opers with channel
+registration privileges can transfer any channel immediately.`,
+			helpShort:    `$bTRANSFER$b gives a channel to another account.`,
+			enabled:      chanregEnabled,
+			authRequired: true,
+			minParams:    2,
+		},
+		"access": {
+			handler: csAccessHandler,
+			help: `Syntax: $bACCESS #channel [LIST]$b
+        $bACCESS #channel ADD <account> <level>$b
+        $bACCESS #channel DEL <account>$b
+
+ACCESS lists or modifies the access levels of accounts on a registered channel.
+The levels are:
+1. 'founder'   [owns the channel; see $bHELP TRANSFER$b]
+2. 'successor' [becomes the founder if the founder's account is unregistered]
+3. 'admin'     [receives mode +a on joining]
+4. 'op'        [receives mode +o on joining]
+5. 'halfop'    [receives mode +h on joining]
+6. 'voice'     [receives mode +v on joining]
+Only the founder can choose the successor; otherwise, the same rules apply as
+for $bAMODE$b.`,
+			helpShort: `$bACCESS$b lists or modifies access levels on a channel.`,
+			enabled:   chanregEnabled,
+			minParams: 1,
+		},
 	}
 )
 
 // csNotice sends the client a notice from ChanServ
 func csNotice(rb *ResponseBuffer, text string) {
-	rb.Add(nil, "ChanServ", "NOTICE", rb.target.Nick(), text)
+	rb.AddSplitMessageFromServer("ChanServ", "NOTICE", rb.target.Nick(), utils.MakeSplitMessage(text))
 }
 
 func csAmodeHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
@@ -102,6 +246,7 @@ func csAmodeHandler(server *Server, client *Client, command string, params []str
 	}
 
 	modeChanges, unknown := modes.ParseChannelModeChanges(params[1:]...)
+	modeChanges = server.Config().filterStatusModes(modeChanges, unknown)
 	var change modes.ModeChange
 	if len(modeChanges) > 1 || len(unknown) > 0 {
 		csNotice(rb, client.t("Invalid mode change"))
@@ -162,6 +307,135 @@ func csAmodeHandler(server *Server, client *Client, command string, params []str
 	}
 }
 
+// persistent channel modes granted by each access level
+var csAccessLevels = map[string]modes.Mode{
+	"founder": modes.ChannelFounder,
+	"admin":   modes.ChannelAdmin,
+	"op":      modes.ChannelOperator,
+	"halfop":  modes.Halfop,
+	"voice":   modes.Voice,
+}
+
+func csAccessLevelName(mode modes.Mode) string {
+	for name, levelMode := range csAccessLevels {
+		if mode == levelMode {
+			return name
+		}
+	}
+	return "+" + string(mode)
+}
+
+func csAccessHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	}
+	channelName := channel.Name()
+	founder := channel.Founder()
+	if founder == "" {
+		csNotice(rb, client.t("Channel is not registered"))
+		return
+	}
+
+	subcommand := "list"
+	if len(params) > 1 {
+		subcommand = strings.ToLower(params[1])
+	}
+	var account string
+	if subcommand == "add" || subcommand == "del" {
+		if len(params) < 3 || (subcommand == "add" && len(params) < 4) {
+			csNotice(rb, client.t("Invalid parameters"))
+			return
+		}
+		var err error
+		account, err = CasefoldName(params[2])
+		if err != nil {
+			csNotice(rb, client.t("Account does not exist"))
+			return
+		} else if account == founder {
+			csNotice(rb, client.t("The founder's access can't be changed; use /NS TRANSFER instead"))
+			return
+		}
+	}
+
+	switch subcommand {
+	case "list":
+		entries, err := channel.ProcessAccountToUmodeChange(client, modes.ModeChange{Op: modes.List})
+		if err != nil {
+			csNotice(rb, client.t("Insufficient privileges"))
+			return
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return umodeGreaterThan(entries[i].Mode, entries[j].Mode)
+		})
+		csNotice(rb, fmt.Sprintf(client.t("Access list for %s:"), channelName))
+		csNotice(rb, fmt.Sprintf(client.t("Account %[1]s has level %[2]s"), founder, "founder"))
+		if successor := channel.Successor(); successor != "" {
+			csNotice(rb, fmt.Sprintf(client.t("Account %[1]s has level %[2]s"), successor, "successor"))
+		}
+		for _, entry := range entries {
+			if entry.Arg != founder {
+				csNotice(rb, fmt.Sprintf(client.t("Account %[1]s has level %[2]s"), entry.Arg, csAccessLevelName(entry.Mode)))
+			}
+		}
+	case "add":
+		if _, err := server.accounts.LoadAccount(account); err != nil {
+			csNotice(rb, client.t("Account does not exist"))
+			return
+		}
+		level := strings.ToLower(params[3])
+		if level == "successor" {
+			if channel.SetSuccessor(client, account) != nil {
+				csNotice(rb, client.t("Only the channel founder can choose a successor"))
+				return
+			}
+			go server.channelRegistry.StoreChannel(channel, IncludeInitial)
+			csNotice(rb, fmt.Sprintf(client.t("Account %[1]s is now the successor of %[2]s"), account, channelName))
+			return
+		}
+		mode, ok := csAccessLevels[level]
+		if !ok || mode == modes.ChannelFounder || !server.Config().statusModeEnabled(mode) {
+			csNotice(rb, client.t("Invalid access level"))
+			return
+		}
+		csAccessChange(channel, client, modes.ModeChange{Op: modes.Add, Mode: mode, Arg: account}, rb)
+	case "del":
+		changed := false
+		if account == channel.Successor() {
+			if channel.SetSuccessor(client, "") != nil {
+				csNotice(rb, client.t("Only the channel founder can choose a successor"))
+				return
+			}
+			go server.channelRegistry.StoreChannel(channel, IncludeInitial)
+			csNotice(rb, fmt.Sprintf(client.t("Channel %s no longer has a successor"), channelName))
+			changed = true
+		}
+		if mode := channel.AccountMode(account); mode != 0 {
+			csAccessChange(channel, client, modes.ModeChange{Op: modes.Remove, Mode: mode, Arg: account}, rb)
+		} else if !changed {
+			csNotice(rb, client.t("No changes were made"))
+		}
+	default:
+		csNotice(rb, client.t("Invalid parameters"))
+	}
+}
+
+func csAccessChange(channel *Channel, client *Client, change modes.ModeChange, rb *ResponseBuffer) {
+	affectedModes, err := channel.ProcessAccountToUmodeChange(client, change)
+	if err == errInsufficientPrivs {
+		csNotice(rb, client.t("Insufficient privileges"))
+	} else if err != nil {
+		csNotice(rb, client.t("Internal error"))
+	} else if len(affectedModes) == 0 {
+		csNotice(rb, client.t("No changes were made"))
+	} else if change.Op == modes.Add {
+		csNotice(rb, fmt.Sprintf(client.t("Account %[1]s now has level %[2]s"), change.Arg, csAccessLevelName(change.Mode)))
+	} else {
+		csNotice(rb, fmt.Sprintf(client.t("Account %s no longer has access"), change.Arg))
+	}
+}
+
 func csOpHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	channelInfo := server.channels.Get(params[0])
 	if channelInfo == nil {
@@ -209,6 +483,54 @@ func csOpHandler(server *Server, client *Client, command string, params []string
 	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] CS OP'd $c[grey][$r%s$c[grey]] in channel $c[grey][$r%s$c[grey]]"), client.NickMaskString(), tnick, channelName))
 }
 
+func csRecoverHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	}
+	channelName := channel.Name()
+
+	founder := channel.Founder()
+	if founder == "" {
+		csNotice(rb, client.t("Channel is not registered"))
+		return
+	}
+	isFounder := client.Account() == founder
+	if !isFounder && !client.HasRoleCapabs("chanreg") {
+		csNotice(rb, client.t("You must be the channel founder to recover it"))
+		return
+	}
+
+	changes, imposters := channel.recoveryChanges(client)
+	if isFounder && channel.hasClient(client) {
+		changes = append(changes, modes.ModeChange{Op: modes.Add, Mode: modes.ChannelFounder, Arg: client.Nick()})
+	}
+
+	chanservMask := fmt.Sprintf("ChanServ!services@%s", server.name)
+	for _, imposter := range imposters {
+		channel.kick(chanservMask, imposter, "Channel recovered by its founder")
+	}
+
+	applied := channel.ApplyChannelModeChanges(client, true, changes, rb)
+	if len(applied) > 0 {
+		go server.channelRegistry.StoreChannel(channel, IncludeModes|IncludeLists)
+		args := append([]string{channelName}, strings.Split(applied.String(), " ")...)
+		for _, member := range channel.Members() {
+			member.Send(nil, chanservMask, "MODE", args...)
+		}
+	}
+
+	csNotice(rb, fmt.Sprintf(client.t("Recovered channel %[1]s: kicked %[2]d members and made %[3]d mode changes"), channelName, len(imposters), len(applied)))
+
+	kicked := make([]string, len(imposters))
+	for i, imposter := range imposters {
+		kicked[i] = imposter.Nick()
+	}
+	server.logger.Info("services", fmt.Sprintf("Client %s recovered channel %s, kicking [%s]", client.Nick(), channelName, strings.Join(kicked, ", ")))
+	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] CS RECOVERed channel $c[grey][$r%s$c[grey]], kicking $c[grey][$r%s$c[grey]]"), client.NickMaskString(), channelName, strings.Join(kicked, ", ")))
+}
+
 func csRegisterHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	channelName := params[0]
 
@@ -224,6 +546,11 @@ func csRegisterHandler(server *Server, client *Client, command string, params []
 		return
 	}
 
+	if server.defcon.Level() <= DefconNoRegistrations && !client.HasRoleCapabs("chanreg") {
+		csNotice(rb, client.t(errTemporarilyDisabled.Error()))
+		return
+	}
+
 	account := client.Account()
 	channelsAlreadyRegistered := server.accounts.ChannelsForAccount(account)
 	if server.Config().Channels.Registration.MaxChannelsPerAccount <= len(channelsAlreadyRegistered) {
@@ -302,6 +629,166 @@ func csUnregisterHandler(server *Server, client *Client, command string, params
 	csNotice(rb, fmt.Sprintf(client.t("Channel %s is now unregistered"), channelKey))
 }
 
+func csSetHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	}
+	founder := channel.Founder()
+	if founder == "" {
+		csNotice(rb, client.t("Channel is not registered"))
+		return
+	} else if client.Account() != founder {
+		csNotice(rb, client.t("You must be the channel founder to change its settings"))
+		return
+	}
+	channelName := channel.Name()
+
+	switch strings.ToLower(params[1]) {
+	case "entrymsg":
+		entryMsg := strings.Join(params[2:], " ")
+		if maxEntryMsgLen < len(entryMsg) {
+			csNotice(rb, fmt.Sprintf(client.t("Entry messages can be at most %d bytes long"), maxEntryMsgLen))
+			return
+		}
+		channel.setEntryMsg(entryMsg)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if entryMsg == "" {
+			csNotice(rb, fmt.Sprintf(client.t("Removed the entry message of %s"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Set the entry message of %s"), channelName))
+		}
+	case "ctcp":
+		policy := strings.ToLower(strings.Join(params[2:], " "))
+		if policy != "" && !validCTCPPolicy(policy) {
+			csNotice(rb, client.t("Invalid CTCP policy; it must be one of allow, strip, or block"))
+			return
+		}
+		channel.setCTCPPolicy(policy)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if policy == "" {
+			csNotice(rb, fmt.Sprintf(client.t("%s now uses the default CTCP policy"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Set the CTCP policy of %[1]s to %[2]s"), channelName, policy))
+		}
+	case "joinflood":
+		settings, err := ParseJoinFloodSettings(strings.Join(params[2:], " "))
+		if err != nil {
+			csNotice(rb, client.t("Invalid join flood threshold; the format is joins:seconds, e.g., 5:10s"))
+			return
+		}
+		channel.setJoinFloodSettings(settings)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if settings.Disabled {
+			csNotice(rb, fmt.Sprintf(client.t("Disabled join flood detection for %s"), channelName))
+		} else if settings.Joins == 0 {
+			csNotice(rb, fmt.Sprintf(client.t("%s now uses the default join flood threshold"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Set the join flood threshold of %[1]s to %[2]d joins in %[3]v"), channelName, settings.Joins, settings.Window))
+		}
+	case "exitmsgs":
+		policy, err := ParseExitMessagePolicy(strings.Join(params[2:], " "))
+		if err != nil {
+			csNotice(rb, client.t("Invalid parameters. For usage, do /msg ChanServ HELP SET"))
+			return
+		}
+		channel.setExitMessagePolicy(policy)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if policy.IsEmpty() {
+			csNotice(rb, fmt.Sprintf(client.t("%s now uses the server's restrictions on exit messages"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Set the exit message restrictions of %[1]s to: %[2]s"), channelName, policy.String()))
+		}
+	case "links":
+		policy, err := ParseLinkPolicy(strings.Join(params[2:], " "))
+		if err != nil {
+			csNotice(rb, client.t("Invalid parameters. For usage, do /msg ChanServ HELP SET"))
+			return
+		}
+		channel.setLinkPolicy(policy)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if policy.IsEmpty() {
+			csNotice(rb, fmt.Sprintf(client.t("Removed the link restrictions of %s"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Set the link restrictions of %[1]s to: %[2]s"), channelName, policy.String()))
+		}
+	case "tags":
+		tags, err := ParseChannelTags(strings.Join(params[2:], " "))
+		if err == errTooManyChannelTags {
+			csNotice(rb, fmt.Sprintf(client.t("Channels can have at most %d tags"), maxChannelTags))
+			return
+		} else if err != nil {
+			csNotice(rb, client.t("Invalid tags; tags can only contain letters, digits, and the characters - _ ."))
+			return
+		}
+		channel.setTags(tags)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if len(tags) == 0 {
+			csNotice(rb, fmt.Sprintf(client.t("Removed the tags of %s"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Set the tags of %[1]s to: %[2]s"), channelName, strings.Join(tags, ", ")))
+		}
+	case "topiclock":
+		var topicLock bool
+		switch strings.ToLower(strings.Join(params[2:], " ")) {
+		case "on":
+			topicLock = true
+		case "off":
+			topicLock = false
+		default:
+			csNotice(rb, client.t("Invalid parameters"))
+			return
+		}
+		channel.setTopicLock(topicLock)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		if topicLock {
+			csNotice(rb, fmt.Sprintf(client.t("Locked the topic of %s"), channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("Unlocked the topic of %s"), channelName))
+		}
+	default:
+		csNotice(rb, client.t("Invalid setting"))
+	}
+}
+
+func csTopicHistoryHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	}
+	if !(channel.ClientIsAtLeast(client, modes.ChannelOperator) || channel.hasTopicAccess(client)) {
+		csNotice(rb, client.t("Insufficient privileges"))
+		return
+	}
+
+	channelName := channel.Name()
+	topics := channel.TopicHistory()
+	csNotice(rb, fmt.Sprintf(client.t("Channel %[1]s has %[2]d recent topics"), channelName, len(topics)))
+	// most recent first
+	for i := len(topics) - 1; 0 <= i; i-- {
+		item := topics[i]
+		csNotice(rb, fmt.Sprintf(client.t("%[1]s set by %[2]s: %[3]s"), item.SetTime.UTC().Format(IRCv3TimestampFormat), item.SetBy, item.Topic))
+	}
+}
+
+func csChallengeHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channelName := params[0]
+	channelKey, err := CasefoldChannel(channelName)
+	if err != nil || !client.answerJoinChallenge(channelKey, params[1]) {
+		csNotice(rb, client.t("Incorrect challenge response"))
+		return
+	}
+	var key string
+	if 2 < len(params) {
+		key = params[2]
+	}
+	if err := server.channels.Join(client, channelName, key, false, rb); err != nil {
+		csNotice(rb, client.t(err.Error()))
+	}
+}
+
 // deterministically generates a confirmation code for unregistering a channel / account
 func unregisterConfirmationCode(name string, registeredAt time.Time) (code string) {
 	var codeInput bytes.Buffer
@@ -309,3 +796,119 @@ func unregisterConfirmationCode(name string, registeredAt time.Time) (code strin
 	codeInput.WriteString(strconv.FormatInt(registeredAt.Unix(), 16))
 	return strconv.Itoa(int(crc32.ChecksumIEEE(codeInput.Bytes())))
 }
+
+func csTransferHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	subcommand := strings.ToLower(params[0])
+	if subcommand == "accept" || subcommand == "cancel" {
+		csTransferRespond(server, client, subcommand, params[1], rb)
+		return
+	}
+
+	// registered channels can be transferred whether or not they're loaded
+	key, err := CasefoldChannel(params[0])
+	if err != nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	}
+	channelName := params[0]
+	if channel := server.channels.Get(key); channel != nil {
+		channelName = channel.Name()
+	}
+	founder, err := server.transferOwner(channelName)
+	if err != nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	} else if founder == "" {
+		csNotice(rb, client.t("Channel is not registered"))
+		return
+	}
+	isOverride := account != founder
+	if isOverride && !client.HasRoleCapabs("chanreg") {
+		csNotice(rb, client.t("You must be the channel founder to transfer it"))
+		return
+	}
+	recipient, err := nsTransferRecipient(server, params[1])
+	if err != nil {
+		csTransferError(server, client, err, rb)
+		return
+	} else if recipient == founder {
+		csNotice(rb, client.t(errTransferSameAccount.Error()))
+		return
+	}
+
+	if !isOverride {
+		server.transfers.Propose(key, channelName, account, recipient)
+		csNotice(rb, fmt.Sprintf(client.t("The transfer of %[1]s to %[2]s will take effect when they accept it"), channelName, recipient))
+		server.notifyAccount("ChanServ", recipient, func(c *Client) string {
+			return fmt.Sprintf(c.t("The account %[1]s wants to transfer %[2]s to you. To accept, type: /CS TRANSFER ACCEPT %[2]s"), account, channelName)
+		})
+		server.logger.Info("audit", fmt.Sprintf("Founder %s proposed transferring channel %s to %s", account, channelName, recipient))
+		return
+	}
+
+	// opers can transfer channels without anyone's agreement
+	err = server.performTransfer(channelName, founder, recipient)
+	if err != nil {
+		csTransferError(server, client, err, rb)
+		return
+	}
+	csNotice(rb, fmt.Sprintf(client.t("Transferred %[1]s from %[2]s to %[3]s"), channelName, founder, recipient))
+	for _, notified := range []string{founder, recipient} {
+		server.notifyAccount("ChanServ", notified, func(c *Client) string {
+			return fmt.Sprintf(c.t("An operator transferred %[1]s from %[2]s to %[3]s"), channelName, founder, recipient)
+		})
+	}
+	server.logger.Info("audit", fmt.Sprintf("Oper %s transferred channel %s from %s to %s", client.Oper().Name, channelName, founder, recipient))
+	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Oper $c[grey][$r%s$c[grey]] transferred channel $c[grey][$r%s$c[grey]] from $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), client.NickMaskString(), channelName, founder, recipient))
+}
+
+// csTransferRespond accepts or cancels a proposed transfer of a channel.
+func csTransferRespond(server *Server, client *Client, subcommand, name string, rb *ResponseBuffer) {
+	account := client.Account()
+	key, err := CasefoldChannel(name)
+	if err != nil {
+		csNotice(rb, client.t(errTransferNotPending.Error()))
+		return
+	}
+	transfer, err := server.transfers.Remove(key, account, subcommand == "accept")
+	if err != nil {
+		csNotice(rb, client.t(err.Error()))
+		return
+	}
+
+	if subcommand == "cancel" {
+		csNotice(rb, fmt.Sprintf(client.t("Cancelled the transfer of %s"), transfer.name))
+		other := transfer.to
+		if account == transfer.to {
+			other = transfer.from
+		}
+		server.notifyAccount("ChanServ", other, func(c *Client) string {
+			return fmt.Sprintf(c.t("The transfer of %s was cancelled"), transfer.name)
+		})
+		server.logger.Info("audit", fmt.Sprintf("Account %s cancelled the transfer of channel %s from %s to %s", account, transfer.name, transfer.from, transfer.to))
+		return
+	}
+
+	err = server.performTransfer(transfer.name, transfer.from, transfer.to)
+	if err != nil {
+		csTransferError(server, client, err, rb)
+		return
+	}
+	csNotice(rb, fmt.Sprintf(client.t("You are now the founder of %s"), transfer.name))
+	server.notifyAccount("ChanServ", transfer.from, func(c *Client) string {
+		return fmt.Sprintf(c.t("%[1]s now belongs to the account %[2]s"), transfer.name, transfer.to)
+	})
+	server.logger.Info("audit", fmt.Sprintf("Channel %s was transferred from %s to %s", transfer.name, transfer.from, transfer.to))
+	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Channel $c[grey][$r%s$c[grey]] transferred from $c[grey][$r%s$c[grey]] to $c[grey][$r%s$c[grey]]"), transfer.name, transfer.from, transfer.to))
+}
+
+func csTransferError(server *Server, client *Client, err error, rb *ResponseBuffer) {
+	switch err {
+	case errTransferNotOwned, errTransferNoSuchChannel, errAccountDoesNotExist:
+		csNotice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "couldn't transfer channel", err.Error())
+		csNotice(rb, client.t("An error occurred"))
+	}
+}
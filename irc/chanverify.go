@@ -0,0 +1,201 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// Project channels can prove that they belong to the project's domain: the
+// founder gets a token from ChanServ VERIFY and publishes it, either in a DNS
+// TXT record on the domain or in a file under the domain's /.well-known/; once
+// ChanServ has checked it, the channel shows the domain as verified (in LIST
+// and in the API's channel info).
+
+const (
+	// TXT records of the form oragono-verification=<token>
+	channelVerificationTXTPrefix = "oragono-verification="
+	// served at https://<domain><channelVerificationPath>
+	channelVerificationPath = "/.well-known/oragono-verification.txt"
+	// larger verification pages are rejected
+	channelVerificationMaxSize = 4096
+
+	defaultChannelVerificationTimeout = 10 * time.Second
+)
+
+var (
+	errInvalidVerificationDomain = errors.New("Invalid domain name")
+	errVerificationTokenNotFound = errors.New("The verification token was not found")
+)
+
+// ChannelVerificationConfig controls ChanServ VERIFY.
+type ChannelVerificationConfig struct {
+	Enabled bool
+	// how long to wait for DNS and HTTP responses
+	Timeout time.Duration
+}
+
+func (conf *ChannelVerificationConfig) prepare() error {
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultChannelVerificationTimeout
+	}
+	return nil
+}
+
+// ChannelVerification is the state of a channel's domain verification: a
+// pending token for Domain, or a completed verification if VerifiedAt is set.
+type ChannelVerification struct {
+	Domain     string
+	Token      string    `json:",omitempty"`
+	VerifiedAt time.Time `json:",omitempty"`
+}
+
+// VerifiedDomain returns the domain, if it has been verified.
+func (v *ChannelVerification) VerifiedDomain() string {
+	if v.VerifiedAt.IsZero() {
+		return ""
+	}
+	return v.Domain
+}
+
+// normalizeVerificationDomain validates and lowercases a domain name.
+func normalizeVerificationDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if len(domain) == 0 || 253 < len(domain) || !strings.Contains(domain, ".") {
+		return "", errInvalidVerificationDomain
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || 63 < len(label) || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", errInvalidVerificationDomain
+		}
+		for _, r := range label {
+			if !(('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '-') {
+				return "", errInvalidVerificationDomain
+			}
+		}
+	}
+	return domain, nil
+}
+
+// txtRecordsContainToken returns whether one of the TXT records publishes the token.
+func txtRecordsContainToken(records []string, token string) bool {
+	for _, record := range records {
+		if strings.TrimSpace(record) == channelVerificationTXTPrefix+token {
+			return true
+		}
+	}
+	return false
+}
+
+// pageContainsToken returns whether a verification page publishes the token
+// (on a line of its own).
+func pageContainsToken(page []byte, token string) bool {
+	for _, line := range strings.Split(string(page), "\n") {
+		if strings.TrimSpace(line) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDomainVerification looks for the token in the domain's TXT records,
+// then on its verification page, returning where it was found.
+func checkDomainVerification(domain, token string, timeout time.Duration) (method string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	records, _ := net.DefaultResolver.LookupTXT(ctx, domain)
+	if txtRecordsContainToken(records, token) {
+		return "dns", nil
+	}
+
+	client := http.Client{Timeout: timeout}
+	response, err := client.Get("https://" + domain + channelVerificationPath)
+	if err != nil {
+		return "", errVerificationTokenNotFound
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", errVerificationTokenNotFound
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(response.Body, channelVerificationMaxSize))
+	if err != nil || !pageContainsToken(page, token) {
+		return "", errVerificationTokenNotFound
+	}
+	return "web", nil
+}
+
+func csVerifyHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	channel := server.channels.Get(params[0])
+	if channel == nil {
+		csNotice(rb, client.t("Channel does not exist"))
+		return
+	}
+	founder := channel.Founder()
+	if founder == "" {
+		csNotice(rb, client.t("Channel is not registered"))
+		return
+	} else if client.Account() != founder {
+		csNotice(rb, client.t("You must be the channel founder to verify it"))
+		return
+	}
+	channelName := channel.Name()
+	verification := channel.Verification()
+
+	if len(params) == 1 {
+		if domain := verification.VerifiedDomain(); domain != "" {
+			csNotice(rb, fmt.Sprintf(client.t("%[1]s is verified as belonging to %[2]s"), channelName, domain))
+		} else if verification.Domain != "" {
+			csNotice(rb, fmt.Sprintf(client.t("Verification of %[1]s for %[2]s is pending"), verification.Domain, channelName))
+		} else {
+			csNotice(rb, fmt.Sprintf(client.t("%s is not verified"), channelName))
+		}
+		return
+	}
+
+	switch strings.ToLower(params[1]) {
+	case "check":
+		if verification.Token == "" {
+			csNotice(rb, fmt.Sprintf(client.t("There's no pending verification for %s"), channelName))
+			return
+		}
+		method, err := checkDomainVerification(verification.Domain, verification.Token, server.Config().Channels.Registration.Verification.Timeout)
+		if err != nil {
+			csNotice(rb, fmt.Sprintf(client.t("Couldn't verify %[1]s: %[2]s"), verification.Domain, client.t(err.Error())))
+			return
+		}
+		verification.Token = ""
+		verification.VerifiedAt = time.Now().UTC()
+		channel.setVerification(verification)
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		server.logger.Info("services", fmt.Sprintf("Client %s verified %s for channel %s (%s)", client.Nick(), verification.Domain, channelName, method))
+		csNotice(rb, fmt.Sprintf(client.t("%[1]s is now verified as belonging to %[2]s"), channelName, verification.Domain))
+	case "clear":
+		channel.setVerification(ChannelVerification{})
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		csNotice(rb, fmt.Sprintf(client.t("Removed the verification of %s"), channelName))
+	default:
+		domain, err := normalizeVerificationDomain(params[1])
+		if err != nil {
+			csNotice(rb, client.t(err.Error()))
+			return
+		}
+		token := utils.GenerateSecretToken()
+		channel.setVerification(ChannelVerification{Domain: domain, Token: token})
+		server.channelRegistry.StoreChannel(channel, IncludeSettings)
+		csNotice(rb, fmt.Sprintf(client.t("To verify that %[1]s belongs to %[2]s, publish this token in one of these ways:"), channelName, domain))
+		csNotice(rb, fmt.Sprintf(client.t("1. a DNS TXT record on %[1]s, containing: %[2]s%[3]s"), domain, channelVerificationTXTPrefix, token))
+		csNotice(rb, fmt.Sprintf(client.t("2. a line of https://%[1]s%[2]s, containing: %[3]s"), domain, channelVerificationPath, token))
+		csNotice(rb, fmt.Sprintf(client.t("Then type: /CS VERIFY %s CHECK"), channelName))
+	}
+}
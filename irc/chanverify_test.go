@@ -0,0 +1,54 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeVerificationDomain(t *testing.T) {
+	valid := map[string]string{
+		"Example.com":        "example.com",
+		"irc.example.org.":   "irc.example.org",
+		"my-project.example": "my-project.example",
+	}
+	for input, expected := range valid {
+		if domain, err := normalizeVerificationDomain(input); err != nil || domain != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", input, expected, domain, err)
+		}
+	}
+	for _, input := range []string{"", "localhost", "example..com", "-bad.com", "exa mple.com", "example.com/path"} {
+		if _, err := normalizeVerificationDomain(input); err == nil {
+			t.Errorf("%s should be rejected", input)
+		}
+	}
+}
+
+func TestVerificationTokenMatching(t *testing.T) {
+	token := "abc123"
+	if !txtRecordsContainToken([]string{"v=spf1 -all", "oragono-verification=abc123"}, token) {
+		t.Errorf("TXT record with the token should match")
+	}
+	if txtRecordsContainToken([]string{"oragono-verification=abc1234"}, token) {
+		t.Errorf("TXT record with a different token should not match")
+	}
+	if !pageContainsToken([]byte("# channel verification\r\nabc123\r\n"), token) {
+		t.Errorf("page with the token on a line should match")
+	}
+	if pageContainsToken([]byte("xabc123"), token) {
+		t.Errorf("page without the token on a line of its own should not match")
+	}
+}
+
+func TestVerifiedDomain(t *testing.T) {
+	verification := ChannelVerification{Domain: "example.com", Token: "abc123"}
+	if verification.VerifiedDomain() != "" {
+		t.Errorf("pending verification should not count as verified")
+	}
+	verification.VerifiedAt = time.Now()
+	if verification.VerifiedDomain() != "example.com" {
+		t.Errorf("completed verification should be reported")
+	}
+}
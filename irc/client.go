@@ -9,26 +9,22 @@ import (
 	"fmt"
 	"net"
 	"runtime/debug"
-	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/goshuirc/irc-go/ircfmt"
 	"github.com/goshuirc/irc-go/ircmsg"
-	ident "github.com/oragono/go-ident"
 	"github.com/oragono/oragono/irc/caps"
 	"github.com/oragono/oragono/irc/connection_limits"
 	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/modes"
 	"github.com/oragono/oragono/irc/sno"
 	"github.com/oragono/oragono/irc/utils"
 )
 
 const (
-	// IdentTimeoutSeconds is how many seconds before our ident (username) check times out.
-	IdentTimeoutSeconds  = 1.5
 	IRCv3TimestampFormat = "2006-01-02T15:04:05.000Z"
 )
 
@@ -42,60 +38,96 @@ type ResumeDetails struct {
 	ResumedAt         time.Time
 	Channels          []string
 	HistoryIncomplete bool
+	// whether the old client was marked away by BRB, and should be marked unaway
+	ClearAway bool
 }
 
 // Client is an IRC client.
 type Client struct {
-	account            string
-	accountName        string // display name of the account: uncasefolded, '*' if not logged in
-	atime              time.Time
-	awayMessage        string
-	capabilities       *caps.Set
-	capState           caps.State
-	capVersion         caps.Version
-	certfp             string
-	channels           ChannelSet
-	ctime              time.Time
-	exitedSnomaskSent  bool
-	fakelag            Fakelag
-	flags              *modes.ModeSet
-	hasQuit            bool
-	hops               int
-	hostname           string
-	idletimer          IdleTimer
-	invitedTo          map[string]bool
-	isDestroyed        bool
-	isTor              bool
-	isQuitting         bool
-	languages          []string
-	loginThrottle      connection_limits.GenericThrottle
-	maxlenRest         uint32
-	nick               string
-	nickCasefolded     string
-	nickMaskCasefolded string
-	nickMaskString     string // cache for nickmask string since it's used with lots of replies
-	nickTimer          NickTimer
-	oper               *Oper
-	preregNick         string
-	proxiedIP          net.IP // actual remote IP if using the PROXY protocol
-	quitMessage        string
-	rawHostname        string
-	realname           string
-	realIP             net.IP
-	registered         bool
-	resumeDetails      *ResumeDetails
-	resumeID           string
-	saslInProgress     bool
-	saslMechanism      string
-	saslValue          string
-	sentPassCommand    bool
-	server             *Server
-	skeleton           string
-	socket             *Socket
-	stateMutex         sync.RWMutex // tier 1
-	username           string
-	vhost              string
-	history            *history.Buffer
+	accepted            map[string]bool
+	account             string
+	accountName         string // display name of the account: uncasefolded, '*' if not logged in
+	accountRegisteredAt time.Time
+	accountSettings     AccountSettings
+	apiToken            *APIToken // the API token the client logged in with, if any
+	atime               time.Time
+	autoAwayDuration    time.Duration
+	autoAwaySet         bool // whether the client was marked away by auto-away
+	autoAwayTimer       *utils.WheelTimer
+	awayMessage         string
+	brbAwaySet          bool // whether the client was marked away by BRB
+	brbReason           string
+	brbTime             time.Time
+	brbTimer            *utils.WheelTimer
+	callerIDNotified    time.Time
+	capabilities        *caps.Set
+	capState            caps.State
+	capVersion          caps.Version
+	certfp              string
+	channels            ChannelSet
+	connectClass        string // assigned by the connect rules
+	ctcp                ctcpState
+	ctime               time.Time
+	deviceID            string                   // identifies the device for read markers, see readmarker.go
+	dmRejected          map[string]time.Time     // keys of rejected message requests, and when they were rejected
+	dmRequests          map[string][]heldMessage // held messages from strangers, see dmrequests.go
+	entryMsgsSent       map[string]time.Time
+	exitedSnomaskSent   bool
+	fakelag             Fakelag
+	flags               *modes.ModeSet
+	hasQuit             bool
+	hops                int
+	hostname            string
+	identDone           chan struct{} // closed when the ident lookup completes
+	identUsername       string
+	idletimer           IdleTimer
+	invitedTo           map[string]bool
+	joinChallenges      map[string]*joinChallenge
+	isDestroyed         bool
+	isTor               bool
+	isQuitting          bool
+	languages           []string
+	lastKnock           time.Time
+	lastNickChange      time.Time
+	loginThrottle       connection_limits.GenericThrottle
+	maxlenRest          uint32
+	metadata            map[string]string // used while not logged in, see metadata.go
+	metadataSubs        map[string]bool
+	nick                string
+	persona             string // the persona the client is attached to, see persona.go
+	nickCasefolded      string
+	nickMaskCasefolded  string
+	nickMaskString      string // cache for nickmask string since it's used with lots of replies
+	nickTimer           NickTimer
+	oper                *Oper
+	pmTargets           map[string]time.Time // recent direct message targets, for clients on probation
+	pmLimits            pmLimitState
+	pendingAnnounce     *pendingAnnouncement
+	reportThrottle      connection_limits.GenericThrottle
+	operChallenge       *operChallenge
+	preregNick          string
+	proxiedIP           net.IP // actual remote IP if using the PROXY protocol
+	quitMessage         string
+	rawHostname         string
+	realname            string
+	realIP              net.IP
+	requireSasl         bool // whether the client's listener requires SASL
+	ipInfo              IPInfo
+	registered          bool
+	resumeDetails       *ResumeDetails
+	resumeID            string
+	saslInProgress      bool
+	saslMechanism       string
+	saslValue           string
+	sentPassCommand     bool
+	server              *Server
+	shardKey            uint32 // determines which shard of a MemberSet holds the client
+	skeleton            string
+	socket              *Socket
+	stateMutex          lockorder.Tier1RWMutex
+	username            string
+	vhost               string
+	history             *history.Buffer
 }
 
 // WhoWas is the subset of client details needed to answer a WHOWAS query
@@ -105,6 +137,13 @@ type WhoWas struct {
 	username       string
 	hostname       string
 	realname       string
+	ip             net.IP
+	account        string
+	accountName    string
+
+	// these are only set for entries in the WHOWAS list:
+	reason string    // the quit message, or "" for a nick change
+	time   time.Time // when the nick stopped being used
 }
 
 // ClientDetails is a standard set of details about a client
@@ -113,17 +152,13 @@ type ClientDetails struct {
 
 	nickMask           string
 	nickMaskCasefolded string
-	account            string
-	accountName        string
 }
 
 // NewClient sets up a new client and runs its goroutine.
 func RunNewClient(server *Server, conn clientConn) {
 	now := time.Now()
 	config := server.Config()
-	fullLineLenLimit := ircmsg.MaxlenTagsFromClient + config.Limits.LineLen.Rest
-	// give them 1k of grace over the limit:
-	socket := NewSocket(conn.Conn, fullLineLenLimit+1024, config.Server.MaxSendQBytes)
+	socket := NewSocket(conn.Conn, connectionRecvQBytes(config), config.Server.MaxSendQBytes, config.Server.WriteTimeout)
 	client := &Client{
 		atime:        now,
 		capabilities: caps.NewSet(),
@@ -133,6 +168,7 @@ func RunNewClient(server *Server, conn clientConn) {
 		ctime:        now,
 		flags:        modes.NewModeSet(),
 		isTor:        conn.IsTor,
+		requireSasl:  conn.RequireSasl,
 		languages:    server.Languages().Default(),
 		loginThrottle: connection_limits.GenericThrottle{
 			Duration: config.Accounts.LoginThrottling.Duration,
@@ -145,14 +181,23 @@ func RunNewClient(server *Server, conn clientConn) {
 		nickCasefolded: "*",
 		nickMaskString: "*", // * is used until actual nick is given
 		history:        history.NewHistoryBuffer(config.History.ClientLength),
+		shardKey:       atomic.AddUint32(&clientShardCounter, 1),
 	}
 
 	client.recomputeMaxlens()
+	server.stats.Add()
 
 	if conn.IsTLS {
 		client.SetMode(modes.TLS, true)
-		// error is not useful to us here anyways so we can ignore it
-		client.certfp, _ = client.socket.CertFP()
+		var err error
+		client.certfp, err = client.socket.CertFP()
+		// a handshake failure is only interesting if the listener requires
+		// client certificates; otherwise the error is not useful to us
+		if err != nil && conn.RequireClientCert {
+			server.logger.Info("localconnect-ip", fmt.Sprintf("Client from %v rejected for lacking a valid TLS client certificate: %v", utils.AddrToIP(conn.Conn.RemoteAddr()), err))
+			// the client goroutine will fail to read from the socket and clean up
+			socket.Close()
+		}
 	}
 
 	if conn.IsTor {
@@ -164,60 +209,45 @@ func RunNewClient(server *Server, conn clientConn) {
 		client.realIP = utils.AddrToIP(remoteAddr)
 		// Set the hostname for this client
 		// (may be overridden by a later PROXY command from stunnel)
-		client.rawHostname = utils.LookupHostname(client.realIP.String())
-		if config.Server.CheckIdent && !utils.AddrIsUnix(remoteAddr) {
-			client.doIdentLookup(conn.Conn)
+		client.rawHostname = server.hostnames.Lookup(client.realIP, config.Server.ReverseDNS)
+		if conn.CheckIdent && !utils.AddrIsUnix(remoteAddr) {
+			client.startIdentLookup(conn.Conn, config.Server.Ident.Timeout)
 		}
 	}
 
 	client.run()
 }
 
-func (client *Client) doIdentLookup(conn net.Conn) {
-	_, serverPortString, err := net.SplitHostPort(conn.LocalAddr().String())
-	if err != nil {
-		client.server.logger.Error("internal", "bad server address", err.Error())
-		return
-	}
-	serverPort, _ := strconv.Atoi(serverPortString)
-	clientHost, clientPortString, err := net.SplitHostPort(conn.RemoteAddr().String())
-	if err != nil {
-		client.server.logger.Error("internal", "bad client address", err.Error())
-		return
-	}
-	clientPort, _ := strconv.Atoi(clientPortString)
-
-	client.Notice(client.t("*** Looking up your username"))
-	resp, err := ident.Query(clientHost, serverPort, clientPort, IdentTimeoutSeconds)
-	if err == nil {
-		err := client.SetNames(resp.Identifier, "", true)
-		if err == nil {
-			client.Notice(client.t("*** Found your username"))
-			// we don't need to updateNickMask here since nickMask is not used for anything yet
-		} else {
-			client.Notice(client.t("*** Got a malformed username, ignoring"))
-		}
-	} else {
-		client.Notice(client.t("*** Could not find your username"))
-	}
-}
-
-func (client *Client) isAuthorized(config *Config) bool {
+// isAuthorized returns nil if the client may complete registration, otherwise
+// errBadPassword or errSaslRequired.
+func (client *Client) isAuthorized(config *Config) error {
 	saslSent := client.account != ""
 	// PASS requirement
 	if (config.Server.passwordBytes != nil) && !client.sentPassCommand && !(config.Accounts.SkipServerPassword && saslSent) {
-		return false
+		return errBadPassword
 	}
-	// Tor connections may be required to authenticate with SASL
-	if client.isTor && config.Server.TorListeners.RequireSasl && !saslSent {
-		return false
+	if saslSent {
+		return nil
+	}
+	// connections to some listeners may be required to authenticate with SASL
+	// (tor-listeners.require-sasl is a connect rule, see connectrules.go)
+	if client.requireSasl && !utils.IPInNets(client.IP(), config.Server.SaslListeners.exemptedNets) {
+		return errSaslRequired
 	}
-	// finally, enforce require-sasl
-	return !config.Accounts.RequireSasl.Enabled || saslSent || utils.IPInNets(client.IP(), config.Accounts.RequireSasl.exemptedNets)
+	// so may connections from some countries or ASNs
+	if config.Server.GeoIP.RequiresSasl(client.ipInfo) {
+		return errSaslRequired
+	}
+	// finally, DEFCON may force require-sasl on (the setting itself is
+	// enforced by a connect rule)
+	if client.server.defcon.Level() <= DefconRequireSasl && !utils.IPInNets(client.IP(), config.Accounts.RequireSasl.exemptedNets) {
+		return errSaslRequired
+	}
+	return nil
 }
 
 func (client *Client) resetFakelag() {
-	var flc FakelagConfig = client.server.Config().Fakelag
+	var flc FakelagConfig = client.connectionClass().Fakelag
 	flc.Enabled = flc.Enabled && !client.HasRoleCapabs("nofakelag")
 	client.fakelag.Initialize(flc)
 }
@@ -235,11 +265,7 @@ func (client *Client) IP() net.IP {
 
 // IPString returns the IP address of this client as a string.
 func (client *Client) IPString() string {
-	ip := client.IP().String()
-	if 0 < len(ip) && ip[0] == ':' {
-		ip = "0" + ip
-	}
-	return ip
+	return utils.IPStringToHostname(client.IP().String())
 }
 
 //
@@ -279,8 +305,13 @@ func (client *Client) run() {
 				panic(r)
 			}
 		}
-		// ensure client connection gets closed
-		client.destroy(false)
+		if client.isBrb() {
+			// keep the session alive for a resume; the BRB timer will destroy it
+			client.socket.Close()
+		} else {
+			// ensure client connection gets closed
+			client.destroy(false)
+		}
 	}()
 
 	client.idletimer.Initialize(client)
@@ -299,6 +330,8 @@ func (client *Client) run() {
 			quitMessage := "connection closed"
 			if err == errReadQ {
 				quitMessage = "readQ exceeded"
+			} else if closeReason := client.socket.CloseReason(); closeReason != nil {
+				quitMessage = closeReason.Error()
 			}
 			client.Quit(quitMessage)
 			break
@@ -321,6 +354,9 @@ func (client *Client) run() {
 			}
 		}
 
+		var utf8OK bool
+		line, utf8OK = client.server.Config().Server.UTF8Only.processLine(line)
+
 		msg, err = ircmsg.ParseLineStrict(line, true, maxlenRest)
 		if err == ircmsg.ErrorLineIsEmpty {
 			continue
@@ -332,18 +368,23 @@ func (client *Client) run() {
 			break
 		}
 
+		if !utf8OK {
+			client.sendLabeledReply(msg, ERR_UNKNOWNERROR, client.nick, msg.Command, client.t("Message rejected: input is not valid UTF-8"))
+			continue
+		}
+
 		cmd, exists := Commands[msg.Command]
 		if !exists {
 			if len(msg.Command) > 0 {
-				client.Send(nil, client.server.name, ERR_UNKNOWNCOMMAND, client.nick, msg.Command, client.t("Unknown command"))
+				client.sendLabeledReply(msg, ERR_UNKNOWNCOMMAND, client.nick, msg.Command, client.t("Unknown command"))
 			} else {
-				client.Send(nil, client.server.name, ERR_UNKNOWNCOMMAND, client.nick, "lastcmd", client.t("No command given"))
+				client.sendLabeledReply(msg, ERR_UNKNOWNCOMMAND, client.nick, "lastcmd", client.t("No command given"))
 			}
 			continue
 		}
 
 		isExiting = cmd.Run(client.server, client, msg)
-		if isExiting || client.isQuitting {
+		if isExiting || client.IsQuitting() {
 			break
 		}
 	}
@@ -356,8 +397,13 @@ func (client *Client) run() {
 // Active updates when the client was last 'active' (i.e. the user should be sitting in front of their client).
 func (client *Client) Active() {
 	client.stateMutex.Lock()
-	defer client.stateMutex.Unlock()
 	client.atime = time.Now()
+	autoAwaySet := client.autoAwaySet
+	client.stateMutex.Unlock()
+
+	if autoAwaySet {
+		client.clearAutoAway()
+	}
 }
 
 // Ping sends the client a PING message.
@@ -377,19 +423,13 @@ func (client *Client) tryResume() (success bool) {
 		}
 	}()
 
-	timestamp := client.resumeDetails.Timestamp
-	var timestampString string
-	if !timestamp.IsZero() {
-		timestampString = timestamp.UTC().Format(IRCv3TimestampFormat)
-	}
-
-	oldClient := server.resumeManager.VerifyToken(client.resumeDetails.PresentedToken)
+	// the token is only consumed once the resume is known to be allowed, so
+	// that a failed attempt leaves a BRB'd client to its timer
+	oldClient := server.resumeManager.LookupToken(client.resumeDetails.PresentedToken)
 	if oldClient == nil {
 		client.Send(nil, server.name, "RESUME", "ERR", client.t("Cannot resume connection, token is not valid"))
 		return
 	}
-	oldNick := oldClient.Nick()
-	oldNickmask := oldClient.NickMaskString()
 
 	resumeAllowed := config.Server.AllowPlaintextResume || (oldClient.HasMode(modes.TLS) && client.HasMode(modes.TLS))
 	if !resumeAllowed {
@@ -402,14 +442,38 @@ func (client *Client) tryResume() (success bool) {
 		return
 	}
 
+	if !server.resumeManager.Delete(oldClient) {
+		client.Send(nil, server.name, "RESUME", "ERR", client.t("Cannot resume connection, token is not valid"))
+		return
+	}
+
+	oldNick := oldClient.Nick()
+	oldNickmask := oldClient.NickMaskString()
 	err := server.clients.Resume(client, oldClient)
 	if err != nil {
 		client.Send(nil, server.name, "RESUME", "ERR", client.t("Cannot resume connection"))
+		// the token is gone, so nothing else would clean up a BRB'd client
+		if oldClient.isBrb() {
+			oldClient.destroy(false)
+		}
 		return
 	}
 
 	success = true
 
+	// if the old client sent BRB, we know what it missed even if the new one doesn't
+	brbTime, brbAwaySet := oldClient.stopBrb()
+	if client.resumeDetails.Timestamp.IsZero() {
+		client.resumeDetails.Timestamp = brbTime
+	}
+	client.resumeDetails.ClearAway = brbAwaySet
+
+	timestamp := client.resumeDetails.Timestamp
+	var timestampString string
+	if !timestamp.IsZero() {
+		timestampString = timestamp.UTC().Format(IRCv3TimestampFormat)
+	}
+
 	// this is a bit racey
 	client.resumeDetails.ResumedAt = time.Now()
 
@@ -489,6 +553,8 @@ func (client *Client) tryResume() (success bool) {
 	}
 
 	client.Send(nil, client.server.name, "RESUME", "SUCCESS", oldNick)
+	// tokens are single-use, so issue a fresh one for the next resume
+	client.Send(nil, client.server.name, "RESUME", "TOKEN", server.resumeManager.RotateToken(client))
 
 	// after we send the rest of the registration burst, we'll try rejoining channels
 	return
@@ -496,20 +562,34 @@ func (client *Client) tryResume() (success bool) {
 
 func (client *Client) tryResumeChannels() {
 	details := client.resumeDetails
+	config := client.server.Config().Server.JoinBurst
 
-	channels := make([]*Channel, len(details.Channels))
+	// send the state of all the channels as a single burst
+	channels := make([]*Channel, 0, len(details.Channels))
+	rb := NewResponseBuffer(client)
+	startJoinBurst(client, rb)
 	for _, name := range details.Channels {
 		channel := client.server.channels.Get(name)
 		if channel == nil {
 			continue
 		}
-		channel.Resume(client, details.OldClient, details.Timestamp)
+		channel.Resume(client, details.OldClient, config.DeferNames, rb)
 		channels = append(channels, channel)
+		flushJoinBurst(rb, len(channels), config.ChunkSize)
 	}
+	rb.Send(true)
 
-	// replay direct PRIVSMG history
-	if !details.Timestamp.IsZero() {
+	if details.ClearAway {
+		client.changeAway(false, "")
+	}
+
+	if !details.Timestamp.IsZero() && !client.AccountSettings().DisableHistoryReplay {
 		now := time.Now()
+		// replay channel history
+		for _, channel := range channels {
+			channel.replayHistoryForResume(client, details.Timestamp, now)
+		}
+		// replay direct PRIVSMG history
 		items, complete := client.history.Between(details.Timestamp, now, false, 0)
 		rb := NewResponseBuffer(client)
 		client.replayPrivmsgHistory(rb, items, complete)
@@ -539,7 +619,7 @@ func (client *Client) replayPrivmsgHistory(rb *ResponseBuffer, items []history.I
 		rb.AddSplitMessageFromClient(item.Nick, item.AccountName, tags, command, nick, item.Message)
 	}
 	if !complete {
-		rb.Add(nil, "HistServ", "NOTICE", nick, client.t("Some additional message history may have been lost"))
+		rb.AddSplitMessageFromServer("HistServ", "NOTICE", nick, utils.MakeSplitMessage(client.t("Some additional message history may have been lost")))
 	}
 }
 
@@ -553,7 +633,16 @@ func (client *Client) copyResumeData(oldClient *Client) {
 	vhost := oldClient.vhost
 	account := oldClient.account
 	accountName := oldClient.accountName
+	accountRegisteredAt := oldClient.accountRegisteredAt
+	accountSettings := oldClient.accountSettings
+	apiToken := oldClient.apiToken
 	skeleton := oldClient.skeleton
+	accepted := oldClient.accepted
+	dmRequests := oldClient.dmRequests
+	dmRejected := oldClient.dmRejected
+	metadata := oldClient.metadata
+	metadataSubs := oldClient.metadataSubs
+	awayMessage := oldClient.awayMessage
 	oldClient.stateMutex.RUnlock()
 
 	// copy all flags, *except* TLS (in the case that the admins enabled
@@ -575,7 +664,16 @@ func (client *Client) copyResumeData(oldClient *Client) {
 	client.vhost = vhost
 	client.account = account
 	client.accountName = accountName
+	client.accountRegisteredAt = accountRegisteredAt
+	client.accountSettings = accountSettings
+	client.apiToken = apiToken
 	client.skeleton = skeleton
+	client.accepted = accepted
+	client.dmRequests = dmRequests
+	client.dmRejected = dmRejected
+	client.metadata = metadata
+	client.metadataSubs = metadataSubs
+	client.awayMessage = awayMessage
 	client.updateNickMaskNoMutex()
 }
 
@@ -610,12 +708,10 @@ func (client *Client) HasUsername() bool {
 	return client.username != "" && client.username != "*"
 }
 
-// SetNames sets the client's ident and realname.
-func (client *Client) SetNames(username, realname string, fromIdent bool) error {
-	limit := client.server.Config().Limits.IdentLen
-	if !fromIdent {
-		limit -= 1 // leave room for the prepended ~
-	}
+// SetNames sets the client's username (with the ~ prefix, since it's
+// unverified; see waitForIdent) and realname.
+func (client *Client) SetNames(username, realname string) error {
+	limit := client.server.Config().Limits.IdentLen - 1 // leave room for the prepended ~
 	if limit < len(username) {
 		username = username[:limit]
 	}
@@ -624,9 +720,7 @@ func (client *Client) SetNames(username, realname string, fromIdent bool) error
 		return errInvalidUsername
 	}
 
-	if !fromIdent {
-		username = "~" + username
-	}
+	username = "~" + username
 
 	client.stateMutex.Lock()
 	defer client.stateMutex.Unlock()
@@ -854,6 +948,8 @@ func (client *Client) destroy(beingResumed bool) {
 	client.stateMutex.Lock()
 	isDestroyed := client.isDestroyed
 	client.isDestroyed = true
+	registered := client.registered
+	invisible, operator := client.statsModes()
 	quitMessage := client.quitMessage
 	nickMaskString := client.nickMaskString
 	accountName := client.accountName
@@ -878,7 +974,9 @@ func (client *Client) destroy(beingResumed bool) {
 	client.Quit("Connection closed")
 
 	if !beingResumed {
-		client.server.whoWas.Append(client.WhoWas())
+		whowas := client.WhoWas()
+		whowas.reason = quitMessage
+		client.server.whoWas.Append(whowas)
 	}
 
 	// remove from connection limits
@@ -897,14 +995,15 @@ func (client *Client) destroy(beingResumed bool) {
 
 	// clean up channels
 	friends := make(ClientSet)
-	for _, channel := range client.Channels() {
+	channels := client.Channels()
+	for _, channel := range channels {
 		if !beingResumed {
 			channel.Quit(client)
 			channel.history.Add(history.Item{
 				Type:        history.Quit,
 				Nick:        nickMaskString,
 				AccountName: accountName,
-				Message:     utils.MakeSplitMessage(quitMessage, true),
+				Message:     utils.MakeSplitMessage(quitMessage),
 			})
 		}
 		for _, member := range channel.Members() {
@@ -919,25 +1018,26 @@ func (client *Client) destroy(beingResumed bool) {
 	}
 
 	// clean up self
+	client.stopBrb()
 	client.idletimer.Stop()
 	client.nickTimer.Stop()
+	client.SetAutoAway(0)
 
+	if !beingResumed {
+		client.server.accounts.RecordQuit(client.Account())
+		client.recordDeviceQuit(channels)
+		client.savePersonaChannels(channels)
+	}
 	client.server.accounts.Logout(client)
 
 	client.socket.Close()
 
+	// a resumed client is removed from the statistics like any other, since
+	// its replacement was counted when it registered
+	client.server.stats.Remove(registered, invisible, operator)
+
 	// send quit messages to friends
 	if !beingResumed {
-		if client.Registered() {
-			client.server.stats.ChangeTotal(-1)
-		}
-		if client.HasMode(modes.Invisible) {
-			client.server.stats.ChangeInvisible(-1)
-		}
-		if client.HasMode(modes.Operator) || client.HasMode(modes.LocalOperator) {
-			client.server.stats.ChangeOperators(-1)
-		}
-
 		for friend := range friends {
 			if quitMessage == "" {
 				quitMessage = "Exited"
@@ -961,15 +1061,30 @@ func (client *Client) SendSplitMsgFromClient(from *Client, tags map[string]strin
 }
 
 func (client *Client) sendSplitMsgFromClientInternal(blocking bool, serverTime time.Time, nickmask, accountName string, tags map[string]string, command, target string, message utils.SplitMessage) {
-	if client.capabilities.Has(caps.MaxLine) || message.Wrapped == nil {
+	wrapped := client.wrapSplitMessage(nickmask, command, target, &message)
+	if wrapped == nil {
 		client.sendFromClientInternal(blocking, serverTime, message.Msgid, nickmask, accountName, tags, command, target, message.Message)
 	} else {
-		for _, messagePair := range message.Wrapped {
+		for _, messagePair := range wrapped {
 			client.sendFromClientInternal(blocking, serverTime, messagePair.Msgid, nickmask, accountName, tags, command, target, messagePair.Message)
 		}
 	}
 }
 
+// wrapSplitMessage returns nil if a message can be sent to the client as-is,
+// given the line length the client negotiated; otherwise it returns the message
+// wrapped to fit.
+func (client *Client) wrapSplitMessage(nickmask, command, target string, message *utils.SplitMessage) []utils.MessagePair {
+	// :nickmask COMMAND target :message\r\n
+	// (tags don't count, since they have their own budget; see lineBuilder)
+	overhead := len(nickmask) + len(command) + len(target) + 7
+	maxlenRest := client.MaxlenRest()
+	if message.Fits(overhead, maxlenRest) {
+		return nil
+	}
+	return message.Wrapped(maxlenRest - overhead)
+}
+
 // SendFromClient sends an IRC line coming from a specific client.
 // Adds account-tag to the line as well.
 func (client *Client) SendFromClient(msgid string, from *Client, tags map[string]string, command string, params ...string) error {
@@ -980,21 +1095,69 @@ func (client *Client) SendFromClient(msgid string, from *Client, tags map[string
 // for things like history replay and CHGHOST where they no longer (necessarily)
 // correspond to the current state of a client
 func (client *Client) sendFromClientInternal(blocking bool, serverTime time.Time, msgid string, nickmask, accountName string, tags map[string]string, command string, params ...string) error {
-	msg := ircmsg.MakeMessage(tags, nickmask, command, params...)
+	// this is the hot path for relaying messages, so the line is built directly,
+	// without an intermediate ircmsg.IrcMessage
+	lb := getLineBuilder()
+	defer lb.release()
+
+	addAccount := client.capabilities.Has(caps.AccountTag) && accountName != "*"
+	addMsgid := msgid != "" && client.capabilities.Has(caps.MessageTags)
+	addTime := client.capabilities.Has(caps.ServerTime)
+	for name, value := range tags {
+		// the tags we attach take precedence
+		if (addAccount && name == "account") || (addMsgid && name == "draft/msgid") || (addTime && name == "time") {
+			continue
+		}
+		lb.addTag(name, value)
+	}
 	// attach account-tag
-	if client.capabilities.Has(caps.AccountTag) && accountName != "*" {
-		msg.SetTag("account", accountName)
+	if addAccount {
+		lb.addTag("account", accountName)
 	}
 	// attach message-id
-	if msgid != "" && client.capabilities.Has(caps.MessageTags) {
-		msg.SetTag("draft/msgid", msgid)
+	if addMsgid {
+		lb.addTag("draft/msgid", msgid)
 	}
 	// attach server-time
-	if client.capabilities.Has(caps.ServerTime) {
+	if addTime {
+		if serverTime.IsZero() {
+			serverTime = time.Now()
+		}
+		lb.addTimeTag(serverTime)
+	}
+
+	return client.sendLine(lb, nickmask, command, params, blocking)
+}
+
+// attachServerTime attaches the server-time tag to a message generated by the
+// server itself (e.g., a numeric), if the client has negotiated it and the message
+// doesn't already have it. Messages from other clients get it in
+// sendFromClientInternal instead.
+func (client *Client) attachServerTime(msg *ircmsg.IrcMessage) {
+	if client.capabilities.Has(caps.ServerTime) && !msg.HasTag("time") {
 		msg.SetTag("time", time.Now().UTC().Format(IRCv3TimestampFormat))
 	}
+}
+
+// SendSplitMsgFromServer sends a PRIVMSG or NOTICE from the server or a service
+// (e.g., NickServ). Like a message from a client, it carries the SplitMessage's
+// msgid, so every copy of the message has the same one.
+func (client *Client) SendSplitMsgFromServer(source, command, target string, message utils.SplitMessage) {
+	client.sendSplitMsgFromClientInternal(false, time.Time{}, source, "*", nil, command, target, message)
+}
+
+// splitMessageCache makes one SplitMessage per distinct text, so that a server
+// message delivered to many clients, each in their own language, has the same
+// msgid for everyone who receives the same text.
+type splitMessageCache map[string]utils.SplitMessage
 
-	return client.SendRawMessage(msg, blocking)
+func (cache splitMessageCache) get(text string) utils.SplitMessage {
+	message, ok := cache[text]
+	if !ok {
+		message = utils.MakeSplitMessage(text)
+		cache[text] = message
+	}
+	return message
 }
 
 var (
@@ -1012,25 +1175,24 @@ var (
 
 // SendRawMessage sends a raw message to the client.
 func (client *Client) SendRawMessage(message ircmsg.IrcMessage, blocking bool) error {
-	// use dumb hack to force the last param to be a trailing param if required
-	var usedTrailingHack bool
-	if commandsThatMustUseTrailing[message.Command] && len(message.Params) > 0 {
-		lastParam := message.Params[len(message.Params)-1]
-		// to force trailing, we ensure the final param contains a space
-		if strings.IndexByte(lastParam, ' ') == -1 {
-			message.Params[len(message.Params)-1] = lastParam + " "
-			usedTrailingHack = true
-		}
+	lb := getLineBuilder()
+	defer lb.release()
+	for name, value := range message.AllTags() {
+		lb.addTag(name, value)
 	}
+	return client.sendLine(lb, message.Prefix, message.Command, message.Params, blocking)
+}
 
-	// assemble message
-	maxlenRest := client.MaxlenRest()
-	line, err := message.LineBytesStrict(false, maxlenRest)
+// sendLine finishes assembling a line whose tags are already in `lb`, and sends it.
+func (client *Client) sendLine(lb *lineBuilder, prefix, command string, params []string, blocking bool) error {
+	// some clients treat trailing params specially, so force them where required
+	forceTrailing := commandsThatMustUseTrailing[command]
+	line, err := lb.finish(prefix, command, params, forceTrailing, client.MaxlenRest())
 	if err != nil {
 		logline := fmt.Sprintf("Error assembling message for sending: %v\n%s", err, debug.Stack())
 		client.server.logger.Error("internal", logline)
 
-		message = ircmsg.MakeMessage(nil, client.server.name, ERR_UNKNOWNERROR, "*", "Error assembling message for sending")
+		message := ircmsg.MakeMessage(nil, client.server.name, ERR_UNKNOWNERROR, "*", "Error assembling message for sending")
 		line, _ := message.LineBytesStrict(false, 0)
 
 		if blocking {
@@ -1041,12 +1203,6 @@ func (client *Client) SendRawMessage(message ircmsg.IrcMessage, blocking bool) e
 		return err
 	}
 
-	// if we used the trailing hack, we need to strip the final space we appended earlier on
-	if usedTrailingHack {
-		copy(line[len(line)-3:], "\r\n")
-		line = line[:len(line)-1]
-	}
-
 	if client.server.logger.IsLoggingRawIO() {
 		logline := string(line[:len(line)-2]) // strip "\r\n"
 		client.server.logger.Debug("useroutput", client.nick, " ->", logline)
@@ -1062,28 +1218,41 @@ func (client *Client) SendRawMessage(message ircmsg.IrcMessage, blocking bool) e
 // Send sends an IRC line to the client.
 func (client *Client) Send(tags map[string]string, prefix string, command string, params ...string) error {
 	msg := ircmsg.MakeMessage(tags, prefix, command, params...)
-	if client.capabilities.Has(caps.ServerTime) && !msg.HasTag("time") {
-		msg.SetTag("time", time.Now().UTC().Format(IRCv3TimestampFormat))
-	}
+	client.attachServerTime(&msg)
 	return client.SendRawMessage(msg, false)
 }
 
+// sendLabeledReply sends a single reply from the server to a command sent by
+// the client, attaching the command's label (if any) for labeled-response.
+func (client *Client) sendLabeledReply(msg ircmsg.IrcMessage, command string, params ...string) {
+	rb := NewResponseBuffer(client)
+	rb.Label = GetLabel(msg)
+	rb.Add(nil, client.server.name, command, params...)
+	rb.Send(true)
+}
+
 // Notice sends the client a notice from the server.
 func (client *Client) Notice(text string) {
-	limit := 400
-	if client.capabilities.Has(caps.MaxLine) {
-		limit = client.server.Limits().LineLen.Rest - 110
-	}
-	lines := utils.WordWrap(text, limit)
+	lines := utils.WordWrap(text, client.noticeLineWidth())
 
 	// force blank lines to be sent if we receive them
 	if len(lines) == 0 {
 		lines = []string{""}
 	}
 
+	nick := client.Nick()
 	for _, line := range lines {
-		client.Send(nil, client.server.name, "NOTICE", client.nick, line)
+		client.SendSplitMsgFromServer(client.server.name, "NOTICE", nick, utils.MakeSplitMessage(line))
+	}
+}
+
+// noticeLineWidth returns the width that server-generated text should be wrapped to
+// for this client, leaving room for the prefix and other parameters.
+func (client *Client) noticeLineWidth() int {
+	if client.capabilities.Has(caps.MaxLine) {
+		return client.server.Limits().LineLen.Rest - 110
 	}
+	return 400
 }
 
 func (client *Client) addChannel(channel *Channel) {
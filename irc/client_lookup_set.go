@@ -7,13 +7,13 @@ package irc
 import (
 	"fmt"
 	"log"
-	"regexp"
 	"strings"
 
-	"github.com/goshuirc/irc-go/ircmatch"
 	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/utils"
 
-	"sync"
+	"sync/atomic"
 )
 
 // ExpandUserHost takes a userhost, and returns an expanded version.
@@ -30,52 +30,149 @@ func ExpandUserHost(userhost string) (expanded string) {
 	return
 }
 
-// ClientManager keeps track of clients by nick, enforcing uniqueness of casefolded nicks
+// CanonicalizeMask expands and casefolds a mask for a channel list (+b, +e, +I),
+// so that equivalent masks are stored once and match as expected.
+func CanonicalizeMask(mask string) (string, error) {
+	return Casefold(ExpandUserHost(mask))
+}
+
+// nickShardCount is the number of shards of the nick-to-client map.
+const nickShardCount = 256
+
+// ClientManager keeps track of clients by nick, enforcing uniqueness of casefolded nicks.
+//
+// Nick lookups happen for nearly every message, so they don't take a lock: the
+// nick-to-client map is split into shards by a hash of the nick, each shard is
+// immutable once published, and changes (which are far rarer: registrations,
+// nick changes, and quits) copy the affected shards, modify the copies, and
+// atomically swap them in. Changes are serialized by the mutex. Sharding keeps
+// the cost of a change proportional to the size of a shard, rather than to the
+// number of clients, so that (for example) a mass quit isn't quadratic.
 type ClientManager struct {
-	sync.RWMutex // tier 2
-	byNick       map[string]*Client
-	bySkeleton   map[string]*Client
+	lockorder.Tier2Mutex
+	byNick     [nickShardCount]atomic.Value // map[string]*Client, which must not be modified
+	count      int64                        // atomic; the total size of the shards
+	bySkeleton map[string]*Client           // protected by the mutex
 }
 
 // NewClientManager returns a new ClientManager.
 func NewClientManager() *ClientManager {
-	return &ClientManager{
-		byNick:     make(map[string]*Client),
+	clients := &ClientManager{
 		bySkeleton: make(map[string]*Client),
 	}
+	for i := range clients.byNick {
+		clients.byNick[i].Store(make(map[string]*Client))
+	}
+	return clients
+}
+
+// nickShard returns the index of the shard containing a casefolded nick (FNV-1a).
+func nickShard(cfnick string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(cfnick); i++ {
+		hash ^= uint32(cfnick[i])
+		hash *= 16777619
+	}
+	return int(hash % nickShardCount)
+}
+
+// shard returns the current (immutable) shard of the nick-to-client map.
+func (clients *ClientManager) shard(index int) map[string]*Client {
+	return clients.byNick[index].Load().(map[string]*Client)
+}
+
+// nickChanges accumulates changes to the nick-to-client map, copying each
+// affected shard once; it requires holding the Lock() until it's committed.
+type nickChanges struct {
+	clients *ClientManager
+	shards  map[int]map[string]*Client
+	delta   int64
+}
+
+func (clients *ClientManager) changeNicks() *nickChanges {
+	return &nickChanges{clients: clients, shards: make(map[int]map[string]*Client, 2)}
+}
+
+// shard returns a modifiable copy of the shard containing cfnick.
+func (changes *nickChanges) shard(cfnick string) map[string]*Client {
+	index := nickShard(cfnick)
+	result, ok := changes.shards[index]
+	if !ok {
+		current := changes.clients.shard(index)
+		result = make(map[string]*Client, len(current)+1)
+		for nick, client := range current {
+			result[nick] = client
+		}
+		changes.shards[index] = result
+	}
+	return result
+}
+
+func (changes *nickChanges) get(cfnick string) (client *Client, present bool) {
+	client, present = changes.shard(cfnick)[cfnick]
+	return
+}
+
+func (changes *nickChanges) set(cfnick string, client *Client) {
+	shard := changes.shard(cfnick)
+	if _, present := shard[cfnick]; !present {
+		changes.delta++
+	}
+	shard[cfnick] = client
+}
+
+func (changes *nickChanges) delete(cfnick string) {
+	shard := changes.shard(cfnick)
+	if _, present := shard[cfnick]; present {
+		changes.delta--
+	}
+	delete(shard, cfnick)
+}
+
+// commit publishes the modified shards.
+func (changes *nickChanges) commit() {
+	for index, shard := range changes.shards {
+		changes.clients.byNick[index].Store(shard)
+	}
+	atomic.AddInt64(&changes.clients.count, changes.delta)
 }
 
 // Count returns how many clients are in the manager.
 func (clients *ClientManager) Count() int {
-	clients.RLock()
-	defer clients.RUnlock()
-	count := len(clients.byNick)
-	return count
+	return int(atomic.LoadInt64(&clients.count))
 }
 
 // Get retrieves a client from the manager, if they exist.
 func (clients *ClientManager) Get(nick string) *Client {
 	casefoldedName, err := CasefoldName(nick)
 	if err == nil {
-		clients.RLock()
-		defer clients.RUnlock()
-		cli := clients.byNick[casefoldedName]
-		return cli
+		return clients.shard(nickShard(casefoldedName))[casefoldedName]
 	}
 	return nil
 }
 
-func (clients *ClientManager) removeInternal(client *Client) (err error) {
-	// requires holding the writable Lock()
+// forEach calls f on every client, stopping if it returns false.
+func (clients *ClientManager) forEach(f func(*Client) bool) {
+	for i := range clients.byNick {
+		for _, client := range clients.shard(i) {
+			if !f(client) {
+				return
+			}
+		}
+	}
+}
+
+func (clients *ClientManager) removeInternal(byNick *nickChanges, client *Client) (err error) {
+	// requires holding the Lock()
 	oldcfnick, oldskeleton := client.uniqueIdentifiers()
 	if oldcfnick == "*" || oldcfnick == "" {
 		return errNickMissing
 	}
 
-	currentEntry, present := clients.byNick[oldcfnick]
+	currentEntry, present := byNick.get(oldcfnick)
 	if present {
 		if currentEntry == client {
-			delete(clients.byNick, oldcfnick)
+			byNick.delete(oldcfnick)
 		} else {
 			// this shouldn't happen, but we can ignore it
 			client.server.logger.Warning("internal", "clients for nick out of sync", oldcfnick)
@@ -105,7 +202,10 @@ func (clients *ClientManager) Remove(client *Client) error {
 	clients.Lock()
 	defer clients.Unlock()
 
-	return clients.removeInternal(client)
+	byNick := clients.changeNicks()
+	err := clients.removeInternal(byNick, client)
+	byNick.commit()
+	return err
 }
 
 // Resume atomically replaces `oldClient` with `newClient`, updating
@@ -116,16 +216,18 @@ func (clients *ClientManager) Resume(newClient, oldClient *Client) (err error) {
 	defer clients.Unlock()
 
 	// atomically grant the new client the old nick
-	err = clients.removeInternal(oldClient)
+	byNick := clients.changeNicks()
+	err = clients.removeInternal(byNick, oldClient)
 	if err != nil {
 		// oldClient no longer owns its nick, fail out
 		return err
 	}
 	// nick has been reclaimed, grant it to the new client
-	clients.removeInternal(newClient)
+	clients.removeInternal(byNick, newClient)
 	oldcfnick, oldskeleton := oldClient.uniqueIdentifiers()
-	clients.byNick[oldcfnick] = newClient
+	byNick.set(oldcfnick, newClient)
 	clients.bySkeleton[oldskeleton] = newClient
+	byNick.commit()
 
 	newClient.copyResumeData(oldClient)
 
@@ -148,7 +250,7 @@ func (clients *ClientManager) SetNick(client *Client, newNick string) error {
 	clients.Lock()
 	defer clients.Unlock()
 
-	currentNewEntry := clients.byNick[newcfnick]
+	currentNewEntry := clients.shard(nickShard(newcfnick))[newcfnick]
 	// the client may just be changing case
 	if currentNewEntry != nil && currentNewEntry != client {
 		return errNicknameInUse
@@ -161,22 +263,21 @@ func (clients *ClientManager) SetNick(client *Client, newNick string) error {
 	if method == NickReservationStrict && reservedAccount != "" && reservedAccount != client.Account() {
 		return errNicknameReserved
 	}
-	clients.removeInternal(client)
-	clients.byNick[newcfnick] = client
+	byNick := clients.changeNicks()
+	clients.removeInternal(byNick, client)
+	byNick.set(newcfnick, client)
 	clients.bySkeleton[newSkeleton] = client
 	client.updateNick(newNick, newcfnick, newSkeleton)
+	byNick.commit()
 	return nil
 }
 
 func (clients *ClientManager) AllClients() (result []*Client) {
-	clients.RLock()
-	defer clients.RUnlock()
-	result = make([]*Client, len(clients.byNick))
-	i := 0
-	for _, client := range clients.byNick {
-		result[i] = client
-		i++
-	}
+	result = make([]*Client, 0, clients.Count())
+	clients.forEach(func(client *Client) bool {
+		result = append(result, client)
+		return true
+	})
 	return
 }
 
@@ -184,10 +285,7 @@ func (clients *ClientManager) AllClients() (result []*Client) {
 func (clients *ClientManager) AllWithCaps(capabs ...caps.Capability) (set ClientSet) {
 	set = make(ClientSet)
 
-	clients.RLock()
-	defer clients.RUnlock()
-	var client *Client
-	for _, client = range clients.byNick {
+	clients.forEach(func(client *Client) bool {
 		// make sure they have all the required caps
 		for _, capab := range capabs {
 			if !client.capabilities.Has(capab) {
@@ -196,7 +294,8 @@ func (clients *ClientManager) AllWithCaps(capabs ...caps.Capability) (set Client
 		}
 
 		set.Add(client)
-	}
+		return true
+	})
 
 	return set
 }
@@ -209,15 +308,14 @@ func (clients *ClientManager) FindAll(userhost string) (set ClientSet) {
 	if err != nil {
 		return set
 	}
-	matcher := ircmatch.MakeMatch(userhost)
+	matcher := utils.NewGlob(userhost)
 
-	clients.RLock()
-	defer clients.RUnlock()
-	for _, client := range clients.byNick {
-		if matcher.Match(client.NickMaskCasefolded()) {
+	clients.forEach(func(client *Client) bool {
+		if matcher.MatchString(client.NickMaskCasefolded()) {
 			set.Add(client)
 		}
-	}
+		return true
+	})
 
 	return set
 }
@@ -228,39 +326,36 @@ func (clients *ClientManager) Find(userhost string) *Client {
 	if err != nil {
 		return nil
 	}
-	matcher := ircmatch.MakeMatch(userhost)
+	matcher := utils.NewGlob(userhost)
 	var matchedClient *Client
 
-	clients.RLock()
-	defer clients.RUnlock()
-	for _, client := range clients.byNick {
-		if matcher.Match(client.NickMaskCasefolded()) {
+	clients.forEach(func(client *Client) bool {
+		if matcher.MatchString(client.NickMaskCasefolded()) {
 			matchedClient = client
-			break
+			return false
 		}
-	}
+		return true
+	})
 
 	return matchedClient
 }
 
 //
-// usermask to regexp
+// usermask matching
 //
 
-//TODO(dan): move this over to generally using glob syntax instead?
-// kinda more expected in normal ban/etc masks, though regex is useful (probably as an extban?)
-
 // UserMaskSet holds a set of client masks and lets you match  hostnames to them.
 type UserMaskSet struct {
-	sync.RWMutex
-	masks  map[string]bool
-	regexp *regexp.Regexp
+	lockorder.Tier0RWMutex
+	masks map[string]*utils.Glob
+	// the compiled masks, replaced (not modified) when masks change
+	globs []*utils.Glob
 }
 
 // NewUserMaskSet returns a new UserMaskSet.
 func NewUserMaskSet() *UserMaskSet {
 	return &UserMaskSet{
-		masks: make(map[string]bool),
+		masks: make(map[string]*utils.Glob),
 	}
 }
 
@@ -271,33 +366,38 @@ func (set *UserMaskSet) Add(mask string) (added bool) {
 		log.Println(fmt.Sprintf("ERROR: Could not add mask to usermaskset: [%s]", mask))
 		return false
 	}
+	// compiled masks are shared through the cache, so a mask that's banned
+	// in many channels is only compiled once
+	glob := utils.CompileGlobCached(casefoldedMask)
 
 	set.Lock()
-	added = !set.masks[casefoldedMask]
-	if added {
-		set.masks[casefoldedMask] = true
-	}
-	set.Unlock()
-
+	defer set.Unlock()
+	_, exists := set.masks[casefoldedMask]
+	added = !exists
 	if added {
-		set.setRegexp()
+		set.masks[casefoldedMask] = glob
+		set.updateGlobs()
 	}
 	return
 }
 
 // AddAll adds the given masks to this set.
 func (set *UserMaskSet) AddAll(masks []string) (added bool) {
+	globs := make([]*utils.Glob, len(masks))
+	for i, mask := range masks {
+		globs[i] = utils.CompileGlobCached(mask)
+	}
+
 	set.Lock()
 	defer set.Unlock()
-
-	for _, mask := range masks {
-		if !added && !set.masks[mask] {
+	for i, mask := range masks {
+		if _, exists := set.masks[mask]; !exists {
 			added = true
+			set.masks[mask] = globs[i]
 		}
-		set.masks[mask] = true
 	}
 	if added {
-		set.setRegexp()
+		set.updateGlobs()
 	}
 	return
 }
@@ -305,14 +405,11 @@ func (set *UserMaskSet) AddAll(masks []string) (added bool) {
 // Remove removes the given mask from this set.
 func (set *UserMaskSet) Remove(mask string) (removed bool) {
 	set.Lock()
-	removed = set.masks[mask]
+	defer set.Unlock()
+	_, removed = set.masks[mask]
 	if removed {
 		delete(set.masks, mask)
-	}
-	set.Unlock()
-
-	if removed {
-		set.setRegexp()
+		set.updateGlobs()
 	}
 	return
 }
@@ -320,13 +417,28 @@ func (set *UserMaskSet) Remove(mask string) (removed bool) {
 // Match matches the given n!u@h.
 func (set *UserMaskSet) Match(userhost string) bool {
 	set.RLock()
-	regexp := set.regexp
+	globs := set.globs
 	set.RUnlock()
 
-	if regexp == nil {
-		return false
+	for _, glob := range globs {
+		if glob.MatchString(userhost) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matching returns the masks in this set that match the given n!u@h.
+func (set *UserMaskSet) Matching(userhost string) (result []string) {
+	set.RLock()
+	defer set.RUnlock()
+
+	for mask, glob := range set.masks {
+		if glob.MatchString(userhost) {
+			result = append(result, mask)
+		}
 	}
-	return regexp.MatchString(userhost)
+	return
 }
 
 // String returns the masks in this set.
@@ -348,40 +460,15 @@ func (set *UserMaskSet) Length() int {
 	return len(set.masks)
 }
 
-// setRegexp generates a regular expression from the set of user mask
-// strings. Masks are split at the two types of wildcards, `*` and
-// `?`. All the pieces are meta-escaped. `*` is replaced with `.*`,
-// the regexp equivalent. Likewise, `?` is replaced with `.`. The
-// parts are re-joined and finally all masks are joined into a big
-// or-expression.
-func (set *UserMaskSet) setRegexp() {
-	var re *regexp.Regexp
-
-	set.RLock()
-	maskExprs := make([]string, len(set.masks))
-	index := 0
-	for mask := range set.masks {
-		manyParts := strings.Split(mask, "*")
-		manyExprs := make([]string, len(manyParts))
-		for mindex, manyPart := range manyParts {
-			oneParts := strings.Split(manyPart, "?")
-			oneExprs := make([]string, len(oneParts))
-			for oindex, onePart := range oneParts {
-				oneExprs[oindex] = regexp.QuoteMeta(onePart)
-			}
-			manyExprs[mindex] = strings.Join(oneExprs, ".")
-		}
-		maskExprs[index] = strings.Join(manyExprs, ".*")
-		index++
-	}
-	set.RUnlock()
-
-	if index > 0 {
-		expr := "^" + strings.Join(maskExprs, "|") + "$"
-		re, _ = regexp.Compile(expr)
+// updateGlobs regenerates the list of compiled masks that Match checks,
+// without recompiling anything; the caller must hold the write lock.
+// Masks are checked one by one rather than joined into a single regular
+// expression, because the simple masks that make up most ban lists
+// (e.g., `*!*@example.com`) are much faster to match as strings.
+func (set *UserMaskSet) updateGlobs() {
+	globs := make([]*utils.Glob, 0, len(set.masks))
+	for _, glob := range set.masks {
+		globs = append(globs, glob)
 	}
-
-	set.Lock()
-	set.regexp = re
-	set.Unlock()
+	set.globs = globs
 }
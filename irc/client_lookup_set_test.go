@@ -0,0 +1,162 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// addTestClient registers a client under `nick`, bypassing the checks in SetNick
+func addTestClient(clients *ClientManager, nick string) *Client {
+	cfnick, _ := CasefoldName(nick)
+	skeleton, _ := Skeleton(nick)
+	client := &Client{nick: nick, nickCasefolded: cfnick, skeleton: skeleton}
+	putTestClient(clients, client)
+	return client
+}
+
+func putTestClient(clients *ClientManager, client *Client) {
+	clients.Lock()
+	defer clients.Unlock()
+	byNick := clients.changeNicks()
+	byNick.set(client.nickCasefolded, client)
+	clients.bySkeleton[client.skeleton] = client
+	byNick.commit()
+}
+
+func TestClientManager(t *testing.T) {
+	clients := NewClientManager()
+	alice := addTestClient(clients, "Alice")
+	bob := addTestClient(clients, "bob")
+
+	if clients.Get("alice") != alice || clients.Get("BOB") != bob || clients.Get("carol") != nil {
+		t.Errorf("incorrect lookup results")
+	}
+	if clients.Count() != 2 || len(clients.AllClients()) != 2 {
+		t.Errorf("incorrect count: %d", clients.Count())
+	}
+
+	// readers holding an old snapshot aren't affected by later changes
+	snapshot := clients.shard(nickShard("alice"))
+	if err := clients.Remove(alice); err != nil {
+		t.Fatal(err)
+	}
+	if clients.Get("alice") != nil || clients.Count() != 1 {
+		t.Errorf("client was not removed")
+	}
+	if snapshot["alice"] != alice {
+		t.Errorf("published snapshot was modified")
+	}
+	if len(clients.bySkeleton) != 1 {
+		t.Errorf("skeleton was not removed")
+	}
+	if err := clients.Remove(alice); err != errNickMissing {
+		t.Errorf("removing a missing client should fail, got %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		addTestClient(clients, fmt.Sprintf("client%d", i))
+	}
+	if clients.Count() != 1001 || len(clients.AllClients()) != 1001 {
+		t.Errorf("incorrect count: %d", clients.Count())
+	}
+	if client := clients.Get("CLIENT500"); client == nil || client.nick != "client500" {
+		t.Errorf("incorrect lookup result: %v", client)
+	}
+}
+
+func BenchmarkClientManagerGet(b *testing.B) {
+	clients := NewClientManager()
+	nicks := make([]string, 1000)
+	for i := range nicks {
+		nicks[i] = fmt.Sprintf("client%d", i)
+		addTestClient(clients, nicks[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			clients.Get(nicks[i%len(nicks)])
+			i++
+		}
+	})
+}
+
+// BenchmarkClientManagerChurn measures changes to a large manager, as in a
+// mass quit followed by reconnections.
+func BenchmarkClientManagerChurn(b *testing.B) {
+	clients := NewClientManager()
+	testClients := make([]*Client, 50000)
+	for i := range testClients {
+		testClients[i] = addTestClient(clients, fmt.Sprintf("client%d", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := testClients[i%len(testClients)]
+		clients.Remove(client)
+		putTestClient(clients, client)
+	}
+}
+
+func TestUserMaskSetMatching(t *testing.T) {
+	set := NewUserMaskSet()
+	set.Add("*!*@example.com")
+	set.Add("dan!*@*")
+	set.Add("*!*@other.example.com")
+
+	matching := set.Matching("dan!~d@example.com")
+	sort.Strings(matching)
+	if len(matching) != 2 || matching[0] != "*!*@example.com" || matching[1] != "dan!*@*" {
+		t.Errorf("incorrect matching masks: %v", matching)
+	}
+	if matching := set.Matching("shivaram!~s@localhost"); len(matching) != 0 {
+		t.Errorf("incorrect matching masks: %v", matching)
+	}
+}
+
+func TestUserMaskSetMatch(t *testing.T) {
+	set := NewUserMaskSet()
+	if set.Match("dan!~d@example.com") {
+		t.Errorf("empty sets shouldn't match anything")
+	}
+	set.AddAll([]string{"*!*@example.com", "dan!*@*", "*!*@other.example.com"})
+	if !set.Match("dan!~d@localhost") || !set.Match("shivaram!~s@example.com") {
+		t.Errorf("masks should match")
+	}
+	// every mask must match the entire n!u@h
+	if set.Match("notdan!~d@localhost") || set.Match("shivaram!~s@example.com.evil") {
+		t.Errorf("masks shouldn't match part of the n!u@h")
+	}
+	set.Remove("dan!*@*")
+	if set.Match("dan!~d@localhost") {
+		t.Errorf("removed mask shouldn't match")
+	}
+}
+
+func BenchmarkUserMaskSetMatch(b *testing.B) {
+	set := NewUserMaskSet()
+	for i := 0; i < 500; i++ {
+		set.Add(fmt.Sprintf("*!*@*.isp%d.example.com", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Match("dan!~d@irc.example.org")
+	}
+}
+
+func TestCanonicalizeMask(t *testing.T) {
+	cases := map[string]string{
+		"Dan":              "dan!*@*",
+		"*!*@Example.COM":  "*!*@example.com",
+		"dan!~d":           "dan!~d@*",
+		"dan!~d@localhost": "dan!~d@localhost",
+	}
+	for mask, expected := range cases {
+		if canonical, err := CanonicalizeMask(mask); err != nil || canonical != expected {
+			t.Errorf("expected %s to canonicalize to %s, got %s (%v)", mask, expected, canonical, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+func TestSaslListeners(t *testing.T) {
+	server := &Server{}
+	server.defcon.level = DefconNormal
+	var config Config
+	exempted, err := utils.ParseNetList([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Server.SaslListeners.exemptedNets = exempted
+
+	client := &Client{server: server, realIP: net.ParseIP("192.168.1.1")}
+	if err := client.isAuthorized(&config); err != nil {
+		t.Errorf("client should be authorized: %v", err)
+	}
+
+	client.requireSasl = true
+	if err := client.isAuthorized(&config); err != errSaslRequired {
+		t.Errorf("expected errSaslRequired, got %v", err)
+	}
+
+	client.account = "dan"
+	if err := client.isAuthorized(&config); err != nil {
+		t.Errorf("authenticated client should be authorized: %v", err)
+	}
+
+	client.account = ""
+	client.realIP = net.ParseIP("10.1.2.3")
+	if err := client.isAuthorized(&config); err != nil {
+		t.Errorf("exempted client should be authorized: %v", err)
+	}
+
+	config.Server.passwordBytes = []byte("hunter2")
+	if err := client.isAuthorized(&config); err != errBadPassword {
+		t.Errorf("expected errBadPassword, got %v", err)
+	}
+}
+
+func TestServerMessageTags(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Connect()
+	alice.Send("CAP", "REQ", "message-tags server-time")
+	alice.Expect("CAP")
+	alice.Send("NICK", "alice")
+	alice.Send("USER", "u", "0", "*", "simulated client")
+	alice.Send("CAP", "END")
+	if msg := alice.Expect(RPL_WELCOME); msg.HasTag("draft/msgid") || !msg.HasTag("time") {
+		t.Errorf("numerics should have a time but no msgid: %v", msg)
+	}
+
+	// services notices go through a SplitMessage, so they have a msgid
+	alice.Send("NS", "INFO")
+	msg := alice.Expect("NOTICE")
+	if !strings.HasPrefix(msg.Prefix, "NickServ") {
+		t.Fatalf("unexpected notice: %v", msg)
+	}
+	if hasMsgid, msgid := msg.GetTag("draft/msgid"); !hasMsgid || msgid == "" || !msg.HasTag("time") {
+		t.Errorf("services notice should have a msgid and a time: %v", msg)
+	}
+}
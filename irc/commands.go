@@ -22,20 +22,31 @@ type Command struct {
 
 // Run runs this command with the given client/message.
 func (cmd *Command) Run(server *Server, client *Client, msg ircmsg.IrcMessage) bool {
+	// all replies (including errors) go through the response buffer,
+	// so that they carry the command's label
+	rb := NewResponseBuffer(client)
+	rb.Label = GetLabel(msg)
+
+	server.commandStats.Increment(msg.Command)
+
 	if !client.registered && !cmd.usablePreReg {
-		client.Send(nil, server.name, ERR_NOTREGISTERED, client.nick, client.t("You need to register before you can use that command"))
+		rb.Add(nil, server.name, ERR_NOTREGISTERED, client.nick, client.t("You need to register before you can use that command"))
+		rb.Send(true)
 		return false
 	}
 	if cmd.oper && !client.HasMode(modes.Operator) {
-		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied - You're not an IRC operator"))
+		rb.Add(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied - You're not an IRC operator"))
+		rb.Send(true)
 		return false
 	}
 	if len(cmd.capabs) > 0 && !client.HasRoleCapabs(cmd.capabs...) {
-		client.Send(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied"))
+		rb.Add(nil, server.name, ERR_NOPRIVILEGES, client.nick, client.t("Permission Denied"))
+		rb.Send(true)
 		return false
 	}
 	if len(msg.Params) < cmd.minParams {
-		client.Send(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
+		rb.Send(true)
 		return false
 	}
 
@@ -43,8 +54,6 @@ func (cmd *Command) Run(server *Server, client *Client, msg ircmsg.IrcMessage) b
 		client.fakelag.Touch()
 	}
 
-	rb := NewResponseBuffer(client)
-	rb.Label = GetLabel(msg)
 	exiting := cmd.handler(server, client, msg, rb)
 	rb.Send(true)
 
@@ -74,10 +83,19 @@ func init() {
 			handler:   accHandler,
 			minParams: 3,
 		},
+		"ACCEPT": {
+			handler:   acceptHandler,
+			minParams: 1,
+		},
 		"AMBIANCE": {
 			handler:   sceneHandler,
 			minParams: 2,
 		},
+		"ANNOUNCE": {
+			handler:   announceHandler,
+			minParams: 2,
+			capabs:    []string{"announce"},
+		},
 		"AUTHENTICATE": {
 			handler:      authenticateHandler,
 			usablePreReg: true,
@@ -87,11 +105,25 @@ func init() {
 			handler:   awayHandler,
 			minParams: 0,
 		},
+		"BACKUP": {
+			handler:   backupHandler,
+			minParams: 0,
+			oper:      true,
+			capabs:    []string{"backup"},
+		},
+		"BRB": {
+			handler:   brbHandler,
+			minParams: 0,
+		},
 		"CAP": {
 			handler:      capHandler,
 			usablePreReg: true,
 			minParams:    1,
 		},
+		"CHALLENGE": {
+			handler:   challengeHandler,
+			minParams: 1,
+		},
 		"CHATHISTORY": {
 			handler:   chathistoryHandler,
 			minParams: 3,
@@ -101,6 +133,16 @@ func init() {
 			minParams: 1,
 			oper:      true,
 		},
+		"DEFCON": {
+			handler: defconHandler,
+			oper:    true,
+			capabs:  []string{"defcon"},
+		},
+		"DIE": {
+			handler: dieHandler,
+			oper:    true,
+			capabs:  []string{"oper:die"},
+		},
 		"DLINE": {
 			handler:   dlineHandler,
 			minParams: 1,
@@ -149,6 +191,10 @@ func init() {
 			minParams: 1,
 			oper:      true,
 		},
+		"KNOCK": {
+			handler:   knockHandler,
+			minParams: 1,
+		},
 		"LANGUAGE": {
 			handler:      languageHandler,
 			usablePreReg: true,
@@ -162,6 +208,14 @@ func init() {
 			handler:   lusersHandler,
 			minParams: 0,
 		},
+		"MARKREAD": {
+			handler:   markreadHandler,
+			minParams: 1,
+		},
+		"METADATA": {
+			handler:   metadataHandler,
+			minParams: 2,
+		},
 		"MODE": {
 			handler:   modeHandler,
 			minParams: 1,
@@ -197,7 +251,7 @@ func init() {
 		},
 		"OPER": {
 			handler:   operHandler,
-			minParams: 2,
+			minParams: 1,
 		},
 		"PART": {
 			handler:   partHandler,
@@ -224,15 +278,35 @@ func init() {
 			handler:   privmsgHandler,
 			minParams: 2,
 		},
+		"REDACT": {
+			handler:   redactHandler,
+			minParams: 2,
+		},
+		"REJECT": {
+			handler:   rejectHandler,
+			minParams: 1,
+		},
+		"RELAYMSG": {
+			handler:   relaymsgHandler,
+			minParams: 3,
+		},
 		"RENAME": {
 			handler:   renameHandler,
 			minParams: 2,
 		},
+		"REPORT": {
+			handler:   reportHandler,
+			minParams: 2,
+		},
 		"RESUME": {
 			handler:      resumeHandler,
 			usablePreReg: true,
 			minParams:    1,
 		},
+		"RULES": {
+			handler:   rulesHandler,
+			minParams: 0,
+		},
 		"SAJOIN": {
 			handler:   sajoinHandler,
 			minParams: 1,
@@ -256,6 +330,10 @@ func init() {
 			handler:   setnameHandler,
 			minParams: 1,
 		},
+		"STATS": {
+			handler:   statsHandler,
+			minParams: 1,
+		},
 		"TAGMSG": {
 			handler:   tagmsgHandler,
 			minParams: 1,
@@ -265,6 +343,11 @@ func init() {
 			usablePreReg: true,
 			minParams:    0,
 		},
+		"REGISTER": {
+			handler:      registerHandler,
+			usablePreReg: true,
+			minParams:    3,
+		},
 		"REHASH": {
 			handler:   rehashHandler,
 			minParams: 0,
@@ -298,6 +381,11 @@ func init() {
 			handler:   userhostHandler,
 			minParams: 1,
 		},
+		"VERIFY": {
+			handler:      verifyHandler,
+			usablePreReg: true,
+			minParams:    2,
+		},
 		"VERSION": {
 			handler:   versionHandler,
 			minParams: 0,
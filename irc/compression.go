@@ -0,0 +1,139 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"compress/zlib"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
+
+var (
+	errTooManyCompressedConns = errors.New("too many compressed connections")
+	errCompressionRatio       = errors.New("compressed data expands too much")
+)
+
+const (
+	// the default limit on how much a connection's data can expand when it's
+	// decompressed; IRC traffic rarely expands more than 20x
+	defaultCompressionMaxRatio = 100
+	// data that can be decompressed regardless of the ratio, so that the ratio
+	// isn't enforced on the first few (highly compressible) lines
+	compressionRatioAllowance = 64 * 1024
+)
+
+// CompressedListenersConfig defines listeners whose connections are compressed.
+type CompressedListenersConfig struct {
+	Listeners      []string
+	Level          int
+	MaxConnections int `yaml:"max-connections"`
+	MaxRatio       int `yaml:"max-ratio"`
+}
+
+// compressedConn is a net.Conn whose data is compressed in both directions
+// with zlib (RFC 1950). Each write is flushed immediately, so that compression
+// doesn't delay delivery of messages.
+type compressedConn struct {
+	net.Conn
+
+	reader     io.ReadCloser // initialized lazily, since zlib.NewReader blocks reading the header
+	readerErr  error
+	raw        countingReader // the compressed data underneath `reader`
+	inflated   int64          // total decompressed data returned by Read
+	maxRatio   int64
+	writer     *zlib.Writer
+	writeMutex lockorder.Tier0Mutex // serializes access to `writer`
+
+	counter   *int32
+	closeOnce sync.Once
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (cr *countingReader) Read(b []byte) (n int, err error) {
+	n, err = cr.Reader.Read(b)
+	cr.count += int64(n)
+	return
+}
+
+// newCompressedConn wraps `conn` in a compressed connection, if the current number
+// of compressed connections (tracked in `counter`) is below the configured maximum.
+func newCompressedConn(conn net.Conn, config *CompressedListenersConfig, counter *int32) (*compressedConn, error) {
+	numConnections := atomic.AddInt32(counter, 1)
+	if config.MaxConnections != 0 && config.MaxConnections < int(numConnections) {
+		atomic.AddInt32(counter, -1)
+		return nil, errTooManyCompressedConns
+	}
+
+	writer, err := zlib.NewWriterLevel(conn, config.Level)
+	if err != nil {
+		atomic.AddInt32(counter, -1)
+		return nil, err
+	}
+	return &compressedConn{
+		Conn:     conn,
+		raw:      countingReader{Reader: conn},
+		maxRatio: int64(config.MaxRatio),
+		writer:   writer,
+		counter:  counter,
+	}, nil
+}
+
+// Read reads decompressed data. It must only be called from one goroutine at a time.
+// Decompressing is where a client can make us do the most work for the least data,
+// so once the data has expanded more than the configured ratio, Read fails.
+func (cc *compressedConn) Read(b []byte) (n int, err error) {
+	if cc.reader == nil && cc.readerErr == nil {
+		cc.reader, cc.readerErr = zlib.NewReader(&cc.raw)
+	}
+	if cc.readerErr != nil {
+		return 0, cc.readerErr
+	}
+	n, err = cc.reader.Read(b)
+	cc.inflated += int64(n)
+	if cc.maxRatio != 0 && cc.maxRatio*cc.raw.count+compressionRatioAllowance < cc.inflated {
+		cc.readerErr = errCompressionRatio
+		return 0, cc.readerErr
+	}
+	return
+}
+
+// Write compresses and sends data.
+func (cc *compressedConn) Write(b []byte) (n int, err error) {
+	cc.writeMutex.Lock()
+	defer cc.writeMutex.Unlock()
+
+	n, err = cc.writer.Write(b)
+	if err == nil {
+		err = cc.writer.Flush()
+	}
+	return
+}
+
+// Close closes the underlying connection and releases this connection's slot.
+func (cc *compressedConn) Close() error {
+	cc.closeOnce.Do(func() {
+		atomic.AddInt32(cc.counter, -1)
+	})
+	return cc.Conn.Close()
+}
+
+// underlyingTLSConn returns the TLS connection underneath `conn`, if any.
+func underlyingTLSConn(conn net.Conn) (tlsConn *tls.Conn, ok bool) {
+	if cc, isCompressed := conn.(*compressedConn); isCompressed {
+		conn = cc.Conn
+	}
+	tlsConn, ok = conn.(*tls.Conn)
+	return
+}
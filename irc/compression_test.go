@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestCompressedConn(t *testing.T) {
+	var counter int32
+	serverSide, clientSide := net.Pipe()
+
+	limited := &CompressedListenersConfig{Level: 6, MaxConnections: 1}
+	sconn, err := newCompressedConn(serverSide, limited, &counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cconn, err := newCompressedConn(clientSide, &CompressedListenersConfig{Level: 6}, &counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCompressedConn(serverSide, limited, &counter); err != errTooManyCompressedConns {
+		t.Errorf("expected connection limit to be enforced, got %v", err)
+	}
+
+	go cconn.Write([]byte("PING :compressed\r\n"))
+	line, err := bufio.NewReader(sconn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "PING :compressed\r\n" {
+		t.Errorf("bad line: %q", line)
+	}
+
+	sconn.Close()
+	cconn.Close()
+	if counter != 0 {
+		t.Errorf("connection slots were not released: %d", counter)
+	}
+}
+
+func TestCompressedConnRatio(t *testing.T) {
+	// a few kilobytes that decompress to several megabytes
+	var bomb bytes.Buffer
+	writer := zlib.NewWriter(&bomb)
+	writer.Write(bytes.Repeat([]byte("PING :x\r\n"), 1000000))
+	writer.Close()
+
+	var counter int32
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	sconn, err := newCompressedConn(serverSide, &CompressedListenersConfig{Level: 6, MaxRatio: 100}, &counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sconn.Close()
+
+	go clientSide.Write(bomb.Bytes())
+	data, err := ioutil.ReadAll(sconn)
+	if err != errCompressionRatio {
+		t.Errorf("expected the ratio to be enforced, got %v", err)
+	}
+	if limit := 100*bomb.Len() + compressionRatioAllowance; limit < len(data) {
+		t.Errorf("decompressed %d bytes, more than the limit of %d", len(data), limit)
+	}
+}
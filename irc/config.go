@@ -6,7 +6,11 @@
 package irc
 
 import (
+	"compress/zlib"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -36,6 +40,19 @@ import (
 type TLSListenConfig struct {
 	Cert string
 	Key  string
+	// minimum protocol version, e.g., "1.2"
+	MinVersion string `yaml:"min-version"`
+	// cipher suites and curves, in order of preference; if empty, the Go defaults are used
+	Ciphers []string
+	Curves  []string
+	// reject clients that don't present a certificate; if client-ca is set,
+	// the certificate must also be signed by that CA
+	RequireClientCert bool   `yaml:"require-client-cert"`
+	ClientCA          string `yaml:"client-ca"`
+	// serve HTTP (e.g., the API) as well as IRC, to clients that negotiate
+	// it with ALPN or that request one of the http-server-names with SNI
+	MultiplexHTTP   bool     `yaml:"multiplex-http"`
+	HTTPServerNames []string `yaml:"http-server-names"`
 }
 
 // Config returns the TLS contiguration assicated with this TLSListenConfig.
@@ -45,9 +62,16 @@ func (conf *TLSListenConfig) Config() (*tls.Config, error) {
 		return nil, ErrInvalidCertKeyPair
 	}
 
-	return &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{cert},
-	}, err
+	}
+	if conf.MultiplexHTTP {
+		config.NextProtos = []string{alpnIRC, alpnHTTP}
+	}
+	if err = conf.applyPolicy(config); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 type AccountConfig struct {
@@ -63,9 +87,21 @@ type AccountConfig struct {
 		Duration    time.Duration
 		MaxAttempts int `yaml:"max-attempts"`
 	} `yaml:"login-throttling"`
-	SkipServerPassword bool                  `yaml:"skip-server-password"`
+	SkipServerPassword bool           `yaml:"skip-server-password"`
+	MonitorPersistence bool           `yaml:"monitor-persistence"`
+	AutoAway           AutoAwayConfig `yaml:"auto-away"`
+	Expiration         AccountExpirationConfig
+	Erasure            AccountErasureConfig
+	Personas           PersonasConfig
+	APITokens          APITokensConfig       `yaml:"api-tokens"`
+	AJoin              AJoinConfig           `yaml:"ajoin"`
+	ExternalAuth       ExternalAuthConfig    `yaml:"external-auth"`
+	JWTAuth            JWTAuthConfig         `yaml:"jwt-auth"`
 	NickReservation    NickReservationConfig `yaml:"nick-reservation"`
 	VHosts             VHostConfig
+	Probation          ProbationConfig
+	PMLimits           PMLimitsConfig `yaml:"unregistered-pm-limits"`
+	Bots               BotConfig
 }
 
 // AccountRegistrationConfig controls account registration.
@@ -74,7 +110,9 @@ type AccountRegistrationConfig struct {
 	EnabledCallbacks       []string      `yaml:"enabled-callbacks"`
 	EnabledCredentialTypes []string      `yaml:"-"`
 	VerifyTimeout          time.Duration `yaml:"verify-timeout"`
-	Callbacks              struct {
+	// allow draft/account-registration before the connection is complete
+	AllowBeforeConnect bool `yaml:"allow-before-connect"`
+	Callbacks          struct {
 		Mailto struct {
 			Server string
 			Port   int
@@ -93,6 +131,37 @@ type AccountRegistrationConfig struct {
 	BcryptCost uint `yaml:"bcrypt-cost"`
 }
 
+// SaslMechanisms returns the SASL mechanisms that are currently enabled.
+func (conf *AccountConfig) SaslMechanisms() (mechanisms []string) {
+	mechanisms = []string{"PLAIN", "EXTERNAL"}
+	if conf.JWTAuth.Enabled {
+		mechanisms = append(mechanisms, "JWT")
+	}
+	return
+}
+
+// RegistrationCapValue returns the value to advertise for draft/account-registration.
+func (conf *AccountConfig) RegistrationCapValue() string {
+	var values []string
+	if conf.Registration.AllowBeforeConnect {
+		values = append(values, "before-connect")
+	}
+	emailRequired := true
+	for _, callback := range conf.Registration.EnabledCallbacks {
+		if callback == "*" {
+			emailRequired = false
+		}
+	}
+	if emailRequired {
+		values = append(values, "email-required")
+	}
+	// with nick reservation, you can only register your current nickname
+	if !conf.NickReservation.Enabled {
+		values = append(values, "custom-account-name")
+	}
+	return strings.Join(values, ",")
+}
+
 type VHostConfig struct {
 	Enabled        bool
 	MaxLength      int    `yaml:"max-length"`
@@ -181,23 +250,28 @@ type NickReservationConfig struct {
 type ChannelRegistrationConfig struct {
 	Enabled               bool
 	MaxChannelsPerAccount int `yaml:"max-channels-per-account"`
+	Verification          ChannelVerificationConfig
 }
 
 // OperClassConfig defines a specific operator class.
 type OperClassConfig struct {
-	Title        string
-	WhoisLine    string
-	Extends      string
-	Capabilities []string
+	Title          string
+	WhoisLine      string
+	Extends        string
+	Capabilities   []string
+	MaxSendQString string `yaml:"max-sendq"`
 }
 
 // OperConfig defines a specific operator's configuration.
 type OperConfig struct {
-	Class     string
-	Vhost     string
-	WhoisLine string `yaml:"whois-line"`
-	Password  string
-	Modes     string
+	Class         string
+	Vhost         string
+	WhoisLine     string `yaml:"whois-line"`
+	Password      string
+	Fingerprint   string
+	PublicKeyFile string `yaml:"public-key-file"`
+	Hosts         []string
+	Modes         string
 }
 
 // LineLenConfig controls line lengths.
@@ -207,6 +281,7 @@ type LineLenLimits struct {
 
 // Various server-enforced limits on data size.
 type Limits struct {
+	AcceptEntries  int           `yaml:"accept-entries"`
 	AwayLen        int           `yaml:"awaylen"`
 	ChanListModes  int           `yaml:"chan-list-modes"`
 	ChannelLen     int           `yaml:"channellen"`
@@ -217,6 +292,8 @@ type Limits struct {
 	NickLen        int           `yaml:"nicklen"`
 	TopicLen       int           `yaml:"topiclen"`
 	WhowasEntries  int           `yaml:"whowas-entries"`
+	// maximum number of whowas entries for any one nickname
+	WhowasEntriesPerNick int `yaml:"whowas-entries-per-nick"`
 }
 
 // STSConfig controls the STS configuration/
@@ -252,9 +329,19 @@ type TorListenersConfig struct {
 	Listeners                 []string
 	RequireSasl               bool `yaml:"require-sasl"`
 	Vhost                     string
-	MaxConnections            int           `yaml:"max-connections"`
-	ThrottleDuration          time.Duration `yaml:"throttle-duration"`
-	MaxConnectionsPerDuration int           `yaml:"max-connections-per-duration"`
+	MaxConnections            int                `yaml:"max-connections"`
+	ThrottleDuration          time.Duration      `yaml:"throttle-duration"`
+	MaxConnectionsPerDuration int                `yaml:"max-connections-per-duration"`
+	OnionService              OnionServiceConfig `yaml:"onion-service"`
+}
+
+// SaslListenersConfig controls which listeners require clients to authenticate
+// with SASL before completing registration.
+type SaslListenersConfig struct {
+	Listeners []string
+	// connections from these IPs or networks don't need to authenticate
+	Exempted     []string
+	exemptedNets []net.IPNet
 }
 
 // Config defines the overall configuration.
@@ -272,18 +359,34 @@ type Config struct {
 		UnixBindMode         os.FileMode                 `yaml:"unix-bind-mode"`
 		TLSListeners         map[string]*TLSListenConfig `yaml:"tls-listeners"`
 		TorListeners         TorListenersConfig          `yaml:"tor-listeners"`
+		CompressedListeners  CompressedListenersConfig   `yaml:"compressed-listeners"`
+		SaslListeners        SaslListenersConfig         `yaml:"require-sasl-listeners"`
 		STS                  STSConfig
-		CheckIdent           bool `yaml:"check-ident"`
+		CheckIdent           bool `yaml:"check-ident"` // legacy name for ident.enabled
+		Ident                IdentConfig
+		ReverseDNS           ReverseDNSConfig `yaml:"rdns"`
+		GeoIP                GeoIPConfig      `yaml:"geoip"`
+		Relaymsg             RelaymsgConfig
+		Reports              ReportsConfig
+		Metadata             MetadataConfig
+		ConnectRules         []ConnectRule   `yaml:"connect-rules"`
+		JoinBurst            JoinBurstConfig `yaml:"join-burst"`
+		CTCP                 CTCPConfig
+		UTF8Only             UTF8OnlyConfig `yaml:"utf8-only"`
 		MOTD                 string
-		MOTDFormatting       bool     `yaml:"motd-formatting"`
+		MOTDFormatting       bool `yaml:"motd-formatting"`
+		Rules                string
 		ProxyAllowedFrom     []string `yaml:"proxy-allowed-from"`
 		proxyAllowedFromNets []net.IPNet
 		WebIRC               []webircConfig `yaml:"webirc"`
 		MaxSendQString       string         `yaml:"max-sendq"`
 		MaxSendQBytes        int
+		WriteTimeout         time.Duration                     `yaml:"write-timeout"`
 		AllowPlaintextResume bool                              `yaml:"allow-plaintext-resume"`
+		BrbTimeout           time.Duration                     `yaml:"brb-timeout"`
 		ConnectionLimiter    connection_limits.LimiterConfig   `yaml:"connection-limits"`
 		ConnectionThrottler  connection_limits.ThrottlerConfig `yaml:"connection-throttling"`
+		Shutdown             ShutdownConfig
 	}
 
 	Languages struct {
@@ -302,12 +405,22 @@ type Config struct {
 	Accounts AccountConfig
 
 	Channels struct {
-		DefaultModes         *string `yaml:"default-modes"`
-		defaultModes         modes.Modes
-		MaxChannelsPerClient int `yaml:"max-channels-per-client"`
-		Registration         ChannelRegistrationConfig
+		DefaultModes             *string `yaml:"default-modes"`
+		defaultModes             modes.Modes
+		StatusModes              string `yaml:"status-modes"`
+		statusModes              modes.Modes
+		MaxChannelsPerClient     int  `yaml:"max-channels-per-client"`
+		KickInsecureOnSecureOnly bool `yaml:"kick-insecure-on-secure-only"`
+		Registration             ChannelRegistrationConfig
+		JoinFlood                JoinFloodConfig `yaml:"join-flood"`
+		Knock                    KnockConfig
+		AutoJoin                 AutoJoinConfig    `yaml:"auto-join"`
+		ExitMessages             ExitMessagePolicy `yaml:"exit-messages"`
 	}
 
+	ConnectionClasses map[string]*ConnectionClassConfig `yaml:"connection-classes"`
+	connectionClasses map[string]*ConnectionClass
+
 	OperClasses map[string]*OperClassConfig `yaml:"oper-classes"`
 
 	Opers map[string]*OperConfig
@@ -323,6 +436,12 @@ type Config struct {
 		PprofListener     *string `yaml:"pprof-listener"`
 	}
 
+	API APIConfig
+
+	Plugins []PluginConfig
+
+	Blocklists []BlocklistConfig
+
 	Limits Limits
 
 	Fakelag FakelagConfig
@@ -340,9 +459,10 @@ type Config struct {
 
 // OperClass defines an assembled operator class.
 type OperClass struct {
-	Title        string
-	WhoisLine    string          `yaml:"whois-line"`
-	Capabilities map[string]bool // map to make lookups much easier
+	Title         string
+	WhoisLine     string          `yaml:"whois-line"`
+	Capabilities  map[string]bool // map to make lookups much easier
+	MaxSendQBytes int             // if nonzero, overrides the server's max-sendq
 }
 
 // OperatorClasses returns a map of assembled operator classes from the given config.
@@ -385,6 +505,7 @@ func (conf *Config) OperatorClasses() (map[string]*OperClass, error) {
 				for capab := range einfo.Capabilities {
 					oc.Capabilities[capab] = true
 				}
+				oc.MaxSendQBytes = einfo.MaxSendQBytes
 			}
 
 			// add our own info
@@ -392,6 +513,13 @@ func (conf *Config) OperatorClasses() (map[string]*OperClass, error) {
 			for _, capab := range info.Capabilities {
 				oc.Capabilities[capab] = true
 			}
+			if info.MaxSendQString != "" {
+				maxSendQBytes, err := bytefmt.ToBytes(info.MaxSendQString)
+				if err != nil {
+					return nil, fmt.Errorf("Could not parse maximum SendQ size for operclass [%s]: %s", name, err.Error())
+				}
+				oc.MaxSendQBytes = int(maxSendQBytes)
+			}
 			if len(info.WhoisLine) > 0 {
 				oc.WhoisLine = info.WhoisLine
 			} else {
@@ -417,12 +545,15 @@ func (conf *Config) OperatorClasses() (map[string]*OperClass, error) {
 
 // Oper represents a single assembled operator's config.
 type Oper struct {
-	Name      string
-	Class     *OperClass
-	WhoisLine string
-	Vhost     string
-	Pass      []byte
-	Modes     []modes.ModeChange
+	Name        string
+	Class       *OperClass
+	WhoisLine   string
+	Vhost       string
+	Pass        []byte
+	Fingerprint string
+	PublicKey   *rsa.PublicKey
+	Hosts       *UserMaskSet
+	Modes       []modes.ModeChange
 }
 
 // Operators returns a map of operator configs from the given OperClass and config.
@@ -438,9 +569,29 @@ func (conf *Config) Operators(oc map[string]*OperClass) (map[string]*Oper, error
 		}
 		oper.Name = name
 
-		oper.Pass, err = decodeLegacyPasswordHash(opConf.Password)
-		if err != nil {
-			return nil, err
+		if opConf.Password != "" {
+			oper.Pass, err = decodeLegacyPasswordHash(opConf.Password)
+			if err != nil {
+				return nil, err
+			}
+		}
+		oper.Fingerprint = strings.ToLower(strings.Replace(opConf.Fingerprint, ":", "", -1))
+		if opConf.PublicKeyFile != "" {
+			oper.PublicKey, err = loadOperPublicKey(opConf.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("Could not load public key for operator [%s]: %s", name, err.Error())
+			}
+		}
+		if oper.Pass == nil && oper.Fingerprint == "" && oper.PublicKey == nil {
+			return nil, fmt.Errorf("Operator [%s] must have a password, fingerprint, or public key", name)
+		}
+		if len(opConf.Hosts) > 0 {
+			oper.Hosts = NewUserMaskSet()
+			for _, host := range opConf.Hosts {
+				if !oper.Hosts.Add(ExpandUserHost(host)) {
+					return nil, fmt.Errorf("Operator [%s] has invalid host mask [%s]", name, host)
+				}
+			}
 		}
 
 		oper.Vhost = opConf.Vhost
@@ -467,15 +618,35 @@ func (conf *Config) Operators(oc map[string]*OperClass) (map[string]*Oper, error
 	return operators, nil
 }
 
+// loadOperPublicKey loads a PEM-encoded RSA public key, for use with CHALLENGE.
+func loadOperPublicKey(filename string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errInvalidPublicKey
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errInvalidPublicKey
+	}
+	return rsaKey, nil
+}
+
 // TLSListeners returns a list of TLS listeners and their configs.
 func (conf *Config) TLSListeners() (map[string]*tls.Config, error) {
 	tlsListeners := make(map[string]*tls.Config)
 	for s, tlsListenersConf := range conf.Server.TLSListeners {
 		config, err := tlsListenersConf.Config()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Could not configure TLS listener %s: %s", s, err.Error())
 		}
-		config.ClientAuth = tls.RequestClientCert
 		tlsListeners[s] = config
 	}
 	return tlsListeners, nil
@@ -570,6 +741,13 @@ func LoadConfig(filename string) (config *Config, err error) {
 		logConfig.MethodFile = methods["file"]
 		logConfig.MethodStdout = methods["stdout"]
 		logConfig.MethodStderr = methods["stderr"]
+		logConfig.MethodSyslog = methods["syslog"]
+		logConfig.MethodJournald = methods["journald"]
+		if logConfig.MethodSyslog || logConfig.MethodJournald {
+			if err := logConfig.Syslog.Prepare(); err != nil {
+				return nil, err
+			}
+		}
 
 		// levels
 		level, exists := logger.LogLevelNames[strings.ToLower(logConfig.LevelString)]
@@ -610,11 +788,150 @@ func LoadConfig(filename string) (config *Config, err error) {
 		}
 	}
 
+	if config.Server.CheckIdent {
+		config.Server.Ident.Enabled = true
+	}
+	config.Server.Ident.prepare()
+	config.Server.ReverseDNS.prepare()
+	config.Server.JoinBurst.prepare()
+	config.Accounts.Probation.prepare()
+	config.Accounts.PMLimits.prepare()
+	err = config.Accounts.Bots.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if config.Server.BrbTimeout <= 0 {
+		config.Server.BrbTimeout = defaultBrbTimeout
+	}
+
+	err = config.Server.Shutdown.prepare()
+	if err != nil {
+		return nil, err
+	}
+	err = config.Server.CTCP.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Channels.Knock.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Channels.AutoJoin.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Channels.JoinFlood.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Channels.ExitMessages.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Channels.Registration.Verification.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Server.Relaymsg.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Server.Reports.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Server.Metadata.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Server.GeoIP.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range config.Plugins {
+		err = config.Plugins[i].prepare()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	blocklistNames := make(map[string]bool)
+	for i := range config.Blocklists {
+		err = config.Blocklists[i].prepare()
+		if err != nil {
+			return nil, err
+		}
+		if blocklistNames[config.Blocklists[i].Name] {
+			return nil, fmt.Errorf("Duplicate blocklist name: %s", config.Blocklists[i].Name)
+		}
+		blocklistNames[config.Blocklists[i].Name] = true
+	}
+
+	err = config.API.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.Expiration.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.Erasure.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.Personas.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.APITokens.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.AJoin.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.ExternalAuth.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Accounts.JWTAuth.prepare()
+	if err != nil {
+		return nil, err
+	}
+
 	config.Accounts.RequireSasl.exemptedNets, err = utils.ParseNetList(config.Accounts.RequireSasl.Exempted)
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse require-sasl exempted nets: %v", err.Error())
 	}
 
+	config.Server.SaslListeners.exemptedNets, err = utils.ParseNetList(config.Server.SaslListeners.Exempted)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse require-sasl-listeners exempted nets: %v", err.Error())
+	}
+
+	err = config.prepareConnectRules()
+	if err != nil {
+		return nil, err
+	}
+
 	config.Server.proxyAllowedFromNets, err = utils.ParseNetList(config.Server.ProxyAllowedFrom)
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse proxy-allowed-from nets: %v", err.Error())
@@ -642,6 +959,12 @@ func LoadConfig(filename string) (config *Config, err error) {
 		return nil, fmt.Errorf("Could not parse maximum SendQ size (make sure it only contains whole numbers): %s", err.Error())
 	}
 	config.Server.MaxSendQBytes = int(maxSendQBytes)
+	if err = config.Server.UTF8Only.prepare(); err != nil {
+		return nil, err
+	}
+	if config.Server.WriteTimeout == 0 {
+		config.Server.WriteTimeout = defaultWriteTimeout
+	}
 
 	config.languageManager, err = languages.NewManager(config.Languages.Enabled, config.Languages.Path, config.Languages.Default)
 	if err != nil {
@@ -673,6 +996,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 
 	// parse default channel modes
 	config.Channels.defaultModes = ParseDefaultChannelModes(config.Channels.DefaultModes)
+	config.Channels.statusModes, err = parseStatusModes(config.Channels.StatusModes)
+	if err != nil {
+		return nil, err
+	}
 
 	if config.Server.Password != "" {
 		config.Server.passwordBytes, err = decodeLegacyPasswordHash(config.Server.Password)
@@ -685,6 +1012,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Accounts.Registration.BcryptCost = passwd.DefaultCost
 	}
 
+	if config.Limits.AcceptEntries == 0 {
+		config.Limits.AcceptEntries = 100
+	}
+
 	if config.Channels.MaxChannelsPerClient == 0 {
 		config.Channels.MaxChannelsPerClient = 100
 	}
@@ -692,6 +1023,11 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Channels.Registration.MaxChannelsPerAccount = 15
 	}
 
+	err = config.prepareConnectionClasses()
+	if err != nil {
+		return nil, err
+	}
+
 	// in the current implementation, we disable history by creating a history buffer
 	// with zero capacity. but the `enabled` config option MUST be respected regardless
 	// of this detail
@@ -713,5 +1049,62 @@ func LoadConfig(filename string) (config *Config, err error) {
 		}
 	}
 
+	err = config.Server.TorListeners.OnionService.prepare(config.Server.TorListeners.Listeners)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, listenAddress := range config.Server.CompressedListeners.Listeners {
+		found := false
+		for _, configuredListener := range config.Server.Listen {
+			if listenAddress == configuredListener {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is configured as a compressed listener, but is not in server.listen", listenAddress)
+		}
+	}
+	for _, listenAddress := range config.Server.SaslListeners.Listeners {
+		found := false
+		for _, configuredListener := range config.Server.Listen {
+			if listenAddress == configuredListener {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is configured to require SASL, but is not in server.listen", listenAddress)
+		}
+	}
+
+	var multiplexed bool
+	for listenAddress, tlsConf := range config.Server.TLSListeners {
+		if !tlsConf.MultiplexHTTP {
+			continue
+		}
+		multiplexed = true
+		for _, compressedListener := range config.Server.CompressedListeners.Listeners {
+			if listenAddress == compressedListener {
+				return nil, fmt.Errorf("%s multiplexes HTTP, so it cannot be a compressed listener", listenAddress)
+			}
+		}
+	}
+	if config.API.Enabled && config.API.Listener == "" && !multiplexed {
+		return nil, fmt.Errorf("API is enabled, but no listener was configured")
+	}
+
+	if config.Server.CompressedListeners.Level == 0 {
+		config.Server.CompressedListeners.Level = zlib.DefaultCompression
+	} else if config.Server.CompressedListeners.Level < zlib.HuffmanOnly || zlib.BestCompression < config.Server.CompressedListeners.Level {
+		return nil, fmt.Errorf("invalid compression level: %d", config.Server.CompressedListeners.Level)
+	}
+	if config.Server.CompressedListeners.MaxRatio == 0 {
+		config.Server.CompressedListeners.MaxRatio = defaultCompressionMaxRatio
+	} else if config.Server.CompressedListeners.MaxRatio < 0 {
+		return nil, fmt.Errorf("invalid compression max-ratio: %d", config.Server.CompressedListeners.MaxRatio)
+	}
+
 	return config, nil
 }
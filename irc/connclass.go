@@ -0,0 +1,181 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+// Connection classes give groups of clients (e.g., bots, or users from a
+// trusted network) their own resource limits: sendq and recvq sizes, how many
+// channels they can join, fakelag, and how long they can be idle. Clients are
+// put in a class by the connect rules (see connectrules.go); those that aren't
+// are in the "default" class, whose limits are the server-wide settings unless
+// the config defines a class by that name. Any limit a class doesn't set is
+// the server-wide one.
+
+const (
+	defaultConnectionClass = "default"
+)
+
+// ConnectionClassConfig is the config for a connection class.
+type ConnectionClassConfig struct {
+	MaxSendQString string `yaml:"max-sendq"`
+	// the longest line (including tags) the client can send; this can only
+	// raise the limit, since the client's socket is created before it's
+	// assigned to a class
+	MaxRecvQString string `yaml:"max-recvq"`
+	MaxChannels    int    `yaml:"max-channels"`
+	Fakelag        *ConnectionClassFakelagConfig
+	// how long the client can be idle before it's sent a PING
+	IdleTimeout time.Duration `yaml:"idle-timeout"`
+	// how long the client has to respond to the PING
+	PingTimeout time.Duration `yaml:"ping-timeout"`
+}
+
+// ConnectionClassFakelagConfig overrides some or all of the server-wide
+// fakelag settings; settings that are left out keep the server-wide values.
+type ConnectionClassFakelagConfig struct {
+	Enabled           *bool
+	Window            time.Duration
+	BurstLimit        uint `yaml:"burst-limit"`
+	MessagesPerWindow uint `yaml:"messages-per-window"`
+	Cooldown          time.Duration
+}
+
+// apply overrides the fakelag settings that were configured.
+func (info *ConnectionClassFakelagConfig) apply(fakelag *FakelagConfig) {
+	if info.Enabled != nil {
+		fakelag.Enabled = *info.Enabled
+	}
+	if info.Window != 0 {
+		fakelag.Window = info.Window
+	}
+	if info.BurstLimit != 0 {
+		fakelag.BurstLimit = info.BurstLimit
+	}
+	if info.MessagesPerWindow != 0 {
+		fakelag.MessagesPerWindow = info.MessagesPerWindow
+	}
+	if info.Cooldown != 0 {
+		fakelag.Cooldown = info.Cooldown
+	}
+}
+
+// ConnectionClass is a connection class, with the server-wide defaults filled in.
+type ConnectionClass struct {
+	Name          string
+	MaxSendQBytes int
+	MaxRecvQBytes int
+	MaxChannels   int
+	Fakelag       FakelagConfig
+	IdleTimeout   time.Duration
+	PingTimeout   time.Duration
+}
+
+// prepareConnectionClasses assembles the connection classes from the config;
+// this has to run after the server-wide limits are prepared.
+func (config *Config) prepareConnectionClasses() error {
+	config.connectionClasses = make(map[string]*ConnectionClass)
+	if _, exists := config.ConnectionClasses[defaultConnectionClass]; !exists {
+		config.connectionClasses[defaultConnectionClass] = config.newConnectionClass(defaultConnectionClass)
+	}
+	for name, info := range config.ConnectionClasses {
+		class := config.newConnectionClass(name)
+		if info.MaxSendQString != "" {
+			maxSendQBytes, err := bytefmt.ToBytes(info.MaxSendQString)
+			if err != nil {
+				return fmt.Errorf("Could not parse maximum SendQ size for connection class [%s]: %s", name, err.Error())
+			}
+			class.MaxSendQBytes = int(maxSendQBytes)
+		}
+		if info.MaxRecvQString != "" {
+			maxRecvQBytes, err := bytefmt.ToBytes(info.MaxRecvQString)
+			if err != nil {
+				return fmt.Errorf("Could not parse maximum RecvQ size for connection class [%s]: %s", name, err.Error())
+			}
+			if int(maxRecvQBytes) < class.MaxRecvQBytes {
+				return fmt.Errorf("Maximum RecvQ size for connection class [%s] is smaller than the server's", name)
+			}
+			class.MaxRecvQBytes = int(maxRecvQBytes)
+		}
+		if info.MaxChannels != 0 {
+			class.MaxChannels = info.MaxChannels
+		}
+		if info.Fakelag != nil {
+			info.Fakelag.apply(&class.Fakelag)
+		}
+		if info.IdleTimeout != 0 {
+			class.IdleTimeout = info.IdleTimeout
+		}
+		if info.PingTimeout != 0 {
+			class.PingTimeout = info.PingTimeout
+		}
+		if class.IdleTimeout < 0 || class.PingTimeout < 0 || class.MaxChannels < 0 {
+			return fmt.Errorf("Invalid limits for connection class [%s]", name)
+		}
+		if class.Fakelag.Enabled && (class.Fakelag.MessagesPerWindow == 0 || class.Fakelag.Window <= 0) {
+			return fmt.Errorf("Invalid fakelag settings for connection class [%s]", name)
+		}
+		config.connectionClasses[name] = class
+	}
+
+	for _, rule := range config.Server.ConnectRules {
+		if _, exists := config.connectionClasses[rule.Class]; rule.Class != "" && !exists {
+			return fmt.Errorf("Connect rule %s uses connection class [%s], which doesn't exist", rule.Name, rule.Class)
+		}
+	}
+	return nil
+}
+
+// newConnectionClass returns a class with the server-wide limits.
+func (config *Config) newConnectionClass(name string) *ConnectionClass {
+	return &ConnectionClass{
+		Name:          name,
+		MaxSendQBytes: config.Server.MaxSendQBytes,
+		MaxRecvQBytes: connectionRecvQBytes(config),
+		MaxChannels:   config.Channels.MaxChannelsPerClient,
+		Fakelag:       config.Fakelag,
+		IdleTimeout:   DefaultIdleTimeout,
+		PingTimeout:   DefaultTotalTimeout - DefaultIdleTimeout,
+	}
+}
+
+// connectionRecvQBytes returns the size of the buffer for reading lines from
+// clients, before they're assigned to a class.
+func connectionRecvQBytes(config *Config) int {
+	// give them 1k of grace over the limit:
+	return ircmsg.MaxlenTagsFromClient + config.Limits.LineLen.Rest + 1024
+}
+
+// ConnectionClass returns the named connection class, or the default class if
+// there's no such class (e.g., because it was removed by a rehash).
+func (config *Config) ConnectionClass(name string) *ConnectionClass {
+	if class, exists := config.connectionClasses[name]; exists {
+		return class
+	}
+	return config.connectionClasses[defaultConnectionClass]
+}
+
+// connectionClass returns the client's connection class.
+func (client *Client) connectionClass() *ConnectionClass {
+	return client.server.Config().ConnectionClass(client.ConnectClass())
+}
+
+// applyConnectionClass puts a registering client in a connection class (or in
+// the default class, if `name` is empty or doesn't exist). This
+// must be called from the client's own goroutine, since it resizes the buffer
+// the client's lines are read into.
+func (client *Client) applyConnectionClass(name string) {
+	client.setConnectClass(name)
+	class := client.connectionClass()
+	client.socket.SetMaxSendQ(class.MaxSendQBytes)
+	client.socket.SetMaxRecvQ(class.MaxRecvQBytes)
+	client.resetFakelag()
+	client.idletimer.Touch()
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrepareConnectionClasses(t *testing.T) {
+	var config Config
+	config.Server.MaxSendQBytes = 16384
+	config.Channels.MaxChannelsPerClient = 100
+	config.Limits.LineLen.Rest = 512
+	config.ConnectionClasses = map[string]*ConnectionClassConfig{
+		"bots": {
+			MaxSendQString: "96k",
+			MaxChannels:    500,
+			IdleTimeout:    5 * time.Minute,
+		},
+	}
+	config.Server.ConnectRules = []ConnectRule{{Name: "bots", Class: "bots"}}
+	if err := config.prepareConnectionClasses(); err != nil {
+		t.Fatal(err)
+	}
+
+	bots := config.ConnectionClass("bots")
+	if bots.MaxSendQBytes != 96*1024 || bots.MaxChannels != 500 || bots.IdleTimeout != 5*time.Minute {
+		t.Errorf("class limits weren't applied: %#v", bots)
+	}
+	// limits the class doesn't set are inherited from the server
+	if bots.MaxRecvQBytes != connectionRecvQBytes(&config) || bots.PingTimeout != DefaultTotalTimeout-DefaultIdleTimeout {
+		t.Errorf("server limits weren't inherited: %#v", bots)
+	}
+	if class := config.ConnectionClass("nonexistent"); class.Name != defaultConnectionClass || class.MaxChannels != 100 {
+		t.Errorf("unknown classes should fall back to the default class: %#v", class)
+	}
+
+	config.Server.ConnectRules = []ConnectRule{{Name: "typo", Class: "bot"}}
+	if err := config.prepareConnectionClasses(); err == nil {
+		t.Errorf("connect rules shouldn't be able to use nonexistent classes")
+	}
+
+	config.Server.ConnectRules = nil
+	config.ConnectionClasses["bots"].MaxRecvQString = "1k"
+	if err := config.prepareConnectionClasses(); err == nil {
+		t.Errorf("classes shouldn't be able to lower the recvq")
+	}
+}
+
+func TestConnectionClassFakelag(t *testing.T) {
+	var config Config
+	config.Fakelag = FakelagConfig{Enabled: true, Window: time.Second, BurstLimit: 5, MessagesPerWindow: 2, Cooldown: 2 * time.Second}
+	config.ConnectionClasses = map[string]*ConnectionClassConfig{
+		"bots": {Fakelag: &ConnectionClassFakelagConfig{BurstLimit: 20}},
+	}
+	if err := config.prepareConnectionClasses(); err != nil {
+		t.Fatal(err)
+	}
+	// settings the class doesn't set are inherited from the server
+	expected := config.Fakelag
+	expected.BurstLimit = 20
+	if fakelag := config.ConnectionClass("bots").Fakelag; fakelag != expected {
+		t.Errorf("unexpected fakelag settings: %#v", fakelag)
+	}
+
+	disabled := false
+	config.ConnectionClasses["bots"].Fakelag = &ConnectionClassFakelagConfig{Enabled: &disabled}
+	if err := config.prepareConnectionClasses(); err != nil {
+		t.Fatal(err)
+	}
+	if config.ConnectionClass("bots").Fakelag.Enabled {
+		t.Errorf("class should be able to disable fakelag")
+	}
+
+	// this would divide by zero when fakelag kicks in
+	config.Fakelag = FakelagConfig{}
+	enabled := true
+	config.ConnectionClasses["bots"].Fakelag = &ConnectionClassFakelagConfig{Enabled: &enabled, BurstLimit: 20}
+	if err := config.prepareConnectionClasses(); err == nil {
+		t.Errorf("classes shouldn't be able to enable fakelag without a rate")
+	}
+}
+
+func TestDefaultConnectionClass(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.ConnectionClasses = map[string]*ConnectionClassConfig{
+			defaultConnectionClass: {MaxSendQString: "3k"},
+		}
+		if err := config.prepareConnectionClasses(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer h.Close()
+
+	h.Register("alice")
+	alice := h.server.clients.Get("alice")
+	alice.socket.Lock()
+	maxSendQBytes := alice.socket.maxSendQBytes
+	alice.socket.Unlock()
+	if maxSendQBytes != 3*1024 {
+		t.Errorf("default class wasn't applied, sendq is %d", maxSendQBytes)
+	}
+}
+
+func TestConnectionClassMaxChannels(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.ConnectionClasses = map[string]*ConnectionClassConfig{
+			"restricted": {MaxChannels: 1},
+		}
+		config.Server.ConnectRules = prepareTestConnectRules(t, []ConnectRule{
+			{Name: "restricted", Match: ConnectRuleMatch{Ident: "*restricted"}, Class: "restricted"},
+		})
+		if err := config.prepareConnectionClasses(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer h.Close()
+
+	alice := h.Connect()
+	alice.Send("NICK", "alice")
+	alice.Send("USER", "restricted", "0", "*", "simulated client")
+	alice.Expect(RPL_WELCOME)
+	alice.Send("JOIN", "#a")
+	alice.Expect(RPL_ENDOFNAMES)
+	alice.Send("JOIN", "#b")
+	alice.Expect(ERR_TOOMANYCHANNELS)
+
+	bob := h.Register("bob")
+	for _, channel := range []string{"#a", "#b"} {
+		bob.Send("JOIN", channel)
+		bob.Expect(RPL_ENDOFNAMES)
+	}
+}
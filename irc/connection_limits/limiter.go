@@ -7,8 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"sync"
 
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/utils"
 )
 
@@ -28,7 +28,7 @@ var (
 
 // Limiter manages the automated client connection limits.
 type Limiter struct {
-	sync.Mutex
+	lockorder.Tier0Mutex
 
 	enabled  bool
 	ipv4Mask net.IPMask
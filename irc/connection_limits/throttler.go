@@ -6,9 +6,9 @@ package connection_limits
 import (
 	"fmt"
 	"net"
-	"sync"
 	"time"
 
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/utils"
 )
 
@@ -71,7 +71,7 @@ func (g *GenericThrottle) touch(now time.Time) (throttled bool, remainingTime ti
 
 // Throttler manages automated client connection throttling.
 type Throttler struct {
-	sync.RWMutex
+	lockorder.Tier0RWMutex
 
 	enabled     bool
 	ipv4Mask    net.IPMask
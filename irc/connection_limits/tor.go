@@ -5,8 +5,9 @@ package connection_limits
 
 import (
 	"errors"
-	"sync"
 	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
 )
 
 var (
@@ -18,7 +19,7 @@ var (
 // proxied from a Tor hidden service (so we don't have meaningful IPs,
 // a notion of CIDR width, etc.)
 type TorLimiter struct {
-	sync.Mutex
+	lockorder.Tier0Mutex
 
 	numConnections int
 	maxConnections int
@@ -0,0 +1,250 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// Connect rules decide what happens to clients as they complete registration,
+// based on what's known about them by then: their ident and hostname, their
+// realname, their IP, whether they're using TLS or Tor, and the account they
+// authenticated to with SASL. The rules are evaluated in order, and every rule
+// that matches applies its actions: it can reject the client, require it to
+// authenticate with SASL, set user modes, or put it in a connection class (see
+// connclass.go). The older settings that required SASL (accounts.require-sasl
+// and tor-listeners.require-sasl) are implemented as connect rules, evaluated
+// before the ones in the config.
+
+// ConnectRuleMatch is the condition of a connect rule: every field that's set
+// has to match the client. Globs are matched case-insensitively.
+type ConnectRuleMatch struct {
+	// glob for the username (which starts with ~ if there was no ident response)
+	Ident string
+	// glob for the hostname (from reverse DNS, or the IP if there was none)
+	Hostname string
+	// glob for the realname
+	Realname string
+	// the client's IP is in one of these networks
+	CIDRs []string `yaml:"cidrs"`
+	// the client's IP isn't in any of these networks
+	Exempted []string
+	TLS      *bool `yaml:"tls"`
+	Tor      *bool
+	// whether the client authenticated with SASL
+	Authenticated *bool
+	// glob for the account the client authenticated to
+	Account string
+
+	ident        *utils.Glob
+	hostname     *utils.Glob
+	realname     *utils.Glob
+	account      *utils.Glob
+	nets         []net.IPNet
+	exemptedNets []net.IPNet
+}
+
+// ConnectRule is a condition and the actions to take for clients that match it.
+type ConnectRule struct {
+	// for logging
+	Name  string
+	Match ConnectRuleMatch
+	// if set, matching clients are rejected with this message
+	Reject string
+	// matching clients have to authenticate with SASL
+	RequireSasl bool `yaml:"require-sasl"`
+	// user modes to set on matching clients, e.g., "+iR"
+	AddModes string `yaml:"add-modes"`
+	// connection class to put matching clients in
+	Class string
+
+	addModes modes.ModeChanges
+}
+
+// connect rules can only set these user modes
+var connectRuleModes = map[modes.Mode]bool{
+	modes.Bot:             true,
+	modes.CallerID:        true,
+	modes.Invisible:       true,
+	modes.RegisteredOnly:  true,
+	modes.UserRoleplaying: true,
+	modes.WallOps:         true,
+}
+
+func compileConnectRuleGlob(glob string) *utils.Glob {
+	if glob == "" {
+		return nil
+	}
+	return utils.CompileGlobCached(strings.ToLower(glob))
+}
+
+func (match *ConnectRuleMatch) prepare() (err error) {
+	match.ident = compileConnectRuleGlob(match.Ident)
+	match.hostname = compileConnectRuleGlob(match.Hostname)
+	match.realname = compileConnectRuleGlob(match.Realname)
+	match.account = compileConnectRuleGlob(match.Account)
+	if match.nets, err = utils.ParseNetList(match.CIDRs); err != nil {
+		return
+	}
+	match.exemptedNets, err = utils.ParseNetList(match.Exempted)
+	return
+}
+
+func (rule *ConnectRule) prepare() error {
+	if err := rule.Match.prepare(); err != nil {
+		return fmt.Errorf("invalid connect rule %s: %v", rule.Name, err)
+	}
+	rule.addModes = nil
+	if rule.AddModes != "" {
+		changes, unknown := modes.ParseUserModeChanges(rule.AddModes)
+		if len(unknown) != 0 {
+			return fmt.Errorf("invalid modes in connect rule %s: %s", rule.Name, rule.AddModes)
+		}
+		for _, change := range changes {
+			if change.Op != modes.Add || !connectRuleModes[change.Mode] {
+				return fmt.Errorf("connect rule %s can't set mode %s", rule.Name, change.Mode.String())
+			}
+		}
+		rule.addModes = changes
+	}
+	return nil
+}
+
+// legacyConnectRules returns the connect rules equivalent to the older
+// settings that required SASL.
+func (config *Config) legacyConnectRules() (rules []ConnectRule) {
+	if config.Server.TorListeners.RequireSasl {
+		tor := true
+		rules = append(rules, ConnectRule{
+			Name:        "tor-listeners.require-sasl",
+			Match:       ConnectRuleMatch{Tor: &tor},
+			RequireSasl: true,
+		})
+	}
+	if config.Accounts.RequireSasl.Enabled {
+		rules = append(rules, ConnectRule{
+			Name:        "accounts.require-sasl",
+			Match:       ConnectRuleMatch{Exempted: config.Accounts.RequireSasl.Exempted},
+			RequireSasl: true,
+		})
+	}
+	return
+}
+
+func (config *Config) prepareConnectRules() error {
+	config.Server.ConnectRules = append(config.legacyConnectRules(), config.Server.ConnectRules...)
+	for i := range config.Server.ConnectRules {
+		rule := &config.Server.ConnectRules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := rule.prepare(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connectRuleSubject is what the rules are matched against.
+type connectRuleSubject struct {
+	ident    string
+	hostname string
+	realname string
+	ip       net.IP
+	tls      bool
+	tor      bool
+	account  string
+}
+
+func matchesConnectRuleGlob(glob *utils.Glob, str string) bool {
+	return glob == nil || glob.MatchString(strings.ToLower(str))
+}
+
+func (match *ConnectRuleMatch) matches(subject *connectRuleSubject) bool {
+	if !matchesConnectRuleGlob(match.ident, subject.ident) ||
+		!matchesConnectRuleGlob(match.hostname, subject.hostname) ||
+		!matchesConnectRuleGlob(match.realname, subject.realname) {
+		return false
+	}
+	if match.account != nil && (subject.account == "" || !match.account.MatchString(strings.ToLower(subject.account))) {
+		return false
+	}
+	if match.Authenticated != nil && *match.Authenticated != (subject.account != "") {
+		return false
+	}
+	if match.TLS != nil && *match.TLS != subject.tls {
+		return false
+	}
+	if match.Tor != nil && *match.Tor != subject.tor {
+		return false
+	}
+	if len(match.nets) != 0 && !utils.IPInNets(subject.ip, match.nets) {
+		return false
+	}
+	return !utils.IPInNets(subject.ip, match.exemptedNets)
+}
+
+// connectVerdict is the combined result of the rules that matched a client.
+type connectVerdict struct {
+	// the rule that rejected the client, if any
+	rejectedBy  *ConnectRule
+	requireSasl bool
+	addModes    modes.ModeChanges
+	class       string
+}
+
+func evaluateConnectRules(rules []ConnectRule, subject *connectRuleSubject) (verdict connectVerdict) {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Match.matches(subject) {
+			continue
+		}
+		if rule.Reject != "" {
+			verdict.rejectedBy = rule
+			return
+		}
+		verdict.requireSasl = verdict.requireSasl || rule.RequireSasl
+		verdict.addModes = append(verdict.addModes, rule.addModes...)
+		if rule.Class != "" {
+			verdict.class = rule.Class
+		}
+	}
+	return
+}
+
+// applyConnectRules evaluates the connect rules for a client that's completing
+// registration, and applies their actions; if the client is rejected, it
+// returns the message to disconnect it with.
+func (server *Server) applyConnectRules(client *Client, config *Config) (rejection string) {
+	subject := connectRuleSubject{
+		ident:    client.Username(),
+		hostname: client.RawHostname(),
+		realname: client.Realname(),
+		ip:       client.IP(),
+		tls:      client.HasMode(modes.TLS),
+		tor:      client.isTor,
+		account:  client.Account(),
+	}
+	verdict := evaluateConnectRules(config.Server.ConnectRules, &subject)
+	if verdict.rejectedBy != nil {
+		server.logger.Info("localconnect", fmt.Sprintf("Client from %s rejected by connect rule %s", client.IPString(), verdict.rejectedBy.Name))
+		return verdict.rejectedBy.Reject
+	}
+	if verdict.requireSasl && subject.account == "" {
+		client.Send(nil, server.name, "FAIL", "*", "ACCOUNT_REQUIRED", client.t(errSaslRequired.Error()))
+		return client.t(errSaslRequired.Error())
+	}
+	if len(verdict.addModes) != 0 {
+		ApplyUserModeChanges(client, verdict.addModes, false)
+	}
+	// clients that no rule put in a class are in the default class, which
+	// the config may also have changed the limits of
+	client.applyConnectionClass(verdict.class)
+	return ""
+}
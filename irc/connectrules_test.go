@@ -0,0 +1,121 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func prepareTestConnectRules(t *testing.T, rules []ConnectRule) []ConnectRule {
+	for i := range rules {
+		if err := rules[i].prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rules
+}
+
+func TestConnectRuleMatch(t *testing.T) {
+	yes := true
+	rules := prepareTestConnectRules(t, []ConnectRule{
+		{
+			Name:  "bots",
+			Match: ConnectRuleMatch{Ident: "*BOT", CIDRs: []string{"192.0.2.0/24"}, Exempted: []string{"192.0.2.1"}},
+			Class: "bots",
+		},
+		{
+			Name:        "tor",
+			Match:       ConnectRuleMatch{Tor: &yes},
+			RequireSasl: true,
+		},
+		{
+			Name:     "users",
+			Match:    ConnectRuleMatch{Authenticated: &yes, Account: "dan*"},
+			AddModes: "+iR",
+			Class:    "users",
+		},
+		{
+			Name:   "spam",
+			Match:  ConnectRuleMatch{Realname: "*followers*"},
+			Reject: "no spam",
+		},
+	})
+
+	subject := connectRuleSubject{ident: "~mybot", ip: net.ParseIP("192.0.2.5")}
+	if verdict := evaluateConnectRules(rules, &subject); verdict.class != "bots" || verdict.requireSasl {
+		t.Errorf("unexpected verdict: %v", verdict)
+	}
+	subject.ip = net.ParseIP("192.0.2.1")
+	if verdict := evaluateConnectRules(rules, &subject); verdict.class != "" {
+		t.Errorf("exempted IP shouldn't match: %v", verdict)
+	}
+
+	subject = connectRuleSubject{ident: "~u", ip: net.ParseIP("10.0.0.1"), tor: true, account: "dan"}
+	verdict := evaluateConnectRules(rules, &subject)
+	if !verdict.requireSasl || verdict.class != "users" || len(verdict.addModes) != 2 {
+		t.Errorf("unexpected verdict: %v", verdict)
+	}
+	subject.account = "shivaram"
+	if verdict := evaluateConnectRules(rules, &subject); verdict.class != "" {
+		t.Errorf("account glob shouldn't match: %v", verdict)
+	}
+
+	subject.realname = "cheap FOLLOWERS"
+	if verdict := evaluateConnectRules(rules, &subject); verdict.rejectedBy == nil || verdict.rejectedBy.Name != "spam" {
+		t.Errorf("client should be rejected: %v", verdict)
+	}
+}
+
+func TestConnectRuleModes(t *testing.T) {
+	rule := ConnectRule{Name: "opers", AddModes: "+o"}
+	if err := rule.prepare(); err == nil {
+		t.Errorf("connect rules shouldn't be able to set +o")
+	}
+	rule = ConnectRule{Name: "remove", AddModes: "-i"}
+	if err := rule.prepare(); err == nil {
+		t.Errorf("connect rules can only add modes")
+	}
+}
+
+func TestLegacyConnectRules(t *testing.T) {
+	var config Config
+	config.Accounts.RequireSasl.Enabled = true
+	config.Accounts.RequireSasl.Exempted = []string{"127.0.0.1"}
+	if err := config.prepareConnectRules(); err != nil {
+		t.Fatal(err)
+	}
+	subject := connectRuleSubject{ip: net.ParseIP("10.0.0.1")}
+	if verdict := evaluateConnectRules(config.Server.ConnectRules, &subject); !verdict.requireSasl {
+		t.Errorf("require-sasl should apply")
+	}
+	subject.ip = net.ParseIP("127.0.0.1")
+	if verdict := evaluateConnectRules(config.Server.ConnectRules, &subject); verdict.requireSasl {
+		t.Errorf("exempted IPs shouldn't require SASL")
+	}
+}
+
+func TestConnectRules(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Server.ConnectRules = prepareTestConnectRules(t, []ConnectRule{
+			{Name: "spam", Match: ConnectRuleMatch{Realname: "*followers*"}, Reject: "no spam"},
+			{Name: "everyone", AddModes: "+R", Class: "users"},
+		})
+	})
+	defer h.Close()
+
+	h.Register("alice")
+	if alice := h.server.clients.Get("alice"); !alice.HasMode(modes.RegisteredOnly) || alice.ConnectClass() != "users" {
+		t.Errorf("connect rule wasn't applied")
+	}
+
+	spammer := h.Connect()
+	spammer.Send("NICK", "spammer")
+	spammer.Send("USER", "u", "0", "*", "buy followers")
+	if msg := spammer.Expect("ERROR"); len(msg.Params) != 1 || msg.Params[0] != "no spam" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/connection_limits"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// CTCP messages other than ACTION (VERSION, PING, DCC, and so on) are
+// subject to a policy: they can be allowed, stripped (the CTCP parts of the
+// message are silently removed), or blocked (the message is rejected with an
+// error). The policy is set globally for channels and for users, and channel
+// founders can override it for their channels. Separately, CTCP requests are
+// rate-limited per client, and opers are notified of clients that send them
+// to many different targets, which is typical of VERSION floods.
+
+const (
+	ctcpPolicyAllow = "allow"
+	ctcpPolicyStrip = "strip"
+	ctcpPolicyBlock = "block"
+
+	defaultCTCPMessages = 5
+	defaultCTCPWindow   = 10 * time.Second
+)
+
+// CTCPConfig controls the handling of CTCP messages.
+type CTCPConfig struct {
+	// policies for CTCP messages to channels and to users
+	ChannelPolicy string `yaml:"channel-policy"`
+	UserPolicy    string `yaml:"user-policy"`
+	// each client can send at most this many CTCP requests per window
+	MaxMessages int `yaml:"max-messages"`
+	Window      time.Duration
+	// opers are notified when a client sends CTCP requests to at least this
+	// many different targets within the window (0 to disable)
+	MassThreshold int `yaml:"mass-threshold"`
+}
+
+func validCTCPPolicy(policy string) bool {
+	switch policy {
+	case ctcpPolicyAllow, ctcpPolicyStrip, ctcpPolicyBlock:
+		return true
+	default:
+		return false
+	}
+}
+
+func (conf *CTCPConfig) prepare() error {
+	if conf.ChannelPolicy == "" {
+		conf.ChannelPolicy = ctcpPolicyAllow
+	}
+	if conf.UserPolicy == "" {
+		conf.UserPolicy = ctcpPolicyAllow
+	}
+	if !validCTCPPolicy(conf.ChannelPolicy) || !validCTCPPolicy(conf.UserPolicy) {
+		return fmt.Errorf("CTCP policies must be one of allow, strip, or block")
+	}
+	if conf.MaxMessages == 0 {
+		conf.MaxMessages = defaultCTCPMessages
+	}
+	if conf.Window == 0 {
+		conf.Window = defaultCTCPWindow
+	}
+	return nil
+}
+
+// isCTCPMessage returns whether the message contains CTCP other than ACTION.
+func isCTCPMessage(message string) bool {
+	return strings.IndexByte(message, '\x01') != -1 && !strings.HasPrefix(message, "\x01ACTION")
+}
+
+// stripCTCP removes the CTCP parts of a message.
+func stripCTCP(message string) string {
+	var buf strings.Builder
+	for {
+		start := strings.IndexByte(message, '\x01')
+		if start == -1 {
+			buf.WriteString(message)
+			break
+		}
+		buf.WriteString(message[:start])
+		end := strings.IndexByte(message[start+1:], '\x01')
+		if end == -1 {
+			// unterminated, the rest of the message is CTCP
+			break
+		}
+		message = message[start+1+end+1:]
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// ctcpState tracks a client's recent CTCP requests.
+type ctcpState struct {
+	throttle connection_limits.GenericThrottle
+	// recent targets, for detecting mass CTCP
+	targets  map[string]time.Time
+	notified time.Time
+}
+
+// touchCTCP records a CTCP request to the given (casefolded) target, returning
+// whether it's within the rate limit, and whether the client has now sent
+// requests to enough different targets that opers should be notified.
+func (client *Client) touchCTCP(config *CTCPConfig, target string) (allowed, massCTCP bool) {
+	now := time.Now()
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	state := &client.ctcp
+	state.throttle.Duration, state.throttle.Limit = config.Window, config.MaxMessages
+	throttled, _ := state.throttle.Touch()
+
+	if config.MassThreshold != 0 {
+		if state.targets == nil {
+			state.targets = make(map[string]time.Time)
+		}
+		for existing, sent := range state.targets {
+			if config.Window <= now.Sub(sent) {
+				delete(state.targets, existing)
+			}
+		}
+		state.targets[target] = now
+		// notify at most once per window
+		if config.MassThreshold <= len(state.targets) && config.Window <= now.Sub(state.notified) {
+			state.notified = now
+			massCTCP = true
+		}
+	}
+	return !throttled, massCTCP
+}
+
+// filterCTCP applies the CTCP policy to a message from the client to a
+// channel (if `channel` is non-nil) or a user. It returns the message to
+// send, which may have been stripped, and whether it may be sent at all.
+func (server *Server) filterCTCP(client *Client, command string, target string, channel *Channel, splitMsg utils.SplitMessage, rb *ResponseBuffer) (result utils.SplitMessage, allowed bool) {
+	message := splitMsg.Message
+	if !isCTCPMessage(message) {
+		return splitMsg, true
+	}
+	config := &server.Config().Server.CTCP
+
+	// rate limits apply to requests, not to replies (which are sent as NOTICE)
+	if command == "PRIVMSG" {
+		allowed, massCTCP := client.touchCTCP(config, target)
+		if massCTCP {
+			server.logger.Warning("ctcp", "Mass CTCP detected from", client.NickMaskString())
+			server.snomasks.Send(sno.LocalFlood, fmt.Sprintf(ircfmt.Unescape("Mass CTCP detected from $c[grey][$r%s$c[grey]]"), client.NickMaskString()))
+		}
+		if !allowed {
+			rb.Notice(client.t("You are sending CTCP messages too quickly"))
+			return splitMsg, false
+		}
+	}
+
+	policy := config.UserPolicy
+	if channel != nil {
+		policy = config.ChannelPolicy
+		if channelPolicy := channel.CTCPPolicy(); channelPolicy != "" {
+			policy = channelPolicy
+		}
+	}
+	switch policy {
+	case ctcpPolicyStrip:
+		stripped := stripCTCP(message)
+		return utils.MakeSplitMessage(stripped), stripped != ""
+	case ctcpPolicyBlock:
+		if command != "NOTICE" {
+			if channel != nil {
+				rb.Add(nil, server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), client.t("CTCP messages are not allowed in this channel"))
+			} else {
+				rb.Notice(client.t("CTCP messages to users are not allowed"))
+			}
+		}
+		return splitMsg, false
+	}
+	return splitMsg, true
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestIsCTCPMessage(t *testing.T) {
+	if isCTCPMessage("hello") || isCTCPMessage("\x01ACTION waves\x01") {
+		t.Errorf("ordinary messages and ACTION aren't CTCP")
+	}
+	if !isCTCPMessage("\x01VERSION\x01") || !isCTCPMessage("hi \x01PING 123\x01") {
+		t.Errorf("CTCP not detected")
+	}
+}
+
+func TestStripCTCP(t *testing.T) {
+	cases := map[string]string{
+		"\x01VERSION\x01":              "",
+		"hi \x01PING 123\x01":          "hi",
+		"a \x01X\x01 b \x01Y\x01 c":    "a  b  c",
+		"text \x01DCC SEND unfinished": "text",
+	}
+	for input, expected := range cases {
+		if result := stripCTCP(input); result != expected {
+			t.Errorf("stripCTCP(%q) = %q, expected %q", input, result, expected)
+		}
+	}
+}
+
+func TestTouchCTCP(t *testing.T) {
+	config := CTCPConfig{MaxMessages: 3, MassThreshold: 2}
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{}
+
+	allowed, mass := client.touchCTCP(&config, "alice")
+	if !allowed || mass {
+		t.Errorf("first request should be allowed")
+	}
+	allowed, mass = client.touchCTCP(&config, "bob")
+	if !allowed || !mass {
+		t.Errorf("second target should trigger the mass CTCP notification")
+	}
+	allowed, mass = client.touchCTCP(&config, "carol")
+	if !allowed || mass {
+		t.Errorf("mass CTCP should only be reported once per window")
+	}
+	if allowed, _ = client.touchCTCP(&config, "alice"); allowed {
+		t.Errorf("requests over the rate limit should be refused")
+	}
+}
+
+func TestCTCPConfig(t *testing.T) {
+	config := CTCPConfig{ChannelPolicy: "discard"}
+	if err := config.prepare(); err == nil {
+		t.Errorf("invalid policy was accepted")
+	}
+}
@@ -6,9 +6,12 @@ package irc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,7 +25,7 @@ const (
 	// 'version' of the database schema
 	keySchemaVersion = "db.version"
 	// latest schema of the db
-	latestDbSchema = "5"
+	latestDbSchema = "7"
 )
 
 type SchemaChanger func(*Config, *buntdb.Tx) error
@@ -36,22 +39,40 @@ type SchemaChange struct {
 // maps an initial version to a schema change capable of upgrading it
 var schemaChanges map[string]SchemaChange
 
+var (
+	errNoSchemaVersion = errors.New("Datastore has no schema version; it may not have been created with `oragono initdb`")
+)
+
 type incompatibleSchemaError struct {
 	currentVersion  string
 	requiredVersion string
+	// the datastore was written by a newer version of oragono, so it can't
+	// be used (let alone upgraded) without risking corruption
+	tooNew bool
 }
 
 func IncompatibleSchemaError(currentVersion string) (result *incompatibleSchemaError) {
 	return &incompatibleSchemaError{
 		currentVersion:  currentVersion,
 		requiredVersion: latestDbSchema,
+		tooNew:          schemaIsNewer(currentVersion, latestDbSchema),
 	}
 }
 
 func (err *incompatibleSchemaError) Error() string {
+	if err.tooNew {
+		return fmt.Sprintf("Database schema v%s is newer than this version of oragono supports (v%s); refusing to use it", err.currentVersion, err.requiredVersion)
+	}
 	return fmt.Sprintf("Database requires update. Expected schema v%s, got v%s", err.requiredVersion, err.currentVersion)
 }
 
+// schemaIsNewer returns whether schema version `a` is newer than `b`.
+func schemaIsNewer(a, b string) bool {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+	return aErr == nil && bErr == nil && bNum < aNum
+}
+
 // InitDB creates the database, implementing the `oragono initdb` command.
 func InitDB(path string) {
 	_, err := os.Stat(path)
@@ -78,6 +99,8 @@ func initializeDB(path string) error {
 	err = store.Update(func(tx *buntdb.Tx) error {
 		// set schema version
 		tx.Set(keySchemaVersion, latestDbSchema, nil)
+		// account activity is recorded from the start
+		tx.Set(keyAccountActivitySince, strconv.FormatInt(time.Now().Unix(), 10), nil)
 		return nil
 	})
 
@@ -109,6 +132,9 @@ func openDatabaseInternal(config *Config, allowAutoupgrade bool) (db *buntdb.DB,
 		version, err = tx.Get(keySchemaVersion)
 		return err
 	})
+	if err == buntdb.ErrNotFound {
+		err = errNoSchemaVersion
+	}
 	if err != nil {
 		return
 	}
@@ -117,6 +143,11 @@ func openDatabaseInternal(config *Config, allowAutoupgrade bool) (db *buntdb.DB,
 		// success
 		return
 	}
+	if schemaIsNewer(version, latestDbSchema) {
+		// don't attempt an upgrade, or even a backup
+		err = IncompatibleSchemaError(version)
+		return
+	}
 
 	// XXX quiesce the DB so we can be sure it's safe to make a backup copy
 	db.Close()
@@ -135,8 +166,13 @@ func openDatabaseInternal(config *Config, allowAutoupgrade bool) (db *buntdb.DB,
 }
 
 func performAutoUpgrade(currentVersion string, config *Config) (err error) {
-	path := config.Datastore.Path
 	log.Printf("attempting to auto-upgrade schema from version %s to %s\n", currentVersion, latestDbSchema)
+	return backupAndUpgradeDB(currentVersion, config)
+}
+
+// backupAndUpgradeDB backs up the datastore, then upgrades it.
+func backupAndUpgradeDB(currentVersion string, config *Config) (err error) {
+	path := config.Datastore.Path
 	timestamp := time.Now().UTC().Format("2006-01-02-15:04:05.000Z")
 	backupPath := fmt.Sprintf("%s.v%s.%s.bak", path, currentVersion, timestamp)
 	log.Printf("making a backup of current database at %s\n", backupPath)
@@ -145,7 +181,7 @@ func performAutoUpgrade(currentVersion string, config *Config) (err error) {
 		return err
 	}
 
-	err = UpgradeDB(config)
+	err = upgradeDB(config)
 	if err != nil {
 		// database upgrade is a single transaction, so we don't need to restore the backup;
 		// we can just delete it
@@ -154,8 +190,34 @@ func performAutoUpgrade(currentVersion string, config *Config) (err error) {
 	return err
 }
 
-// UpgradeDB upgrades the datastore to the latest schema.
+// UpgradeDB implements the `oragono upgradedb` command, upgrading the datastore
+// to the latest schema; as with automatic upgrades, it's backed up first.
 func UpgradeDB(config *Config) (err error) {
+	store, err := buntdb.Open(config.Datastore.Path)
+	if err != nil {
+		return err
+	}
+	var version string
+	err = store.View(func(tx *buntdb.Tx) error {
+		version, err = tx.Get(keySchemaVersion)
+		return err
+	})
+	store.Close()
+	if err == buntdb.ErrNotFound {
+		return errNoSchemaVersion
+	} else if err != nil {
+		return err
+	} else if version == latestDbSchema {
+		return nil
+	} else if schemaIsNewer(version, latestDbSchema) {
+		return IncompatibleSchemaError(version)
+	}
+	return backupAndUpgradeDB(version, config)
+}
+
+// upgradeDB performs the schema changes required to bring the datastore up to
+// the latest schema, in a single transaction.
+func upgradeDB(config *Config) (err error) {
 	store, err := buntdb.Open(config.Datastore.Path)
 	if err != nil {
 		return err
@@ -195,6 +257,75 @@ func UpgradeDB(config *Config) (err error) {
 	return err
 }
 
+// CheckNames implements the `oragono checknames` command. It checks the names of
+// registered accounts and channels in the datastore against the current casemapping,
+// returning a description of each problem: names that are no longer valid, names
+// that are stored under the wrong key, and names that collide with each other,
+// either directly or by being confusable.
+func CheckNames(config *Config) (problems []string, err error) {
+	store, err := buntdb.Open(config.Datastore.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	err = store.View(func(tx *buntdb.Tx) error {
+		problems = checkNames(tx)
+		return nil
+	})
+	return
+}
+
+func checkNames(tx *buntdb.Tx) (problems []string) {
+	type nameChecker struct {
+		description string
+		prefix      string
+		casefold    func(string) (string, error)
+		skeleton    func(string) (string, error)
+	}
+	checkers := []nameChecker{
+		{"account", strings.TrimSuffix(keyAccountName, "%s"), CasefoldName, Skeleton},
+		{"channel", strings.TrimSuffix(keyChannelName, "%s"), CasefoldChannel, ChannelSkeleton},
+	}
+
+	for _, checker := range checkers {
+		byCasefold := make(map[string][]string)
+		bySkeleton := make(map[string][]string)
+		tx.AscendGreaterOrEqual("", checker.prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, checker.prefix) {
+				return false
+			}
+			storedKey := strings.TrimPrefix(key, checker.prefix)
+			casefolded, err := checker.casefold(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s is not a valid name: %v", checker.description, value, err))
+				return true
+			}
+			if casefolded != storedKey {
+				problems = append(problems, fmt.Sprintf("%s %s casefolds to %s, but is stored as %s", checker.description, value, casefolded, storedKey))
+			}
+			byCasefold[casefolded] = append(byCasefold[casefolded], value)
+			if skeleton, err := checker.skeleton(value); err == nil {
+				bySkeleton[skeleton] = append(bySkeleton[skeleton], value)
+			}
+			return true
+		})
+
+		for _, names := range byCasefold {
+			if 1 < len(names) {
+				problems = append(problems, fmt.Sprintf("%s names collide: %s", checker.description, strings.Join(names, ", ")))
+			}
+		}
+		for _, names := range bySkeleton {
+			if 1 < len(names) {
+				problems = append(problems, fmt.Sprintf("%s names are confusable: %s", checker.description, strings.Join(names, ", ")))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return
+}
+
 func schemaChangeV1toV2(config *Config, tx *buntdb.Tx) error {
 	// == version 1 -> 2 ==
 	// account key changes and account.verified key bugfix.
@@ -409,6 +540,45 @@ func schemaChangeV4ToV5(config *Config, tx *buntdb.Tx) error {
 	return nil
 }
 
+//  1. index registered channels by the skeletons of their names, so that confusable
+//     channels can be prevented even when the registered channel isn't loaded
+//  2. report existing problems with registered names (the same ones as `oragono checknames`)
+func schemaChangeV5ToV6(config *Config, tx *buntdb.Tx) error {
+	skeletonToChannel := make(map[string]string)
+	prefix := "channel.name "
+	tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		skeleton, err := ChannelSkeleton(value)
+		if err != nil {
+			return true
+		}
+		// if registered channels are already confusable, the first one keeps the skeleton
+		if _, exists := skeletonToChannel[skeleton]; !exists {
+			skeletonToChannel[skeleton] = strings.TrimPrefix(key, prefix)
+		}
+		return true
+	})
+
+	for skeleton, channel := range skeletonToChannel {
+		tx.Set(fmt.Sprintf("channel.skeleton %s", skeleton), channel, nil)
+	}
+
+	for _, problem := range checkNames(tx) {
+		log.Printf("problem with registered names: %s\n", problem)
+	}
+	return nil
+}
+
+// accounts have only had their logins and quits recorded since this version,
+// so their activity is treated as starting at the time of the upgrade
+// (otherwise enabling account expiration would expire every existing account)
+func schemaChangeV6ToV7(config *Config, tx *buntdb.Tx) error {
+	tx.Set(keyAccountActivitySince, strconv.FormatInt(time.Now().Unix(), 10), nil)
+	return nil
+}
+
 func init() {
 	allChanges := []SchemaChange{
 		{
@@ -431,6 +601,16 @@ func init() {
 			TargetVersion:  "5",
 			Changer:        schemaChangeV4ToV5,
 		},
+		{
+			InitialVersion: "5",
+			TargetVersion:  "6",
+			Changer:        schemaChangeV5ToV6,
+		},
+		{
+			InitialVersion: "6",
+			TargetVersion:  "7",
+			Changer:        schemaChangeV6ToV7,
+		},
 	}
 
 	// build the index
@@ -438,4 +618,29 @@ func init() {
 	for _, change := range allChanges {
 		schemaChanges[change.InitialVersion] = change
 	}
+	if err := checkSchemaChanges(allChanges); err != nil {
+		panic(err)
+	}
+}
+
+// checkSchemaChanges verifies that the schema changes form a single chain,
+// each one upgrading the schema by one version, ending at the latest schema;
+// otherwise some datastores couldn't be upgraded.
+func checkSchemaChanges(changes []SchemaChange) error {
+	for i, change := range changes {
+		initial, err := strconv.Atoi(change.InitialVersion)
+		if err != nil {
+			return err
+		}
+		if change.TargetVersion != strconv.Itoa(initial+1) {
+			return fmt.Errorf("schema change from v%s must target v%d", change.InitialVersion, initial+1)
+		}
+		if 0 < i && change.InitialVersion != changes[i-1].TargetVersion {
+			return fmt.Errorf("schema change from v%s does not follow the previous change", change.InitialVersion)
+		}
+	}
+	if len(changes) != 0 && changes[len(changes)-1].TargetVersion != latestDbSchema {
+		return fmt.Errorf("schema changes do not end at the latest schema, v%s", latestDbSchema)
+	}
+	return nil
 }
@@ -0,0 +1,130 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestCheckNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oragono-checknames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := new(Config)
+	config.Datastore.Path = filepath.Join(dir, "ircd.db")
+	store, err := buntdb.Open(config.Datastore.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Update(func(tx *buntdb.Tx) error {
+		tx.Set("account.name alice", "Alice", nil)
+		tx.Set("account.name carol", "Dave", nil)
+		tx.Set("channel.name #test", "#Test", nil)
+		tx.Set("channel.name #tеst", "#tеst", nil) // Cyrillic е
+		return nil
+	})
+	store.Close()
+
+	problems, err := CheckNames(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"account Dave casefolds to dave, but is stored as carol",
+		"channel names are confusable: #Test, #tеst",
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected %#v, got %#v", expected, problems)
+	}
+}
+
+func TestCheckSchemaChanges(t *testing.T) {
+	latest, _ := strconv.Atoi(latestDbSchema)
+	good := []SchemaChange{
+		{InitialVersion: strconv.Itoa(latest - 2), TargetVersion: strconv.Itoa(latest - 1)},
+		{InitialVersion: strconv.Itoa(latest - 1), TargetVersion: latestDbSchema},
+	}
+	if err := checkSchemaChanges(good); err != nil {
+		t.Error(err)
+	}
+	bad := [][]SchemaChange{
+		{{InitialVersion: "3", TargetVersion: "5"}},
+		{{InitialVersion: "2", TargetVersion: "3"}, {InitialVersion: "4", TargetVersion: "5"}},
+		{{InitialVersion: "1", TargetVersion: "2"}},
+	}
+	for _, changes := range bad {
+		if err := checkSchemaChanges(changes); err == nil {
+			t.Errorf("invalid schema changes should be rejected: %#v", changes)
+		}
+	}
+}
+
+func TestUpgradeDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oragono-upgradedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := new(Config)
+	config.Datastore.Path = filepath.Join(dir, "ircd.db")
+	setVersion := func(version string) {
+		store, err := buntdb.Open(config.Datastore.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Update(func(tx *buntdb.Tx) error {
+			tx.Set(keySchemaVersion, version, nil)
+			tx.Set("channel.founder #test", "alice", nil)
+			tx.Set("channel.name #test", "#Test", nil)
+			return nil
+		})
+		store.Close()
+	}
+
+	// a datastore from a newer version is refused, without an upgrade or a backup
+	setVersion("1000")
+	_, err = openDatabaseInternal(config, true)
+	if schemaErr, ok := err.(*incompatibleSchemaError); !ok || !schemaErr.tooNew {
+		t.Errorf("newer schema should be refused, got %v", err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(backups) != 0 {
+		t.Errorf("no backup should have been made: %v", backups)
+	}
+
+	setVersion("4")
+	if err := UpgradeDB(config); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "ircd.db.v4.*.bak")); len(backups) != 1 {
+		t.Errorf("a backup should have been made before upgrading: %v", backups)
+	}
+	store, err := openDatabaseInternal(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.View(func(tx *buntdb.Tx) error {
+		if channels, _ := tx.Get("account.channels alice"); channels != "#test" {
+			t.Errorf("schema change was not applied: %s", channels)
+		}
+		if holder, _ := tx.Get("channel.skeleton #test"); holder != "#test" {
+			t.Errorf("channel skeletons were not indexed: %s", holder)
+		}
+		if since, _ := tx.Get(keyAccountActivitySince); since == "" {
+			t.Errorf("start of account activity tracking was not recorded")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/oragono/oragono/irc/connection_limits"
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// DEFCON levels are emergency lockdown levels that opers can raise during an attack.
+// each level includes the restrictions of all the levels above it.
+const (
+	// no restrictions
+	DefconNormal uint32 = 5
+	// no new account or channel registrations
+	DefconNoRegistrations uint32 = 4
+	// new connections must authenticate with SASL
+	DefconRequireSasl uint32 = 3
+	// only opers can create new channels
+	DefconNoChannelCreation uint32 = 2
+	// joins by non-opers are throttled server-wide
+	DefconThrottleJoins uint32 = 1
+)
+
+const (
+	// at DefconThrottleJoins, the whole server allows this many joins per window
+	defconJoinLimit    = 10
+	defconJoinDuration = 10 * time.Second
+)
+
+// DefconManager tracks the server's current DEFCON level.
+type DefconManager struct {
+	level uint32 // accessed atomically, so the registration and join paths never block on it
+
+	lockorder.Tier1Mutex
+	server *Server
+	timer  *time.Timer
+	// server-wide join throttle, used at DefconThrottleJoins:
+	joinThrottle connection_limits.GenericThrottle
+}
+
+// Initialize sets up the manager at DefconNormal.
+func (dm *DefconManager) Initialize(server *Server) {
+	dm.server = server
+	dm.level = DefconNormal
+	dm.joinThrottle = connection_limits.GenericThrottle{
+		Duration: defconJoinDuration,
+		Limit:    defconJoinLimit,
+	}
+}
+
+// Level returns the current DEFCON level.
+func (dm *DefconManager) Level() uint32 {
+	return atomic.LoadUint32(&dm.level)
+}
+
+// SetLevel changes the DEFCON level. If duration is nonzero, the level automatically
+// steps back down to DefconNormal once it expires; any previous timer is cancelled.
+func (dm *DefconManager) SetLevel(level uint32, duration time.Duration) {
+	dm.Lock()
+	if dm.timer != nil {
+		dm.timer.Stop()
+		dm.timer = nil
+	}
+	atomic.StoreUint32(&dm.level, level)
+	if duration != 0 && level != DefconNormal {
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() {
+			dm.Lock()
+			// check that we weren't superseded by a later change
+			expired := dm.timer == timer
+			if expired {
+				dm.timer = nil
+				atomic.StoreUint32(&dm.level, DefconNormal)
+			}
+			dm.Unlock()
+			if expired {
+				dm.server.logger.Info("server", "DEFCON level expired, returning to normal")
+				dm.server.snomasks.Send(sno.LocalAccouncements, fmt.Sprintf("DEFCON level expired, returning to %d", DefconNormal))
+			}
+		})
+		dm.timer = timer
+	}
+	dm.Unlock()
+}
+
+// AllowJoin returns whether a join by a non-oper is permitted by the join throttle.
+func (dm *DefconManager) AllowJoin() bool {
+	if dm.Level() > DefconThrottleJoins {
+		return true
+	}
+	dm.Lock()
+	defer dm.Unlock()
+	throttled, _ := dm.joinThrottle.Touch()
+	return !throttled
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestDefconJoinThrottle(t *testing.T) {
+	var dm DefconManager
+	dm.Initialize(nil)
+
+	if dm.Level() != DefconNormal {
+		t.Errorf("incorrect initial level %d", dm.Level())
+	}
+	for i := 0; i < 2*defconJoinLimit; i++ {
+		if !dm.AllowJoin() {
+			t.Fatalf("joins should not be throttled at level %d", dm.Level())
+		}
+	}
+
+	dm.SetLevel(DefconThrottleJoins, 0)
+	for i := 0; i < defconJoinLimit; i++ {
+		if !dm.AllowJoin() {
+			t.Fatalf("join %d should have been allowed", i)
+		}
+	}
+	if dm.AllowJoin() {
+		t.Errorf("joins should be throttled at level %d", dm.Level())
+	}
+
+	dm.SetLevel(DefconNormal, 0)
+	if !dm.AllowJoin() {
+		t.Errorf("joins should not be throttled at level %d", dm.Level())
+	}
+}
+
+func TestDefconJoinThrottleAfterChecks(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		// bob's repeated joins would otherwise set off the channel's own flood protection
+		config.Channels.JoinFlood.Enabled = false
+	})
+	defer h.Close()
+
+	alice := h.Register("alice")
+	alice.Send("JOIN", "#keyed")
+	alice.Expect("JOIN")
+	alice.Send("MODE", "#keyed", "+k", "secret")
+	alice.Expect("MODE")
+	bob := h.Register("bob")
+
+	h.server.defcon.SetLevel(DefconThrottleJoins, 0)
+	// joins refused by the channel don't count against the throttle
+	for i := 0; i < 2*defconJoinLimit; i++ {
+		bob.Send("JOIN", "#keyed", "wrong")
+		bob.Expect(ERR_BADCHANNELKEY)
+	}
+	for i := 0; i < defconJoinLimit; i++ {
+		bob.Send("JOIN", "#keyed", "secret")
+		bob.Expect("JOIN")
+		bob.Send("PART", "#keyed")
+		bob.Expect("PART")
+	}
+	bob.Send("JOIN", "#keyed", "secret")
+	bob.Expect(ERR_UNAVAILRESOURCE)
+}
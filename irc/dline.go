@@ -8,9 +8,9 @@ import (
 	"fmt"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/utils"
 	"github.com/tidwall/buntdb"
 )
@@ -31,6 +31,9 @@ type IPBanInfo struct {
 	TimeCreated time.Time
 	// duration of the ban; 0 means "permanent"
 	Duration time.Duration
+	// Source is the blocklist feed that manages the ban; it's empty for bans
+	// set by opers (see blocklist.go).
+	Source string `json:"source,omitempty"`
 }
 
 func (info IPBanInfo) timeLeft() time.Duration {
@@ -66,14 +69,14 @@ type dLineNet struct {
 
 // DLineManager manages and dlines.
 type DLineManager struct {
-	sync.RWMutex                // tier 1
-	persistenceMutex sync.Mutex // tier 2
+	lockorder.Tier1RWMutex
+	persistenceMutex lockorder.Tier2Mutex
 	// networks that are dlined:
 	// XXX: the keys of this map (which are also the database persistence keys)
 	// are the human-readable representations returned by NetToNormalizedString
 	networks map[string]dLineNet
 	// this keeps track of expiration timers for temporary bans
-	expirationTimers map[string]*time.Timer
+	expirationTimers map[string]*utils.WheelTimer
 	server           *Server
 }
 
@@ -81,7 +84,7 @@ type DLineManager struct {
 func NewDLineManager(server *Server) *DLineManager {
 	var dm DLineManager
 	dm.networks = make(map[string]dLineNet)
-	dm.expirationTimers = make(map[string]*time.Timer)
+	dm.expirationTimers = make(map[string]*utils.WheelTimer)
 	dm.server = server
 
 	dm.loadFromDatastore()
@@ -106,9 +109,6 @@ func (dm *DLineManager) AllBans() map[string]IPBanInfo {
 
 // AddNetwork adds a network to the blocked list.
 func (dm *DLineManager) AddNetwork(network net.IPNet, duration time.Duration, reason, operReason, operName string) error {
-	dm.persistenceMutex.Lock()
-	defer dm.persistenceMutex.Unlock()
-
 	// assemble ban info
 	info := IPBanInfo{
 		Reason:      reason,
@@ -117,6 +117,12 @@ func (dm *DLineManager) AddNetwork(network net.IPNet, duration time.Duration, re
 		TimeCreated: time.Now(),
 		Duration:    duration,
 	}
+	return dm.addNetwork(network, info)
+}
+
+func (dm *DLineManager) addNetwork(network net.IPNet, info IPBanInfo) error {
+	dm.persistenceMutex.Lock()
+	defer dm.persistenceMutex.Unlock()
 
 	id := dm.addNetworkInternal(network, info)
 	return dm.persistDline(id, info)
@@ -161,7 +167,7 @@ func (dm *DLineManager) addNetworkInternal(network net.IPNet, info IPBanInfo) (i
 			delete(dm.expirationTimers, id)
 		}
 	}
-	dm.expirationTimers[id] = time.AfterFunc(timeLeft, processExpiration)
+	dm.expirationTimers[id] = timerWheel.AfterFunc(timeLeft, processExpiration)
 
 	return
 }
@@ -0,0 +1,198 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// A user whose allow-dms setting is "requests" doesn't receive direct messages
+// from strangers (users who aren't on their accept list) right away. Instead,
+// a stranger's messages are held as a "message request": the user is notified
+// once, and can ACCEPT the stranger, which delivers the held messages and adds
+// them to the accept list, or REJECT them, which discards the messages. The
+// stranger is told about all of this with standard replies. Unlike caller-ID
+// (+g), nothing is lost, and the notifications can't be used for flooding.
+
+const (
+	// at most this many messages are held per request
+	maxDMRequestMessages = 10
+	// a client can have at most this many pending requests
+	maxDMRequests = 20
+	// a client remembers at most this many rejections, forgetting the oldest
+	maxDMRejections = 100
+)
+
+// heldMessage is a message waiting for the recipient to accept its sender.
+type heldMessage struct {
+	item history.Item
+	// whether the message should be stored in history once it's delivered
+	store bool
+}
+
+// requiresDMRequest returns whether `sender`'s direct messages to `client` are
+// held as a message request.
+func (client *Client) requiresDMRequest(sender *Client) bool {
+	if client == sender || client.AccountSettings().DirectMessages != DMPolicyRequests {
+		return false
+	}
+	return !sender.HasMode(modes.Operator) && !client.hasAccepted(sender)
+}
+
+// dmRequestKey returns the key for message requests from `sender`: their account
+// if they're logged in, so that changing nicks doesn't get around a rejection,
+// or else their casefolded nickname.
+func dmRequestKey(sender *Client) string {
+	if account := sender.Account(); account != "" {
+		return account
+	}
+	return sender.NickCasefolded()
+}
+
+// dmRequestKeyFor returns the key for message requests from the user that the
+// casefolded name `cfname` (as given to ACCEPT or REJECT) refers to.
+func (server *Server) dmRequestKeyFor(cfname string) string {
+	if sender := server.clients.Get(cfname); sender != nil {
+		return dmRequestKey(sender)
+	}
+	// the sender isn't online, so this can only be an account name (or an
+	// unregistered sender's last nick)
+	return cfname
+}
+
+// holdDMRequest holds a message from the sender with the request key `key`;
+// `isNew` is whether it started a new request.
+func (client *Client) holdDMRequest(key string, message heldMessage) (isNew bool, err error) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	if _, rejected := client.dmRejected[key]; rejected {
+		return false, errDMRequestRejected
+	}
+	messages, exists := client.dmRequests[key]
+	if !exists && maxDMRequests <= len(client.dmRequests) {
+		return false, errDMRequestsFull
+	} else if maxDMRequestMessages <= len(messages) {
+		return false, errDMRequestsFull
+	}
+	if client.dmRequests == nil {
+		client.dmRequests = make(map[string][]heldMessage)
+	}
+	client.dmRequests[key] = append(messages, message)
+	return !exists, nil
+}
+
+// takeDMRequest removes the request with the key `key`, returning its messages.
+// If `reject` is set, later messages from its sender are refused.
+func (client *Client) takeDMRequest(key string, reject bool) (messages []heldMessage, exists bool) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	messages, exists = client.dmRequests[key]
+	delete(client.dmRequests, key)
+	if reject {
+		if client.dmRejected == nil {
+			client.dmRejected = make(map[string]time.Time)
+		}
+		if _, rejected := client.dmRejected[key]; !rejected && maxDMRejections <= len(client.dmRejected) {
+			var oldestKey string
+			var oldest time.Time
+			for rejectedKey, rejectedAt := range client.dmRejected {
+				if oldestKey == "" || rejectedAt.Before(oldest) {
+					oldestKey, oldest = rejectedKey, rejectedAt
+				}
+			}
+			delete(client.dmRejected, oldestKey)
+		}
+		client.dmRejected[key] = time.Now()
+	} else {
+		delete(client.dmRejected, key)
+	}
+	return
+}
+
+// holdDirectMessage holds a PRIVMSG from `client` to `user` as a message
+// request, and tells both of them so.
+func (server *Server) holdDirectMessage(client, user *Client, message heldMessage, rb *ResponseBuffer) {
+	cnick, tnick := client.Nick(), user.Nick()
+	isNew, err := user.holdDMRequest(dmRequestKey(client), message)
+	switch err {
+	case nil:
+		rb.Add(nil, server.name, "WARN", "PRIVMSG", "MESSAGE_REQUEST_PENDING", tnick, client.t("This user only accepts messages from people they know; your message will be delivered if they accept it"))
+		if isNew {
+			user.SendSplitMsgFromServer(server.name, "NOTICE", tnick, utils.MakeSplitMessage(fmt.Sprintf(user.t("%s wants to send you a direct message. To read it, type: /ACCEPT %s -- to refuse it, type: /REJECT %s"), cnick, cnick, cnick)))
+		}
+	case errDMRequestRejected:
+		rb.Add(nil, server.name, "FAIL", "PRIVMSG", "MESSAGE_REQUEST_REJECTED", tnick, client.t("This user declined your message request"))
+	case errDMRequestsFull:
+		rb.Add(nil, server.name, "FAIL", "PRIVMSG", "MESSAGE_REQUEST_FULL", tnick, client.t("This user has too many messages waiting to be accepted; try again later"))
+	}
+}
+
+// acceptDMRequest delivers the messages held from `cfnick`, if there are any,
+// and tells their sender.
+func (server *Server) acceptDMRequest(client *Client, cfnick string) {
+	messages, exists := client.takeDMRequest(server.dmRequestKeyFor(cfnick), false)
+	if !exists {
+		return
+	}
+	nick := client.Nick()
+	for _, message := range messages {
+		item := message.item
+		client.sendSplitMsgFromClientInternal(false, item.Time, item.Nick, item.AccountName, nil, "PRIVMSG", nick, item.Message)
+		if message.store {
+			client.history.Add(item)
+		}
+	}
+	if sender := server.clients.Get(cfnick); sender != nil {
+		sender.SendSplitMsgFromServer(server.name, "NOTICE", sender.Nick(), utils.MakeSplitMessage(fmt.Sprintf(sender.t("%s accepted your message request"), nick)))
+	}
+}
+
+// REJECT <nick>{,<nick>}
+func rejectHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	var rejected []string
+	for _, target := range strings.Split(msg.Params[0], ",") {
+		cfnick, err := CasefoldName(target)
+		if err != nil {
+			rb.Add(nil, server.name, "FAIL", "REJECT", "NO_SUCH_REQUEST", target, client.t("You have no message request from this user"))
+			continue
+		}
+		if _, exists := client.takeDMRequest(server.dmRequestKeyFor(cfnick), true); !exists {
+			rb.Add(nil, server.name, "FAIL", "REJECT", "NO_SUCH_REQUEST", target, client.t("You have no message request from this user"))
+			continue
+		}
+		rejected = append(rejected, target)
+		if sender := server.clients.Get(cfnick); sender != nil {
+			sender.Send(nil, server.name, "FAIL", "PRIVMSG", "MESSAGE_REQUEST_REJECTED", nick, sender.t("This user declined your message request"))
+		}
+	}
+	for _, line := range utils.ArgsToStrings(maxLastArgLength, rejected, ", ") {
+		rb.Notice(fmt.Sprintf(client.t("Rejected message requests from: %s"), line))
+	}
+	return false
+}
+
+// heldMessageFor returns the record of a direct message from `client`, for
+// holding as a message request.
+func heldMessageFor(client *Client, message utils.SplitMessage, store bool) heldMessage {
+	return heldMessage{
+		item: history.Item{
+			Type:        history.Privmsg,
+			Time:        time.Now().UTC(),
+			Message:     message,
+			Nick:        client.NickMaskString(),
+			AccountName: client.AccountName(),
+		},
+		store: store,
+	}
+}
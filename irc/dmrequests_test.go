@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDMRequests(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	carol := h.Register("carol")
+	sAlice := h.server.clients.Get("alice")
+	sAlice.stateMutex.Lock()
+	sAlice.accountSettings.DirectMessages = DMPolicyRequests
+	sAlice.stateMutex.Unlock()
+
+	bob.Send("PRIVMSG", "alice", "hi")
+	if msg := bob.Expect("WARN"); len(msg.Params) < 2 || msg.Params[1] != "MESSAGE_REQUEST_PENDING" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+	alice.Expect("NOTICE")
+	bob.Send("PRIVMSG", "alice", "are you there?")
+	bob.Expect("WARN")
+
+	alice.Send("ACCEPT", "bob")
+	for _, expected := range []string{"hi", "are you there?"} {
+		if msg := alice.Expect("PRIVMSG"); msg.Params[1] != expected {
+			t.Errorf("expected held message %#v, got %v", expected, msg)
+		}
+	}
+	bob.Expect("NOTICE")
+	bob.Send("PRIVMSG", "alice", "thanks")
+	if msg := alice.Expect("PRIVMSG"); msg.Params[1] != "thanks" {
+		t.Errorf("accepted users' messages should be delivered: %v", msg)
+	}
+
+	carol.Send("PRIVMSG", "alice", "buy my stuff")
+	carol.Expect("WARN")
+	alice.Send("REJECT", "carol")
+	alice.Expect("NOTICE")
+	if msg := carol.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "MESSAGE_REQUEST_REJECTED" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+	carol.Send("PRIVMSG", "alice", "please?")
+	if msg := carol.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "MESSAGE_REQUEST_REJECTED" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+
+	alice.Send("REJECT", "carol")
+	if msg := alice.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "NO_SUCH_REQUEST" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+}
+
+func TestDMRequestRejectedAccount(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	if err := h.server.accounts.Register(nil, "dave", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.accounts.Verify(nil, "dave", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := h.Register("alice")
+	sAlice := h.server.clients.Get("alice")
+	sAlice.stateMutex.Lock()
+	sAlice.accountSettings.DirectMessages = DMPolicyRequests
+	sAlice.stateMutex.Unlock()
+	dave := h.Register("dave")
+	dave.Send("NS", "IDENTIFY", "dave", "hunter2")
+	if msg := dave.Expect("NOTICE"); !strings.Contains(msg.Params[1], "now logged in") {
+		t.Fatalf("couldn't log in: %v", msg)
+	}
+
+	dave.Send("PRIVMSG", "alice", "hi")
+	dave.Expect("WARN")
+	alice.Send("REJECT", "dave")
+	alice.Expect("NOTICE")
+	dave.Expect("FAIL")
+
+	// a logged-in sender's requests are keyed by account, so changing nicks doesn't help
+	dave.Send("NICK", "david")
+	dave.Expect("NICK")
+	dave.Send("PRIVMSG", "alice", "it's me, david")
+	if msg := dave.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "MESSAGE_REQUEST_REJECTED" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+
+	// accepting the sender by their new nick finds the rejection and lifts it
+	alice.Send("ACCEPT", "david")
+	alice.Sync()
+	sAlice.stateMutex.RLock()
+	_, rejected := sAlice.dmRejected["dave"]
+	sAlice.stateMutex.RUnlock()
+	if rejected {
+		t.Errorf("accepting the sender should lift the rejection")
+	}
+	dave.Send("PRIVMSG", "alice", "thanks")
+	if msg := alice.Expect("PRIVMSG"); msg.Params[1] != "thanks" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+}
+
+func TestDMRejectionLimit(t *testing.T) {
+	client := new(Client)
+	for i := 0; i <= maxDMRejections; i++ {
+		client.takeDMRequest(fmt.Sprintf("spammer%d", i), true)
+	}
+	if len(client.dmRejected) != maxDMRejections {
+		t.Errorf("expected %d rejections to be remembered, got %d", maxDMRejections, len(client.dmRejected))
+	}
+	if _, rejected := client.dmRejected[fmt.Sprintf("spammer%d", maxDMRejections)]; !rejected {
+		t.Errorf("the newest rejection should be remembered")
+	}
+}
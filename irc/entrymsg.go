@@ -0,0 +1,58 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+const (
+	// maximum length of a channel entry message, before wrapping
+	maxEntryMsgLen = 1024
+	// a client who rejoins a channel within this interval doesn't get its entry message again
+	entryMsgInterval = 5 * time.Minute
+)
+
+// shouldSendEntryMsg returns whether the client should be sent the entry message for
+// the given (casefolded) channel, rate-limiting them so that join/part cycling
+// can't be used to make the server flood the client.
+func (client *Client) shouldSendEntryMsg(chname string) bool {
+	now := time.Now()
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	if now.Sub(client.entryMsgsSent[chname]) < entryMsgInterval {
+		return false
+	}
+	if client.entryMsgsSent == nil {
+		client.entryMsgsSent = make(map[string]time.Time)
+	}
+	// clean up expired entries so the map doesn't grow without bound
+	for name, sent := range client.entryMsgsSent {
+		if entryMsgInterval <= now.Sub(sent) {
+			delete(client.entryMsgsSent, name)
+		}
+	}
+	client.entryMsgsSent[chname] = now
+	return true
+}
+
+// sendEntryMsg sends the channel's entry message (if any) to a client who just joined it,
+// as notices from ChanServ.
+func (channel *Channel) sendEntryMsg(client *Client, rb *ResponseBuffer) {
+	entryMsg := channel.EntryMsg()
+	if entryMsg == "" || !client.shouldSendEntryMsg(channel.NameCasefolded()) {
+		return
+	}
+
+	chname := channel.Name()
+	nick := client.Nick()
+	prefix := fmt.Sprintf("[%s] ", chname)
+	for _, line := range utils.WordWrap(entryMsg, client.noticeLineWidth()-len(prefix)) {
+		rb.AddSplitMessageFromServer("ChanServ", "NOTICE", nick, utils.MakeSplitMessage(prefix+line))
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldSendEntryMsg(t *testing.T) {
+	client := new(Client)
+	if !client.shouldSendEntryMsg("#chan") {
+		t.Error("first join should get the entry message")
+	}
+	if client.shouldSendEntryMsg("#chan") {
+		t.Error("immediate rejoin should not get the entry message")
+	}
+	if !client.shouldSendEntryMsg("#other") {
+		t.Error("entry messages should be limited per channel")
+	}
+
+	client.entryMsgsSent["#chan"] = time.Now().Add(-2 * entryMsgInterval)
+	if !client.shouldSendEntryMsg("#chan") {
+		t.Error("rejoin after the interval should get the entry message")
+	}
+}
@@ -9,6 +9,9 @@ import "errors"
 
 // Runtime Errors
 var (
+	errAcceptListFull                 = errors.New("Accept list is full")
+	errDMRequestRejected              = errors.New("Message request was rejected")
+	errDMRequestsFull                 = errors.New("Too many messages are waiting to be accepted")
 	errAccountAlreadyRegistered       = errors.New(`Account already exists`)
 	errAccountAlreadyVerified         = errors.New(`Account is already verified`)
 	errAccountCantDropPrimaryNick     = errors.New("Can't unreserve primary nickname")
@@ -21,6 +24,8 @@ var (
 	errAccountNotLoggedIn             = errors.New("You're not logged into an account")
 	errAccountTooManyNicks            = errors.New("Account has too many reserved nicks")
 	errAccountUnverified              = errors.New("Account is not yet verified")
+	errAccountSuspended               = errors.New("Account has been suspended")
+	errAccountNotSuspended            = errors.New("Account is not suspended")
 	errAccountVerificationFailed      = errors.New("Account verification failed")
 	errAccountVerificationInvalidCode = errors.New("Invalid account verification code")
 	errAccountUpdateFailed            = errors.New("Error while updating your account information")
@@ -28,7 +33,10 @@ var (
 	errCallbackFailed                 = errors.New("Account verification could not be sent")
 	errCertfpAlreadyExists            = errors.New(`An account already exists for your certificate fingerprint`)
 	errChannelAlreadyRegistered       = errors.New("Channel is already registered")
+	errChannelCreationDisabled        = errors.New("Channel creation is temporarily disabled")
+	errChannelCreationProbation       = errors.New("New accounts can't create channels yet")
 	errChannelNameInUse               = errors.New(`Channel name in use`)
+	errConfusableChannelName          = errors.New(`Channel name is confusable with an existing channel`)
 	errInvalidChannelName             = errors.New(`Invalid channel name`)
 	errMonitorLimitExceeded           = errors.New("Monitor limit exceeded")
 	errNickMissing                    = errors.New("nick missing")
@@ -39,10 +47,16 @@ var (
 	errRenamePrivsNeeded              = errors.New(`Only chanops can rename channels`)
 	errInsufficientPrivs              = errors.New("Insufficient privileges")
 	errSaslFail                       = errors.New("SASL failed")
+	errSaslRequired                   = errors.New("You must authenticate with SASL to connect")
+	errBadPassword                    = errors.New("Bad password")
 	errResumeTokenAlreadySet          = errors.New("Client was already assigned a resume token")
 	errInvalidUsername                = errors.New("Invalid username")
 	errFeatureDisabled                = errors.New(`That feature is disabled`)
+	errTemporarilyDisabled            = errors.New(`That is temporarily disabled by the server administrators`)
 	errInvalidParams                  = errors.New("Invalid parameters")
+	errUnsupportedLanguage            = errors.New("Language is not supported by this server")
+	errInvalidAutoAway                = errors.New("Invalid duration; the minimum is 1m")
+	errInvalidPublicKey               = errors.New("Invalid RSA public key")
 )
 
 // Socket Errors
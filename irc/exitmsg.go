@@ -0,0 +1,171 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// Quit, part, and kick messages are broadcast to everyone in the channel, so
+// spammers use them for advertising: they join a lot of channels, then leave
+// with an ad as their quit message. These messages are subject to a policy
+// that can strip formatting, drop messages containing URLs or matching banned
+// patterns, and truncate long messages. The policy is set in the config, and
+// channel founders can add restrictions of their own for their channels;
+// since a quit message is seen in all of the client's channels, it's subject
+// to the restrictions of all of them. A dropped message is replaced with the
+// default (no part message, "Quit", or the target's nickname for a kick).
+
+// ExitMessagePolicy controls quit, part, and kick messages.
+type ExitMessagePolicy struct {
+	StripFormatting bool `yaml:"strip-formatting"`
+	BlockURLs       bool `yaml:"block-urls"`
+	// globs (e.g., "*discord.gg/*"), matched case-insensitively against the
+	// whole message
+	BannedPatterns []string `yaml:"banned-patterns"`
+	// longer messages are truncated (0 for no limit)
+	MaxLength int `yaml:"max-length"`
+
+	bannedPatterns []*utils.Glob
+}
+
+// matches something that's unmistakably a URL: a scheme, or www.
+var urlRegex = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S`)
+
+func (policy *ExitMessagePolicy) prepare() error {
+	if policy.MaxLength < 0 {
+		return fmt.Errorf("invalid exit message max-length: %d", policy.MaxLength)
+	}
+	policy.bannedPatterns = make([]*utils.Glob, len(policy.BannedPatterns))
+	for i, pattern := range policy.BannedPatterns {
+		policy.bannedPatterns[i] = utils.CompileGlobCached(strings.ToLower(pattern))
+	}
+	return nil
+}
+
+// IsEmpty returns whether the policy doesn't restrict anything.
+func (policy *ExitMessagePolicy) IsEmpty() bool {
+	return !policy.StripFormatting && !policy.BlockURLs && len(policy.BannedPatterns) == 0 && policy.MaxLength == 0
+}
+
+// String formats the policy as it's entered in /CS SET EXITMSGS.
+func (policy ExitMessagePolicy) String() string {
+	var fields []string
+	if policy.StripFormatting {
+		fields = append(fields, "strip-formatting")
+	}
+	if policy.BlockURLs {
+		fields = append(fields, "block-urls")
+	}
+	if policy.MaxLength != 0 {
+		fields = append(fields, fmt.Sprintf("max-length=%d", policy.MaxLength))
+	}
+	for _, pattern := range policy.BannedPatterns {
+		fields = append(fields, "ban="+pattern)
+	}
+	return strings.Join(fields, " ")
+}
+
+// ParseExitMessagePolicy parses a policy of the form
+// `[strip-formatting] [block-urls] [max-length=N] [ban=pattern ...]`; the
+// empty string is the empty policy.
+func ParseExitMessagePolicy(str string) (policy ExitMessagePolicy, err error) {
+	for _, field := range strings.Fields(str) {
+		lowered := strings.ToLower(field)
+		switch {
+		case lowered == "strip-formatting":
+			policy.StripFormatting = true
+		case lowered == "block-urls":
+			policy.BlockURLs = true
+		case strings.HasPrefix(lowered, "max-length="):
+			policy.MaxLength, err = strconv.Atoi(field[len("max-length="):])
+			if err != nil || policy.MaxLength <= 0 {
+				return ExitMessagePolicy{}, errInvalidParams
+			}
+		case strings.HasPrefix(lowered, "ban=") && len(field) > len("ban="):
+			policy.BannedPatterns = append(policy.BannedPatterns, field[len("ban="):])
+		default:
+			return ExitMessagePolicy{}, errInvalidParams
+		}
+	}
+	if policy.prepare() != nil {
+		return ExitMessagePolicy{}, errInvalidParams
+	}
+	return
+}
+
+// combine returns a policy with the restrictions of both policies.
+func (policy *ExitMessagePolicy) combine(other *ExitMessagePolicy) (result ExitMessagePolicy) {
+	result.StripFormatting = policy.StripFormatting || other.StripFormatting
+	result.BlockURLs = policy.BlockURLs || other.BlockURLs
+	result.MaxLength = policy.MaxLength
+	if other.MaxLength != 0 && (result.MaxLength == 0 || other.MaxLength < result.MaxLength) {
+		result.MaxLength = other.MaxLength
+	}
+	result.BannedPatterns = append(append([]string(nil), policy.BannedPatterns...), other.BannedPatterns...)
+	result.bannedPatterns = append(append([]*utils.Glob(nil), policy.bannedPatterns...), other.bannedPatterns...)
+	return
+}
+
+// filter applies the policy to a message, returning the empty string if the
+// message is blocked.
+func (policy *ExitMessagePolicy) filter(message string) string {
+	if policy.StripFormatting {
+		message = ircfmt.Strip(message)
+	}
+	if policy.BlockURLs && urlRegex.MatchString(message) {
+		return ""
+	}
+	if len(policy.bannedPatterns) != 0 {
+		lowered := strings.ToLower(message)
+		for _, pattern := range policy.bannedPatterns {
+			if pattern.MatchString(lowered) {
+				return ""
+			}
+		}
+	}
+	if policy.MaxLength != 0 && policy.MaxLength < len(message) {
+		// don't cut a character in half
+		end := policy.MaxLength
+		for 0 < end && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		message = message[:end]
+	}
+	return message
+}
+
+// filterExitMessage applies the server's and the channel's policies to a part
+// or kick message in the channel.
+func (channel *Channel) filterExitMessage(message string) string {
+	if message == "" {
+		return message
+	}
+	global := &channel.server.Config().Channels.ExitMessages
+	policy := channel.ExitMessagePolicy()
+	if policy.IsEmpty() {
+		return global.filter(message)
+	}
+	combined := global.combine(&policy)
+	return combined.filter(message)
+}
+
+// filterQuitMessage applies the server's policy, and those of all the client's
+// channels, to the client's quit message.
+func (server *Server) filterQuitMessage(client *Client, message string) string {
+	policy := server.Config().Channels.ExitMessages
+	for _, channel := range client.Channels() {
+		if channelPolicy := channel.ExitMessagePolicy(); !channelPolicy.IsEmpty() {
+			policy = policy.combine(&channelPolicy)
+		}
+	}
+	return policy.filter(message)
+}
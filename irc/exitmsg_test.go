@@ -0,0 +1,91 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestExitMessagePolicyFilter(t *testing.T) {
+	policy, err := ParseExitMessagePolicy("strip-formatting block-urls max-length=10 ban=*JOIN*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"\x02bye\x02":                 "bye",
+		"see https://example.com":     "",
+		"visit www.example.com":       "",
+		"come join #spam":             "",
+		"goodbye, everyone":           "goodbye, e",
+		"über-long goodbye":           "über-long",
+		"ok":                          "ok",
+		"http:// is how URLs start?":  "http:// is",
+		"no url: example dot com now": "no url: ex",
+	}
+	for message, expected := range cases {
+		if result := policy.filter(message); result != expected {
+			t.Errorf("%#v: expected %#v, got %#v", message, expected, result)
+		}
+	}
+}
+
+func TestParseExitMessagePolicy(t *testing.T) {
+	policy, err := ParseExitMessagePolicy("BLOCK-URLS max-length=80 ban=*spam*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.String() != "block-urls max-length=80 ban=*spam*" {
+		t.Errorf("unexpected policy: %s", policy.String())
+	}
+	if policy, err := ParseExitMessagePolicy(""); err != nil || !policy.IsEmpty() {
+		t.Errorf("empty policy should be accepted: %v", err)
+	}
+	for _, str := range []string{"max-length=0", "max-length=x", "ban=", "strip"} {
+		if _, err := ParseExitMessagePolicy(str); err == nil {
+			t.Errorf("%s should be rejected", str)
+		}
+	}
+}
+
+func TestCombineExitMessagePolicies(t *testing.T) {
+	global, _ := ParseExitMessagePolicy("max-length=100 ban=*spam*")
+	channel, _ := ParseExitMessagePolicy("block-urls max-length=20 ban=*ads*")
+	combined := global.combine(&channel)
+	if !combined.BlockURLs || combined.StripFormatting || combined.MaxLength != 20 || len(combined.bannedPatterns) != 2 {
+		t.Errorf("unexpected combined policy: %v", combined)
+	}
+	if combined.filter("buy our spam") != "" || combined.filter("free ads") != "" {
+		t.Errorf("both policies' patterns should apply")
+	}
+
+	unlimited, _ := ParseExitMessagePolicy("strip-formatting")
+	if combined := unlimited.combine(&channel); combined.MaxLength != 20 {
+		t.Errorf("length limit should apply: %d", combined.MaxLength)
+	}
+}
+
+func TestExitMessagesBroadcast(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Channels.ExitMessages.BlockURLs = true
+	})
+	defer h.Close()
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	for _, client := range []*testClient{alice, bob} {
+		client.Send("JOIN", "#test")
+		client.Expect(RPL_ENDOFNAMES)
+	}
+
+	bob.Send("PART", "#test", "cheap followers at https://example.com")
+	if msg := alice.Expect("PART"); len(msg.Params) != 2 || msg.Params[1] != "" {
+		t.Errorf("part message should have been dropped: %v", msg)
+	}
+	bob.Send("JOIN", "#test")
+	bob.Expect(RPL_ENDOFNAMES)
+	bob.Send("QUIT", "see www.example.com")
+	if msg := alice.Expect("QUIT"); len(msg.Params) != 1 || msg.Params[0] != "Quit" {
+		t.Errorf("quit message should have been dropped: %v", msg)
+	}
+}
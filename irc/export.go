@@ -0,0 +1,193 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// The datastore can be exported to (and imported from) a JSON document, which
+// is independent of buntdb's file format, for disaster recovery and migrations.
+// The format is versioned separately from the datastore schema; see
+// docs/MANUAL.md for a description. Exports record the schema version of the
+// datastore they were taken from, and imports of older schemas are upgraded
+// as usual the next time the datastore is opened.
+
+const (
+	exportFormatName    = "oragono-datastore"
+	exportFormatVersion = 1
+)
+
+var (
+	errExportFormat  = errors.New("Not an oragono datastore export")
+	errExportVersion = errors.New("Unsupported datastore export version")
+	errExportSchema  = errors.New("Datastore export has no schema version")
+)
+
+// DatastoreExport is the JSON document produced by exporting the datastore.
+type DatastoreExport struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Schema   string          `json:"schema"`
+	Exported time.Time       `json:"exported"`
+	Entries  []DatastoreItem `json:"entries"`
+}
+
+// DatastoreItem is a single key/value pair from the datastore.
+type DatastoreItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// set for keys that expire
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// exportDatastore builds an export of every key in the datastore, in key order.
+func exportDatastore(tx *buntdb.Tx) (result DatastoreExport, err error) {
+	result = DatastoreExport{
+		Format:   exportFormatName,
+		Version:  exportFormatVersion,
+		Exported: time.Now().UTC(),
+		Entries:  make([]DatastoreItem, 0),
+	}
+	result.Schema, err = tx.Get(keySchemaVersion)
+	if err != nil {
+		return result, errExportSchema
+	}
+	now := time.Now()
+	err = tx.Ascend("", func(key, value string) bool {
+		item := DatastoreItem{Key: key, Value: value}
+		if ttl, ttlErr := tx.TTL(key); ttlErr == nil && 0 < ttl {
+			expires := now.Add(ttl).UTC().Truncate(time.Second)
+			item.Expires = &expires
+		}
+		result.Entries = append(result.Entries, item)
+		return true
+	})
+	return
+}
+
+func writeDatastoreExport(tx *buntdb.Tx, w io.Writer) error {
+	export, err := exportDatastore(tx)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// importDatastore writes the contents of an export into the datastore.
+func importDatastore(tx *buntdb.Tx, export *DatastoreExport) error {
+	if export.Format != exportFormatName {
+		return errExportFormat
+	} else if export.Version != exportFormatVersion {
+		return errExportVersion
+	} else if export.Schema == "" {
+		return errExportSchema
+	}
+	now := time.Now()
+	for _, item := range export.Entries {
+		var opts *buntdb.SetOptions
+		if item.Expires != nil {
+			ttl := item.Expires.Sub(now)
+			if ttl <= 0 {
+				// already expired
+				continue
+			}
+			opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+		}
+		if _, _, err := tx.Set(item.Key, item.Value, opts); err != nil {
+			return err
+		}
+	}
+	_, _, err := tx.Set(keySchemaVersion, export.Schema, nil)
+	return err
+}
+
+// ExportDB implements the `oragono exportdb` command, writing the datastore
+// as JSON to `w`.
+func ExportDB(config *Config, w io.Writer) error {
+	store, err := buntdb.Open(config.Datastore.Path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.View(func(tx *buntdb.Tx) error {
+		return writeDatastoreExport(tx, w)
+	})
+}
+
+// ImportDB implements the `oragono importdb` command, creating the datastore
+// from a JSON export. Like initdb, it refuses to overwrite an existing datastore.
+func ImportDB(config *Config, r io.Reader) error {
+	path := config.Datastore.Path
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("Datastore already exists (delete it manually to continue): %s", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	var export DatastoreExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return err
+	}
+
+	store, err := buntdb.Open(path)
+	if err != nil {
+		return err
+	}
+	err = store.Update(func(tx *buntdb.Tx) error {
+		return importDatastore(tx, &export)
+	})
+	store.Close()
+	if err != nil {
+		// don't leave a partial datastore behind
+		os.Remove(path)
+	}
+	return err
+}
+
+// backupDatastore writes a snapshot of the running server's datastore next to
+// it, either in buntdb's own format (which can be used as a datastore as is),
+// or as a JSON export, returning the path of the backup.
+func (server *Server) backupDatastore(asJSON bool) (path string, err error) {
+	timestamp := time.Now().UTC().Format("2006-01-02-15:04:05.000Z")
+	path = fmt.Sprintf("%s.%s.bak", server.Config().Datastore.Path, timestamp)
+	if asJSON {
+		path += ".json"
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(file)
+	if asJSON {
+		err = server.store.View(func(tx *buntdb.Tx) error {
+			return writeDatastoreExport(tx, writer)
+		})
+	} else {
+		// Save takes a consistent snapshot without blocking writers for long
+		err = server.store.Save(writer)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oragono-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := new(Config)
+	config.Datastore.Path = filepath.Join(dir, "ircd.db")
+	store, err := buntdb.Open(config.Datastore.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(keySchemaVersion, latestDbSchema, nil)
+		tx.Set("account.name alice", "Alice", nil)
+		tx.Set("account.verificationcode bob", "1234", &buntdb.SetOptions{Expires: true, TTL: time.Hour})
+		return nil
+	})
+	store.Close()
+
+	var buf bytes.Buffer
+	if err := ExportDB(config, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var export DatastoreExport
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Format != exportFormatName || export.Version != exportFormatVersion || export.Schema != latestDbSchema {
+		t.Errorf("incorrect export header: %#v", export)
+	}
+	if len(export.Entries) != 3 || export.Entries[0].Key != "account.name alice" || export.Entries[0].Expires != nil || export.Entries[1].Expires == nil {
+		t.Errorf("incorrect export entries: %#v", export.Entries)
+	}
+
+	// importing refuses to overwrite the datastore
+	if err := ImportDB(config, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Errorf("import should not overwrite an existing datastore")
+	}
+
+	config.Datastore.Path = filepath.Join(dir, "imported.db")
+	if err := ImportDB(config, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	store, err = buntdb.Open(config.Datastore.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.View(func(tx *buntdb.Tx) error {
+		if value, _ := tx.Get("account.name alice"); value != "Alice" {
+			t.Errorf("key was not imported")
+		}
+		if ttl, _ := tx.TTL("account.verificationcode bob"); ttl <= 0 || time.Hour < ttl {
+			t.Errorf("expiration was not imported: %v", ttl)
+		}
+		return nil
+	})
+}
+
+func TestImportInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oragono-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := new(Config)
+	config.Datastore.Path = filepath.Join(dir, "ircd.db")
+	if err := ImportDB(config, strings.NewReader(`{"format": "something-else", "version": 1}`)); err != errExportFormat {
+		t.Errorf("unexpected error for an invalid format: %v", err)
+	}
+	if _, err := os.Stat(config.Datastore.Path); !os.IsNotExist(err) {
+		t.Errorf("failed import should not leave a datastore behind")
+	}
+}
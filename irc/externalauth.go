@@ -0,0 +1,282 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/ldap"
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// External authentication lets SASL PLAIN and NickServ IDENTIFY check passphrases
+// against an outside system (e.g., an organization's SSO directory), for accounts
+// whose passphrase doesn't check out locally. Backends are tried in order until
+// one of them accepts the credentials.
+
+const (
+	defaultExternalAuthTimeout = 9 * time.Second
+	// maximum size of a response from a script or HTTP backend
+	maxExternalAuthResponse = 1 << 16
+)
+
+// ExternalAuthConfig controls external authentication.
+type ExternalAuthConfig struct {
+	Enabled  bool
+	Backends []ExternalAuthBackendConfig
+	// successful authentications are cached for this long
+	CacheDuration time.Duration `yaml:"cache-duration"`
+	// whether to create local accounts for users who authenticate externally
+	Autocreate bool
+}
+
+// ExternalAuthBackendConfig configures one external authentication backend.
+type ExternalAuthBackendConfig struct {
+	// one of "script", "http", or "ldap"
+	Type    string
+	Timeout time.Duration
+	// for "script": the command to run, and its arguments
+	Command string
+	Args    []string
+	// for "http": the URL to POST to
+	URL string
+	// for "ldap": the server, and the DN to bind as; %s is replaced by the account name
+	LDAP   ldap.Config
+	BindDN string `yaml:"bind-dn"`
+}
+
+func (conf *ExternalAuthConfig) prepare() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if len(conf.Backends) == 0 {
+		return fmt.Errorf("External authentication is enabled, but no backends were configured")
+	}
+	for i := range conf.Backends {
+		backend := &conf.Backends[i]
+		if backend.Timeout == 0 {
+			backend.Timeout = defaultExternalAuthTimeout
+		}
+		var missing string
+		switch backend.Type {
+		case "script":
+			if backend.Command == "" {
+				missing = "command"
+			}
+		case "http":
+			if backend.URL == "" {
+				missing = "url"
+			}
+		case "ldap":
+			if backend.LDAP.Timeout == 0 {
+				backend.LDAP.Timeout = backend.Timeout
+			}
+			if backend.LDAP.Server == "" {
+				missing = "server"
+			} else if !strings.Contains(backend.BindDN, "%s") {
+				missing = "bind-dn"
+			}
+		default:
+			return fmt.Errorf("Unknown external authentication backend type: %s", backend.Type)
+		}
+		if missing != "" {
+			return fmt.Errorf("External authentication backend %d (%s) is missing a valid %s", i, backend.Type, missing)
+		}
+	}
+	return nil
+}
+
+// externalAuthRequest is sent, as JSON, to script and HTTP backends.
+type externalAuthRequest struct {
+	AccountName string `json:"accountName"`
+	Passphrase  string `json:"passphrase"`
+	IP          string `json:"ip"`
+}
+
+// externalAuthResponse is expected, as JSON, from script and HTTP backends.
+type externalAuthResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// authenticate checks the credentials against this backend.
+func (backend *ExternalAuthBackendConfig) authenticate(request externalAuthRequest) (success bool, err error) {
+	switch backend.Type {
+	case "script":
+		return backend.authenticateScript(request)
+	case "http":
+		return backend.authenticateHTTP(request)
+	case "ldap":
+		err = ldap.Bind(backend.LDAP, fmt.Sprintf(backend.BindDN, ldap.EscapeDN(request.AccountName)), request.Passphrase)
+		if err == ldap.ErrInvalidCredentials {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, nil
+}
+
+func (backend *ExternalAuthBackendConfig) authenticateScript(request externalAuthRequest) (success bool, err error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), backend.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, backend.Command, backend.Args...)
+	// pass the credentials on stdin, not the command line, so they're not visible in ps
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	output, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	return parseExternalAuthResponse(output)
+}
+
+func (backend *ExternalAuthBackendConfig) authenticateHTTP(request externalAuthRequest) (success bool, err error) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+	client := http.Client{Timeout: backend.Timeout}
+	response, err := client.Post(backend.URL, "application/json", bytes.NewReader(input))
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	var output bytes.Buffer
+	if _, err = output.ReadFrom(io.LimitReader(response.Body, maxExternalAuthResponse)); err != nil {
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("HTTP status %d", response.StatusCode)
+	}
+	return parseExternalAuthResponse(output.Bytes())
+}
+
+func parseExternalAuthResponse(output []byte) (success bool, err error) {
+	var response externalAuthResponse
+	if err = json.Unmarshal(output, &response); err != nil {
+		return
+	}
+	if response.Error != "" {
+		return false, fmt.Errorf("backend error: %s", response.Error)
+	}
+	return response.Success, nil
+}
+
+// externalAuthCache remembers recent successful external authentications,
+// so that every reconnection doesn't have to wait on the backend.
+type externalAuthCache struct {
+	lockorder.Tier1Mutex
+	entries map[[sha256.Size]byte]time.Time
+}
+
+func externalAuthCacheKey(accountName, passphrase string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(accountName + "\x00" + passphrase))
+}
+
+func (cache *externalAuthCache) Check(accountName, passphrase string) bool {
+	key := externalAuthCacheKey(accountName, passphrase)
+	cache.Lock()
+	defer cache.Unlock()
+	expiration, ok := cache.entries[key]
+	if ok && time.Now().After(expiration) {
+		delete(cache.entries, key)
+		ok = false
+	}
+	return ok
+}
+
+func (cache *externalAuthCache) Add(accountName, passphrase string, duration time.Duration) {
+	if duration == 0 {
+		return
+	}
+	now := time.Now()
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.entries == nil {
+		cache.entries = make(map[[sha256.Size]byte]time.Time)
+	}
+	// clean up expired entries so the map doesn't grow without bound
+	for key, expiration := range cache.entries {
+		if now.After(expiration) {
+			delete(cache.entries, key)
+		}
+	}
+	cache.entries[externalAuthCacheKey(accountName, passphrase)] = now.Add(duration)
+}
+
+// checkExternalAuth checks a passphrase with the external authentication backends,
+// returning the account to log into (creating it if necessary and allowed).
+func (am *AccountManager) checkExternalAuth(client *Client, accountName, passphrase string) (account ClientAccount, err error) {
+	config := am.server.AccountConfig().ExternalAuth
+	cfname, err := CasefoldName(accountName)
+	if err != nil || passphrase == "" {
+		return account, errAccountInvalidCredentials
+	}
+
+	if !am.externalAuthCache.Check(cfname, passphrase) {
+		request := externalAuthRequest{
+			AccountName: accountName,
+			Passphrase:  passphrase,
+		}
+		if client != nil {
+			request.IP = client.IP().String()
+		}
+		success := false
+		for i := range config.Backends {
+			success, err = config.Backends[i].authenticate(request)
+			if err != nil {
+				am.server.logger.Error("accounts", "external authentication backend failed", config.Backends[i].Type, err.Error())
+			}
+			if success {
+				break
+			}
+		}
+		if !success {
+			return account, errAccountInvalidCredentials
+		}
+		am.externalAuthCache.Add(cfname, passphrase, config.CacheDuration)
+	}
+
+	return am.loadExternalAccount(accountName, config.Autocreate)
+}
+
+// loadExternalAccount loads an account whose owner was authenticated by an outside
+// system, creating it first if it doesn't exist and `autocreate` is set.
+func (am *AccountManager) loadExternalAccount(accountName string, autocreate bool) (account ClientAccount, err error) {
+	account, err = am.LoadAccount(accountName)
+	if err == errAccountDoesNotExist && autocreate {
+		// the local passphrase is random, so the account can only be used via external auth
+		err = am.Register(nil, accountName, "admin", "", utils.GenerateSecretToken(), "")
+		if err == nil {
+			err = am.Verify(nil, accountName, "")
+		}
+		if err == nil {
+			am.server.logger.Info("accounts", "created account for externally authenticated user", accountName)
+			account, err = am.LoadAccount(accountName)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if !account.Verified {
+		return account, errAccountUnverified
+	}
+	if account.Suspended {
+		return account, errAccountSuspended
+	}
+	return account, nil
+}
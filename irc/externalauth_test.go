@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExternalAuthScript(t *testing.T) {
+	conf := ExternalAuthConfig{
+		Enabled: true,
+		Backends: []ExternalAuthBackendConfig{{
+			Type:    "script",
+			Command: "/bin/sh",
+			Args:    []string{"-c", `grep -q '"passphrase":"hunter2"' && echo '{"success": true}' || echo '{"success": false}'`},
+		}},
+	}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	backend := &conf.Backends[0]
+
+	success, err := backend.authenticate(externalAuthRequest{AccountName: "dan", Passphrase: "hunter2"})
+	if !success || err != nil {
+		t.Errorf("correct passphrase was rejected: %v", err)
+	}
+	success, err = backend.authenticate(externalAuthRequest{AccountName: "dan", Passphrase: "hunter3"})
+	if success || err != nil {
+		t.Errorf("incorrect passphrase was accepted: %v", err)
+	}
+}
+
+func TestExternalAuthHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request externalAuthRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(externalAuthResponse{Success: request.AccountName == "dan" && request.Passphrase == "hunter2"})
+	}))
+	defer ts.Close()
+
+	backend := ExternalAuthBackendConfig{Type: "http", URL: ts.URL, Timeout: time.Second}
+	if success, err := backend.authenticate(externalAuthRequest{AccountName: "dan", Passphrase: "hunter2"}); !success || err != nil {
+		t.Errorf("correct passphrase was rejected: %v", err)
+	}
+	if success, err := backend.authenticate(externalAuthRequest{AccountName: "dan", Passphrase: "hunter3"}); success || err != nil {
+		t.Errorf("incorrect passphrase was accepted: %v", err)
+	}
+}
+
+func TestExternalAuthConfig(t *testing.T) {
+	conf := ExternalAuthConfig{Enabled: true}
+	if err := conf.prepare(); err == nil {
+		t.Errorf("external auth without backends should be rejected")
+	}
+	conf.Backends = []ExternalAuthBackendConfig{{Type: "ldap", BindDN: "uid=dan,dc=example,dc=com"}}
+	conf.Backends[0].LDAP.Server = "ldap.example.com:636"
+	if err := conf.prepare(); err == nil {
+		t.Errorf("bind DN without a placeholder should be rejected")
+	}
+	conf.Backends[0].Type = "kerberos"
+	if err := conf.prepare(); err == nil {
+		t.Errorf("unknown backend type should be rejected")
+	}
+}
+
+func TestExternalAuthCache(t *testing.T) {
+	var cache externalAuthCache
+	if cache.Check("dan", "hunter2") {
+		t.Errorf("empty cache returned a hit")
+	}
+	cache.Add("dan", "hunter2", time.Minute)
+	if !cache.Check("dan", "hunter2") {
+		t.Errorf("cached credentials were not found")
+	}
+	if cache.Check("dan", "hunter3") {
+		t.Errorf("cache accepted the wrong passphrase")
+	}
+	cache.Add("slingamn", "hunter2", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if cache.Check("slingamn", "hunter2") {
+		t.Errorf("expired credentials were accepted")
+	}
+}
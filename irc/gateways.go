@@ -69,7 +69,7 @@ func (client *Client) ApplyProxiedIP(proxiedIP string, tls bool) (success bool)
 	// given IP is sane! override the client's current IP
 	ipstring := parsedProxiedIP.String()
 	client.server.logger.Info("localconnect-ip", "Accepted proxy IP for client", ipstring)
-	rawHostname := utils.LookupHostname(ipstring)
+	rawHostname := client.server.hostnames.Lookup(parsedProxiedIP, client.server.Config().Server.ReverseDNS)
 
 	client.stateMutex.Lock()
 	defer client.stateMutex.Unlock()
@@ -0,0 +1,157 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oragono/oragono/irc/mmdb"
+)
+
+// IPInfo is what's known about the location of an IP address.
+type IPInfo struct {
+	// ISO 3166-1 alpha-2 country code, e.g., "US"
+	Country string
+	// autonomous system number and organization
+	ASN            uint
+	ASOrganization string
+}
+
+// String returns a short description for logs, e.g., "US/AS15169".
+func (info IPInfo) String() string {
+	var parts []string
+	if info.Country != "" {
+		parts = append(parts, info.Country)
+	}
+	if info.ASN != 0 {
+		parts = append(parts, fmt.Sprintf("AS%d", info.ASN))
+	}
+	return strings.Join(parts, "/")
+}
+
+// IPLookup looks up information about IP addresses.
+type IPLookup interface {
+	Lookup(ip net.IP) IPInfo
+}
+
+// mmdbLookup looks up IPs in MaxMind databases (GeoIP2 or GeoLite2),
+// either of which may be nil.
+type mmdbLookup struct {
+	country *mmdb.Reader
+	asn     *mmdb.Reader
+}
+
+func (lookup *mmdbLookup) Lookup(ip net.IP) (info IPInfo) {
+	if lookup.country != nil {
+		if record, err := lookup.country.Lookup(ip); err == nil {
+			info.Country = strings.ToUpper(mmdbString(record, "country", "iso_code"))
+		}
+	}
+	if lookup.asn != nil {
+		if record, err := lookup.asn.Lookup(ip); err == nil {
+			if recordMap, ok := record.(map[string]interface{}); ok {
+				asn, _ := recordMap["autonomous_system_number"].(uint64)
+				info.ASN = uint(asn)
+			}
+			info.ASOrganization = mmdbString(record, "autonomous_system_organization")
+		}
+	}
+	return
+}
+
+// mmdbString follows a path of map keys through a decoded record to a string.
+func mmdbString(record interface{}, path ...string) string {
+	for _, key := range path {
+		recordMap, ok := record.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		record = recordMap[key]
+	}
+	result, _ := record.(string)
+	return result
+}
+
+// GeoIPConfig controls the lookup of clients' countries and ASNs.
+type GeoIPConfig struct {
+	Enabled         bool
+	CountryDatabase string `yaml:"country-database"`
+	ASNDatabase     string `yaml:"asn-database"`
+	// connections from these countries or ASNs are rejected
+	DenyCountries []string `yaml:"deny-countries"`
+	DenyASNs      []uint   `yaml:"deny-asns"`
+	// connections from these countries or ASNs must authenticate with SASL
+	RequireSaslCountries []string `yaml:"require-sasl-countries"`
+	RequireSaslASNs      []uint   `yaml:"require-sasl-asns"`
+
+	lookup IPLookup
+}
+
+func (conf *GeoIPConfig) prepare() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	var lookup mmdbLookup
+	if conf.CountryDatabase != "" {
+		lookup.country, err = mmdb.Open(conf.CountryDatabase)
+		if err != nil {
+			return fmt.Errorf("Could not load GeoIP country database: %s", err.Error())
+		}
+	}
+	if conf.ASNDatabase != "" {
+		lookup.asn, err = mmdb.Open(conf.ASNDatabase)
+		if err != nil {
+			return fmt.Errorf("Could not load GeoIP ASN database: %s", err.Error())
+		}
+	}
+	if lookup.country == nil && lookup.asn == nil {
+		return fmt.Errorf("GeoIP is enabled, but no databases were configured")
+	}
+	conf.lookup = &lookup
+	for i, country := range conf.DenyCountries {
+		conf.DenyCountries[i] = strings.ToUpper(country)
+	}
+	for i, country := range conf.RequireSaslCountries {
+		conf.RequireSaslCountries[i] = strings.ToUpper(country)
+	}
+	return nil
+}
+
+// Lookup returns what's known about an IP address.
+func (conf *GeoIPConfig) Lookup(ip net.IP) (info IPInfo) {
+	if conf.lookup == nil || ip == nil {
+		return
+	}
+	return conf.lookup.Lookup(ip)
+}
+
+func ipInfoMatches(info IPInfo, countries []string, asns []uint) bool {
+	if info.Country != "" {
+		for _, country := range countries {
+			if info.Country == country {
+				return true
+			}
+		}
+	}
+	if info.ASN != 0 {
+		for _, asn := range asns {
+			if info.ASN == asn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsDenied returns whether connections from this location are rejected.
+func (conf *GeoIPConfig) IsDenied(info IPInfo) bool {
+	return ipInfoMatches(info, conf.DenyCountries, conf.DenyASNs)
+}
+
+// RequiresSasl returns whether connections from this location must authenticate with SASL.
+func (conf *GeoIPConfig) RequiresSasl(info IPInfo) bool {
+	return ipInfoMatches(info, conf.RequireSaslCountries, conf.RequireSaslASNs)
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestGeoIPPolicy(t *testing.T) {
+	conf := GeoIPConfig{
+		DenyCountries:        []string{"XX"},
+		RequireSaslCountries: []string{"YY"},
+		RequireSaslASNs:      []uint{64496},
+	}
+
+	if !conf.IsDenied(IPInfo{Country: "XX"}) || conf.IsDenied(IPInfo{Country: "YY"}) {
+		t.Errorf("incorrect deny policy")
+	}
+	if !conf.RequiresSasl(IPInfo{Country: "YY"}) || !conf.RequiresSasl(IPInfo{Country: "US", ASN: 64496}) {
+		t.Errorf("incorrect require-sasl policy")
+	}
+	if conf.RequiresSasl(IPInfo{}) || conf.IsDenied(IPInfo{}) {
+		t.Errorf("unknown locations should not be restricted")
+	}
+
+	if str := (IPInfo{Country: "US", ASN: 64496}).String(); str != "US/AS64496" {
+		t.Errorf("incorrect description: %s", str)
+	}
+}
@@ -4,9 +4,13 @@
 package irc
 
 import (
+	"sort"
+	"time"
+
 	"github.com/oragono/oragono/irc/isupport"
 	"github.com/oragono/oragono/irc/languages"
 	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
 )
 
 func (server *Server) Config() (config *Config) {
@@ -34,6 +38,14 @@ func (server *Server) RecoverFromErrors() bool {
 	return *server.Config().Debug.RecoverFromErrors
 }
 
+// Clock returns the clock that drives the client timers.
+func (server *Server) Clock() utils.Clock {
+	if server.clock == nil {
+		return timerClock
+	}
+	return server.clock
+}
+
 func (server *Server) DefaultChannelModes() modes.Modes {
 	return server.Config().Channels.defaultModes
 }
@@ -123,6 +135,62 @@ func (client *Client) SetResumeID(id string) {
 	client.resumeID = id
 }
 
+func (client *Client) IsQuitting() bool {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.isQuitting
+}
+
+func (client *Client) ConnectClass() string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.connectClass
+}
+
+func (client *Client) setConnectClass(class string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.connectClass = class
+}
+
+func (client *Client) Metadata() map[string]string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return copyMetadata(client.metadata)
+}
+
+func (client *Client) updateMetadata(update func(metadata map[string]string) (map[string]string, error)) (err error) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	metadata, err := update(copyMetadata(client.metadata))
+	if err == nil {
+		client.metadata = metadata
+	}
+	return
+}
+
+func (client *Client) MetadataSubscriptions() (result []string) {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	for key := range client.metadataSubs {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+func (client *Client) pendingAnnouncement() *pendingAnnouncement {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.pendingAnnounce
+}
+
+func (client *Client) setPendingAnnouncement(pending *pendingAnnouncement) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.pendingAnnounce = pending
+}
+
 func (client *Client) Oper() *Oper {
 	client.stateMutex.RLock()
 	defer client.stateMutex.RUnlock()
@@ -142,6 +210,12 @@ func (client *Client) SetRegistered() {
 	// and other goroutines must read it with synchronization
 	client.stateMutex.Lock()
 	client.registered = true
+	// count the client as registered atomically with respect to destroy(),
+	// which may run concurrently (e.g., from KILL), so that destroy() undoes
+	// exactly what was counted here
+	if !client.isDestroyed {
+		client.server.stats.Register(client.statsModes())
+	}
 	client.stateMutex.Unlock()
 }
 
@@ -177,12 +251,56 @@ func (client *Client) Account() string {
 	return client.account
 }
 
+// DeviceID returns the device the client identified itself as when it logged in.
+func (client *Client) APIToken() *APIToken {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.apiToken
+}
+
+func (client *Client) setAPIToken(token *APIToken) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.apiToken = token
+}
+
+func (client *Client) DeviceID() string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.deviceID
+}
+
+func (client *Client) SetDeviceID(device string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.deviceID = device
+}
+
+// Persona returns the persona the client is attached to.
+func (client *Client) Persona() string {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.persona
+}
+
+func (client *Client) SetPersona(persona string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.persona = persona
+}
+
 func (client *Client) AccountName() string {
 	client.stateMutex.RLock()
 	defer client.stateMutex.RUnlock()
 	return client.accountName
 }
 
+func (client *Client) setAccountRegisteredAt(registeredAt time.Time) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.accountRegisteredAt = registeredAt
+}
+
 func (client *Client) SetAccountName(account string) (changed bool) {
 	var casefoldedAccount string
 	var err error
@@ -222,6 +340,27 @@ func (client *Client) SetMode(mode modes.Mode, on bool) bool {
 	return client.flags.SetMode(mode, on)
 }
 
+// statsModes returns whether the client is counted as invisible and as an
+// operator in the server's statistics.
+func (client *Client) statsModes() (invisible, operator bool) {
+	return client.flags.HasMode(modes.Invisible), client.flags.HasMode(modes.Operator) || client.flags.HasMode(modes.LocalOperator)
+}
+
+// setCountedMode is like SetMode, for the modes that are reflected in the
+// server's statistics; the statistics are updated along with the mode.
+func (client *Client) setCountedMode(mode modes.Mode, on bool) (changed bool) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	wasInvisible, wasOperator := client.statsModes()
+	changed = client.flags.SetMode(mode, on)
+	if changed && client.registered && !client.isDestroyed {
+		invisible, operator := client.statsModes()
+		client.server.stats.ChangeInvisible(int(boolToDelta(invisible) - boolToDelta(wasInvisible)))
+		client.server.stats.ChangeOperators(int(boolToDelta(operator) - boolToDelta(wasOperator)))
+	}
+	return
+}
+
 func (client *Client) Channels() (result []*Channel) {
 	client.stateMutex.RLock()
 	defer client.stateMutex.RUnlock()
@@ -245,6 +384,19 @@ func (client *Client) WhoWas() (result WhoWas) {
 	return client.Details().WhoWas
 }
 
+// IPInfo returns what's known about the location of the client's IP.
+func (client *Client) IPInfo() IPInfo {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.ipInfo
+}
+
+func (client *Client) SetIPInfo(info IPInfo) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.ipInfo = info
+}
+
 func (client *Client) Details() (result ClientDetails) {
 	client.stateMutex.RLock()
 	defer client.stateMutex.RUnlock()
@@ -258,6 +410,11 @@ func (client *Client) Details() (result ClientDetails) {
 	result.nickMaskCasefolded = client.nickMaskCasefolded
 	result.account = client.account
 	result.accountName = client.accountName
+	if client.proxiedIP != nil {
+		result.ip = client.proxiedIP
+	} else {
+		result.ip = client.realIP
+	}
 	return
 }
 
@@ -286,9 +443,7 @@ func (channel *Channel) setNameCasefolded(nameCasefolded string) {
 }
 
 func (channel *Channel) Members() (result []*Client) {
-	channel.stateMutex.RLock()
-	defer channel.stateMutex.RUnlock()
-	return channel.membersCache
+	return channel.members.Members()
 }
 
 func (channel *Channel) setUserLimit(limit int) {
@@ -309,8 +464,139 @@ func (channel *Channel) setKey(key string) {
 	channel.key = key
 }
 
+func (channel *Channel) CreatedTime() time.Time {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.createdTime
+}
+
+func (channel *Channel) TopicInfo() (topic string, topicSetTime time.Time) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.topic, channel.topicSetTime
+}
+
+func (channel *Channel) EntryMsg() string {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.entryMsg
+}
+
+func (channel *Channel) setTopicLock(topicLock bool) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.topicLock = topicLock
+}
+
+func (channel *Channel) setEntryMsg(entryMsg string) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.entryMsg = entryMsg
+}
+
+func (channel *Channel) Tags() []string {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.tags
+}
+
+func (channel *Channel) setTags(tags []string) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.tags = tags
+}
+
+func (channel *Channel) JoinFloodSettings() JoinFloodSettings {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.joinFloodSettings
+}
+
+func (channel *Channel) setJoinFloodSettings(settings JoinFloodSettings) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.joinFloodSettings = settings
+}
+
+func (channel *Channel) CTCPPolicy() string {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.ctcpPolicy
+}
+
+func (channel *Channel) setCTCPPolicy(policy string) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.ctcpPolicy = policy
+}
+
+func (channel *Channel) ExitMessagePolicy() ExitMessagePolicy {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.exitMessagePolicy
+}
+
+func (channel *Channel) setExitMessagePolicy(policy ExitMessagePolicy) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.exitMessagePolicy = policy
+}
+
+func (channel *Channel) LinkPolicy() LinkPolicy {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.linkPolicy
+}
+
+func (channel *Channel) setLinkPolicy(policy LinkPolicy) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.linkPolicy = policy
+}
+
+func (channel *Channel) Metadata() map[string]string {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return copyMetadata(channel.metadata)
+}
+
+func (channel *Channel) updateMetadata(update func(metadata map[string]string) (map[string]string, error)) (err error) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	metadata, err := update(copyMetadata(channel.metadata))
+	if err == nil {
+		channel.metadata = metadata
+	}
+	return
+}
+
+func (channel *Channel) Verification() ChannelVerification {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.verification
+}
+
+func (channel *Channel) setVerification(verification ChannelVerification) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	channel.verification = verification
+}
+
 func (channel *Channel) Founder() string {
 	channel.stateMutex.RLock()
 	defer channel.stateMutex.RUnlock()
 	return channel.registeredFounder
 }
+
+func (channel *Channel) Successor() string {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.registeredSuccessor
+}
+
+// AccountMode returns the persistent channel mode of an account.
+func (channel *Channel) AccountMode(account string) modes.Mode {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	return channel.accountToUMode[account]
+}
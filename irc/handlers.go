@@ -20,7 +20,6 @@ import (
 	"time"
 
 	"github.com/goshuirc/irc-go/ircfmt"
-	"github.com/goshuirc/irc-go/ircmatch"
 	"github.com/goshuirc/irc-go/ircmsg"
 	"github.com/oragono/oragono/irc/caps"
 	"github.com/oragono/oragono/irc/custime"
@@ -183,7 +182,7 @@ func registrationErrorToMessageAndCode(err error) (message, numeric string) {
 	case errAccountAlreadyRegistered, errAccountAlreadyVerified:
 		message = err.Error()
 		numeric = ERR_ACCOUNT_ALREADY_EXISTS
-	case errAccountCreation, errAccountMustHoldNick, errAccountBadPassphrase, errCertfpAlreadyExists, errFeatureDisabled:
+	case errAccountCreation, errAccountMustHoldNick, errAccountBadPassphrase, errCertfpAlreadyExists, errFeatureDisabled, errTemporarilyDisabled:
 		message = err.Error()
 	}
 	return
@@ -210,6 +209,14 @@ func sendSuccessfulSaslAuth(client *Client, rb *ResponseBuffer, forNS bool) {
 		rb.Add(nil, client.server.name, RPL_SASLSUCCESS, details.nick, client.t("Authentication successful"))
 	}
 
+	announceAccountLogin(client)
+}
+
+// announceAccountLogin notifies the client's friends (via account-notify), the opers
+// and the logs that the client logged into an account.
+func announceAccountLogin(client *Client) {
+	details := client.Details()
+
 	// dispatch account-notify
 	for friend := range client.Friends(caps.AccountNotify) {
 		friend.Send(nil, details.nickMask, "ACCOUNT", details.accountName)
@@ -248,6 +255,66 @@ func accVerifyHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb
 	return false
 }
 
+// ACCEPT <nick>{,-<nick>}
+// ACCEPT *
+func acceptHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+
+	if msg.Params[0] == "*" {
+		var nicks []string
+		for _, cfnick := range client.AcceptList() {
+			// report the uncasefolded nick if the client is online
+			if aclient := server.clients.Get(cfnick); aclient != nil {
+				cfnick = aclient.Nick()
+			}
+			nicks = append(nicks, cfnick)
+		}
+		for _, line := range utils.ArgsToStrings(maxLastArgLength, nicks, " ") {
+			rb.Add(nil, server.name, RPL_ACCEPTLIST, nick, line)
+		}
+		rb.Add(nil, server.name, RPL_ENDOFACCEPT, nick, client.t("End of /ACCEPT list"))
+		return false
+	}
+
+	limit := server.Limits().AcceptEntries
+	changed := false
+	for _, target := range strings.Split(msg.Params[0], ",") {
+		remove := strings.HasPrefix(target, "-")
+		if remove {
+			target = target[1:]
+		}
+		cfnick, err := CasefoldName(target)
+		if err != nil {
+			rb.Add(nil, server.name, ERR_NOSUCHNICK, nick, target, client.t("No such nick"))
+			continue
+		}
+
+		if remove {
+			if client.RemoveAccept(cfnick) {
+				changed = true
+			} else {
+				rb.Add(nil, server.name, ERR_ACCEPTNOT, nick, target, client.t("is not on your accept list"))
+			}
+		} else {
+			added, err := client.AddAccept(cfnick, limit)
+			if err == errAcceptListFull {
+				rb.Add(nil, server.name, ERR_ACCEPTFULL, nick, client.t("Accept list is full"))
+				break
+			} else if added {
+				changed = true
+				server.acceptDMRequest(client, cfnick)
+			} else {
+				rb.Add(nil, server.name, ERR_ACCEPTEXIST, nick, target, client.t("is already on your accept list"))
+			}
+		}
+	}
+
+	if changed {
+		persistAcceptList(server, client)
+	}
+	return false
+}
+
 // AUTHENTICATE [<mechanism>|<data>|*]
 func authenticateHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	// sasl abort
@@ -262,7 +329,13 @@ func authenticateHandler(server *Server, client *Client, msg ircmsg.IrcMessage,
 	// start new sasl session
 	if !client.saslInProgress {
 		mechanism := strings.ToUpper(msg.Params[0])
-		_, mechanismIsEnabled := EnabledSaslMechanisms[mechanism]
+		mechanismIsEnabled := false
+		for _, enabledMechanism := range server.AccountConfig().SaslMechanisms() {
+			if mechanism == enabledMechanism {
+				mechanismIsEnabled = true
+				break
+			}
+		}
 
 		if mechanismIsEnabled {
 			client.saslInProgress = true
@@ -365,6 +438,18 @@ func authPlainHandler(server *Server, client *Client, mechanism string, value []
 		return false
 	}
 
+	// account@device logs in as a specific device, for read markers, and
+	// account/persona attaches to a persona once the connection is complete
+	accountKey, device := splitDeviceID(accountKey)
+	accountKey, persona := splitPersona(accountKey)
+	if persona != "" {
+		var err error
+		persona, err = normalizePersonaName(persona)
+		if err != nil || !server.AccountConfig().Personas.Enabled || client.Registered() {
+			rb.Add(nil, server.name, ERR_SASLFAIL, nick, client.t("SASL authentication failed: Invalid persona"))
+			return false
+		}
+	}
 	password := string(splitValue[2])
 	err := server.accounts.AuthenticateByPassphrase(client, accountKey, password)
 	if err != nil {
@@ -372,13 +457,15 @@ func authPlainHandler(server *Server, client *Client, mechanism string, value []
 		rb.Add(nil, server.name, ERR_SASLFAIL, nick, fmt.Sprintf("%s: %s", client.t("SASL authentication failed"), client.t(msg)))
 		return false
 	}
+	client.SetDeviceID(device)
+	client.SetPersona(persona)
 
 	sendSuccessfulSaslAuth(client, rb, false)
 	return false
 }
 
 func authErrorToMessage(server *Server, err error) (msg string) {
-	if err == errAccountDoesNotExist || err == errAccountUnverified || err == errAccountInvalidCredentials {
+	if err == errAccountDoesNotExist || err == errAccountUnverified || err == errAccountInvalidCredentials || err == errAccountSuspended {
 		msg = err.Error()
 	} else {
 		server.logger.Error("internal", "sasl authentication failure", err.Error())
@@ -405,6 +492,33 @@ func authExternalHandler(server *Server, client *Client, mechanism string, value
 	return false
 }
 
+// AUTHENTICATE JWT
+func authJWTHandler(server *Server, client *Client, mechanism string, value []byte, rb *ResponseBuffer) bool {
+	err := server.accounts.AuthenticateByJWT(client, string(value))
+	if err != nil {
+		msg := authErrorToMessage(server, err)
+		rb.Add(nil, server.name, ERR_SASLFAIL, client.nick, fmt.Sprintf("%s: %s", client.t("SASL authentication failed"), client.t(msg)))
+		return false
+	}
+
+	sendSuccessfulSaslAuth(client, rb, false)
+	return false
+}
+
+// BACKUP [JSON]
+func backupHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	asJSON := 0 < len(msg.Params) && strings.ToUpper(msg.Params[0]) == "JSON"
+	path, err := server.backupDatastore(asJSON)
+	if err != nil {
+		server.logger.Error("server", "Could not back up datastore", err.Error())
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), "BACKUP", client.t("Could not back up the datastore"))
+		return false
+	}
+	server.logger.Info("server", fmt.Sprintf("BACKUP command used by %s, wrote %s", client.Nick(), path))
+	rb.Notice(fmt.Sprintf(client.t("Datastore backed up to %s"), path))
+	return false
+}
+
 // AWAY [<message>]
 func awayHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	var isAway bool
@@ -436,16 +550,36 @@ func awayHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 	}}
 	rb.Add(nil, server.name, "MODE", client.nick, modech.String())
 
-	// dispatch away-notify
-	for friend := range client.Friends(caps.AwayNotify) {
-		if isAway {
-			friend.SendFromClient("", client, nil, "AWAY", awayMessage)
-		} else {
-			friend.SendFromClient("", client, nil, "AWAY")
+	// an explicit AWAY overrides auto-away
+	client.stateMutex.Lock()
+	client.autoAwaySet = false
+	client.stateMutex.Unlock()
+
+	dispatchAwayNotify(client, isAway, awayMessage)
+
+	return false
+}
+
+// BRB [reason]
+func brbHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	if !client.capabilities.Has(caps.Resume) || client.ResumeID() == "" {
+		rb.Add(nil, server.name, "BRB", "ERR", client.t("Cannot BRB without a resume token"))
+		return false
+	}
+
+	reason := defaultBrbReason
+	if 0 < len(msg.Params) && msg.Params[0] != "" {
+		reason = msg.Params[0]
+		awayLen := server.Limits().AwayLen
+		if len(reason) > awayLen {
+			reason = reason[:awayLen]
 		}
 	}
 
-	return false
+	timeout := server.Config().Server.BrbTimeout
+	rb.Add(nil, server.name, "BRB", strconv.Itoa(int(timeout.Seconds())))
+	client.brb(reason, timeout)
+	return true
 }
 
 // CAP <subcmd> [<caps>]
@@ -527,6 +661,48 @@ func capHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Respo
 	return false
 }
 
+// CHALLENGE <name>
+// CHALLENGE +<response>
+func challengeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	if client.HasMode(modes.Operator) {
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.nick, "CHALLENGE", client.t("You're already opered-up!"))
+		return false
+	}
+
+	if !strings.HasPrefix(msg.Params[0], "+") {
+		oper := server.GetOperator(msg.Params[0])
+		if oper == nil || oper.PublicKey == nil || !oper.CanOperFrom(client) {
+			rb.Add(nil, server.name, ERR_NOOPERHOST, client.nick, client.t("No appropriate operator blocks were found for your host"))
+			return false
+		}
+		lines, err := client.startOperChallenge(oper)
+		if err != nil {
+			server.logger.Error("internal", "couldn't generate oper challenge", err.Error())
+			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.nick, "CHALLENGE", client.t("Could not generate challenge"))
+			return false
+		}
+		for _, line := range lines {
+			rb.Add(nil, server.name, RPL_RSACHALLENGE2, client.nick, line)
+		}
+		rb.Add(nil, server.name, RPL_ENDOFRSACHALLENGE2, client.nick, client.t("End of CHALLENGE"))
+		return false
+	}
+
+	operName, ok := client.finishOperChallenge(msg.Params[0][1:])
+	var oper *Oper
+	if ok {
+		oper = server.GetOperator(operName)
+	}
+	if oper == nil || !oper.CanOperFrom(client) {
+		rb.Add(nil, server.name, ERR_PASSWDMISMATCH, client.nick, client.t("Password incorrect"))
+		client.Quit(client.t("Password incorrect"))
+		return true
+	}
+
+	operUp(server, client, oper, rb)
+	return false
+}
+
 // CHATHISTORY <target> <preposition> <query> [<limit>]
 // e.g., CHATHISTORY #ircv3 AFTER id=ytNBbt565yt4r3err3 10
 // CHATHISTORY <target> BETWEEN <query> <query> <direction> [<limit>]
@@ -756,6 +932,24 @@ func chathistoryHandler(server *Server, client *Client, msg ircmsg.IrcMessage, r
 	return
 }
 
+const (
+	// number of clients shown by DEBUG SENDQ
+	debugSendQLimit = 20
+)
+
+// sendQSorter sorts clients in descending order of sendq length
+type sendQSorter struct {
+	clients []*Client
+	sendQs  []int
+}
+
+func (s sendQSorter) Len() int           { return len(s.clients) }
+func (s sendQSorter) Less(i, j int) bool { return s.sendQs[j] < s.sendQs[i] }
+func (s sendQSorter) Swap(i, j int) {
+	s.clients[i], s.clients[j] = s.clients[j], s.clients[i]
+	s.sendQs[i], s.sendQs[j] = s.sendQs[j], s.sendQs[i]
+}
+
 // DEBUG <subcmd>
 func debugHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	param := strings.ToUpper(msg.Params[0])
@@ -810,6 +1004,23 @@ func debugHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 	case "STOPCPUPROFILE":
 		pprof.StopCPUProfile()
 		rb.Notice(fmt.Sprintf("CPU profiling stopped"))
+
+	case "SENDQ":
+		// list the clients with the fullest sendqs
+		clients := server.clients.AllClients()
+		sendQs := make([]int, len(clients))
+		for i, target := range clients {
+			sendQs[i], _ = target.socket.SendQ()
+		}
+		sort.Sort(sendQSorter{clients, sendQs})
+		for i, target := range clients {
+			if debugSendQLimit <= i || sendQs[i] == 0 {
+				break
+			}
+			_, max := target.socket.SendQ()
+			rb.Notice(fmt.Sprintf("sendq: %-20s %d/%d bytes", target.Nick(), sendQs[i], max))
+		}
+		rb.Notice(fmt.Sprintf("total clients: %d", len(clients)))
 	}
 	return false
 }
@@ -841,6 +1052,69 @@ func formatBanForListing(client *Client, key string, info IPBanInfo) string {
 	return fmt.Sprintf(client.t("Ban - %[1]s - added by %[2]s - %[3]s"), key, info.OperName, desc)
 }
 
+// DEFCON [level] [duration]
+func defconHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	if len(msg.Params) == 0 {
+		rb.Notice(fmt.Sprintf(client.t("Current DEFCON level is %d"), server.defcon.Level()))
+		return false
+	}
+
+	level, err := strconv.ParseUint(msg.Params[0], 10, 32)
+	if err != nil || level < uint64(DefconThrottleJoins) || uint64(DefconNormal) < level {
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, fmt.Sprintf(client.t("DEFCON level must be between %[1]d and %[2]d"), DefconThrottleJoins, DefconNormal))
+		return false
+	}
+
+	var duration time.Duration
+	if 1 < len(msg.Params) {
+		duration, err = custime.ParseDuration(msg.Params[1])
+		if err != nil {
+			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, client.t("Invalid duration"))
+			return false
+		}
+	}
+
+	server.defcon.SetLevel(uint32(level), duration)
+
+	var message string
+	if duration != 0 {
+		message = fmt.Sprintf("%s set DEFCON level to %d for %v", client.Nick(), level, duration)
+	} else {
+		message = fmt.Sprintf("%s set DEFCON level to %d", client.Nick(), level)
+	}
+	server.logger.Info("server", message)
+	server.snomasks.Send(sno.LocalAccouncements, message)
+	rb.Notice(fmt.Sprintf(client.t("DEFCON level is now %d"), level))
+	return false
+}
+
+// DIE [delay] [reason]
+func dieHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	var delay time.Duration
+	var reason string
+	if 0 < len(msg.Params) {
+		var err error
+		delay, err = custime.ParseDuration(msg.Params[0])
+		if err != nil || delay < 0 {
+			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, client.t("Invalid duration"))
+			return false
+		}
+	}
+	if 1 < len(msg.Params) {
+		reason = strings.Join(msg.Params[1:], " ")
+	}
+
+	server.logger.Info("server", fmt.Sprintf("DIE command used by %s", client.Nick()))
+	if server.shutdown.Start(delay, reason) != nil {
+		rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, client.t("Server is already shutting down"))
+		return false
+	}
+	if delay != 0 {
+		rb.Notice(fmt.Sprintf(client.t("Server will shut down in %v"), delay))
+	}
+	return false
+}
+
 // DLINE [ANDKILL] [MYSELF] [duration] <ip>/<net> [ON <server>] [reason [| oper reason]]
 // DLINE LIST
 func dlineHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
@@ -1157,8 +1431,14 @@ func joinHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 
 	config := server.Config()
 	oper := client.Oper()
+	if 1 < len(channels) {
+		startJoinBurst(client, rb)
+	}
 	for i, name := range channels {
-		if config.Channels.MaxChannelsPerClient <= client.NumChannels() && oper == nil {
+		if i != 0 {
+			flushJoinBurst(rb, i, config.Server.JoinBurst.ChunkSize)
+		}
+		if client.connectionClass().MaxChannels <= client.NumChannels() && oper == nil {
 			rb.Add(nil, server.name, ERR_TOOMANYCHANNELS, client.Nick(), name, client.t("You have joined too many channels"))
 			return false
 		}
@@ -1169,6 +1449,14 @@ func joinHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 		err := server.channels.Join(client, name, key, false, rb)
 		if err == errNoSuchChannel {
 			rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), name, client.t("No such channel"))
+		} else if err == errChannelCreationDisabled {
+			rb.Add(nil, server.name, ERR_UNAVAILRESOURCE, client.Nick(), name, client.t("Channel creation is temporarily disabled"))
+		} else if err == errChannelCreationProbation {
+			rb.Add(nil, server.name, ERR_UNAVAILRESOURCE, client.Nick(), name, client.t("New accounts can't create channels yet"))
+		} else if err == errConfusableChannelName {
+			rb.Add(nil, server.name, ERR_BADCHANNAME, client.Nick(), name, client.t("Channel name is confusable with an existing channel"))
+		} else if err == errAPITokenRestricted {
+			rb.Add(nil, server.name, "FAIL", "JOIN", "TOKEN_RESTRICTED", name, client.t(err.Error()))
 		}
 	}
 	return false
@@ -1188,7 +1476,7 @@ func sajoinHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 		} else {
 			target = server.clients.Get(msg.Params[0])
 			if target == nil {
-				rb.Add(nil, server.name, ERR_NOSUCHNICK, client.Nick(), msg.Params[0], "No such nick")
+				rb.Add(nil, server.name, ERR_NOSUCHNICK, client.Nick(), msg.Params[0], client.t("No such nick"))
 				return false
 			}
 			channelString = msg.Params[1]
@@ -1333,20 +1621,13 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 		rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, client.nick, msg.Command, client.t("Not enough parameters"))
 		return false
 	}
-	mask := strings.ToLower(msg.Params[currentArg])
+	mask := canonicalizeKlineMask(msg.Params[currentArg])
 	currentArg++
 
-	// check mask
-	if !strings.Contains(mask, "!") && !strings.Contains(mask, "@") {
-		mask = mask + "!*@*"
-	} else if !strings.Contains(mask, "@") {
-		mask = mask + "@*"
-	}
-
-	matcher := ircmatch.MakeMatch(mask)
+	matcher := utils.NewGlob(mask)
 
 	for _, clientMask := range client.AllNickmasks() {
-		if !klineMyself && matcher.Match(clientMask) {
+		if !klineMyself && matcher.MatchString(clientMask) {
 			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.nick, msg.Command, client.t("This ban matches you. To KLINE yourself, you must use the command:  /KLINE MYSELF <arguments>"))
 			return false
 		}
@@ -1390,7 +1671,7 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 
 		for _, mcl := range server.clients.AllClients() {
 			for _, clientMask := range mcl.AllNickmasks() {
-				if matcher.Match(clientMask) {
+				if matcher.MatchString(clientMask) {
 					clientsToKill = append(clientsToKill, mcl)
 					killedClientNicks = append(killedClientNicks, mcl.nick)
 				}
@@ -1419,8 +1700,6 @@ func klineHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 // LANGUAGE <code>{ <code>}
 func languageHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	nick := client.Nick()
-	alreadyDoneLanguages := make(map[string]bool)
-	var appliedLanguages []string
 
 	lm := server.Languages()
 	supportedLanguagesCount := lm.Count()
@@ -1429,29 +1708,10 @@ func languageHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *
 		return false
 	}
 
-	for _, value := range msg.Params {
-		value = strings.ToLower(value)
-		// strip ~ from the language if it has it
-		value = strings.TrimPrefix(value, "~")
-
-		// silently ignore empty languages or those with spaces in them
-		if len(value) == 0 || strings.Contains(value, " ") {
-			continue
-		}
-
-		_, exists := lm.Languages[value]
-		if !exists {
-			rb.Add(nil, client.server.name, ERR_NOLANGUAGE, nick, fmt.Sprintf(client.t("Language %s is not supported by this server"), value))
-			return false
-		}
-
-		// if we've already applied the given language, skip it
-		_, exists = alreadyDoneLanguages[value]
-		if exists {
-			continue
-		}
-
-		appliedLanguages = append(appliedLanguages, value)
+	appliedLanguages, unknown := lm.Normalize(msg.Params)
+	if unknown != "" {
+		rb.Add(nil, client.server.name, ERR_NOLANGUAGE, nick, fmt.Sprintf(client.t("Language %s is not supported by this server"), unknown))
+		return false
 	}
 
 	var langsToSet []string
@@ -1472,53 +1732,36 @@ func languageHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *
 
 // LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
 func listHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
-	// get channels
+	// get channels and elist conditions
 	var channels []string
-	for _, param := range msg.Params {
-		if 0 < len(param) && param[0] == '#' {
-			for _, channame := range strings.Split(param, ",") {
-				if 0 < len(channame) && channame[0] == '#' {
-					channels = append(channels, channame)
-				}
-			}
-		}
-	}
-
-	// get elist conditions
 	var matcher elistMatcher
 	for _, param := range msg.Params {
-		if len(param) < 1 {
-			continue
-		}
-
-		if param[0] == '<' {
-			param = param[1:]
-			val, err := strconv.Atoi(param)
-			if err != nil {
-				continue
-			}
-			matcher.MaxClientsActive = true
-			matcher.MaxClients = val - 1 // -1 because < means less than the given number
-		}
-		if param[0] == '>' {
-			param = param[1:]
-			val, err := strconv.Atoi(param)
-			if err != nil {
-				continue
+		for _, item := range strings.Split(param, ",") {
+			if 0 < len(item) && item[0] == '#' && !strings.ContainsAny(item, "*?") {
+				channels = append(channels, item)
+			} else {
+				matcher.AddCondition(item)
 			}
-			matcher.MinClientsActive = true
-			matcher.MinClients = val + 1 // +1 because > means more than the given number
 		}
 	}
 
 	clientIsOp := client.HasMode(modes.Operator)
 	if len(channels) == 0 {
+		// SAFELIST: send the (potentially huge) list in chunks, blocking on each one;
+		// this paces the output to the client's read speed and keeps it out of the sendq
+		listed := 0
 		for _, channel := range server.channels.Channels() {
 			if !clientIsOp && channel.flags.HasMode(modes.Secret) {
 				continue
 			}
 			if matcher.Matches(channel) {
 				client.RplList(channel, rb)
+				listed++
+				if listed%listChunkSize == 0 {
+					rb.Flush(true)
+					// large lists count against the client's fakelag budget
+					client.fakelag.Touch()
+				}
 			}
 		}
 	} else {
@@ -1547,17 +1790,69 @@ func listHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 
 // LUSERS [<mask> [<server>]]
 func lusersHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
-	//TODO(vegax87) Fix network statistics and additional parameters
-	totalCount, invisibleCount, operCount := server.stats.GetStats()
+	// the mask and server parameters are ignored, since there's only one server
+	stats := server.stats.GetValues()
+	nick := client.Nick()
 
-	rb.Add(nil, server.name, RPL_LUSERCLIENT, client.nick, fmt.Sprintf(client.t("There are %[1]d users and %[2]d invisible on %[3]d server(s)"), totalCount-invisibleCount, invisibleCount, 1))
-	rb.Add(nil, server.name, RPL_LUSEROP, client.nick, strconv.Itoa(operCount), client.t("IRC Operators online"))
-	rb.Add(nil, server.name, RPL_LUSERCHANNELS, client.nick, strconv.Itoa(server.channels.Len()), client.t("channels formed"))
-	rb.Add(nil, server.name, RPL_LUSERME, client.nick, fmt.Sprintf(client.t("I have %[1]d clients and %[2]d servers"), totalCount, 1))
+	rb.Add(nil, server.name, RPL_LUSERCLIENT, nick, fmt.Sprintf(client.t("There are %[1]d users and %[2]d invisible on %[3]d server(s)"), stats.Total-stats.Invisible, stats.Invisible, 1))
+	rb.Add(nil, server.name, RPL_LUSEROP, nick, strconv.Itoa(stats.Operators), client.t("IRC Operators online"))
+	if stats.Unknown != 0 {
+		rb.Add(nil, server.name, RPL_LUSERUNKNOWN, nick, strconv.Itoa(stats.Unknown), client.t("unregistered connections"))
+	}
+	rb.Add(nil, server.name, RPL_LUSERCHANNELS, nick, strconv.Itoa(server.channels.Len()), client.t("channels formed"))
+	rb.Add(nil, server.name, RPL_LUSERME, nick, fmt.Sprintf(client.t("I have %[1]d clients and %[2]d servers"), stats.Total, 0))
+	total, max := strconv.Itoa(stats.Total), strconv.Itoa(stats.Max)
+	rb.Add(nil, server.name, RPL_LOCALUSERS, nick, total, max, fmt.Sprintf(client.t("Current local users %[1]s, max %[2]s"), total, max))
+	rb.Add(nil, server.name, RPL_GLOBALUSERS, nick, total, max, fmt.Sprintf(client.t("Current global users %[1]s, max %[2]s"), total, max))
 
 	return false
 }
 
+// MARKREAD <target> [timestamp=<timestamp>]
+func markreadHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	target := msg.Params[0]
+	account := client.Account()
+	if account == "" {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "ACCOUNT_REQUIRED", target, client.t("You must be logged into an account to use read markers"))
+		return false
+	}
+	cftarget, err := casefoldReadMarkerTarget(target)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INVALID_PARAMS", target, client.t("Invalid target"))
+		return false
+	}
+
+	if len(msg.Params) == 1 {
+		sendReadMarker(client, target, server.accounts.ReadMarker(account, sharedReadMarkers, cftarget), rb)
+		return false
+	}
+
+	var timestamp time.Time
+	if strings.HasPrefix(msg.Params[1], "timestamp=") {
+		timestamp, err = time.Parse(IRCv3TimestampFormat, strings.TrimPrefix(msg.Params[1], "timestamp="))
+	}
+	if err != nil || timestamp.IsZero() {
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INVALID_PARAMS", target, client.t("Invalid timestamp"))
+		return false
+	}
+	marker, err := server.accounts.SetReadMarker(account, client.DeviceID(), cftarget, timestamp)
+	if err != nil {
+		server.logger.Error("internal", "couldn't store read marker", err.Error())
+		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INTERNAL_ERROR", target, client.t("Couldn't store the read marker"))
+		return false
+	}
+
+	// sync the marker to all the account's sessions
+	for _, session := range server.accounts.AccountToClients(account) {
+		if session == client {
+			sendReadMarker(client, target, marker, rb)
+		} else if session.capabilities.Has(caps.ReadMarker) {
+			sendReadMarker(session, target, marker, nil)
+		}
+	}
+	return false
+}
+
 // MODE <target> [<modestring> [<mode arguments>...]]
 func modeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	_, errChan := CasefoldChannel(msg.Params[0])
@@ -1585,6 +1880,7 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 		// parse out real mode changes
 		params := msg.Params[1:]
 		changes, unknown := modes.ParseChannelModeChanges(params...)
+		changes = server.Config().filterStatusModes(changes, unknown)
 
 		// alert for unknown mode changes
 		for char := range unknown {
@@ -1600,8 +1896,12 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 
 	// save changes
 	var includeFlags uint
+	var secureOnlySet bool
 	for _, change := range applied {
 		includeFlags |= IncludeModes
+		if change.Mode == modes.SecureOnly && change.Op == modes.Add {
+			secureOnlySet = true
+		}
 		if change.Mode == modes.BanMask || change.Mode == modes.ExceptMask || change.Mode == modes.InviteMask {
 			includeFlags |= IncludeLists
 		}
@@ -1627,6 +1927,10 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 		rb.Add(nil, client.nickMaskString, RPL_CHANNELMODEIS, args...)
 		rb.Add(nil, client.nickMaskString, RPL_CHANNELCREATED, client.nick, channel.name, strconv.FormatInt(channel.createdTime.Unix(), 10))
 	}
+
+	if secureOnlySet && server.Config().Channels.KickInsecureOnSecureOnly {
+		channel.KickInsecure()
+	}
 	return false
 }
 
@@ -1714,6 +2018,7 @@ func monitorRemoveHandler(server *Server, client *Client, msg ircmsg.IrcMessage,
 		}
 		server.monitorManager.Remove(client, cfnick)
 	}
+	persistMonitorList(server, client)
 
 	return false
 }
@@ -1733,7 +2038,7 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb
 	targets := strings.Split(msg.Params[1], ",")
 	for _, target := range targets {
 		// check name length
-		if len(target) < 1 || len(targets) > limits.NickLen {
+		if len(target) < 1 || len(target) > limits.NickLen {
 			continue
 		}
 
@@ -1759,6 +2064,8 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb
 		}
 	}
 
+	persistMonitorList(server, client)
+
 	if len(online) > 0 {
 		rb.Add(nil, server.name, RPL_MONONLINE, client.Nick(), strings.Join(online, ","))
 	}
@@ -1772,6 +2079,7 @@ func monitorAddHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb
 // MONITOR C
 func monitorClearHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	server.monitorManager.RemoveAll(client)
+	persistMonitorList(server, client)
 	return false
 }
 
@@ -1794,7 +2102,7 @@ func monitorListHandler(server *Server, client *Client, msg ircmsg.IrcMessage, r
 		rb.Add(nil, server.name, RPL_MONLIST, nick, line)
 	}
 
-	rb.Add(nil, server.name, RPL_ENDOFMONLIST, nick, "End of MONITOR list")
+	rb.Add(nil, server.name, RPL_ENDOFMONLIST, nick, client.t("End of MONITOR list"))
 
 	return false
 }
@@ -1865,7 +2173,13 @@ func namesHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 // NICK <nickname>
 func nickHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	if client.registered {
-		performNickChange(server, client, client, msg.Params[0], rb)
+		if wait := client.nickChangeWait(&server.AccountConfig().Probation); wait != 0 {
+			rb.Add(nil, server.name, ERR_UNAVAILRESOURCE, client.Nick(), msg.Params[0], fmt.Sprintf(client.t("New accounts must wait %v before changing nicknames again"), wait.Round(time.Second)))
+			return false
+		}
+		if performNickChange(server, client, client, msg.Params[0], rb) {
+			client.recordNickChange()
+		}
 	} else {
 		client.preregNick = msg.Params[0]
 	}
@@ -1874,16 +2188,21 @@ func nickHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 
 // NOTICE <target>{,<target>} <message>
 func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
-	clientOnlyTags := msg.ClientOnlyTags()
+	clientOnlyTags := client.messageTags(msg.ClientOnlyTags())
 	targets := strings.Split(msg.Params[0], ",")
 	message := msg.Params[1]
 
 	if client.isTor && isRestrictedCTCPMessage(message) {
-		rb.Add(nil, server.name, "NOTICE", client.t("CTCP messages are disabled over Tor"))
+		rb.Notice(client.t("CTCP messages are disabled over Tor"))
+		return false
+	}
+
+	// errors silently ignored with NOTICE as per RFC
+	if !client.apiTokenAllows(apiTokenCapMessage) {
 		return false
 	}
 
-	splitMsg := utils.MakeSplitMessage(message, !client.capabilities.Has(caps.MaxLine))
+	splitMsg := utils.MakeSplitMessage(message)
 
 	for i, targetString := range targets {
 		// max of four targets per privmsg
@@ -1900,11 +2219,22 @@ func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 				// errors silently ignored with NOTICE as per RFC
 				continue
 			}
-			if !channel.CanSpeak(client) {
+			if !channel.CanSpeak(client) || !client.apiTokenAllowsChannel(target) {
 				// errors silently ignored with NOTICE as per RFC
 				continue
 			}
-			channel.SendSplitMessage("NOTICE", lowestPrefix, clientOnlyTags, client, splitMsg, rb)
+			channelMsg, allowed := server.filterCTCP(client, "NOTICE", target, channel, splitMsg, rb)
+			if !allowed {
+				continue
+			}
+			if !server.filterLinks(client, "NOTICE", channel, channelMsg, rb) {
+				continue
+			}
+			channelMsg, allowed = server.filterChannelMessage(client, "NOTICE", channel, channelMsg, rb)
+			if !allowed {
+				continue
+			}
+			channel.SendSplitMessage("NOTICE", lowestPrefix, clientOnlyTags, client, channelMsg, rb)
 		} else {
 			target, err := CasefoldName(targetString)
 			if err != nil {
@@ -1921,25 +2251,51 @@ func noticeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 				// errors silently ignored with NOTICE as per RFC
 				continue
 			}
+			// (don't clobber the tags for the remaining targets)
+			userTags := clientOnlyTags
 			if !user.capabilities.Has(caps.MessageTags) {
-				clientOnlyTags = nil
+				userTags = nil
 			}
-			// restrict messages appropriately when +R is set
+			if !checkCallerID(server, client, user, false, rb) {
+				continue
+			}
+			if !client.allowPMTarget(&server.AccountConfig().Probation, user.NickCasefolded()) {
+				continue
+			}
+			if !server.allowPMLimits(client, user, false, rb) {
+				continue
+			}
+			userMsg, allowed := server.filterCTCP(client, "NOTICE", target, nil, splitMsg, rb)
+			if !allowed {
+				continue
+			}
+			// +R users only accept messages from users who are logged into accounts
+			// (NOTICE must never generate automatic replies, so fail silently)
+			if user.requiresRegisteredSender() && !client.LoggedIntoAccount() {
+				continue
+			}
+			// likewise, NOTICEs from strangers aren't held as message requests
+			if user.requiresDMRequest(client) {
+				continue
+			}
+			// restrict messages appropriately when Tor is involved
 			// intentionally make the sending user think the message went through fine
-			allowedPlusR := !user.HasMode(modes.RegisteredOnly) || client.LoggedIntoAccount()
-			allowedTor := !user.isTor || !isRestrictedCTCPMessage(message)
-			if allowedPlusR && allowedTor {
-				user.SendSplitMsgFromClient(client, clientOnlyTags, "NOTICE", user.nick, splitMsg)
+			allowedTor := !user.isTor || !isRestrictedCTCPMessage(userMsg.Message)
+			if allowedTor {
+				user.SendSplitMsgFromClient(client, userTags, "NOTICE", user.nick, userMsg)
 			}
 			nickMaskString := client.NickMaskString()
 			accountName := client.AccountName()
 			if client.capabilities.Has(caps.EchoMessage) {
-				rb.AddSplitMessageFromClient(nickMaskString, accountName, clientOnlyTags, "NOTICE", user.nick, splitMsg)
+				rb.AddSplitMessageFromClient(nickMaskString, accountName, userTags, "NOTICE", user.nick, userMsg)
 			}
 
+			if !client.storesHistory(clientOnlyTags) {
+				continue
+			}
 			user.history.Add(history.Item{
 				Type:        history.Notice,
-				Message:     splitMsg,
+				Message:     userMsg,
 				Nick:        nickMaskString,
 				AccountName: accountName,
 			})
@@ -1985,7 +2341,7 @@ func npcaHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 	return false
 }
 
-// OPER <name> <password>
+// OPER <name> [password]
 func operHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	if client.HasMode(modes.Operator) == true {
 		rb.Add(nil, server.name, ERR_UNKNOWNERROR, "OPER", client.t("You're already opered-up!"))
@@ -1994,9 +2350,17 @@ func operHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 
 	authorized := false
 	oper := server.GetOperator(msg.Params[0])
-	if oper != nil {
-		password := []byte(msg.Params[1])
-		authorized = (bcrypt.CompareHashAndPassword(oper.Pass, password) == nil)
+	if oper != nil && oper.CanOperFrom(client) {
+		if oper.Pass != nil {
+			if 1 < len(msg.Params) {
+				password := []byte(msg.Params[1])
+				authorized = (bcrypt.CompareHashAndPassword(oper.Pass, password) == nil)
+			}
+		} else {
+			// with no password, the certificate fingerprint is sufficient,
+			// unless the oper must authenticate via CHALLENGE instead
+			authorized = oper.PublicKey == nil
+		}
 	}
 	if !authorized {
 		rb.Add(nil, server.name, ERR_PASSWDMISMATCH, client.nick, client.t("Password incorrect"))
@@ -2004,6 +2368,12 @@ func operHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 		return true
 	}
 
+	operUp(server, client, oper, rb)
+	return false
+}
+
+// operUp gives the client operator privileges, after they've successfully authenticated.
+func operUp(server *Server, client *Client, oper *Oper, rb *ResponseBuffer) {
 	oldNickmask := client.NickMaskString()
 	client.SetOper(oper)
 	if client.NickMaskString() != oldNickmask {
@@ -2024,10 +2394,15 @@ func operHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 
 	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Client opered up $c[grey][$r%s$c[grey], $r%s$c[grey]]"), client.nickMaskString, oper.Name))
 
+	server.autoJoin(client, server.Config().Channels.AutoJoin.Opers, rb)
+
 	// client may now be unthrottled by the fakelag system
 	client.resetFakelag()
 
-	return false
+	// and may be entitled to a larger sendq
+	if oper.Class.MaxSendQBytes > client.connectionClass().MaxSendQBytes {
+		client.socket.SetMaxSendQ(oper.Class.MaxSendQBytes)
+	}
 }
 
 // PART <channel>{,<channel>} [<reason>]
@@ -2092,17 +2467,17 @@ func isRestrictedCTCPMessage(message string) bool {
 
 // PRIVMSG <target>{,<target>} <message>
 func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
-	clientOnlyTags := msg.ClientOnlyTags()
+	clientOnlyTags := client.messageTags(msg.ClientOnlyTags())
 	targets := strings.Split(msg.Params[0], ",")
 	message := msg.Params[1]
 
 	if client.isTor && isRestrictedCTCPMessage(message) {
-		rb.Add(nil, server.name, "NOTICE", client.t("CTCP messages are disabled over Tor"))
+		rb.Notice(client.t("CTCP messages are disabled over Tor"))
 		return false
 	}
 
 	// split privmsg
-	splitMsg := utils.MakeSplitMessage(message, !client.capabilities.Has(caps.MaxLine))
+	splitMsg := utils.MakeSplitMessage(message)
 
 	cnick := client.Nick()
 	for i, targetString := range targets {
@@ -2129,43 +2504,92 @@ func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *R
 				rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
 				continue
 			}
-			channel.SendSplitMessage("PRIVMSG", lowestPrefix, clientOnlyTags, client, splitMsg, rb)
+			if !(client.apiTokenAllows(apiTokenCapMessage) && client.apiTokenAllowsChannel(target)) {
+				rb.Add(nil, server.name, "FAIL", "PRIVMSG", "TOKEN_RESTRICTED", targetString, client.t(errAPITokenRestricted.Error()))
+				continue
+			}
+			channelMsg, allowed := server.filterCTCP(client, "PRIVMSG", target, channel, splitMsg, rb)
+			if !allowed {
+				continue
+			}
+			if !server.filterLinks(client, "PRIVMSG", channel, channelMsg, rb) {
+				continue
+			}
+			channelMsg, allowed = server.filterChannelMessage(client, "PRIVMSG", channel, channelMsg, rb)
+			if !allowed {
+				continue
+			}
+			channel.SendSplitMessage("PRIVMSG", lowestPrefix, clientOnlyTags, client, channelMsg, rb)
 		} else {
 			target, err = CasefoldName(targetString)
 			if service, isService := OragonoServices[target]; isService {
 				servicePrivmsgHandler(service, server, client, message, rb)
 				continue
 			}
+			if !client.apiTokenAllows(apiTokenCapMessage) {
+				rb.Add(nil, server.name, "FAIL", "PRIVMSG", "TOKEN_RESTRICTED", targetString, client.t(errAPITokenRestricted.Error()))
+				continue
+			}
 			user := server.clients.Get(target)
 			if err != nil || user == nil {
 				if len(target) > 0 {
-					client.Send(nil, server.name, ERR_NOSUCHNICK, cnick, target, "No such nick")
+					client.Send(nil, server.name, ERR_NOSUCHNICK, cnick, target, client.t("No such nick"))
 				}
 				continue
 			}
+			// (don't clobber the tags for the remaining targets)
+			userTags := clientOnlyTags
 			if !user.capabilities.Has(caps.MessageTags) {
-				clientOnlyTags = nil
+				userTags = nil
+			}
+			// caller-ID (+g) blocks the message outright, and tells the sender so
+			if !checkCallerID(server, client, user, true, rb) {
+				continue
+			}
+			if !client.allowPMTarget(&server.AccountConfig().Probation, user.NickCasefolded()) {
+				rb.Add(nil, server.name, ERR_TOOMANYTARGETS, cnick, user.Nick(), client.t("New accounts can't message so many users at once; try again later"))
+				continue
 			}
-			// restrict messages appropriately when +R is set
+			if !server.allowPMLimits(client, user, true, rb) {
+				continue
+			}
+			userMsg, allowed := server.filterCTCP(client, "PRIVMSG", target, nil, splitMsg, rb)
+			if !allowed {
+				continue
+			}
+			// +R users only accept messages from users who are logged into accounts
+			if user.requiresRegisteredSender() && !client.LoggedIntoAccount() {
+				rb.Add(nil, server.name, ERR_NEEDREGGEDNICK, cnick, user.Nick(), client.t("You must be logged into an account to message this user"))
+				nsLoginHint(client, rb)
+				continue
+			}
+			// strangers' messages are held until the user accepts them
+			if user.requiresDMRequest(client) {
+				server.holdDirectMessage(client, user, heldMessageFor(client, userMsg, client.storesHistory(clientOnlyTags)), rb)
+				continue
+			}
+			// restrict messages appropriately when Tor is involved
 			// intentionally make the sending user think the message went through fine
-			allowedPlusR := !user.HasMode(modes.RegisteredOnly) || client.LoggedIntoAccount()
-			allowedTor := !user.isTor || !isRestrictedCTCPMessage(message)
-			if allowedPlusR && allowedTor {
-				user.SendSplitMsgFromClient(client, clientOnlyTags, "PRIVMSG", user.nick, splitMsg)
+			allowedTor := !user.isTor || !isRestrictedCTCPMessage(userMsg.Message)
+			if allowedTor {
+				user.SendSplitMsgFromClient(client, userTags, "PRIVMSG", user.nick, userMsg)
 			}
 			nickMaskString := client.NickMaskString()
 			accountName := client.AccountName()
 			if client.capabilities.Has(caps.EchoMessage) {
-				rb.AddSplitMessageFromClient(nickMaskString, accountName, clientOnlyTags, "PRIVMSG", user.nick, splitMsg)
+				rb.AddSplitMessageFromClient(nickMaskString, accountName, userTags, "PRIVMSG", user.nick, userMsg)
 			}
 			if user.HasMode(modes.Away) {
 				//TODO(dan): possibly implement cooldown of away notifications to users
 				rb.Add(nil, server.name, RPL_AWAY, cnick, user.Nick(), user.AwayMessage())
 			}
 
+			if !client.storesHistory(clientOnlyTags) {
+				continue
+			}
 			user.history.Add(history.Item{
 				Type:        history.Privmsg,
-				Message:     splitMsg,
+				Message:     userMsg,
 				Nick:        nickMaskString,
 				AccountName: accountName,
 			})
@@ -2178,12 +2602,134 @@ func privmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *R
 func quitHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	reason := "Quit"
 	if len(msg.Params) > 0 {
-		reason += ": " + msg.Params[0]
+		if message := server.filterQuitMessage(client, msg.Params[0]); message != "" {
+			reason += ": " + message
+		}
 	}
 	client.Quit(reason)
 	return true
 }
 
+// REDACT <target> <msgid> [<reason>]
+func redactHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	target, msgid := msg.Params[0], msg.Params[1]
+	var reason string
+	if len(msg.Params) > 2 {
+		reason = msg.Params[2]
+	}
+
+	if channel := server.channels.Get(target); channel != nil {
+		server.redactChannelMessage(client, channel, msgid, reason, rb)
+	} else if user := server.clients.Get(target); user != nil {
+		server.redactDirectMessage(client, user, msgid, reason, rb)
+	} else {
+		rb.Add(nil, server.name, "FAIL", "REDACT", "INVALID_TARGET", target, client.t("No such channel or nick"))
+	}
+	return false
+}
+
+// REGISTER <account> <email | *> <password>
+func registerHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	config := server.AccountConfig()
+	accountName := msg.Params[0]
+
+	if !config.Registration.Enabled {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "TEMPORARILY_UNAVAILABLE", accountName, client.t("Account registration is disabled"))
+		return false
+	}
+	if !client.registered && !config.Registration.AllowBeforeConnect {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "COMPLETE_CONNECTION_REQUIRED", accountName, client.t("You must complete the connection before registering your account"))
+		return false
+	}
+	if client.LoggedIntoAccount() {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "ALREADY_AUTHENTICATED", accountName, client.t("You're already logged into an account"))
+		return false
+	}
+
+	// "*" means the client's current nickname
+	if accountName == "*" {
+		accountName = client.Nick()
+		if accountName == "*" {
+			rb.Add(nil, server.name, "FAIL", "REGISTER", "NEED_NICK", "*", client.t("You must set a nickname before registering it as an account"))
+			return false
+		}
+	}
+	casefoldedAccount, err := CasefoldName(accountName)
+	if err != nil {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "BAD_ACCOUNT_NAME", accountName, client.t("Account name is not valid"))
+		return false
+	}
+
+	callbackNamespace, callbackValue := parseCallback(msg.Params[1], config)
+	if callbackNamespace == "" {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_EMAIL", accountName, client.t("A valid email address is required"))
+		return false
+	}
+
+	passphrase := msg.Params[2]
+	if validatePassphrase(passphrase) != nil {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "WEAK_PASSWORD", accountName, client.t(errAccountBadPassphrase.Error()))
+		return false
+	}
+
+	throttled, remainingTime := client.loginThrottle.Touch()
+	if throttled {
+		rb.Add(nil, server.name, "FAIL", "REGISTER", "TEMPORARILY_UNAVAILABLE", accountName, fmt.Sprintf(client.t("Please wait at least %v and try again"), remainingTime))
+		return false
+	}
+
+	err = server.accounts.Register(client, accountName, callbackNamespace, callbackValue, passphrase, "")
+	if err != nil {
+		message, _ := registrationErrorToMessageAndCode(err)
+		rb.Add(nil, server.name, "FAIL", "REGISTER", registrationErrorToFailCode(err), accountName, client.t(message))
+		return false
+	}
+
+	// automatically complete registration
+	if callbackNamespace == "*" {
+		err = server.accounts.Verify(client, casefoldedAccount, "")
+		if err != nil {
+			rb.Add(nil, server.name, "FAIL", "REGISTER", "UNKNOWN_ERROR", accountName, client.t("Could not register"))
+			return false
+		}
+		rb.Add(nil, server.name, "REGISTER", "SUCCESS", accountName, client.t("Account successfully registered"))
+		sendAccountRegLoggedIn(client, rb)
+	} else {
+		messageTemplate := client.t("Account created, pending verification; verification code has been sent to %s")
+		message := fmt.Sprintf(messageTemplate, callbackValue)
+		rb.Add(nil, server.name, "REGISTER", "VERIFICATION_REQUIRED", accountName, message)
+	}
+
+	return false
+}
+
+// registrationErrorToFailCode returns the draft/account-registration FAIL code
+// corresponding to an error from AccountManager.Register.
+func registrationErrorToFailCode(err error) string {
+	switch err {
+	case errAccountAlreadyRegistered, errAccountAlreadyVerified:
+		return "ACCOUNT_EXISTS"
+	case errAccountCreation, errAccountMustHoldNick:
+		return "BAD_ACCOUNT_NAME"
+	case errAccountBadPassphrase:
+		return "WEAK_PASSWORD"
+	case errCallbackFailed:
+		return "UNACCEPTABLE_EMAIL"
+	case errFeatureDisabled, errTemporarilyDisabled:
+		return "TEMPORARILY_UNAVAILABLE"
+	default:
+		return "UNKNOWN_ERROR"
+	}
+}
+
+// sendAccountRegLoggedIn tells a client that completed draft/account-registration
+// that it's now logged into its new account.
+func sendAccountRegLoggedIn(client *Client, rb *ResponseBuffer) {
+	details := client.Details()
+	rb.Add(nil, client.server.name, RPL_LOGGEDIN, details.nick, details.nickMask, details.accountName, fmt.Sprintf(client.t("You are now logged in as %s"), details.accountName))
+	announceAccountLogin(client)
+}
+
 // REHASH
 func rehashHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	server.logger.Info("server", fmt.Sprintf("REHASH command used by %s", client.nick))
@@ -2198,6 +2744,49 @@ func rehashHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 	return false
 }
 
+// RELAYMSG <channel> <spoofed nick> :<message>
+func relaymsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	config := server.Config().Server.Relaymsg
+	if !config.Enabled {
+		rb.Add(nil, server.name, ERR_UNKNOWNCOMMAND, client.Nick(), "RELAYMSG", client.t("Unknown command"))
+		return false
+	}
+
+	channel := server.channels.Get(msg.Params[0])
+	if channel == nil {
+		rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), msg.Params[0], client.t("No such channel"))
+		return false
+	}
+	if !config.canRelay(client, channel) {
+		rb.Add(nil, server.name, ERR_CHANOPRIVSNEEDED, client.Nick(), channel.Name(), client.t("You're not allowed to relay messages to this channel"))
+		return false
+	}
+	if !(client.apiTokenAllows(apiTokenCapMessage) && client.apiTokenAllowsChannel(channel.NameCasefolded())) {
+		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "TOKEN_RESTRICTED", channel.Name(), client.t(errAPITokenRestricted.Error()))
+		return false
+	}
+
+	nick := msg.Params[1]
+	if err := config.validateRelayNick(nick, 2*server.Limits().NickLen); err != nil {
+		rb.Add(nil, server.name, ERR_ERRONEUSNICKNAME, client.Nick(), nick, client.t(err.Error()))
+		return false
+	}
+	// a real client may have taken the nick before relaying was enabled
+	if cfnick, err := CasefoldName(nick); err == nil && server.clients.Get(cfnick) != nil {
+		rb.Add(nil, server.name, ERR_NICKNAMEINUSE, client.Nick(), nick, client.t("Nickname is already in use"))
+		return false
+	}
+
+	message := msg.Params[2]
+	if message == "" {
+		rb.Add(nil, server.name, ERR_NOTEXTTOSEND, client.Nick(), client.t("No text to send"))
+		return false
+	}
+	splitMsg := utils.MakeSplitMessage(message)
+	channel.SendRelayMessage(client, nick, splitMsg, rb)
+	return false
+}
+
 // RENAME <oldchan> <newchan> [<reason>]
 func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) (result bool) {
 	result = false
@@ -2216,7 +2805,7 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 		return false
 	}
 	casefoldedOldName := channel.NameCasefolded()
-	if !(channel.ClientIsAtLeast(client, modes.Operator) || client.HasRoleCapabs("chanreg")) {
+	if !(channel.ClientIsAtLeast(client, modes.ChannelOperator) || client.HasRoleCapabs("chanreg")) {
 		rb.Add(nil, server.name, ERR_CHANOPRIVSNEEDED, client.Nick(), oldName, client.t("You're not a channel operator"))
 		return false
 	}
@@ -2227,11 +2816,23 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 		return false
 	}
 
+	// a registered channel that isn't currently loaded still owns its name and its skeleton
+	if cfnewname, err := CasefoldChannel(newName); err == nil && cfnewname != casefoldedOldName && server.channelRegistry.LoadChannel(cfnewname) != nil {
+		rb.Add(nil, server.name, ERR_CHANNAMEINUSE, client.Nick(), newName, client.t(errChannelNameInUse.Error()))
+		return false
+	}
+	if skeleton, err := ChannelSkeleton(newName); err == nil {
+		if holder := server.channelRegistry.SkeletonHolder(skeleton); holder != "" && holder != casefoldedOldName {
+			rb.Add(nil, server.name, ERR_CHANNAMEINUSE, client.Nick(), newName, client.t(errConfusableChannelName.Error()))
+			return false
+		}
+	}
+
 	// perform the channel rename
 	err := server.channels.Rename(oldName, newName)
 	if err == errInvalidChannelName {
 		rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), newName, client.t(err.Error()))
-	} else if err == errChannelNameInUse {
+	} else if err == errChannelNameInUse || err == errConfusableChannelName {
 		rb.Add(nil, server.name, ERR_CHANNAMEINUSE, client.Nick(), newName, client.t(err.Error()))
 	} else if err != nil {
 		rb.Add(nil, server.name, ERR_CANNOTRENAME, client.Nick(), oldName, newName, client.t("Cannot rename channel"))
@@ -2280,6 +2881,16 @@ func renameHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 	return false
 }
 
+// REPORT <target> <msgid> [<reason>]
+func reportHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	var reason string
+	if len(msg.Params) > 2 {
+		reason = msg.Params[2]
+	}
+	server.reportMessage(client, msg.Params[0], msg.Params[1], reason, rb)
+	return false
+}
+
 // RESUME <token> [timestamp]
 func resumeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	token := msg.Params[0]
@@ -2307,6 +2918,20 @@ func resumeHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 	return false
 }
 
+// RULES [<channel>]
+func rulesHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	var channel *Channel
+	if 0 < len(msg.Params) {
+		channel = server.channels.Get(msg.Params[0])
+		if channel == nil || (channel.flags.HasMode(modes.Secret) && !channel.hasClient(client)) {
+			rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.nick, msg.Params[0], client.t("No such channel"))
+			return false
+		}
+	}
+	server.Rules(client, channel, rb)
+	return false
+}
+
 // SANICK <oldnick> <nickname>
 func sanickHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	targetNick := strings.TrimSpace(msg.Params[0])
@@ -2346,6 +2971,83 @@ func setnameHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *R
 	return false
 }
 
+var (
+	// oper capabilities needed for each STATS query ("" means anyone can use it)
+	statsCapabs = map[string]string{
+		"k": "oper:local_ban",
+		"l": "stats",
+		"m": "stats",
+		"o": "stats",
+		"u": "",
+	}
+)
+
+// STATS <query> [<nickname>]
+func statsHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	query := msg.Params[0]
+	nick := client.Nick()
+	capab, known := statsCapabs[query]
+	if known && capab != "" && !client.HasRoleCapabs(capab) {
+		rb.Add(nil, server.name, ERR_NOPRIVILEGES, nick, client.t("Permission Denied"))
+		return false
+	}
+	server.snomasks.Send(sno.Stats, fmt.Sprintf(ircfmt.Unescape("%s$r requested STATS %s"), client.NickMaskString(), query))
+
+	switch query {
+	case "k":
+		for mask, info := range server.klines.AllBans() {
+			rb.Add(nil, server.name, RPL_STATSKLINE, nick, "K", mask, "*", "*", info.BanMessage("%s"))
+		}
+		for network, info := range server.dlines.AllBans() {
+			rb.Add(nil, server.name, RPL_STATSDLINE, nick, "D", network, info.BanMessage("%s"))
+		}
+	case "l":
+		var targets []*Client
+		if 1 < len(msg.Params) {
+			target := server.clients.Get(msg.Params[1])
+			if target == nil {
+				rb.Add(nil, server.name, ERR_NOSUCHNICK, nick, msg.Params[1], client.t("No such nick"))
+				return false
+			}
+			targets = []*Client{target}
+		} else {
+			targets = server.clients.AllClients()
+		}
+		now := time.Now().Unix()
+		for _, target := range targets {
+			stats := target.socket.Stats()
+			sendQ, _ := target.socket.SendQ()
+			rb.Add(nil, server.name, RPL_STATSLINKINFO, nick, target.NickMaskString(), strconv.Itoa(sendQ),
+				strconv.FormatUint(stats.LinesWritten, 10), strconv.FormatUint(stats.BytesWritten/1024, 10),
+				strconv.FormatUint(stats.LinesRead, 10), strconv.FormatUint(stats.BytesRead/1024, 10),
+				strconv.FormatInt(now-target.SignonTime(), 10))
+		}
+	case "m":
+		counts := server.commandStats.Counts()
+		commands := make([]string, 0, len(counts))
+		for command := range counts {
+			commands = append(commands, command)
+		}
+		sort.Strings(commands)
+		for _, command := range commands {
+			rb.Add(nil, server.name, RPL_STATSCOMMANDS, nick, command, strconv.FormatUint(counts[command], 10), "0", "0")
+		}
+	case "o":
+		for name, oper := range server.Config().operators {
+			rb.Add(nil, server.name, RPL_STATSOLINE, nick, "O", "*", "*", name, "0", oper.Class.Title)
+		}
+	case "u":
+		uptime := time.Since(server.ctime)
+		days := int(uptime / (24 * time.Hour))
+		uptime -= time.Duration(days) * 24 * time.Hour
+		rb.Add(nil, server.name, RPL_STATSUPTIME, nick, fmt.Sprintf(client.t("Server Up %d days %d:%02d:%02d"),
+			days, int(uptime.Hours()), int(uptime.Minutes())%60, int(uptime.Seconds())%60))
+	}
+
+	rb.Add(nil, server.name, RPL_ENDOFSTATS, nick, query, client.t("End of STATS report"))
+	return false
+}
+
 // TAGMSG <target>{,<target>}
 func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	clientOnlyTags := msg.ClientOnlyTags()
@@ -2353,11 +3055,16 @@ func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 	if clientOnlyTags == nil {
 		return false
 	}
+	clientOnlyTags = client.messageTags(clientOnlyTags)
+	if !client.apiTokenAllows(apiTokenCapMessage) {
+		rb.Add(nil, server.name, "FAIL", "TAGMSG", "TOKEN_RESTRICTED", client.t(errAPITokenRestricted.Error()))
+		return false
+	}
 
 	targets := strings.Split(msg.Params[0], ",")
 
 	cnick := client.Nick()
-	message := utils.MakeSplitMessage("", true) // assign consistent message ID
+	message := utils.MakeSplitMessage("") // assign consistent message ID
 	for i, targetString := range targets {
 		// max of four targets per privmsg
 		if i > maxTargets-1 {
@@ -2378,7 +3085,7 @@ func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 				rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, cnick, targetString, client.t("No such channel"))
 				continue
 			}
-			if !channel.CanSpeak(client) {
+			if !channel.CanSpeak(client) || !client.apiTokenAllowsChannel(target) {
 				rb.Add(nil, client.server.name, ERR_CANNOTSENDTOCHAN, channel.name, client.t("Cannot send to channel"))
 				continue
 			}
@@ -2397,6 +3104,15 @@ func tagmsgHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 			if !user.capabilities.Has(caps.MessageTags) {
 				continue
 			}
+			if !checkCallerID(server, client, user, false, rb) {
+				continue
+			}
+			if user.requiresRegisteredSender() && !client.LoggedIntoAccount() {
+				continue
+			}
+			if user.requiresDMRequest(client) {
+				continue
+			}
 			unick := user.Nick()
 			user.SendSplitMsgFromClient(client, clientOnlyTags, "TAGMSG", unick, message)
 			if client.capabilities.Has(caps.EchoMessage) {
@@ -2479,13 +3195,7 @@ func unKLineHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *R
 	}
 
 	// get host
-	mask := msg.Params[0]
-
-	if !strings.Contains(mask, "!") && !strings.Contains(mask, "@") {
-		mask = mask + "!*@*"
-	} else if !strings.Contains(mask, "@") {
-		mask = mask + "@*"
-	}
+	mask := canonicalizeKlineMask(msg.Params[0])
 
 	err := server.klines.RemoveMask(mask)
 
@@ -2506,12 +3216,12 @@ func userHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Resp
 		return false
 	}
 
-	err := client.SetNames(msg.Params[0], msg.Params[3], false)
+	err := client.SetNames(msg.Params[0], msg.Params[3])
 	if err == errInvalidUsername {
 		// if client's using a unicode nick or something weird, let's just set 'em up with a stock username instead.
 		// fixes clients that just use their nick as a username so they can still use the interesting nick
 		if client.preregNick == msg.Params[0] {
-			client.SetNames("user", msg.Params[3], false)
+			client.SetNames("user", msg.Params[3])
 		} else {
 			rb.Add(nil, server.name, ERR_INVALIDUSERNAME, client.t("Malformed username"))
 		}
@@ -2558,6 +3268,35 @@ func userhostHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *
 	return false
 }
 
+// VERIFY <account> <code>
+func verifyHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	config := server.AccountConfig()
+	accountName := msg.Params[0]
+
+	if !client.registered && !config.Registration.AllowBeforeConnect {
+		rb.Add(nil, server.name, "FAIL", "VERIFY", "COMPLETE_CONNECTION_REQUIRED", accountName, client.t("You must complete the connection before verifying your account"))
+		return false
+	}
+	if client.LoggedIntoAccount() {
+		rb.Add(nil, server.name, "FAIL", "VERIFY", "ALREADY_AUTHENTICATED", accountName, client.t("You're already logged into an account"))
+		return false
+	}
+
+	err := server.accounts.Verify(client, accountName, msg.Params[1])
+	switch err {
+	case nil:
+		rb.Add(nil, server.name, "VERIFY", "SUCCESS", accountName, client.t("Account successfully registered"))
+		sendAccountRegLoggedIn(client, rb)
+	case errAccountVerificationInvalidCode:
+		rb.Add(nil, server.name, "FAIL", "VERIFY", "INVALID_CODE", accountName, client.t(err.Error()))
+	case errAccountAlreadyVerified:
+		rb.Add(nil, server.name, "FAIL", "VERIFY", "ALREADY_REGISTERED", accountName, client.t(err.Error()))
+	default:
+		rb.Add(nil, server.name, "FAIL", "VERIFY", "UNKNOWN_ERROR", accountName, client.t(errAccountVerificationFailed.Error()))
+	}
+	return false
+}
+
 // VERSION
 func versionHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	rb.Add(nil, server.name, RPL_VERSION, client.nick, Ver, server.name)
@@ -2639,23 +3378,22 @@ func whoHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Respo
 
 	friends := client.Friends()
 
-	//TODO(dan): is this used and would I put this param in the Modern doc?
-	// if not, can we remove it?
-	//var operatorOnly bool
-	//if len(msg.Params) > 1 && msg.Params[1] == "o" {
-	//	operatorOnly = true
-	//}
+	// WHO <mask> %<fields>[,<token>] is a WHOX query
+	var whox *whoxQuery
+	if len(msg.Params) > 1 {
+		whox = parseWhoxQuery(msg.Params[1])
+	}
 
 	if mask[0] == '#' {
 		// TODO implement wildcard matching
 		//TODO(dan): ^ only for opers
 		channel := server.channels.Get(mask)
 		if channel != nil {
-			whoChannel(client, channel, friends, rb)
+			whoChannel(client, channel, friends, whox, rb)
 		}
 	} else {
 		for mclient := range server.clients.FindAll(mask) {
-			client.rplWhoReply(nil, mclient, rb)
+			client.rplWhoReply(nil, mclient, whox, rb)
 		}
 	}
 
@@ -2722,6 +3460,7 @@ func whoisHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Res
 }
 
 // WHOWAS <nickname> [<count> [<server>]]
+// WHOWAS $a:<account> [<count>]
 func whowasHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
 	nicknames := strings.Split(msg.Params[0], ",")
 
@@ -2735,8 +3474,15 @@ func whowasHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 	//	target = msg.Params[2]
 	//}
 	cnick := client.Nick()
+	isOper := client.HasMode(modes.Operator)
 	for _, nickname := range nicknames {
-		results := server.whoWas.Find(nickname, int(count))
+		var results []WhoWas
+		// opers can search by account, to trace users who change nicknames
+		if isOper && strings.HasPrefix(nickname, "$a:") {
+			results = server.whoWas.FindByAccount(strings.TrimPrefix(nickname, "$a:"), int(count))
+		} else {
+			results = server.whoWas.Find(nickname, int(count))
+		}
 		if len(results) == 0 {
 			if len(nickname) > 0 {
 				rb.Add(nil, server.name, ERR_WASNOSUCHNICK, cnick, nickname, client.t("There was no such nickname"))
@@ -2744,6 +3490,17 @@ func whowasHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *Re
 		} else {
 			for _, whoWas := range results {
 				rb.Add(nil, server.name, RPL_WHOWASUSER, cnick, whoWas.nick, whoWas.username, whoWas.hostname, "*", whoWas.realname)
+				if whoWas.accountName != "*" && whoWas.accountName != "" {
+					rb.Add(nil, server.name, RPL_WHOISACCOUNT, cnick, whoWas.nick, whoWas.accountName, client.t("was logged in as"))
+				}
+				signoff := whoWas.time.Format(time.RFC1123)
+				if isOper {
+					rb.Add(nil, server.name, RPL_WHOISACTUALLY, cnick, whoWas.nick, utils.IPStringToHostname(whoWas.ip.String()), client.t("Actual IP"))
+					if whoWas.reason != "" {
+						signoff = fmt.Sprintf("%s (%s)", signoff, whoWas.reason)
+					}
+				}
+				rb.Add(nil, server.name, RPL_WHOISSERVER, cnick, whoWas.nick, server.name, signoff)
 			}
 		}
 		if len(nickname) > 0 {
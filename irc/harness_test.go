@@ -0,0 +1,326 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// This is an in-process test harness: it runs a server without listeners and
+// connects simulated clients to it over in-memory pipes, so that tests can
+// drive it at scale (see TestSoak and BenchmarkClientRegistration) and, with
+// a utils.FakeClock, control its timers.
+
+var soakClients = flag.Int("soak-clients", 1000, "number of simulated clients for TestSoak")
+
+// how long a simulated client waits for an expected line
+const harnessTimeout = 10 * time.Second
+
+type testHarness struct {
+	tb     testing.TB
+	server *Server
+	dir    string
+
+	sync.Mutex
+	clientCount int
+}
+
+// newTestHarness starts a server from the example config, with its datastore
+// in a temporary directory. If `clock` is non-nil, it drives the client timers.
+func newTestHarness(tb testing.TB, clock utils.Clock, configure func(*Config)) *testHarness {
+	dir, err := ioutil.TempDir("", "oragono-harness")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	config, err := LoadConfig("../oragono.yaml")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	config.Server.Listen = nil
+	config.Server.TLSListeners = nil
+	config.Server.TorListeners.Listeners = nil
+	config.Server.CompressedListeners.Listeners = nil
+	config.Server.ReverseDNS.Enabled = false
+	config.Server.ConnectionLimiter.Enabled = false
+	config.Server.ConnectionThrottler.Enabled = false
+	config.Datastore.Path = filepath.Join(dir, "ircd.db")
+	config.Logging = nil
+	if configure != nil {
+		configure(config)
+	}
+
+	logman, err := logger.NewManager(config.Logging)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server, err := NewServer(config, logman)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server.clock = clock
+	return &testHarness{tb: tb, server: server, dir: dir}
+}
+
+// Close shuts down the server and deletes its datastore.
+func (h *testHarness) Close() {
+	h.server.Shutdown()
+	os.RemoveAll(h.dir)
+}
+
+// testConn is one end of a pipe, with a distinct address for each client.
+type testConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (conn testConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+// testClient is a simulated client. Everything the server sends it is
+// recorded, so that it never blocks the server, and can be waited for with
+// Expect.
+type testClient struct {
+	tb   testing.TB
+	conn net.Conn
+	nick string
+
+	sync.Mutex
+	lines  []ircmsg.IrcMessage
+	read   int // lines before this have been consumed by Expect
+	closed bool
+	notify chan struct{}
+}
+
+// Connect connects a new simulated client, without registering it.
+func (h *testHarness) Connect() *testClient {
+	h.Lock()
+	h.clientCount++
+	n := h.clientCount
+	h.Unlock()
+
+	serverEnd, clientEnd := net.Pipe()
+	ip := net.IPv4(10, byte(n>>16), byte(n>>8), byte(n))
+	go RunNewClient(h.server, clientConn{Conn: testConn{Conn: serverEnd, addr: &net.TCPAddr{IP: ip, Port: 6667}}})
+
+	client := &testClient{
+		tb:     h.tb,
+		conn:   clientEnd,
+		notify: make(chan struct{}, 1),
+	}
+	go client.readLoop()
+	return client
+}
+
+// Register connects a new simulated client and registers it with the nickname.
+func (h *testHarness) Register(nick string) *testClient {
+	client := h.Connect()
+	client.nick = nick
+	client.Send("NICK", nick)
+	client.Send("USER", "u", "0", "*", "simulated client")
+	client.Expect(RPL_WELCOME)
+	client.Sync()
+	return client
+}
+
+func (client *testClient) readLoop() {
+	reader := bufio.NewReader(client.conn)
+	for {
+		line, err := reader.ReadString('\n')
+		client.Lock()
+		if err != nil {
+			client.closed = true
+		} else if msg, err := ircmsg.ParseLine(line); err == nil {
+			client.lines = append(client.lines, msg)
+		}
+		closed := client.closed
+		client.Unlock()
+		select {
+		case client.notify <- struct{}{}:
+		default:
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// Send sends a line to the server. Like Expect, it reports errors with
+// Errorf rather than Fatalf, so it can be used from any goroutine.
+func (client *testClient) Send(command string, params ...string) {
+	client.SendWithTags(nil, command, params...)
+}
+
+// SendWithTags is like Send, but sends the line with message tags.
+func (client *testClient) SendWithTags(tags map[string]string, command string, params ...string) {
+	msg := ircmsg.MakeMessage(tags, "", command, params...)
+	line, err := msg.Line()
+	if err == nil {
+		_, err = client.conn.Write([]byte(line))
+	}
+	if err != nil {
+		client.tb.Errorf("%s couldn't send %s: %v", client.nick, command, err)
+	}
+}
+
+// Sync waits until the server has finished processing the lines sent so far,
+// including the bookkeeping after each command (e.g., resetting the idle timer)
+// that happens after its replies are sent. Writes to the simulated connection
+// block until the server reads them, and the server only reads the next line
+// once it's done with the previous one; it ignores the empty line sent here.
+func (client *testClient) Sync() {
+	if _, err := client.conn.Write([]byte("\r\n")); err != nil {
+		client.tb.Errorf("%s couldn't sync: %v", client.nick, err)
+	}
+}
+
+// Expect waits for a line with the command (or numeric), consuming it and any
+// lines received before it.
+func (client *testClient) Expect(command string) ircmsg.IrcMessage {
+	msg, ok := client.ExpectWithin(command, harnessTimeout)
+	if !ok {
+		client.tb.Errorf("%s didn't receive %s", client.nick, command)
+	}
+	return msg
+}
+
+// ExpectWithin is like Expect, but returns whether the line was received
+// instead of failing the test.
+func (client *testClient) ExpectWithin(command string, timeout time.Duration) (msg ircmsg.IrcMessage, ok bool) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		client.Lock()
+		for client.read < len(client.lines) {
+			msg = client.lines[client.read]
+			client.read++
+			if msg.Command == command {
+				client.Unlock()
+				return msg, true
+			}
+		}
+		closed := client.closed
+		client.Unlock()
+		if closed {
+			return msg, false
+		}
+
+		select {
+		case <-client.notify:
+		case <-deadline.C:
+			return msg, false
+		}
+	}
+}
+
+// Close disconnects the client.
+func (client *testClient) Close() {
+	client.conn.Close()
+}
+
+func TestHarness(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	alice.Send("JOIN", "#test")
+	alice.Expect(RPL_ENDOFNAMES)
+	bob.Send("JOIN", "#test")
+	bob.Expect(RPL_ENDOFNAMES)
+	alice.Send("PRIVMSG", "#test", "hi bob")
+	if msg := bob.Expect("PRIVMSG"); len(msg.Params) != 2 || msg.Params[1] != "hi bob" || !strings.HasPrefix(msg.Prefix, "alice!") {
+		t.Errorf("unexpected message: %v", msg)
+	}
+
+	bob.Send("QUIT", "bye")
+	if msg := alice.Expect("QUIT"); !strings.HasPrefix(msg.Prefix, "bob!") {
+		t.Errorf("unexpected quit: %v", msg)
+	}
+}
+
+// TestSoak connects many clients at once (set the number with -soak-clients),
+// has them talk in shared channels, then disconnects them all, and checks that
+// the server is left with no clients or channels.
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping soak test in short mode")
+	}
+	h := newTestHarness(t, nil, func(config *Config) {
+		// every channel fills up at once, which would look like a join flood
+		config.Channels.JoinFlood.Enabled = false
+	})
+	defer h.Close()
+
+	const clientsPerChannel = 50
+	clients := make([]*testClient, *soakClients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client := h.Register(fmt.Sprintf("soak%d", i))
+			client.Send("JOIN", fmt.Sprintf("#soak%d", i/clientsPerChannel))
+			client.Expect(RPL_ENDOFNAMES)
+			clients[i] = client
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if count := h.server.clients.Count(); count != len(clients) {
+		t.Errorf("expected %d clients, got %d", len(clients), count)
+	}
+
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *testClient) {
+			defer wg.Done()
+			client.Send("PRIVMSG", fmt.Sprintf("#soak%d", i/clientsPerChannel), "hello")
+			client.Send("PING", "sync")
+			client.Expect("PONG")
+			client.Send("QUIT")
+			client.Expect("ERROR")
+		}(i, client)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(harnessTimeout)
+	for (h.server.clients.Count() != 0 || len(h.server.channels.Channels()) != 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := h.server.clients.Count(); count != 0 {
+		t.Errorf("%d clients were not cleaned up", count)
+	}
+	if count := len(h.server.channels.Channels()); count != 0 {
+		t.Errorf("%d channels were not cleaned up", count)
+	}
+}
+
+func BenchmarkClientRegistration(b *testing.B) {
+	h := newTestHarness(b, nil, nil)
+	defer h.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := h.Register(fmt.Sprintf("bench%d", i))
+		client.Send("QUIT")
+		client.Expect("ERROR")
+	}
+}
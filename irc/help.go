@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"sort"
 	"strings"
-	"sync"
 
 	"github.com/oragono/oragono/irc/languages"
+	"github.com/oragono/oragono/irc/lockorder"
 )
 
 // HelpEntryType represents the different sorts of help entries that can exist.
@@ -45,12 +45,14 @@ Oragono supports the following channel modes:
   +i  |  Invite-only mode, only invited clients can join the channel.
   +k  |  Key required when joining the channel.
   +l  |  Client join limit for the channel.
+  +K  |  No-knock mode, /KNOCK can't be used to ask for an invite.
   +m  |  Moderated mode, only privileged clients can talk on the channel.
   +n  |  No-outside-messages mode, only users that are on the channel can send
       |  messages to it.
-  +R  |  Only registered users can talk in the channel.
+  +R  |  Only users logged into accounts can join or talk in the channel.
   +s  |  Secret mode, channel won't show up in /LIST or whois replies.
   +t  |  Only channel opers can modify the topic.
+  +z  |  Secure-only mode, only clients connected via TLS can join the channel.
 
 = Prefixes =
 
@@ -58,15 +60,21 @@ Oragono supports the following channel modes:
   +a (&)  |  Admin channel mode.
   +o (@)  |  Operator channel mode.
   +h (%)  |  Halfop channel mode.
-  +v (+)  |  Voice channel mode.`
+  +v (+)  |  Voice channel mode.
+
+The server may disable the founder, admin, and halfop modes; the ones that are
+enabled are listed in RPL_ISUPPORT PREFIX.`
 	umodeHelpText = `== User Modes ==
 
 Oragono supports the following user modes:
 
   +a  |  User is marked as being away. This mode is set with the /AWAY command.
+  +B  |  User is marked as a bot. Their messages carry the draft/bot tag.
+  +g  |  Caller-ID: user only accepts private messages from users on their
+      |  accept list (see /HELP accept).
   +i  |  User is marked as invisible (their channels are hidden from whois replies).
   +o  |  User is an IRC operator.
-  +R  |  User only accepts messages from other registered users. 
+  +R  |  User only accepts messages from users logged into accounts.
   +s  |  Server Notice Masks (see help with /HELPOP snomasks).
   +Z  |  User is connected via TLS.`
 	snomaskHelpText = `== Server Notice Masks ==
@@ -75,6 +83,7 @@ Oragono supports the following server notice masks for operators:
 
   a  |  Local announcements.
   c  |  Local client connections.
+  f  |  Local flood detection.
   j  |  Local channel actions.
   k  |  Local kills.
   n  |  Local nick changes.
@@ -102,11 +111,34 @@ ACC VERIFY <accountname> <auth_code>
 
 Used in account registration. See the relevant specs for more info:
 https://oragono.io/specs.html`,
+	},
+	"accept": {
+		text: `ACCEPT <nick>{,-<nick>}
+ACCEPT *
+
+When you have user mode +g (caller-ID) set, you only receive private messages
+from users on your accept list. ACCEPT adds the given nicknames to your accept
+list, or removes them if they're prefixed with '-'. ACCEPT * shows your current
+accept list. If you're logged into an account, your accept list is stored with
+it, and restored whenever you log in.
+
+If you've set NickServ's ALLOW-DMS setting to REQUESTS, messages from users who
+aren't on your accept list are held until you accept them; accepting a user
+delivers their messages. See also REJECT.`,
 	},
 	"ambiance": {
 		text: `AMBIANCE <target> <text to be sent>
 
 The AMBIANCE command is used to send a scene notification to the given target.`,
+	},
+	"announce": {
+		oper: true,
+		text: `ANNOUNCE <* | #channel{,#channel}> <message>
+ANNOUNCE CONFIRM <code>
+
+Sends <message> as a notice to every user on the network (*), or to the members
+of the given channels. The announcement is only sent once you confirm it with
+the code you're given.`,
 	},
 	"authenticate": {
 		text: `AUTHENTICATE
@@ -119,6 +151,23 @@ http://ircv3.net/specs/extensions/sasl-3.1.html`,
 
 If [message] is sent, marks you away. If [message] is not sent, marks you no
 longer away.`,
+	},
+	"backup": {
+		oper: true,
+		text: `BACKUP [JSON]
+
+Writes a snapshot of the datastore next to it, while the server keeps running.
+By default, the snapshot can be used as a datastore as is; with JSON, it's
+written in the format of "oragono exportdb", which can be restored with
+"oragono importdb".`,
+	},
+	"brb": {
+		text: `BRB [reason]
+
+Used by clients that support the RESUME extension, to announce that they're
+about to disconnect and will resume their session shortly. You're marked away
+with [reason], and your connection is closed; other users won't see you quit
+unless you don't resume in time.`,
 	},
 	"cap": {
 		text: `CAP <subcommand> [:<capabilities>]
@@ -131,6 +180,15 @@ http://ircv3.net/specs/core/capability-negotiation-3.2.html`,
 		text: `CHANSERV <subcommand> [params]
 
 ChanServ controls channel registrations.`,
+	},
+	"challenge": {
+		text: `CHALLENGE <name>
+CHALLENGE +<response>
+
+CHALLENGE lets you oper up using an RSA keypair instead of a password. The
+server sends a challenge encrypted with your public key; decrypt it with your
+private key and send back the base64-encoded SHA-1 hash of the decrypted
+challenge. Most clients have a script or module that does this for you.`,
 	},
 	"chathistory": {
 		text: `CHATHISTORY [params]
@@ -154,7 +212,32 @@ Prints debug information about the IRCd. <option> can be one of:
 * NUMGOROUTINE: Number of goroutines in use.
 * STARTCPUPROFILE: Starts the CPU profiler.
 * STOPCPUPROFILE: Stops the CPU profiler.
-* PROFILEHEAP: Writes out the CPU profiler info.`,
+* PROFILEHEAP: Writes out the CPU profiler info.
+* SENDQ: Lists the clients with the fullest sendQs.`,
+	},
+	"defcon": {
+		oper: true,
+		text: `DEFCON [level] [duration]
+
+Shows or changes the server's DEFCON (emergency lockdown) level. Each level
+includes the restrictions of the levels above it:
+
+5: Normal operation.
+4: No new account or channel registrations.
+3: New connections must authenticate with SASL.
+2: Only operators can create new channels.
+1: Joins by non-operators are throttled server-wide.
+
+If [duration] is given, the server returns to level 5 once it expires.`,
+	},
+	"die": {
+		oper: true,
+		text: `DIE [delay] [reason]
+
+Shuts down the server. If [delay] is given (e.g., 10m), the server stops
+accepting new connections right away, warns connected users as the shutdown
+approaches, and disconnects them once the delay has passed. [reason] is shown
+to users; it defaults to the configured shutdown message.`,
 	},
 	"dline": {
 		oper: true,
@@ -203,6 +286,12 @@ Replay message history. <target> can be a channel name, "me" to replay direct
 message history, or a nickname to replay another client's direct message
 history (they must be logged into the same account as you). At most [limit]
 messages will be replayed.`,
+	},
+	"histserv": {
+		text: `HISTSERV <command> [params]
+
+HistServ lets you search the message history of channels you're in, and of
+your own direct messages.`,
 	},
 	"hostserv": {
 		text: `HOSTSERV <command> [params]
@@ -278,6 +367,12 @@ ON <server> specifies that the ban is to be set on that specific server.
 [reason] and [oper reason], if they exist, are separated by a vertical bar (|).
 
 If "KLINE LIST" is sent, the server sends back a list of our current KLINEs.`,
+	},
+	"knock": {
+		text: `KNOCK <channel> [message]
+
+Asks the operators of a channel you can't join (because it's invite-only,
+requires a key, or is full) to invite you, with an optional message.`,
 	},
 	"language": {
 		text: `LANGUAGE <code>{ <code>}
@@ -288,8 +383,15 @@ Sets your preferred languages to the given ones.`,
 		text: `LIST [<channel>{,<channel>}] [<elistcond>{,<elistcond>}]
 
 Shows information on the given channels (or if none are given, then on all
-channels). <elistcond>s modify how the channels are selected.`,
-		//TODO(dan): Explain <elistcond>s in more specific detail
+channels). <elistcond>s modify how the channels are selected:
+
+	>N and <N       channels with more than, or fewer than, N users
+	C>N and C<N     channels created more than, or less than, N minutes ago
+	T>N and T<N     channels whose topic was set more than, or less than,
+	                N minutes ago
+	L=<tag>         channels tagged with <tag> by their founder, e.g. L=en
+	                (see /msg ChanServ HELP SET)
+	<mask>          channels whose names match the mask, e.g. #*irc*`,
 	},
 	"lusers": {
 		text: `LUSERS [<mask> [<server>]]
@@ -297,6 +399,30 @@ channels). <elistcond>s modify how the channels are selected.`,
 Shows statistics about the size of the network. If <mask> is given, only
 returns stats for servers matching the given mask.  If <server> is given, the
 command is processed by that server.`,
+	},
+	"markread": {
+		text: `MARKREAD <target> [timestamp=<timestamp>]
+
+Gets or sets the time up to which you've read <target> (a channel or a user).
+Read markers are stored with your account, and synced to all your clients that
+support the draft/read-marker capability. To keep separate markers for each of
+your devices (so that rejoining a channel only replays what the device hasn't
+seen), log in with a SASL username of the form account@device.`,
+	},
+	"metadata": {
+		text: `METADATA <target> GET <key>{ <key>}
+METADATA <target> LIST
+METADATA <target> SET <key> [<value>]
+METADATA <target> CLEAR
+METADATA * SUB <key>{ <key>}
+METADATA * UNSUB <key>{ <key>}
+METADATA * SUBS
+
+Gets or modifies the metadata (key-value pairs like url, avatar, or
+display-name) of a user or channel; * is yourself. You can modify your own
+metadata, and channel operators can modify their channel's. SUB subscribes to
+changes to the given keys, in your channels and for the users in them. See the
+draft/metadata-2 specification for more info.`,
 	},
 	"mode": {
 		text: `MODE <target> [<modestring> [<mode arguments>...]]
@@ -372,9 +498,10 @@ Requires the roleplay mode (+E) to be set on the target.`,
 NickServ controls accounts and user registrations.`,
 	},
 	"oper": {
-		text: `OPER <name> <password>
+		text: `OPER <name> [password]
 
-If the correct details are given, gives you IRCop privs.`,
+If the correct details are given, gives you IRCop privs. The password can be
+left out if the operator is authenticated by their TLS client certificate.`,
 	},
 	"part": {
 		text: `PART <channel>{,<channel>} [reason]
@@ -401,11 +528,24 @@ Replies to a PING. Used to check link connectivity.`,
 		text: `PRIVMSG <target>{,<target>} <text to be sent>
 
 Sends the text to the given targets as a PRIVMSG.`,
+	},
+	"relaymsg": {
+		text: `RELAYMSG <channel> <nick> :<message>
+
+RELAYMSG lets bridge bots relay messages into a channel from users on other
+networks. The nick must contain one of the separators advertised in the
+draft/relaymsg capability (usually "/"), e.g., "alice/discord". Depending on the
+server's configuration, relaying is available to channel operators, or only to
+server operators.`,
 	},
 	"rename": {
 		text: `RENAME <channel> <newname> [<reason>]
 
-Renames the given channel with the given reason, if possible.
+Renames the given channel with the given reason, if possible. Channel
+operators can rename unregistered channels; registered channels can only be
+renamed by their founder. Members, modes, the registration, and the channel's
+history are kept, and clients that don't support the draft/channel-rename
+capability see everyone part the old channel and join the new one.
 
 For example:
 	RENAME #ircv2 #ircv3 :Protocol upgrades!`,
@@ -440,6 +580,19 @@ The SCENE command is used to send a scene notification to the given target.`,
 		text: `SETNAME <realname>
 
 The SETNAME command updates the realname to be the newly-given one.`,
+	},
+	"stats": {
+		text: `STATS <query> [<nickname>]
+
+Shows information about the server. <query> can be one of:
+
+* k: Active K-lines and D-lines (requires the oper:local_ban capability).
+* l: Connection details for each client, or the given client: nickmask,
+     sendq, messages and KiB sent, messages and KiB received, and seconds
+     connected (requires the stats capability).
+* m: How many times each command has been used (requires the stats capability).
+* o: Configured operators (requires the stats capability).
+* u: Server uptime.`,
 	},
 	"tagmsg": {
 		text: `@+client-only-tags TAGMSG <target>{,<target>}
@@ -451,18 +604,55 @@ specs for more info: http://ircv3.net/specs/core/message-tags-3.3.html`,
 		text: `QUIT [reason]
 
 Indicates that you're leaving the server, and shows everyone the given reason.`,
+	},
+	"redact": {
+		text: `REDACT <target> <msgid> [reason]
+
+Deletes a message you sent to <target> (a channel or a user) from the stored
+history, and hides it from the clients that support the draft/message-redaction
+capability. Channel operators can redact any message in their channels.`,
+	},
+	"register": {
+		text: `REGISTER <account> <email | *> <password>
+
+Registers an account, as part of the draft/account-registration capability. If
+<account> is *, your current nickname is used. If the server doesn't require
+email verification, you can use * for the email address. If verification is
+required, complete the registration with VERIFY.`,
 	},
 	"rehash": {
 		oper: true,
 		text: `REHASH
 
 Reloads the config file and updates TLS certificates on listeners`,
+	},
+	"reject": {
+		text: `REJECT <nick>{,<nick>}
+
+If you've set NickServ's ALLOW-DMS setting to REQUESTS, messages from users who
+aren't on your accept list are held until you accept them with ACCEPT. REJECT
+discards the messages held from the given users, and refuses their future
+messages until you accept them.`,
+	},
+	"report": {
+		text: `REPORT <target> <msgid> [reason]
+
+Forwards an abusive message you received to the server staff, as the server
+stored it. <target> is the channel the message was sent to, or for a direct
+message, the nickname of its sender.`,
 	},
 	"resume": {
 		text: `RESUME <oldnick> [timestamp]
 
 Sent before registration has completed, this indicates that the client wants to
 resume their old connection <oldnick>.`,
+	},
+	"rules": {
+		text: `RULES [channel]
+
+Shows the rules of this server or, if a channel is given, the rules (entry
+message) of that channel. Channel founders can set the entry message with
+ChanServ's SET ENTRYMSG command.`,
 	},
 	"time": {
 		text: `TIME [server]
@@ -505,6 +695,12 @@ values (though your username may also be looked up with Ident).`,
 		text: `USERHOST <nickname>{ <nickname>}
 		
 Shows information about the given users. Takes up to 10 nicknames.`,
+	},
+	"verify": {
+		text: `VERIFY <account> <code>
+
+Completes the registration of an account created with REGISTER, using the
+verification code that was sent to you.`,
 	},
 	"version": {
 		text: `VERSION [server]
@@ -525,9 +721,12 @@ the connection from the client to the gateway, such as:
 - tls: this flag indicates that the client->gateway connection is secure`,
 	},
 	"who": {
-		text: `WHO <name> [o]
+		text: `WHO <name> [%<fields>[,<token>]]
 
-Returns information for the given user.`,
+Returns information for the given user or channel. If the second parameter is
+given, a WHOX reply is sent instead, containing only the requested fields
+(some of: t, c, u, i, h, s, n, f, d, l, a, o, r). The optional token is echoed
+back in the 't' field, so that clients can match replies to queries.`,
 	},
 	"whois": {
 		text: `WHOIS <client>{,<client>}
@@ -535,9 +734,11 @@ Returns information for the given user.`,
 Returns information for the given user(s).`,
 	},
 	"whowas": {
-		text: `WHOWAS <nickname>
+		text: `WHOWAS <nickname> [count]
 
-Returns historical information on the last user with the given nickname.`,
+Returns historical information on the last users with the given nickname.
+IRC operators can also use $a:<account> in place of the nickname, to see all
+the nicknames that were recently used while logged into that account.`,
 	},
 
 	// Informational
@@ -593,7 +794,10 @@ Oragono supports the following channel membership prefixes:
   +a (&)  |  Admin channel mode.
   +o (@)  |  Operator channel mode.
   +h (%)  |  Halfop channel mode.
-  +v (+)  |  Voice channel mode.`,
+  +v (+)  |  Voice channel mode.
+
+The server may disable the founder, admin, and halfop modes, in which case
+they're left out of PREFIX.`,
 		helpType: ISupportHelpEntry,
 	},
 }
@@ -605,7 +809,7 @@ func modesTextGenerator(client *Client) string {
 }
 
 type HelpIndexManager struct {
-	sync.RWMutex // tier 1
+	lockorder.Tier1RWMutex
 
 	langToIndex     map[string]string
 	langToOperIndex map[string]string
@@ -4,8 +4,8 @@
 package history
 
 import (
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/utils"
-	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -47,7 +47,7 @@ type Predicate func(item Item) (matches bool)
 
 // Buffer is a ring buffer holding message/event history for a channel or user
 type Buffer struct {
-	sync.RWMutex
+	lockorder.Tier0RWMutex
 
 	// ring buffer, see irc/whowas.go for conventions
 	buffer []Item
@@ -211,6 +211,52 @@ func (list *Buffer) Latest(limit int) (results []Item) {
 	return list.Match(matchAll, false, limit)
 }
 
+// Delete removes all history items such that `predicate` returns true for
+// them (the same caveats apply as for Match), returning how many were removed.
+// The remaining items keep their order.
+func (list *Buffer) Delete(predicate Predicate) (count int) {
+	if !list.Enabled() {
+		return
+	}
+
+	list.Lock()
+	defer list.Unlock()
+
+	if list.start == -1 {
+		return
+	}
+
+	// compact the surviving items toward the end of the buffer
+	length := list.length()
+	pos := list.prev(list.end)
+	write := pos
+	for i := 0; i < length; i++ {
+		if predicate(list.buffer[pos]) {
+			count++
+		} else {
+			list.buffer[write] = list.buffer[pos]
+			write = list.prev(write)
+		}
+		pos = list.prev(pos)
+	}
+	if count == 0 {
+		return
+	}
+
+	// clear the freed entries, so the deleted data doesn't linger in memory
+	// (these are the first `count` entries, starting from the old start)
+	for i, pos := 0, list.start; i < count; i, pos = i+1, list.next(pos) {
+		list.buffer[pos] = Item{}
+	}
+	if count == length {
+		list.start = -1
+		list.end = -1
+	} else {
+		list.start = list.next(write)
+	}
+	return
+}
+
 // LastDiscarded returns the latest time of any entry that was evicted
 // from the ring buffer.
 func (list *Buffer) LastDiscarded() time.Time {
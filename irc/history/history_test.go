@@ -159,3 +159,32 @@ func TestBuffer(t *testing.T) {
 	since, _ = buf.Between(easyParse("2006-01-03 00:00:00Z"), time.Now(), true, 2)
 	assertEqual(toNicks(since), []string{"testnick2", "testnick3"}, t)
 }
+
+func TestDelete(t *testing.T) {
+	buf := NewHistoryBuffer(4)
+	for _, nick := range []string{"a1", "b1", "a2", "b2", "a3", "b3"} {
+		buf.Add(Item{Nick: nick})
+	}
+	// the buffer is full and has wrapped around
+	assertEqual(toNicks(buf.Latest(0)), []string{"a2", "b2", "a3", "b3"}, t)
+
+	isB := func(item Item) bool { return item.Nick[0] == 'b' }
+	assertEqual(buf.Delete(isB), 2, t)
+	assertEqual(toNicks(buf.Latest(0)), []string{"a2", "a3"}, t)
+	assertEqual(buf.Delete(isB), 0, t)
+
+	// new items go after the survivors
+	buf.Add(Item{Nick: "b4"})
+	buf.Add(Item{Nick: "a4"})
+	buf.Add(Item{Nick: "a5"})
+	assertEqual(toNicks(buf.Latest(0)), []string{"a3", "b4", "a4", "a5"}, t)
+
+	all := func(item Item) bool { return true }
+	assertEqual(buf.Delete(all), 4, t)
+	assertEqual(len(buf.Latest(0)), 0, t)
+	for _, item := range buf.buffer {
+		assertEqual(item.Nick, "", t)
+	}
+	buf.Add(Item{Nick: "a6"})
+	assertEqual(toNicks(buf.Latest(0)), []string{"a6"}, t)
+}
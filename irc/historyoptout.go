@@ -0,0 +1,56 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"github.com/oragono/oragono/irc/history"
+)
+
+// Users can keep their messages out of the stored history, either for all
+// their messages (NickServ SET STORE-HISTORY OFF) or for individual messages
+// (by sending them with the noHistoryTag client tag). Since replay and HistServ
+// searches only see what's stored, opting out also deletes the messages that
+// were stored previously.
+
+const (
+	// noHistoryTag marks a message as not to be stored in history
+	noHistoryTag = "+oragono.io/no-history"
+)
+
+// storesHistory returns whether a message sent by the client, with the given
+// client-only tags, can be stored in history.
+func (client *Client) storesHistory(clientOnlyTags map[string]string) bool {
+	if _, ok := clientOnlyTags[noHistoryTag]; ok {
+		return false
+	}
+	return !client.AccountSettings().DisableHistoryStorage
+}
+
+// messagesAuthoredBy returns a history predicate matching the messages (but
+// not joins, parts, and so on) sent by clients logged into the (casefolded)
+// account.
+func messagesAuthoredBy(account string) history.Predicate {
+	authored := authoredBy(account)
+	return func(item history.Item) bool {
+		switch item.Type {
+		case history.Privmsg, history.Notice, history.Tagmsg:
+			return authored(item)
+		default:
+			return false
+		}
+	}
+}
+
+// setHistoryStorage changes whether the (casefolded) account's messages are
+// stored in history; disabling it deletes the ones stored so far.
+func (server *Server) setHistoryStorage(account string, enabled bool) error {
+	_, err := server.accounts.ModifySettings(account, func(settings *AccountSettings) error {
+		settings.DisableHistoryStorage = !enabled
+		return nil
+	})
+	if err == nil && !enabled {
+		server.deleteHistory(messagesAuthoredBy(account))
+	}
+	return err
+}
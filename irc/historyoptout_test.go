@@ -0,0 +1,40 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/oragono/oragono/irc/history"
+)
+
+func TestStoresHistory(t *testing.T) {
+	client := &Client{}
+	if !client.storesHistory(nil) {
+		t.Errorf("messages should be stored by default")
+	}
+	if client.storesHistory(map[string]string{noHistoryTag: ""}) {
+		t.Errorf("messages with the no-history tag should not be stored")
+	}
+	client.accountSettings.DisableHistoryStorage = true
+	if client.storesHistory(map[string]string{"+draft/react": "lol"}) {
+		t.Errorf("messages from users who opted out should not be stored")
+	}
+}
+
+func TestMessagesAuthoredBy(t *testing.T) {
+	buffer := history.NewHistoryBuffer(16)
+	buffer.Add(history.Item{Type: history.Join, AccountName: "shivaram"})
+	buffer.Add(history.Item{Type: history.Privmsg, AccountName: "shivaram"})
+	buffer.Add(history.Item{Type: history.Notice, AccountName: "Shivaram"})
+	buffer.Add(history.Item{Type: history.Privmsg, AccountName: "dan"})
+
+	if count := buffer.Delete(messagesAuthoredBy("shivaram")); count != 2 {
+		t.Errorf("expected to delete 2 messages, deleted %d", count)
+	}
+	remaining := buffer.Match(func(item history.Item) bool { return true }, true, 0)
+	if len(remaining) != 2 || remaining[0].Type != history.Join || remaining[1].AccountName != "dan" {
+		t.Errorf("unexpected remaining items: %v", remaining)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/custime"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+const histservHelp = `HistServ lets you search the message history of channels you're in,
+and of your own direct messages.
+
+To see in-depth help for a specific HistServ command, try:
+    $b/HISTSERV HELP <command>$b
+
+Here are the commands you can use:
+%s`
+
+const (
+	// number of results shown per page of SEARCH output
+	histservSearchPageSize = 10
+)
+
+var (
+	errHistservBadQuery = errors.New("Invalid search query")
+)
+
+func histservEnabled(config *Config) bool {
+	return config.History.Enabled
+}
+
+var (
+	histservCommands = map[string]*serviceCommand{
+		"search": {
+			handler: histservSearchHandler,
+			help: `Syntax: $bSEARCH <target> [from=<nick>] [after=<time>] [before=<time>] [page=<n>] [text]$b
+
+SEARCH searches the stored history of <target>, which is either a channel
+you're in, or your own nickname (to search your direct messages). Messages
+match if they contain [text] (ignoring case), were sent by <nick>, and were
+sent within the given time range. Times are either timestamps (like
+2019-06-01T12:00:00.000Z) or durations meaning "this long ago" (like 2h).
+Results are shown newest first, 10 to a page.`,
+			helpShort: `$bSEARCH$b searches channel and direct message history.`,
+			enabled:   histservEnabled,
+			minParams: 1,
+		},
+	}
+)
+
+func histservNotice(rb *ResponseBuffer, text string) {
+	rb.AddSplitMessageFromServer("HistServ", "NOTICE", rb.target.Nick(), utils.MakeSplitMessage(text))
+}
+
+// historySearch is a parsed HistServ SEARCH query.
+type historySearch struct {
+	from   string // casefolded nickname or account name
+	after  time.Time
+	before time.Time
+	page   int
+	text   string // lowercase
+}
+
+// parseHistoryTime parses a time given either as a timestamp or as a duration
+// before `now`.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if timestamp, err := time.Parse(IRCv3TimestampFormat, value); err == nil {
+		return timestamp, nil
+	}
+	duration, err := custime.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return time.Time{}, errHistservBadQuery
+	}
+	return now.Add(-duration), nil
+}
+
+// parseHistorySearch parses the parameters of SEARCH after the target.
+func parseHistorySearch(params []string, now time.Time) (search historySearch, err error) {
+	search.page = 1
+	var text []string
+	for _, param := range params {
+		pieces := strings.SplitN(param, "=", 2)
+		if len(pieces) != 2 {
+			text = append(text, param)
+			continue
+		}
+		switch strings.ToLower(pieces[0]) {
+		case "from":
+			search.from, err = CasefoldName(pieces[1])
+		case "after":
+			search.after, err = parseHistoryTime(pieces[1], now)
+		case "before":
+			search.before, err = parseHistoryTime(pieces[1], now)
+		case "page":
+			search.page, err = strconv.Atoi(pieces[1])
+			if err == nil && search.page < 1 {
+				err = errHistservBadQuery
+			}
+		default:
+			text = append(text, param)
+		}
+		if err != nil {
+			return search, errHistservBadQuery
+		}
+	}
+	search.text = strings.ToLower(strings.Join(text, " "))
+	return
+}
+
+// matches returns whether a history item satisfies the search.
+func (search *historySearch) matches(item history.Item) bool {
+	if item.Type != history.Privmsg && item.Type != history.Notice {
+		return false
+	}
+	if !search.after.IsZero() && !item.Time.After(search.after) {
+		return false
+	}
+	if !search.before.IsZero() && !item.Time.Before(search.before) {
+		return false
+	}
+	if search.from != "" {
+		nick := item.Nick
+		if i := strings.IndexByte(nick, '!'); i != -1 {
+			nick = nick[:i]
+		}
+		cfnick, _ := CasefoldName(nick)
+		cfaccount, _ := CasefoldName(item.AccountName)
+		if search.from != cfnick && search.from != cfaccount {
+			return false
+		}
+	}
+	return search.text == "" || strings.Contains(strings.ToLower(item.Message.Message), search.text)
+}
+
+// run searches the buffer, returning the requested page of results (in
+// chronological order), and whether there are more pages after it.
+func (search *historySearch) run(buffer *history.Buffer) (results []history.Item, more bool) {
+	skip := (search.page - 1) * histservSearchPageSize
+	// look for one more result than we need, to see if there's another page:
+	results = buffer.Match(search.matches, false, skip+histservSearchPageSize+1)
+	// results are in chronological order, so the newest ones are at the end:
+	end := len(results) - skip
+	if end <= 0 {
+		return nil, false
+	}
+	start := end - histservSearchPageSize
+	if start <= 0 {
+		start = 0
+	} else {
+		more = true
+	}
+	return results[start:end], more
+}
+
+func histservSearchHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	target := params[0]
+	var buffer *history.Buffer
+	if channel := server.channels.Get(target); channel != nil {
+		// only members can search a channel's history
+		if channel.hasClient(client) {
+			buffer = &channel.history
+		}
+	} else if cftarget, err := CasefoldName(target); err == nil && cftarget == client.NickCasefolded() {
+		buffer = client.history
+	}
+	if buffer == nil {
+		histservNotice(rb, client.t("You can only search the history of channels you're in, or of your own direct messages"))
+		return
+	}
+
+	search, err := parseHistorySearch(params[1:], time.Now().UTC())
+	if err != nil {
+		histservNotice(rb, client.t("Invalid search query. For usage, do /msg HistServ HELP SEARCH"))
+		return
+	}
+
+	results, more := search.run(buffer)
+	if len(results) == 0 {
+		histservNotice(rb, client.t("No matching messages found"))
+		return
+	}
+	histservNotice(rb, fmt.Sprintf(client.t("Matching messages in %[1]s (page %[2]d):"), target, search.page))
+	for _, item := range results {
+		nick := item.Nick
+		if i := strings.IndexByte(nick, '!'); i != -1 {
+			nick = nick[:i]
+		}
+		histservNotice(rb, fmt.Sprintf("[%s] <%s> %s", item.Time.UTC().Format(IRCv3TimestampFormat), nick, item.Message.Message))
+	}
+	if more {
+		histservNotice(rb, fmt.Sprintf(client.t("There are more results; to see them, add page=%d to your search"), search.page+1))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+func TestParseHistorySearch(t *testing.T) {
+	now := time.Unix(1000000, 0).UTC()
+	search, err := parseHistorySearch([]string{"from=Dan", "after=2h", "before=1970-01-12T13:00:00.000Z", "page=2", "Hello", "world"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search.from != "dan" || !search.after.Equal(now.Add(-2*time.Hour)) || search.page != 2 || search.text != "hello world" {
+		t.Errorf("unexpected search: %#v", search)
+	}
+	if !search.before.Equal(time.Date(1970, 1, 12, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected before time: %v", search.before)
+	}
+
+	search, err = parseHistorySearch([]string{"a=b"}, now)
+	if err != nil || search.text != "a=b" || search.page != 1 {
+		t.Errorf("unknown keys should be searched for as text: %#v %v", search, err)
+	}
+
+	for _, invalid := range [][]string{{"page=0"}, {"after=yesterday"}, {"from=a b"}} {
+		if _, err := parseHistorySearch(invalid, now); err == nil {
+			t.Errorf("query should have been rejected: %v", invalid)
+		}
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	buffer := history.NewHistoryBuffer(100)
+	base := time.Unix(1000000, 0).UTC()
+	for i := 0; i < 25; i++ {
+		nick := "dan!d@localhost"
+		if i%2 == 1 {
+			nick = "shivaram!s@localhost"
+		}
+		buffer.Add(history.Item{
+			Type:        history.Privmsg,
+			Time:        base.Add(time.Duration(i) * time.Minute),
+			Nick:        nick,
+			AccountName: "*",
+			Message:     utils.MakeSplitMessage(fmt.Sprintf("Message %d", i)),
+		})
+	}
+	buffer.Add(history.Item{Type: history.Join, Time: base.Add(time.Hour), Nick: "dan!d@localhost"})
+
+	search := historySearch{page: 1, text: "message"}
+	results, more := search.run(buffer)
+	if len(results) != histservSearchPageSize || !more {
+		t.Fatalf("unexpected results: %d %v", len(results), more)
+	}
+	// newest first, shown in chronological order
+	if results[0].Message.Message != "Message 15" || results[9].Message.Message != "Message 24" {
+		t.Errorf("unexpected first page: %s ... %s", results[0].Message.Message, results[9].Message.Message)
+	}
+
+	search.page = 3
+	results, more = search.run(buffer)
+	if len(results) != 5 || more || results[0].Message.Message != "Message 0" {
+		t.Errorf("unexpected last page: %d %v", len(results), more)
+	}
+
+	search.page = 4
+	if results, _ = search.run(buffer); len(results) != 0 {
+		t.Errorf("pages past the end should be empty")
+	}
+
+	search = historySearch{page: 1, from: "shivaram", after: base.Add(10 * time.Minute), before: base.Add(15 * time.Minute)}
+	results, more = search.run(buffer)
+	if len(results) != 2 || more || results[0].Message.Message != "Message 11" || results[1].Message.Message != "Message 13" {
+		t.Errorf("unexpected results: %v", results)
+	}
+}
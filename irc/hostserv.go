@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"regexp"
 	"time"
+
+	"github.com/oragono/oragono/irc/utils"
 )
 
 const hostservHelp = `HostServ lets you manage your vhost (i.e., the string displayed
@@ -131,7 +133,7 @@ for the rejection.`,
 
 // hsNotice sends the client a notice from HostServ
 func hsNotice(rb *ResponseBuffer, text string) {
-	rb.Add(nil, "HostServ", "NOTICE", rb.target.Nick(), text)
+	rb.AddSplitMessageFromServer("HostServ", "NOTICE", rb.target.Nick(), utils.MakeSplitMessage(text))
 }
 
 // hsNotifyChannel notifies the designated channel of new vhost activity
@@ -142,8 +144,9 @@ func hsNotifyChannel(server *Server, message string) {
 		return
 	}
 	chname = channel.Name()
+	splitMessage := utils.MakeSplitMessage(message)
 	for _, client := range channel.Members() {
-		client.Send(nil, "HostServ", "PRIVMSG", chname, message)
+		client.SendSplitMsgFromServer("HostServ", "PRIVMSG", chname, splitMessage)
 	}
 }
 
@@ -312,7 +315,7 @@ func hsRejectHandler(server *Server, client *Client, command string, params []st
 		hsNotifyChannel(server, chanMsg)
 		for _, client := range server.accounts.AccountToClients(user) {
 			if reason == "" {
-				client.Notice("Your vhost request was rejected by an administrator")
+				client.Notice(client.t("Your vhost request was rejected by an administrator"))
 			} else {
 				client.Notice(fmt.Sprintf(client.t("Your vhost request was rejected by an administrator. The reason given was: %s"), reason))
 			}
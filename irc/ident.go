@@ -0,0 +1,105 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	ident "github.com/oragono/go-ident"
+)
+
+const (
+	// DefaultIdentTimeout is how long we wait for an ident (RFC 1413) response.
+	DefaultIdentTimeout = 1500 * time.Millisecond
+)
+
+// IdentConfig controls ident lookups of connecting clients' usernames.
+type IdentConfig struct {
+	Enabled bool
+	Timeout time.Duration
+	// if nonempty, lookups are only performed for connections to these listeners
+	Listeners []string
+}
+
+func (conf *IdentConfig) prepare() {
+	if conf.Timeout == 0 {
+		conf.Timeout = DefaultIdentTimeout
+	}
+}
+
+// enabledForListener returns whether connections to the listener get ident lookups.
+func (conf *IdentConfig) enabledForListener(addr string) bool {
+	if !conf.Enabled {
+		return false
+	}
+	if len(conf.Listeners) == 0 {
+		return true
+	}
+	for _, listener := range conf.Listeners {
+		if listener == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// startIdentLookup looks up the client's username in the background; the lookup
+// runs concurrently with registration (and counts against the registration timeout),
+// and registration waits for it to finish (see waitForIdent).
+func (client *Client) startIdentLookup(conn net.Conn, timeout time.Duration) {
+	client.identDone = make(chan struct{})
+	go func() {
+		defer close(client.identDone)
+		client.identUsername = client.doIdentLookup(conn, timeout)
+	}()
+}
+
+func (client *Client) doIdentLookup(conn net.Conn, timeout time.Duration) (username string) {
+	_, serverPortString, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		client.server.logger.Error("internal", "bad server address", err.Error())
+		return
+	}
+	serverPort, _ := strconv.Atoi(serverPortString)
+	clientHost, clientPortString, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		client.server.logger.Error("internal", "bad client address", err.Error())
+		return
+	}
+	clientPort, _ := strconv.Atoi(clientPortString)
+
+	client.Notice(client.t("*** Looking up your username"))
+	resp, err := ident.Query(clientHost, serverPort, clientPort, timeout.Seconds())
+	if err != nil {
+		client.Notice(client.t("*** Could not find your username"))
+		return
+	}
+	username = resp.Identifier
+	if limit := client.server.Config().Limits.IdentLen; limit < len(username) {
+		username = username[:limit]
+	}
+	if !isIdent(username) {
+		client.Notice(client.t("*** Got a malformed username, ignoring"))
+		return ""
+	}
+	client.Notice(client.t("*** Found your username"))
+	return username
+}
+
+// waitForIdent waits for the ident lookup (if any) to complete, then replaces
+// the username from USER (which has the ~ prefix) with the one from ident.
+func (client *Client) waitForIdent() {
+	if client.identDone == nil {
+		return
+	}
+	<-client.identDone
+	if client.identUsername != "" {
+		client.stateMutex.Lock()
+		client.username = client.identUsername
+		client.stateMutex.Unlock()
+	}
+	client.identDone = nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestIdentListeners(t *testing.T) {
+	var conf IdentConfig
+	conf.prepare()
+	if conf.Timeout != DefaultIdentTimeout {
+		t.Errorf("default timeout not set")
+	}
+	if conf.enabledForListener(":6667") {
+		t.Errorf("ident should be disabled")
+	}
+
+	conf.Enabled = true
+	if !conf.enabledForListener(":6667") || !conf.enabledForListener(":6697") {
+		t.Errorf("ident should be enabled for all listeners")
+	}
+
+	conf.Listeners = []string{":6667"}
+	if !conf.enabledForListener(":6667") || conf.enabledForListener(":6697") {
+		t.Errorf("ident should only be enabled for :6667")
+	}
+}
+
+func TestWaitForIdent(t *testing.T) {
+	client := &Client{username: "~dan"}
+	client.waitForIdent()
+	if client.username != "~dan" {
+		t.Errorf("username changed without an ident lookup")
+	}
+
+	client.identDone = make(chan struct{})
+	go func() {
+		client.identUsername = "daniel"
+		close(client.identDone)
+	}()
+	client.waitForIdent()
+	if client.username != "daniel" {
+		t.Errorf("ident username not applied: %s", client.username)
+	}
+}
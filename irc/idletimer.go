@@ -5,12 +5,13 @@ package irc
 
 import (
 	"fmt"
-	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/goshuirc/irc-go/ircfmt"
 	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/utils"
 )
 
 const (
@@ -27,8 +28,22 @@ const (
 	DefaultTotalTimeout = 2*time.Minute + 30*time.Second
 	// Resumeable clients (clients who have negotiated caps.Resume) get longer:
 	ResumeableTotalTimeout = 3*time.Minute + 30*time.Second
+
+	// resolution and size of the timer wheel; with these values, the idle timers
+	// fit within a single revolution
+	timerWheelTick  = 100 * time.Millisecond
+	timerWheelSlots = 1024
 )
 
+// timerWheel runs the per-client timers (idle, nick enforcement, and auto-away)
+// and the ban expiration timers: there can be a great many of these, and they
+// don't need to be precise.
+var timerWheel = utils.NewTimerWheel(timerWheelTick, timerWheelSlots)
+
+// timerClock is the default clock for the idle and nick timers; tests can give
+// the server a utils.FakeClock instead.
+var timerClock utils.Clock = utils.WheelClock{Wheel: timerWheel}
+
 // client idleness state machine
 
 type TimerState uint
@@ -41,23 +56,25 @@ const (
 )
 
 type IdleTimer struct {
-	sync.Mutex // tier 1
+	lockorder.Tier1Mutex
 
 	// immutable after construction
 	registerTimeout time.Duration
 	client          *Client
+	clock           utils.Clock
 
 	// mutable
 	idleTimeout time.Duration
 	quitTimeout time.Duration
 	state       TimerState
-	timer       *time.Timer
+	timer       utils.Timer
 }
 
 // Initialize sets up an IdleTimer and starts counting idle time;
 // if there is no activity from the client, it will eventually be stopped.
 func (it *IdleTimer) Initialize(client *Client) {
 	it.client = client
+	it.clock = client.server.Clock()
 	it.registerTimeout = RegisterTimeout
 	it.idleTimeout, it.quitTimeout = it.recomputeDurations()
 
@@ -67,21 +84,22 @@ func (it *IdleTimer) Initialize(client *Client) {
 	it.resetTimeout()
 }
 
-// recomputeDurations recomputes the idle and quit durations, given the client's caps.
+// recomputeDurations recomputes the idle and quit durations, given the client's
+// connection class and caps.
 func (it *IdleTimer) recomputeDurations() (idleTimeout, quitTimeout time.Duration) {
-	totalTimeout := DefaultTotalTimeout
+	class := it.client.connectionClass()
+	idleTimeout, quitTimeout = class.IdleTimeout, class.PingTimeout
 	// if they have the resume cap, wait longer before pinging them out
 	// to give them a chance to resume their connection
 	if it.client.capabilities.Has(caps.Resume) {
-		totalTimeout = ResumeableTotalTimeout
+		quitTimeout += ResumeableTotalTimeout - DefaultTotalTimeout
 	}
 
-	idleTimeout = DefaultIdleTimeout
-	if it.client.isTor {
+	// ping Tor clients more often, without changing the total timeout
+	if it.client.isTor && TorIdleTimeout < idleTimeout {
+		quitTimeout += idleTimeout - TorIdleTimeout
 		idleTimeout = TorIdleTimeout
 	}
-
-	quitTimeout = totalTimeout - idleTimeout
 	return
 }
 
@@ -152,7 +170,7 @@ func (it *IdleTimer) resetTimeout() {
 	case TimerDead:
 		return
 	}
-	it.timer = time.AfterFunc(nextTimeout, it.processTimeout)
+	it.timer = it.clock.AfterFunc(nextTimeout, it.processTimeout)
 }
 
 func (it *IdleTimer) quitMessage(state TimerState) string {
@@ -172,17 +190,18 @@ func (it *IdleTimer) quitMessage(state TimerState) string {
 
 // NickTimer manages timing out of clients who are squatting reserved nicks
 type NickTimer struct {
-	sync.Mutex // tier 1
+	lockorder.Tier1Mutex
 
 	// immutable after construction
 	client *Client
+	clock  utils.Clock
 
 	// mutable
 	nick           string
 	accountForNick string
 	account        string
 	timeout        time.Duration
-	timer          *time.Timer
+	timer          utils.Timer
 	enabled        uint32
 }
 
@@ -190,6 +209,7 @@ type NickTimer struct {
 func (nt *NickTimer) Initialize(client *Client) {
 	if nt.client == nil {
 		nt.client = client // placate the race detector
+		nt.clock = client.server.Clock()
 	}
 
 	config := &client.server.Config().Accounts.NickReservation
@@ -246,7 +266,7 @@ func (nt *NickTimer) Touch() {
 			nt.timer = nil
 		}
 		if enforceTimeout && delinquent && (accountChanged || nt.timer == nil) {
-			nt.timer = time.AfterFunc(nt.timeout, nt.processTimeout)
+			nt.timer = nt.clock.AfterFunc(nt.timeout, nt.processTimeout)
 			shouldWarn = true
 		} else if method == NickReservationStrict && delinquent {
 			shouldRename = true // this can happen if reservation was enabled by rehash
@@ -254,7 +274,7 @@ func (nt *NickTimer) Touch() {
 	}()
 
 	if shouldWarn {
-		nt.client.Send(nil, "NickServ", "NOTICE", nt.client.Nick(), fmt.Sprintf(ircfmt.Unescape(nt.client.t(nsTimeoutNotice)), nt.Timeout()))
+		nt.client.SendSplitMsgFromServer("NickServ", "NOTICE", nt.client.Nick(), utils.MakeSplitMessage(fmt.Sprintf(ircfmt.Unescape(nt.client.t(nsTimeoutNotice)), nt.Timeout())))
 	} else if shouldRename {
 		nt.client.Notice(nt.client.t("Nickname is reserved by a different account"))
 		nt.client.server.RandomlyRename(nt.client)
@@ -0,0 +1,126 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// waitForIdleState waits for the client's idle timer to reach the state; the
+// timer is touched after each command is processed, so this can lag behind
+// the replies.
+func waitForIdleState(t *testing.T, client *Client, state TimerState) {
+	deadline := time.Now().Add(harnessTimeout)
+	for time.Now().Before(deadline) {
+		client.idletimer.Lock()
+		current := client.idletimer.state
+		client.idletimer.Unlock()
+		if current == state {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("idle timer never reached state %d", state)
+}
+
+func TestRegistrationTimeout(t *testing.T) {
+	clock := utils.NewFakeClock(time.Now())
+	h := newTestHarness(t, clock, nil)
+	defer h.Close()
+
+	client := h.Connect()
+	deadline := time.Now().Add(harnessTimeout)
+	for clock.Pending() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(RegisterTimeout - time.Second)
+	if clock.Pending() != 1 {
+		t.Errorf("registration timeout fired early")
+	}
+	clock.Advance(time.Second)
+	if msg := client.Expect("ERROR"); len(msg.Params) == 0 || !strings.Contains(msg.Params[0], "Registration timeout") {
+		t.Errorf("unexpected error: %v", msg)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	clock := utils.NewFakeClock(time.Now())
+	h := newTestHarness(t, clock, nil)
+	defer h.Close()
+
+	client := h.Register("alice")
+	sClient := h.server.clients.Get("alice")
+	waitForIdleState(t, sClient, TimerActive)
+
+	// answering the PING keeps the client alive
+	clock.Advance(DefaultIdleTimeout)
+	ping := client.Expect("PING")
+	client.Send("PONG", ping.Params...)
+	client.Sync()
+	waitForIdleState(t, sClient, TimerActive)
+
+	clock.Advance(DefaultTotalTimeout - DefaultIdleTimeout)
+	sClient.idletimer.Lock()
+	state := sClient.idletimer.state
+	sClient.idletimer.Unlock()
+	if state != TimerActive {
+		t.Errorf("client that answered the PING was timed out")
+	}
+	clock.Advance(DefaultIdleTimeout - (DefaultTotalTimeout - DefaultIdleTimeout))
+	client.Expect("PING")
+
+	// otherwise, it's disconnected once the total timeout elapses
+	clock.Advance(DefaultTotalTimeout - DefaultIdleTimeout)
+	if msg := client.Expect("ERROR"); len(msg.Params) == 0 || !strings.Contains(msg.Params[0], "Ping timeout: 2m30s") {
+		t.Errorf("unexpected error: %v", msg)
+	}
+	if clock.Pending() != 0 {
+		t.Errorf("timers were left pending: %d", clock.Pending())
+	}
+}
+
+func TestNickEnforcementTimeout(t *testing.T) {
+	clock := utils.NewFakeClock(time.Now())
+	h := newTestHarness(t, clock, func(config *Config) {
+		config.Accounts.NickReservation.Enabled = true
+		config.Accounts.NickReservation.Method = NickReservationWithTimeout
+	})
+	defer h.Close()
+
+	if err := h.server.accounts.Register(nil, "dan", "admin", "", "hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.accounts.Verify(nil, "dan", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the warning is sent during registration, before RPL_WELCOME
+	client := h.Connect()
+	client.Send("NICK", "dan")
+	client.Send("USER", "u", "0", "*", "dan")
+	if msg := client.Expect("NOTICE"); !strings.HasPrefix(msg.Prefix, "NickServ") {
+		t.Errorf("expected a warning from NickServ, got %v", msg)
+	}
+	// let registration finish before renaming the client
+	client.Sync()
+	waitForIdleState(t, h.server.clients.Get("dan"), TimerActive)
+	timeout := h.server.Config().Accounts.NickReservation.RenameTimeout
+
+	clock.Advance(timeout - time.Second)
+	if h.server.clients.Get("dan") == nil {
+		t.Errorf("client was renamed early")
+	}
+	clock.Advance(time.Second)
+	if msg := client.Expect("NICK"); len(msg.Params) == 0 || !strings.HasPrefix(msg.Params[0], "Guest-") {
+		t.Errorf("client was not renamed: %v", msg)
+	}
+	if h.server.clients.Get("dan") != nil {
+		t.Errorf("client still has the reserved nick")
+	}
+}
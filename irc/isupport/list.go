@@ -75,26 +75,28 @@ func (il *List) GetDifference(newil *List) [][]string {
 
 	sort.Sort(outTokens)
 
-	// create output list
+	return splitTokens(outTokens)
+}
+
+// splitTokens splits a list of tokens into RPL_ISUPPORT lines, each of which
+// has at most 13 tokens and fits in maxLastArgLength.
+func splitTokens(tokens []string) [][]string {
 	replies := make([][]string, 0)
 	var length int     // Length of the current cache
 	var cache []string // Token list cache
 
-	for _, token := range outTokens {
-		if len(token)+length <= maxLastArgLength {
-			// account for the space separating tokens
-			if len(cache) > 0 {
-				length++
-			}
-			cache = append(cache, token)
-			length += len(token)
-		}
-
-		if len(cache) == 13 || len(token)+length >= maxLastArgLength {
+	for _, token := range tokens {
+		// account for the space separating tokens
+		if len(cache) == 13 || (len(cache) > 0 && maxLastArgLength < length+1+len(token)) {
 			replies = append(replies, cache)
-			cache = make([]string, 0)
+			cache = nil
 			length = 0
 		}
+		if len(cache) > 0 {
+			length++
+		}
+		cache = append(cache, token)
+		length += len(token)
 	}
 
 	if len(cache) > 0 {
@@ -106,43 +108,23 @@ func (il *List) GetDifference(newil *List) [][]string {
 
 // RegenerateCachedReply regenerates the cached RPL_ISUPPORT reply
 func (il *List) RegenerateCachedReply() (err error) {
-	il.CachedReply = make([][]string, 0)
-	var length int     // Length of the current cache
-	var cache []string // Token list cache
-
 	// make sure we get a sorted list of tokens, needed for tests and looks nice
-	var tokens sort.StringSlice
+	var names sort.StringSlice
 	for name := range il.Tokens {
-		tokens = append(tokens, name)
+		names = append(names, name)
 	}
-	sort.Sort(tokens)
+	sort.Sort(names)
 
-	for _, name := range tokens {
+	tokens := make([]string, 0, len(names))
+	for _, name := range names {
 		token := getTokenString(name, il.Tokens[name])
 		if token[0] == ':' || strings.Contains(token, " ") {
 			err = fmt.Errorf("bad isupport token (cannot contain spaces or start with :): %s", token)
 			continue
 		}
-
-		if len(token)+length <= maxLastArgLength {
-			// account for the space separating tokens
-			if len(cache) > 0 {
-				length++
-			}
-			cache = append(cache, token)
-			length += len(token)
-		}
-
-		if len(cache) == 13 || len(token)+length >= maxLastArgLength {
-			il.CachedReply = append(il.CachedReply, cache)
-			cache = make([]string, 0)
-			length = 0
-		}
-	}
-
-	if len(cache) > 0 {
-		il.CachedReply = append(il.CachedReply, cache)
+		tokens = append(tokens, token)
 	}
 
+	il.CachedReply = splitTokens(tokens)
 	return
 }
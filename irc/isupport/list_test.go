@@ -5,6 +5,7 @@ package isupport
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -104,3 +105,31 @@ func TestBadToken(t *testing.T) {
 		t.Errorf("expected the other 4 params to be generated, got %v", list.CachedReply)
 	}
 }
+
+func TestLongTokens(t *testing.T) {
+	// tokens that don't fit on the current line must start a new one,
+	// instead of being dropped
+	list := NewList()
+	longValue := strings.Repeat("a", 150)
+	for _, name := range []string{"A", "B", "C", "D"} {
+		list.Add(name, longValue)
+	}
+	if err := list.RegenerateCachedReply(); err != nil {
+		t.Fatal(err)
+	}
+	numParams := 0
+	for _, tokenLine := range list.CachedReply {
+		numParams += len(tokenLine)
+		if length := len(strings.Join(tokenLine, " ")); maxLastArgLength < length {
+			t.Errorf("token line is too long: %d", length)
+		}
+	}
+	if numParams != 4 || len(list.CachedReply) != 2 {
+		t.Errorf("expected 4 params on 2 lines, got %v", list.CachedReply)
+	}
+
+	difference := NewList().GetDifference(list)
+	if !reflect.DeepEqual(difference, list.CachedReply) {
+		t.Errorf("difference should include every new token, got %v", difference)
+	}
+}
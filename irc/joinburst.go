@@ -0,0 +1,50 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"github.com/oragono/oragono/irc/caps"
+)
+
+const (
+	// vendor-specific batch type for the channel state sent to a client that
+	// attaches to many channels at once
+	joinBurstBatchType = "oragono.io/join-burst"
+
+	defaultJoinBurstChunkSize = 25
+)
+
+// JoinBurstConfig controls how channel state is sent to clients that attach
+// to many channels at once (on RESUME, or with a multi-channel JOIN).
+type JoinBurstConfig struct {
+	// if true, resuming clients don't receive NAMES for their channels;
+	// they can request them with NAMES as needed
+	DeferNames bool `yaml:"defer-names"`
+	// how many channels to send before waiting for the client to read them
+	ChunkSize int `yaml:"chunk-size"`
+}
+
+func (conf *JoinBurstConfig) prepare() {
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = defaultJoinBurstChunkSize
+	}
+}
+
+// startJoinBurst wraps the responses in `rb` in a join-burst batch, if the
+// client supports batches and they aren't already in a labeled-response batch.
+func startJoinBurst(client *Client, rb *ResponseBuffer) {
+	if client.capabilities.Has(caps.Batch) && !(rb.Label != "" && client.capabilities.Has(caps.LabeledResponse)) {
+		rb.InitializeBatch(joinBurstBatchType, false)
+	}
+}
+
+// flushJoinBurst sends the burst so far, once every `chunkSize` channels.
+// Writing synchronously first drains anything already queued on the client's
+// sendq, then blocks until the client has read the chunk, so a large burst
+// proceeds at the client's pace and can't overflow the sendq.
+func flushJoinBurst(rb *ResponseBuffer, count, chunkSize int) {
+	if count%chunkSize == 0 {
+		rb.Flush(true)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/sno"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// When a channel receives more joins than its threshold allows, it's considered
+// to be join-flooded, and for a while afterwards new joiners must pass a
+// challenge: either answering a token sent to them by ChanServ (which stops
+// bots that don't understand the challenge), or being logged into an account
+// that's old enough.
+
+const (
+	joinChallengeToken      = "token"
+	joinChallengeAccountAge = "account-age"
+
+	defaultJoinFloodJoins    = 10
+	defaultJoinFloodWindow   = 10 * time.Second
+	defaultJoinFloodDuration = 10 * time.Minute
+	joinChallengeTokenLength = 8
+)
+
+// JoinFloodConfig controls the detection of join floods, and the challenge
+// that new joiners must pass while a channel is being flooded.
+type JoinFloodConfig struct {
+	Enabled bool
+	// the default threshold: more than `joins` joins within `window`
+	Joins  int
+	Window time.Duration
+	// how long the challenge stays active after the last flooding join
+	Duration time.Duration
+	// "token" or "account-age"
+	Challenge string
+	// accounts registered at least this long ago are exempt from the challenge
+	MinAccountAge time.Duration `yaml:"min-account-age"`
+}
+
+func (conf *JoinFloodConfig) prepare() error {
+	if conf.Joins == 0 {
+		conf.Joins = defaultJoinFloodJoins
+	}
+	if conf.Window == 0 {
+		conf.Window = defaultJoinFloodWindow
+	}
+	if conf.Duration == 0 {
+		conf.Duration = defaultJoinFloodDuration
+	}
+	switch conf.Challenge {
+	case "":
+		conf.Challenge = joinChallengeToken
+	case joinChallengeToken:
+	case joinChallengeAccountAge:
+		if conf.MinAccountAge == 0 {
+			return fmt.Errorf("The account-age join flood challenge requires min-account-age to be set")
+		}
+	default:
+		return fmt.Errorf("Unknown join flood challenge: %s", conf.Challenge)
+	}
+	return nil
+}
+
+// JoinFloodSettings is a channel's own join flood threshold, overriding the
+// server default. The zero value means the server default applies.
+type JoinFloodSettings struct {
+	Joins    int
+	Window   time.Duration
+	Disabled bool
+}
+
+// String formats the settings as they're entered in /CS SET JOINFLOOD.
+func (settings JoinFloodSettings) String() string {
+	if settings.Disabled {
+		return "off"
+	} else if settings.Joins == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%v", settings.Joins, settings.Window)
+}
+
+// ParseJoinFloodSettings parses settings of the form `joins:window` (e.g., `5:10s`),
+// `off`, or the empty string (for the server default).
+func ParseJoinFloodSettings(str string) (settings JoinFloodSettings, err error) {
+	if str == "" {
+		return
+	} else if strings.ToLower(str) == "off" {
+		settings.Disabled = true
+		return
+	}
+	pieces := strings.SplitN(str, ":", 2)
+	if len(pieces) != 2 {
+		err = errInvalidParams
+		return
+	}
+	settings.Joins, err = strconv.Atoi(pieces[0])
+	if err == nil {
+		settings.Window, err = time.ParseDuration(pieces[1])
+	}
+	if err != nil || settings.Joins <= 0 || settings.Window <= 0 {
+		return JoinFloodSettings{}, errInvalidParams
+	}
+	return
+}
+
+// joinFloodState tracks recent joins to a channel.
+type joinFloodState struct {
+	start          time.Time
+	count          int
+	challengeUntil time.Time
+}
+
+// touch records a join, returning whether the challenge is active and whether
+// this join started it.
+func (state *joinFloodState) touch(now time.Time, joins int, window, duration time.Duration) (active, triggered bool) {
+	if window < now.Sub(state.start) {
+		state.start = now
+		state.count = 0
+	}
+	state.count++
+	if joins < state.count {
+		triggered = !now.Before(state.challengeUntil)
+		state.challengeUntil = now.Add(duration)
+	}
+	return now.Before(state.challengeUntil), triggered
+}
+
+// touchJoinFlood records a join attempt, returning whether new joiners are
+// currently being challenged, and whether this join started the challenge.
+func (channel *Channel) touchJoinFlood(config *JoinFloodConfig) (active, triggered bool) {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	settings := channel.joinFloodSettings
+	if settings.Disabled {
+		return false, false
+	}
+	joins, window := config.Joins, config.Window
+	if settings.Joins != 0 {
+		joins, window = settings.Joins, settings.Window
+	}
+	return channel.joinFlood.touch(time.Now(), joins, window, config.Duration)
+}
+
+// notifyJoinFlood tells the channel's operators (and the server's) that the
+// channel is being flooded.
+func (channel *Channel) notifyJoinFlood(config *JoinFloodConfig) {
+	server := channel.server
+	chname := channel.Name()
+	server.logger.Warning("join", "Join flood detected in channel", chname)
+	server.snomasks.Send(sno.LocalChannels, fmt.Sprintf(ircfmt.Unescape("Join flood detected in channel $c[grey][$r%s$c[grey]]"), chname))
+	prefix := fmt.Sprintf("[%s] ", chname)
+	messages := make(splitMessageCache)
+	for _, member := range channel.Members() {
+		if channel.ClientIsAtLeast(member, modes.ChannelOperator) {
+			message := messages.get(prefix + fmt.Sprintf(member.t("Join flood detected; new joiners must pass a challenge for the next %v"), config.Duration))
+			member.SendSplitMsgFromServer("ChanServ", "NOTICE", member.Nick(), message)
+		}
+	}
+}
+
+// accountIsOlderThan returns whether the client is logged into an account that
+// was registered at least `age` ago.
+func (client *Client) accountIsOlderThan(age time.Duration) bool {
+	account := client.Account()
+	if age == 0 || account == "" {
+		return false
+	}
+	info, err := client.server.accounts.LoadAccount(account)
+	return err == nil && age <= time.Since(info.RegisteredAt)
+}
+
+// joinChallenge is a challenge issued to a client by a join-flooded channel.
+type joinChallenge struct {
+	token  string
+	passed bool
+}
+
+// joinChallengeToken returns the token the client must answer to join the
+// given (casefolded) channel, generating one if necessary.
+func (client *Client) joinChallengeToken(chname string) string {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	if client.joinChallenges == nil {
+		client.joinChallenges = make(map[string]*joinChallenge)
+	}
+	challenge := client.joinChallenges[chname]
+	if challenge == nil {
+		challenge = &joinChallenge{token: utils.GenerateSecretToken()[:joinChallengeTokenLength]}
+		client.joinChallenges[chname] = challenge
+	}
+	return challenge.token
+}
+
+// answerJoinChallenge checks the client's answer to a challenge, returning
+// whether it was correct.
+func (client *Client) answerJoinChallenge(chname, token string) bool {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	challenge := client.joinChallenges[chname]
+	if challenge == nil || !utils.SecretTokensMatch(challenge.token, token) {
+		return false
+	}
+	challenge.passed = true
+	return true
+}
+
+// consumeJoinChallenge returns whether the client passed the challenge for
+// the given channel, in which case it can't be used again.
+func (client *Client) consumeJoinChallenge(chname string) bool {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	challenge := client.joinChallenges[chname]
+	if challenge == nil || !challenge.passed {
+		return false
+	}
+	delete(client.joinChallenges, chname)
+	return true
+}
+
+// checkJoinFlood records a join to the channel, and if the channel is being
+// flooded, challenges the joiner; it returns whether the join may proceed.
+func (channel *Channel) checkJoinFlood(client *Client, rb *ResponseBuffer) bool {
+	config := &channel.server.Config().Channels.JoinFlood
+	if !config.Enabled {
+		return true
+	}
+	active, triggered := channel.touchJoinFlood(config)
+	if triggered {
+		channel.notifyJoinFlood(config)
+	}
+	if !active {
+		return true
+	}
+
+	chname := channel.Name()
+	chcfname := channel.NameCasefolded()
+	if client.consumeJoinChallenge(chcfname) || client.accountIsOlderThan(config.MinAccountAge) {
+		return true
+	}
+	switch config.Challenge {
+	case joinChallengeAccountAge:
+		rb.Add(nil, client.server.name, ERR_UNAVAILRESOURCE, client.Nick(), chname, fmt.Sprintf(client.t("Cannot join channel while it is being flooded, unless you are logged into an account registered at least %v ago"), config.MinAccountAge))
+	default:
+		token := client.joinChallengeToken(chcfname)
+		rb.Add(nil, client.server.name, ERR_UNAVAILRESOURCE, client.Nick(), chname, client.t("Cannot join channel while it is being flooded, without answering a challenge"))
+		rb.AddSplitMessageFromServer("ChanServ", "NOTICE", client.Nick(), utils.MakeSplitMessage(fmt.Sprintf(client.t("To join %[1]s, type: /msg ChanServ CHALLENGE %[1]s %[2]s"), chname, token)))
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoinFloodState(t *testing.T) {
+	var state joinFloodState
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if active, _ := state.touch(now, 3, time.Second, time.Minute); active {
+			t.Fatalf("challenge active after %d joins", i+1)
+		}
+	}
+	active, triggered := state.touch(now, 3, time.Second, time.Minute)
+	if !active || !triggered {
+		t.Errorf("flood should trigger the challenge")
+	}
+	active, triggered = state.touch(now.Add(10*time.Second), 3, time.Second, time.Minute)
+	if !active || triggered {
+		t.Errorf("challenge should remain active, without triggering again")
+	}
+	if active, _ = state.touch(now.Add(2*time.Minute), 3, time.Second, time.Minute); active {
+		t.Errorf("challenge should have expired")
+	}
+}
+
+func TestParseJoinFloodSettings(t *testing.T) {
+	settings, err := ParseJoinFloodSettings("5:10s")
+	if err != nil || settings.Joins != 5 || settings.Window != 10*time.Second {
+		t.Errorf("incorrect parse: %v %v", settings, err)
+	}
+	if settings.String() != "5:10s" {
+		t.Errorf("incorrect serialization: %s", settings.String())
+	}
+	settings, err = ParseJoinFloodSettings("OFF")
+	if err != nil || !settings.Disabled || settings.String() != "off" {
+		t.Errorf("incorrect parse: %v %v", settings, err)
+	}
+	for _, invalid := range []string{"5", "0:10s", "5:0s", "a:10s", "5:forever"} {
+		if _, err = ParseJoinFloodSettings(invalid); err == nil {
+			t.Errorf("invalid settings accepted: %s", invalid)
+		}
+	}
+}
+
+func TestJoinChallenge(t *testing.T) {
+	client := &Client{}
+	if client.consumeJoinChallenge("#chan") {
+		t.Errorf("client hasn't been challenged")
+	}
+	token := client.joinChallengeToken("#chan")
+	if len(token) != joinChallengeTokenLength || client.joinChallengeToken("#chan") != token {
+		t.Errorf("token should be stable until answered")
+	}
+	if client.answerJoinChallenge("#chan", "wrong") || client.answerJoinChallenge("#other", token) {
+		t.Errorf("incorrect answer was accepted")
+	}
+	if client.consumeJoinChallenge("#chan") {
+		t.Errorf("challenge wasn't answered")
+	}
+	if !client.answerJoinChallenge("#chan", token) {
+		t.Errorf("correct answer was rejected")
+	}
+	if !client.consumeJoinChallenge("#chan") || client.consumeJoinChallenge("#chan") {
+		t.Errorf("passed challenge should be usable exactly once")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// Package jwt verifies signed JSON Web Tokens (RFC 7519) in the JWS compact
+// serialization, using HMAC (HS256, HS384, HS512) or RSA (RS256, RS384, RS512).
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+
+	// register the hash functions
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrWrongAlgorithm   = errors.New("token was signed with an unexpected algorithm")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrExpired          = errors.New("token has expired or is not yet valid")
+	ErrInvalidKey       = errors.New("invalid key")
+
+	hashes = map[string]crypto.Hash{
+		"256": crypto.SHA256,
+		"384": crypto.SHA384,
+		"512": crypto.SHA512,
+	}
+)
+
+// Key is a key for verifying tokens, along with the algorithm it's used with.
+type Key struct {
+	algorithm  string
+	hash       crypto.Hash
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+}
+
+// NewHMACKey returns a key for verifying tokens signed with a shared secret;
+// `algorithm` is one of HS256, HS384, or HS512.
+func NewHMACKey(algorithm string, secret []byte) (key Key, err error) {
+	hash, ok := hashes[strings.TrimPrefix(algorithm, "HS")]
+	if !ok || !strings.HasPrefix(algorithm, "HS") || len(secret) == 0 {
+		return key, ErrInvalidKey
+	}
+	return Key{algorithm: algorithm, hash: hash, hmacSecret: secret}, nil
+}
+
+// NewRSAKey returns a key for verifying tokens signed with an RSA private key;
+// `algorithm` is one of RS256, RS384, or RS512, and `pemBytes` is the PEM-encoded
+// public key (either PKIX or PKCS #1).
+func NewRSAKey(algorithm string, pemBytes []byte) (key Key, err error) {
+	hash, ok := hashes[strings.TrimPrefix(algorithm, "RS")]
+	if !ok || !strings.HasPrefix(algorithm, "RS") {
+		return key, ErrInvalidKey
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return key, ErrInvalidKey
+	}
+	var rsaKey *rsa.PublicKey
+	if parsed, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		rsaKey, _ = parsed.(*rsa.PublicKey)
+	} else {
+		rsaKey, _ = x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	if rsaKey == nil {
+		return key, ErrInvalidKey
+	}
+	return Key{algorithm: algorithm, hash: hash, rsaKey: rsaKey}, nil
+}
+
+// Claims are the claims of a verified token.
+type Claims map[string]interface{}
+
+// String returns the value of a string-valued claim, or "" if it's missing or not a string.
+func (claims Claims) String(name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// HasAudience returns whether the token's `aud` claim includes `audience`.
+func (claims Claims) HasAudience(audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (claims Claims) timeClaim(name string) (result time.Time, present bool) {
+	value, present := claims[name].(float64)
+	if present {
+		result = time.Unix(int64(value), 0)
+	}
+	return
+}
+
+// Verify checks the token's signature with `key`, and checks that it has not
+// expired (tokens without an `exp` claim are rejected). It returns the token's claims.
+func Verify(token string, key Key, now time.Time) (claims Claims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(headerBytes, &header) != nil {
+		return nil, ErrMalformed
+	}
+	// checking the algorithm against the key prevents algorithm substitution attacks
+	if header.Alg != key.algorithm {
+		return nil, ErrWrongAlgorithm
+	}
+
+	signedContent := []byte(parts[0] + "." + parts[1])
+	if key.rsaKey != nil {
+		hasher := key.hash.New()
+		hasher.Write(signedContent)
+		if rsa.VerifyPKCS1v15(key.rsaKey, key.hash, hasher.Sum(nil), signature) != nil {
+			return nil, ErrInvalidSignature
+		}
+	} else {
+		mac := hmac.New(key.hash.New, key.hmacSecret)
+		mac.Write(signedContent)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return nil, ErrMalformed
+	}
+
+	expiration, present := claims.timeClaim("exp")
+	if !present || !now.Before(expiration) {
+		return nil, ErrExpired
+	}
+	if notBefore, present := claims.timeClaim("nbf"); present && now.Before(notBefore) {
+		return nil, ErrExpired
+	}
+	return claims, nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func encodeSegment(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func makeHS256Token(secret []byte, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMAC(t *testing.T) {
+	secret := []byte("hunter2")
+	key, err := NewHMACKey("HS256", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+
+	token := makeHS256Token(secret, map[string]interface{}{"sub": "dan", "aud": "oragono", "exp": now.Unix() + 60})
+	claims, err := Verify(token, key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != "dan" || !claims.HasAudience("oragono") || claims.HasAudience("other") {
+		t.Errorf("incorrect claims: %v", claims)
+	}
+
+	if _, err := Verify(token, key, now.Add(time.Hour)); err != ErrExpired {
+		t.Errorf("expired token was accepted: %v", err)
+	}
+
+	forged := makeHS256Token([]byte("hunter3"), map[string]interface{}{"sub": "dan", "exp": now.Unix() + 60})
+	if _, err := Verify(forged, key, now); err != ErrInvalidSignature {
+		t.Errorf("forged token was accepted: %v", err)
+	}
+
+	noExpiry := makeHS256Token(secret, map[string]interface{}{"sub": "dan"})
+	if _, err := Verify(noExpiry, key, now); err != ErrExpired {
+		t.Errorf("token without expiration was accepted: %v", err)
+	}
+
+	notYet := makeHS256Token(secret, map[string]interface{}{"sub": "dan", "nbf": now.Unix() + 30, "exp": now.Unix() + 60})
+	if _, err := Verify(notYet, key, now); err != ErrExpired {
+		t.Errorf("token that is not yet valid was accepted: %v", err)
+	}
+
+	unsigned := encodeSegment(map[string]string{"alg": "none"}) + "." + encodeSegment(map[string]interface{}{"sub": "dan", "exp": now.Unix() + 60}) + "."
+	if _, err := Verify(unsigned, key, now); err != ErrWrongAlgorithm {
+		t.Errorf("unsigned token was accepted: %v", err)
+	}
+}
+
+func TestRSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	key, err := NewRSAKey("RS256", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+
+	signed := encodeSegment(map[string]string{"alg": "RS256"}) + "." + encodeSegment(map[string]interface{}{"sub": "dan", "exp": now.Unix() + 60})
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	claims, err := Verify(token, key, now)
+	if err != nil || claims.String("sub") != "dan" {
+		t.Errorf("valid token was rejected: %v", err)
+	}
+
+	// an HMAC token "signed" with the public key must not be accepted
+	hmacToken := makeHS256Token(pubBytes, map[string]interface{}{"sub": "dan", "exp": now.Unix() + 60})
+	if _, err := Verify(hmacToken, key, now); err != ErrWrongAlgorithm {
+		t.Errorf("algorithm substitution was accepted: %v", err)
+	}
+}
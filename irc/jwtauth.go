@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/jwt"
+)
+
+// JWT authentication lets a web application that has already authenticated a user
+// log them into an account, by handing them a signed JSON Web Token to present
+// via the SASL mechanism JWT (the SASL payload is the token itself).
+
+var (
+	defaultJWTAccountClaims = []string{"account", "sub"}
+)
+
+// JWTAuthConfig controls SASL authentication with signed JSON Web Tokens.
+type JWTAuthConfig struct {
+	Enabled bool
+	// tokens are accepted if they verify against any of these
+	Tokens []JWTTokenConfig
+	// whether to create accounts that don't exist yet
+	Autocreate bool
+}
+
+// JWTTokenConfig describes one kind of acceptable token.
+type JWTTokenConfig struct {
+	// HS256, HS384, HS512, RS256, RS384, or RS512
+	Algorithm string
+	// shared secret, for the HS algorithms
+	Secret string
+	// file containing the PEM-encoded public key, for the RS algorithms
+	KeyFile string `yaml:"key-file"`
+	// if set, the token's `iss` and `aud` claims must match these
+	Issuer   string
+	Audience string
+	// claims to take the account name from, in order of preference
+	AccountClaims []string `yaml:"account-claims"`
+	key           jwt.Key
+}
+
+func (conf *JWTAuthConfig) prepare() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	if len(conf.Tokens) == 0 {
+		return fmt.Errorf("JWT authentication is enabled, but no tokens were configured")
+	}
+	for i := range conf.Tokens {
+		token := &conf.Tokens[i]
+		algorithm := strings.ToUpper(token.Algorithm)
+		if strings.HasPrefix(algorithm, "RS") {
+			var pemBytes []byte
+			pemBytes, err = ioutil.ReadFile(token.KeyFile)
+			if err == nil {
+				token.key, err = jwt.NewRSAKey(algorithm, pemBytes)
+			}
+		} else {
+			token.key, err = jwt.NewHMACKey(algorithm, []byte(token.Secret))
+		}
+		if err != nil {
+			return fmt.Errorf("Could not load JWT key %d (%s): %s", i, token.Algorithm, err.Error())
+		}
+		if len(token.AccountClaims) == 0 {
+			token.AccountClaims = defaultJWTAccountClaims
+		}
+	}
+	return nil
+}
+
+// accountName checks a token against this configuration, returning the
+// account name it authenticates.
+func (conf *JWTTokenConfig) accountName(token string, now time.Time) (accountName string, err error) {
+	claims, err := jwt.Verify(token, conf.key, now)
+	if err != nil {
+		return
+	}
+	if conf.Issuer != "" && claims.String("iss") != conf.Issuer {
+		return "", errAccountInvalidCredentials
+	}
+	if conf.Audience != "" && !claims.HasAudience(conf.Audience) {
+		return "", errAccountInvalidCredentials
+	}
+	for _, claim := range conf.AccountClaims {
+		if accountName = claims.String(claim); accountName != "" {
+			return
+		}
+	}
+	return "", errAccountInvalidCredentials
+}
+
+// AuthenticateByJWT logs the client into the account named by a signed token.
+func (am *AccountManager) AuthenticateByJWT(client *Client, token string) error {
+	config := am.server.AccountConfig().JWTAuth
+	if !config.Enabled {
+		return errFeatureDisabled
+	}
+
+	now := time.Now()
+	var accountName string
+	for i := range config.Tokens {
+		name, err := config.Tokens[i].accountName(token, now)
+		if err == nil {
+			accountName = name
+			break
+		}
+	}
+	if accountName == "" {
+		return errAccountInvalidCredentials
+	}
+
+	account, err := am.loadExternalAccount(accountName, config.Autocreate)
+	if err != nil {
+		return err
+	}
+	am.Login(client, account)
+	return nil
+}
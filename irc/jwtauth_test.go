@@ -0,0 +1,66 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func makeTestJWT(secret string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAccountName(t *testing.T) {
+	conf := JWTAuthConfig{
+		Enabled: true,
+		Tokens: []JWTTokenConfig{{
+			Algorithm: "HS256",
+			Secret:    "hunter2",
+			Issuer:    "https://example.com",
+			Audience:  "oragono",
+		}},
+	}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	tokenConf := &conf.Tokens[0]
+	now := time.Now()
+	exp := now.Add(time.Minute).Unix()
+
+	token := makeTestJWT("hunter2", map[string]interface{}{"iss": "https://example.com", "aud": "oragono", "sub": "dan", "exp": exp})
+	if name, err := tokenConf.accountName(token, now); name != "dan" || err != nil {
+		t.Errorf("valid token was rejected: %s %v", name, err)
+	}
+
+	// `account` is preferred over `sub` by default
+	token = makeTestJWT("hunter2", map[string]interface{}{"iss": "https://example.com", "aud": "oragono", "sub": "1234", "account": "dan", "exp": exp})
+	if name, _ := tokenConf.accountName(token, now); name != "dan" {
+		t.Errorf("incorrect account name: %s", name)
+	}
+
+	token = makeTestJWT("hunter2", map[string]interface{}{"iss": "https://evil.com", "aud": "oragono", "sub": "dan", "exp": exp})
+	if _, err := tokenConf.accountName(token, now); err == nil {
+		t.Errorf("token with the wrong issuer was accepted")
+	}
+
+	token = makeTestJWT("hunter2", map[string]interface{}{"iss": "https://example.com", "aud": "other", "sub": "dan", "exp": exp})
+	if _, err := tokenConf.accountName(token, now); err == nil {
+		t.Errorf("token with the wrong audience was accepted")
+	}
+
+	token = makeTestJWT("hunter2", map[string]interface{}{"iss": "https://example.com", "aud": "oragono", "exp": exp})
+	if _, err := tokenConf.accountName(token, now); err == nil {
+		t.Errorf("token without an account name was accepted")
+	}
+}
@@ -7,10 +7,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/goshuirc/irc-go/ircmatch"
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/utils"
 	"github.com/tidwall/buntdb"
 )
 
@@ -23,26 +23,37 @@ type KLineInfo struct {
 	// Mask that is blocked.
 	Mask string
 	// Matcher, to facilitate fast matching.
-	Matcher ircmatch.Matcher
+	Matcher *utils.Glob
 	// Info contains information on the ban.
 	Info IPBanInfo
 }
 
 // KLineManager manages and klines.
 type KLineManager struct {
-	sync.RWMutex                // tier 1
-	persistenceMutex sync.Mutex // tier 2
+	lockorder.Tier1RWMutex
+	persistenceMutex lockorder.Tier2Mutex
 	// kline'd entries
 	entries          map[string]KLineInfo
-	expirationTimers map[string]*time.Timer
+	expirationTimers map[string]*utils.WheelTimer
 	server           *Server
 }
 
+// canonicalizeKlineMask lowercases a KLINE mask and fills in its missing parts.
+func canonicalizeKlineMask(mask string) string {
+	mask = strings.ToLower(mask)
+	if !strings.Contains(mask, "!") && !strings.Contains(mask, "@") {
+		mask = mask + "!*@*"
+	} else if !strings.Contains(mask, "@") {
+		mask = mask + "@*"
+	}
+	return mask
+}
+
 // NewKLineManager returns a new KLineManager.
 func NewKLineManager(s *Server) *KLineManager {
 	var km KLineManager
 	km.entries = make(map[string]KLineInfo)
-	km.expirationTimers = make(map[string]*time.Timer)
+	km.expirationTimers = make(map[string]*utils.WheelTimer)
 	km.server = s
 
 	km.loadFromDatastore()
@@ -65,9 +76,6 @@ func (km *KLineManager) AllBans() map[string]IPBanInfo {
 
 // AddMask adds to the blocked list.
 func (km *KLineManager) AddMask(mask string, duration time.Duration, reason, operReason, operName string) error {
-	km.persistenceMutex.Lock()
-	defer km.persistenceMutex.Unlock()
-
 	info := IPBanInfo{
 		Reason:      reason,
 		OperReason:  operReason,
@@ -75,6 +83,13 @@ func (km *KLineManager) AddMask(mask string, duration time.Duration, reason, ope
 		TimeCreated: time.Now(),
 		Duration:    duration,
 	}
+	return km.addMask(mask, info)
+}
+
+func (km *KLineManager) addMask(mask string, info IPBanInfo) error {
+	km.persistenceMutex.Lock()
+	defer km.persistenceMutex.Unlock()
+
 	km.addMaskInternal(mask, info)
 	return km.persistKLine(mask, info)
 }
@@ -82,7 +97,7 @@ func (km *KLineManager) AddMask(mask string, duration time.Duration, reason, ope
 func (km *KLineManager) addMaskInternal(mask string, info IPBanInfo) {
 	kln := KLineInfo{
 		Mask:    mask,
-		Matcher: ircmatch.MakeMatch(mask),
+		Matcher: utils.NewGlob(mask),
 		Info:    info,
 	}
 
@@ -116,7 +131,7 @@ func (km *KLineManager) addMaskInternal(mask string, info IPBanInfo) {
 			delete(km.expirationTimers, mask)
 		}
 	}
-	km.expirationTimers[mask] = time.AfterFunc(timeLeft, processExpiration)
+	km.expirationTimers[mask] = timerWheel.AfterFunc(timeLeft, processExpiration)
 }
 
 func (km *KLineManager) cancelTimer(id string) {
@@ -189,7 +204,7 @@ func (km *KLineManager) CheckMasks(masks ...string) (isBanned bool, info IPBanIn
 
 	for _, entryInfo := range km.entries {
 		for _, mask := range masks {
-			if entryInfo.Matcher.Match(mask) {
+			if entryInfo.Matcher.MatchString(mask) {
 				return true, entryInfo.Info
 			}
 		}
@@ -0,0 +1,150 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/modes"
+)
+
+// KNOCK lets users ask the operators of a channel they can't join (because
+// it's invite-only, keyed, or full) to invite them. Knocks are rate-limited
+// both per user and per channel, and channels can refuse them with +K.
+
+const (
+	knockDeliveryNotice  = "notice"
+	knockDeliveryNumeric = "numeric"
+
+	defaultKnockUserDelay    = time.Minute
+	defaultKnockChannelDelay = 10 * time.Second
+)
+
+// KnockConfig controls the KNOCK command.
+type KnockConfig struct {
+	Enabled bool
+	// minimum time between knocks by the same user (on any channel)
+	UserDelay time.Duration `yaml:"user-delay"`
+	// minimum time between knocks on the same channel (by anyone)
+	ChannelDelay time.Duration `yaml:"channel-delay"`
+	// how knocks are delivered to channel operators: "notice" or "numeric"
+	Delivery string
+}
+
+func (conf *KnockConfig) prepare() error {
+	if conf.UserDelay == 0 {
+		conf.UserDelay = defaultKnockUserDelay
+	}
+	if conf.ChannelDelay == 0 {
+		conf.ChannelDelay = defaultKnockChannelDelay
+	}
+	switch conf.Delivery {
+	case "":
+		conf.Delivery = knockDeliveryNotice
+	case knockDeliveryNotice, knockDeliveryNumeric:
+	default:
+		return fmt.Errorf("Unknown knock delivery method: %s", conf.Delivery)
+	}
+	return nil
+}
+
+// touchKnock records a knock if enough time has passed since the last one,
+// returning whether it's allowed.
+func touchKnock(last *time.Time, now time.Time, delay time.Duration) bool {
+	if now.Sub(*last) < delay {
+		return false
+	}
+	*last = now
+	return true
+}
+
+func (client *Client) touchKnock(now time.Time, delay time.Duration) bool {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	return touchKnock(&client.lastKnock, now, delay)
+}
+
+func (channel *Channel) touchKnock(now time.Time, delay time.Duration) bool {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	return touchKnock(&channel.lastKnock, now, delay)
+}
+
+// isClosed returns whether the channel is invite-only, keyed, or full (and so
+// whether knocking on it makes sense).
+func (channel *Channel) isClosed() bool {
+	channel.stateMutex.RLock()
+	key := channel.key
+	limit := channel.userLimit
+	channel.stateMutex.RUnlock()
+
+	return channel.flags.HasMode(modes.InviteOnly) || key != "" || (limit != 0 && limit <= channel.members.Len())
+}
+
+// KNOCK <channel> [<message>]
+func knockHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	config := server.Config()
+	nick := client.Nick()
+	if !config.Channels.Knock.Enabled {
+		rb.Add(nil, server.name, ERR_UNKNOWNCOMMAND, nick, "KNOCK", client.t("Unknown command"))
+		return false
+	}
+
+	channel := server.channels.Get(msg.Params[0])
+	if channel == nil {
+		rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, nick, msg.Params[0], client.t("No such channel"))
+		return false
+	}
+	chname := channel.Name()
+
+	if channel.hasClient(client) {
+		rb.Add(nil, server.name, ERR_KNOCKONCHAN, nick, chname, client.t("You're already on that channel"))
+		return false
+	}
+	if !channel.isClosed() {
+		rb.Add(nil, server.name, ERR_CHANOPEN, nick, chname, client.t("Channel is open"))
+		return false
+	}
+	if channel.flags.HasMode(modes.NoKnock) {
+		rb.Add(nil, server.name, ERR_CANNOTKNOCK, nick, chname, fmt.Sprintf(client.t("Cannot knock on channel (+%s)"), "K"))
+		return false
+	}
+	nickMask := client.NickMaskString()
+	if channel.lists[modes.BanMask].Match(client.NickMaskCasefolded()) && !channel.lists[modes.ExceptMask].Match(client.NickMaskCasefolded()) {
+		rb.Add(nil, server.name, ERR_CANNOTKNOCK, nick, chname, client.t("You're banned from that channel"))
+		return false
+	}
+
+	now := time.Now()
+	if !client.touchKnock(now, config.Channels.Knock.UserDelay) {
+		rb.Add(nil, server.name, ERR_TOOMANYKNOCK, nick, chname, client.t("Too many KNOCKs (user)"))
+		return false
+	}
+	if !channel.touchKnock(now, config.Channels.Knock.ChannelDelay) {
+		rb.Add(nil, server.name, ERR_TOOMANYKNOCK, nick, chname, client.t("Too many KNOCKs (channel)"))
+		return false
+	}
+
+	var message string
+	if 1 < len(msg.Params) {
+		message = msg.Params[1]
+	}
+	notices := make(splitMessageCache)
+	for _, member := range channel.Members() {
+		if !channel.ClientIsAtLeast(member, modes.ChannelOperator) {
+			continue
+		}
+		if config.Channels.Knock.Delivery == knockDeliveryNumeric {
+			member.Send(nil, server.name, RPL_KNOCK, member.Nick(), chname, nickMask, member.t("has asked for an invite"))
+		} else if message != "" {
+			member.SendSplitMsgFromServer(server.name, "NOTICE", chname, notices.get(fmt.Sprintf(member.t("[Knock] by %[1]s (%[2]s)"), nickMask, message)))
+		} else {
+			member.SendSplitMsgFromServer(server.name, "NOTICE", chname, notices.get(fmt.Sprintf(member.t("[Knock] by %s"), nickMask)))
+		}
+	}
+	rb.Add(nil, server.name, RPL_KNOCKDLVR, nick, chname, client.t("Your KNOCK has been delivered"))
+	return false
+}
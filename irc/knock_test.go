@@ -0,0 +1,38 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTouchKnock(t *testing.T) {
+	var last time.Time
+	now := time.Now()
+	if !touchKnock(&last, now, time.Minute) {
+		t.Errorf("first knock should be allowed")
+	}
+	if touchKnock(&last, now.Add(30*time.Second), time.Minute) {
+		t.Errorf("knock within the delay should be refused")
+	}
+	// refused knocks don't restart the delay
+	if !touchKnock(&last, now.Add(time.Minute), time.Minute) {
+		t.Errorf("knock after the delay should be allowed")
+	}
+}
+
+func TestKnockConfig(t *testing.T) {
+	var conf KnockConfig
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.UserDelay != defaultKnockUserDelay || conf.ChannelDelay != defaultKnockChannelDelay || conf.Delivery != knockDeliveryNotice {
+		t.Errorf("incorrect defaults: %#v", conf)
+	}
+	conf.Delivery = "carrier-pigeon"
+	if err := conf.prepare(); err == nil {
+		t.Errorf("unknown delivery method should be rejected")
+	}
+}
@@ -196,6 +196,32 @@ func (lm *Manager) Codes(codes []string) []string {
 	return newCodes
 }
 
+// Normalize casefolds a list of requested language codes and removes duplicates
+// and empty entries, preserving their order. If any of the codes isn't supported,
+// it's returned as `unknown`.
+func (lm *Manager) Normalize(codes []string) (result []string, unknown string) {
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		// strip ~ from the language if it has it
+		code = strings.TrimPrefix(strings.ToLower(code), "~")
+
+		// silently ignore empty languages or those with spaces in them
+		if len(code) == 0 || strings.Contains(code, " ") {
+			continue
+		}
+
+		if _, exists := lm.Languages[code]; !exists {
+			return nil, code
+		}
+
+		if !seen[code] {
+			seen[code] = true
+			result = append(result, code)
+		}
+	}
+	return
+}
+
 // Translate returns the given string, translated into the given language.
 func (lm *Manager) Translate(languages []string, originalString string) string {
 	// not using any special languages
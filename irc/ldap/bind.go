@@ -0,0 +1,198 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// Package ldap implements just enough of LDAPv3 (RFC 4511) to check a
+// password with a simple bind.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// maximum size of a response we're willing to read
+	maxResponseLength = 1 << 16
+
+	tagBindRequest  = 0
+	tagBindResponse = 1
+	tagUnbind       = 2
+
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+var (
+	// ErrInvalidCredentials means the server rejected the DN or password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	errEmptyPassword      = errors.New("refusing to perform an unauthenticated bind")
+	errMalformedResponse  = errors.New("malformed LDAP response")
+)
+
+// Config describes how to connect to an LDAP server.
+type Config struct {
+	// host:port of the server
+	Server string
+	// whether to connect with TLS (ldaps)
+	TLS bool
+	// skip TLS certificate verification; don't use this in production
+	InsecureSkipVerify bool `yaml:"insecure-skip-verify"`
+	Timeout            time.Duration
+}
+
+// EscapeDN escapes a string for use as an attribute value inside a DN (RFC 4514).
+func EscapeDN(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;=", c) != -1:
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == 0 || (i == 0 && (c == ' ' || c == '#')) || (i == len(value)-1 && c == ' '):
+			fmt.Fprintf(&buf, "\\%02x", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// Bind connects to the server and performs a simple bind as `dn`, returning nil
+// if the password was accepted and ErrInvalidCredentials if it was rejected.
+func Bind(config Config, dn, password string) (err error) {
+	if password == "" {
+		return errEmptyPassword
+	}
+
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	var conn net.Conn
+	if config.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", config.Server, &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify})
+	} else {
+		conn, err = dialer.Dial("tcp", config.Server)
+	}
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if config.Timeout != 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+
+	request, err := marshalBindRequest(1, dn, password)
+	if err != nil {
+		return
+	}
+	if _, err = conn.Write(request); err != nil {
+		return
+	}
+	response, err := readMessage(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	resultCode, err := parseBindResponse(response)
+	if err != nil {
+		return
+	}
+
+	// be polite and unbind; we don't care whether this succeeds
+	if unbind, err := marshalMessage(2, asn1.RawValue{Class: asn1.ClassApplication, Tag: tagUnbind}); err == nil {
+		conn.Write(unbind)
+	}
+
+	switch resultCode {
+	case resultSuccess:
+		return nil
+	case resultInvalidCredentials:
+		return ErrInvalidCredentials
+	default:
+		return fmt.Errorf("LDAP bind failed with result code %d", resultCode)
+	}
+}
+
+func marshalMessage(messageID int, op asn1.RawValue) ([]byte, error) {
+	return asn1.Marshal(struct {
+		MessageID int
+		Op        asn1.RawValue
+	}{messageID, op})
+}
+
+func marshalBindRequest(messageID int, dn, password string) ([]byte, error) {
+	var body []byte
+	for _, value := range []interface{}{
+		3, // LDAP version
+		[]byte(dn),
+		asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(password)}, // simple authentication
+	} {
+		encoded, err := asn1.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, encoded...)
+	}
+	return marshalMessage(messageID, asn1.RawValue{Class: asn1.ClassApplication, Tag: tagBindRequest, IsCompound: true, Bytes: body})
+}
+
+// readMessage reads a single BER-encoded LDAPMessage, which must have a definite length.
+func readMessage(reader *bufio.Reader) (message []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+	if header[0] != 0x30 {
+		return nil, errMalformedResponse
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		if numBytes == 0 || 4 < numBytes {
+			return nil, errMalformedResponse
+		}
+		lengthBytes := make([]byte, numBytes)
+		if _, err = io.ReadFull(reader, lengthBytes); err != nil {
+			return
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = (length << 8) | int(b)
+		}
+	}
+	if maxResponseLength < length {
+		return nil, errMalformedResponse
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		return
+	}
+	return append(header, body...), nil
+}
+
+func parseBindResponse(message []byte) (resultCode int, err error) {
+	var envelope struct {
+		MessageID int
+		Op        asn1.RawValue
+	}
+	if _, err = asn1.Unmarshal(message, &envelope); err != nil {
+		return 0, errMalformedResponse
+	}
+	if envelope.Op.Class != asn1.ClassApplication || envelope.Op.Tag != tagBindResponse {
+		return 0, errMalformedResponse
+	}
+	var result asn1.RawValue
+	if _, err = asn1.Unmarshal(envelope.Op.Bytes, &result); err != nil || result.Tag != asn1.TagEnum {
+		return 0, errMalformedResponse
+	}
+	for _, b := range result.Bytes {
+		resultCode = (resultCode << 8) | int(b)
+	}
+	return resultCode, nil
+}
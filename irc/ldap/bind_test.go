@@ -0,0 +1,85 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package ldap
+
+import (
+	"bufio"
+	"encoding/asn1"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEscapeDN(t *testing.T) {
+	cases := map[string]string{
+		"slingamn":  "slingamn",
+		"a,b=c":     "a\\,b\\=c",
+		" leading":  "\\20leading",
+		"trailing ": "trailing\\20",
+		"#hash":     "\\23hash",
+		"back\\":    "back\\\\",
+	}
+	for input, expected := range cases {
+		if escaped := EscapeDN(input); escaped != expected {
+			t.Errorf("expected %s to escape to %s, got %s", input, expected, escaped)
+		}
+	}
+}
+
+// fakeServer accepts one connection, checks the bind request, and responds with resultCode.
+func fakeServer(t *testing.T, password string) (addr string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := readMessage(bufio.NewReader(conn))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resultCode := resultInvalidCredentials
+		expected, _ := marshalBindRequest(1, "uid=dan,dc=example,dc=com", password)
+		if string(request) == string(expected) {
+			resultCode = resultSuccess
+		}
+
+		var body []byte
+		for _, value := range []interface{}{
+			asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagEnum, Bytes: []byte{byte(resultCode)}},
+			[]byte(""),
+			[]byte(""),
+		} {
+			encoded, _ := asn1.Marshal(value)
+			body = append(body, encoded...)
+		}
+		response, _ := marshalMessage(1, asn1.RawValue{Class: asn1.ClassApplication, Tag: tagBindResponse, IsCompound: true, Bytes: body})
+		conn.Write(response)
+	}()
+	return listener.Addr().String()
+}
+
+func TestBind(t *testing.T) {
+	config := Config{Timeout: 5 * time.Second}
+
+	config.Server = fakeServer(t, "hunter2")
+	if err := Bind(config, "uid=dan,dc=example,dc=com", "hunter2"); err != nil {
+		t.Errorf("bind with the correct password failed: %v", err)
+	}
+
+	config.Server = fakeServer(t, "hunter2")
+	if err := Bind(config, "uid=dan,dc=example,dc=com", "hunter3"); err != ErrInvalidCredentials {
+		t.Errorf("bind with the wrong password returned %v", err)
+	}
+
+	if err := Bind(config, "uid=dan,dc=example,dc=com", ""); err == nil {
+		t.Errorf("unauthenticated bind should be refused")
+	}
+}
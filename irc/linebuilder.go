@@ -0,0 +1,192 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+)
+
+// lineBuilder assembles outgoing lines. Assembling a line with ircmsg means
+// building an IrcMessage, with its tag maps, and then copying those maps again
+// when serializing it; on the hot path (relaying a message to every member of
+// a channel) this accounts for most of the allocations. lineBuilders instead
+// write tags and parameters directly into a reusable buffer, taken from a pool,
+// so that the only allocation per line is the final copy handed to the socket.
+type lineBuilder struct {
+	buf []byte
+	// length of the tag section, including the leading '@'
+	tagsLen int
+}
+
+const (
+	lineBuilderInitialSize = 512
+	// don't return builders with huge buffers to the pool
+	lineBuilderMaxPooledSize = 16384
+)
+
+var lineBuilderPool = sync.Pool{
+	New: func() interface{} {
+		return &lineBuilder{buf: make([]byte, 0, lineBuilderInitialSize)}
+	},
+}
+
+func getLineBuilder() *lineBuilder {
+	return lineBuilderPool.Get().(*lineBuilder)
+}
+
+// release returns the builder to the pool; it must not be used afterwards.
+func (lb *lineBuilder) release() {
+	if lineBuilderMaxPooledSize < cap(lb.buf) {
+		return
+	}
+	lb.buf = lb.buf[:0]
+	lb.tagsLen = 0
+	lineBuilderPool.Put(lb)
+}
+
+func (lb *lineBuilder) startTag(name string) {
+	if lb.tagsLen == 0 {
+		lb.buf = append(lb.buf, '@')
+	} else {
+		lb.buf = append(lb.buf, ';')
+	}
+	lb.buf = append(lb.buf, name...)
+}
+
+// endTag checks the tag that was just written against the tags' own limit of
+// MaxlenTags bytes (tags don't count against the line length limit); if the
+// tag exceeds it, the tag is dropped rather than the whole line.
+func (lb *lineBuilder) endTag(start, tagsLen int) {
+	// leave room for the space that ends the tags
+	if ircmsg.MaxlenTags < len(lb.buf)+1 {
+		lb.buf, lb.tagsLen = lb.buf[:start], tagsLen
+		return
+	}
+	lb.tagsLen = len(lb.buf)
+}
+
+// validTagName returns whether `name` is a valid tag name: an optional client-only
+// prefix (+), an optional vendor (a hostname) followed by a slash, and a nonempty
+// name made of letters, digits, and hyphens.
+func validTagName(name string) bool {
+	name = strings.TrimPrefix(name, "+")
+	if slash := strings.LastIndexByte(name, '/'); slash != -1 {
+		vendor := name[:slash]
+		name = name[slash+1:]
+		if vendor == "" {
+			return false
+		}
+		for i := 0; i < len(vendor); i++ {
+			if !isTagNameByte(vendor[i]) && vendor[i] != '.' {
+				return false
+			}
+		}
+	}
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTagNameByte(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTagNameByte(char byte) bool {
+	return ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9') || char == '-'
+}
+
+// addTag adds a message tag, escaping its value. A tag with an invalid name,
+// or that doesn't fit within MaxlenTags, is dropped.
+func (lb *lineBuilder) addTag(name, value string) {
+	if !validTagName(name) {
+		return
+	}
+	start, tagsLen := len(lb.buf), lb.tagsLen
+	lb.startTag(name)
+	if value != "" {
+		lb.buf = append(lb.buf, '=')
+		for i := 0; i < len(value); i++ {
+			switch value[i] {
+			case ';':
+				lb.buf = append(lb.buf, '\\', ':')
+			case ' ':
+				lb.buf = append(lb.buf, '\\', 's')
+			case '\\':
+				lb.buf = append(lb.buf, '\\', '\\')
+			case '\r':
+				lb.buf = append(lb.buf, '\\', 'r')
+			case '\n':
+				lb.buf = append(lb.buf, '\\', 'n')
+			default:
+				lb.buf = append(lb.buf, value[i])
+			}
+		}
+	}
+	lb.endTag(start, tagsLen)
+}
+
+// addTimeTag adds a server-time tag, without allocating a string for it.
+func (lb *lineBuilder) addTimeTag(serverTime time.Time) {
+	start, tagsLen := len(lb.buf), lb.tagsLen
+	lb.startTag("time")
+	lb.buf = append(lb.buf, '=')
+	lb.buf = serverTime.UTC().AppendFormat(lb.buf, IRCv3TimestampFormat)
+	lb.endTag(start, tagsLen)
+}
+
+// finish writes the rest of the message and returns the finished line, which
+// is a copy owned by the caller. The part of the line after the tags is truncated
+// to `maxlenRest` bytes (including the trailing \r\n), if nonzero, without
+// splitting a UTF-8 character. If
+// `forceTrailing` is set, the last parameter is always sent as a trailing
+// parameter, since some clients treat trailing parameters specially.
+func (lb *lineBuilder) finish(prefix, command string, params []string, forceTrailing bool, maxlenRest int) ([]byte, error) {
+	if command == "" {
+		return nil, ircmsg.ErrorCommandMissing
+	}
+	if lb.tagsLen != 0 {
+		lb.buf = append(lb.buf, ' ')
+	}
+	restStart := len(lb.buf)
+
+	if prefix != "" {
+		lb.buf = append(lb.buf, ':')
+		lb.buf = append(lb.buf, prefix...)
+		lb.buf = append(lb.buf, ' ')
+	}
+	lb.buf = append(lb.buf, command...)
+	for i, param := range params {
+		lb.buf = append(lb.buf, ' ')
+		trailing := param == "" || param[0] == ':' || strings.IndexByte(param, ' ') != -1
+		if i == len(params)-1 {
+			if trailing || forceTrailing {
+				lb.buf = append(lb.buf, ':')
+			}
+		} else if trailing {
+			return nil, ircmsg.ErrorBadParam
+		}
+		lb.buf = append(lb.buf, param...)
+	}
+
+	if maxlenRest != 0 && restStart+maxlenRest-2 < len(lb.buf) {
+		end := restStart + maxlenRest - 2
+		// back up to the start of the character that would be split, if any
+		for i := 0; i < utf8.UTFMax-1 && restStart < end && !utf8.RuneStart(lb.buf[end]); i++ {
+			end--
+		}
+		lb.buf = lb.buf[:end]
+	}
+	lb.buf = append(lb.buf, '\r', '\n')
+
+	line := make([]byte, len(lb.buf))
+	copy(line, lb.buf)
+	return line, nil
+}
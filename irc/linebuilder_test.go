@@ -0,0 +1,162 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+func buildTestLine(tags [][2]string, prefix, command string, params []string, forceTrailing bool, maxlenRest int) (string, error) {
+	lb := getLineBuilder()
+	defer lb.release()
+	for _, tag := range tags {
+		lb.addTag(tag[0], tag[1])
+	}
+	line, err := lb.finish(prefix, command, params, forceTrailing, maxlenRest)
+	return string(line), err
+}
+
+func TestLineBuilder(t *testing.T) {
+	line, err := buildTestLine(nil, "alice!a@example.com", "PRIVMSG", []string{"#chan", "hi"}, true, 0)
+	if err != nil || line != ":alice!a@example.com PRIVMSG #chan :hi\r\n" {
+		t.Errorf("incorrect line: %q %v", line, err)
+	}
+	line, _ = buildTestLine(nil, "", "MODE", []string{"#chan", "+o", "bob"}, false, 0)
+	if line != "MODE #chan +o bob\r\n" {
+		t.Errorf("incorrect line: %q", line)
+	}
+	line, _ = buildTestLine(nil, "", "AWAY", []string{""}, false, 0)
+	if line != "AWAY :\r\n" {
+		t.Errorf("incorrect line: %q", line)
+	}
+
+	line, _ = buildTestLine([][2]string{{"account", "alice"}, {"+draft/reply", "a b;c\\d"}, {"+flag", ""}}, "", "TAGMSG", []string{"#chan"}, false, 0)
+	if line != "@account=alice;+draft/reply=a\\sb\\:c\\\\d;+flag TAGMSG #chan\r\n" {
+		t.Errorf("incorrect escaping: %q", line)
+	}
+
+	// the tags don't count against the length limit
+	line, _ = buildTestLine([][2]string{{"account", "alice"}}, "", "NOTICE", []string{"bob", strings.Repeat("a", 20)}, false, 20)
+	if line != "@account=alice NOTICE bob aaaaaaa\r\n" {
+		t.Errorf("incorrect truncation: %q", line)
+	}
+
+	if _, err = buildTestLine(nil, "", "PRIVMSG", []string{"bad param", "hi"}, false, 0); err != ircmsg.ErrorBadParam {
+		t.Errorf("invalid param was accepted")
+	}
+	if _, err = buildTestLine(nil, "", "", nil, false, 0); err != ircmsg.ErrorCommandMissing {
+		t.Errorf("missing command was accepted")
+	}
+	// tags that would exceed the tag budget are dropped
+	line, err = buildTestLine([][2]string{{"account", "alice"}, {"+big", strings.Repeat("a", ircmsg.MaxlenTags)}, {"+small", "1"}}, "", "TAGMSG", []string{"#chan"}, false, 0)
+	if err != nil || line != "@account=alice;+small=1 TAGMSG #chan\r\n" {
+		t.Errorf("oversized tag wasn't dropped: %q %v", line, err)
+	}
+	// so are tags with invalid names
+	line, _ = buildTestLine([][2]string{{"bad name", "1"}, {"+", "1"}, {"/x", "1"}, {"a=b", "1"}, {"+example.com/x-y", "1"}}, "", "TAGMSG", []string{"#chan"}, false, 0)
+	if line != "@+example.com/x-y=1 TAGMSG #chan\r\n" {
+		t.Errorf("invalid tag names weren't dropped: %q", line)
+	}
+
+	// truncation doesn't split UTF-8 characters
+	line, _ = buildTestLine(nil, "", "NOTICE", []string{"bob", "aa\xe2\x98\x83"}, false, 16)
+	if line != "NOTICE bob aa\r\n" {
+		t.Errorf("incorrect truncation: %q", line)
+	}
+}
+
+// worst-case prefixes and targets must not push relayed lines over the limit
+func TestSplitMessageLineBudget(t *testing.T) {
+	nickmask := strings.Repeat("n", 32) + "!~" + strings.Repeat("u", 10) + "@" + strings.Repeat("h", 63)
+	target := "#" + strings.Repeat("c", 63)
+	client := &Client{maxlenRest: 512}
+	messages := []string{
+		// the longest message a client with 512-byte lines can send
+		strings.Repeat("word ", 100)[:512-len("PRIVMSG  :\r\n")-len(target)],
+		// a message from a client with long lines, including multibyte characters
+		strings.Repeat("\xe2\x98\x83 hello ", 200),
+		strings.Repeat("x", 1500),
+	}
+	for _, text := range messages {
+		message := utils.MakeSplitMessage(text)
+		wrapped := client.wrapSplitMessage(nickmask, "PRIVMSG", target, &message)
+		if wrapped == nil {
+			t.Fatalf("message of length %d should have been wrapped", len(text))
+		}
+		var reconstructed string
+		for _, pair := range wrapped {
+			lb := getLineBuilder()
+			lb.addTag("account", "alice")
+			lb.addTag("draft/msgid", pair.Msgid)
+			line, err := lb.finish(nickmask, "PRIVMSG", []string{target, pair.Message}, true, 0)
+			lb.release()
+			rest := string(line[strings.IndexByte(string(line), ' ')+1:])
+			if err != nil || 512 < len(rest) {
+				t.Errorf("line too long (%d bytes): %q", len(rest), rest)
+			}
+			reconstructed += pair.Message
+		}
+		if reconstructed != text {
+			t.Errorf("message was altered by wrapping")
+		}
+	}
+}
+
+func TestLineBuilderTimeTag(t *testing.T) {
+	lb := getLineBuilder()
+	defer lb.release()
+	serverTime := time.Date(2019, 3, 1, 12, 30, 0, 500000000, time.FixedZone("EST", -5*3600))
+	lb.addTimeTag(serverTime)
+	line, _ := lb.finish("", "PING", []string{"x"}, false, 0)
+	if string(line) != "@time=2019-03-01T17:30:00.500Z PING x\r\n" {
+		t.Errorf("incorrect time tag: %q", line)
+	}
+
+	// like other tags, the time tag is dropped if it would exceed the tag budget
+	lb.buf, lb.tagsLen = lb.buf[:0], 0
+	lb.addTag("+big", strings.Repeat("a", ircmsg.MaxlenTags-len("@+big= ")))
+	lb.addTimeTag(serverTime)
+	line, _ = lb.finish("", "PING", []string{"x"}, false, 0)
+	if strings.Contains(string(line), "time=") || ircmsg.MaxlenTags < strings.IndexByte(string(line), ' ')+1 {
+		t.Errorf("time tag exceeded the tag budget")
+	}
+}
+
+var (
+	benchmarkTags   = map[string]string{"+draft/reply": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}
+	benchmarkParams = []string{"#chan", "this is a fairly typical message that someone might send to a channel"}
+)
+
+func BenchmarkLineBuilder(b *testing.B) {
+	b.ReportAllocs()
+	serverTime := time.Now()
+	for i := 0; i < b.N; i++ {
+		lb := getLineBuilder()
+		for name, value := range benchmarkTags {
+			lb.addTag(name, value)
+		}
+		lb.addTag("account", "alice")
+		lb.addTag("draft/msgid", "h6kmbqtrnr4aycb5hahd7v6uh2")
+		lb.addTimeTag(serverTime)
+		lb.finish("alice!alice@example.com", "PRIVMSG", benchmarkParams, true, 512)
+		lb.release()
+	}
+}
+
+func BenchmarkIrcmsgLine(b *testing.B) {
+	b.ReportAllocs()
+	serverTime := time.Now()
+	for i := 0; i < b.N; i++ {
+		msg := ircmsg.MakeMessage(benchmarkTags, "alice!alice@example.com", "PRIVMSG", benchmarkParams...)
+		msg.SetTag("account", "alice")
+		msg.SetTag("draft/msgid", "h6kmbqtrnr4aycb5hahd7v6uh2")
+		msg.SetTag("time", serverTime.UTC().Format(IRCv3TimestampFormat))
+		msg.LineBytesStrict(false, 512)
+	}
+}
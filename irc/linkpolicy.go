@@ -0,0 +1,141 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// Links are the most common vector for spam in channels, so founders can
+// restrict who may send messages containing them (see urlRegex) to their
+// channels: only users who are logged into accounts, only members who joined
+// the channel at least a while ago, and at most a few such messages per member
+// per minute. Members with voice or higher are exempt. Blocked PRIVMSGs get a
+// FAIL LINK_BLOCKED reply; blocked NOTICEs are dropped silently.
+
+const (
+	linkRateWindow = time.Minute
+)
+
+var (
+	errLinkNeedsAccount = errors.New("You must be logged into an account to post links in this channel")
+	errLinkTooSoon      = errors.New("You haven't been in this channel long enough to post links")
+	errLinkRateLimited  = errors.New("You are posting links too quickly")
+)
+
+// LinkPolicy is a channel's restrictions on messages containing links. The
+// zero value doesn't restrict anything.
+type LinkPolicy struct {
+	RequireAccount bool
+	MinJoinAge     time.Duration
+	// messages with links that each member can send per minute (0 for no limit)
+	PerMinute int
+}
+
+// IsEmpty returns whether the policy doesn't restrict anything.
+func (policy LinkPolicy) IsEmpty() bool {
+	return !policy.RequireAccount && policy.MinJoinAge == 0 && policy.PerMinute == 0
+}
+
+// String formats the policy as it's entered in /CS SET LINKS.
+func (policy LinkPolicy) String() string {
+	var fields []string
+	if policy.RequireAccount {
+		fields = append(fields, "account")
+	}
+	if policy.MinJoinAge != 0 {
+		fields = append(fields, fmt.Sprintf("age=%v", policy.MinJoinAge))
+	}
+	if policy.PerMinute != 0 {
+		fields = append(fields, fmt.Sprintf("rate=%d", policy.PerMinute))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ParseLinkPolicy parses a policy of the form `[account] [age=duration] [rate=N]`;
+// the empty string is the empty policy.
+func ParseLinkPolicy(str string) (policy LinkPolicy, err error) {
+	for _, field := range strings.Fields(str) {
+		lowered := strings.ToLower(field)
+		switch {
+		case lowered == "account":
+			policy.RequireAccount = true
+		case strings.HasPrefix(lowered, "age="):
+			policy.MinJoinAge, err = time.ParseDuration(lowered[len("age="):])
+			if err != nil || policy.MinJoinAge <= 0 {
+				return LinkPolicy{}, errInvalidParams
+			}
+		case strings.HasPrefix(lowered, "rate="):
+			policy.PerMinute, err = strconv.Atoi(lowered[len("rate="):])
+			if err != nil || policy.PerMinute <= 0 {
+				return LinkPolicy{}, errInvalidParams
+			}
+		default:
+			return LinkPolicy{}, errInvalidParams
+		}
+	}
+	return
+}
+
+// memberLinkState tracks when a member joined a channel, and the messages
+// with links they sent to it recently.
+type memberLinkState struct {
+	joined      time.Time
+	windowStart time.Time
+	count       int
+}
+
+// checkLinkPolicy returns whether the client may send the message to the
+// channel, recording it against the client's rate limit if it has a link.
+func (channel *Channel) checkLinkPolicy(client *Client, message string, now time.Time) error {
+	policy := channel.LinkPolicy()
+	if policy.IsEmpty() || !urlRegex.MatchString(message) || channel.ClientIsAtLeast(client, modes.Voice) {
+		return nil
+	}
+	if policy.RequireAccount && client.Account() == "" {
+		return errLinkNeedsAccount
+	}
+	if policy.MinJoinAge == 0 && policy.PerMinute == 0 {
+		return nil
+	}
+
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+	state := channel.linkStates[client]
+	if state == nil || now.Sub(state.joined) < policy.MinJoinAge {
+		// non-members (of a -n channel) haven't joined at all
+		return errLinkTooSoon
+	}
+	if policy.PerMinute != 0 {
+		if linkRateWindow <= now.Sub(state.windowStart) {
+			state.windowStart = now
+			state.count = 0
+		}
+		if policy.PerMinute <= state.count {
+			return errLinkRateLimited
+		}
+		state.count++
+	}
+	return nil
+}
+
+// filterLinks applies the channel's link policy to a message, returning
+// whether it may be sent.
+func (server *Server) filterLinks(client *Client, command string, channel *Channel, splitMsg utils.SplitMessage, rb *ResponseBuffer) (allowed bool) {
+	err := channel.checkLinkPolicy(client, splitMsg.Message, time.Now())
+	if err == nil {
+		return true
+	}
+	if command != "NOTICE" {
+		rb.Add(nil, server.name, "FAIL", command, "LINK_BLOCKED", channel.Name(), client.t(err.Error()))
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLinkPolicy(t *testing.T) {
+	policy, err := ParseLinkPolicy("ACCOUNT age=10m rate=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := LinkPolicy{RequireAccount: true, MinJoinAge: 10 * time.Minute, PerMinute: 3}
+	if policy != expected {
+		t.Errorf("unexpected policy: %#v", policy)
+	}
+	if reparsed, _ := ParseLinkPolicy(policy.String()); reparsed != policy {
+		t.Errorf("policy didn't round-trip: %s", policy.String())
+	}
+	if policy, err := ParseLinkPolicy(""); err != nil || !policy.IsEmpty() {
+		t.Errorf("the empty string should be the empty policy")
+	}
+	for _, invalid := range []string{"rate=0", "age=soon", "links"} {
+		if _, err := ParseLinkPolicy(invalid); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
+
+func TestLinkPolicy(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	alice.Send("JOIN", "#test")
+	alice.Expect(RPL_ENDOFNAMES)
+	bob.Send("JOIN", "#test")
+	bob.Expect(RPL_ENDOFNAMES)
+
+	channel := h.server.channels.Get("#test")
+	bobClient := h.server.clients.Get("bob")
+	link := "see https://example.com"
+	now := time.Now()
+
+	channel.setLinkPolicy(LinkPolicy{RequireAccount: true})
+	bob.Send("PRIVMSG", "#test", link)
+	if msg := bob.Expect("FAIL"); len(msg.Params) < 3 || msg.Params[1] != "LINK_BLOCKED" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+	bob.Send("PRIVMSG", "#test", "no links here")
+	if msg := alice.Expect("PRIVMSG"); msg.Params[1] != "no links here" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+
+	channel.setLinkPolicy(LinkPolicy{MinJoinAge: time.Hour})
+	if err := channel.checkLinkPolicy(bobClient, link, now); err != errLinkTooSoon {
+		t.Errorf("expected %v, got %v", errLinkTooSoon, err)
+	}
+	if err := channel.checkLinkPolicy(bobClient, link, now.Add(2*time.Hour)); err != nil {
+		t.Errorf("old enough members should be able to post links: %v", err)
+	}
+
+	channel.setLinkPolicy(LinkPolicy{PerMinute: 2})
+	for i := 0; i < 2; i++ {
+		if err := channel.checkLinkPolicy(bobClient, link, now); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := channel.checkLinkPolicy(bobClient, link, now); err != errLinkRateLimited {
+		t.Errorf("expected %v, got %v", errLinkRateLimited, err)
+	}
+	if err := channel.checkLinkPolicy(bobClient, link, now.Add(linkRateWindow)); err != nil {
+		t.Errorf("the rate limit should reset: %v", err)
+	}
+
+	// voice and higher are exempt
+	channel.setLinkPolicy(LinkPolicy{RequireAccount: true})
+	alice.Send("MODE", "#test", "+v", "bob")
+	bob.Expect("MODE")
+	bob.Send("PRIVMSG", "#test", link)
+	if msg := alice.Expect("PRIVMSG"); msg.Params[1] != link {
+		t.Errorf("unexpected message: %v", msg)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// Package lockorder provides mutexes annotated with their tier in the lock
+// hierarchy (see DEVELOPING.md): a goroutine holding a mutex of tier N may only
+// acquire mutexes of tiers lower than N. In normal builds these are plain
+// sync.Mutex and sync.RWMutex. When built with `-tags lockorder`, every
+// acquisition is checked against the mutexes the goroutine already holds, and
+// violations (including recursive locking) are reported to the handler set
+// with SetViolationHandler, which by default panics.
+package lockorder
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Violation describes an acquisition that broke the tier convention.
+type Violation struct {
+	// tier of the mutex being acquired
+	Tier int
+	// tier of the mutex that was already held
+	HeldTier int
+	// whether the mutex being acquired was the one already held
+	Recursive bool
+	// where the held mutex was acquired (file:line), if known
+	HeldAt string
+	// stack trace of the acquisition
+	Stack string
+}
+
+var (
+	handlerMutex sync.Mutex
+	handler      = func(v *Violation) { panic(v) }
+)
+
+// SetViolationHandler sets the function that's called on violations (by
+// default, it panics). It has no effect unless built with `-tags lockorder`.
+func SetViolationHandler(h func(v *Violation)) {
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
+	handler = h
+}
+
+func handleViolation(v *Violation) {
+	handlerMutex.Lock()
+	h := handler
+	handlerMutex.Unlock()
+	h(v)
+}
+
+func (v *Violation) Error() string {
+	if v.Recursive {
+		return fmt.Sprintf("lock order violation: recursive acquisition of tier %d mutex (held since %s)\n%s", v.Tier, v.HeldAt, v.Stack)
+	}
+	return fmt.Sprintf("lock order violation: acquired tier %d mutex while holding tier %d mutex (acquired at %s)\n%s", v.Tier, v.HeldTier, v.HeldAt, v.Stack)
+}
+
+// Tier0Mutex may be acquired while holding any other mutex, and acquires nothing else.
+type Tier0Mutex struct{ mutex }
+
+func (m *Tier0Mutex) Lock()   { m.lock(0) }
+func (m *Tier0Mutex) Unlock() { m.unlock() }
+
+// Tier0RWMutex is the RWMutex counterpart of Tier0Mutex.
+type Tier0RWMutex struct{ rwMutex }
+
+func (m *Tier0RWMutex) Lock()    { m.lock(0) }
+func (m *Tier0RWMutex) Unlock()  { m.unlock() }
+func (m *Tier0RWMutex) RLock()   { m.rlock(0) }
+func (m *Tier0RWMutex) RUnlock() { m.runlock() }
+
+// Tier1Mutex protects getters, setters, and invariants local to one object.
+type Tier1Mutex struct{ mutex }
+
+func (m *Tier1Mutex) Lock()   { m.lock(1) }
+func (m *Tier1Mutex) Unlock() { m.unlock() }
+
+// Tier1RWMutex is the RWMutex counterpart of Tier1Mutex.
+type Tier1RWMutex struct{ rwMutex }
+
+func (m *Tier1RWMutex) Lock()    { m.lock(1) }
+func (m *Tier1RWMutex) Unlock()  { m.unlock() }
+func (m *Tier1RWMutex) RLock()   { m.rlock(1) }
+func (m *Tier1RWMutex) RUnlock() { m.runlock() }
+
+// Tier2Mutex protects invariants that involve other synchronized objects.
+type Tier2Mutex struct{ mutex }
+
+func (m *Tier2Mutex) Lock()   { m.lock(2) }
+func (m *Tier2Mutex) Unlock() { m.unlock() }
+
+// Tier2RWMutex is the RWMutex counterpart of Tier2Mutex.
+type Tier2RWMutex struct{ rwMutex }
+
+func (m *Tier2RWMutex) Lock()    { m.lock(2) }
+func (m *Tier2RWMutex) Unlock()  { m.unlock() }
+func (m *Tier2RWMutex) RLock()   { m.rlock(2) }
+func (m *Tier2RWMutex) RUnlock() { m.runlock() }
+
+// Tier3Mutex serializes macroscopic operations.
+type Tier3Mutex struct{ mutex }
+
+func (m *Tier3Mutex) Lock()   { m.lock(3) }
+func (m *Tier3Mutex) Unlock() { m.unlock() }
+
+// Tier4Mutex serializes operations (i.e., rehashing) that take tier 3 mutexes.
+type Tier4Mutex struct{ mutex }
+
+func (m *Tier4Mutex) Lock()   { m.lock(4) }
+func (m *Tier4Mutex) Unlock() { m.unlock() }
@@ -0,0 +1,29 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// +build !lockorder
+
+package lockorder
+
+import (
+	"sync"
+)
+
+// Enabled is whether lock order verification was compiled in.
+const Enabled = false
+
+type mutex struct {
+	m sync.Mutex
+}
+
+func (m *mutex) lock(tier int) { m.m.Lock() }
+func (m *mutex) unlock()       { m.m.Unlock() }
+
+type rwMutex struct {
+	m sync.RWMutex
+}
+
+func (m *rwMutex) lock(tier int)  { m.m.Lock() }
+func (m *rwMutex) unlock()        { m.m.Unlock() }
+func (m *rwMutex) rlock(tier int) { m.m.RLock() }
+func (m *rwMutex) runlock()       { m.m.RUnlock() }
@@ -0,0 +1,181 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// +build lockorder
+
+package lockorder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Enabled is whether lock order verification was compiled in.
+const Enabled = true
+
+func init() {
+	// ORAGONO_LOCKORDER=log reports violations without stopping the server
+	if os.Getenv("ORAGONO_LOCKORDER") == "log" {
+		SetViolationHandler(func(v *Violation) {
+			fmt.Fprintln(os.Stderr, v.Error())
+		})
+	}
+}
+
+// heldLock is a mutex held by a goroutine.
+type heldLock struct {
+	lock interface{}
+	tier int
+	at   string
+}
+
+var (
+	// this is a plain mutex, so it's exempt from checking
+	heldMutex sync.Mutex
+	// goroutine ID to the mutexes it holds, in order of acquisition
+	held = make(map[uint64][]heldLock)
+)
+
+// goroutineID parses the ID out of the first line of the goroutine's stack
+// trace, "goroutine 123 [running]:". The runtime doesn't expose it otherwise,
+// and this is too slow for anything but debugging.
+func goroutineID() uint64 {
+	var buf [64]byte
+	line := buf[:runtime.Stack(buf[:], false)]
+	line = bytes.TrimPrefix(line, []byte("goroutine "))
+	if i := bytes.IndexByte(line, ' '); i != -1 {
+		line = line[:i]
+	}
+	id, _ := strconv.ParseUint(string(line), 10, 64)
+	return id
+}
+
+// check reports a violation if acquiring the lock (of the given tier) could
+// deadlock with the locks the goroutine already holds; it has to be called
+// before blocking on the lock, so that the violation is reported even if
+// the acquisition never completes.
+func check(id uint64, lock interface{}, tier int) {
+	var violation *Violation
+	heldMutex.Lock()
+	for _, h := range held[id] {
+		if h.lock == lock {
+			violation = &Violation{Tier: tier, HeldTier: h.tier, Recursive: true, HeldAt: h.at}
+			break
+		} else if h.tier <= tier {
+			violation = &Violation{Tier: tier, HeldTier: h.tier, HeldAt: h.at}
+			break
+		}
+	}
+	heldMutex.Unlock()
+
+	if violation != nil {
+		violation.Stack = string(debug.Stack())
+		handleViolation(violation)
+	}
+}
+
+func acquired(id uint64, lock interface{}, tier int) {
+	at := callerOutsidePackage()
+	heldMutex.Lock()
+	held[id] = append(held[id], heldLock{lock: lock, tier: tier, at: at})
+	heldMutex.Unlock()
+}
+
+// callerOutsidePackage returns the file:line that locked the mutex.
+func callerOutsidePackage() string {
+	var pcs [8]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.Function, "/irc/lockorder.") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// released forgets the most recent acquisition of the lock; mutexes can be
+// unlocked by a different goroutine from the one that locked them, so if the
+// current goroutine doesn't hold it, the others are searched.
+func released(lock interface{}) {
+	heldMutex.Lock()
+	defer heldMutex.Unlock()
+	if forget(goroutineID(), lock) {
+		return
+	}
+	for id := range held {
+		if forget(id, lock) {
+			return
+		}
+	}
+}
+
+func forget(id uint64, lock interface{}) bool {
+	locks := held[id]
+	for i := len(locks) - 1; 0 <= i; i-- {
+		if locks[i].lock == lock {
+			locks = append(locks[:i], locks[i+1:]...)
+			if len(locks) == 0 {
+				delete(held, id)
+			} else {
+				held[id] = locks
+			}
+			return true
+		}
+	}
+	return false
+}
+
+type mutex struct {
+	m sync.Mutex
+}
+
+func (m *mutex) lock(tier int) {
+	id := goroutineID()
+	check(id, m, tier)
+	m.m.Lock()
+	acquired(id, m, tier)
+}
+
+func (m *mutex) unlock() {
+	released(m)
+	m.m.Unlock()
+}
+
+type rwMutex struct {
+	m sync.RWMutex
+}
+
+func (m *rwMutex) lock(tier int) {
+	id := goroutineID()
+	check(id, m, tier)
+	m.m.Lock()
+	acquired(id, m, tier)
+}
+
+func (m *rwMutex) unlock() {
+	released(m)
+	m.m.Unlock()
+}
+
+// read locks are checked the same way, since a pending writer blocks new
+// readers: recursive read locking, or read locking out of order, can deadlock
+func (m *rwMutex) rlock(tier int) {
+	id := goroutineID()
+	check(id, m, tier)
+	m.m.RLock()
+	acquired(id, m, tier)
+}
+
+func (m *rwMutex) runlock() {
+	released(m)
+	m.m.RUnlock()
+}
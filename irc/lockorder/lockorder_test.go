@@ -0,0 +1,131 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// +build lockorder
+
+package lockorder
+
+import (
+	"sync"
+	"testing"
+)
+
+// captureViolations replaces the violation handler for the duration of a test.
+func captureViolations() (violations *[]*Violation, restore func()) {
+	var mutex sync.Mutex
+	violations = new([]*Violation)
+	SetViolationHandler(func(v *Violation) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		*violations = append(*violations, v)
+	})
+	return violations, func() {
+		SetViolationHandler(func(v *Violation) { panic(v) })
+	}
+}
+
+func TestTierOrder(t *testing.T) {
+	violations, restore := captureViolations()
+	defer restore()
+
+	var inner Tier1Mutex
+	var middle Tier2RWMutex
+	var outer Tier3Mutex
+
+	outer.Lock()
+	middle.RLock()
+	inner.Lock()
+	inner.Unlock()
+	middle.RUnlock()
+	outer.Unlock()
+	if len(*violations) != 0 {
+		t.Fatalf("acquiring in decreasing tier order shouldn't be a violation: %v", (*violations)[0])
+	}
+
+	inner.Lock()
+	middle.Lock()
+	middle.Unlock()
+	inner.Unlock()
+	if len(*violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(*violations))
+	}
+	if v := (*violations)[0]; v.Tier != 2 || v.HeldTier != 1 || v.Recursive {
+		t.Errorf("unexpected violation: %v", v)
+	}
+}
+
+func TestSameTier(t *testing.T) {
+	violations, restore := captureViolations()
+	defer restore()
+
+	var a, b Tier1RWMutex
+	a.RLock()
+	b.RLock()
+	b.RUnlock()
+	a.RUnlock()
+	if len(*violations) != 1 || (*violations)[0].Recursive {
+		t.Errorf("nesting mutexes of the same tier should be a violation: %v", *violations)
+	}
+}
+
+func TestRecursiveLocking(t *testing.T) {
+	violations, restore := captureViolations()
+	defer restore()
+
+	// a recursive RLock deadlocks if a writer is waiting in between
+	var m Tier0RWMutex
+	m.RLock()
+	m.RLock()
+	m.RUnlock()
+	m.RUnlock()
+	if len(*violations) != 1 || !(*violations)[0].Recursive {
+		t.Errorf("recursive read locking should be a violation: %v", *violations)
+	}
+}
+
+func TestUnlockFromOtherGoroutine(t *testing.T) {
+	violations, restore := captureViolations()
+	defer restore()
+
+	var outer Tier2Mutex
+	var inner Tier1Mutex
+	done := make(chan bool)
+	outer.Lock()
+	go func() {
+		outer.Unlock()
+		done <- true
+	}()
+	<-done
+
+	// outer is no longer held by this goroutine, so this is fine:
+	inner.Lock()
+	outer.Lock()
+	outer.Unlock()
+	inner.Unlock()
+	if len(*violations) != 1 {
+		t.Errorf("expected 1 violation, got %d", len(*violations))
+	}
+
+	*violations = nil
+	outer.Lock()
+	inner.Lock()
+	inner.Unlock()
+	outer.Unlock()
+	if len(*violations) != 0 {
+		t.Errorf("released mutexes should be forgotten: %v", *violations)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	SetViolationHandler(func(v *Violation) { panic(v) })
+	var a, b Tier1Mutex
+	a.Lock()
+	defer a.Unlock()
+	defer func() {
+		if _, ok := recover().(*Violation); !ok {
+			t.Errorf("expected a panic with a *Violation")
+		}
+	}()
+	b.Lock()
+	b.Unlock()
+}
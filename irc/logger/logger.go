@@ -78,16 +78,19 @@ type Manager struct {
 
 // LoggingConfig represents the configuration of a single logger.
 type LoggingConfig struct {
-	Method        string
-	MethodStdout  bool
-	MethodStderr  bool
-	MethodFile    bool
-	Filename      string
-	TypeString    string   `yaml:"type"`
-	Types         []string `yaml:"real-types"`
-	ExcludedTypes []string `yaml:"real-excluded-types"`
-	LevelString   string   `yaml:"level"`
-	Level         Level    `yaml:"level-real"`
+	Method         string
+	MethodStdout   bool
+	MethodStderr   bool
+	MethodFile     bool
+	MethodSyslog   bool
+	MethodJournald bool
+	Filename       string
+	Syslog         SyslogConfig
+	TypeString     string   `yaml:"type"`
+	Types          []string `yaml:"real-types"`
+	ExcludedTypes  []string `yaml:"real-excluded-types"`
+	LevelString    string   `yaml:"level"`
+	Level          Level    `yaml:"level-real"`
 }
 
 // NewManager returns a new log manager.
@@ -153,6 +156,22 @@ func (logger *Manager) ApplyConfig(config []LoggingConfig) error {
 			sLogger.MethodFile.File = file
 			sLogger.MethodFile.Writer = writer
 		}
+		if logConfig.MethodSyslog {
+			writer, err := newSyslogWriter(logConfig.Syslog)
+			if err != nil {
+				lastErr = fmt.Errorf("Could not connect to syslog [%s]", err.Error())
+			} else {
+				sLogger.syslogWriters = append(sLogger.syslogWriters, writer)
+			}
+		}
+		if logConfig.MethodJournald {
+			writer, err := newJournaldWriter(logConfig.Syslog)
+			if err != nil {
+				lastErr = fmt.Errorf("Could not connect to journald [%s]", err.Error())
+			} else {
+				sLogger.syslogWriters = append(sLogger.syslogWriters, writer)
+			}
+		}
 		logger.loggers = append(logger.loggers, sLogger)
 	}
 
@@ -211,9 +230,14 @@ type singleLogger struct {
 	Level           Level
 	Types           map[string]bool
 	ExcludedTypes   map[string]bool
+	// syslog and journald:
+	syslogWriters []syslogWriter
 }
 
 func (logger *singleLogger) Close() error {
+	for _, writer := range logger.syslogWriters {
+		writer.Close()
+	}
 	if logger.MethodFile.Enabled {
 		flushErr := logger.MethodFile.Writer.Flush()
 		closeErr := logger.MethodFile.File.Close()
@@ -228,7 +252,7 @@ func (logger *singleLogger) Close() error {
 // Log logs the given message with the given details.
 func (logger *singleLogger) Log(level Level, logType string, messageParts ...string) {
 	// no logging enabled
-	if !(logger.MethodSTDOUT || logger.MethodSTDERR || logger.MethodFile.Enabled) {
+	if !(logger.MethodSTDOUT || logger.MethodSTDERR || logger.MethodFile.Enabled || len(logger.syslogWriters) != 0) {
 		return
 	}
 
@@ -298,4 +322,11 @@ func (logger *singleLogger) Log(level Level, logType string, messageParts ...str
 		logger.MethodFile.Writer.Flush()
 		logger.fileWriteLock.Unlock()
 	}
+	if len(logger.syslogWriters) != 0 {
+		// syslog and journald record the time and level themselves
+		message := strings.Join(messageParts, " : ")
+		for _, writer := range logger.syslogWriters {
+			writer.Log(level, logType, message)
+		}
+	}
 }
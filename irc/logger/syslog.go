@@ -0,0 +1,137 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// syslog and journald both take the standard syslog priorities (RFC 5424);
+// these are defined here, rather than taken from log/syslog, because that
+// package isn't available on every platform we build for.
+
+const (
+	defaultSyslogTag      = "oragono"
+	defaultSyslogFacility = "daemon"
+)
+
+var (
+	errSyslogUnsupported    = errors.New("syslog and journald logging are not supported on this platform")
+	errSyslogNoAddress      = errors.New("Remote syslog logging requires an address")
+	errSyslogInvalidNetwork = errors.New("Syslog network must be one of: udp tcp (or empty, for the local syslog daemon)")
+
+	syslogFacilities = map[string]int{
+		"kern":     0 << 3,
+		"user":     1 << 3,
+		"mail":     2 << 3,
+		"daemon":   3 << 3,
+		"auth":     4 << 3,
+		"syslog":   5 << 3,
+		"lpr":      6 << 3,
+		"news":     7 << 3,
+		"uucp":     8 << 3,
+		"cron":     9 << 3,
+		"authpriv": 10 << 3,
+		"ftp":      11 << 3,
+		"local0":   16 << 3,
+		"local1":   17 << 3,
+		"local2":   18 << 3,
+		"local3":   19 << 3,
+		"local4":   20 << 3,
+		"local5":   21 << 3,
+		"local6":   22 << 3,
+		"local7":   23 << 3,
+	}
+)
+
+// SyslogConfig controls where a logger sends messages when its method
+// includes syslog or journald.
+type SyslogConfig struct {
+	// empty for the local syslog daemon, or udp/tcp for a remote one
+	Network string
+	// host:port of the remote syslog daemon
+	Address  string
+	Facility string
+	// identifies oragono's messages (the syslog tag, or the journald SYSLOG_IDENTIFIER)
+	Tag string
+
+	facility int
+}
+
+// Prepare validates the config and fills in the defaults.
+func (conf *SyslogConfig) Prepare() error {
+	conf.Network = strings.ToLower(conf.Network)
+	switch conf.Network {
+	case "":
+	case "udp", "tcp":
+		if conf.Address == "" {
+			return errSyslogNoAddress
+		}
+	default:
+		return errSyslogInvalidNetwork
+	}
+	if conf.Facility == "" {
+		conf.Facility = defaultSyslogFacility
+	}
+	facility, ok := syslogFacilities[strings.ToLower(conf.Facility)]
+	if !ok {
+		return fmt.Errorf("Unknown syslog facility: %s", conf.Facility)
+	}
+	conf.facility = facility
+	if conf.Tag == "" {
+		conf.Tag = defaultSyslogTag
+	}
+	return nil
+}
+
+// syslogSeverity maps our log levels to syslog severities.
+func syslogSeverity(level Level) int {
+	switch level {
+	case LogDebug:
+		return 7 // LOG_DEBUG
+	case LogInfo:
+		return 6 // LOG_INFO
+	case LogWarning:
+		return 4 // LOG_WARNING
+	default:
+		return 3 // LOG_ERR
+	}
+}
+
+// syslogWriter is the interface to the syslog and journald writers, which
+// are only implemented on some platforms.
+type syslogWriter interface {
+	Log(level Level, logType string, message string) error
+	Close() error
+}
+
+// journaldMessage serializes a message in journald's native protocol:
+// each field is sent as NAME=value, except that values containing newlines
+// are length-prefixed instead.
+func journaldMessage(tag string, level Level, logType string, message string) []byte {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "PRIORITY", strconv.Itoa(syslogSeverity(level)))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", tag)
+	writeJournaldField(&buf, "ORAGONO_LOG_TYPE", logType)
+	writeJournaldField(&buf, "MESSAGE", message)
+	return buf.Bytes()
+}
+
+func writeJournaldField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.IndexByte(value, '\n') == -1 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+	} else {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+}
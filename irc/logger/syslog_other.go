@@ -0,0 +1,14 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// +build windows plan9 nacl
+
+package logger
+
+func newSyslogWriter(config SyslogConfig) (syslogWriter, error) {
+	return nil, errSyslogUnsupported
+}
+
+func newJournaldWriter(config SyslogConfig) (syslogWriter, error) {
+	return nil, errSyslogUnsupported
+}
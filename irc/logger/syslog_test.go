@@ -0,0 +1,59 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package logger
+
+import (
+	"testing"
+)
+
+func TestSyslogConfig(t *testing.T) {
+	var conf SyslogConfig
+	if err := conf.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Tag != "oragono" || conf.facility != syslogFacilities["daemon"] {
+		t.Errorf("unexpected defaults: %#v", conf)
+	}
+
+	conf = SyslogConfig{Network: "UDP", Address: "logs.example.com:514", Facility: "local3"}
+	if err := conf.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Network != "udp" || conf.facility != 19<<3 {
+		t.Errorf("unexpected config: %#v", conf)
+	}
+
+	for _, invalid := range []SyslogConfig{
+		{Network: "tcp"},
+		{Network: "sctp", Address: "logs.example.com:514"},
+		{Facility: "local8"},
+	} {
+		if invalid.Prepare() == nil {
+			t.Errorf("config should have been rejected: %#v", invalid)
+		}
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	expected := map[Level]int{LogDebug: 7, LogInfo: 6, LogWarning: 4, LogError: 3}
+	for level, severity := range expected {
+		if syslogSeverity(level) != severity {
+			t.Errorf("level %d: expected severity %d, got %d", level, severity, syslogSeverity(level))
+		}
+	}
+}
+
+func TestJournaldMessage(t *testing.T) {
+	message := string(journaldMessage("oragono", LogWarning, "server", "Rehashing"))
+	expected := "PRIORITY=4\nSYSLOG_IDENTIFIER=oragono\nORAGONO_LOG_TYPE=server\nMESSAGE=Rehashing\n"
+	if message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+
+	message = string(journaldMessage("oragono", LogError, "internal", "a\nb"))
+	expected = "PRIORITY=3\nSYSLOG_IDENTIFIER=oragono\nORAGONO_LOG_TYPE=internal\nMESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n"
+	if message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// +build !windows,!plan9,!nacl
+
+package logger
+
+import (
+	"log/syslog"
+	"net"
+)
+
+const (
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+type localSyslogWriter struct {
+	writer *syslog.Writer
+}
+
+func newSyslogWriter(config SyslogConfig) (syslogWriter, error) {
+	writer, err := syslog.Dial(config.Network, config.Address, syslog.Priority(config.facility)|syslog.LOG_INFO, config.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &localSyslogWriter{writer: writer}, nil
+}
+
+func (w *localSyslogWriter) Log(level Level, logType string, message string) error {
+	message = logType + " : " + message
+	switch level {
+	case LogDebug:
+		return w.writer.Debug(message)
+	case LogInfo:
+		return w.writer.Info(message)
+	case LogWarning:
+		return w.writer.Warning(message)
+	default:
+		return w.writer.Err(message)
+	}
+}
+
+func (w *localSyslogWriter) Close() error {
+	return w.writer.Close()
+}
+
+type journaldWriter struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+func newJournaldWriter(config SyslogConfig) (syslogWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{conn: conn, tag: config.Tag}, nil
+}
+
+func (w *journaldWriter) Log(level Level, logType string, message string) error {
+	// XXX messages too large for a single datagram would have to be passed
+	// to journald in a memfd; our log lines are never that long
+	_, err := w.conn.Write(journaldMessage(w.tag, level, logType, message))
+	return err
+}
+
+func (w *journaldWriter) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/modes"
+)
+
+const (
+	// number of independently locked shards in a MemberSet
+	memberShardCount = 16
+)
+
+var (
+	// source of Client.shardKey
+	clientShardCounter uint32
+)
+
+// memberShard holds a subset of a channel's members.
+type memberShard struct {
+	// may be acquired while holding Channel.stateMutex, and acquires nothing else
+	lockorder.Tier0RWMutex
+	members map[*Client]*modes.ModeSet
+}
+
+// memberEntry is a member, together with their channel prefixes (in multi-prefix form).
+type memberEntry struct {
+	client   *Client
+	prefixes string
+}
+
+// memberSnapshot is an immutable view of a MemberSet at one point in time.
+type memberSnapshot struct {
+	generation uint64
+	members    []*Client
+	// the same members, sorted by their highest channel prefix (founders first)
+	sorted []memberEntry
+}
+
+// MemberSet is the set of a channel's members, with their channel modes.
+//
+// Large channels get a lot of concurrent traffic, so members are distributed
+// across shards with their own locks: checking one member's privileges never
+// waits on a join or part of a different member. Iteration over the members
+// (for relaying messages, NAMES, and WHO) uses a snapshot that's rebuilt lazily,
+// after the first read following a change, rather than on every join and part.
+type MemberSet struct {
+	shards [memberShardCount]memberShard
+	count  int32 // accessed atomically
+
+	// incremented (atomically) on every change, invalidating the snapshot
+	generation    uint64
+	snapshot      atomic.Value         // *memberSnapshot
+	snapshotMutex lockorder.Tier1Mutex // serializes rebuilding the snapshot
+}
+
+func (members *MemberSet) shard(client *Client) *memberShard {
+	return &members.shards[client.shardKey%memberShardCount]
+}
+
+func (members *MemberSet) invalidate() {
+	atomic.AddUint64(&members.generation, 1)
+}
+
+// Add adds the client (with no channel modes), returning the new number of members.
+func (members *MemberSet) Add(client *Client) int {
+	shard := members.shard(client)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, present := shard.members[client]; present {
+		return members.Len()
+	}
+	if shard.members == nil {
+		shard.members = make(map[*Client]*modes.ModeSet)
+	}
+	shard.members[client] = modes.NewModeSet()
+	members.invalidate()
+	return int(atomic.AddInt32(&members.count, 1))
+}
+
+// Remove removes the client, returning the new number of members.
+func (members *MemberSet) Remove(client *Client) int {
+	shard := members.shard(client)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, present := shard.members[client]; !present {
+		return members.Len()
+	}
+	delete(shard.members, client)
+	members.invalidate()
+	return int(atomic.AddInt32(&members.count, -1))
+}
+
+// Replace replaces one member with another (e.g., on resume), who keeps their modes.
+func (members *MemberSet) Replace(oldClient, newClient *Client) (modeSet *modes.ModeSet) {
+	oldShard := members.shard(oldClient)
+	oldShard.Lock()
+	modeSet = oldShard.members[oldClient]
+	if modeSet != nil {
+		delete(oldShard.members, oldClient)
+		atomic.AddInt32(&members.count, -1)
+	} else {
+		modeSet = modes.NewModeSet()
+	}
+	oldShard.Unlock()
+
+	newShard := members.shard(newClient)
+	newShard.Lock()
+	if newShard.members == nil {
+		newShard.members = make(map[*Client]*modes.ModeSet)
+	}
+	if _, present := newShard.members[newClient]; !present {
+		atomic.AddInt32(&members.count, 1)
+	}
+	newShard.members[newClient] = modeSet
+	newShard.Unlock()
+
+	members.invalidate()
+	return
+}
+
+// Get returns the client's channel modes, or nil if they aren't a member.
+func (members *MemberSet) Get(client *Client) *modes.ModeSet {
+	shard := members.shard(client)
+	shard.RLock()
+	defer shard.RUnlock()
+	return shard.members[client]
+}
+
+// Has returns whether the client is a member.
+func (members *MemberSet) Has(client *Client) bool {
+	return members.Get(client) != nil
+}
+
+// SetMode sets or unsets a channel mode on a member, returning whether the
+// client is a member and whether anything changed.
+func (members *MemberSet) SetMode(client *Client, mode modes.Mode, on bool) (present, changed bool) {
+	modeSet := members.Get(client)
+	if modeSet == nil {
+		return false, false
+	}
+	changed = modeSet.SetMode(mode, on)
+	if changed {
+		// the snapshot includes prefixes
+		members.invalidate()
+	}
+	return true, changed
+}
+
+// Len returns the number of members.
+func (members *MemberSet) Len() int {
+	return int(atomic.LoadInt32(&members.count))
+}
+
+// getSnapshot returns an up-to-date snapshot, rebuilding it if necessary.
+func (members *MemberSet) getSnapshot() *memberSnapshot {
+	generation := atomic.LoadUint64(&members.generation)
+	if snapshot, _ := members.snapshot.Load().(*memberSnapshot); snapshot != nil && snapshot.generation == generation {
+		return snapshot
+	}
+
+	members.snapshotMutex.Lock()
+	defer members.snapshotMutex.Unlock()
+	// someone else may have rebuilt it while we were waiting
+	generation = atomic.LoadUint64(&members.generation)
+	if snapshot, _ := members.snapshot.Load().(*memberSnapshot); snapshot != nil && snapshot.generation == generation {
+		return snapshot
+	}
+
+	snapshot := &memberSnapshot{generation: generation}
+	snapshot.members = make([]*Client, 0, members.Len())
+	snapshot.sorted = make([]memberEntry, 0, members.Len())
+	ranks := make([]int, 0, members.Len())
+	for i := range members.shards {
+		shard := &members.shards[i]
+		shard.RLock()
+		for client, modeSet := range shard.members {
+			snapshot.members = append(snapshot.members, client)
+			snapshot.sorted = append(snapshot.sorted, memberEntry{client: client, prefixes: modeSet.Prefixes(true)})
+			ranks = append(ranks, memberRank(modeSet))
+		}
+		shard.RUnlock()
+	}
+	sort.Stable(memberEntrySorter{snapshot.sorted, ranks})
+
+	// if there were changes during the rebuild, the next read will rebuild again
+	members.snapshot.Store(snapshot)
+	return snapshot
+}
+
+// Members returns the members, in no particular order. The result must not be modified.
+func (members *MemberSet) Members() []*Client {
+	return members.getSnapshot().members
+}
+
+// SortedMembers returns the members with their prefixes, sorted by their highest
+// channel prefix. The result must not be modified.
+func (members *MemberSet) SortedMembers() []memberEntry {
+	return members.getSnapshot().sorted
+}
+
+// memberRank returns the position of the member's highest channel mode in
+// modes.ChannelUserModes, or len(modes.ChannelUserModes) if they have none.
+func memberRank(modeSet *modes.ModeSet) int {
+	for i, mode := range modes.ChannelUserModes {
+		if modeSet.HasMode(mode) {
+			return i
+		}
+	}
+	return len(modes.ChannelUserModes)
+}
+
+// memberEntrySorter sorts member entries by rank.
+type memberEntrySorter struct {
+	entries []memberEntry
+	ranks   []int
+}
+
+func (s memberEntrySorter) Len() int           { return len(s.entries) }
+func (s memberEntrySorter) Less(i, j int) bool { return s.ranks[i] < s.ranks[j] }
+func (s memberEntrySorter) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.ranks[i], s.ranks[j] = s.ranks[j], s.ranks[i]
+}
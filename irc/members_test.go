@@ -0,0 +1,237 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func makeTestMembers(count int) []*Client {
+	clients := make([]*Client, count)
+	for i := range clients {
+		clients[i] = &Client{shardKey: uint32(i)}
+	}
+	return clients
+}
+
+func TestMemberSet(t *testing.T) {
+	var members MemberSet
+	clients := makeTestMembers(100)
+	for i, client := range clients {
+		if count := members.Add(client); count != i+1 {
+			t.Fatalf("incorrect count after add: %d", count)
+		}
+	}
+	// adding twice doesn't change anything
+	if count := members.Add(clients[0]); count != 100 {
+		t.Errorf("incorrect count after duplicate add: %d", count)
+	}
+	if len(members.Members()) != 100 {
+		t.Errorf("incorrect snapshot size: %d", len(members.Members()))
+	}
+
+	if present, changed := members.SetMode(clients[50], modes.ChannelOperator, true); !present || !changed {
+		t.Errorf("SetMode failed")
+	}
+	members.SetMode(clients[60], modes.Voice, true)
+	members.SetMode(clients[70], modes.ChannelFounder, true)
+	members.SetMode(clients[70], modes.ChannelOperator, true)
+	sorted := members.SortedMembers()
+	if sorted[0].client != clients[70] || sorted[0].prefixes != "~@" || sorted[1].client != clients[50] || sorted[2].client != clients[60] || sorted[3].prefixes != "" {
+		t.Errorf("members are not sorted by prefix: %v", sorted[:4])
+	}
+	if !channelUserModeIsAtLeast(members.Get(clients[50]), modes.ChannelOperator) || members.Get(clients[50]) == members.Get(clients[51]) {
+		t.Errorf("incorrect modes")
+	}
+
+	replacement := &Client{shardKey: 1000}
+	modeSet := members.Replace(clients[50], replacement)
+	if members.Has(clients[50]) || !members.Has(replacement) || !modeSet.HasMode(modes.ChannelOperator) || members.Len() != 100 {
+		t.Errorf("replace failed")
+	}
+
+	for i, client := range clients {
+		if i == 50 {
+			continue
+		}
+		members.Remove(client)
+	}
+	if members.Len() != 1 || len(members.Members()) != 1 || members.Members()[0] != replacement {
+		t.Errorf("incorrect members after removal: %v", members.Members())
+	}
+	if count := members.Remove(clients[0]); count != 1 {
+		t.Errorf("removing a nonmember changed the count: %d", count)
+	}
+}
+
+func TestMemberSetConcurrency(t *testing.T) {
+	var members MemberSet
+	clients := makeTestMembers(1000)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < len(clients); i += 4 {
+				members.Add(clients[i])
+				members.Members()
+				members.SetMode(clients[i], modes.Voice, true)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if members.Len() != 1000 || len(members.Members()) != 1000 {
+		t.Errorf("incorrect member count: %d %d", members.Len(), len(members.Members()))
+	}
+	for _, member := range members.SortedMembers() {
+		if member.prefixes != "+" {
+			t.Errorf("stale prefixes in snapshot: %v", member)
+		}
+	}
+}
+
+// legacyMemberSet is the previous design, for comparison: one map under one lock,
+// with the list of members regenerated on every join and part.
+type legacyMemberSet struct {
+	sync.RWMutex
+	members map[*Client]*modes.ModeSet
+	cache   []*Client
+}
+
+func (l *legacyMemberSet) add(client *Client) {
+	l.Lock()
+	l.members[client] = modes.NewModeSet()
+	l.Unlock()
+	l.regenerate()
+}
+
+func (l *legacyMemberSet) remove(client *Client) {
+	l.Lock()
+	delete(l.members, client)
+	l.Unlock()
+	l.regenerate()
+}
+
+func (l *legacyMemberSet) regenerate() {
+	l.RLock()
+	result := make([]*Client, 0, len(l.members))
+	for client := range l.members {
+		result = append(result, client)
+	}
+	l.RUnlock()
+	l.Lock()
+	l.cache = result
+	l.Unlock()
+}
+
+func (l *legacyMemberSet) get(client *Client) *modes.ModeSet {
+	l.RLock()
+	defer l.RUnlock()
+	return l.members[client]
+}
+
+const benchmarkChannelSize = 10000
+
+// join and part churn in a large channel, with occasional message relays
+func BenchmarkJoinPartLegacy(b *testing.B) {
+	l := legacyMemberSet{members: make(map[*Client]*modes.ModeSet)}
+	for _, client := range makeTestMembers(benchmarkChannelSize) {
+		l.add(client)
+	}
+	churn := &Client{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.add(churn)
+		l.remove(churn)
+	}
+}
+
+func BenchmarkJoinPart(b *testing.B) {
+	var members MemberSet
+	for _, client := range makeTestMembers(benchmarkChannelSize) {
+		members.Add(client)
+	}
+	churn := &Client{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		members.Add(churn)
+		members.Remove(churn)
+	}
+}
+
+// privilege checks (e.g., CanSpeak) from many goroutines, while members join and part
+func BenchmarkParallelLookupLegacy(b *testing.B) {
+	l := legacyMemberSet{members: make(map[*Client]*modes.ModeSet)}
+	clients := makeTestMembers(benchmarkChannelSize)
+	for _, client := range clients {
+		l.add(client)
+	}
+	stop := make(chan struct{})
+	go func() {
+		churn := &Client{}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				l.add(churn)
+				l.remove(churn)
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			l.get(clients[i%len(clients)])
+			i++
+		}
+	})
+	close(stop)
+}
+
+func BenchmarkParallelLookup(b *testing.B) {
+	var members MemberSet
+	clients := makeTestMembers(benchmarkChannelSize)
+	for _, client := range clients {
+		members.Add(client)
+	}
+	stop := make(chan struct{})
+	go func() {
+		churn := &Client{}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				members.Add(churn)
+				members.Remove(churn)
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			members.Get(clients[i%len(clients)])
+			i++
+		}
+	})
+	close(stop)
+}
+
+// repeated NAMES requests without intervening membership changes
+func BenchmarkNamesSnapshot(b *testing.B) {
+	var members MemberSet
+	for _, client := range makeTestMembers(benchmarkChannelSize) {
+		members.Add(client)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		members.SortedMembers()
+	}
+}
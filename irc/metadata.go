@@ -0,0 +1,473 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
+	"github.com/tidwall/buntdb"
+)
+
+// Metadata (the draft/metadata-2 extension) is a set of key-value pairs
+// attached to users and channels, e.g., a website url, an avatar, or a display
+// name. Clients set and get it with the METADATA command, and can subscribe to
+// keys, to be told when they change for their channels and the users in them.
+// A logged-in user's metadata is stored with their account (so it's shared by
+// all their clients), and a registered channel's is stored with the channel.
+// Everything is public: all metadata has the visibility "*".
+
+const (
+	defaultMetadataMaxKeys       = 20
+	defaultMetadataMaxValueBytes = 300
+	defaultMetadataMaxSubs       = 50
+	maxMetadataKeyLength         = 64
+	// metadata values have to fit in a line with the other parameters
+	maxMetadataValueBytes = 400
+)
+
+var (
+	errMetadataLimitReached = errors.New("Too many metadata keys")
+)
+
+// MetadataConfig controls the draft/metadata-2 extension.
+type MetadataConfig struct {
+	Enabled bool
+	// if set, only these keys can be set
+	AllowedKeys []string `yaml:"allowed-keys"`
+	// each user and channel can have at most this many keys
+	MaxKeys       int `yaml:"max-keys"`
+	MaxValueBytes int `yaml:"max-value-bytes"`
+	// each client can subscribe to at most this many keys
+	MaxSubs int `yaml:"max-subs"`
+
+	allowedKeys map[string]bool
+}
+
+func (conf *MetadataConfig) prepare() error {
+	if conf.MaxKeys == 0 {
+		conf.MaxKeys = defaultMetadataMaxKeys
+	}
+	if conf.MaxValueBytes == 0 {
+		conf.MaxValueBytes = defaultMetadataMaxValueBytes
+	}
+	if conf.MaxSubs == 0 {
+		conf.MaxSubs = defaultMetadataMaxSubs
+	}
+	if conf.MaxKeys < 0 || conf.MaxSubs < 0 || conf.MaxValueBytes < 0 || maxMetadataValueBytes < conf.MaxValueBytes {
+		return fmt.Errorf("invalid metadata limits")
+	}
+	conf.allowedKeys = nil
+	if len(conf.AllowedKeys) != 0 {
+		conf.allowedKeys = make(map[string]bool)
+		for _, key := range conf.AllowedKeys {
+			key = strings.ToLower(key)
+			if !isValidMetadataKey(key) {
+				return fmt.Errorf("invalid metadata key: %s", key)
+			}
+			conf.allowedKeys[key] = true
+		}
+	}
+	return nil
+}
+
+// CapValue returns the value of the draft/metadata-2 capability.
+func (conf *MetadataConfig) CapValue() string {
+	return fmt.Sprintf("max-subs=%d,max-keys=%d,max-value-bytes=%d", conf.MaxSubs, conf.MaxKeys, conf.MaxValueBytes)
+}
+
+// isAllowedKey returns whether the key can be set.
+func (conf *MetadataConfig) isAllowedKey(key string) bool {
+	return conf.allowedKeys == nil || conf.allowedKeys[key]
+}
+
+// isValidMetadataKey returns whether a key is well-formed: made of lowercase
+// letters, digits, and the characters "_.-/", and not starting with "/".
+func isValidMetadataKey(key string) bool {
+	if key == "" || maxMetadataKeyLength < len(key) || key[0] == '/' {
+		return false
+	}
+	for _, r := range key {
+		if !(('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r == '_' || r == '.' || r == '-' || r == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+// updateMetadata sets key to value in the metadata (or deletes it, if value is
+// empty), returning the modified metadata.
+func updateMetadata(metadata map[string]string, key, value string, maxKeys int) (map[string]string, error) {
+	if value == "" {
+		delete(metadata, key)
+		return metadata, nil
+	}
+	if _, exists := metadata[key]; !exists && maxKeys <= len(metadata) {
+		return metadata, errMetadataLimitReached
+	}
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[key] = value
+	return metadata, nil
+}
+
+func copyMetadata(metadata map[string]string) (result map[string]string) {
+	result = make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		result[key] = value
+	}
+	return
+}
+
+// LoadMetadata returns the metadata stored with the (casefolded) account.
+func (am *AccountManager) LoadMetadata(account string) (metadata map[string]string) {
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		metadata = am.loadMetadata(tx, account)
+		return nil
+	})
+	return
+}
+
+func (am *AccountManager) loadMetadata(tx *buntdb.Tx, account string) (metadata map[string]string) {
+	if rawMetadata, err := tx.Get(fmt.Sprintf(keyAccountMetadata, account)); err == nil {
+		json.Unmarshal([]byte(rawMetadata), &metadata)
+	}
+	return
+}
+
+// ModifyMetadata runs `update` on the (casefolded) account's metadata, then
+// stores the result.
+func (am *AccountManager) ModifyMetadata(account string, update func(metadata map[string]string) (map[string]string, error)) error {
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		// don't resurrect an account that was unregistered in the meantime
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return errAccountDoesNotExist
+		}
+		metadata, err := update(am.loadMetadata(tx, account))
+		if err != nil {
+			return err
+		}
+		rawMetadata, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(fmt.Sprintf(keyAccountMetadata, account), string(rawMetadata), nil)
+		return err
+	})
+}
+
+// metadataTarget is the user or channel whose metadata is being accessed.
+type metadataTarget struct {
+	name    string
+	client  *Client
+	channel *Channel
+}
+
+// resolveMetadataTarget looks up the target of a METADATA command; "*" is the
+// client itself.
+func (server *Server) resolveMetadataTarget(client *Client, target string) (result metadataTarget, found bool) {
+	if target == "*" {
+		return metadataTarget{name: client.Nick(), client: client}, true
+	} else if channel := server.channels.Get(target); channel != nil {
+		return metadataTarget{name: channel.Name(), channel: channel}, true
+	} else if user := server.clients.Get(target); user != nil {
+		return metadataTarget{name: user.Nick(), client: user}, true
+	}
+	return
+}
+
+// canRead returns whether the client can see the target's metadata: anyone
+// can, except for the metadata of secret channels they aren't in.
+func (target *metadataTarget) canRead(client *Client) bool {
+	if target.channel != nil && target.channel.flags.HasMode(modes.Secret) {
+		return target.channel.hasClient(client)
+	}
+	return true
+}
+
+// canWrite returns whether the client can modify the target's metadata: users
+// can modify their own, and channel operators their channel's.
+func (target *metadataTarget) canWrite(client *Client) bool {
+	if target.channel != nil {
+		return target.channel.ClientIsAtLeast(client, modes.ChannelOperator)
+	}
+	return target.client == client
+}
+
+// tokenAllowsWrite returns whether the API token the client logged in with (if
+// any) lets it modify the target's metadata, which is treated like messaging.
+func (target *metadataTarget) tokenAllowsWrite(client *Client) bool {
+	if !client.apiTokenAllows(apiTokenCapMessage) {
+		return false
+	}
+	return target.channel == nil || client.apiTokenAllowsChannel(target.channel.NameCasefolded())
+}
+
+// get returns a copy of the target's metadata.
+func (target *metadataTarget) get() map[string]string {
+	if target.channel != nil {
+		return target.channel.Metadata()
+	}
+	if account := target.client.Account(); account != "" {
+		return target.client.server.accounts.LoadMetadata(account)
+	}
+	return target.client.Metadata()
+}
+
+// update runs `update` on the target's metadata, and stores the result.
+func (target *metadataTarget) update(update func(metadata map[string]string) (map[string]string, error)) error {
+	if target.channel != nil {
+		err := target.channel.updateMetadata(update)
+		if err == nil {
+			target.channel.server.channelRegistry.StoreChannel(target.channel, IncludeSettings)
+		}
+		return err
+	}
+	if account := target.client.Account(); account != "" {
+		return target.client.server.accounts.ModifyMetadata(account, update)
+	}
+	return target.client.updateMetadata(update)
+}
+
+// notifyMetadata tells the clients that subscribed to a key about a change to
+// it: the members of the channel, or the users who share a channel with the
+// user, whose metadata changed.
+func (server *Server) notifyMetadata(changer *Client, target *metadataTarget, key, value string) {
+	var recipients []*Client
+	if target.channel != nil {
+		recipients = target.channel.Members()
+	} else {
+		for friend := range target.client.Friends(caps.Metadata) {
+			recipients = append(recipients, friend)
+		}
+	}
+	params := []string{target.name, key, "*"}
+	if value != "" {
+		params = append(params, value)
+	}
+	for _, recipient := range recipients {
+		if recipient != changer && recipient.capabilities.Has(caps.Metadata) && recipient.isSubscribedToMetadata(key) {
+			recipient.Send(nil, server.name, "METADATA", params...)
+		}
+	}
+}
+
+// sendMetadata sends a client that just joined the channel its metadata, for
+// the keys the client subscribed to.
+func (channel *Channel) sendMetadata(client *Client, rb *ResponseBuffer) {
+	if !client.capabilities.Has(caps.Metadata) {
+		return
+	}
+	metadata := channel.Metadata()
+	for _, key := range sortedMetadataKeys(metadata) {
+		if client.isSubscribedToMetadata(key) {
+			rb.Add(nil, client.server.name, "METADATA", channel.Name(), key, "*", metadata[key])
+		}
+	}
+}
+
+func sortedMetadataKeys(metadata map[string]string) (keys []string) {
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (client *Client) isSubscribedToMetadata(key string) bool {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return client.metadataSubs[key]
+}
+
+// METADATA <target> GET <key>{ <key>}
+// METADATA <target> LIST
+// METADATA <target> SET <key> [<value>]
+// METADATA <target> CLEAR
+// METADATA * SUB <key>{ <key>}
+// METADATA * UNSUB <key>{ <key>}
+// METADATA * SUBS
+func metadataHandler(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool {
+	config := &server.Config().Server.Metadata
+	if !config.Enabled {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "DISABLED", client.t("Metadata is disabled on this server"))
+		return false
+	}
+
+	subcommand := strings.ToLower(msg.Params[1])
+	switch subcommand {
+	case "sub", "unsub", "subs":
+		metadataSubscriptionHandler(server, client, subcommand, msg.Params[2:], config, rb)
+		return false
+	case "get", "list", "set", "clear":
+	default:
+		rb.Add(nil, server.name, "FAIL", "METADATA", "SUBCOMMAND_INVALID", msg.Params[1], client.t("Invalid subcommand"))
+		return false
+	}
+
+	target, found := server.resolveMetadataTarget(client, msg.Params[0])
+	if !found {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "INVALID_TARGET", msg.Params[0], client.t("No such nick or channel"))
+		return false
+	}
+	if !target.canRead(client) || ((subcommand == "set" || subcommand == "clear") && !target.canWrite(client)) {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_NO_PERMISSION", target.name, client.t("You don't have permission to do that"))
+		return false
+	}
+	if (subcommand == "set" || subcommand == "clear") && !target.tokenAllowsWrite(client) {
+		rb.Add(nil, server.name, "FAIL", "METADATA", "TOKEN_RESTRICTED", target.name, client.t(errAPITokenRestricted.Error()))
+		return false
+	}
+
+	nick := client.Nick()
+	switch subcommand {
+	case "get":
+		if len(msg.Params) < 3 {
+			rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, nick, msg.Command, client.t("Not enough parameters"))
+			return false
+		}
+		metadata := target.get()
+		for _, key := range msg.Params[2:] {
+			key = strings.ToLower(key)
+			if !isValidMetadataKey(key) {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", key, client.t("Invalid key"))
+			} else if value, ok := metadata[key]; ok {
+				rb.Add(nil, server.name, RPL_KEYVALUE, nick, target.name, key, "*", value)
+			} else {
+				rb.Add(nil, server.name, RPL_KEYNOTSET, nick, target.name, key, client.t("Key not set"))
+			}
+		}
+	case "list":
+		metadata := target.get()
+		for _, key := range sortedMetadataKeys(metadata) {
+			rb.Add(nil, server.name, RPL_KEYVALUE, nick, target.name, key, "*", metadata[key])
+		}
+		rb.Add(nil, server.name, RPL_METADATAEND, nick, client.t("End of metadata"))
+	case "set":
+		if len(msg.Params) < 3 {
+			rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, nick, msg.Command, client.t("Not enough parameters"))
+			return false
+		}
+		key := strings.ToLower(msg.Params[2])
+		var value string
+		if len(msg.Params) > 3 {
+			value = msg.Params[3]
+		}
+		if !isValidMetadataKey(key) || !config.isAllowedKey(key) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", key, client.t("Invalid key"))
+			return false
+		}
+		if config.MaxValueBytes < len(value) || !utf8.ValidString(value) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "VALUE_INVALID", client.t("Invalid value"))
+			return false
+		}
+		err := target.update(func(metadata map[string]string) (map[string]string, error) {
+			return updateMetadata(metadata, key, value, config.MaxKeys)
+		})
+		if err == errMetadataLimitReached {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "LIMIT_REACHED", target.name, client.t("Too many metadata keys"))
+			return false
+		} else if err != nil {
+			server.logger.Error("internal", "couldn't store metadata", target.name, err.Error())
+			rb.Add(nil, server.name, "FAIL", "METADATA", "INTERNAL_ERROR", client.t("An error occurred"))
+			return false
+		}
+		if value != "" {
+			rb.Add(nil, server.name, RPL_KEYVALUE, nick, target.name, key, "*", value)
+		} else {
+			rb.Add(nil, server.name, RPL_KEYNOTSET, nick, target.name, key, client.t("Key not set"))
+		}
+		server.notifyMetadata(client, &target, key, value)
+	case "clear":
+		var cleared map[string]string
+		err := target.update(func(metadata map[string]string) (map[string]string, error) {
+			cleared = metadata
+			return nil, nil
+		})
+		if err != nil {
+			server.logger.Error("internal", "couldn't clear metadata", target.name, err.Error())
+			rb.Add(nil, server.name, "FAIL", "METADATA", "INTERNAL_ERROR", client.t("An error occurred"))
+			return false
+		}
+		for _, key := range sortedMetadataKeys(cleared) {
+			rb.Add(nil, server.name, RPL_KEYNOTSET, nick, target.name, key, client.t("Key not set"))
+			server.notifyMetadata(client, &target, key, "")
+		}
+		rb.Add(nil, server.name, RPL_METADATAEND, nick, client.t("End of metadata"))
+	}
+	return false
+}
+
+func metadataSubscriptionHandler(server *Server, client *Client, subcommand string, keys []string, config *MetadataConfig, rb *ResponseBuffer) {
+	nick := client.Nick()
+	if subcommand == "subs" {
+		for _, line := range utils.ArgsToStrings(maxLastArgLength, client.MetadataSubscriptions(), " ") {
+			rb.Add(nil, server.name, RPL_METADATASUBS, nick, line)
+		}
+		rb.Add(nil, server.name, RPL_METADATAEND, nick, client.t("End of metadata"))
+		return
+	}
+
+	if len(keys) == 0 {
+		rb.Add(nil, server.name, ERR_NEEDMOREPARAMS, nick, "METADATA", client.t("Not enough parameters"))
+		return
+	}
+	var changed []string
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if !isValidMetadataKey(key) {
+			rb.Add(nil, server.name, "FAIL", "METADATA", "KEY_INVALID", key, client.t("Invalid key"))
+			continue
+		}
+		if subcommand == "sub" {
+			if !client.subscribeToMetadata(key, config.MaxSubs) {
+				rb.Add(nil, server.name, "FAIL", "METADATA", "TOO_MANY_SUBS", key, client.t("Too many subscriptions"))
+				break
+			}
+		} else {
+			client.unsubscribeFromMetadata(key)
+		}
+		changed = append(changed, key)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	numeric := RPL_METADATASUBOK
+	if subcommand == "unsub" {
+		numeric = RPL_METADATAUNSUBOK
+	}
+	rb.Add(nil, server.name, numeric, append([]string{nick}, changed...)...)
+}
+
+// subscribeToMetadata subscribes the client to a key, returning false if it
+// has too many subscriptions.
+func (client *Client) subscribeToMetadata(key string, maxSubs int) bool {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	if client.metadataSubs[key] {
+		return true
+	} else if maxSubs <= len(client.metadataSubs) {
+		return false
+	}
+	if client.metadataSubs == nil {
+		client.metadataSubs = make(map[string]bool)
+	}
+	client.metadataSubs[key] = true
+	return true
+}
+
+func (client *Client) unsubscribeFromMetadata(key string) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	delete(client.metadataSubs, key)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsValidMetadataKey(t *testing.T) {
+	for _, key := range []string{"url", "display-name", "avatar", "oragono.io/x_y"} {
+		if !isValidMetadataKey(key) {
+			t.Errorf("%s should be valid", key)
+		}
+	}
+	for _, key := range []string{"", "URL", "/url", "a b", "émoji"} {
+		if isValidMetadataKey(key) {
+			t.Errorf("%s should be invalid", key)
+		}
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	metadata, err := updateMetadata(nil, "url", "https://example.com", 2)
+	if err != nil || metadata["url"] != "https://example.com" {
+		t.Fatalf("couldn't set key: %v", err)
+	}
+	metadata, _ = updateMetadata(metadata, "avatar", "a.png", 2)
+	if _, err := updateMetadata(metadata, "color", "red", 2); err != errMetadataLimitReached {
+		t.Errorf("limit should be enforced")
+	}
+	// existing keys can always be modified
+	if _, err := updateMetadata(metadata, "avatar", "b.png", 2); err != nil {
+		t.Errorf("couldn't modify key: %v", err)
+	}
+	metadata, _ = updateMetadata(metadata, "url", "", 2)
+	if !reflect.DeepEqual(metadata, map[string]string{"avatar": "b.png"}) {
+		t.Errorf("unexpected metadata: %v", metadata)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Connect()
+	alice.Send("CAP", "REQ", "draft/metadata-2")
+	alice.Expect("CAP")
+	alice.Send("NICK", "alice")
+	alice.Send("USER", "u", "0", "*", "simulated client")
+	alice.Send("CAP", "END")
+	alice.Expect(RPL_WELCOME)
+	bob := h.Register("bob")
+
+	alice.Send("METADATA", "*", "SUB", "avatar", "url")
+	alice.Expect(RPL_METADATASUBOK)
+	for _, client := range []*testClient{alice, bob} {
+		client.Send("JOIN", "#test")
+		client.Expect(RPL_ENDOFNAMES)
+	}
+
+	bob.Send("METADATA", "*", "SET", "avatar", "https://example.com/bob.png")
+	bob.Expect(RPL_KEYVALUE)
+	if msg := alice.Expect("METADATA"); !reflect.DeepEqual(msg.Params, []string{"bob", "avatar", "*", "https://example.com/bob.png"}) {
+		t.Errorf("unexpected notification: %v", msg)
+	}
+	alice.Send("METADATA", "bob", "GET", "avatar", "url")
+	if msg := alice.Expect(RPL_KEYVALUE); msg.Params[len(msg.Params)-1] != "https://example.com/bob.png" {
+		t.Errorf("unexpected value: %v", msg)
+	}
+	alice.Expect(RPL_KEYNOTSET)
+
+	bob.Send("METADATA", "*", "SET", "password", "hunter2")
+	if msg := bob.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "KEY_INVALID" {
+		t.Errorf("keys that aren't allowed should be rejected: %v", msg)
+	}
+	bob.Send("METADATA", "alice", "SET", "avatar", "x")
+	if msg := bob.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "KEY_NO_PERMISSION" {
+		t.Errorf("other users' metadata can't be modified: %v", msg)
+	}
+	// alice is the channel operator, bob isn't
+	bob.Send("METADATA", "#test", "SET", "url", "https://example.com")
+	if msg := bob.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "KEY_NO_PERMISSION" {
+		t.Errorf("only channel operators can modify channel metadata: %v", msg)
+	}
+	alice.Send("METADATA", "#test", "SET", "url", "https://example.com")
+	alice.Expect(RPL_KEYVALUE)
+	bob.Send("METADATA", "#test", "LIST")
+	if msg := bob.Expect(RPL_KEYVALUE); !reflect.DeepEqual(msg.Params, []string{"bob", "#test", "url", "*", "https://example.com"}) {
+		t.Errorf("unexpected metadata: %v", msg)
+	}
+	bob.Expect(RPL_METADATAEND)
+}
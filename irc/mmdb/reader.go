@@ -0,0 +1,337 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+// Package mmdb reads MaxMind DB files (the format of the GeoIP2 and GeoLite2
+// databases), as specified at https://maxmind.github.io/MaxMind-DB/
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+const (
+	// the data section is separated from the search tree by 16 zero bytes
+	dataSectionSeparatorSize = 16
+	// maximum nesting of maps and arrays we're willing to decode
+	maxDecodeDepth = 32
+)
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var (
+	metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+	ErrInvalidDatabase = errors.New("invalid MaxMind DB file")
+	errUnsupportedIP   = errors.New("IPv6 address in an IPv4-only database")
+)
+
+// Reader looks up IP addresses in a MaxMind DB file, which it holds in memory.
+type Reader struct {
+	buffer     []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// the node at which IPv4 lookups start, in an IPv6 database
+	ipv4Start uint
+	// DatabaseType is from the file's metadata, e.g., "GeoLite2-Country"
+	DatabaseType string
+}
+
+// Open reads the database at `path`.
+func Open(path string) (*Reader, error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buffer)
+}
+
+// FromBytes parses a database that has already been read into memory.
+func FromBytes(buffer []byte) (reader *Reader, err error) {
+	metadataStart := bytes.LastIndex(buffer, metadataStartMarker)
+	if metadataStart == -1 {
+		return nil, ErrInvalidDatabase
+	}
+	metadataStart += len(metadataStartMarker)
+	metadataDecoder := decoder{buffer: buffer[metadataStart:]}
+	rawMetadata, _, err := metadataDecoder.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := rawMetadata.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	reader = &Reader{
+		nodeCount:  metadataUint(metadata, "node_count"),
+		recordSize: metadataUint(metadata, "record_size"),
+		ipVersion:  metadataUint(metadata, "ip_version"),
+	}
+	reader.DatabaseType, _ = metadata["database_type"].(string)
+	if reader.recordSize != 24 && reader.recordSize != 28 && reader.recordSize != 32 {
+		return nil, ErrInvalidDatabase
+	}
+	reader.buffer = buffer[:metadataStart-len(metadataStartMarker)]
+	// the search tree and the data section separator must fit before the metadata;
+	// every node takes at least 6 bytes, so checking the node count first keeps
+	// the tree size from overflowing
+	if uint(len(reader.buffer)) < reader.nodeCount {
+		return nil, ErrInvalidDatabase
+	}
+	treeSize := reader.nodeCount * reader.recordSize / 4
+	if uint(len(reader.buffer)) < treeSize+dataSectionSeparatorSize {
+		return nil, ErrInvalidDatabase
+	}
+
+	if reader.ipVersion == 6 {
+		// IPv4 addresses are stored as ::a.b.c.d, i.e., under 96 zero bits
+		node := uint(0)
+		for i := 0; i < 96 && node < reader.nodeCount; i++ {
+			node, err = reader.readNode(node, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		reader.ipv4Start = node
+	}
+	return reader, nil
+}
+
+func metadataUint(metadata map[string]interface{}, key string) uint {
+	value, _ := metadata[key].(uint64)
+	return uint(value)
+}
+
+// readNode returns the left (bit == 0) or right (bit == 1) record of a node.
+func (reader *Reader) readNode(node uint, bit uint) (uint, error) {
+	offset := node * reader.recordSize / 4
+	if uint(len(reader.buffer)) < offset+reader.recordSize/4 {
+		return 0, ErrInvalidDatabase
+	}
+	b := reader.buffer[offset:]
+	switch reader.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+// Lookup returns the record for the network containing `ip`, or nil if there is none.
+// Records are decoded into map[string]interface{}, []interface{}, string, []byte,
+// bool, float32, float64, int32, uint64, or *big.Int.
+func (reader *Reader) Lookup(ip net.IP) (record interface{}, err error) {
+	node := uint(0)
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+		node = reader.ipv4Start
+	} else if reader.ipVersion == 4 {
+		return nil, errUnsupportedIP
+	}
+
+	bitCount := uint(len(ip)) * 8
+	for i := uint(0); i < bitCount && node < reader.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-(i%8))) & 1
+		node, err = reader.readNode(node, bit)
+		if err != nil {
+			return
+		}
+	}
+
+	if node == reader.nodeCount {
+		// not found
+		return nil, nil
+	} else if node < reader.nodeCount {
+		return nil, ErrInvalidDatabase
+	}
+	treeSize := reader.nodeCount * reader.recordSize / 4
+	offset := node - reader.nodeCount - dataSectionSeparatorSize
+	d := decoder{buffer: reader.buffer[treeSize+dataSectionSeparatorSize:]}
+	record, _, err = d.decode(offset, 0)
+	return
+}
+
+// decoder decodes values from a data section.
+type decoder struct {
+	buffer []byte
+}
+
+func (d *decoder) bytes(offset, size uint) ([]byte, error) {
+	if uint(len(d.buffer)) < offset+size || offset+size < offset {
+		return nil, ErrInvalidDatabase
+	}
+	return d.buffer[offset : offset+size], nil
+}
+
+func (d *decoder) uint(offset, size uint) (result uint64, err error) {
+	b, err := d.bytes(offset, size)
+	for _, v := range b {
+		result = result<<8 | uint64(v)
+	}
+	return
+}
+
+// decode decodes the value at `offset`, returning it and the offset just past it.
+func (d *decoder) decode(offset uint, depth int) (value interface{}, next uint, err error) {
+	if maxDecodeDepth < depth {
+		return nil, 0, ErrInvalidDatabase
+	}
+	ctrl, err := d.bytes(offset, 1)
+	if err != nil {
+		return
+	}
+	offset++
+	dataType := uint(ctrl[0] >> 5)
+
+	if dataType == typePointer {
+		pointerSize := uint((ctrl[0]>>3)&0x3) + 1
+		var pointer uint64
+		pointer, err = d.uint(offset, pointerSize)
+		if err != nil {
+			return
+		}
+		switch pointerSize {
+		case 1:
+			pointer = uint64(ctrl[0]&0x7)<<8 | pointer
+		case 2:
+			pointer = (uint64(ctrl[0]&0x7)<<16 | pointer) + 2048
+		case 3:
+			pointer = (uint64(ctrl[0]&0x7)<<24 | pointer) + 526336
+		}
+		value, _, err = d.decode(uint(pointer), depth+1)
+		return value, offset + pointerSize, err
+	}
+
+	if dataType == typeExtended {
+		var extended uint64
+		extended, err = d.uint(offset, 1)
+		if err != nil {
+			return
+		}
+		dataType = uint(extended) + 7
+		offset++
+	}
+
+	size := uint(ctrl[0] & 0x1f)
+	if 29 <= size {
+		extraBytes := size - 28
+		var extra uint64
+		extra, err = d.uint(offset, extraBytes)
+		if err != nil {
+			return
+		}
+		offset += extraBytes
+		switch extraBytes {
+		case 1:
+			size = 29 + uint(extra)
+		case 2:
+			size = 285 + uint(extra)
+		case 3:
+			size = 65821 + uint(extra)
+		}
+	}
+
+	switch dataType {
+	case typeMap:
+		result := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, entry interface{}
+			key, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidDatabase
+			}
+			entry, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return
+			}
+			result[keyString] = entry
+		}
+		return result, offset, nil
+	case typeArray:
+		result := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var entry interface{}
+			entry, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return
+			}
+			result = append(result, entry)
+		}
+		return result, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return
+	}
+	next = offset + size
+	switch dataType {
+	case typeString:
+		value = string(b)
+	case typeBytes:
+		value = append([]byte(nil), b...)
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		value = math.Float64frombits(binary.BigEndian.Uint64(b))
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		value = math.Float32frombits(binary.BigEndian.Uint32(b))
+	case typeUint16, typeUint32, typeUint64:
+		var result uint64
+		for _, v := range b {
+			result = result<<8 | uint64(v)
+		}
+		value = result
+	case typeInt32:
+		var result uint32
+		for _, v := range b {
+			result = result<<8 | uint32(v)
+		}
+		value = int32(result)
+	case typeUint128:
+		value = new(big.Int).SetBytes(b)
+	default:
+		return nil, 0, ErrInvalidDatabase
+	}
+	return
+}
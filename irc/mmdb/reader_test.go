@@ -0,0 +1,116 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package mmdb
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// minimal encoder for the data section format, supporting what the tests need
+func encodeValue(value interface{}) (result []byte) {
+	switch v := value.(type) {
+	case string:
+		result = append(result, byte(typeString<<5|len(v)))
+		result = append(result, v...)
+	case uint32:
+		result = append(result, byte(typeUint32<<5|4), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case int32:
+		// extended type: the type byte follows the control byte
+		result = append(result, 4, byte(typeInt32-7), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case map[string]interface{}:
+		result = append(result, byte(typeMap<<5|len(v)))
+		for key, entry := range v {
+			result = append(result, encodeValue(key)...)
+			result = append(result, encodeValue(entry)...)
+		}
+	}
+	return
+}
+
+// makeTestDatabase builds an IPv4 database with 24-bit records, containing
+// a single network, 10.0.0.0/8.
+func makeTestDatabase(record map[string]interface{}) []byte {
+	const nodeCount = 8
+	var tree []byte
+	prefix := byte(10)
+	for i := uint(0); i < nodeCount; i++ {
+		bit := (prefix >> (7 - i)) & 1
+		match := uint32(i + 1)
+		if i == nodeCount-1 {
+			// pointer to the start of the data section
+			match = nodeCount + dataSectionSeparatorSize
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[bit] = match
+		for _, r := range records {
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	var buffer []byte
+	buffer = append(buffer, tree...)
+	buffer = append(buffer, make([]byte, dataSectionSeparatorSize)...)
+	buffer = append(buffer, encodeValue(record)...)
+	buffer = append(buffer, metadataStartMarker...)
+	buffer = append(buffer, encodeValue(map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint32(24),
+		"ip_version":    uint32(4),
+		"database_type": "Test-Country",
+	})...)
+	return buffer
+}
+
+func TestLookup(t *testing.T) {
+	db := makeTestDatabase(map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "US"},
+		"offset":  int32(-5),
+	})
+	reader, err := FromBytes(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.DatabaseType != "Test-Country" {
+		t.Errorf("incorrect database type: %s", reader.DatabaseType)
+	}
+
+	record, err := reader.Lookup(net.ParseIP("10.1.2.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordMap, _ := record.(map[string]interface{})
+	country, _ := recordMap["country"].(map[string]interface{})
+	if country["iso_code"] != "US" || recordMap["offset"] != int32(-5) {
+		t.Errorf("incorrect record: %v", record)
+	}
+
+	record, err = reader.Lookup(net.ParseIP("192.168.1.1"))
+	if record != nil || err != nil {
+		t.Errorf("unexpected record for address outside the database: %v %v", record, err)
+	}
+
+	if _, err = reader.Lookup(net.ParseIP("2001:db8::1")); err == nil {
+		t.Errorf("IPv6 lookup in an IPv4 database should fail")
+	}
+}
+
+func TestInvalidDatabase(t *testing.T) {
+	if _, err := FromBytes([]byte("not a database")); err == nil {
+		t.Errorf("invalid database was accepted")
+	}
+	db := makeTestDatabase(map[string]interface{}{})
+	// truncate the search tree
+	if _, err := FromBytes(db[20:]); err == nil {
+		t.Errorf("truncated database was accepted")
+	}
+	// truncate the data section separator, leaving the metadata intact
+	const treeSize = 8 * 24 / 4
+	metadataStart := bytes.LastIndex(db, metadataStartMarker)
+	truncated := append(append([]byte(nil), db[:treeSize+4]...), db[metadataStart:]...)
+	if _, err := FromBytes(truncated); err == nil {
+		t.Errorf("database with a truncated separator was accepted")
+	}
+}
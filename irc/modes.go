@@ -27,28 +27,25 @@ func ApplyUserModeChanges(client *Client, changes modes.ModeChanges, force bool)
 
 	for _, change := range changes {
 		switch change.Mode {
-		case modes.Bot, modes.Invisible, modes.WallOps, modes.UserRoleplaying, modes.Operator, modes.LocalOperator, modes.RegisteredOnly:
+		case modes.Bot, modes.CallerID, modes.Invisible, modes.WallOps, modes.UserRoleplaying, modes.Operator, modes.LocalOperator, modes.RegisteredOnly:
 			switch change.Op {
 			case modes.Add:
 				if !force && (change.Mode == modes.Operator || change.Mode == modes.LocalOperator) {
 					continue
 				}
+				if !force && change.Mode == modes.Bot && !client.canSetBotMode() {
+					continue
+				}
 
-				if client.SetMode(change.Mode, true) {
-					if change.Mode == modes.Invisible {
-						client.server.stats.ChangeInvisible(1)
-					} else if change.Mode == modes.Operator || change.Mode == modes.LocalOperator {
-						client.server.stats.ChangeOperators(1)
-					}
+				if client.setCountedMode(change.Mode, true) {
 					applied = append(applied, change)
 				}
 
 			case modes.Remove:
-				if client.SetMode(change.Mode, false) {
-					if change.Mode == modes.Invisible {
-						client.server.stats.ChangeInvisible(-1)
-					} else if change.Mode == modes.Operator || change.Mode == modes.LocalOperator {
-						client.server.stats.ChangeOperators(-1)
+				if client.setCountedMode(change.Mode, false) {
+					if change.Mode == modes.Operator || change.Mode == modes.LocalOperator {
+						// drop any oper-specific sendq
+						client.socket.SetMaxSendQ(client.connectionClass().MaxSendQBytes)
 					}
 					applied = append(applied, change)
 				}
@@ -163,10 +160,11 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 			}
 
 			// confirm mask looks valid
-			mask, err := Casefold(change.Arg)
+			mask, err := CanonicalizeMask(change.Arg)
 			if err != nil {
 				continue
 			}
+			change.Arg = mask
 
 			switch change.Op {
 			case modes.Add:
@@ -178,12 +176,14 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 					continue
 				}
 
-				channel.lists[change.Mode].Add(mask)
-				applied = append(applied, change)
+				if channel.lists[change.Mode].Add(mask) {
+					applied = append(applied, change)
+				}
 
 			case modes.Remove:
-				channel.lists[change.Mode].Remove(mask)
-				applied = append(applied, change)
+				if channel.lists[change.Mode].Remove(mask) {
+					applied = append(applied, change)
+				}
 			}
 
 		case modes.UserLimit:
@@ -210,7 +210,7 @@ func (channel *Channel) ApplyChannelModeChanges(client *Client, isSamode bool, c
 				applied = append(applied, change)
 			}
 
-		case modes.InviteOnly, modes.Moderated, modes.NoOutside, modes.OpOnlyTopic, modes.RegisteredOnly, modes.Secret, modes.ChanRoleplaying:
+		case modes.InviteOnly, modes.Moderated, modes.NoKnock, modes.NoOutside, modes.OpOnlyTopic, modes.RegisteredOnly, modes.Secret, modes.SecureOnly, modes.ChanRoleplaying:
 			if change.Op == modes.List {
 				continue
 			}
@@ -15,13 +15,13 @@ import (
 var (
 	// SupportedUserModes are the user modes that we actually support (modifying).
 	SupportedUserModes = Modes{
-		Away, Bot, Invisible, Operator, RegisteredOnly, ServerNotice, UserRoleplaying,
+		Away, Bot, CallerID, Invisible, Operator, RegisteredOnly, ServerNotice, UserRoleplaying,
 	}
 
 	// SupportedChannelModes are the channel modes that we support.
 	SupportedChannelModes = Modes{
 		BanMask, ChanRoleplaying, ExceptMask, InviteMask, InviteOnly, Key,
-		Moderated, NoOutside, OpOnlyTopic, RegisteredOnly, Secret, UserLimit,
+		Moderated, NoKnock, NoOutside, OpOnlyTopic, RegisteredOnly, Secret, SecureOnly, UserLimit,
 	}
 )
 
@@ -109,6 +109,7 @@ func (modes Modes) String() string {
 const (
 	Away            Mode = 'a'
 	Bot             Mode = 'B'
+	CallerID        Mode = 'g'
 	Invisible       Mode = 'i'
 	LocalOperator   Mode = 'O'
 	Operator        Mode = 'o'
@@ -129,11 +130,13 @@ const (
 	InviteOnly      Mode = 'i' // flag
 	Key             Mode = 'k' // flag arg
 	Moderated       Mode = 'm' // flag
+	NoKnock         Mode = 'K' // flag
 	NoOutside       Mode = 'n' // flag
 	OpOnlyTopic     Mode = 't' // flag
 	// RegisteredOnly mode is reused here from umode definition
-	Secret    Mode = 's' // flag
-	UserLimit Mode = 'l' // flag arg
+	Secret     Mode = 's' // flag
+	SecureOnly Mode = 'z' // flag
+	UserLimit  Mode = 'l' // flag arg
 )
 
 var (
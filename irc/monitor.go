@@ -4,14 +4,14 @@
 package irc
 
 import (
-	"sync"
-
 	"github.com/goshuirc/irc-go/ircmsg"
+
+	"github.com/oragono/oragono/irc/lockorder"
 )
 
 // MonitorManager keeps track of who's monitoring which nicks.
 type MonitorManager struct {
-	sync.RWMutex // tier 2
+	lockorder.Tier2RWMutex
 	// client -> nicks it's watching
 	watching map[*Client]map[string]bool
 	// nick -> clients watching it
@@ -30,8 +30,21 @@ func NewMonitorManager() *MonitorManager {
 
 // AlertAbout alerts everyone monitoring `client`'s nick that `client` is now {on,off}line.
 func (manager *MonitorManager) AlertAbout(client *Client, online bool) {
-	cfnick := client.NickCasefolded()
-	nick := client.Nick()
+	manager.alertAboutNick(client.server, client.Nick(), client.NickCasefolded(), online)
+}
+
+// AlertAboutNickChange alerts everyone monitoring `client`'s old nick that it is now offline,
+// and everyone monitoring its new nick that it is now online. A change that only affects
+// the capitalization of the nick doesn't change who's monitoring it, so nobody is alerted.
+func (manager *MonitorManager) AlertAboutNickChange(client *Client, oldNick, oldCfnick string) {
+	if oldCfnick == client.NickCasefolded() {
+		return
+	}
+	manager.alertAboutNick(client.server, oldNick, oldCfnick, false)
+	manager.alertAboutNick(client.server, client.Nick(), client.NickCasefolded(), true)
+}
+
+func (manager *MonitorManager) alertAboutNick(server *Server, nick, cfnick string, online bool) {
 	var watchers []*Client
 	// safely copy the list of clients watching our nick
 	manager.RLock()
@@ -46,7 +59,7 @@ func (manager *MonitorManager) AlertAbout(client *Client, online bool) {
 	}
 
 	for _, mClient := range watchers {
-		mClient.Send(nil, client.server.name, command, mClient.Nick(), nick)
+		mClient.Send(nil, server.name, command, mClient.Nick(), nick)
 	}
 }
 
@@ -55,6 +68,13 @@ func (manager *MonitorManager) Add(client *Client, nick string, limit int) error
 	manager.Lock()
 	defer manager.Unlock()
 
+	if manager.watching[client][nick] {
+		return nil
+	}
+	if len(manager.watching[client]) >= limit {
+		return errMonitorLimitExceeded
+	}
+
 	if manager.watching[client] == nil {
 		manager.watching[client] = make(map[string]bool)
 	}
@@ -62,10 +82,6 @@ func (manager *MonitorManager) Add(client *Client, nick string, limit int) error
 		manager.watchedby[nick] = make(map[*Client]bool)
 	}
 
-	if len(manager.watching[client]) >= limit {
-		return errMonitorLimitExceeded
-	}
-
 	manager.watching[client][nick] = true
 	manager.watchedby[nick][client] = true
 	return nil
@@ -78,6 +94,13 @@ func (manager *MonitorManager) Remove(client *Client, nick string) error {
 	// deleting from nil maps is fine
 	delete(manager.watching[client], nick)
 	delete(manager.watchedby[nick], client)
+	// clean up empty entries, so the reverse index doesn't grow without bound
+	if len(manager.watching[client]) == 0 {
+		delete(manager.watching, client)
+	}
+	if len(manager.watchedby[nick]) == 0 {
+		delete(manager.watchedby, nick)
+	}
 	return nil
 }
 
@@ -88,6 +111,9 @@ func (manager *MonitorManager) Resume(newClient, oldClient *Client) error {
 	// newClient is now watching everyone oldClient was watching
 	oldTargets := manager.watching[oldClient]
 	delete(manager.watching, oldClient)
+	if len(oldTargets) == 0 {
+		return nil
+	}
 	manager.watching[newClient] = oldTargets
 
 	// update watchedby as well
@@ -106,6 +132,9 @@ func (manager *MonitorManager) RemoveAll(client *Client) {
 
 	for nick := range manager.watching[client] {
 		delete(manager.watchedby[nick], client)
+		if len(manager.watchedby[nick]) == 0 {
+			delete(manager.watchedby, nick)
+		}
 	}
 	delete(manager.watching, client)
 }
@@ -120,6 +149,32 @@ func (manager *MonitorManager) List(client *Client) (nicks []string) {
 	return nicks
 }
 
+// persistMonitorList stores `client`'s monitor list with their account, if that's enabled.
+func persistMonitorList(server *Server, client *Client) {
+	account := client.Account()
+	if account == "" || !server.AccountConfig().MonitorPersistence {
+		return
+	}
+	err := server.accounts.StoreMonitorList(account, server.monitorManager.List(client))
+	if err != nil {
+		server.logger.Error("internal", "couldn't store monitor list", account, err.Error())
+	}
+}
+
+// restoreMonitorList adds the monitor list stored with `client`'s account to their current list.
+func restoreMonitorList(server *Server, client *Client) {
+	account := client.Account()
+	if account == "" || !server.AccountConfig().MonitorPersistence {
+		return
+	}
+	limit := server.Limits().MonitorEntries
+	for _, cfnick := range server.accounts.LoadMonitorList(account) {
+		if server.monitorManager.Add(client, cfnick, limit) != nil {
+			break
+		}
+	}
+}
+
 var (
 	monitorSubcommands = map[string]func(server *Server, client *Client, msg ircmsg.IrcMessage, rb *ResponseBuffer) bool{
 		"-": monitorRemoveHandler,
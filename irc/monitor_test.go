@@ -0,0 +1,73 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitorManager(t *testing.T) {
+	mm := NewMonitorManager()
+	alice, bob := new(Client), new(Client)
+
+	if err := mm.Add(alice, "dan", 2); err != nil {
+		t.Fatalf("couldn't add monitor entry: %v", err)
+	}
+	mm.Add(alice, "shivaram", 2)
+	// re-adding an existing entry doesn't count against the limit
+	if err := mm.Add(alice, "dan", 2); err != nil {
+		t.Errorf("re-adding an entry failed: %v", err)
+	}
+	if err := mm.Add(alice, "jesopo", 2); err != errMonitorLimitExceeded {
+		t.Errorf("limit was not enforced: %v", err)
+	}
+	if _, ok := mm.watchedby["jesopo"]; ok {
+		t.Errorf("rejected entry leaked into the reverse index")
+	}
+
+	mm.Add(bob, "dan", 2)
+	if len(mm.watchedby["dan"]) != 2 {
+		t.Errorf("incorrect reverse index: %v", mm.watchedby)
+	}
+
+	mm.Remove(alice, "shivaram")
+	if _, ok := mm.watchedby["shivaram"]; ok {
+		t.Errorf("empty reverse index entry was not cleaned up")
+	}
+
+	mm.RemoveAll(alice)
+	mm.Remove(bob, "dan")
+	if len(mm.watching) != 0 || len(mm.watchedby) != 0 {
+		t.Errorf("monitor state was not cleaned up: %v %v", mm.watching, mm.watchedby)
+	}
+}
+
+func TestMonitorNickChange(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	bob := h.Register("bob")
+	bob.Send("MONITOR", "+", "foo,bar")
+	bob.Expect(RPL_MONOFFLINE)
+	foo := h.Register("Foo")
+	bob.Expect(RPL_MONONLINE)
+
+	// changing the capitalization of a nick isn't going offline
+	foo.Send("NICK", "foo")
+	foo.Expect("NICK")
+	if msg, ok := bob.ExpectWithin(RPL_MONOFFLINE, 100*time.Millisecond); ok {
+		t.Errorf("case-only nick change was reported as going offline: %v", msg)
+	}
+
+	// but changing to a different nick is
+	foo.Send("NICK", "bar")
+	foo.Expect("NICK")
+	if msg := bob.Expect(RPL_MONOFFLINE); msg.Params[1] != "foo" {
+		t.Errorf("unexpected notification: %v", msg)
+	}
+	if msg := bob.Expect(RPL_MONONLINE); msg.Params[1] != "bar" {
+		t.Errorf("unexpected notification: %v", msg)
+	}
+}
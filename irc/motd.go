@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+)
+
+// MOTD-style files (the MOTD and the rules) are processed as follows when they're
+// loaded (at startup and on every rehash):
+//
+// 1. a line of the form `@include <filename>` is replaced by the contents of that file;
+//    relative paths are resolved relative to the directory of the including file.
+// 2. if formatting is enabled, `**text**` is bold and `__text__` is underlined,
+//    in addition to the usual ircfmt escapes like $b, $i, and $c[red].
+//
+// Substitutions like {users} are performed every time the file is sent to a client,
+// so that they stay current; see motdSubstitutions.
+
+const (
+	// maximum depth of nested @include directives, to prevent include loops
+	motdMaxIncludeDepth = 4
+)
+
+var (
+	motdMarkdownReplacer = strings.NewReplacer(
+		"**", "$b",
+		"__", "$u",
+	)
+)
+
+// readMOTDFile reads a MOTD-style text file (the MOTD itself, or the rules),
+// returning its lines ready to be sent to clients.
+func readMOTDFile(path string, useFormatting bool) (lines []string, err error) {
+	lines = make([]string, 0)
+	if path == "" {
+		return
+	}
+
+	rawLines, err := readMOTDLines(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range rawLines {
+		if useFormatting {
+			line = ircfmt.Unescape(motdMarkdownReplacer.Replace(line))
+		}
+
+		// "- " is the required prefix for MOTD, we just add it here to make
+		// bursting it out to clients easier
+		lines = append(lines, fmt.Sprintf("- %s", line))
+	}
+	return lines, nil
+}
+
+// readMOTDLines reads the lines of a MOTD-style file, expanding @include directives.
+func readMOTDLines(path string, depth int) (lines []string, err error) {
+	if motdMaxIncludeDepth < depth {
+		return nil, fmt.Errorf("includes nested too deeply at %s", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, "@include ") {
+			includePath := strings.TrimSpace(strings.TrimPrefix(line, "@include "))
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(path), includePath)
+			}
+			included, err := readMOTDLines(includePath, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, included...)
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// motdSubstitutions returns a replacer for the dynamic values that can be
+// used in MOTD-style files, as seen by the given client.
+func (server *Server) motdSubstitutions(client *Client) *strings.Replacer {
+	stats := server.stats.GetValues()
+	uptime := time.Since(server.ctime).Truncate(time.Second)
+	return strings.NewReplacer(
+		"{server}", server.name,
+		"{network}", server.Config().Network.Name,
+		"{version}", Ver,
+		"{nick}", client.Nick(),
+		"{users}", strconv.Itoa(stats.Total),
+		"{opers}", strconv.Itoa(stats.Operators),
+		"{channels}", strconv.Itoa(server.channels.Len()),
+		"{uptime}", uptime.String(),
+	)
+}
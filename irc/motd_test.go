@@ -0,0 +1,45 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadMOTDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oragono-motd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	writeFile("included.motd", "included line\n")
+	motdPath := writeFile("main.motd", "first\n@include included.motd\n**bold** on {server}\n")
+
+	lines, err := readMOTDFile(motdPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"- first", "- included line", "- \x02bold\x02 on {server}"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %#v, got %#v", expected, lines)
+	}
+
+	loopPath := writeFile("loop.motd", "@include loop.motd\n")
+	if _, err := readMOTDFile(loopPath, false); err == nil {
+		t.Error("include loop should be an error")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
+
+// Some deployments can only expose a single port (typically 443), so a TLS
+// listener can serve both IRC and the server's HTTP endpoints. After the TLS
+// handshake, connections that negotiated HTTP via ALPN, or that requested one
+// of the configured HTTP server names via SNI, are handed to an HTTP server;
+// all others (including clients that negotiated ALPN "irc", or used no ALPN
+// at all) are treated as IRC clients.
+
+const (
+	alpnIRC  = "irc"
+	alpnHTTP = "http/1.1"
+)
+
+var (
+	errMultiplexerClosed = errors.New("HTTP multiplexer is closed")
+)
+
+// httpMultiplexConfig describes how a TLS listener recognizes HTTP connections.
+type httpMultiplexConfig struct {
+	serverNames map[string]bool
+}
+
+// multiplexConfig returns the listener's HTTP multiplexing configuration, or
+// nil if it only serves IRC.
+func (conf *TLSListenConfig) multiplexConfig() *httpMultiplexConfig {
+	if !conf.MultiplexHTTP {
+		return nil
+	}
+	result := &httpMultiplexConfig{serverNames: make(map[string]bool)}
+	for _, name := range conf.HTTPServerNames {
+		result.serverNames[strings.ToLower(name)] = true
+	}
+	return result
+}
+
+// isHTTP returns whether a connection should be served HTTP, given the
+// result of its TLS handshake.
+func (conf *httpMultiplexConfig) isHTTP(state tls.ConnectionState) bool {
+	switch state.NegotiatedProtocol {
+	case alpnHTTP:
+		return true
+	case alpnIRC:
+		return false
+	default:
+		return conf.serverNames[strings.ToLower(state.ServerName)]
+	}
+}
+
+// connListener is a net.Listener whose connections are accepted elsewhere,
+// then passed in with `serve`.
+type connListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (cl *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-cl.conns:
+		return conn, nil
+	case <-cl.closed:
+		return nil, errMultiplexerClosed
+	}
+}
+
+func (cl *connListener) Close() error {
+	cl.closeOnce.Do(func() {
+		close(cl.closed)
+	})
+	return nil
+}
+
+func (cl *connListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// serve hands a connection to the listener's consumer, closing it instead
+// if the listener is closed; it returns whether the connection was accepted.
+func (cl *connListener) serve(conn net.Conn) bool {
+	select {
+	case cl.conns <- conn:
+		return true
+	case <-cl.closed:
+		conn.Close()
+		return false
+	}
+}
+
+// httpMultiplexer serves HTTP to connections accepted by IRC listeners.
+type httpMultiplexer struct {
+	listener   *connListener
+	httpServer *http.Server
+
+	releaseMutex lockorder.Tier0Mutex
+	// connections hold their slot in the connection limits until they're closed
+	releases map[net.Conn]func()
+}
+
+func newHTTPMultiplexer(server *Server) *httpMultiplexer {
+	mux := &httpMultiplexer{
+		listener: newConnListener(),
+		releases: make(map[net.Conn]func()),
+	}
+	mux.httpServer = &http.Server{
+		Handler:      &multiplexedHandler{server: server, api: server.apiHandler()},
+		ReadTimeout:  time.Minute,
+		WriteTimeout: time.Minute,
+		ConnState:    mux.connState,
+	}
+	go mux.httpServer.Serve(mux.listener)
+	return mux
+}
+
+// serve hands a connection to the HTTP server; `release` is called once
+// the connection is closed.
+func (mux *httpMultiplexer) serve(conn net.Conn, release func()) {
+	mux.releaseMutex.Lock()
+	mux.releases[conn] = release
+	mux.releaseMutex.Unlock()
+
+	if !mux.listener.serve(conn) {
+		mux.connState(conn, http.StateClosed)
+	}
+}
+
+func (mux *httpMultiplexer) connState(conn net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	mux.releaseMutex.Lock()
+	release := mux.releases[conn]
+	delete(mux.releases, conn)
+	mux.releaseMutex.Unlock()
+
+	if release != nil {
+		release()
+	}
+}
+
+func (mux *httpMultiplexer) stop() {
+	mux.httpServer.Close()
+}
+
+// multiplexedHandler dispatches requests to whichever HTTP endpoints are enabled.
+type multiplexedHandler struct {
+	server *Server
+	api    http.Handler
+}
+
+func (mh *multiplexedHandler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	if mh.server.Config().API.Enabled {
+		mh.api.ServeHTTP(w, request)
+	} else {
+		http.NotFound(w, request)
+	}
+}
+
+// routeHTTP completes the TLS handshake for a connection accepted by a
+// multiplexed listener, and if it's an HTTP connection, hands it to the HTTP
+// server; it returns whether it did so. The connection must already have
+// passed the ban checks and been added to the connection limits.
+func (server *Server) routeHTTP(conn clientConn, ipaddr net.IP) bool {
+	tlsConn, ok := conn.Conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+	// handshake failures are handled (and logged) by the IRC client code
+	if err != nil || !conn.Multiplex.isHTTP(tlsConn.ConnectionState()) {
+		return false
+	}
+	server.logger.Debug("localconnect-ip", "HTTP connection from", tlsConn.RemoteAddr().String())
+	release := func() { server.connectionLimiter.RemoveClient(ipaddr) }
+	if conn.IsTor {
+		release = server.torLimiter.RemoveClient
+	}
+	server.httpMultiplexer.serve(tlsConn, release)
+	return true
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestMultiplexIsHTTP(t *testing.T) {
+	if (&TLSListenConfig{}).multiplexConfig() != nil {
+		t.Errorf("listeners shouldn't multiplex HTTP by default")
+	}
+	conf := (&TLSListenConfig{MultiplexHTTP: true, HTTPServerNames: []string{"API.example.com"}}).multiplexConfig()
+
+	cases := []struct {
+		state  tls.ConnectionState
+		isHTTP bool
+	}{
+		{tls.ConnectionState{}, false},
+		{tls.ConnectionState{NegotiatedProtocol: alpnHTTP}, true},
+		{tls.ConnectionState{NegotiatedProtocol: alpnIRC}, false},
+		{tls.ConnectionState{ServerName: "irc.example.com"}, false},
+		{tls.ConnectionState{ServerName: "api.example.com"}, true},
+		// explicitly negotiating IRC takes precedence over SNI
+		{tls.ConnectionState{ServerName: "api.example.com", NegotiatedProtocol: alpnIRC}, false},
+	}
+	for _, c := range cases {
+		if conf.isHTTP(c.state) != c.isHTTP {
+			t.Errorf("incorrect routing for %#v", c.state)
+		}
+	}
+}
+
+func TestConnListener(t *testing.T) {
+	listener := newConnListener()
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go listener.serve(server)
+	conn, err := listener.Accept()
+	if err != nil || conn != server {
+		t.Fatalf("connection was not accepted: %v", err)
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); err != errMultiplexerClosed {
+		t.Errorf("closed listener should not accept connections")
+	}
+	// connections served after closing are closed
+	other, otherServer := net.Pipe()
+	listener.serve(otherServer)
+	if _, err := other.Write([]byte("x")); err == nil {
+		t.Errorf("connection should have been closed")
+	}
+}
+
+func TestMultiplexerRelease(t *testing.T) {
+	mux := &httpMultiplexer{
+		listener: newConnListener(),
+		releases: make(map[net.Conn]func()),
+	}
+	releases := 0
+	release := func() { releases++ }
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go mux.serve(server, release)
+	if conn, _ := mux.listener.Accept(); conn != server {
+		t.Fatalf("connection was not accepted")
+	}
+	mux.connState(server, http.StateActive)
+	if releases != 0 {
+		t.Errorf("connection limit was released while the connection was open")
+	}
+	mux.connState(server, http.StateClosed)
+	mux.connState(server, http.StateClosed)
+	if releases != 1 {
+		t.Errorf("connection limit should be released once, was released %d times", releases)
+	}
+
+	// connections that are never accepted are released immediately
+	mux.listener.Close()
+	_, otherServer := net.Pipe()
+	mux.serve(otherServer, release)
+	if releases != 2 {
+		t.Errorf("connection limit for a rejected connection was not released")
+	}
+}
@@ -17,8 +17,7 @@ import (
 var (
 	// anything added here MUST be casefolded:
 	restrictedNicknames = map[string]bool{
-		"=scene=":  true, // used for rp commands
-		"histserv": true, // TODO(slingamn) this should become a real service
+		"=scene=": true, // used for rp commands
 	}
 )
 
@@ -32,7 +31,7 @@ func performNickChange(server *Server, client *Client, target *Client, newnick s
 		return false
 	}
 
-	if err != nil || len(nickname) > server.Limits().NickLen || restrictedNicknames[cfnick] {
+	if err != nil || len(nickname) > server.Limits().NickLen || restrictedNicknames[cfnick] || server.Config().Server.Relaymsg.isForbiddenNick(nickname) {
 		rb.Add(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, nickname, client.t("Erroneous nickname"))
 		return false
 	}
@@ -41,9 +40,26 @@ func performNickChange(server *Server, client *Client, target *Client, newnick s
 		return true
 	}
 
+	// nick changes by registered users are subject to the plugins
+	if client == target && target.Registered() {
+		event := newPluginEvent(target, pluginEventNick)
+		event.NewNick = nickname
+		verdict := server.plugins.Dispatch(event)
+		if verdict.Verdict == pluginVerdictBlock {
+			reason := verdict.Reason
+			if reason == "" {
+				reason = client.t("Erroneous nickname")
+			}
+			rb.Add(nil, server.name, ERR_ERRONEUSNICKNAME, client.nick, nickname, reason)
+			return false
+		}
+	}
+
 	hadNick := target.HasNick()
+	origNick := target.Nick()
+	origCfnick := target.NickCasefolded()
 	origNickMask := target.NickMaskString()
-	whowas := client.WhoWas()
+	whowas := target.WhoWas()
 	err = client.server.clients.SetNick(target, nickname)
 	if err == errNicknameInUse {
 		rb.Add(nil, server.name, ERR_NICKNAMEINUSE, client.nick, nickname, client.t("Nickname is already in use"))
@@ -71,7 +87,11 @@ func performNickChange(server *Server, client *Client, target *Client, newnick s
 	}
 
 	if target.Registered() {
-		client.server.monitorManager.AlertAbout(target, true)
+		if hadNick {
+			client.server.monitorManager.AlertAboutNickChange(target, origNick, origCfnick)
+		} else {
+			client.server.monitorManager.AlertAbout(target, true)
+		}
 	}
 	// else: Run() will attempt registration immediately after this
 	return true
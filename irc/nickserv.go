@@ -5,8 +5,14 @@ package irc
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/utils"
 )
 
 // "enabled" callbacks for specific nickserv commands
@@ -22,6 +28,22 @@ func servCmdRequiresNickRes(config *Config) bool {
 	return config.Accounts.AuthenticationEnabled && config.Accounts.NickReservation.Enabled
 }
 
+func nsEraseEnabled(config *Config) bool {
+	return config.Accounts.AuthenticationEnabled && config.Accounts.Erasure.Enabled
+}
+
+func nsPersonaEnabled(config *Config) bool {
+	return config.Accounts.AuthenticationEnabled && config.Accounts.Personas.Enabled
+}
+
+func nsAJoinEnabled(config *Config) bool {
+	return config.Accounts.AuthenticationEnabled && config.Accounts.AJoin.Enabled
+}
+
+func nsTokenEnabled(config *Config) bool {
+	return config.Accounts.AuthenticationEnabled && config.Accounts.APITokens.Enabled
+}
+
 func nsEnforceEnabled(config *Config) bool {
 	return servCmdRequiresNickRes(config) && config.Accounts.NickReservation.AllowCustomEnforcement
 }
@@ -44,6 +66,21 @@ Here are the commands you can use:
 
 var (
 	nickservCommands = map[string]*serviceCommand{
+		"ajoin": {
+			handler: nsAJoinHandler,
+			help: `Syntax: $bAJOIN [LIST]$b
+Or:     $bAJOIN ADD <#channel> [key]$b
+Or:     $bAJOIN DEL <#channel>$b
+Or:     $bAJOIN SAVE$b
+
+AJOIN manages your auto-join list: channels that you join automatically
+whenever you log in, in the order they were added. $bADD$b adds a channel
+(with its key, if it needs one) to the end of the list, and $bDEL$b removes
+one. $bSAVE$b replaces the list with the channels you're in now.`,
+			helpShort:    `$bAJOIN$b manages the channels you join when you log in.`,
+			enabled:      nsAJoinEnabled,
+			authRequired: true,
+		},
 		"drop": {
 			handler: nsDropHandler,
 			help: `Syntax: $bDROP [nickname]$b
@@ -69,6 +106,31 @@ With no arguments, queries your current enforcement status.`,
 			authRequired: true,
 			enabled:      nsEnforceEnabled,
 		},
+		"erase": {
+			handler: nsEraseHandler,
+			help: `Syntax: $bERASE [code]$b
+Or:     $bERASE CANCEL$b
+
+ERASE requests the erasure of your user account: the account is unregistered,
+and the messages you sent from it are deleted from the stored history. This
+takes effect after a grace period, during which $bERASE CANCEL$b cancels it.
+To prevent accidental erasures, a verification code is required; invoking the
+command without a code will display the necessary code.`,
+			helpShort:    `$bERASE$b lets you erase your user account and its data.`,
+			enabled:      nsEraseEnabled,
+			authRequired: true,
+		},
+		"export": {
+			handler: nsExportHandler,
+			help: `Syntax: $bEXPORT$b
+
+EXPORT sends you (as JSON) all the data the server stores about your user
+account, including the messages you sent from it that are in the stored
+history.`,
+			helpShort:    `$bEXPORT$b sends you all the data stored about your account.`,
+			enabled:      servCmdRequiresAuthEnabled,
+			authRequired: true,
+		},
 		"ghost": {
 			handler: nsGhostHandler,
 			help: `Syntax: $bGHOST <nickname>$b
@@ -105,6 +167,25 @@ certfp (your client certificate) if a password is not given.`,
 INFO gives you information about the given (or your own) user account.`,
 			helpShort: `$bINFO$b gives you information on a user account.`,
 		},
+		"persona": {
+			handler: nsPersonaHandler,
+			help: `Syntax: $bPERSONA [LIST]$b
+Or:     $bPERSONA ADD <name> <nickname>$b
+Or:     $bPERSONA DEL <name>$b
+Or:     $bPERSONA ATTACH <name>$b
+Or:     $bPERSONA DETACH$b
+
+PERSONA manages your personas: separate identities (say, for work and personal
+use) on the same account. Each persona has its own nickname, which must be
+your account name or grouped with your account (see $bHELP GROUP$b), and its own
+channels. $bATTACH$b switches you to a persona: you take its nickname, leave
+the channels that aren't the persona's, and join the ones that are. The
+channels you're in are saved to the persona when you detach or disconnect.
+To attach to a persona while connecting, log in with SASL as account/persona.`,
+			helpShort:    `$bPERSONA$b manages separate identities on your account.`,
+			enabled:      nsPersonaEnabled,
+			authRequired: true,
+		},
 		"register": {
 			handler: nsRegisterHandler,
 			// TODO: "email" is an oversimplification here; it's actually any callback, e.g.,
@@ -142,6 +223,93 @@ an administrator can set use this command to set up user accounts.`,
 			capabs:    []string{"accreg"},
 			minParams: 2,
 		},
+		"satransfer": {
+			handler: nsSatransferHandler,
+			help: `Syntax: $bSATRANSFER <nickname | #channel> <username>$b
+
+SATRANSFER immediately transfers ownership of a reserved nickname or a
+registered channel to the given account, without confirmation from either side.
+Transferring channels also requires channel registration privileges.`,
+			helpShort: `$bSATRANSFER$b forcibly transfers a nickname or channel to another account.`,
+			enabled:   servCmdRequiresAuthEnabled,
+			capabs:    []string{"accreg"},
+			minParams: 2,
+		},
+		"get": {
+			handler: nsGetHandler,
+			help: `Syntax: $bGET [setting]$b
+
+GET shows the value of the given setting, or of all your account settings.
+See $bHELP SET$b for the available settings.`,
+			helpShort:    `$bGET$b shows your account settings.`,
+			authRequired: true,
+		},
+		"sessions": {
+			handler: nsSessionsHandler,
+			help: `Syntax: $bSESSIONS$b
+        $bSESSIONS KILL <number>$b
+
+SESSIONS lists the clients that are currently logged into your account, with
+their IP address, connection time, enabled capabilities, and idle time. This
+lets you notice clients that you don't recognize (which could mean that your
+password has been stolen).
+
+SESSIONS KILL disconnects one of those clients, identified by its number in
+the list.`,
+			helpShort:    `$bSESSIONS$b lists or disconnects the clients logged into your account.`,
+			authRequired: true,
+		},
+		"set": {
+			handler: nsSetHandler,
+			help: `Syntax: $bSET <setting> <value>$b
+
+SET modifies the settings stored with your user account, which are applied
+every time you log in. The available settings are:
+
+$bLANGUAGE$b <code ...>: the languages in which you'd like to receive messages
+from the server, in order of preference (e.g., $bSET LANGUAGE de en$b). Use
+'default' to stop overriding the language you choose when you connect.
+
+$bENFORCE$b <method>: how your nicknames are reserved; see $bHELP ENFORCE$b.
+
+$bAUTOAWAY$b <duration | off>: if the server allows it, mark you as away
+automatically after you've been idle for the given duration (e.g., $b30m$b).
+
+$bREPLAY$b <on | off>: whether missed messages are replayed to you when you
+resume a connection.
+
+$bSTORE-HISTORY$b <on | off>: whether your messages are stored in the history
+the server keeps (for replay, CHATHISTORY, and HistServ). Turning this off also
+deletes the messages of yours that were stored so far. To keep just one message
+out of history, send it with the +oragono.io/no-history tag.
+
+$bALLOW-DMS$b <all | registered | requests>: whether to accept direct messages
+from everyone, or only from users who are logged into accounts. With $brequests$b,
+messages from users who aren't on your accept list are held until you accept
+them (see /HELP ACCEPT).
+
+$bAUTOJOIN$b <on | off>: whether you're automatically joined to the channels
+the server configures for new connections, logged-in users, and opers.`,
+			helpShort:    `$bSET$b modifies your account settings.`,
+			authRequired: true,
+			minParams:    2,
+		},
+		"transfer": {
+			handler: nsTransferHandler,
+			help: `Syntax: $bTRANSFER <nickname | #channel> <username>$b
+        $bTRANSFER ACCEPT <nickname | #channel>$b
+        $bTRANSFER CANCEL <nickname | #channel>$b
+
+TRANSFER proposes giving one of your reserved nicknames, or a channel you
+founded, to another account (for example, a new account you've registered).
+The transfer only takes effect once someone logged into the other account
+accepts it with TRANSFER ACCEPT; proposals expire after a day. Either side can
+call off a pending transfer with TRANSFER CANCEL.`,
+			helpShort:    `$bTRANSFER$b gives a nickname or channel to another account.`,
+			enabled:      servCmdRequiresAuthEnabled,
+			authRequired: true,
+			minParams:    2,
+		},
 		"unregister": {
 			handler: nsUnregisterHandler,
 			help: `Syntax: $bUNREGISTER <username> [code]$b
@@ -154,6 +322,30 @@ a code will display the necessary code.`,
 			enabled:   servCmdRequiresAuthEnabled,
 			minParams: 1,
 		},
+		"token": {
+			handler: nsTokenHandler,
+			help: `Syntax: $bTOKEN [LIST]$b
+Or:     $bTOKEN ADD <name> [capabilities] [#channel{,#channel}]$b
+Or:     $bTOKEN DEL <name>$b
+
+TOKEN manages API tokens, which let bots log into your account with SASL PLAIN
+(using the token as the password) with restricted capabilities. $bADD$b creates
+a token and shows it to you; this is the only time it's shown. The
+capabilities, separated by commas, can be any of:
+
+$bMESSAGE$b  send messages (including relayed messages) and set metadata
+$bJOIN$b     join channels
+$bSERVICES$b use NickServ, ChanServ, and HostServ (e.g., to change settings)
+
+If no capabilities are given, the token can send messages and join channels.
+If channels are given, the token can only join and message those channels.
+$bLIST$b shows your tokens and when they were last used, and $bDEL$b revokes
+a token, disconnecting the clients that are logged in with it. Tokens can't
+be added or revoked by clients that are logged in with a token.`,
+			helpShort:    `$bTOKEN$b manages API tokens for bots.`,
+			enabled:      nsTokenEnabled,
+			authRequired: true,
+		},
 		"verify": {
 			handler: nsVerifyHandler,
 			help: `Syntax: $bVERIFY <username> <code>$b
@@ -182,7 +374,20 @@ password by supplying their username and then the desired password.`,
 
 // nsNotice sends the client a notice from NickServ
 func nsNotice(rb *ResponseBuffer, text string) {
-	rb.Add(nil, "NickServ", "NOTICE", rb.target.Nick(), text)
+	rb.AddSplitMessageFromServer("NickServ", "NOTICE", rb.target.Nick(), utils.MakeSplitMessage(text))
+}
+
+// nsLoginHint tells a client that was refused something (e.g., because of +R)
+// how to log into an account, or register one.
+func nsLoginHint(client *Client, rb *ResponseBuffer) {
+	config := client.server.AccountConfig()
+	if !config.AuthenticationEnabled {
+		return
+	}
+	nsNotice(rb, ircfmt.Unescape(client.t("To log into your account, use $b/msg NickServ IDENTIFY <account> <password>$b")))
+	if config.Registration.Enabled {
+		nsNotice(rb, ircfmt.Unescape(client.t("To register an account, use $b/msg NickServ REGISTER <account> <email> <password>$b")))
+	}
 }
 
 func nsDropHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
@@ -233,6 +438,60 @@ func nsGhostHandler(server *Server, client *Client, command string, params []str
 	ghost.destroy(false)
 }
 
+// accountSessions returns the clients logged into an account, ordered by
+// connection time, so that sessions keep their numbers while they're connected.
+func accountSessions(server *Server, account string) (sessions []*Client) {
+	sessions = append(sessions, server.accounts.AccountToClients(account)...)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ctime.Before(sessions[j].ctime)
+	})
+	return
+}
+
+func nsSessionsHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	sessions := accountSessions(server, client.Account())
+
+	if 0 < len(params) {
+		if strings.ToLower(params[0]) != "kill" || len(params) < 2 {
+			nsNotice(rb, client.t("Invalid parameters"))
+			return
+		}
+		number, err := strconv.Atoi(params[1])
+		if err != nil || number < 1 || len(sessions) < number {
+			nsNotice(rb, client.t("No such session"))
+			return
+		}
+		target := sessions[number-1]
+		if target == client {
+			nsNotice(rb, client.t("You can't kill your own session (try /QUIT instead)"))
+			return
+		}
+		nsNotice(rb, fmt.Sprintf(client.t("Disconnected session %[1]d (%[2]s)"), number, target.Nick()))
+		target.Quit(fmt.Sprintf(target.t("Session killed by %s"), client.Nick()))
+		target.destroy(false)
+		return
+	}
+
+	for i, session := range sessions {
+		current := ""
+		if session == client {
+			current = client.t(" (this session)")
+		}
+		nsNotice(rb, fmt.Sprintf(client.t("Session %[1]d: %[2]s%[3]s"), i+1, session.Nick(), current))
+		nsNotice(rb, fmt.Sprintf(client.t("IP address: %s"), session.IP().String()))
+		nsNotice(rb, fmt.Sprintf(client.t("Connected at: %s"), session.ctime.UTC().Format("Jan 02, 2006 15:04:05Z")))
+		nsNotice(rb, fmt.Sprintf(client.t("Idle for: %s"), session.IdleTime().Truncate(time.Second).String()))
+		capabilities := session.capabilities.String(caps.Cap301, CapValues)
+		if capabilities == "" {
+			capabilities = "*"
+		}
+		nsNotice(rb, fmt.Sprintf(client.t("Capabilities: %s"), capabilities))
+		if token := session.APIToken(); token != nil {
+			nsNotice(rb, fmt.Sprintf(client.t("Logged in with API token: %s"), token.Name))
+		}
+	}
+}
+
 func nsGroupHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	nick := client.Nick()
 	err := server.accounts.SetNickReserved(client, nick, false, true)
@@ -331,6 +590,16 @@ func nsInfoHandler(server *Server, client *Client, command string, params []stri
 	nsNotice(rb, fmt.Sprintf(client.t("Account: %s"), account.Name))
 	registeredAt := account.RegisteredAt.Format("Jan 02, 2006 15:04:05Z")
 	nsNotice(rb, fmt.Sprintf(client.t("Registered at: %s"), registeredAt))
+	if len(server.accounts.AccountToClients(account.Name)) != 0 {
+		nsNotice(rb, client.t("Last seen: now"))
+	} else {
+		if !account.LastLogin.IsZero() {
+			nsNotice(rb, fmt.Sprintf(client.t("Last login: %s"), account.LastLogin.UTC().Format("Jan 02, 2006 15:04:05Z")))
+		}
+		if !account.LastQuit.IsZero() {
+			nsNotice(rb, fmt.Sprintf(client.t("Last quit: %s"), account.LastQuit.UTC().Format("Jan 02, 2006 15:04:05Z")))
+		}
+	}
 	// TODO nicer formatting for this
 	for _, nick := range account.AdditionalNicks {
 		nsNotice(rb, fmt.Sprintf(client.t("Additional grouped nick: %s"), nick))
@@ -481,6 +750,59 @@ func nsUnregisterHandler(server *Server, client *Client, command string, params
 	}
 }
 
+func nsEraseHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	cfname := client.Account()
+	if len(params) != 0 && strings.ToLower(params[0]) == "cancel" {
+		err := server.accounts.CancelErasure(cfname)
+		if err == errErasureNotScheduled {
+			nsNotice(rb, client.t(err.Error()))
+		} else if err != nil {
+			nsNotice(rb, client.t("Internal error"))
+		} else {
+			nsNotice(rb, client.t("Account erasure cancelled"))
+		}
+		return
+	}
+
+	account, err := server.accounts.LoadAccount(cfname)
+	if err != nil {
+		nsNotice(rb, client.t("Internal error"))
+		return
+	}
+	expectedCode := unregisterConfirmationCode(account.Name, account.RegisteredAt)
+	if len(params) == 0 || params[0] != expectedCode {
+		nsNotice(rb, ircfmt.Unescape(client.t("$bWarning: erasing your account will unregister it, and delete the messages you sent from the stored history.$b")))
+		nsNotice(rb, fmt.Sprintf(client.t("To confirm account erasure, type: /NS ERASE %s"), expectedCode))
+		return
+	}
+
+	erasureAt, err := server.accounts.ScheduleErasure(cfname, false)
+	if err != nil {
+		nsNotice(rb, client.t("Error while erasing account"))
+	} else if time.Now().Before(erasureAt) {
+		nsNotice(rb, fmt.Sprintf(client.t("Your account will be erased on %s; to cancel this, type: /NS ERASE CANCEL"), erasureAt.UTC().Format(time.RFC1123)))
+	} else {
+		nsNotice(rb, fmt.Sprintf(client.t("Successfully erased account %s"), cfname))
+	}
+}
+
+func nsExportHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	export, err := server.ExportAccountData(client.Account())
+	var lines []string
+	if err == nil {
+		lines, err = marshalAccountDataExport(export)
+	}
+	if err != nil {
+		nsNotice(rb, client.t("Internal error"))
+		return
+	}
+	nsNotice(rb, client.t("Exported account data (JSON) follows"))
+	for _, line := range lines {
+		nsNotice(rb, line)
+	}
+	nsNotice(rb, client.t("End of exported account data"))
+}
+
 func nsVerifyHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
 	username, code := params[0], params[1]
 	err := server.accounts.Verify(client, username, code)
@@ -569,3 +891,186 @@ func nsEnforceHandler(server *Server, client *Client, command string, params []s
 		}
 	}
 }
+
+func nsGetHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	config := server.Config()
+	settings := server.accounts.LoadSettings(account)
+
+	if len(params) == 0 {
+		// list every setting that's enabled on this server
+		for _, name := range accountSettingNames {
+			if setting := accountSettings[name]; setting.isEnabled(config) {
+				nsNotice(rb, fmt.Sprintf("%s: %s", strings.ToUpper(name), setting.get(server, account, &settings)))
+			}
+		}
+		return
+	}
+
+	name := strings.ToLower(params[0])
+	setting, ok := accountSettings[name]
+	if !ok || !setting.isEnabled(config) {
+		nsNotice(rb, fmt.Sprintf(client.t("No such setting: %s"), name))
+		return
+	}
+	nsNotice(rb, fmt.Sprintf("%s: %s", strings.ToUpper(name), setting.get(server, account, &settings)))
+}
+
+func nsSetHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	name := strings.ToLower(params[0])
+	setting, ok := accountSettings[name]
+	if !ok || !setting.isEnabled(server.Config()) {
+		nsNotice(rb, fmt.Sprintf(client.t("No such setting: %s"), params[0]))
+		return
+	}
+
+	var settings AccountSettings
+	var err error
+	if setting.setExternal != nil {
+		err = setting.setExternal(server, account, params[1:])
+		settings = server.accounts.LoadSettings(account)
+	} else {
+		settings, err = server.accounts.ModifySettings(account, func(settings *AccountSettings) error {
+			return setting.set(server, settings, params[1:])
+		})
+	}
+	switch err {
+	case nil:
+		// client.t now reflects the new settings
+		nsNotice(rb, fmt.Sprintf(client.t("Successfully changed your %[1]s setting to: %[2]s"), strings.ToUpper(name), setting.get(server, account, &settings)))
+	case errInvalidParams, errFeatureDisabled, errUnsupportedLanguage, errInvalidAutoAway:
+		nsNotice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "couldn't store NS SET data", err.Error())
+		nsNotice(rb, client.t("An error occurred"))
+	}
+}
+
+func nsTransferHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	subcommand := strings.ToLower(params[0])
+	if subcommand == "accept" || subcommand == "cancel" {
+		name := params[1]
+		key, _, err := transferKey(name)
+		if err != nil {
+			nsNotice(rb, client.t(errTransferNotPending.Error()))
+			return
+		}
+		transfer, err := server.transfers.Remove(key, account, subcommand == "accept")
+		if err != nil {
+			nsNotice(rb, client.t(err.Error()))
+			return
+		}
+		if subcommand == "cancel" {
+			nsNotice(rb, fmt.Sprintf(client.t("Cancelled the transfer of %s"), transfer.name))
+			other := transfer.to
+			if account == transfer.to {
+				other = transfer.from
+			}
+			server.notifyAccount("NickServ", other, func(c *Client) string {
+				return fmt.Sprintf(c.t("The transfer of %s was cancelled"), transfer.name)
+			})
+			return
+		}
+		err = server.performTransfer(transfer.name, transfer.from, transfer.to)
+		if err != nil {
+			nsTransferError(server, client, err, rb)
+			return
+		}
+		nsNotice(rb, fmt.Sprintf(client.t("%s now belongs to your account"), transfer.name))
+		server.notifyAccount("NickServ", transfer.from, func(c *Client) string {
+			return fmt.Sprintf(c.t("%[1]s now belongs to the account %[2]s"), transfer.name, transfer.to)
+		})
+		server.logger.Info("accounts", "transferred", transfer.name, "from", transfer.from, "to", transfer.to)
+		return
+	}
+
+	name := params[0]
+	key, _, err := transferKey(name)
+	if err != nil {
+		nsNotice(rb, client.t(errTransferNotOwned.Error()))
+		return
+	}
+	owner, err := server.transferOwner(name)
+	if err != nil {
+		nsTransferError(server, client, err, rb)
+		return
+	} else if owner != account {
+		nsNotice(rb, client.t(errTransferNotOwned.Error()))
+		return
+	} else if key == account {
+		nsNotice(rb, client.t(errTransferPrimaryNick.Error()))
+		return
+	}
+	recipient, err := nsTransferRecipient(server, params[1])
+	if err != nil {
+		nsTransferError(server, client, err, rb)
+		return
+	} else if recipient == account {
+		nsNotice(rb, client.t(errTransferSameAccount.Error()))
+		return
+	}
+
+	server.transfers.Propose(key, name, account, recipient)
+	nsNotice(rb, fmt.Sprintf(client.t("The transfer of %[1]s to %[2]s will take effect when they accept it"), name, recipient))
+	server.notifyAccount("NickServ", recipient, func(c *Client) string {
+		return fmt.Sprintf(c.t("The account %[1]s wants to transfer %[2]s to you. To accept, type: /NS TRANSFER ACCEPT %[2]s"), account, name)
+	})
+}
+
+func nsSatransferHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	name := params[0]
+	_, isChannel, err := transferKey(name)
+	if err != nil {
+		nsNotice(rb, client.t("Invalid nickname or channel"))
+		return
+	} else if isChannel && !client.HasRoleCapabs("chanreg") {
+		nsNotice(rb, client.t("Insufficient oper privs"))
+		return
+	}
+	owner, err := server.transferOwner(name)
+	if err != nil {
+		nsTransferError(server, client, err, rb)
+		return
+	} else if owner == "" {
+		nsNotice(rb, client.t("That nickname or channel isn't registered"))
+		return
+	}
+	recipient, err := nsTransferRecipient(server, params[1])
+	if err != nil {
+		nsTransferError(server, client, err, rb)
+		return
+	} else if recipient == owner {
+		nsNotice(rb, client.t(errTransferSameAccount.Error()))
+		return
+	}
+
+	err = server.performTransfer(name, owner, recipient)
+	if err != nil {
+		nsTransferError(server, client, err, rb)
+		return
+	}
+	nsNotice(rb, fmt.Sprintf(client.t("Transferred %[1]s from %[2]s to %[3]s"), name, owner, recipient))
+	server.logger.Info("accounts", "operator", client.Nick(), "transferred", name, "from", owner, "to", recipient)
+}
+
+// nsTransferRecipient returns the casefolded name of the account receiving a transfer.
+func nsTransferRecipient(server *Server, name string) (account string, err error) {
+	if _, err = server.accounts.LoadAccount(name); err != nil {
+		return
+	}
+	return CasefoldName(name)
+}
+
+func nsTransferError(server *Server, client *Client, err error, rb *ResponseBuffer) {
+	switch err {
+	case errTransferNotOwned, errTransferPrimaryNick, errTransferNoSuchChannel, errAccountDoesNotExist:
+		nsNotice(rb, client.t(err.Error()))
+	case errAccountNickReservationFailed:
+		nsNotice(rb, client.t("Invalid nickname or channel"))
+	default:
+		server.logger.Error("internal", "couldn't transfer ownership", err.Error())
+		nsNotice(rb, client.t("An error occurred"))
+	}
+}
@@ -32,8 +32,11 @@ const (
 	RPL_TRACERECONNECT              = "210"
 	RPL_STATSLINKINFO               = "211"
 	RPL_STATSCOMMANDS               = "212"
+	RPL_STATSKLINE                  = "216"
 	RPL_ENDOFSTATS                  = "219"
 	RPL_UMODEIS                     = "221"
+	RPL_STATSDLINE                  = "225"
+	RPL_RULES                       = "232"
 	RPL_SERVLIST                    = "234"
 	RPL_SERVLISTEND                 = "235"
 	RPL_STATSUPTIME                 = "242"
@@ -50,12 +53,18 @@ const (
 	RPL_TRACELOG                    = "261"
 	RPL_TRACEEND                    = "262"
 	RPL_TRYAGAIN                    = "263"
+	RPL_LOCALUSERS                  = "265"
+	RPL_GLOBALUSERS                 = "266"
 	RPL_WHOISCERTFP                 = "276"
+	RPL_ACCEPTLIST                  = "281"
+	RPL_ENDOFACCEPT                 = "282"
 	RPL_AWAY                        = "301"
 	RPL_USERHOST                    = "302"
 	RPL_ISON                        = "303"
 	RPL_UNAWAY                      = "305"
 	RPL_NOWAWAY                     = "306"
+	RPL_RULESTART                   = "308"
+	RPL_ENDOFRULES                  = "309"
 	RPL_WHOISUSER                   = "311"
 	RPL_WHOISSERVER                 = "312"
 	RPL_WHOISOPERATOR               = "313"
@@ -77,6 +86,7 @@ const (
 	RPL_WHOISACTUALLY               = "338"
 	RPL_INVITING                    = "341"
 	RPL_SUMMONING                   = "342"
+	RPL_WHOISCOUNTRY                = "344"
 	RPL_INVITELIST                  = "346"
 	RPL_ENDOFINVITELIST             = "347"
 	RPL_EXCEPTLIST                  = "348"
@@ -84,6 +94,7 @@ const (
 	RPL_VERSION                     = "351"
 	RPL_WHOREPLY                    = "352"
 	RPL_NAMREPLY                    = "353"
+	RPL_WHOSPCRPL                   = "354"
 	RPL_LINKS                       = "364"
 	RPL_ENDOFLINKS                  = "365"
 	RPL_ENDOFNAMES                  = "366"
@@ -127,6 +138,7 @@ const (
 	ERR_NONICKNAMEGIVEN             = "431"
 	ERR_ERRONEUSNICKNAME            = "432"
 	ERR_NICKNAMEINUSE               = "433"
+	ERR_NORULES                     = "434"
 	ERR_NICKCOLLISION               = "436"
 	ERR_UNAVAILRESOURCE             = "437"
 	ERR_REG_UNAVAILABLE             = "440"
@@ -137,6 +149,9 @@ const (
 	ERR_SUMMONDISABLED              = "445"
 	ERR_USERSDISABLED               = "446"
 	ERR_NOTREGISTERED               = "451"
+	ERR_ACCEPTFULL                  = "456"
+	ERR_ACCEPTEXIST                 = "457"
+	ERR_ACCEPTNOT                   = "458"
 	ERR_NEEDMOREPARAMS              = "461"
 	ERR_ALREADYREGISTRED            = "462"
 	ERR_NOPERMFORHOST               = "463"
@@ -152,12 +167,16 @@ const (
 	ERR_BADCHANNELKEY               = "475"
 	ERR_BADCHANMASK                 = "476"
 	ERR_NOCHANMODES                 = "477"
+	ERR_NEEDREGGEDNICK              = "477"
 	ERR_BANLISTFULL                 = "478"
+	ERR_BADCHANNAME                 = "479"
+	ERR_CANNOTKNOCK                 = "480"
 	ERR_NOPRIVILEGES                = "481"
 	ERR_CHANOPRIVSNEEDED            = "482"
 	ERR_CANTKILLSERVER              = "483"
 	ERR_RESTRICTED                  = "484"
 	ERR_UNIQOPPRIVSNEEDED           = "485"
+	ERR_SECUREONLYCHAN              = "489"
 	ERR_NOOPERHOST                  = "491"
 	ERR_UMODEUNKNOWNFLAG            = "501"
 	ERR_USERSDONTMATCH              = "502"
@@ -171,12 +190,28 @@ const (
 	RPL_HELPSTART                   = "704"
 	RPL_HELPTXT                     = "705"
 	RPL_ENDOFHELP                   = "706"
+	RPL_KNOCK                       = "710"
+	RPL_KNOCKDLVR                   = "711"
+	ERR_TOOMANYKNOCK                = "712"
+	ERR_CHANOPEN                    = "713"
+	ERR_KNOCKONCHAN                 = "714"
+	ERR_TARGUMODEG                  = "716"
+	RPL_TARGNOTIFY                  = "717"
+	RPL_UMODEGMSG                   = "718"
 	ERR_NOPRIVS                     = "723"
 	RPL_MONONLINE                   = "730"
 	RPL_MONOFFLINE                  = "731"
 	RPL_MONLIST                     = "732"
 	RPL_ENDOFMONLIST                = "733"
 	ERR_MONLISTFULL                 = "734"
+	RPL_RSACHALLENGE2               = "740"
+	RPL_ENDOFRSACHALLENGE2          = "741"
+	RPL_KEYVALUE                    = "761"
+	RPL_METADATAEND                 = "762"
+	RPL_KEYNOTSET                   = "766"
+	RPL_METADATASUBOK               = "770"
+	RPL_METADATAUNSUBOK             = "771"
+	RPL_METADATASUBS                = "772"
 	RPL_LOGGEDIN                    = "900"
 	RPL_LOGGEDOUT                   = "901"
 	ERR_NICKLOCKED                  = "902"
@@ -0,0 +1,360 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
+
+// Instead of requiring a hidden service to be configured manually in torrc,
+// oragono can connect to Tor's control port and publish an onion service for
+// one of its Tor listeners. The service's private key is kept in the datastore,
+// so that its .onion address stays the same across restarts. The service only
+// exists while the control connection is open, so it disappears if oragono
+// exits, and is published again if the connection to Tor is lost.
+
+const (
+	keyOnionServiceKey = "tor.onion.key"
+
+	defaultTorControlAddress = "127.0.0.1:9051"
+	defaultOnionServicePort  = 6667
+	torControlTimeout        = 10 * time.Second
+	// wait this long before reconnecting to the control port
+	onionServiceRetryInterval = 30 * time.Second
+)
+
+var (
+	errTorControlAuthUnsupported = errors.New("Tor control port doesn't support any usable authentication method")
+	errTorControlBadReply        = errors.New("Invalid reply from Tor control port")
+)
+
+// OnionServiceConfig controls the automatic publication of an onion service.
+type OnionServiceConfig struct {
+	Enabled bool
+	// host:port or path of a unix domain socket
+	ControlAddress string `yaml:"control-address"`
+	// if empty, cookie authentication (or no authentication) is used
+	ControlPassword string `yaml:"control-password"`
+	// the Tor listener that connections to the service are forwarded to
+	Listener string
+	// the port that clients connect to at the .onion address
+	Port int
+
+	target string
+}
+
+func (conf *OnionServiceConfig) prepare(torListeners []string) error {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.ControlAddress == "" {
+		conf.ControlAddress = defaultTorControlAddress
+	}
+	if conf.Port == 0 {
+		conf.Port = defaultOnionServicePort
+	}
+	found := false
+	for _, listener := range torListeners {
+		if listener == conf.Listener {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("onion service listener %s is not configured as a Tor listener", conf.Listener)
+	}
+	listener := strings.TrimPrefix(conf.Listener, "unix:")
+	if strings.HasPrefix(listener, "/") {
+		conf.target = "unix:" + listener
+	} else if strings.HasPrefix(listener, ":") {
+		conf.target = "127.0.0.1" + listener
+	} else {
+		conf.target = listener
+	}
+	return nil
+}
+
+// torController is a connection to Tor's control port (see control-spec.txt).
+type torController struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTorController(conn net.Conn) *torController {
+	return &torController{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func dialTorController(address string) (*torController, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, address, torControlTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newTorController(conn), nil
+}
+
+// command sends a command and returns the lines of its reply, without their
+// status codes; replies other than 250 are returned as errors.
+func (tc *torController) command(line string) (result []string, err error) {
+	if _, err = tc.conn.Write([]byte(line + "\r\n")); err != nil {
+		return
+	}
+	for {
+		reply, err := tc.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		reply = strings.TrimRight(reply, "\r\n")
+		if len(reply) < 4 {
+			return nil, errTorControlBadReply
+		}
+		status, separator, text := reply[:3], reply[3], reply[4:]
+		if status != "250" {
+			return nil, fmt.Errorf("Tor control port error: %s", reply)
+		}
+		result = append(result, text)
+		switch separator {
+		case ' ':
+			return result, nil
+		case '+':
+			// skip the data that follows, up to a line consisting of a single period
+			for {
+				data, err := tc.reader.ReadString('\n')
+				if err != nil {
+					return nil, err
+				}
+				if strings.TrimRight(data, "\r\n") == "." {
+					break
+				}
+			}
+		case '-':
+		default:
+			return nil, errTorControlBadReply
+		}
+	}
+}
+
+func torQuote(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	str = strings.Replace(str, `"`, `\"`, -1)
+	return `"` + str + `"`
+}
+
+// authenticate authenticates with a password, if one is given, otherwise with
+// the cookie file (or not at all, if the control port doesn't require it).
+func (tc *torController) authenticate(password string) error {
+	if password != "" {
+		_, err := tc.command("AUTHENTICATE " + torQuote(password))
+		return err
+	}
+
+	lines, err := tc.command("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	var methods []string
+	var cookieFile string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		for _, field := range strings.Fields(line[len("AUTH "):]) {
+			if strings.HasPrefix(field, "METHODS=") {
+				methods = strings.Split(field[len("METHODS="):], ",")
+			}
+		}
+		if start := strings.Index(line, `COOKIEFILE="`); start != -1 {
+			quoted := line[start+len("COOKIEFILE="):]
+			// find the closing quote, skipping escaped characters
+			for i := 1; i < len(quoted); i++ {
+				if quoted[i] == '\\' {
+					i++
+				} else if quoted[i] == '"' {
+					cookieFile, err = strconv.Unquote(quoted[:i+1])
+					if err != nil {
+						return errTorControlBadReply
+					}
+					break
+				}
+			}
+		}
+	}
+
+	for _, method := range methods {
+		if method == "NULL" {
+			_, err = tc.command("AUTHENTICATE")
+			return err
+		}
+	}
+	for _, method := range methods {
+		if method == "COOKIE" && cookieFile != "" {
+			cookie, err := ioutil.ReadFile(cookieFile)
+			if err != nil {
+				return err
+			}
+			_, err = tc.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+			return err
+		}
+	}
+	return errTorControlAuthUnsupported
+}
+
+// addOnion publishes an onion service forwarding `port` to `target`, using the
+// given private key, or a new one if `key` is empty. It returns the service ID
+// (the .onion address without the suffix) and the key, if a new one was generated.
+func (tc *torController) addOnion(key string, port int, target string) (serviceID, newKey string, err error) {
+	keySpec := key
+	if keySpec == "" {
+		keySpec = "NEW:ED25519-V3"
+	}
+	lines, err := tc.command(fmt.Sprintf("ADD_ONION %s Port=%d,%s", keySpec, port, target))
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ServiceID=") {
+			serviceID = line[len("ServiceID="):]
+		} else if strings.HasPrefix(line, "PrivateKey=") {
+			newKey = line[len("PrivateKey="):]
+		}
+	}
+	if serviceID == "" || (key == "" && newKey == "") {
+		err = errTorControlBadReply
+	}
+	return
+}
+
+// onionService keeps an onion service published for as long as it's running.
+type onionService struct {
+	server *Server
+	config OnionServiceConfig
+
+	lockorder.Tier1Mutex
+	controller *torController
+	stopped    bool
+}
+
+func (server *Server) setupOnionService(config *Config) {
+	onionConfig := config.Server.TorListeners.OnionService
+	if server.onionService != nil {
+		if server.onionService.config == onionConfig {
+			return
+		}
+		server.onionService.stop()
+		server.onionService = nil
+	}
+	if onionConfig.Enabled {
+		server.onionService = &onionService{server: server, config: onionConfig}
+		go server.onionService.run()
+	}
+}
+
+func (service *onionService) loadKey() (key string) {
+	service.server.store.View(func(tx *buntdb.Tx) error {
+		key, _ = tx.Get(keyOnionServiceKey)
+		return nil
+	})
+	return
+}
+
+func (service *onionService) saveKey(key string) error {
+	return service.server.store.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(keyOnionServiceKey, key, nil)
+		return err
+	})
+}
+
+// publish connects to the control port and publishes the service.
+func (service *onionService) publish() (controller *torController, err error) {
+	controller, err = dialTorController(service.config.ControlAddress)
+	if err != nil {
+		return
+	}
+	controller.conn.SetDeadline(time.Now().Add(torControlTimeout))
+	defer func() {
+		if err == nil {
+			controller.conn.SetDeadline(time.Time{})
+		} else {
+			controller.conn.Close()
+		}
+	}()
+
+	if err = controller.authenticate(service.config.ControlPassword); err != nil {
+		return
+	}
+	serviceID, newKey, err := controller.addOnion(service.loadKey(), service.config.Port, service.config.target)
+	if err != nil {
+		return
+	}
+	if newKey != "" {
+		if err = service.saveKey(newKey); err != nil {
+			return
+		}
+	}
+	service.server.logger.Info("tor", "Published onion service", fmt.Sprintf("%s.onion:%d", serviceID, service.config.Port))
+	return
+}
+
+func (service *onionService) run() {
+	for {
+		service.Lock()
+		stopped := service.stopped
+		service.Unlock()
+		if stopped {
+			return
+		}
+
+		controller, err := service.publish()
+		if err == nil {
+			service.Lock()
+			if service.stopped {
+				controller.conn.Close()
+			} else {
+				service.controller = controller
+			}
+			service.Unlock()
+			// Tor sends nothing more, unless we ask for events; wait for the
+			// connection to close
+			_, err = controller.reader.ReadString('\n')
+		}
+
+		service.Lock()
+		stopped = service.stopped
+		service.controller = nil
+		service.Unlock()
+		if stopped {
+			return
+		}
+		service.server.logger.Error("tor", "Onion service is unavailable, retrying", err.Error())
+		time.Sleep(onionServiceRetryInterval)
+	}
+}
+
+// stop closes the control connection, which removes the service.
+func (service *onionService) stop() {
+	service.Lock()
+	defer service.Unlock()
+	service.stopped = true
+	if service.controller != nil {
+		service.controller.conn.Close()
+	}
+}
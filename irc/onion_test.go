@@ -0,0 +1,116 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeTorControl answers control port commands with canned replies, recording
+// the commands it received.
+func fakeTorControl(replies map[string]string) (*torController, chan string) {
+	client, server := net.Pipe()
+	commands := make(chan string, 16)
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			commands <- line
+			verb := strings.SplitN(line, " ", 2)[0]
+			reply, ok := replies[verb]
+			if !ok {
+				reply = "510 Unrecognized command\r\n"
+			}
+			server.Write([]byte(reply))
+		}
+	}()
+	return newTorController(client), commands
+}
+
+func TestOnionServicePrepare(t *testing.T) {
+	conf := OnionServiceConfig{Enabled: true, Listener: ":6668"}
+	if err := conf.prepare([]string{"/tmp/sock"}); err == nil {
+		t.Errorf("listener that isn't a Tor listener was accepted")
+	}
+	if err := conf.prepare([]string{":6668"}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.target != "127.0.0.1:6668" || conf.Port != 6667 || conf.ControlAddress != defaultTorControlAddress {
+		t.Errorf("incorrect defaults: %#v", conf)
+	}
+
+	conf = OnionServiceConfig{Enabled: true, Listener: "unix:/tmp/sock"}
+	if err := conf.prepare([]string{"unix:/tmp/sock"}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.target != "unix:/tmp/sock" {
+		t.Errorf("incorrect target: %s", conf.target)
+	}
+}
+
+func TestTorControlCookieAuth(t *testing.T) {
+	cookieFile, err := ioutil.TempFile("", "oragono-tor-cookie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(cookieFile.Name())
+	cookieFile.Write([]byte{0xde, 0xad, 0xbe, 0xef})
+	cookieFile.Close()
+
+	tc, commands := fakeTorControl(map[string]string{
+		"PROTOCOLINFO": "250-PROTOCOLINFO 1\r\n" +
+			"250-AUTH METHODS=COOKIE,SAFECOOKIE COOKIEFILE=\"" + cookieFile.Name() + "\"\r\n" +
+			"250-VERSION Tor=\"0.4.1.6\"\r\n" +
+			"250 OK\r\n",
+		"AUTHENTICATE": "250 OK\r\n",
+	})
+	if err := tc.authenticate(""); err != nil {
+		t.Fatal(err)
+	}
+	<-commands
+	if command := <-commands; command != "AUTHENTICATE deadbeef" {
+		t.Errorf("incorrect authentication: %s", command)
+	}
+}
+
+func TestTorControlAddOnion(t *testing.T) {
+	tc, commands := fakeTorControl(map[string]string{
+		"AUTHENTICATE": "250 OK\r\n",
+		"ADD_ONION":    "250-ServiceID=abcdef\r\n250-PrivateKey=ED25519-V3:c2VjcmV0\r\n250 OK\r\n",
+	})
+	if err := tc.authenticate(`pass"word`); err != nil {
+		t.Fatal(err)
+	}
+	if command := <-commands; command != `AUTHENTICATE "pass\"word"` {
+		t.Errorf("incorrect authentication: %s", command)
+	}
+
+	serviceID, key, err := tc.addOnion("", 6667, "127.0.0.1:6668")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command := <-commands; command != "ADD_ONION NEW:ED25519-V3 Port=6667,127.0.0.1:6668" {
+		t.Errorf("incorrect command: %s", command)
+	}
+	if serviceID != "abcdef" || key != "ED25519-V3:c2VjcmV0" {
+		t.Errorf("incorrect reply: %s %s", serviceID, key)
+	}
+
+	tc, _ = fakeTorControl(map[string]string{
+		"ADD_ONION": "512 Bad argument\r\n",
+	})
+	if _, _, err = tc.addOnion(key, 6667, "127.0.0.1:6668"); err == nil {
+		t.Errorf("error reply was accepted")
+	}
+}
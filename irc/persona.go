@@ -0,0 +1,320 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Personas let one account keep several identities, say for work and personal
+// use: each persona has its own nickname (one of the account's reserved nicks)
+// and its own set of channels, and keeps its own read markers (so history
+// replay on joining is tracked separately for each persona). A client attaches
+// to a persona either while connecting, with a SASL PLAIN username of the form
+// account/persona, or afterwards with NickServ PERSONA ATTACH; it then takes
+// the persona's nickname and joins the persona's channels. When the client
+// detaches or disconnects, the channels it's in are saved to the persona.
+
+const (
+	// persona names follow the same rules as device IDs, see readmarker.go
+	maxPersonaNameLength = maxDeviceIDLength
+
+	defaultMaxPersonasPerAccount = 5
+)
+
+var (
+	errInvalidPersonaName  = errors.New("Invalid persona name")
+	errNoSuchPersona       = errors.New("No such persona")
+	errPersonaExists       = errors.New("You already have a persona with that name")
+	errTooManyPersonas     = errors.New("You have too many personas already")
+	errPersonaNickNotOwned = errors.New("Persona nicknames must be your account name or grouped with your account")
+)
+
+// PersonasConfig controls personas.
+type PersonasConfig struct {
+	Enabled       bool
+	MaxPerAccount int `yaml:"max-per-account"`
+}
+
+func (conf *PersonasConfig) prepare() error {
+	if conf.MaxPerAccount == 0 {
+		conf.MaxPerAccount = defaultMaxPersonasPerAccount
+	}
+	return nil
+}
+
+// Persona is one of an account's identities.
+type Persona struct {
+	Nick string
+	// the channels the persona was in when it was last detached
+	Channels []string `json:",omitempty"`
+}
+
+// splitPersona splits a login name of the form account/persona; if there's no
+// persona, the name is returned unchanged.
+func splitPersona(name string) (account, persona string) {
+	if i := strings.LastIndexByte(name, '/'); i != -1 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// normalizePersonaName validates and lowercases a persona name.
+func normalizePersonaName(name string) (string, error) {
+	if !isValidDeviceID(name) || maxPersonaNameLength < len(name) {
+		return "", errInvalidPersonaName
+	}
+	return strings.ToLower(name), nil
+}
+
+// personaDevice returns the device ID under which a persona's read markers
+// are kept.
+func personaDevice(persona string) string {
+	return "persona:" + persona
+}
+
+func (am *AccountManager) loadPersonas(tx *buntdb.Tx, account string) (personas map[string]Persona) {
+	personas = make(map[string]Persona)
+	if rawPersonas, err := tx.Get(fmt.Sprintf(keyAccountPersonas, account)); err == nil {
+		json.Unmarshal([]byte(rawPersonas), &personas)
+	}
+	return
+}
+
+// Personas returns the (casefolded) account's personas.
+func (am *AccountManager) Personas(account string) (personas map[string]Persona) {
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		personas = am.loadPersonas(tx, account)
+		return nil
+	})
+	return
+}
+
+// Persona returns one of the (casefolded) account's personas.
+func (am *AccountManager) Persona(account, name string) (persona Persona, err error) {
+	persona, ok := am.Personas(account)[name]
+	if !ok {
+		return persona, errNoSuchPersona
+	}
+	return persona, nil
+}
+
+// modifyPersonas runs `update` on the (casefolded) account's personas, then
+// stores them if it succeeds.
+func (am *AccountManager) modifyPersonas(account string, update func(personas map[string]Persona) error) error {
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return errAccountDoesNotExist
+		}
+		personas := am.loadPersonas(tx, account)
+		if err := update(personas); err != nil {
+			return err
+		}
+		rawPersonas, err := json.Marshal(personas)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(fmt.Sprintf(keyAccountPersonas, account), string(rawPersonas), nil)
+		return err
+	})
+}
+
+// AddPersona creates a persona for the (casefolded) account, with one of the
+// account's reserved nicknames.
+func (am *AccountManager) AddPersona(account, name, nick string) error {
+	cfnick, err := CasefoldName(nick)
+	if err != nil || (cfnick != account && am.NickToAccount(cfnick) != account) {
+		return errPersonaNickNotOwned
+	}
+	maxPersonas := am.server.AccountConfig().Personas.MaxPerAccount
+	return am.modifyPersonas(account, func(personas map[string]Persona) error {
+		if _, exists := personas[name]; exists {
+			return errPersonaExists
+		} else if maxPersonas <= len(personas) {
+			return errTooManyPersonas
+		}
+		personas[name] = Persona{Nick: nick}
+		return nil
+	})
+}
+
+// DeletePersona deletes one of the (casefolded) account's personas.
+func (am *AccountManager) DeletePersona(account, name string) error {
+	return am.modifyPersonas(account, func(personas map[string]Persona) error {
+		if _, exists := personas[name]; !exists {
+			return errNoSuchPersona
+		}
+		delete(personas, name)
+		return nil
+	})
+}
+
+// savePersonaChannels records the channels the client is in as those of the
+// persona it's attached to.
+func (client *Client) savePersonaChannels(channels []*Channel) {
+	account, persona := client.Account(), client.Persona()
+	if account == "" || persona == "" {
+		return
+	}
+	names := make([]string, len(channels))
+	for i, channel := range channels {
+		names[i] = channel.Name()
+	}
+	sort.Strings(names)
+	client.server.accounts.modifyPersonas(account, func(personas map[string]Persona) error {
+		if current, exists := personas[persona]; exists {
+			current.Channels = names
+			personas[persona] = current
+		}
+		return nil
+	})
+}
+
+// attachPersona switches the client to the persona: it takes the persona's
+// nickname, joins its channels, and uses its read markers.
+func (server *Server) attachPersona(client *Client, name string, rb *ResponseBuffer) error {
+	persona, err := server.accounts.Persona(client.Account(), name)
+	if err != nil {
+		return err
+	}
+	// save the channels of the persona we're leaving, if any, then leave the
+	// channels that aren't the new persona's, so that the identities aren't
+	// linked by the nick change
+	channels := client.Channels()
+	client.savePersonaChannels(channels)
+	keep := make(map[string]bool, len(persona.Channels))
+	for _, channel := range persona.Channels {
+		if cfchannel, err := CasefoldChannel(channel); err == nil {
+			keep[cfchannel] = true
+		}
+	}
+	for _, channel := range channels {
+		if !keep[channel.NameCasefolded()] {
+			channel.Part(client, client.t("Switching personas"), rb)
+		}
+	}
+
+	client.SetPersona(name)
+	client.SetDeviceID(personaDevice(name))
+	performNickChange(server, client, client, persona.Nick, rb)
+	for _, channel := range persona.Channels {
+		server.channels.Join(client, channel, "", false, rb)
+	}
+	return nil
+}
+
+// detachPersona saves the client's channels to its persona, and detaches it.
+func (client *Client) detachPersona() {
+	client.savePersonaChannels(client.Channels())
+	client.SetPersona("")
+	client.SetDeviceID("")
+}
+
+// attachPersonaOnConnect attaches a client that just completed registration
+// to the persona it asked for while logging in, if any.
+func (server *Server) attachPersonaOnConnect(client *Client) {
+	name := client.Persona()
+	if name == "" {
+		return
+	}
+	rb := NewResponseBuffer(client)
+	// the persona is only set for real once it's attached
+	client.SetPersona("")
+	if err := server.attachPersona(client, name, rb); err != nil {
+		nsNotice(rb, fmt.Sprintf(client.t("Couldn't attach to persona %[1]s: %[2]s"), name, client.t(err.Error())))
+	}
+	rb.Send(true)
+}
+
+func nsPersonaHandler(server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account := client.Account()
+	subcommand := "list"
+	if len(params) != 0 {
+		subcommand = strings.ToLower(params[0])
+	}
+
+	var name string
+	switch subcommand {
+	case "add", "del", "attach":
+		if len(params) < 2 || (subcommand == "add" && len(params) < 3) {
+			nsNotice(rb, client.t("Invalid parameters. For usage, do /msg NickServ HELP PERSONA"))
+			return
+		}
+		var err error
+		name, err = normalizePersonaName(params[1])
+		if err != nil {
+			nsNotice(rb, client.t(err.Error()))
+			return
+		}
+	}
+
+	var err error
+	switch subcommand {
+	case "list":
+		personas := server.accounts.Personas(account)
+		if len(personas) == 0 {
+			nsNotice(rb, client.t("You have no personas"))
+			return
+		}
+		names := make([]string, 0, len(personas))
+		for name := range personas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		current := client.Persona()
+		for _, name := range names {
+			persona := personas[name]
+			line := fmt.Sprintf(client.t("%[1]s: nick %[2]s, channels: %[3]s"), name, persona.Nick, strings.Join(persona.Channels, " "))
+			if name == current {
+				line += " " + client.t("(attached)")
+			}
+			nsNotice(rb, line)
+		}
+		return
+	case "add":
+		err = server.accounts.AddPersona(account, name, params[2])
+		if err == nil {
+			nsNotice(rb, fmt.Sprintf(client.t("Created persona %[1]s with nick %[2]s"), name, params[2]))
+		}
+	case "del":
+		err = server.accounts.DeletePersona(account, name)
+		if err == nil {
+			if client.Persona() == name {
+				client.SetPersona("")
+				client.SetDeviceID("")
+			}
+			nsNotice(rb, fmt.Sprintf(client.t("Deleted persona %s"), name))
+		}
+	case "attach":
+		err = server.attachPersona(client, name, rb)
+		if err == nil {
+			nsNotice(rb, fmt.Sprintf(client.t("Attached to persona %s"), name))
+		}
+	case "detach":
+		if client.Persona() == "" {
+			nsNotice(rb, client.t("You're not attached to a persona"))
+			return
+		}
+		client.detachPersona()
+		nsNotice(rb, client.t("Detached from your persona"))
+	default:
+		nsNotice(rb, client.t("Invalid parameters. For usage, do /msg NickServ HELP PERSONA"))
+		return
+	}
+
+	switch err {
+	case nil:
+	case errInvalidPersonaName, errNoSuchPersona, errPersonaExists, errTooManyPersonas, errPersonaNickNotOwned:
+		nsNotice(rb, client.t(err.Error()))
+	default:
+		server.logger.Error("internal", "couldn't modify personas", err.Error())
+		nsNotice(rb, client.t("An error occurred"))
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestSplitPersona(t *testing.T) {
+	cases := []struct {
+		name, account, persona string
+	}{
+		{"shivaram", "shivaram", ""},
+		{"shivaram/work", "shivaram", "work"},
+		{"shivaram/", "shivaram", ""},
+	}
+	for _, c := range cases {
+		if account, persona := splitPersona(c.name); account != c.account || persona != c.persona {
+			t.Errorf("%s: expected %s and %s, got %s and %s", c.name, c.account, c.persona, account, persona)
+		}
+	}
+
+	if name, err := normalizePersonaName("Work"); err != nil || name != "work" {
+		t.Errorf("unexpected result %s: %v", name, err)
+	}
+	for _, name := range []string{"", "a b", "persona:work"} {
+		if _, err := normalizePersonaName(name); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestModifyPersonas(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := &Server{store: store}
+	am := &AccountManager{server: server}
+	store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(fmt.Sprintf(keyAccountExists, "shivaram"), "1", nil)
+		return nil
+	})
+
+	err = am.modifyPersonas("shivaram", func(personas map[string]Persona) error {
+		personas["work"] = Persona{Nick: "shivaram_work", Channels: []string{"#standup"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persona, err := am.Persona("shivaram", "work"); err != nil || persona.Nick != "shivaram_work" || len(persona.Channels) != 1 {
+		t.Errorf("unexpected persona %v: %v", persona, err)
+	}
+	if _, err := am.Persona("shivaram", "home"); err != errNoSuchPersona {
+		t.Errorf("expected errNoSuchPersona, got %v", err)
+	}
+
+	if err := am.DeletePersona("shivaram", "work"); err != nil {
+		t.Error(err)
+	}
+	if personas := am.Personas("shivaram"); len(personas) != 0 {
+		t.Errorf("persona was not deleted: %v", personas)
+	}
+	if err := am.DeletePersona("dan", "work"); err != errAccountDoesNotExist {
+		t.Errorf("personas shouldn't be stored for nonexistent accounts: %v", err)
+	}
+}
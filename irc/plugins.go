@@ -0,0 +1,370 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/logger"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// Plugins are long-running external programs that receive events (connections,
+// channel messages, and nick changes) and return verdicts on them, so that custom
+// anti-spam logic can be implemented without modifying the server.
+//
+// The protocol is line-delimited JSON over the plugin's stdin and stdout: the
+// server writes a pluginEvent, and the plugin answers with a pluginVerdict
+// carrying the same id. If the plugin doesn't answer in time (or isn't running),
+// the event is allowed or blocked according to its fail-closed setting.
+
+const (
+	defaultPluginTimeout = 2 * time.Second
+	// don't restart a crashed plugin more often than this
+	pluginRestartInterval = 10 * time.Second
+
+	pluginEventConnect = "connect"
+	pluginEventMessage = "message"
+	pluginEventNick    = "nick"
+
+	pluginVerdictAllow  = "allow"
+	pluginVerdictBlock  = "block"
+	pluginVerdictModify = "modify"
+)
+
+var (
+	errPluginUnavailable = errors.New("plugin is not running")
+)
+
+// PluginConfig configures an external plugin.
+type PluginConfig struct {
+	Name    string
+	Command string
+	Args    []string
+	// events to send to the plugin: connect, message, and/or nick
+	Events []string
+	// channels whose messages are sent to the plugin; "*" means all channels
+	Channels []string
+	Timeout  time.Duration
+	// if true, events are blocked when the plugin fails to answer in time
+	FailClosed bool `yaml:"fail-closed"`
+
+	events      map[string]bool
+	channels    map[string]bool
+	allChannels bool
+}
+
+func (conf *PluginConfig) prepare() error {
+	if conf.Command == "" {
+		return fmt.Errorf("Plugin %s has no command", conf.Name)
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultPluginTimeout
+	}
+	conf.events = make(map[string]bool)
+	for _, event := range conf.Events {
+		switch event {
+		case pluginEventConnect, pluginEventMessage, pluginEventNick:
+			conf.events[event] = true
+		default:
+			return fmt.Errorf("Plugin %s has unknown event type %s", conf.Name, event)
+		}
+	}
+	conf.channels = make(map[string]bool)
+	for _, channel := range conf.Channels {
+		if channel == "*" {
+			conf.allChannels = true
+			continue
+		}
+		cfchannel, err := CasefoldChannel(channel)
+		if err != nil {
+			return fmt.Errorf("Plugin %s has invalid channel %s", conf.Name, channel)
+		}
+		conf.channels[cfchannel] = true
+	}
+	return nil
+}
+
+// wants returns whether the plugin is interested in the event.
+func (conf *PluginConfig) wants(event *pluginEvent) bool {
+	if !conf.events[event.Event] {
+		return false
+	}
+	if event.Event == pluginEventMessage {
+		return conf.allChannels || conf.channels[event.channel]
+	}
+	return true
+}
+
+// pluginEvent is sent to plugins.
+type pluginEvent struct {
+	ID       uint64 `json:"id"`
+	Event    string `json:"event"`
+	Nick     string `json:"nick"`
+	Username string `json:"username"`
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+	Account  string `json:"account,omitempty"`
+	// for message events:
+	Command string `json:"command,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Message string `json:"message,omitempty"`
+	// for nick events:
+	NewNick string `json:"newNick,omitempty"`
+
+	channel string // casefolded target
+}
+
+// pluginVerdict is received from plugins.
+type pluginVerdict struct {
+	ID      uint64 `json:"id"`
+	Verdict string `json:"verdict"`
+	// replacement message, for the modify verdict on message events
+	Message string `json:"message,omitempty"`
+	// shown to the user, for the block verdict
+	Reason string `json:"reason,omitempty"`
+}
+
+func newPluginEvent(client *Client, event string) pluginEvent {
+	details := client.Details()
+	result := pluginEvent{
+		Event:    event,
+		Nick:     details.nick,
+		Username: details.username,
+		Hostname: details.hostname,
+		IP:       client.IP().String(),
+	}
+	if details.account != "" {
+		result.Account = details.accountName
+	}
+	return result
+}
+
+// plugin is a running (or restartable) plugin process.
+type plugin struct {
+	config PluginConfig
+	logger *logger.Manager
+
+	lockorder.Tier1Mutex
+	cmd       *exec.Cmd
+	stdin     *os.File
+	pending   map[uint64]chan pluginVerdict
+	nextID    uint64
+	lastStart time.Time
+	stopped   bool
+}
+
+// ensureRunning starts the plugin process if necessary. It must be called
+// with the plugin's mutex held.
+func (p *plugin) ensureRunning() error {
+	if p.cmd != nil {
+		return nil
+	}
+	if p.stopped || time.Since(p.lastStart) < pluginRestartInterval {
+		return errPluginUnavailable
+	}
+	p.lastStart = time.Now()
+
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(p.config.Command, p.config.Args...)
+	cmd.Stdin = stdinReader
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	stdinReader.Close()
+	if err != nil {
+		stdinWriter.Close()
+		p.logger.Error("plugins", "could not start plugin", p.config.Name, err.Error())
+		return err
+	}
+	p.logger.Info("plugins", "started plugin", p.config.Name)
+	p.cmd = cmd
+	p.stdin = stdinWriter
+	p.pending = make(map[uint64]chan pluginVerdict)
+	go p.readVerdicts(cmd, stdout)
+	return nil
+}
+
+// readVerdicts reads the plugin's answers until it exits.
+func (p *plugin) readVerdicts(cmd *exec.Cmd, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var verdict pluginVerdict
+		if err := json.Unmarshal(scanner.Bytes(), &verdict); err != nil {
+			p.logger.Warning("plugins", "invalid response from plugin", p.config.Name, err.Error())
+			continue
+		}
+		p.Lock()
+		if ch, ok := p.pending[verdict.ID]; ok && p.cmd == cmd {
+			delete(p.pending, verdict.ID)
+			ch <- verdict
+		}
+		p.Unlock()
+	}
+	cmd.Wait()
+
+	p.Lock()
+	defer p.Unlock()
+	if p.cmd == cmd {
+		if !p.stopped {
+			p.logger.Error("plugins", "plugin exited", p.config.Name)
+		}
+		p.stdin.Close()
+		p.cmd = nil
+		// fail everything that was waiting on this process
+		for id, ch := range p.pending {
+			delete(p.pending, id)
+			close(ch)
+		}
+	}
+}
+
+// query sends an event to the plugin and waits for its verdict.
+func (p *plugin) query(event pluginEvent) (verdict pluginVerdict) {
+	failure := pluginVerdict{Verdict: pluginVerdictAllow}
+	if p.config.FailClosed {
+		failure.Verdict = pluginVerdictBlock
+	}
+
+	p.Lock()
+	if err := p.ensureRunning(); err != nil {
+		p.Unlock()
+		return failure
+	}
+	p.nextID++
+	event.ID = p.nextID
+	ch := make(chan pluginVerdict, 1)
+	p.pending[event.ID] = ch
+	line, err := json.Marshal(event)
+	if err == nil {
+		// don't block forever if the plugin stops reading its input
+		p.stdin.SetWriteDeadline(time.Now().Add(p.config.Timeout))
+		_, err = p.stdin.Write(append(line, '\n'))
+	}
+	p.Unlock()
+
+	if err == nil {
+		timer := time.NewTimer(p.config.Timeout)
+		defer timer.Stop()
+		select {
+		case result, ok := <-ch:
+			if ok {
+				return result
+			}
+		case <-timer.C:
+			p.logger.Warning("plugins", "plugin timed out", p.config.Name)
+		}
+	}
+
+	p.Lock()
+	delete(p.pending, event.ID)
+	p.Unlock()
+	return failure
+}
+
+// stop terminates the plugin process; it won't be restarted.
+func (p *plugin) stop() {
+	p.Lock()
+	defer p.Unlock()
+	p.stopped = true
+	if p.cmd != nil {
+		// closing its input also stops any children it may have spawned
+		p.stdin.Close()
+		p.cmd.Process.Kill()
+	}
+}
+
+// PluginManager holds the configured plugins.
+type PluginManager struct {
+	lockorder.Tier2RWMutex
+	configs []PluginConfig
+	plugins []*plugin
+}
+
+// Configure (re)starts the plugins if their configuration changed.
+func (pm *PluginManager) Configure(configs []PluginConfig, logger *logger.Manager) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	if reflect.DeepEqual(pm.configs, configs) {
+		return
+	}
+	for _, p := range pm.plugins {
+		p.stop()
+	}
+	pm.configs = configs
+	pm.plugins = nil
+	for _, config := range configs {
+		pm.plugins = append(pm.plugins, &plugin{config: config, logger: logger})
+	}
+}
+
+// Dispatch sends an event to each interested plugin in turn, returning the
+// combined verdict: block if any of them blocked it, otherwise modify if any
+// of them modified it (in which case later plugins see the modified message).
+func (pm *PluginManager) Dispatch(event pluginEvent) (result pluginVerdict) {
+	pm.RLock()
+	plugins := pm.plugins
+	pm.RUnlock()
+
+	result.Verdict = pluginVerdictAllow
+	for _, p := range plugins {
+		if !p.config.wants(&event) {
+			continue
+		}
+		verdict := p.query(event)
+		switch verdict.Verdict {
+		case pluginVerdictBlock:
+			return verdict
+		case pluginVerdictModify:
+			if event.Event == pluginEventMessage {
+				event.Message = verdict.Message
+				result = verdict
+			}
+		}
+	}
+	return
+}
+
+// filterChannelMessage runs a client's message to a channel past the plugins,
+// returning the (possibly modified) message and whether it may be sent.
+func (server *Server) filterChannelMessage(client *Client, command string, channel *Channel, splitMsg utils.SplitMessage, rb *ResponseBuffer) (result utils.SplitMessage, allowed bool) {
+	event := newPluginEvent(client, pluginEventMessage)
+	event.Command = command
+	event.Target = channel.Name()
+	event.channel = channel.NameCasefolded()
+	event.Message = splitMsg.Message
+
+	verdict := server.plugins.Dispatch(event)
+	switch verdict.Verdict {
+	case pluginVerdictBlock:
+		if command != "NOTICE" {
+			reason := verdict.Reason
+			if reason == "" {
+				reason = client.t("Your message was blocked")
+			}
+			rb.Add(nil, server.name, ERR_CANNOTSENDTOCHAN, client.Nick(), channel.Name(), reason)
+		}
+		return splitMsg, false
+	case pluginVerdictModify:
+		if verdict.Message == "" {
+			return splitMsg, false
+		}
+		return utils.MakeSplitMessage(verdict.Message), true
+	}
+	return splitMsg, true
+}
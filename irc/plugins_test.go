@@ -0,0 +1,126 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/logger"
+)
+
+// blocks messages containing "spam", rewrites messages containing "darn", allows everything else
+const testPluginScript = `while read line; do
+	id=$(echo "$line" | sed 's/.*"id":\([0-9]*\).*/\1/')
+	case "$line" in
+		*spam*) echo "{\"id\":$id,\"verdict\":\"block\",\"reason\":\"no spam\"}" ;;
+		*darn*) echo "{\"id\":$id,\"verdict\":\"modify\",\"message\":\"d**n\"}" ;;
+		*) echo "{\"id\":$id,\"verdict\":\"allow\"}" ;;
+	esac
+done`
+
+func newTestPluginManager(t *testing.T, configs ...PluginConfig) *PluginManager {
+	for i := range configs {
+		if err := configs[i].prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pm PluginManager
+	pm.Configure(configs, logger)
+	return &pm
+}
+
+func testMessageEvent(channel, message string) pluginEvent {
+	event := pluginEvent{Event: pluginEventMessage, Target: channel, Message: message}
+	event.channel, _ = CasefoldChannel(channel)
+	return event
+}
+
+func TestPluginConfig(t *testing.T) {
+	conf := PluginConfig{Name: "test", Command: "true", Events: []string{"message", "nick"}, Channels: []string{"#Chat"}}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Timeout != defaultPluginTimeout {
+		t.Errorf("default timeout not set")
+	}
+	if !conf.wants(&pluginEvent{Event: pluginEventNick}) || conf.wants(&pluginEvent{Event: pluginEventConnect}) {
+		t.Errorf("incorrect event filtering")
+	}
+	if !conf.wants(&pluginEvent{Event: pluginEventMessage, channel: "#chat"}) || conf.wants(&pluginEvent{Event: pluginEventMessage, channel: "#other"}) {
+		t.Errorf("incorrect channel filtering")
+	}
+
+	conf = PluginConfig{Name: "test", Command: "true", Events: []string{"join"}}
+	if conf.prepare() == nil {
+		t.Errorf("unknown event type was accepted")
+	}
+	conf = PluginConfig{Name: "test", Events: []string{"nick"}}
+	if conf.prepare() == nil {
+		t.Errorf("plugin without a command was accepted")
+	}
+}
+
+func TestPluginVerdicts(t *testing.T) {
+	pm := newTestPluginManager(t, PluginConfig{
+		Name:     "test",
+		Command:  "/bin/sh",
+		Args:     []string{"-c", testPluginScript},
+		Events:   []string{"message"},
+		Channels: []string{"*"},
+	})
+	defer pm.Configure(nil, nil)
+
+	verdict := pm.Dispatch(testMessageEvent("#chat", "hello"))
+	if verdict.Verdict != pluginVerdictAllow {
+		t.Errorf("expected allow, got %#v", verdict)
+	}
+	verdict = pm.Dispatch(testMessageEvent("#chat", "buy spam"))
+	if verdict.Verdict != pluginVerdictBlock || verdict.Reason != "no spam" {
+		t.Errorf("expected block, got %#v", verdict)
+	}
+	verdict = pm.Dispatch(testMessageEvent("#chat", "darn it"))
+	if verdict.Verdict != pluginVerdictModify || verdict.Message != "d**n" {
+		t.Errorf("expected modify, got %#v", verdict)
+	}
+	// not subscribed:
+	verdict = pm.Dispatch(pluginEvent{Event: pluginEventNick, NewNick: "spam"})
+	if verdict.Verdict != pluginVerdictAllow {
+		t.Errorf("expected allow, got %#v", verdict)
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	// a plugin that never answers
+	config := PluginConfig{
+		Name:    "test",
+		Command: "/bin/sh",
+		Args:    []string{"-c", "cat > /dev/null"},
+		Events:  []string{"connect"},
+		Timeout: 50 * time.Millisecond,
+	}
+	pm := newTestPluginManager(t, config)
+	if verdict := pm.Dispatch(pluginEvent{Event: pluginEventConnect}); verdict.Verdict != pluginVerdictAllow {
+		t.Errorf("plugin should fail open, got %#v", verdict)
+	}
+
+	config.FailClosed = true
+	pm.Configure(nil, nil)
+	pm = newTestPluginManager(t, config)
+	defer pm.Configure(nil, nil)
+	if verdict := pm.Dispatch(pluginEvent{Event: pluginEventConnect}); verdict.Verdict != pluginVerdictBlock {
+		t.Errorf("plugin should fail closed, got %#v", verdict)
+	}
+
+	// a plugin that can't be started
+	config.Command = "/nonexistent/plugin"
+	pm = newTestPluginManager(t, config)
+	if verdict := pm.Dispatch(pluginEvent{Event: pluginEventConnect}); verdict.Verdict != pluginVerdictBlock {
+		t.Errorf("plugin should fail closed, got %#v", verdict)
+	}
+}
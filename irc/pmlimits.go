@@ -0,0 +1,160 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/connection_limits"
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// PM spam waves typically come from clients that aren't logged into accounts:
+// they connect, message as many users as they can, and disconnect. Direct
+// messages (PRIVMSG and NOTICE) from such clients are limited, both in how
+// many different users they can message per window and in how many messages
+// they can send to any one user per window. Clients that keep running into
+// the limits are muted for a while, and opers are notified.
+
+const (
+	defaultPMLimitsWindow       = time.Minute
+	defaultPMLimitsTargets      = 10
+	defaultPMLimitsPerTarget    = 10
+	defaultPMLimitsViolations   = 3
+	defaultPMLimitsMuteDuration = 10 * time.Minute
+)
+
+// pmLimitResult is the outcome of checking a direct message against the limits.
+type pmLimitResult uint
+
+const (
+	pmLimitAllowed pmLimitResult = iota
+	pmLimitTooManyTargets
+	pmLimitTooFast
+	pmLimitMuted
+)
+
+// PMLimitsConfig controls the limits on direct messages from clients that
+// aren't logged into accounts.
+type PMLimitsConfig struct {
+	Enabled bool
+	Window  time.Duration
+	// each client can message at most this many different users per window
+	MaxTargets int `yaml:"max-targets"`
+	// and send at most this many messages to any one user per window
+	MaxMessagesPerTarget int `yaml:"max-messages-per-target"`
+	// clients that exceed the limits this many times within a window are muted
+	MaxViolations int           `yaml:"max-violations"`
+	MuteDuration  time.Duration `yaml:"mute-duration"`
+}
+
+func (conf *PMLimitsConfig) prepare() {
+	if conf.Window == 0 {
+		conf.Window = defaultPMLimitsWindow
+	}
+	if conf.MaxTargets == 0 {
+		conf.MaxTargets = defaultPMLimitsTargets
+	}
+	if conf.MaxMessagesPerTarget == 0 {
+		conf.MaxMessagesPerTarget = defaultPMLimitsPerTarget
+	}
+	if conf.MaxViolations == 0 {
+		conf.MaxViolations = defaultPMLimitsViolations
+	}
+	if conf.MuteDuration == 0 {
+		conf.MuteDuration = defaultPMLimitsMuteDuration
+	}
+}
+
+// pmLimitState tracks a client's recent direct messages.
+type pmLimitState struct {
+	targets       map[string]connection_limits.ThrottleDetails
+	violations    int
+	lastViolation time.Time
+	mutedUntil    time.Time
+}
+
+// touchPMLimits records a direct message to the given (casefolded) nickname,
+// returning whether it's allowed, and whether the client was muted as a
+// result of it.
+func (client *Client) touchPMLimits(config *PMLimitsConfig, target string) (result pmLimitResult, muted bool) {
+	if !config.Enabled || client.HasMode(modes.Operator) || client.LoggedIntoAccount() {
+		return pmLimitAllowed, false
+	}
+	now := time.Now()
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+
+	state := &client.pmLimits
+	if now.Before(state.mutedUntil) {
+		return pmLimitMuted, false
+	}
+	if state.targets == nil {
+		state.targets = make(map[string]connection_limits.ThrottleDetails)
+	}
+	for existing, details := range state.targets {
+		if config.Window <= now.Sub(details.Start) {
+			delete(state.targets, existing)
+		}
+	}
+	if config.Window <= now.Sub(state.lastViolation) {
+		state.violations = 0
+	}
+
+	details, exists := state.targets[target]
+	if !exists && config.MaxTargets <= len(state.targets) {
+		result = pmLimitTooManyTargets
+	} else {
+		throttle := connection_limits.GenericThrottle{
+			ThrottleDetails: details,
+			Duration:        config.Window,
+			Limit:           config.MaxMessagesPerTarget,
+		}
+		throttled, _ := throttle.Touch()
+		state.targets[target] = throttle.ThrottleDetails
+		if !throttled {
+			return pmLimitAllowed, false
+		}
+		result = pmLimitTooFast
+	}
+
+	state.violations++
+	state.lastViolation = now
+	if config.MaxViolations <= state.violations {
+		state.violations = 0
+		state.targets = nil
+		state.mutedUntil = now.Add(config.MuteDuration)
+		return pmLimitMuted, true
+	}
+	return result, false
+}
+
+// allowPMLimits applies the limits on direct messages from clients that aren't
+// logged in. If `reply` is set, the client is told why a message was refused
+// (NOTICE must never generate automatic replies, so it isn't).
+func (server *Server) allowPMLimits(client *Client, target *Client, reply bool, rb *ResponseBuffer) bool {
+	config := &server.AccountConfig().PMLimits
+	result, muted := client.touchPMLimits(config, target.NickCasefolded())
+	if muted {
+		server.logger.Warning("flood", "Muted unregistered client for PM flooding", client.NickMaskString())
+		server.snomasks.Send(sno.LocalFlood, fmt.Sprintf(ircfmt.Unescape("Muted $c[grey][$r%[1]s$c[grey]] for %[2]v for sending direct messages too quickly"), client.NickMaskString(), config.MuteDuration))
+	}
+	if !reply || result == pmLimitAllowed {
+		return result == pmLimitAllowed
+	}
+
+	cnick := client.Nick()
+	switch result {
+	case pmLimitTooManyTargets:
+		rb.Add(nil, server.name, ERR_TOOMANYTARGETS, cnick, target.Nick(), client.t("You're messaging too many users at once; log into an account or try again later"))
+	case pmLimitTooFast:
+		rb.Add(nil, server.name, ERR_TOOMANYTARGETS, cnick, target.Nick(), client.t("You're messaging this user too quickly; log into an account or try again later"))
+	case pmLimitMuted:
+		rb.Add(nil, server.name, ERR_TOOMANYTARGETS, cnick, target.Nick(), client.t("You've been prevented from sending direct messages for a while, because you sent too many; log into an account to send them"))
+	}
+	return false
+}
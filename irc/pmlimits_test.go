@@ -0,0 +1,47 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestPMLimits(t *testing.T) {
+	config := PMLimitsConfig{Enabled: true, MaxTargets: 2, MaxMessagesPerTarget: 2, MaxViolations: 2}
+	config.prepare()
+	client := &Client{flags: modes.NewModeSet()}
+
+	for _, target := range []string{"alice", "alice", "bob"} {
+		if result, _ := client.touchPMLimits(&config, target); result != pmLimitAllowed {
+			t.Errorf("messages within the limits should be allowed, got %v", result)
+		}
+	}
+	if result, muted := client.touchPMLimits(&config, "carol"); result != pmLimitTooManyTargets || muted {
+		t.Errorf("too many targets should be refused, got %v", result)
+	}
+	if result, muted := client.touchPMLimits(&config, "alice"); result != pmLimitMuted || !muted {
+		t.Errorf("repeated violations should mute the client, got %v", result)
+	}
+	if result, muted := client.touchPMLimits(&config, "bob"); result != pmLimitMuted || muted {
+		t.Errorf("muted clients can't send messages, got %v", result)
+	}
+
+	client.pmLimits.mutedUntil = time.Now().Add(-time.Second)
+	if result, _ := client.touchPMLimits(&config, "bob"); result != pmLimitAllowed {
+		t.Errorf("the mute should expire, got %v", result)
+	}
+	client.touchPMLimits(&config, "bob")
+	if result, muted := client.touchPMLimits(&config, "bob"); result != pmLimitTooFast || muted {
+		t.Errorf("too many messages to one target should be refused, got %v", result)
+	}
+
+	// clients that are logged in aren't limited
+	client.account = "dan"
+	if result, _ := client.touchPMLimits(&config, "bob"); result != pmLimitAllowed {
+		t.Errorf("logged-in clients shouldn't be limited, got %v", result)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+// Spammers tend to use throwaway accounts (or no account at all), so clients
+// can be put "on probation" while their accounts are new: they can't create
+// channels, message many different users, or change nicknames rapidly. A
+// client's reputation is the age of its account, or if it isn't logged in,
+// the age of its connection.
+
+const (
+	defaultProbationDuration  = 24 * time.Hour
+	defaultProbationPMWindow  = time.Minute
+	defaultProbationPMTargets = 5
+)
+
+// ProbationConfig controls the restrictions on new accounts.
+type ProbationConfig struct {
+	Enabled bool
+	// accounts are on probation for this long after they're registered
+	Duration time.Duration
+	// clients that aren't logged in are on probation for this long after
+	// they connect (if zero, they aren't put on probation)
+	UnregisteredDuration time.Duration `yaml:"unregistered-duration"`
+	// if false, clients on probation can't create new channels
+	AllowChannelCreation bool `yaml:"allow-channel-creation"`
+	// clients on probation can send direct messages to at most this many
+	// different users per window
+	MaxPMTargets int           `yaml:"max-pm-targets"`
+	PMWindow     time.Duration `yaml:"pm-window"`
+	// clients on probation must wait this long between nick changes
+	NickChangeInterval time.Duration `yaml:"nick-change-interval"`
+}
+
+func (conf *ProbationConfig) prepare() {
+	if conf.Duration == 0 {
+		conf.Duration = defaultProbationDuration
+	}
+	if conf.MaxPMTargets == 0 {
+		conf.MaxPMTargets = defaultProbationPMTargets
+	}
+	if conf.PMWindow == 0 {
+		conf.PMWindow = defaultProbationPMWindow
+	}
+}
+
+// onProbation returns whether the client is subject to the restrictions on
+// new accounts.
+func (client *Client) onProbation(config *ProbationConfig) bool {
+	if !config.Enabled || client.HasMode(modes.Operator) {
+		return false
+	}
+	client.stateMutex.RLock()
+	account := client.account
+	registeredAt := client.accountRegisteredAt
+	client.stateMutex.RUnlock()
+	if account != "" {
+		return time.Since(registeredAt) < config.Duration
+	}
+	return time.Since(client.ctime) < config.UnregisteredDuration
+}
+
+// canCreateChannel returns whether the client may create a new channel.
+func (client *Client) canCreateChannel(config *ProbationConfig) bool {
+	return config.AllowChannelCreation || !client.onProbation(config)
+}
+
+// allowPMTarget records a direct message to the given (casefolded) nickname,
+// returning whether it's allowed: clients on probation can only message a
+// limited number of different users at a time.
+func (client *Client) allowPMTarget(config *ProbationConfig, target string) bool {
+	if !client.onProbation(config) {
+		return true
+	}
+	now := time.Now()
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	for nick, sent := range client.pmTargets {
+		if config.PMWindow <= now.Sub(sent) {
+			delete(client.pmTargets, nick)
+		}
+	}
+	if _, exists := client.pmTargets[target]; !exists && config.MaxPMTargets <= len(client.pmTargets) {
+		return false
+	}
+	if client.pmTargets == nil {
+		client.pmTargets = make(map[string]time.Time)
+	}
+	client.pmTargets[target] = now
+	return true
+}
+
+// nickChangeWait returns how long the client must wait before changing its
+// nickname again, or zero if it can change it now.
+func (client *Client) nickChangeWait(config *ProbationConfig) time.Duration {
+	if !client.onProbation(config) {
+		return 0
+	}
+	client.stateMutex.RLock()
+	lastNickChange := client.lastNickChange
+	client.stateMutex.RUnlock()
+	wait := config.NickChangeInterval - time.Since(lastNickChange)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (client *Client) recordNickChange() {
+	client.stateMutex.Lock()
+	client.lastNickChange = time.Now()
+	client.stateMutex.Unlock()
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestProbation(t *testing.T) {
+	config := ProbationConfig{Enabled: true, NickChangeInterval: time.Minute}
+	config.prepare()
+
+	client := &Client{flags: modes.NewModeSet(), ctime: time.Now()}
+	if client.onProbation(&config) {
+		t.Errorf("unregistered clients shouldn't be on probation by default")
+	}
+	config.UnregisteredDuration = time.Hour
+	if !client.onProbation(&config) || client.canCreateChannel(&config) {
+		t.Errorf("new unregistered client should be on probation")
+	}
+
+	client.account = "dan"
+	client.accountRegisteredAt = time.Now().Add(-48 * time.Hour)
+	if client.onProbation(&config) {
+		t.Errorf("old account shouldn't be on probation")
+	}
+	client.accountRegisteredAt = time.Now()
+	if !client.onProbation(&config) {
+		t.Errorf("new account should be on probation")
+	}
+
+	client.flags.SetMode(modes.Operator, true)
+	if client.onProbation(&config) {
+		t.Errorf("opers shouldn't be on probation")
+	}
+	client.flags.SetMode(modes.Operator, false)
+
+	if client.nickChangeWait(&config) != 0 {
+		t.Errorf("first nick change should be allowed")
+	}
+	client.recordNickChange()
+	if wait := client.nickChangeWait(&config); wait <= 0 || time.Minute < wait {
+		t.Errorf("incorrect nick change wait: %v", wait)
+	}
+}
+
+func TestProbationPMTargets(t *testing.T) {
+	config := ProbationConfig{Enabled: true, MaxPMTargets: 2}
+	config.prepare()
+	client := &Client{flags: modes.NewModeSet(), account: "dan", accountRegisteredAt: time.Now()}
+
+	if !client.allowPMTarget(&config, "alice") || !client.allowPMTarget(&config, "bob") {
+		t.Errorf("messages within the limit should be allowed")
+	}
+	if client.allowPMTarget(&config, "carol") {
+		t.Errorf("too many targets should be refused")
+	}
+	if !client.allowPMTarget(&config, "alice") {
+		t.Errorf("further messages to an existing target should be allowed")
+	}
+
+	client.pmTargets["alice"] = time.Now().Add(-2 * config.PMWindow)
+	if !client.allowPMTarget(&config, "carol") {
+		t.Errorf("expired targets should not count against the limit")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+const (
+	defaultHostnameLookupTimeout = 2 * time.Second
+	defaultHostnameCacheDuration = 10 * time.Minute
+	// expired cache entries are swept out when the cache grows past this size
+	hostnameCacheSweepSize = 4096
+)
+
+// ReverseDNSConfig controls hostname lookups for connecting clients.
+type ReverseDNSConfig struct {
+	Enabled bool
+	// whether to resolve the hostname back to an IP address, and reject it
+	// unless it matches the client's IP
+	ForwardConfirm bool `yaml:"forward-confirm"`
+	Timeout        time.Duration
+	CacheDuration  time.Duration `yaml:"cache-duration"`
+}
+
+func (conf *ReverseDNSConfig) prepare() {
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultHostnameLookupTimeout
+	}
+	if conf.CacheDuration == 0 {
+		conf.CacheDuration = defaultHostnameCacheDuration
+	}
+}
+
+type hostnameCacheEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// HostnameResolver looks up (and caches) the hostnames of IP addresses.
+type HostnameResolver struct {
+	lockorder.Tier1Mutex
+	cache map[string]hostnameCacheEntry
+
+	// these can be replaced for testing:
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	lookupIP   func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewHostnameResolver returns a new HostnameResolver using the system resolver.
+func NewHostnameResolver() *HostnameResolver {
+	return &HostnameResolver{
+		cache:      make(map[string]hostnameCacheEntry),
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		lookupIP:   net.DefaultResolver.LookupIPAddr,
+	}
+}
+
+// Lookup returns the hostname to display for `ip`: its hostname from reverse DNS,
+// if it has a valid one, otherwise the IP address itself.
+func (hr *HostnameResolver) Lookup(ip net.IP, config ReverseDNSConfig) string {
+	ipString := ip.String()
+	if !config.Enabled {
+		return utils.IPStringToHostname(ipString)
+	}
+
+	now := time.Now()
+	hr.Lock()
+	entry, ok := hr.cache[ipString]
+	hr.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.hostname
+	}
+
+	hostname := hr.resolve(ip, config)
+	if hostname == "" {
+		hostname = utils.IPStringToHostname(ipString)
+	}
+
+	hr.Lock()
+	defer hr.Unlock()
+	if hostnameCacheSweepSize <= len(hr.cache) {
+		for key, entry := range hr.cache {
+			if !now.Before(entry.expires) {
+				delete(hr.cache, key)
+			}
+		}
+	}
+	hr.cache[ipString] = hostnameCacheEntry{hostname: hostname, expires: now.Add(config.CacheDuration)}
+	return hostname
+}
+
+// resolve does the actual DNS lookups, returning "" if there's no valid hostname.
+func (hr *HostnameResolver) resolve(ip net.IP, config ReverseDNSConfig) string {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	names, err := hr.lookupAddr(ctx, ip.String())
+	if err != nil {
+		return ""
+	}
+	for _, name := range names {
+		candidate := strings.TrimSuffix(name, ".")
+		if !utils.IsHostname(candidate) {
+			continue
+		}
+		if !config.ForwardConfirm || hr.forwardConfirm(ctx, candidate, ip) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// forwardConfirm returns whether `hostname` resolves back to `ip`.
+func (hr *HostnameResolver) forwardConfirm(ctx context.Context, hostname string, ip net.IP) bool {
+	addrs, err := hr.lookupIP(ctx, hostname)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
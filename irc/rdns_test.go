@@ -0,0 +1,97 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestHostnameResolver(names map[string][]string, ips map[string][]string, lookups *int) *HostnameResolver {
+	hr := NewHostnameResolver()
+	hr.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		*lookups++
+		if result, ok := names[addr]; ok {
+			return result, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	hr.lookupIP = func(ctx context.Context, host string) (result []net.IPAddr, err error) {
+		for _, ip := range ips[host] {
+			result = append(result, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		if result == nil {
+			err = errors.New("no such host")
+		}
+		return
+	}
+	return hr
+}
+
+func TestHostnameLookup(t *testing.T) {
+	names := map[string][]string{
+		"192.0.2.1":   {"good.example.com."},
+		"192.0.2.2":   {"spoofed.example.com."},
+		"192.0.2.3":   {"invalid_hostname"},
+		"2001:db8::1": {"v6.example.com."},
+	}
+	ips := map[string][]string{
+		"good.example.com":    {"192.0.2.1"},
+		"spoofed.example.com": {"198.51.100.1"},
+		"v6.example.com":      {"2001:db8::1"},
+	}
+	var lookups int
+	hr := newTestHostnameResolver(names, ips, &lookups)
+	config := ReverseDNSConfig{Enabled: true, ForwardConfirm: true}
+	config.prepare()
+
+	expected := map[string]string{
+		"192.0.2.1":   "good.example.com",
+		"192.0.2.2":   "192.0.2.2",
+		"192.0.2.3":   "192.0.2.3",
+		"192.0.2.4":   "192.0.2.4",
+		"2001:db8::1": "v6.example.com",
+		"::1":         "0::1",
+	}
+	for ip, hostname := range expected {
+		if result := hr.Lookup(net.ParseIP(ip), config); result != hostname {
+			t.Errorf("lookup of %s: expected %s, got %s", ip, hostname, result)
+		}
+	}
+
+	// without forward confirmation, the spoofed hostname is accepted
+	config.ForwardConfirm = false
+	hr = newTestHostnameResolver(names, ips, &lookups)
+	if result := hr.Lookup(net.ParseIP("192.0.2.2"), config); result != "spoofed.example.com" {
+		t.Errorf("unexpected hostname %s", result)
+	}
+
+	config.Enabled = false
+	if result := hr.Lookup(net.ParseIP("192.0.2.1"), config); result != "192.0.2.1" {
+		t.Errorf("lookups should be disabled, got %s", result)
+	}
+}
+
+func TestHostnameCache(t *testing.T) {
+	var lookups int
+	hr := newTestHostnameResolver(map[string][]string{"192.0.2.1": {"good.example.com."}}, nil, &lookups)
+	config := ReverseDNSConfig{Enabled: true}
+	config.prepare()
+
+	ip := net.ParseIP("192.0.2.1")
+	hr.Lookup(ip, config)
+	hr.Lookup(ip, config)
+	if lookups != 1 {
+		t.Errorf("expected one lookup, got %d", lookups)
+	}
+
+	// expire the entry
+	hr.cache[ip.String()] = hostnameCacheEntry{hostname: "good.example.com", expires: time.Now().Add(-time.Second)}
+	if result := hr.Lookup(ip, config); result != "good.example.com" || lookups != 2 {
+		t.Errorf("expired entry was not looked up again: %s %d", result, lookups)
+	}
+}
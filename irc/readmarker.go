@@ -0,0 +1,218 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Read markers record, per account, how far each conversation has been read.
+// There's a shared marker per target, which is what the draft/read-marker
+// extension exposes (so that reading a channel on one device marks it as read
+// everywhere), and a marker per device, which also advances when a device
+// disconnects (since it had seen everything up to that point). When a device
+// rejoins a channel, the automatic history replay starts from its own marker,
+// so it only gets what it hasn't seen.
+//
+// Devices are identified by the client: a SASL PLAIN username of the form
+// account@device logs into `account` as `device`.
+
+const (
+	// the key in readMarkers for the markers shared by all devices
+	sharedReadMarkers = ""
+
+	maxReadMarkerDevices = 16
+	maxReadMarkerTargets = 256
+	maxDeviceIDLength    = 32
+)
+
+// readMarkers maps device IDs to their markers, which map casefolded targets
+// (channels and nicknames) to the time up to which they've been read.
+type readMarkers map[string]map[string]time.Time
+
+// isValidDeviceID returns whether a device ID is acceptable: short, and
+// made of letters, digits, and - or _.
+func isValidDeviceID(device string) bool {
+	if device == "" || maxDeviceIDLength < len(device) {
+		return false
+	}
+	for _, r := range device {
+		if !(('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitDeviceID splits a login name of the form account@device; if there's no
+// valid device ID, the name is returned unchanged.
+func splitDeviceID(name string) (account, device string) {
+	if i := strings.LastIndexByte(name, '@'); i != -1 && isValidDeviceID(name[i+1:]) {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// casefoldReadMarkerTarget casefolds a channel name or nickname.
+func casefoldReadMarkerTarget(target string) (string, error) {
+	if cftarget, err := CasefoldChannel(target); err == nil {
+		return cftarget, nil
+	}
+	return CasefoldName(target)
+}
+
+// advance moves the marker for target forward to timestamp (markers never move
+// backwards), returning the resulting marker.
+func (markers readMarkers) advance(device, target string, timestamp time.Time) time.Time {
+	deviceMarkers := markers[device]
+	if deviceMarkers == nil {
+		deviceMarkers = make(map[string]time.Time)
+		markers[device] = deviceMarkers
+	}
+	if current, ok := deviceMarkers[target]; ok && !timestamp.After(current) {
+		return current
+	}
+	deviceMarkers[target] = timestamp
+	return timestamp
+}
+
+// trim discards the oldest markers once there are too many of them, starting
+// with devices that haven't been seen in the longest time.
+func (markers readMarkers) trim() {
+	newest := func(deviceMarkers map[string]time.Time) (result time.Time) {
+		for _, timestamp := range deviceMarkers {
+			if result.Before(timestamp) {
+				result = timestamp
+			}
+		}
+		return
+	}
+	for maxReadMarkerDevices < len(markers) {
+		var oldestDevice string
+		var oldestTime time.Time
+		first := true
+		for device, deviceMarkers := range markers {
+			if device == sharedReadMarkers {
+				continue
+			}
+			if latest := newest(deviceMarkers); first || latest.Before(oldestTime) {
+				oldestDevice, oldestTime, first = device, latest, false
+			}
+		}
+		delete(markers, oldestDevice)
+	}
+	for _, deviceMarkers := range markers {
+		for maxReadMarkerTargets < len(deviceMarkers) {
+			var oldestTarget string
+			var oldestTime time.Time
+			first := true
+			for target, timestamp := range deviceMarkers {
+				if first || timestamp.Before(oldestTime) {
+					oldestTarget, oldestTime, first = target, timestamp, false
+				}
+			}
+			delete(deviceMarkers, oldestTarget)
+		}
+	}
+}
+
+func (am *AccountManager) loadReadMarkers(tx *buntdb.Tx, account string) (markers readMarkers) {
+	markers = make(readMarkers)
+	if rawMarkers, err := tx.Get(fmt.Sprintf(keyAccountReadMarkers, account)); err == nil {
+		json.Unmarshal([]byte(rawMarkers), &markers)
+	}
+	return
+}
+
+// updateReadMarkers runs `update` on the (casefolded) account's read markers,
+// then stores them.
+func (am *AccountManager) updateReadMarkers(account string, update func(markers readMarkers)) error {
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		// don't resurrect an account that was unregistered in the meantime
+		if _, err := tx.Get(fmt.Sprintf(keyAccountExists, account)); err != nil {
+			return errAccountDoesNotExist
+		}
+		markers := am.loadReadMarkers(tx, account)
+		update(markers)
+		markers.trim()
+		rawMarkers, err := json.Marshal(markers)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(fmt.Sprintf(keyAccountReadMarkers, account), string(rawMarkers), nil)
+		return err
+	})
+}
+
+// ReadMarker returns the time up to which the (casefolded) target has been
+// read by the given device of the (casefolded) account; if the device has no
+// marker of its own, or no device is given, the shared marker is returned.
+// The zero time means the target hasn't been read at all.
+func (am *AccountManager) ReadMarker(account, device, target string) (result time.Time) {
+	am.server.store.View(func(tx *buntdb.Tx) error {
+		markers := am.loadReadMarkers(tx, account)
+		result = markers[sharedReadMarkers][target]
+		if deviceMarker, ok := markers[device][target]; ok && device != sharedReadMarkers {
+			result = deviceMarker
+		}
+		return nil
+	})
+	return
+}
+
+// SetReadMarker marks the (casefolded) target as read up to timestamp, for the
+// given device and for the account as a whole, returning the resulting shared
+// marker (which is later than timestamp if the target was already read further).
+func (am *AccountManager) SetReadMarker(account, device, target string, timestamp time.Time) (result time.Time, err error) {
+	err = am.updateReadMarkers(account, func(markers readMarkers) {
+		if device != sharedReadMarkers {
+			markers.advance(device, target, timestamp)
+		}
+		result = markers.advance(sharedReadMarkers, target, timestamp)
+	})
+	return
+}
+
+// setDeviceReadMarkers advances the device's own markers for the (casefolded)
+// targets, leaving the shared markers alone.
+func (am *AccountManager) setDeviceReadMarkers(account, device string, targets []string, timestamp time.Time) error {
+	return am.updateReadMarkers(account, func(markers readMarkers) {
+		for _, target := range targets {
+			markers.advance(device, target, timestamp)
+		}
+	})
+}
+
+// recordDeviceQuit advances the markers of a disconnecting device for the
+// channels it was in, since it saw everything sent to them up to now.
+func (client *Client) recordDeviceQuit(channels []*Channel) {
+	account, device := client.Account(), client.DeviceID()
+	if account == "" || device == "" || len(channels) == 0 {
+		return
+	}
+	targets := make([]string, len(channels))
+	for i, channel := range channels {
+		targets[i] = channel.NameCasefolded()
+	}
+	client.server.accounts.setDeviceReadMarkers(account, device, targets, time.Now().UTC())
+}
+
+// sendReadMarker sends a session the marker for the target as a MARKREAD
+// line, either through rb or (if rb is nil) directly.
+func sendReadMarker(session *Client, target string, timestamp time.Time, rb *ResponseBuffer) {
+	value := "*"
+	if !timestamp.IsZero() {
+		value = "timestamp=" + timestamp.UTC().Format(IRCv3TimestampFormat)
+	}
+	if rb != nil {
+		rb.Add(nil, session.server.name, "MARKREAD", target, value)
+	} else {
+		session.Send(nil, session.server.name, "MARKREAD", target, value)
+	}
+}
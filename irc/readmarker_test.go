@@ -0,0 +1,107 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestSplitDeviceID(t *testing.T) {
+	assertSplit := func(name, expectedAccount, expectedDevice string) {
+		account, device := splitDeviceID(name)
+		if account != expectedAccount || device != expectedDevice {
+			t.Errorf("%s: expected %s and %s, got %s and %s", name, expectedAccount, expectedDevice, account, device)
+		}
+	}
+	assertSplit("shivaram", "shivaram", "")
+	assertSplit("shivaram@phone", "shivaram", "phone")
+	assertSplit("shivaram@work-laptop_2", "shivaram", "work-laptop_2")
+	assertSplit("shivaram@", "shivaram@", "")
+	assertSplit("shivaram@ph one", "shivaram@ph one", "")
+	assertSplit("shivaram@abcdefghijklmnopqrstuvwxyz0123456789", "shivaram@abcdefghijklmnopqrstuvwxyz0123456789", "")
+}
+
+func TestReadMarkersTrim(t *testing.T) {
+	base := time.Unix(1000000, 0).UTC()
+	markers := make(readMarkers)
+	for i := 0; i < maxReadMarkerDevices+2; i++ {
+		markers.advance(fmt.Sprintf("device%d", i), "#chat", base.Add(time.Duration(i)*time.Minute))
+	}
+	for i := 0; i < maxReadMarkerTargets+5; i++ {
+		markers.advance(sharedReadMarkers, fmt.Sprintf("#chan%d", i), base.Add(time.Duration(i)*time.Second))
+	}
+	markers.trim()
+
+	if len(markers) != maxReadMarkerDevices {
+		t.Errorf("expected %d devices, got %d", maxReadMarkerDevices, len(markers))
+	}
+	if _, ok := markers[sharedReadMarkers]; !ok {
+		t.Errorf("shared markers should never be discarded")
+	}
+	// the devices seen least recently go first
+	for _, device := range []string{"device0", "device1", "device2"} {
+		if _, ok := markers[device]; ok {
+			t.Errorf("%s should have been discarded", device)
+		}
+	}
+	if _, ok := markers["device3"]; !ok {
+		t.Errorf("device3 should have been kept")
+	}
+	if len(markers[sharedReadMarkers]) != maxReadMarkerTargets {
+		t.Errorf("expected %d targets, got %d", maxReadMarkerTargets, len(markers[sharedReadMarkers]))
+	}
+	if _, ok := markers[sharedReadMarkers]["#chan4"]; ok {
+		t.Errorf("oldest targets should have been discarded")
+	}
+}
+
+func TestReadMarkers(t *testing.T) {
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := &Server{store: store}
+	am := &AccountManager{server: server}
+	store.Update(func(tx *buntdb.Tx) error {
+		tx.Set(fmt.Sprintf(keyAccountExists, "shivaram"), "1", nil)
+		return nil
+	})
+
+	first := time.Unix(1000000, 0).UTC()
+	second := first.Add(time.Hour)
+	third := second.Add(time.Hour)
+
+	if marker := am.ReadMarker("shivaram", "", "#chat"); !marker.IsZero() {
+		t.Errorf("unexpected marker %v", marker)
+	}
+	if marker, err := am.SetReadMarker("shivaram", "phone", "#chat", second); err != nil || !marker.Equal(second) {
+		t.Errorf("unexpected marker %v: %v", marker, err)
+	}
+	// markers don't move backwards
+	if marker, err := am.SetReadMarker("shivaram", "", "#chat", first); err != nil || !marker.Equal(second) {
+		t.Errorf("unexpected marker %v: %v", marker, err)
+	}
+
+	// a device that disconnects has its own marker advanced, but not the shared one
+	am.setDeviceReadMarkers("shivaram", "laptop", []string{"#chat"}, third)
+	if marker := am.ReadMarker("shivaram", "laptop", "#chat"); !marker.Equal(third) {
+		t.Errorf("unexpected marker %v", marker)
+	}
+	if marker := am.ReadMarker("shivaram", "", "#chat"); !marker.Equal(second) {
+		t.Errorf("unexpected marker %v", marker)
+	}
+	// devices without markers of their own get the shared one
+	if marker := am.ReadMarker("shivaram", "tablet", "#chat"); !marker.Equal(second) {
+		t.Errorf("unexpected marker %v", marker)
+	}
+
+	if _, err := am.SetReadMarker("dan", "", "#chat", first); err != errAccountDoesNotExist {
+		t.Errorf("markers shouldn't be stored for nonexistent accounts: %v", err)
+	}
+}
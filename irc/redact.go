@@ -0,0 +1,102 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/modes"
+)
+
+// This implements draft/message-redaction: REDACT deletes a previously sent
+// message, identified by its msgid, from the stored history, and tells the
+// clients that saw it (if they support the capability) to hide it. Users can
+// redact their own messages, and channel operators can redact any message in
+// their channels.
+
+// authored returns whether the client sent the history item: if the client is
+// logged in, the item must have been sent from its account, otherwise from its
+// current nickmask.
+func (client *Client) authored(item history.Item) bool {
+	if account := client.Account(); account != "" {
+		cfaccount, err := CasefoldName(item.AccountName)
+		return err == nil && cfaccount == account
+	}
+	return item.Nick == client.NickMaskString()
+}
+
+// redactMessage finds the message with the given msgid in the buffer and, if
+// `allowed` approves of it, deletes it. It returns whether the message was
+// found, and whether it was deleted.
+func redactMessage(buffer *history.Buffer, msgid string, allowed func(item history.Item) bool) (found, deleted bool) {
+	hasMsgid := func(item history.Item) bool {
+		return item.HasMsgid(msgid)
+	}
+	items := buffer.Match(hasMsgid, false, 1)
+	if len(items) == 0 {
+		return false, false
+	}
+	if !allowed(items[0]) {
+		return true, false
+	}
+	return true, buffer.Delete(hasMsgid) != 0
+}
+
+// sendRedaction relays a REDACT line to the recipients that support
+// draft/message-redaction; the redacting client gets it through rb.
+func sendRedaction(client *Client, recipients []*Client, target, msgid, reason string, rb *ResponseBuffer) {
+	params := []string{target, msgid}
+	if reason != "" {
+		params = append(params, reason)
+	}
+	nickmask := client.NickMaskString()
+	for _, recipient := range recipients {
+		if !recipient.capabilities.Has(caps.MessageRedaction) {
+			continue
+		}
+		if recipient == client {
+			rb.Add(nil, nickmask, "REDACT", params...)
+		} else {
+			recipient.Send(nil, nickmask, "REDACT", params...)
+		}
+	}
+}
+
+// redactChannelMessage handles REDACT for a channel target.
+func (server *Server) redactChannelMessage(client *Client, channel *Channel, msgid, reason string, rb *ResponseBuffer) {
+	name := channel.Name()
+	if !channel.hasClient(client) {
+		rb.Add(nil, server.name, "FAIL", "REDACT", "INVALID_TARGET", name, client.t("You're not on that channel"))
+		return
+	}
+	isOp := channel.ClientIsAtLeast(client, modes.ChannelOperator)
+	found, deleted := redactMessage(&channel.history, msgid, func(item history.Item) bool {
+		return isOp || client.authored(item)
+	})
+	if !found {
+		rb.Add(nil, server.name, "FAIL", "REDACT", "UNKNOWN_MSGID", name, msgid, client.t("This message does not exist or is too old"))
+		return
+	} else if !deleted {
+		rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", name, msgid, client.t("You're not allowed to redact this message"))
+		return
+	}
+	sendRedaction(client, channel.Members(), name, msgid, reason, rb)
+}
+
+// redactDirectMessage handles REDACT for a direct message sent to user.
+func (server *Server) redactDirectMessage(client *Client, user *Client, msgid, reason string, rb *ResponseBuffer) {
+	// direct messages are stored in the recipient's history, along with their messages
+	// from everyone else; a message someone else sent is reported as unknown, so that
+	// REDACT can't be used to probe for other people's msgids
+	_, deleted := redactMessage(user.history, msgid, client.authored)
+	if !deleted {
+		rb.Add(nil, server.name, "FAIL", "REDACT", "UNKNOWN_MSGID", user.Nick(), msgid, client.t("This message does not exist or is too old"))
+		return
+	}
+	recipients := []*Client{user}
+	if user != client {
+		recipients = append(recipients, client)
+	}
+	sendRedaction(client, recipients, user.Nick(), msgid, reason, rb)
+}
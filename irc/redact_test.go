@@ -0,0 +1,73 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+func TestRedactMessage(t *testing.T) {
+	buffer := history.NewHistoryBuffer(16)
+	add := func(msgid, account string) {
+		message := utils.MakeSplitMessage("hi")
+		message.Msgid = msgid
+		buffer.Add(history.Item{Type: history.Privmsg, AccountName: account, Message: message})
+	}
+	add("a", "shivaram")
+	add("b", "dan")
+	fromShivaram := func(item history.Item) bool {
+		return item.AccountName == "shivaram"
+	}
+
+	if found, deleted := redactMessage(buffer, "c", fromShivaram); found || deleted {
+		t.Errorf("unknown msgid should not be found")
+	}
+	if found, deleted := redactMessage(buffer, "b", fromShivaram); !found || deleted {
+		t.Errorf("unauthorized redaction should not delete the message")
+	}
+	if found, deleted := redactMessage(buffer, "a", fromShivaram); !found || !deleted {
+		t.Errorf("authorized redaction should delete the message")
+	}
+
+	remaining := buffer.Latest(0)
+	if len(remaining) != 1 || remaining[0].Message.Msgid != "b" {
+		t.Errorf("unexpected remaining items: %v", remaining)
+	}
+}
+
+func TestRedactDirectMessage(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.History.Enabled = true
+		config.History.ChannelLength, config.History.ClientLength = 64, 64
+	})
+	defer h.Close()
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	carol := h.Register("carol")
+	bob.Send("PRIVMSG", "alice", "hi")
+	alice.Expect("PRIVMSG")
+	items := h.server.clients.Get("alice").history.Latest(0)
+	if len(items) != 1 {
+		t.Fatalf("expected the message in alice's history, got %v", items)
+	}
+	msgid := items[0].Message.Msgid
+
+	// someone else's message in alice's history looks the same as a missing one
+	for _, id := range []string{msgid, "nonexistent"} {
+		carol.Send("REDACT", "alice", id)
+		if msg := carol.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "UNKNOWN_MSGID" {
+			t.Errorf("unexpected reply for %s: %v", id, msg)
+		}
+	}
+
+	bob.Send("REDACT", "alice", msgid)
+	bob.Sync()
+	if items := h.server.clients.Get("alice").history.Latest(0); len(items) != 0 {
+		t.Errorf("the sender should be able to redact the message: %v", items)
+	}
+}
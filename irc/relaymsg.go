@@ -0,0 +1,119 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/caps"
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// RELAYMSG lets bridge bots (e.g., to Matrix or Discord) relay messages into a channel
+// under the nicknames of the remote users, like "alice/discord". Relay nicknames must
+// contain one of the configured separators, which are forbidden in real nicknames, so
+// relayed messages can't be confused with messages from real clients.
+
+const (
+	// tag identifying the client that relayed a message
+	relaymsgTagName = "draft/relaymsg"
+)
+
+var (
+	errRelayNickNoSeparator = errors.New("Relay nickname must contain a separator")
+	errRelayNickInvalid     = errors.New("Invalid relay nickname")
+)
+
+// RelaymsgConfig controls the RELAYMSG command.
+type RelaymsgConfig struct {
+	Enabled bool
+	// characters that separate the remote user's nick from the remote network's name
+	Separators string
+	// whether channel operators can relay messages into their channels; otherwise,
+	// only opers with the relaymsg capability can relay
+	AvailableToChanops bool `yaml:"available-to-chanops"`
+}
+
+func (conf *RelaymsgConfig) prepare() error {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Separators == "" {
+		conf.Separators = "/"
+	}
+	if strings.ContainsAny(conf.Separators, " ,*?.!@:#~&%+") {
+		return fmt.Errorf("Invalid relaymsg separators: %s", conf.Separators)
+	}
+	return nil
+}
+
+// isForbiddenNick returns whether a nickname is reserved for relaying.
+func (conf *RelaymsgConfig) isForbiddenNick(nick string) bool {
+	return conf.Enabled && strings.ContainsAny(nick, conf.Separators)
+}
+
+// validateRelayNick checks a relay nickname: it must contain a separator, and
+// every component between the separators must be a valid nickname in its own right.
+func (conf *RelaymsgConfig) validateRelayNick(nick string, maxLen int) error {
+	if !strings.ContainsAny(nick, conf.Separators) {
+		return errRelayNickNoSeparator
+	}
+	if maxLen < len(nick) {
+		return errRelayNickInvalid
+	}
+	components := strings.FieldsFunc(nick, func(r rune) bool {
+		return strings.ContainsRune(conf.Separators, r)
+	})
+	if len(components) < 2 {
+		return errRelayNickInvalid
+	}
+	for _, component := range components {
+		if _, err := CasefoldName(component); err != nil {
+			return errRelayNickInvalid
+		}
+	}
+	return nil
+}
+
+// canRelay returns whether the client may relay messages into the channel.
+func (conf *RelaymsgConfig) canRelay(client *Client, channel *Channel) bool {
+	if client.HasRoleCapabs("relaymsg") {
+		return true
+	}
+	return conf.AvailableToChanops && channel.ClientIsAtLeast(client, modes.ChannelOperator)
+}
+
+// SendRelayMessage sends a message relayed by `relayer` to the channel, from `nick`.
+func (channel *Channel) SendRelayMessage(relayer *Client, nick string, message utils.SplitMessage, rb *ResponseBuffer) {
+	relayerNick := relayer.Nick()
+	now := time.Now().UTC()
+
+	for _, member := range channel.Members() {
+		if member == relayer && !member.capabilities.Has(caps.EchoMessage) {
+			continue
+		}
+		var tags map[string]string
+		if member.capabilities.Has(caps.MessageTags) {
+			tags = map[string]string{relaymsgTagName: relayerNick}
+		}
+		if member == relayer {
+			rb.AddSplitMessageFromClient(nick, "*", tags, "PRIVMSG", channel.name, message)
+		} else {
+			member.sendSplitMsgFromClientInternal(false, now, nick, "*", tags, "PRIVMSG", channel.name, message)
+		}
+	}
+
+	channel.history.Add(history.Item{
+		Type:        history.Privmsg,
+		Message:     message,
+		Nick:        nick,
+		AccountName: "*",
+		Time:        now,
+	})
+}
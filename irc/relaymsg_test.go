@@ -0,0 +1,50 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestRelayNicks(t *testing.T) {
+	conf := RelaymsgConfig{Enabled: true}
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []string{"alice/discord", "bob/matrix/org", "ed/x"}
+	for _, nick := range valid {
+		if err := conf.validateRelayNick(nick, 32); err != nil {
+			t.Errorf("valid relay nick %s was rejected: %v", nick, err)
+		}
+	}
+
+	invalid := map[string]error{
+		"alice":                                  errRelayNickNoSeparator,
+		"alice/":                                 errRelayNickInvalid,
+		"/discord":                               errRelayNickInvalid,
+		"al ice/discord":                         errRelayNickInvalid,
+		"alice!/discord":                         errRelayNickInvalid,
+		"alice/#discord":                         errRelayNickInvalid,
+		"alice/discord0123456789012345678901234": errRelayNickInvalid,
+	}
+	for nick, expected := range invalid {
+		if err := conf.validateRelayNick(nick, 32); err != expected {
+			t.Errorf("invalid relay nick %s: expected %v, got %v", nick, expected, err)
+		}
+	}
+
+	if !conf.isForbiddenNick("alice/discord") || conf.isForbiddenNick("alice") {
+		t.Errorf("incorrect nick restriction")
+	}
+	conf.Enabled = false
+	if conf.isForbiddenNick("alice/discord") {
+		t.Errorf("nicks shouldn't be restricted when relaymsg is disabled")
+	}
+
+	conf = RelaymsgConfig{Enabled: true, Separators: "/@"}
+	if conf.prepare() == nil {
+		t.Errorf("invalid separator was accepted")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/history"
+	"github.com/oragono/oragono/irc/utils"
+)
+
+// REPORT lets a user forward an abusive message to the staff, as the server
+// stored it (sender, account, time, and msgid), rather than as a screenshot
+// that could have been doctored. Only messages that were delivered to the
+// reporter can be reported, so opers never see a private conversation unless
+// one of its participants chooses to show it to them. Reports go to the opers
+// with the "reports" capability, either all of them, or only those in the
+// configured staff channel, and are logged for auditing.
+
+const (
+	defaultMaxReports   = 5
+	defaultReportWindow = time.Hour
+	// reports are truncated to this length, to leave room for the metadata
+	maxReportReasonLen = 300
+)
+
+// ReportsConfig controls the REPORT command.
+type ReportsConfig struct {
+	Enabled bool
+	// if set, reports go only to the opers with the "reports" capability who
+	// are in this channel
+	Channel string
+	// each client can send at most this many reports per window
+	MaxReports int `yaml:"max-reports"`
+	Window     time.Duration
+
+	channelCasefolded string
+}
+
+func (conf *ReportsConfig) prepare() (err error) {
+	if conf.Channel != "" {
+		conf.channelCasefolded, err = CasefoldChannel(conf.Channel)
+		if err != nil {
+			return fmt.Errorf("invalid reports channel: %s", conf.Channel)
+		}
+	}
+	if conf.MaxReports == 0 {
+		conf.MaxReports = defaultMaxReports
+	}
+	if conf.Window == 0 {
+		conf.Window = defaultReportWindow
+	}
+	return nil
+}
+
+// findReportableMessage looks up a message delivered to the client: in the
+// channel's history for a channel target, otherwise in the client's own
+// history of direct messages.
+func (server *Server) findReportableMessage(client *Client, target, msgid string) (item history.Item, found bool) {
+	var buffer *history.Buffer
+	if channel := server.channels.Get(target); channel != nil {
+		if !channel.hasClient(client) {
+			return
+		}
+		buffer = &channel.history
+	} else {
+		buffer = client.history
+	}
+	items := buffer.Match(func(item history.Item) bool {
+		return item.HasMsgid(msgid)
+	}, false, 1)
+	if len(items) == 0 {
+		return
+	}
+	return items[0], true
+}
+
+// reportRecipients returns the opers who receive reports.
+func (server *Server) reportRecipients(config *ReportsConfig) (recipients []*Client) {
+	var candidates []*Client
+	if config.channelCasefolded != "" {
+		if channel := server.channels.Get(config.channelCasefolded); channel != nil {
+			candidates = channel.Members()
+		}
+	} else {
+		candidates = server.clients.AllClients()
+	}
+	for _, candidate := range candidates {
+		if candidate.HasRoleCapabs("reports") {
+			recipients = append(recipients, candidate)
+		}
+	}
+	return
+}
+
+// touchReport records a report by the client, returning whether it's within
+// the rate limit.
+func (client *Client) touchReport(config *ReportsConfig) bool {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	client.reportThrottle.Duration, client.reportThrottle.Limit = config.Window, config.MaxReports
+	throttled, _ := client.reportThrottle.Touch()
+	return !throttled
+}
+
+// reportMessage forwards a message delivered to the client to the staff.
+func (server *Server) reportMessage(client *Client, target, msgid, reason string, rb *ResponseBuffer) {
+	config := &server.Config().Server.Reports
+	if !config.Enabled {
+		rb.Add(nil, server.name, "FAIL", "REPORT", "DISABLED", client.t("Reports are disabled on this server"))
+		return
+	}
+	item, found := server.findReportableMessage(client, target, msgid)
+	if !found {
+		rb.Add(nil, server.name, "FAIL", "REPORT", "UNKNOWN_MSGID", target, msgid, client.t("This message does not exist, is too old, or wasn't sent to you"))
+		return
+	}
+	if !client.touchReport(config) {
+		rb.Add(nil, server.name, "FAIL", "REPORT", "RATE_LIMITED", target, msgid, client.t("You are sending reports too quickly"))
+		return
+	}
+	if maxReportReasonLen < len(reason) {
+		reason = reason[:maxReportReasonLen]
+	}
+
+	where := "a direct message"
+	if channel := server.channels.Get(target); channel != nil {
+		where = channel.Name()
+	}
+	reporter := client.NickMaskString()
+	if account := client.AccountName(); account != "*" {
+		reporter = fmt.Sprintf("%s (account %s)", reporter, account)
+	}
+	sender := item.Nick
+	if item.AccountName != "" && item.AccountName != "*" {
+		sender = fmt.Sprintf("%s (account %s)", sender, item.AccountName)
+	}
+	// the message may have been split; report it all on one line
+	message := strings.Replace(item.Message.Message, "\n", " ", -1)
+	lines := []string{
+		fmt.Sprintf("Report from %s about a message in %s:", reporter, where),
+		fmt.Sprintf("<%s> %s", sender, message),
+		fmt.Sprintf("Sent %s, msgid %s", item.Time.UTC().Format(IRCv3TimestampFormat), msgid),
+	}
+	if reason != "" {
+		lines = append(lines, fmt.Sprintf("Reason: %s", reason))
+	}
+
+	server.logger.Info("audit", fmt.Sprintf("Report from %s about message %s from %s in %s: %s", reporter, msgid, sender, where, reason))
+	messages := make([]utils.SplitMessage, len(lines))
+	for i, line := range lines {
+		messages[i] = utils.MakeSplitMessage(line)
+	}
+	for _, recipient := range server.reportRecipients(config) {
+		for _, message := range messages {
+			recipient.SendSplitMsgFromServer(server.name, "NOTICE", recipient.Nick(), message)
+		}
+	}
+	rb.Notice(client.t("Your report was forwarded to the staff"))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.History.Enabled = true
+		config.History.ChannelLength, config.History.ClientLength = 64, 64
+		config.Server.Reports.MaxReports = 1
+	})
+	defer h.Close()
+
+	staff := h.Register("staff")
+	alice := h.Register("alice")
+	spammer := h.Register("spammer")
+	sStaff := h.server.clients.Get("staff")
+	oper := h.server.Config().operators["dan"]
+	sStaff.stateMutex.Lock()
+	sStaff.oper = oper
+	sStaff.stateMutex.Unlock()
+
+	spammer.Send("PRIVMSG", "alice", "buy my stuff")
+	alice.Expect("PRIVMSG")
+	// the message is stored after it's sent; wait for the spammer's next command
+	spammer.Send("PING", "sync")
+	spammer.Expect("PONG")
+	// the client didn't negotiate message-tags, so look up the msgid
+	items := h.server.clients.Get("alice").history.Latest(1)
+	if len(items) != 1 {
+		t.Fatalf("message wasn't stored")
+	}
+	msgid := items[0].Message.Msgid
+
+	// messages that weren't sent to the reporter can't be reported
+	spammer.Send("REPORT", "alice", msgid)
+	if msg := spammer.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "UNKNOWN_MSGID" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+
+	alice.Send("REPORT", "spammer", msgid, "spam")
+	alice.Expect("NOTICE")
+	if msg := staff.Expect("NOTICE"); !strings.Contains(msg.Params[1], "Report from alice!") {
+		t.Errorf("unexpected report: %v", msg)
+	}
+	if msg := staff.Expect("NOTICE"); !strings.Contains(msg.Params[1], "buy my stuff") {
+		t.Errorf("report should contain the message: %v", msg)
+	}
+
+	alice.Send("REPORT", "spammer", msgid, "spam")
+	if msg := alice.Expect("FAIL"); len(msg.Params) < 2 || msg.Params[1] != "RATE_LIMITED" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+}
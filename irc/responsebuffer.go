@@ -5,7 +5,6 @@ package irc
 
 import (
 	"runtime/debug"
-	"time"
 
 	"github.com/goshuirc/irc-go/ircmsg"
 	"github.com/oragono/oragono/irc/caps"
@@ -79,15 +78,22 @@ func (rb *ResponseBuffer) AddFromClient(msgid string, fromNickMask string, fromA
 
 // AddSplitMessageFromClient adds a new split message from a specific client to our queue.
 func (rb *ResponseBuffer) AddSplitMessageFromClient(fromNickMask string, fromAccount string, tags map[string]string, command string, target string, message utils.SplitMessage) {
-	if rb.target.capabilities.Has(caps.MaxLine) || message.Wrapped == nil {
+	wrapped := rb.target.wrapSplitMessage(fromNickMask, command, target, &message)
+	if wrapped == nil {
 		rb.AddFromClient(message.Msgid, fromNickMask, fromAccount, tags, command, target, message.Message)
 	} else {
-		for _, messagePair := range message.Wrapped {
+		for _, messagePair := range wrapped {
 			rb.AddFromClient(messagePair.Msgid, fromNickMask, fromAccount, tags, command, target, messagePair.Message)
 		}
 	}
 }
 
+// AddSplitMessageFromServer adds a PRIVMSG or NOTICE from the server or a service
+// (e.g., NickServ) to our queue.
+func (rb *ResponseBuffer) AddSplitMessageFromServer(source, command, target string, message utils.SplitMessage) {
+	rb.AddSplitMessageFromClient(source, "*", nil, command, target, message)
+}
+
 // InitializeBatch forcibly starts a batch of batch `batchType`.
 // Normally, Send/Flush will decide automatically whether to start a batch
 // of type draft/labeled-response. This allows changing the batch type
@@ -162,9 +168,7 @@ func (rb *ResponseBuffer) flushInternal(final bool, blocking bool) error {
 	// send each message out
 	for _, message := range rb.messages {
 		// attach server-time if needed
-		if rb.target.capabilities.Has(caps.ServerTime) && !message.HasTag("time") {
-			message.SetTag("time", time.Now().UTC().Format(IRCv3TimestampFormat))
-		}
+		rb.target.attachServerTime(&message)
 
 		// attach batch ID
 		if rb.batchID != "" {
@@ -189,5 +193,5 @@ func (rb *ResponseBuffer) flushInternal(final bool, blocking bool) error {
 
 // Notice sends the client the given notice from the server.
 func (rb *ResponseBuffer) Notice(text string) {
-	rb.Add(nil, rb.target.server.name, "NOTICE", rb.target.nick, text)
+	rb.AddSplitMessageFromServer(rb.target.server.name, "NOTICE", rb.target.Nick(), utils.MakeSplitMessage(text))
 }
@@ -0,0 +1,67 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/goshuirc/irc-go/ircmsg"
+	"github.com/oragono/oragono/irc/caps"
+)
+
+func TestLabeledResponse(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	label := func(value string) map[string]string {
+		return map[string]string{caps.LabelTagName: value}
+	}
+	expectLabel := func(msg ircmsg.IrcMessage, expected string) {
+		t.Helper()
+		if _, value := msg.GetTag(caps.LabelTagName); value != expected {
+			t.Errorf("expected label %q: %v", expected, msg)
+		}
+	}
+
+	alice := h.Connect()
+	alice.nick = "alice"
+	alice.Send("CAP", "REQ", "batch draft/labeled-response")
+	alice.Expect("CAP")
+	// errors from before registration are labeled
+	alice.SendWithTags(label("prereg"), "PRIVMSG", "bob", "hi")
+	expectLabel(alice.Expect(ERR_NOTREGISTERED), "prereg")
+	alice.Send("NICK", "alice")
+	alice.Send("USER", "u", "0", "*", "simulated client")
+	alice.Send("CAP", "END")
+	alice.Expect(RPL_WELCOME)
+	alice.Sync()
+
+	// so are errors for unknown commands, and for commands without enough parameters
+	alice.SendWithTags(label("unknown"), "FROBNICATE")
+	expectLabel(alice.Expect(ERR_UNKNOWNCOMMAND), "unknown")
+	alice.SendWithTags(label("params"), "JOIN")
+	expectLabel(alice.Expect(ERR_NEEDMOREPARAMS), "params")
+
+	// several replies are sent in a labeled batch
+	alice.SendWithTags(label("whois"), "WHOIS", "alice")
+	start := alice.Expect("BATCH")
+	expectLabel(start, "whois")
+	if len(start.Params) != 2 || start.Params[0][0] != '+' || start.Params[1] != "draft/labeled-response" {
+		t.Fatalf("unexpected batch start: %v", start)
+	}
+	batchID := start.Params[0][1:]
+	reply := alice.Expect(RPL_ENDOFWHOIS)
+	if _, batch := reply.GetTag("batch"); batch != batchID {
+		t.Errorf("reply isn't in the batch: %v", reply)
+	}
+	expectLabel(reply, "")
+	if end := alice.Expect("BATCH"); len(end.Params) != 1 || end.Params[0] != "-"+batchID {
+		t.Errorf("unexpected batch end: %v", end)
+	}
+
+	// clients without the capability don't get labels
+	bob := h.Register("bob")
+	bob.SendWithTags(label("unknown"), "FROBNICATE")
+	expectLabel(bob.Expect(ERR_UNKNOWNCOMMAND), "")
+}
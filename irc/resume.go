@@ -4,13 +4,24 @@
 package irc
 
 import (
-	"sync"
+	"fmt"
+	"time"
 
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/modes"
 	"github.com/oragono/oragono/irc/utils"
 )
 
 // implements draft/resume-0.3, in particular the issuing, management, and verification
-// of resume tokens with two components: a unique ID and a secret key
+// of resume tokens with two components: a unique ID and a secret key.
+// in addition, clients can announce a planned disconnection with BRB: the server
+// keeps their session (and their presence in channels) alive for a while, so that
+// they can resume it later without their peers seeing them quit.
+
+const (
+	defaultBrbTimeout = 5 * time.Minute
+	defaultBrbReason  = "Be right back"
+)
 
 type resumeTokenPair struct {
 	client *Client
@@ -18,7 +29,7 @@ type resumeTokenPair struct {
 }
 
 type ResumeManager struct {
-	sync.RWMutex // level 2
+	lockorder.Tier2RWMutex
 
 	resumeIDtoCreds map[string]resumeTokenPair
 	server          *Server
@@ -55,36 +66,117 @@ func (rm *ResumeManager) GenerateToken(client *Client) (token string) {
 // nil if there is no such client or the token is invalid. If successful,
 // the token is consumed and cannot be used to resume again.
 func (rm *ResumeManager) VerifyToken(token string) (client *Client) {
-	if len(token) != 2*utils.SecretTokenLength {
-		return
+	rm.Lock()
+	defer rm.Unlock()
+
+	client, id := rm.lookupToken(token)
+	if client != nil {
+		// consume the token, ensuring that at most one resume can succeed
+		delete(rm.resumeIDtoCreds, id)
 	}
+	return
+}
 
+// LookupToken is like VerifyToken, but doesn't consume the token. Before
+// resuming the client, the caller must consume the token with Delete, which
+// fails if a concurrent resume (or the BRB timeout) consumed it first.
+func (rm *ResumeManager) LookupToken(token string) (client *Client) {
 	rm.RLock()
 	defer rm.RUnlock()
 
-	id := token[:utils.SecretTokenLength]
+	client, _ = rm.lookupToken(token)
+	return
+}
+
+func (rm *ResumeManager) lookupToken(token string) (client *Client, id string) {
+	if len(token) != 2*utils.SecretTokenLength {
+		return
+	}
+
+	id = token[:utils.SecretTokenLength]
 	pair, ok := rm.resumeIDtoCreds[id]
 	if ok {
 		if utils.SecretTokensMatch(pair.secret, token[utils.SecretTokenLength:]) {
 			// disallow resume of an unregistered client; this prevents the use of
 			// resume as an auth bypass
 			if pair.client.Registered() {
-				// consume the token, ensuring that at most one resume can succeed
-				delete(rm.resumeIDtoCreds, id)
-				return pair.client
+				return pair.client, id
 			}
 		}
 	}
 	return
 }
 
-// Delete stops tracking a client's resume token.
-func (rm *ResumeManager) Delete(client *Client) {
+// Delete stops tracking a client's resume token. It returns false if the client
+// didn't have a token, or if it was already consumed by a resume.
+func (rm *ResumeManager) Delete(client *Client) (deleted bool) {
 	rm.Lock()
 	defer rm.Unlock()
 
 	currentID := client.ResumeID()
 	if currentID != "" {
+		_, deleted = rm.resumeIDtoCreds[currentID]
 		delete(rm.resumeIDtoCreds, currentID)
 	}
+	return
+}
+
+// RotateToken replaces a client's resume token (if any) with a new one,
+// which it returns.
+func (rm *ResumeManager) RotateToken(client *Client) (token string) {
+	rm.Delete(client)
+	client.SetResumeID("")
+	return rm.GenerateToken(client)
+}
+
+// brb puts the client into the BRB state: its connection will be closed, but
+// its session is kept until `timeout` elapses, so that it can be resumed.
+// Meanwhile, the client is marked away (unless it already was).
+func (client *Client) brb(reason string, timeout time.Duration) {
+	client.stateMutex.Lock()
+	client.brbTime = time.Now()
+	client.brbReason = reason
+	client.brbTimer = timerWheel.AfterFunc(timeout, client.brbTimeout)
+	setAway := !client.flags.HasMode(modes.Away)
+	client.brbAwaySet = setAway
+	client.stateMutex.Unlock()
+
+	// the BRB timer takes over from the idle timer
+	client.idletimer.Stop()
+	if setAway {
+		client.changeAway(true, reason)
+	}
+}
+
+// isBrb returns whether the client announced a planned disconnection with BRB.
+func (client *Client) isBrb() bool {
+	client.stateMutex.RLock()
+	defer client.stateMutex.RUnlock()
+	return !client.brbTime.IsZero()
+}
+
+// brbTimeout is run when a client that sent BRB didn't resume in time.
+func (client *Client) brbTimeout() {
+	// if the token was already consumed, a resume is in progress, and it
+	// will destroy this client
+	if !client.server.resumeManager.Delete(client) {
+		return
+	}
+	client.stateMutex.RLock()
+	reason := client.brbReason
+	client.stateMutex.RUnlock()
+	client.Quit(fmt.Sprintf("BRB timed out: %s", reason))
+	client.destroy(false)
+}
+
+// stopBrb cancels the BRB timer, if any, returning when the client sent BRB
+// and whether it was marked away as a result.
+func (client *Client) stopBrb() (brbTime time.Time, awaySet bool) {
+	client.stateMutex.Lock()
+	defer client.stateMutex.Unlock()
+	if client.brbTimer != nil {
+		client.brbTimer.Stop()
+		client.brbTimer = nil
+	}
+	return client.brbTime, client.brbAwaySet
 }
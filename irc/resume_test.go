@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/caps"
+)
+
+func TestResumeTokenRotation(t *testing.T) {
+	var rm ResumeManager
+	rm.Initialize(nil)
+	client := &Client{registered: true}
+
+	token := rm.GenerateToken(client)
+	if token == "" || rm.GenerateToken(client) != "" {
+		t.Fatalf("client should get exactly one token")
+	}
+
+	newToken := rm.RotateToken(client)
+	if newToken == "" || newToken == token {
+		t.Fatalf("token wasn't rotated")
+	}
+	if rm.VerifyToken(token) != nil {
+		t.Errorf("old token is still valid after rotation")
+	}
+	if rm.VerifyToken(newToken) != client {
+		t.Errorf("new token isn't valid")
+	}
+	// tokens are single-use
+	if rm.VerifyToken(newToken) != nil {
+		t.Errorf("token was accepted twice")
+	}
+	if rm.Delete(client) {
+		t.Errorf("consumed token shouldn't be deletable")
+	}
+
+	other := &Client{registered: true}
+	rm.GenerateToken(other)
+	if !rm.Delete(other) {
+		t.Errorf("unused token should be deletable")
+	}
+}
+
+func TestFailedResumeAfterBrb(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Server.AllowPlaintextResume = false
+		config.Server.BrbTimeout = time.Second
+	})
+	defer h.Close()
+
+	alice := h.Connect()
+	alice.nick = "alice"
+	alice.Send("CAP", "REQ", caps.Resume.Name())
+	alice.Expect("CAP")
+	alice.Send("NICK", "alice")
+	alice.Send("USER", "u", "0", "*", "simulated client")
+	alice.Send("CAP", "END")
+	token := alice.Expect("RESUME").Params[1]
+	alice.Expect(RPL_WELCOME)
+	alice.Send("BRB")
+	alice.Expect("BRB")
+
+	// the simulated clients don't use TLS, so the resume is refused
+	bob := h.Connect()
+	bob.nick = "bob"
+	bob.Send("CAP", "REQ", caps.Resume.Name())
+	bob.Expect("CAP")
+	// bob's own token
+	bob.Expect("RESUME")
+	bob.Send("RESUME", token)
+	bob.Send("NICK", "bob")
+	bob.Send("USER", "u", "0", "*", "simulated client")
+	bob.Send("CAP", "END")
+	if msg := bob.Expect("RESUME"); msg.Params[0] != "ERR" {
+		t.Errorf("resume should have failed: %v", msg)
+	}
+	bob.Expect(RPL_WELCOME)
+
+	// the failed resume shouldn't keep the BRB'd session alive forever
+	if h.server.clients.Get("alice") == nil {
+		t.Fatalf("BRB'd session should be kept until the timeout")
+	}
+	deadline := time.Now().Add(harnessTimeout)
+	for h.server.clients.Get("alice") != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("BRB'd session wasn't destroyed after a failed resume")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
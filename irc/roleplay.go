@@ -8,6 +8,7 @@ import (
 
 	"github.com/oragono/oragono/irc/caps"
 	"github.com/oragono/oragono/irc/modes"
+	"github.com/oragono/oragono/irc/utils"
 )
 
 const (
@@ -45,14 +46,15 @@ func sendRoleplayMessage(server *Server, client *Client, source string, targetSt
 			return
 		}
 
+		splitMessage := utils.MakeSplitMessage(message)
 		for _, member := range channel.Members() {
 			if member == client && !client.capabilities.Has(caps.EchoMessage) {
 				continue
 			}
 			if member == client {
-				rb.Add(nil, source, "PRIVMSG", channel.name, message)
+				rb.AddSplitMessageFromServer(source, "PRIVMSG", channel.name, splitMessage)
 			} else {
-				member.Send(nil, source, "PRIVMSG", channel.name, message)
+				member.SendSplitMsgFromServer(source, "PRIVMSG", channel.name, splitMessage)
 			}
 		}
 	} else {
@@ -70,9 +72,10 @@ func sendRoleplayMessage(server *Server, client *Client, source string, targetSt
 
 		cnick := client.Nick()
 		tnick := user.Nick()
-		user.Send(nil, source, "PRIVMSG", tnick, message)
+		splitMessage := utils.MakeSplitMessage(message)
+		user.SendSplitMsgFromServer(source, "PRIVMSG", tnick, splitMessage)
 		if client.capabilities.Has(caps.EchoMessage) {
-			rb.Add(nil, source, "PRIVMSG", tnick, message)
+			rb.AddSplitMessageFromServer(source, "PRIVMSG", tnick, splitMessage)
 		}
 		if user.HasMode(modes.Away) {
 			//TODO(dan): possibly implement cooldown of away notifications to users
@@ -6,7 +6,6 @@
 package irc
 
 import (
-	"bufio"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -16,7 +15,6 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -24,6 +22,7 @@ import (
 	"github.com/oragono/oragono/irc/caps"
 	"github.com/oragono/oragono/irc/connection_limits"
 	"github.com/oragono/oragono/irc/isupport"
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/logger"
 	"github.com/oragono/oragono/irc/modes"
 	"github.com/oragono/oragono/irc/sno"
@@ -42,7 +41,7 @@ var (
 
 	// SupportedCapabilities are the caps we advertise.
 	// MaxLine, SASL and STS are set during server startup.
-	SupportedCapabilities = caps.NewSet(caps.AccountTag, caps.AccountNotify, caps.AwayNotify, caps.Batch, caps.CapNotify, caps.ChgHost, caps.EchoMessage, caps.ExtendedJoin, caps.InviteNotify, caps.LabeledResponse, caps.Languages, caps.MessageTags, caps.MultiPrefix, caps.Rename, caps.Resume, caps.ServerTime, caps.SetName, caps.UserhostInNames)
+	SupportedCapabilities = caps.NewSet(caps.AccountTag, caps.AccountNotify, caps.AwayNotify, caps.Batch, caps.CapNotify, caps.ChgHost, caps.EchoMessage, caps.ExtendedJoin, caps.InviteNotify, caps.LabeledResponse, caps.Languages, caps.MessageRedaction, caps.MessageTags, caps.MultiPrefix, caps.ReadMarker, caps.Rename, caps.Resume, caps.ServerTime, caps.SetName, caps.UserhostInNames)
 
 	// CapValues are the actual values we advertise to v3.2 clients.
 	// actual values are set during server startup.
@@ -51,44 +50,63 @@ var (
 
 // ListenerWrapper wraps a listener so it can be safely reconfigured or stopped
 type ListenerWrapper struct {
-	listener   net.Listener
-	tlsConfig  *tls.Config
-	isTor      bool
-	shouldStop bool
+	listener     net.Listener
+	tlsConfig    *tls.Config
+	isTor        bool
+	isCompressed bool
+	checkIdent   bool
+	requireSasl  bool
+	multiplex    *httpMultiplexConfig
+	shouldStop   bool
 	// protects atomic update of tlsConfig and shouldStop:
-	configMutex sync.Mutex // tier 1
+	configMutex lockorder.Tier1Mutex
 }
 
 // Server is the main Oragono server.
 type Server struct {
 	accounts               *AccountManager
+	announcing             uint32 // accessed atomically; whether an announcement is being delivered
+	blocklists             BlocklistManager
 	channels               *ChannelManager
+	compressedConns        int32 // accessed atomically
 	channelRegistry        *ChannelRegistry
 	clients                *ClientManager
+	clock                  utils.Clock // immutable after startup; nil for the default
+	commandStats           CommandStats
 	config                 *Config
 	configFilename         string
-	configurableStateMutex sync.RWMutex // tier 1; generic protection for server state modified by rehash()
+	configurableStateMutex lockorder.Tier1RWMutex // generic protection for server state modified by rehash()
 	connectionLimiter      *connection_limits.Limiter
 	connectionThrottler    *connection_limits.Throttler
 	ctime                  time.Time
+	defcon                 DefconManager
 	dlines                 *DLineManager
 	helpIndexManager       HelpIndexManager
+	hostnames              *HostnameResolver
 	isupport               *isupport.List
 	klines                 *KLineManager
 	listeners              map[string]*ListenerWrapper
 	logger                 *logger.Manager
 	monitorManager         *MonitorManager
 	motdLines              []string
+	rulesLines             []string
 	name                   string
 	nameCasefolded         string
-	rehashMutex            sync.Mutex // tier 4
+	rehashMutex            lockorder.Tier4Mutex
 	rehashSignal           chan os.Signal
+	shutdown               ShutdownManager
+	plugins                PluginManager
 	pprofServer            *http.Server
+	apiServer              *http.Server
+	apiTLS                 *TLSListenConfig
+	httpMultiplexer        *httpMultiplexer
 	resumeManager          ResumeManager
 	signals                chan os.Signal
 	snomasks               *SnoManager
 	store                  *buntdb.DB
+	onionService           *onionService
 	torLimiter             connection_limits.TorLimiter
+	transfers              TransferManager
 	whoWas                 *WhoWasList
 	stats                  *Stats
 	semaphores             *ServerSemaphores
@@ -104,9 +122,15 @@ var (
 )
 
 type clientConn struct {
-	Conn  net.Conn
-	IsTLS bool
-	IsTor bool
+	Conn        net.Conn
+	IsTLS       bool
+	IsTor       bool
+	CheckIdent  bool
+	RequireSasl bool
+	// the TLS handshake fails unless the client presents a certificate
+	RequireClientCert bool
+	// if non-nil, the connection may be HTTP instead of IRC
+	Multiplex *httpMultiplexConfig
 }
 
 // NewServer returns a new Oragono server.
@@ -115,6 +139,8 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 	server := &Server{
 		channels:            NewChannelManager(),
 		clients:             NewClientManager(),
+		commandStats:        NewCommandStats(),
+		hostnames:           NewHostnameResolver(),
 		connectionLimiter:   connection_limits.NewLimiter(),
 		connectionThrottler: connection_limits.NewThrottler(),
 		listeners:           make(map[string]*ListenerWrapper),
@@ -128,7 +154,10 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 		semaphores:          NewServerSemaphores(),
 	}
 
+	server.httpMultiplexer = newHTTPMultiplexer(server)
 	server.resumeManager.Initialize(server)
+	server.defcon.Initialize(server)
+	server.shutdown.Initialize(server)
 
 	if err := server.applyConfig(config, true); err != nil {
 		return nil, err
@@ -150,30 +179,45 @@ func (server *Server) setISupport() (err error) {
 	// add RPL_ISUPPORT tokens
 	isupport := isupport.NewList()
 	isupport.Add("AWAYLEN", strconv.Itoa(config.Limits.AwayLen))
+	isupport.Add("BOT", string(modes.Bot))
+	isupport.Add("CALLERID", string(modes.CallerID))
 	isupport.Add("CASEMAPPING", "ascii")
-	isupport.Add("CHANMODES", strings.Join([]string{modes.Modes{modes.BanMask, modes.ExceptMask, modes.InviteMask}.String(), "", modes.Modes{modes.UserLimit, modes.Key}.String(), modes.Modes{modes.InviteOnly, modes.Moderated, modes.NoOutside, modes.OpOnlyTopic, modes.ChanRoleplaying, modes.Secret}.String()}, ","))
+	isupport.Add("CHANMODES", strings.Join([]string{modes.Modes{modes.BanMask, modes.ExceptMask, modes.InviteMask}.String(), "", modes.Modes{modes.UserLimit, modes.Key}.String(), modes.Modes{modes.InviteOnly, modes.Moderated, modes.NoKnock, modes.NoOutside, modes.OpOnlyTopic, modes.ChanRoleplaying, modes.RegisteredOnly, modes.Secret, modes.SecureOnly}.String()}, ","))
 	if config.History.Enabled && config.History.ChathistoryMax > 0 {
 		isupport.Add("draft/CHATHISTORY", strconv.Itoa(config.History.ChathistoryMax))
 	}
 	isupport.Add("CHANNELLEN", strconv.Itoa(config.Limits.ChannelLen))
 	isupport.Add("CHANTYPES", "#")
-	isupport.Add("ELIST", "U")
+	isupport.Add("ELIST", "CLMTU")
 	isupport.Add("EXCEPTS", "")
 	isupport.Add("INVEX", "")
 	isupport.Add("KICKLEN", strconv.Itoa(config.Limits.KickLen))
+	if config.Channels.Knock.Enabled {
+		isupport.Add("KNOCK", "")
+	}
+	if config.Limits.LineLen.Rest != 512 {
+		// clients must negotiate the maxline capability to use longer lines
+		isupport.Add("LINELEN", strconv.Itoa(config.Limits.LineLen.Rest))
+	}
 	isupport.Add("MAXLIST", fmt.Sprintf("beI:%s", strconv.Itoa(config.Limits.ChanListModes)))
 	isupport.Add("MAXTARGETS", maxTargetsString)
 	isupport.Add("MODES", "")
 	isupport.Add("MONITOR", strconv.Itoa(config.Limits.MonitorEntries))
 	isupport.Add("NETWORK", config.Network.Name)
 	isupport.Add("NICKLEN", strconv.Itoa(config.Limits.NickLen))
-	isupport.Add("PREFIX", "(qaohv)~&@%+")
+	statusModes, statusPrefixes := config.statusModePrefixes()
+	isupport.Add("PREFIX", fmt.Sprintf("(%s)%s", statusModes, statusPrefixes))
 	isupport.Add("RPCHAN", "E")
 	isupport.Add("RPUSER", "E")
-	isupport.Add("STATUSMSG", "~&@%+")
+	isupport.Add("SAFELIST", "")
+	isupport.Add("STATUSMSG", statusPrefixes)
 	isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:1,WHOIS:1,USERHOST:10,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:", maxTargetsString, maxTargetsString, maxTargetsString))
 	isupport.Add("TOPICLEN", strconv.Itoa(config.Limits.TopicLen))
+	if config.Server.UTF8Only.Enabled {
+		isupport.Add("UTF8ONLY", "")
+	}
 	isupport.Add("UTF8MAPPING", casemappingName)
+	isupport.Add("WHOX", "")
 
 	// account registration
 	if config.Accounts.Registration.Enabled {
@@ -208,13 +252,27 @@ func loadChannelList(channel *Channel, list string, maskMode modes.Mode) {
 	channel.lists[maskMode].AddAll(strings.Split(list, " "))
 }
 
-// Shutdown shuts down the server.
+// Shutdown shuts down the server, disconnecting all clients.
 func (server *Server) Shutdown() {
-	//TODO(dan): Make sure we disallow new nicks
+	reason := server.shutdown.Reason()
 	for _, client := range server.clients.AllClients() {
-		client.Notice("Server is shutting down")
+		client.Quit(reason)
+		client.socket.Close()
 	}
 
+	// persist the current state of registered channels, including any
+	// writes that were still pending in the background
+	for _, channel := range server.channels.Channels() {
+		if channel.IsRegistered() {
+			server.channelRegistry.StoreChannel(channel, IncludeAllChannelAttrs)
+		}
+	}
+
+	if server.onionService != nil {
+		server.onionService.stop()
+	}
+	server.httpMultiplexer.stop()
+
 	if err := server.store.Close(); err != nil {
 		server.logger.Error("shutdown", fmt.Sprintln("Could not close datastore:", err))
 	}
@@ -227,7 +285,18 @@ func (server *Server) Run() {
 
 	for {
 		select {
-		case <-server.signals:
+		case sig := <-server.signals:
+			// SIGTERM starts a graceful shutdown; anything else (including a
+			// second SIGTERM) shuts down immediately
+			if sig == syscall.SIGTERM {
+				if server.shutdown.Start(server.Config().Server.Shutdown.DrainPeriod, "") == nil {
+					continue
+				}
+			}
+			server.Shutdown()
+			return
+
+		case <-server.shutdown.done:
 			server.Shutdown()
 			return
 
@@ -247,7 +316,10 @@ func (server *Server) acceptClient(conn clientConn) {
 	var isBanned bool
 	var banMsg string
 	var ipaddr net.IP
-	if conn.IsTor {
+	if server.shutdown.InProgress() {
+		// we stopped listening, but this connection was already accepted
+		isBanned, banMsg = true, server.shutdown.Reason()
+	} else if conn.IsTor {
 		ipaddr = utils.IPv4LoopbackAddress
 		isBanned, banMsg = server.checkTorLimits()
 	} else {
@@ -262,6 +334,12 @@ func (server *Server) acceptClient(conn clientConn) {
 		return
 	}
 
+	// HTTP connections on a multiplexed listener are subject to the same bans
+	// and limits as IRC connections
+	if conn.Multiplex != nil && server.routeHTTP(conn, ipaddr) {
+		return
+	}
+
 	server.logger.Info("localconnect-ip", fmt.Sprintf("Client connecting from %v", ipaddr))
 
 	go RunNewClient(server, conn)
@@ -322,8 +400,20 @@ func (server *Server) checkTorLimits() (banned bool, message string) {
 // IRC protocol listeners
 //
 
+// compressConn wraps a newly accepted connection from a compressed listener.
+func (server *Server) compressConn(conn net.Conn) (net.Conn, error) {
+	compConfig := server.Config().Server.CompressedListeners
+	cconn, err := newCompressedConn(conn, &compConfig, &server.compressedConns)
+	if err != nil {
+		server.logger.Warning("localconnect-ip", "rejecting compressed connection", err.Error())
+		conn.Close()
+		return nil, err
+	}
+	return cconn, nil
+}
+
 // createListener starts a given listener.
-func (server *Server) createListener(addr string, tlsConfig *tls.Config, isTor bool, bindMode os.FileMode) (*ListenerWrapper, error) {
+func (server *Server) createListener(addr string, tlsConfig *tls.Config, isTor bool, isCompressed bool, checkIdent bool, requireSasl bool, multiplex *httpMultiplexConfig, bindMode os.FileMode) (*ListenerWrapper, error) {
 	// make listener
 	var listener net.Listener
 	var err error
@@ -344,10 +434,14 @@ func (server *Server) createListener(addr string, tlsConfig *tls.Config, isTor b
 
 	// throw our details to the server so we can be modified/killed later
 	wrapper := ListenerWrapper{
-		listener:   listener,
-		tlsConfig:  tlsConfig,
-		isTor:      isTor,
-		shouldStop: false,
+		listener:     listener,
+		tlsConfig:    tlsConfig,
+		isTor:        isTor,
+		isCompressed: isCompressed,
+		checkIdent:   checkIdent,
+		requireSasl:  requireSasl,
+		multiplex:    multiplex,
+		shouldStop:   false,
 	}
 
 	var shouldStop bool
@@ -362,16 +456,31 @@ func (server *Server) createListener(addr string, tlsConfig *tls.Config, isTor b
 			shouldStop = wrapper.shouldStop
 			tlsConfig = wrapper.tlsConfig
 			isTor = wrapper.isTor
+			isCompressed = wrapper.isCompressed
+			checkIdent = wrapper.checkIdent
+			requireSasl = wrapper.requireSasl
+			multiplex = wrapper.multiplex
 			wrapper.configMutex.Unlock()
 
 			if err == nil {
 				if tlsConfig != nil {
 					conn = tls.Server(conn, tlsConfig)
 				}
+				// compression happens inside TLS, if any
+				if isCompressed {
+					conn, err = server.compressConn(conn)
+				}
+			}
+
+			if err == nil {
 				newConn := clientConn{
-					Conn:  conn,
-					IsTLS: tlsConfig != nil,
-					IsTor: isTor,
+					Conn:              conn,
+					IsTLS:             tlsConfig != nil,
+					IsTor:             isTor,
+					CheckIdent:        checkIdent,
+					RequireSasl:       requireSasl,
+					RequireClientCert: requiresClientCert(tlsConfig),
+					Multiplex:         multiplex,
 				}
 				// hand off the connection
 				go server.acceptClient(newConn)
@@ -399,16 +508,35 @@ func (server *Server) tryRegister(c *Client) {
 			return
 		}
 		resumed = true
+		c.SetIPInfo(server.Config().Server.GeoIP.Lookup(c.IP()))
 	} else {
 		if c.preregNick == "" || !c.HasUsername() || c.capState == caps.NegotiatingState {
 			return
 		}
+		c.waitForIdent()
+
+		config := server.Config()
+		c.SetIPInfo(config.Server.GeoIP.Lookup(c.IP()))
+		if config.Server.GeoIP.IsDenied(c.IPInfo()) {
+			c.Quit(c.t("You are not allowed to connect from your location"))
+			c.destroy(false)
+			return
+		}
 
 		// client MUST send PASS if necessary, or authenticate with SASL if necessary,
 		// before completing the other registration commands
-		config := server.Config()
-		if !c.isAuthorized(config) {
-			c.Quit(c.t("Bad password"))
+		if err := c.isAuthorized(config); err != nil {
+			if err == errSaslRequired {
+				c.Send(nil, server.name, "FAIL", "*", "ACCOUNT_REQUIRED", c.t(err.Error()))
+			}
+			c.Quit(c.t(err.Error()))
+			c.destroy(false)
+			return
+		}
+
+		// connect rules may reject the client, or require SASL, among other things
+		if rejection := server.applyConnectRules(c, config); rejection != "" {
+			c.Quit(rejection)
 			c.destroy(false)
 			return
 		}
@@ -428,21 +556,38 @@ func (server *Server) tryRegister(c *Client) {
 			c.destroy(false)
 			return
 		}
+
+		// check plugins
+		verdict := server.plugins.Dispatch(newPluginEvent(c, pluginEventConnect))
+		if verdict.Verdict == pluginVerdictBlock {
+			reason := verdict.Reason
+			if reason == "" {
+				reason = c.t("Your connection was rejected")
+			}
+			c.Quit(reason)
+			c.destroy(false)
+			return
+		}
 	}
 
 	// registration has succeeded:
 	c.SetRegistered()
 
-	// count new user in statistics
-	server.stats.ChangeTotal(1)
-
 	if !resumed {
 		server.monitorManager.AlertAbout(c, true)
 	}
 
 	// continue registration
-	server.logger.Info("localconnect", fmt.Sprintf("Client connected [%s] [u:%s] [r:%s]", c.nick, c.username, c.realname))
-	server.snomasks.Send(sno.LocalConnects, fmt.Sprintf("Client connected [%s] [u:%s] [h:%s] [ip:%s] [r:%s]", c.nick, c.username, c.rawHostname, c.IPString(), c.realname))
+	geo := c.IPInfo().String()
+	if geo != "" {
+		geo = fmt.Sprintf(" [geo:%s]", geo)
+	}
+	class := c.ConnectClass()
+	if class != "" {
+		class = fmt.Sprintf(" [class:%s]", class)
+	}
+	server.logger.Info("localconnect", fmt.Sprintf("Client connected [%s] [u:%s] [r:%s]%s%s", c.nick, c.username, c.realname, geo, class))
+	server.snomasks.Send(sno.LocalConnects, fmt.Sprintf("Client connected [%s] [u:%s] [h:%s] [ip:%s] [r:%s]%s%s", c.nick, c.username, c.rawHostname, c.IPString(), c.realname, geo, class))
 
 	// send welcome text
 	//NOTE(dan): we specifically use the NICK here instead of the nickmask
@@ -468,6 +613,9 @@ func (server *Server) tryRegister(c *Client) {
 
 	if resumed {
 		c.tryResumeChannels()
+	} else {
+		server.attachPersonaOnConnect(c)
+		server.autoJoinOnConnect(c)
 	}
 }
 
@@ -489,13 +637,46 @@ func (server *Server) MOTD(client *Client, rb *ResponseBuffer) {
 		return
 	}
 
+	substitutions := server.motdSubstitutions(client)
 	rb.Add(nil, server.name, RPL_MOTDSTART, client.nick, fmt.Sprintf(client.t("- %s Message of the day - "), server.name))
 	for _, line := range motdLines {
-		rb.Add(nil, server.name, RPL_MOTD, client.nick, line)
+		rb.Add(nil, server.name, RPL_MOTD, client.nick, substitutions.Replace(line))
 	}
 	rb.Add(nil, server.name, RPL_ENDOFMOTD, client.nick, client.t("End of MOTD command"))
 }
 
+// Rules serves the server's rules, or the entry message of a channel if one is given.
+func (server *Server) Rules(client *Client, channel *Channel, rb *ResponseBuffer) {
+	var name string
+	var rulesLines []string
+	if channel == nil {
+		name = server.name
+		server.configurableStateMutex.RLock()
+		serverRules := server.rulesLines
+		server.configurableStateMutex.RUnlock()
+		substitutions := server.motdSubstitutions(client)
+		for _, line := range serverRules {
+			rulesLines = append(rulesLines, substitutions.Replace(line))
+		}
+	} else {
+		name = channel.Name()
+		for _, line := range utils.WordWrap(channel.EntryMsg(), client.noticeLineWidth()) {
+			rulesLines = append(rulesLines, fmt.Sprintf("- %s", line))
+		}
+	}
+
+	if len(rulesLines) < 1 {
+		rb.Add(nil, server.name, ERR_NORULES, client.nick, client.t("RULES File is missing"))
+		return
+	}
+
+	rb.Add(nil, server.name, RPL_RULESTART, client.nick, fmt.Sprintf(client.t("- %s Rules -"), name))
+	for _, line := range rulesLines {
+		rb.Add(nil, server.name, RPL_RULES, client.nick, line)
+	}
+	rb.Add(nil, server.name, RPL_ENDOFRULES, client.nick, client.t("End of RULES command"))
+}
+
 // WhoisChannelsNames returns the common channel names between two users.
 func (client *Client) WhoisChannelsNames(target *Client) []string {
 	isMultiPrefix := client.capabilities.Has(caps.MultiPrefix)
@@ -529,6 +710,15 @@ func (client *Client) getWhoisOf(target *Client, rb *ResponseBuffer) {
 	if client.HasMode(modes.Operator) || client == target {
 		rb.Add(nil, client.server.name, RPL_WHOISACTUALLY, cnick, tnick, fmt.Sprintf("%s@%s", targetInfo.username, target.RawHostname()), target.IPString(), client.t("Actual user@host, Actual IP"))
 	}
+	if client.HasMode(modes.Operator) {
+		if ipInfo := target.IPInfo(); ipInfo.String() != "" {
+			location := ipInfo.Country
+			if ipInfo.ASN != 0 {
+				location = strings.TrimSpace(fmt.Sprintf("%s AS%d %s", location, ipInfo.ASN, ipInfo.ASOrganization))
+			}
+			rb.Add(nil, client.server.name, RPL_WHOISCOUNTRY, cnick, tnick, ipInfo.Country, fmt.Sprintf(client.t("is connecting from %s"), location))
+		}
+	}
 	if target.HasMode(modes.TLS) {
 		rb.Add(nil, client.server.name, RPL_WHOISSECURE, cnick, tnick, client.t("is using a secure connection"))
 	}
@@ -555,10 +745,39 @@ func (client *Client) getWhoisOf(target *Client, rb *ResponseBuffer) {
 	rb.Add(nil, client.server.name, RPL_WHOISIDLE, cnick, tnick, strconv.FormatUint(target.IdleSeconds(), 10), strconv.FormatInt(target.SignonTime(), 10), client.t("seconds idle, signon time"))
 }
 
+// whoxQuery is a parsed WHOX request, i.e., the `%fields[,token]` parameter of WHO.
+type whoxQuery struct {
+	fields string
+	token  string
+}
+
+// whoxFieldOrder is the order in which WHOX fields must appear in RPL_WHOSPCRPL,
+// regardless of the order in which they were requested.
+const whoxFieldOrder = "tcuihsnfdlaor"
+
+// parseWhoxQuery parses the second parameter of WHO; it returns nil if this is not a WHOX query.
+func parseWhoxQuery(param string) *whoxQuery {
+	if !strings.HasPrefix(param, "%") {
+		return nil
+	}
+	var result whoxQuery
+	result.fields = param[1:]
+	if commaIndex := strings.IndexByte(result.fields, ','); commaIndex != -1 {
+		result.token = result.fields[commaIndex+1:]
+		result.fields = result.fields[:commaIndex]
+	}
+	// the query type token must be 1-3 digits
+	if _, err := strconv.ParseUint(result.token, 10, 16); err != nil || 3 < len(result.token) {
+		result.token = "0"
+	}
+	return &result
+}
+
 // rplWhoReply returns the WHO reply between one user and another channel/user.
 // <channel> <user> <host> <server> <nick> ( "H" / "G" ) ["*"] [ ( "@" / "+" ) ]
 // :<hopcount> <real name>
-func (target *Client) rplWhoReply(channel *Channel, client *Client, rb *ResponseBuffer) {
+// if whox is non-nil, RPL_WHOSPCRPL is sent with the requested fields instead.
+func (target *Client) rplWhoReply(channel *Channel, client *Client, whox *whoxQuery, rb *ResponseBuffer) {
 	channelName := "*"
 	flags := ""
 
@@ -570,18 +789,75 @@ func (target *Client) rplWhoReply(channel *Channel, client *Client, rb *Response
 	if client.HasMode(modes.Operator) {
 		flags += "*"
 	}
+	if client.HasMode(modes.Bot) {
+		flags += "B"
+	}
 
 	if channel != nil {
 		flags += channel.ClientPrefixes(client, target.capabilities.Has(caps.MultiPrefix))
 		channelName = channel.name
 	}
-	rb.Add(nil, target.server.name, RPL_WHOREPLY, target.nick, channelName, client.Username(), client.Hostname(), client.server.name, client.Nick(), flags, strconv.Itoa(client.hops)+" "+client.Realname())
+
+	if whox == nil {
+		rb.Add(nil, target.server.name, RPL_WHOREPLY, target.nick, channelName, client.Username(), client.Hostname(), client.server.name, client.Nick(), flags, strconv.Itoa(client.hops)+" "+client.Realname())
+		return
+	}
+
+	hasPrivs := target == client || target.HasMode(modes.Operator)
+	params := []string{target.nick}
+	for _, field := range whoxFieldOrder {
+		if !strings.ContainsRune(whox.fields, field) {
+			continue
+		}
+		switch field {
+		case 't':
+			params = append(params, whox.token)
+		case 'c':
+			params = append(params, channelName)
+		case 'u':
+			params = append(params, client.Username())
+		case 'i':
+			if hasPrivs {
+				params = append(params, client.IPString())
+			} else {
+				params = append(params, "255.255.255.255")
+			}
+		case 'h':
+			params = append(params, client.Hostname())
+		case 's':
+			params = append(params, client.server.name)
+		case 'n':
+			params = append(params, client.Nick())
+		case 'f':
+			params = append(params, flags)
+		case 'd':
+			params = append(params, strconv.Itoa(client.hops))
+		case 'l':
+			if hasPrivs {
+				params = append(params, strconv.FormatUint(client.IdleSeconds(), 10))
+			} else {
+				params = append(params, "0")
+			}
+		case 'a':
+			account := client.AccountName()
+			if account == "*" {
+				account = "0"
+			}
+			params = append(params, account)
+		case 'o':
+			// we don't implement ircu-style channel oplevels
+			params = append(params, "n/a")
+		case 'r':
+			params = append(params, client.Realname())
+		}
+	}
+	rb.Add(nil, target.server.name, RPL_WHOSPCRPL, params...)
 }
 
-func whoChannel(client *Client, channel *Channel, friends ClientSet, rb *ResponseBuffer) {
-	for _, member := range channel.Members() {
-		if !client.HasMode(modes.Invisible) || friends[client] {
-			client.rplWhoReply(channel, member, rb)
+func whoChannel(client *Client, channel *Channel, friends ClientSet, whox *whoxQuery, rb *ResponseBuffer) {
+	for _, member := range channel.members.SortedMembers() {
+		if !member.client.HasMode(modes.Invisible) || friends[member.client] {
+			client.rplWhoReply(channel, member.client, whox, rb)
 		}
 	}
 }
@@ -596,6 +872,10 @@ func (server *Server) rehash() error {
 
 	server.logger.Debug("server", "Got rehash lock")
 
+	if server.shutdown.InProgress() {
+		return errShuttingDown
+	}
+
 	config, err := LoadConfig(server.configFilename)
 	if err != nil {
 		return fmt.Errorf("Error loading config file config: %s", err.Error())
@@ -674,17 +954,70 @@ func (server *Server) applyConfig(config *Config, initial bool) (err error) {
 
 	// SASL
 	authPreviouslyEnabled := oldConfig != nil && oldConfig.Accounts.AuthenticationEnabled
+	saslValue := strings.Join(config.Accounts.SaslMechanisms(), ",")
+	currentSaslValue, _ := CapValues.Get(caps.SASL)
 	if config.Accounts.AuthenticationEnabled && !authPreviouslyEnabled {
 		// enabling SASL
 		SupportedCapabilities.Enable(caps.SASL)
-		CapValues.Set(caps.SASL, "PLAIN,EXTERNAL")
+		CapValues.Set(caps.SASL, saslValue)
 		addedCaps.Add(caps.SASL)
+	} else if config.Accounts.AuthenticationEnabled && saslValue != currentSaslValue {
+		CapValues.Set(caps.SASL, saslValue)
+		updatedCaps.Add(caps.SASL)
 	} else if !config.Accounts.AuthenticationEnabled && authPreviouslyEnabled {
 		// disabling SASL
 		SupportedCapabilities.Disable(caps.SASL)
 		removedCaps.Add(caps.SASL)
 	}
 
+	// account registration
+	accRegPreviouslyEnabled := oldConfig != nil && oldConfig.Accounts.Registration.Enabled
+	accRegValue := config.Accounts.RegistrationCapValue()
+	currentAccRegValue, _ := CapValues.Get(caps.AccountRegistration)
+	if config.Accounts.Registration.Enabled && !accRegPreviouslyEnabled {
+		SupportedCapabilities.Enable(caps.AccountRegistration)
+		CapValues.Set(caps.AccountRegistration, accRegValue)
+		addedCaps.Add(caps.AccountRegistration)
+	} else if !config.Accounts.Registration.Enabled && accRegPreviouslyEnabled {
+		SupportedCapabilities.Disable(caps.AccountRegistration)
+		removedCaps.Add(caps.AccountRegistration)
+	} else if config.Accounts.Registration.Enabled && accRegValue != currentAccRegValue {
+		CapValues.Set(caps.AccountRegistration, accRegValue)
+		updatedCaps.Add(caps.AccountRegistration)
+	}
+
+	// relaymsg
+	relaymsgPreviouslyEnabled := oldConfig != nil && oldConfig.Server.Relaymsg.Enabled
+	relaymsgValue := config.Server.Relaymsg.Separators
+	currentRelaymsgValue, _ := CapValues.Get(caps.Relaymsg)
+	if config.Server.Relaymsg.Enabled && !relaymsgPreviouslyEnabled {
+		SupportedCapabilities.Enable(caps.Relaymsg)
+		CapValues.Set(caps.Relaymsg, relaymsgValue)
+		addedCaps.Add(caps.Relaymsg)
+	} else if !config.Server.Relaymsg.Enabled && relaymsgPreviouslyEnabled {
+		SupportedCapabilities.Disable(caps.Relaymsg)
+		removedCaps.Add(caps.Relaymsg)
+	} else if config.Server.Relaymsg.Enabled && relaymsgValue != currentRelaymsgValue {
+		CapValues.Set(caps.Relaymsg, relaymsgValue)
+		updatedCaps.Add(caps.Relaymsg)
+	}
+
+	// metadata
+	metadataPreviouslyEnabled := oldConfig != nil && oldConfig.Server.Metadata.Enabled
+	metadataValue := config.Server.Metadata.CapValue()
+	currentMetadataValue, _ := CapValues.Get(caps.Metadata)
+	if config.Server.Metadata.Enabled && !metadataPreviouslyEnabled {
+		SupportedCapabilities.Enable(caps.Metadata)
+		CapValues.Set(caps.Metadata, metadataValue)
+		addedCaps.Add(caps.Metadata)
+	} else if !config.Server.Metadata.Enabled && metadataPreviouslyEnabled {
+		SupportedCapabilities.Disable(caps.Metadata)
+		removedCaps.Add(caps.Metadata)
+	} else if config.Server.Metadata.Enabled && metadataValue != currentMetadataValue {
+		CapValues.Set(caps.Metadata, metadataValue)
+		updatedCaps.Add(caps.Metadata)
+	}
+
 	nickReservationPreviouslyDisabled := oldConfig != nil && !oldConfig.Accounts.NickReservation.Enabled
 	nickReservationNowEnabled := config.Accounts.NickReservation.Enabled
 	if nickReservationPreviouslyDisabled && nickReservationNowEnabled {
@@ -740,6 +1073,9 @@ func (server *Server) applyConfig(config *Config, initial bool) (err error) {
 		}
 	}
 
+	server.whoWas.SetMaxPerNick(config.Limits.WhowasEntriesPerNick)
+	server.plugins.Configure(config.Plugins, server.logger)
+
 	// burst new and removed caps
 	var capBurstClients ClientSet
 	added := make(map[caps.Version]string)
@@ -748,6 +1084,14 @@ func (server *Server) applyConfig(config *Config, initial bool) (err error) {
 	// updated caps get DEL'd and then NEW'd
 	// so, we can just add updated ones to both removed and added lists here and they'll be correctly handled
 	server.logger.Debug("server", "Updated Caps", updatedCaps.String(caps.Cap301, CapValues))
+	// clients can't keep using caps that are no longer supported
+	disabledCaps := caps.NewSet()
+	disabledCaps.Union(removedCaps)
+	if !disabledCaps.Empty() {
+		for _, sClient := range server.clients.AllClients() {
+			sClient.capabilities.Subtract(disabledCaps)
+		}
+	}
 	addedCaps.Union(updatedCaps)
 	removedCaps.Union(updatedCaps)
 
@@ -782,6 +1126,7 @@ func (server *Server) applyConfig(config *Config, initial bool) (err error) {
 	}
 
 	server.loadMOTD(config.Server.MOTD, config.Server.MOTDFormatting)
+	server.loadRules(config.Server.Rules, config.Server.MOTDFormatting)
 
 	// save a pointer to the new config
 	server.configurableStateMutex.Lock()
@@ -796,6 +1141,7 @@ func (server *Server) applyConfig(config *Config, initial bool) (err error) {
 	}
 
 	server.setupPprofListener(config)
+	server.setupAPIListener(config)
 
 	// set RPL_ISUPPORT
 	var newISupportReplies [][]string
@@ -810,12 +1156,16 @@ func (server *Server) applyConfig(config *Config, initial bool) (err error) {
 
 	// we are now open for business
 	err = server.setupListeners(config)
+	server.setupOnionService(config)
 
 	if !initial {
 		// push new info to all of our clients
 		for _, sClient := range server.clients.AllClients() {
-			for _, tokenline := range newISupportReplies {
-				sClient.Send(nil, server.name, RPL_ISUPPORT, append([]string{sClient.nick}, tokenline...)...)
+			// unregistered clients get the new tokens when they complete registration
+			if sClient.Registered() {
+				for _, tokenline := range newISupportReplies {
+					sClient.Send(nil, server.name, RPL_ISUPPORT, append([]string{sClient.nick}, tokenline...)...)
+				}
 			}
 
 			if sendRawOutputNotice {
@@ -862,37 +1212,26 @@ func (server *Server) setupPprofListener(config *Config) {
 
 func (server *Server) loadMOTD(motdPath string, useFormatting bool) error {
 	server.logger.Info("server", "Using MOTD", motdPath)
-	motdLines := make([]string, 0)
-	if motdPath != "" {
-		file, err := os.Open(motdPath)
-		if err == nil {
-			defer file.Close()
-
-			reader := bufio.NewReader(file)
-			for {
-				line, err := reader.ReadString('\n')
-				if err != nil {
-					break
-				}
-				line = strings.TrimRight(line, "\r\n")
-
-				if useFormatting {
-					line = ircfmt.Unescape(line)
-				}
+	motdLines, err := readMOTDFile(motdPath, useFormatting)
+	if err != nil {
+		return err
+	}
 
-				// "- " is the required prefix for MOTD, we just add it here to make
-				// bursting it out to clients easier
-				line = fmt.Sprintf("- %s", line)
+	server.configurableStateMutex.Lock()
+	server.motdLines = motdLines
+	server.configurableStateMutex.Unlock()
+	return nil
+}
 
-				motdLines = append(motdLines, line)
-			}
-		} else {
-			return err
-		}
+func (server *Server) loadRules(rulesPath string, useFormatting bool) error {
+	server.logger.Info("server", "Using rules", rulesPath)
+	rulesLines, err := readMOTDFile(rulesPath, useFormatting)
+	if err != nil {
+		return err
 	}
 
 	server.configurableStateMutex.Lock()
-	server.motdLines = motdLines
+	server.rulesLines = rulesLines
 	server.configurableStateMutex.Unlock()
 	return nil
 }
@@ -926,6 +1265,8 @@ func (server *Server) loadDatastore(config *Config) error {
 
 	server.accounts = NewAccountManager(server)
 
+	server.blocklists.Initialize(server)
+
 	return nil
 }
 
@@ -951,6 +1292,31 @@ func (server *Server) setupListeners(config *Config) (err error) {
 		return false
 	}
 
+	isSaslListener := func(listener string) bool {
+		for _, saslListener := range config.Server.SaslListeners.Listeners {
+			if listener == saslListener {
+				return true
+			}
+		}
+		return false
+	}
+
+	multiplexConfig := func(listener string) *httpMultiplexConfig {
+		if tlsConf, ok := config.Server.TLSListeners[listener]; ok {
+			return tlsConf.multiplexConfig()
+		}
+		return nil
+	}
+
+	isCompressedListener := func(listener string) bool {
+		for _, compressedListener := range config.Server.CompressedListeners.Listeners {
+			if listener == compressedListener {
+				return true
+			}
+		}
+		return false
+	}
+
 	// update or destroy all existing listeners
 	for addr := range server.listeners {
 		currentListener := server.listeners[addr]
@@ -972,6 +1338,10 @@ func (server *Server) setupListeners(config *Config) (err error) {
 		currentListener.shouldStop = !stillConfigured
 		currentListener.tlsConfig = tlsConfig
 		currentListener.isTor = isTor
+		currentListener.isCompressed = isCompressedListener(addr)
+		currentListener.checkIdent = config.Server.Ident.enabledForListener(addr)
+		currentListener.requireSasl = isSaslListener(addr)
+		currentListener.multiplex = multiplexConfig(addr)
 		currentListener.configMutex.Unlock()
 
 		if stillConfigured {
@@ -991,7 +1361,7 @@ func (server *Server) setupListeners(config *Config) (err error) {
 			// make new listener
 			isTor := isTorListener(newaddr)
 			tlsConfig := tlsListeners[newaddr]
-			listener, listenerErr := server.createListener(newaddr, tlsConfig, isTor, config.Server.UnixBindMode)
+			listener, listenerErr := server.createListener(newaddr, tlsConfig, isTor, isCompressedListener(newaddr), config.Server.Ident.enabledForListener(newaddr), isSaslListener(newaddr), multiplexConfig(newaddr), config.Server.UnixBindMode)
 			if listenerErr != nil {
 				server.logger.Error("server", "couldn't listen on", newaddr, listenerErr.Error())
 				err = listenerErr
@@ -1020,24 +1390,141 @@ func (server *Server) setupListeners(config *Config) (err error) {
 	return
 }
 
+const (
+	// number of RPL_LIST lines sent between flushes of a full LIST
+	listChunkSize = 100
+)
+
 // elistMatcher takes and matches ELIST conditions
 type elistMatcher struct {
 	MinClientsActive bool
 	MinClients       int
 	MaxClientsActive bool
 	MaxClients       int
+	// zero values mean that the corresponding condition is inactive:
+	CreatedBefore time.Time
+	CreatedAfter  time.Time
+	TopicBefore   time.Time
+	TopicAfter    time.Time
+	Masks         []*utils.Glob
+	// channels must have all of these tags:
+	Tags []string
+}
+
+// AddCondition parses a single ELIST condition (U: `<5`, `>5`;
+// C: `C<60`, `C>60`; T: `T<60`, `T>60`; M: `*mask*`; L: `L=tag`) and adds it
+// to the matcher, returning false if it wasn't a valid condition.
+func (matcher *elistMatcher) AddCondition(cond string) bool {
+	if len(cond) < 2 {
+		return false
+	}
+
+	if (cond[0] == 'L' || cond[0] == 'l') && cond[1] == '=' {
+		tag := strings.ToLower(cond[2:])
+		if !isValidChannelTag(tag) {
+			return false
+		}
+		matcher.Tags = append(matcher.Tags, tag)
+		return true
+	}
+
+	if strings.ContainsAny(cond, "*?") {
+		cfmask, err := Casefold(cond)
+		if err != nil {
+			cfmask = strings.ToLower(cond)
+		}
+		matcher.Masks = append(matcher.Masks, utils.NewGlob(cfmask))
+		return true
+	}
+
+	var kind byte
+	switch cond[0] {
+	case 'C', 'c', 'T', 't':
+		kind = cond[0] &^ 0x20 // uppercase
+		cond = cond[1:]
+	}
+	if len(cond) < 2 || (cond[0] != '<' && cond[0] != '>') {
+		return false
+	}
+	val, err := strconv.Atoi(cond[1:])
+	if err != nil || val < 0 {
+		return false
+	}
+	lessThan := cond[0] == '<'
+
+	switch kind {
+	case 0:
+		if lessThan {
+			matcher.MaxClientsActive = true
+			matcher.MaxClients = val - 1 // -1 because < means less than the given number
+		} else {
+			matcher.MinClientsActive = true
+			matcher.MinClients = val + 1 // +1 because > means more than the given number
+		}
+	case 'C', 'T':
+		// times are given in minutes ago, so "less than" means "after"
+		cutoff := time.Now().Add(-time.Duration(val) * time.Minute)
+		if kind == 'C' && lessThan {
+			matcher.CreatedAfter = cutoff
+		} else if kind == 'C' {
+			matcher.CreatedBefore = cutoff
+		} else if lessThan {
+			matcher.TopicAfter = cutoff
+		} else {
+			matcher.TopicBefore = cutoff
+		}
+	}
+	return true
 }
 
 // Matches checks whether the given channel matches our matches.
 func (matcher *elistMatcher) Matches(channel *Channel) bool {
-	if matcher.MinClientsActive {
-		if len(channel.Members()) < matcher.MinClients {
+	memberCount := len(channel.Members())
+	if matcher.MinClientsActive && memberCount < matcher.MinClients {
+		return false
+	}
+	if matcher.MaxClientsActive && matcher.MaxClients < memberCount {
+		return false
+	}
+
+	if !matcher.CreatedBefore.IsZero() || !matcher.CreatedAfter.IsZero() {
+		createdTime := channel.CreatedTime()
+		if !matcher.CreatedBefore.IsZero() && !createdTime.Before(matcher.CreatedBefore) {
+			return false
+		}
+		if !matcher.CreatedAfter.IsZero() && !createdTime.After(matcher.CreatedAfter) {
+			return false
+		}
+	}
+
+	if !matcher.TopicBefore.IsZero() || !matcher.TopicAfter.IsZero() {
+		// channels without a topic never match a topic condition
+		topic, topicSetTime := channel.TopicInfo()
+		if topic == "" {
+			return false
+		}
+		if !matcher.TopicBefore.IsZero() && !topicSetTime.Before(matcher.TopicBefore) {
+			return false
+		}
+		if !matcher.TopicAfter.IsZero() && !topicSetTime.After(matcher.TopicAfter) {
 			return false
 		}
 	}
 
-	if matcher.MaxClientsActive {
-		if len(channel.Members()) < len(channel.members) {
+	if len(matcher.Tags) != 0 && !channel.HasTags(matcher.Tags) {
+		return false
+	}
+
+	if len(matcher.Masks) != 0 {
+		name := channel.NameCasefolded()
+		matched := false
+		for _, mask := range matcher.Masks {
+			if mask.MatchString(name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
 			return false
 		}
 	}
@@ -1059,7 +1546,12 @@ func (target *Client) RplList(channel *Channel, rb *ResponseBuffer) {
 		}
 	}
 
-	rb.Add(nil, target.server.name, RPL_LIST, target.nick, channel.name, strconv.Itoa(memberCount), channel.topic)
+	topic, _ := channel.TopicInfo()
+	verification := channel.Verification()
+	if domain := verification.VerifiedDomain(); domain != "" {
+		topic = fmt.Sprintf("[verified: %s] %s", domain, topic)
+	}
+	rb.Add(nil, target.server.name, RPL_LIST, target.nick, channel.Name(), strconv.Itoa(memberCount), topic)
 }
 
 var (
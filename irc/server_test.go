@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestParseWhoxQuery(t *testing.T) {
+	if parseWhoxQuery("o") != nil {
+		t.Errorf("non-WHOX parameter was parsed as WHOX")
+	}
+
+	whox := parseWhoxQuery("%cuhnfa")
+	if whox == nil || whox.fields != "cuhnfa" || whox.token != "0" {
+		t.Errorf("incorrect WHOX parse: %v", whox)
+	}
+
+	whox = parseWhoxQuery("%tna,42")
+	if whox == nil || whox.fields != "tna" || whox.token != "42" {
+		t.Errorf("incorrect WHOX parse: %v", whox)
+	}
+
+	whox = parseWhoxQuery("%tna,abcd")
+	if whox == nil || whox.fields != "tna" || whox.token != "0" {
+		t.Errorf("invalid token should be replaced: %v", whox)
+	}
+}
+
+func TestElistConditions(t *testing.T) {
+	var matcher elistMatcher
+	for _, cond := range []string{">5", "<10", "C<60", "t>30", "#*irc*", "L=en"} {
+		if !matcher.AddCondition(cond) {
+			t.Errorf("condition %s should be valid", cond)
+		}
+	}
+	for _, cond := range []string{"", "x", ">", "C=5", "<abc", "T<-1", "L=", "L=#en"} {
+		if matcher.AddCondition(cond) {
+			t.Errorf("condition %s should be invalid", cond)
+		}
+	}
+
+	if !(matcher.MinClientsActive && matcher.MinClients == 6) {
+		t.Errorf("bad min clients: %v", matcher)
+	}
+	if !(matcher.MaxClientsActive && matcher.MaxClients == 9) {
+		t.Errorf("bad max clients: %v", matcher)
+	}
+	if matcher.CreatedAfter.IsZero() || !matcher.CreatedBefore.IsZero() {
+		t.Errorf("bad creation time conditions: %v", matcher)
+	}
+	if matcher.TopicBefore.IsZero() || !matcher.TopicAfter.IsZero() {
+		t.Errorf("bad topic time conditions: %v", matcher)
+	}
+	if len(matcher.Tags) != 1 || matcher.Tags[0] != "en" {
+		t.Errorf("bad tags: %v", matcher)
+	}
+	if len(matcher.Masks) != 1 || !matcher.Masks[0].MatchString("#oragono-irc") {
+		t.Errorf("bad masks: %v", matcher)
+	}
+}
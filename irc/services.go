@@ -77,6 +77,13 @@ var OragonoServices = map[string]*ircService{
 		Commands:       hostservCommands,
 		HelpBanner:     hostservHelp,
 	},
+	"histserv": {
+		Name:           "HistServ",
+		ShortName:      "HISTSERV",
+		CommandAliases: []string{"HISTSERV"},
+		Commands:       histservCommands,
+		HelpBanner:     histservHelp,
+	},
 }
 
 // all service commands at the protocol level, by uppercase command name
@@ -139,7 +146,7 @@ func servicePrivmsgHandler(service *ircService, server *Server, client *Client,
 func serviceRunCommand(service *ircService, server *Server, client *Client, cmd *serviceCommand, commandName string, params []string, rb *ResponseBuffer) {
 	nick := rb.target.Nick()
 	sendNotice := func(notice string) {
-		rb.Add(nil, service.Name, "NOTICE", nick, notice)
+		rb.AddSplitMessageFromServer(service.Name, "NOTICE", nick, utils.MakeSplitMessage(notice))
 	}
 
 	if cmd == nil {
@@ -167,6 +174,11 @@ func serviceRunCommand(service *ircService, server *Server, client *Client, cmd
 		return
 	}
 
+	if commandName != "help" && !client.apiTokenAllows(apiTokenCapServices) {
+		sendNotice(client.t(errAPITokenRestricted.Error()))
+		return
+	}
+
 	server.logger.Debug("services", fmt.Sprintf("Client %s ran %s command %s", client.Nick(), service.Name, commandName))
 	if commandName == "help" {
 		serviceHelpHandler(service, server, client, params, rb)
@@ -180,7 +192,7 @@ func serviceHelpHandler(service *ircService, server *Server, client *Client, par
 	nick := rb.target.Nick()
 	config := server.Config()
 	sendNotice := func(notice string) {
-		rb.Add(nil, service.Name, "NOTICE", nick, notice)
+		rb.AddSplitMessageFromServer(service.Name, "NOTICE", nick, utils.MakeSplitMessage(notice))
 	}
 
 	sendNotice(ircfmt.Unescape(fmt.Sprintf("*** $b%s HELP$b ***", service.Name)))
@@ -0,0 +1,167 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/sno"
+)
+
+// A graceful shutdown (SIGTERM, or DIE with a delay) stops accepting new
+// connections, then counts down while warning connected users, so that they
+// can reconnect elsewhere; only then are the remaining clients disconnected
+// and the datastore closed.
+
+const (
+	defaultShutdownMessage = "Server is shutting down"
+)
+
+var (
+	errShuttingDown       = errors.New("Server is shutting down")
+	errShutdownInProgress = errors.New("Server is already shutting down")
+
+	// users are warned when the shutdown starts, and again when these
+	// amounts of time are left
+	shutdownWarnings = []time.Duration{
+		30 * time.Minute,
+		10 * time.Minute,
+		5 * time.Minute,
+		time.Minute,
+		30 * time.Second,
+		10 * time.Second,
+	}
+)
+
+// ShutdownConfig controls graceful shutdowns.
+type ShutdownConfig struct {
+	// how long to wait between the start of a shutdown (on SIGTERM) and
+	// disconnecting the remaining clients
+	DrainPeriod time.Duration `yaml:"drain-period"`
+	// the default message shown to users (DIE can override it)
+	Message string
+}
+
+func (conf *ShutdownConfig) prepare() error {
+	if conf.DrainPeriod < 0 {
+		return errors.New("Shutdown drain period cannot be negative")
+	}
+	if conf.Message == "" {
+		conf.Message = defaultShutdownMessage
+	}
+	return nil
+}
+
+// ShutdownManager tracks a graceful shutdown in progress.
+type ShutdownManager struct {
+	lockorder.Tier1Mutex
+	server     *Server
+	inProgress bool
+	reason     string
+	// closed once the drain period is over, telling Run() to exit:
+	done chan struct{}
+}
+
+// Initialize sets up the manager.
+func (sm *ShutdownManager) Initialize(server *Server) {
+	sm.server = server
+	sm.done = make(chan struct{})
+}
+
+// InProgress returns whether the server is shutting down.
+func (sm *ShutdownManager) InProgress() bool {
+	sm.Lock()
+	defer sm.Unlock()
+	return sm.inProgress
+}
+
+// Reason returns the message to show to users for the shutdown.
+func (sm *ShutdownManager) Reason() string {
+	sm.Lock()
+	reason := sm.reason
+	sm.Unlock()
+	if reason == "" {
+		reason = sm.server.Config().Server.Shutdown.Message
+	}
+	return reason
+}
+
+// Start begins a graceful shutdown: new connections are refused right away,
+// and the server exits once `delay` has passed. If reason is empty, the
+// configured message is used.
+func (sm *ShutdownManager) Start(delay time.Duration, reason string) error {
+	server := sm.server
+	// stop rehashes from bringing the listeners back
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
+	sm.Lock()
+	alreadyInProgress := sm.inProgress
+	if !alreadyInProgress {
+		sm.inProgress = true
+		sm.reason = reason
+	}
+	sm.Unlock()
+	if alreadyInProgress {
+		return errShutdownInProgress
+	}
+
+	reason = sm.Reason()
+	server.logger.Info("server", fmt.Sprintf("Shutting down in %v", delay), reason)
+	server.snomasks.Send(sno.LocalAccouncements, fmt.Sprintf("Server is shutting down in %v: %s", delay, reason))
+	server.stopListeners()
+
+	go sm.drain(delay, reason)
+	return nil
+}
+
+// shutdownCountdown returns the amounts of time left at which users are
+// warned about a shutdown that's `delay` away, in decreasing order.
+func shutdownCountdown(delay time.Duration) (result []time.Duration) {
+	if delay <= 0 {
+		return nil
+	}
+	result = append(result, delay)
+	for _, remaining := range shutdownWarnings {
+		if remaining < delay {
+			result = append(result, remaining)
+		}
+	}
+	return
+}
+
+// drain warns users as the shutdown approaches, then tells Run() to exit.
+func (sm *ShutdownManager) drain(delay time.Duration, reason string) {
+	deadline := time.Now().Add(delay)
+	for _, remaining := range shutdownCountdown(delay) {
+		time.Sleep(time.Until(deadline.Add(-remaining)))
+		sm.warn(reason, remaining)
+	}
+	time.Sleep(time.Until(deadline))
+	close(sm.done)
+}
+
+// warn tells all connected users how long they have left.
+func (sm *ShutdownManager) warn(reason string, remaining time.Duration) {
+	for _, client := range sm.server.clients.AllClients() {
+		client.Notice(fmt.Sprintf(client.t("%[1]s; disconnecting all users in %[2]v"), reason, remaining))
+	}
+}
+
+// stopListeners closes all the listeners, so that no new clients can connect.
+// You must be holding the rehash mutex to call this.
+func (server *Server) stopListeners() {
+	for addr, listener := range server.listeners {
+		listener.configMutex.Lock()
+		listener.shouldStop = true
+		listener.configMutex.Unlock()
+		// interrupt its Accept() call:
+		listener.listener.Close()
+		delete(server.listeners, addr)
+		server.logger.Info("listeners", fmt.Sprintf("stopped listening on %s.", addr))
+	}
+}
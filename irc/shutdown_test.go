@@ -0,0 +1,58 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdownCountdown(t *testing.T) {
+	assertCountdown := func(delay time.Duration, expected []time.Duration) {
+		if result := shutdownCountdown(delay); !reflect.DeepEqual(result, expected) {
+			t.Errorf("countdown for %v: expected %v, got %v", delay, expected, result)
+		}
+	}
+
+	assertCountdown(0, nil)
+	assertCountdown(5*time.Second, []time.Duration{5 * time.Second})
+	assertCountdown(time.Minute, []time.Duration{time.Minute, 30 * time.Second, 10 * time.Second})
+	assertCountdown(2*time.Minute, []time.Duration{2 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second})
+	assertCountdown(time.Hour, []time.Duration{time.Hour, 30 * time.Minute, 10 * time.Minute, 5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second})
+}
+
+func TestShutdownConfig(t *testing.T) {
+	var conf ShutdownConfig
+	if err := conf.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Message != defaultShutdownMessage || conf.DrainPeriod != 0 {
+		t.Errorf("unexpected defaults: %#v", conf)
+	}
+
+	conf = ShutdownConfig{DrainPeriod: -time.Second}
+	if conf.prepare() == nil {
+		t.Error("negative drain period should be rejected")
+	}
+}
+
+func TestShutdownAlreadyInProgress(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	// pretend a shutdown was started, without actually draining the server
+	sm := &h.server.shutdown
+	sm.Lock()
+	sm.inProgress = true
+	sm.reason = "upgrading"
+	sm.Unlock()
+
+	if err := sm.Start(time.Minute, "something else"); err != errShutdownInProgress {
+		t.Errorf("a second shutdown should be refused, got %v", err)
+	}
+	if reason := sm.Reason(); reason != "upgrading" {
+		t.Errorf("the second shutdown changed the reason to %q", reason)
+	}
+}
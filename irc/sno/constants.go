@@ -11,6 +11,7 @@ type Mask rune
 const (
 	LocalAccouncements Mask = 'a'
 	LocalConnects      Mask = 'c'
+	LocalFlood         Mask = 'f'
 	LocalChannels      Mask = 'j'
 	LocalKills         Mask = 'k'
 	LocalNicks         Mask = 'n'
@@ -26,6 +27,7 @@ var (
 	NoticeMaskNames = map[Mask]string{
 		LocalAccouncements: "ANNOUNCEMENT",
 		LocalConnects:      "CONNECT",
+		LocalFlood:         "FLOOD",
 		LocalChannels:      "CHANNEL",
 		LocalKills:         "KILL",
 		LocalNicks:         "NICK",
@@ -40,6 +42,7 @@ var (
 	ValidMasks = map[Mask]bool{
 		LocalAccouncements: true,
 		LocalConnects:      true,
+		LocalFlood:         true,
 		LocalChannels:      true,
 		LocalKills:         true,
 		LocalNicks:         true,
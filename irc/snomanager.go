@@ -2,15 +2,15 @@ package irc
 
 import (
 	"fmt"
-	"sync"
 
 	"github.com/goshuirc/irc-go/ircfmt"
+	"github.com/oragono/oragono/irc/lockorder"
 	"github.com/oragono/oragono/irc/sno"
 )
 
 // SnoManager keeps track of which clients to send snomasks to.
 type SnoManager struct {
-	sendListMutex sync.RWMutex // tier 2
+	sendListMutex lockorder.Tier2RWMutex
 	sendLists     map[sno.Mask]map[*Client]bool
 }
 
@@ -7,31 +7,36 @@ package irc
 import (
 	"bufio"
 	"crypto/sha256"
-	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"io"
 	"net"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
 )
 
 var (
 	handshakeTimeout, _ = time.ParseDuration("5s")
+	defaultWriteTimeout = 2 * time.Minute
 	errSendQExceeded    = errors.New("SendQ exceeded")
+	errWriteTimeout     = errors.New("Write timeout")
 
 	sendQExceededMessage = []byte("\r\nERROR :SendQ Exceeded\r\n")
 )
 
 // Socket represents an IRC socket.
 type Socket struct {
-	sync.Mutex
+	lockorder.Tier0Mutex
 
 	conn   net.Conn
 	reader *bufio.Reader
 
 	maxSendQBytes int
+	// if nonzero, a write that can't complete within this long (e.g., because the
+	// client stopped reading) disconnects the client:
+	writeTimeout time.Duration
 
 	// this is a trylock enforcing that only one goroutine can write to `conn` at a time
 	writerSemaphore Semaphore
@@ -40,16 +45,29 @@ type Socket struct {
 	totalLength   int
 	closed        bool
 	sendQExceeded bool
+	writeTimedOut bool
 	finalData     []byte // what to send when we die
 	finalized     bool
+
+	// traffic counters, for STATS:
+	stats SocketStats
+}
+
+// SocketStats counts the lines and bytes that went through a Socket.
+type SocketStats struct {
+	LinesRead    uint64
+	BytesRead    uint64
+	LinesWritten uint64
+	BytesWritten uint64
 }
 
 // NewSocket returns a new Socket.
-func NewSocket(conn net.Conn, maxReadQBytes int, maxSendQBytes int) *Socket {
+func NewSocket(conn net.Conn, maxReadQBytes int, maxSendQBytes int, writeTimeout time.Duration) *Socket {
 	result := Socket{
 		conn:          conn,
 		reader:        bufio.NewReaderSize(conn, maxReadQBytes),
 		maxSendQBytes: maxSendQBytes,
+		writeTimeout:  writeTimeout,
 	}
 	result.writerSemaphore.Initialize(1)
 	return &result
@@ -64,9 +82,43 @@ func (socket *Socket) Close() {
 	socket.wakeWriter()
 }
 
+// SetMaxSendQ changes the maximum length of the socket's sendQ, in bytes.
+func (socket *Socket) SetMaxSendQ(maxSendQBytes int) {
+	socket.Lock()
+	defer socket.Unlock()
+	socket.maxSendQBytes = maxSendQBytes
+}
+
+// SetMaxRecvQ raises the maximum length of a line read from the socket, in bytes.
+// This is only safe to call from the goroutine that reads from the socket.
+func (socket *Socket) SetMaxRecvQ(maxRecvQBytes int) {
+	// (this has no effect if the existing buffer is at least this large)
+	socket.reader = bufio.NewReaderSize(socket.reader, maxRecvQBytes)
+}
+
+// SendQ returns the current length of the socket's sendQ and its maximum, in bytes.
+func (socket *Socket) SendQ() (length, max int) {
+	socket.Lock()
+	defer socket.Unlock()
+	return socket.totalLength, socket.maxSendQBytes
+}
+
+// CloseReason returns errSendQExceeded or errWriteTimeout if the socket was closed
+// because the client couldn't keep up with the data we were sending, otherwise nil.
+func (socket *Socket) CloseReason() error {
+	socket.Lock()
+	defer socket.Unlock()
+	if socket.sendQExceeded {
+		return errSendQExceeded
+	} else if socket.writeTimedOut {
+		return errWriteTimeout
+	}
+	return nil
+}
+
 // CertFP returns the fingerprint of the certificate provided by the client.
 func (socket *Socket) CertFP() (string, error) {
-	var tlsConn, isTLS = socket.conn.(*tls.Conn)
+	var tlsConn, isTLS = underlyingTLSConn(socket.conn)
 	if !isTLS {
 		return "", errNotTLS
 	}
@@ -116,9 +168,21 @@ func (socket *Socket) Read() (string, error) {
 		return "", err
 	}
 
+	socket.Lock()
+	socket.stats.LinesRead++
+	socket.stats.BytesRead += uint64(len(lineBytes)) + 2 // \r\n
+	socket.Unlock()
+
 	return line, nil
 }
 
+// Stats returns the socket's traffic counters.
+func (socket *Socket) Stats() SocketStats {
+	socket.Lock()
+	defer socket.Unlock()
+	return socket.stats
+}
+
 // Write sends the given string out of Socket. Requirements:
 // 1. MUST NOT block for macroscopic amounts of time
 // 2. MUST NOT reorder messages
@@ -141,6 +205,8 @@ func (socket *Socket) Write(data []byte) (err error) {
 		} else {
 			socket.buffers = append(socket.buffers, data)
 			socket.totalLength = prospectiveLen
+			socket.stats.LinesWritten++
+			socket.stats.BytesWritten += uint64(len(data))
 		}
 	}
 	socket.Unlock()
@@ -181,13 +247,37 @@ func (socket *Socket) BlockingWrite(data []byte) (err error) {
 		return io.EOF
 	}
 
+	socket.Lock()
+	socket.stats.LinesWritten++
+	socket.stats.BytesWritten += uint64(len(data))
+	socket.Unlock()
+
+	socket.setWriteDeadline()
 	_, err = socket.conn.Write(data)
 	if err != nil {
+		socket.noteWriteError(err)
 		socket.finalize()
 	}
 	return
 }
 
+// setWriteDeadline sets the deadline for the next write to the connection, if configured.
+// you must be holding the semaphore to call this:
+func (socket *Socket) setWriteDeadline() {
+	if socket.writeTimeout != 0 {
+		socket.conn.SetWriteDeadline(time.Now().Add(socket.writeTimeout))
+	}
+}
+
+// noteWriteError records whether a failed write was due to the write timeout
+func (socket *Socket) noteWriteError(err error) {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		socket.Lock()
+		socket.writeTimedOut = true
+		socket.Unlock()
+	}
+}
+
 // wakeWriter starts the goroutine that actually performs the write, without blocking
 func (socket *Socket) wakeWriter() {
 	if socket.writerSemaphore.TryAcquire() {
@@ -253,8 +343,12 @@ func (socket *Socket) performWrite() (closed bool) {
 
 	var err error
 	if !closed && len(buffers) > 0 {
+		socket.setWriteDeadline()
 		// on Linux, the runtime will optimize this into a single writev(2) call:
 		_, err = (*net.Buffers)(&buffers).WriteTo(socket.conn)
+		if err != nil {
+			socket.noteWriteError(err)
+		}
 	}
 
 	closed = closed || err != nil
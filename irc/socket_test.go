@@ -0,0 +1,41 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSocketWriteTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	// nothing ever reads from `peer`, so the write can't complete
+	socket := NewSocket(conn, 512, 1024, 50*time.Millisecond)
+	socket.Write([]byte("PING :stalled\r\n"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for socket.CloseReason() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if socket.CloseReason() != errWriteTimeout {
+		t.Errorf("expected write timeout, got %v", socket.CloseReason())
+	}
+}
+
+func TestSocketSendQExceeded(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	socket := NewSocket(conn, 512, 16, 0)
+	socket.SetMaxSendQ(8)
+	if err := socket.Write([]byte("PING :this is too long\r\n")); err != errSendQExceeded {
+		t.Errorf("expected sendq exceeded, got %v", err)
+	}
+	if socket.CloseReason() != errSendQExceeded {
+		t.Errorf("expected sendq exceeded, got %v", socket.CloseReason())
+	}
+}
@@ -1,57 +1,125 @@
 package irc
 
 import (
-	"sync"
+	"sync/atomic"
 )
 
-// Stats contains the numbers of total, invisible and operators on the server
+// Stats counts the clients on the server, for LUSERS, the MOTD, and the API.
+// The counters are only changed at well-defined points in a client's lifecycle:
+// when it connects (Add), when it completes registration (Register), when its
+// invisible or operator status changes (ChangeInvisible and ChangeOperators,
+// for registered clients only), and when it's destroyed (Remove). Resumed
+// clients are counted like any others: the new client registers, and the old
+// one is removed.
 type Stats struct {
-	sync.RWMutex
+	// all fields are accessed atomically
+	unknown   int64 // connections that haven't completed registration
+	total     int64 // registered clients
+	invisible int64
+	operators int64
+	max       int64 // the highest value of total since startup
+}
 
-	Total     int
-	Invisible int
-	Operators int
+// StatsValues is a snapshot of the server's statistics.
+type StatsValues struct {
+	Unknown   int `json:"unknown"`
+	Total     int `json:"total"`
+	Invisible int `json:"invisible"`
+	Operators int `json:"operators"`
+	Max       int `json:"max"`
 }
 
 // NewStats creates a new instance of Stats
 func NewStats() *Stats {
-	serverStats := &Stats{
-		Total:     0,
-		Invisible: 0,
-		Operators: 0,
+	return new(Stats)
+}
+
+func boolToDelta(b bool) int64 {
+	if b {
+		return 1
 	}
+	return 0
+}
 
-	return serverStats
+// Add records a new connection.
+func (s *Stats) Add() {
+	atomic.AddInt64(&s.unknown, 1)
 }
 
-// ChangeTotal increments the total user count on server
-func (s *Stats) ChangeTotal(i int) {
-	s.Lock()
-	defer s.Unlock()
+// Register records that a connection has completed registration.
+func (s *Stats) Register(invisible, operator bool) {
+	atomic.AddInt64(&s.unknown, -1)
+	total := atomic.AddInt64(&s.total, 1)
+	atomic.AddInt64(&s.invisible, boolToDelta(invisible))
+	atomic.AddInt64(&s.operators, boolToDelta(operator))
+	for {
+		max := atomic.LoadInt64(&s.max)
+		if total <= max || atomic.CompareAndSwapInt64(&s.max, max, total) {
+			break
+		}
+	}
+}
 
-	s.Total += i
+// Remove records that a connection has closed.
+func (s *Stats) Remove(registered, invisible, operator bool) {
+	if !registered {
+		atomic.AddInt64(&s.unknown, -1)
+		return
+	}
+	atomic.AddInt64(&s.total, -1)
+	atomic.AddInt64(&s.invisible, -boolToDelta(invisible))
+	atomic.AddInt64(&s.operators, -boolToDelta(operator))
 }
 
-// ChangeInvisible increments the invisible count
+// ChangeInvisible changes the invisible count.
 func (s *Stats) ChangeInvisible(i int) {
-	s.Lock()
-	defer s.Unlock()
-
-	s.Invisible += i
+	atomic.AddInt64(&s.invisible, int64(i))
 }
 
-// ChangeOperators increases the operator count
+// ChangeOperators changes the operator count.
 func (s *Stats) ChangeOperators(i int) {
-	s.Lock()
-	defer s.Unlock()
+	atomic.AddInt64(&s.operators, int64(i))
+}
 
-	s.Operators += i
+// GetValues returns a snapshot of the statistics.
+func (s *Stats) GetValues() StatsValues {
+	return StatsValues{
+		Unknown:   int(atomic.LoadInt64(&s.unknown)),
+		Total:     int(atomic.LoadInt64(&s.total)),
+		Invisible: int(atomic.LoadInt64(&s.invisible)),
+		Operators: int(atomic.LoadInt64(&s.operators)),
+		Max:       int(atomic.LoadInt64(&s.max)),
+	}
+}
+
+// CommandStats counts how many times each command has been used, for STATS m.
+type CommandStats struct {
+	counts map[string]*uint64 // values are accessed atomically
 }
 
-// GetStats retrives total, invisible and oper count
-func (s *Stats) GetStats() (int, int, int) {
-	s.Lock()
-	defer s.Unlock()
+// NewCommandStats creates a new instance of CommandStats, covering all the commands we know.
+func NewCommandStats() CommandStats {
+	counts := make(map[string]*uint64, len(Commands))
+	for command := range Commands {
+		counts[command] = new(uint64)
+	}
+	return CommandStats{counts: counts}
+}
 
-	return s.Total, s.Invisible, s.Operators
+// Increment records a use of the command.
+func (cs *CommandStats) Increment(command string) {
+	if count, ok := cs.counts[command]; ok {
+		atomic.AddUint64(count, 1)
+	}
+}
+
+// Counts returns the counts of the commands that have been used.
+func (cs *CommandStats) Counts() (result map[string]uint64) {
+	result = make(map[string]uint64)
+	for command, count := range cs.counts {
+		if value := atomic.LoadUint64(count); value != 0 {
+			result[command] = value
+		}
+	}
+	return
 }
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestCommandStats(t *testing.T) {
+	cs := NewCommandStats()
+	cs.Increment("PRIVMSG")
+	cs.Increment("PRIVMSG")
+	cs.Increment("JOIN")
+	// unknown commands aren't counted
+	cs.Increment("NOTACOMMAND")
+
+	expected := map[string]uint64{"PRIVMSG": 2, "JOIN": 1}
+	if counts := cs.Counts(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("incorrect command counts: %v", counts)
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+	s.Add()
+	s.Add()
+	s.Add()
+	s.Register(true, false)
+	s.Register(false, true)
+	// the third connection never registers
+	s.Remove(false, false, false)
+
+	expected := StatsValues{Total: 2, Invisible: 1, Operators: 1, Max: 2}
+	if values := s.GetValues(); values != expected {
+		t.Errorf("incorrect stats: %#v", values)
+	}
+
+	s.Remove(true, true, false)
+	expected = StatsValues{Total: 1, Operators: 1, Max: 2}
+	if values := s.GetValues(); values != expected {
+		t.Errorf("incorrect stats: %#v", values)
+	}
+}
+
+func TestClientStatsLifecycle(t *testing.T) {
+	server := &Server{stats: NewStats()}
+	client := &Client{server: server, flags: modes.NewModeSet()}
+	server.stats.Add()
+
+	// modes set before registration are counted when the client registers
+	client.setCountedMode(modes.Invisible, true)
+	if values := server.stats.GetValues(); values.Invisible != 0 || values.Unknown != 1 {
+		t.Errorf("unregistered client should not be counted: %#v", values)
+	}
+	client.SetRegistered()
+
+	// an oper with both operator modes is only counted once
+	client.setCountedMode(modes.Operator, true)
+	client.setCountedMode(modes.LocalOperator, true)
+	expected := StatsValues{Total: 1, Invisible: 1, Operators: 1, Max: 1}
+	if values := server.stats.GetValues(); values != expected {
+		t.Errorf("incorrect stats: %#v", values)
+	}
+	client.setCountedMode(modes.Operator, false)
+	if values := server.stats.GetValues(); values.Operators != 1 {
+		t.Errorf("incorrect operator count: %#v", values)
+	}
+	client.setCountedMode(modes.LocalOperator, false)
+	client.setCountedMode(modes.Invisible, false)
+	if values := server.stats.GetValues(); values.Operators != 0 || values.Invisible != 0 {
+		t.Errorf("incorrect stats: %#v", values)
+	}
+}
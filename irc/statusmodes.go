@@ -0,0 +1,97 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+// Channel status modes are the ones that members hold in a channel: founder
+// (+q, ~), admin (+a, &), op (+o, @), halfop (+h, %), and voice (+v, +).
+// Networks that prefer the minimal set can enable only some of them with
+// channels.status-modes (op and voice are always enabled). Nobody can set the
+// disabled ones with MODE or ChanServ AMODE; when something would grant one
+// anyway (e.g., being the founder of a registered channel, or an AMODE that
+// was set before it was disabled), the highest enabled mode below it is
+// granted instead, so that founders and admins get +o, and halfops get +v.
+
+const defaultStatusModes = "qaohv"
+
+// parseStatusModes parses the enabled status modes, returning them in
+// descending order of precedence.
+func parseStatusModes(str string) (result modes.Modes, err error) {
+	if str == "" {
+		str = defaultStatusModes
+	}
+	for _, char := range str {
+		if _, ok := modes.ChannelModePrefixes[modes.Mode(char)]; !ok {
+			return nil, fmt.Errorf("Invalid channel status mode: %c", char)
+		}
+	}
+	for _, mode := range modes.ChannelUserModes {
+		if strings.ContainsRune(str, rune(mode)) {
+			result = append(result, mode)
+		} else if mode == modes.ChannelOperator || mode == modes.Voice {
+			return nil, fmt.Errorf("Channel status modes must include +%s", mode)
+		}
+	}
+	return
+}
+
+// statusModeEnabled returns whether the status mode can be set. Other modes
+// are unaffected.
+func (config *Config) statusModeEnabled(mode modes.Mode) bool {
+	if _, isStatusMode := modes.ChannelModePrefixes[mode]; !isStatusMode {
+		return true
+	}
+	for _, enabled := range config.Channels.statusModes {
+		if mode == enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// effectiveStatusMode returns the status mode that's actually granted in
+// place of the given one: the mode itself if it's enabled, otherwise the
+// highest enabled mode below it.
+func (config *Config) effectiveStatusMode(mode modes.Mode) modes.Mode {
+	if config.statusModeEnabled(mode) {
+		return mode
+	}
+	for _, enabled := range config.Channels.statusModes {
+		if umodeGreaterThan(mode, enabled) {
+			return enabled
+		}
+	}
+	return modes.Voice
+}
+
+// filterStatusModes removes changes to disabled status modes, adding them to
+// the unknown modes instead.
+func (config *Config) filterStatusModes(changes modes.ModeChanges, unknown map[rune]bool) (result modes.ModeChanges) {
+	result = changes[:0]
+	for _, change := range changes {
+		if config.statusModeEnabled(change.Mode) {
+			result = append(result, change)
+		} else {
+			unknown[rune(change.Mode)] = true
+		}
+	}
+	return
+}
+
+// statusModePrefixes returns the enabled status modes and their prefixes,
+// for ISUPPORT PREFIX.
+func (config *Config) statusModePrefixes() (modeString, prefixes string) {
+	var modeChars, prefixChars strings.Builder
+	for _, mode := range config.Channels.statusModes {
+		modeChars.WriteRune(rune(mode))
+		prefixChars.WriteString(modes.ChannelModePrefixes[mode])
+	}
+	return modeChars.String(), prefixChars.String()
+}
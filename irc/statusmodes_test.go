@@ -0,0 +1,102 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestParseStatusModes(t *testing.T) {
+	statusModes, err := parseStatusModes("")
+	if err != nil || statusModes.String() != "qaohv" {
+		t.Errorf("unexpected default status modes: %v, %v", statusModes, err)
+	}
+	statusModes, err = parseStatusModes("vho")
+	if err != nil || statusModes.String() != "ohv" {
+		t.Errorf("status modes should be in order of precedence: %v, %v", statusModes, err)
+	}
+	if _, err := parseStatusModes("qahv"); err == nil {
+		t.Errorf("op can't be disabled")
+	}
+	if _, err := parseStatusModes("ovb"); err == nil {
+		t.Errorf("only status modes can be listed")
+	}
+}
+
+func TestEffectiveStatusMode(t *testing.T) {
+	var config Config
+	config.Channels.statusModes, _ = parseStatusModes("ov")
+	cases := map[modes.Mode]modes.Mode{
+		modes.ChannelFounder:  modes.ChannelOperator,
+		modes.ChannelAdmin:    modes.ChannelOperator,
+		modes.ChannelOperator: modes.ChannelOperator,
+		modes.Halfop:          modes.Voice,
+		modes.Voice:           modes.Voice,
+		modes.Moderated:       modes.Moderated,
+		0:                     0,
+	}
+	for mode, expected := range cases {
+		if effective := config.effectiveStatusMode(mode); effective != expected {
+			t.Errorf("expected %v to become %v, got %v", mode, expected, effective)
+		}
+	}
+
+	if mode, prefixes := config.statusModePrefixes(); mode != "ov" || prefixes != "@+" {
+		t.Errorf("unexpected prefixes: %s %s", mode, prefixes)
+	}
+}
+
+func TestMinimalStatusModes(t *testing.T) {
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Channels.statusModes, _ = parseStatusModes("ov")
+	})
+	defer h.Close()
+
+	if prefix := h.server.ISupport().Tokens["PREFIX"]; prefix == nil || *prefix != "(ov)@+" {
+		t.Errorf("unexpected PREFIX: %v", prefix)
+	}
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	alice.Send("JOIN", "#test")
+	alice.Expect(RPL_ENDOFNAMES)
+	bob.Send("JOIN", "#test")
+	bob.Expect(RPL_ENDOFNAMES)
+
+	alice.Send("MODE", "#test", "+h", "bob")
+	if msg := alice.Expect(ERR_UNKNOWNMODE); msg.Params[1] != "h" {
+		t.Errorf("unexpected reply: %v", msg)
+	}
+
+	// privileges that would grant a disabled mode grant the next one down
+	channel := h.server.channels.Get("#test")
+	rb := NewResponseBuffer(h.server.clients.Get("alice"))
+	if change := channel.applyModeToMember(h.server.clients.Get("alice"), modes.Halfop, modes.Add, "bob", rb); change == nil || change.Mode != modes.Voice {
+		t.Errorf("expected bob to be voiced, got %v", change)
+	}
+}
+
+func TestHalfopTopic(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	alice := h.Register("alice")
+	bob := h.Register("bob")
+	alice.Send("JOIN", "#test")
+	alice.Expect(RPL_ENDOFNAMES)
+	// the default modes include +t
+	bob.Send("JOIN", "#test")
+	bob.Expect(RPL_ENDOFNAMES)
+
+	bob.Send("TOPIC", "#test", "hi")
+	bob.Expect(ERR_CHANOPRIVSNEEDED)
+	alice.Send("MODE", "#test", "+h", "bob")
+	bob.Expect("MODE")
+	bob.Send("TOPIC", "#test", "hi")
+	if msg := bob.Expect("TOPIC"); msg.Params[1] != "hi" {
+		t.Errorf("halfops should be able to set the topic: %v", msg)
+	}
+}
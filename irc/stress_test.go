@@ -0,0 +1,171 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests hammer the server's shared state from many goroutines at once.
+// They're most useful run with the race detector and lock order verification
+// (see DEVELOPING.md):
+//
+//     go test -race -tags lockorder -run Stress ./irc/
+
+var (
+	stressClients    = flag.Int("stress-clients", 40, "number of simulated clients for the stress tests")
+	stressIterations = flag.Int("stress-iterations", 40, "number of rounds of commands each stress test client sends")
+)
+
+const stressChannels = 4
+
+// stressRound sends one round of randomly chosen commands, touching channel
+// membership, channel state, and the nickname index, then waits for the
+// server to process them.
+func stressRound(client *testClient, r *rand.Rand, nick *string, round int) {
+	channel := fmt.Sprintf("#stress%d", r.Intn(stressChannels))
+	switch r.Intn(8) {
+	case 0:
+		client.Send("JOIN", channel)
+	case 1:
+		client.Send("PART", channel)
+	case 2:
+		client.Send("PRIVMSG", channel, "hello")
+	case 3:
+		client.Send("TOPIC", channel, fmt.Sprintf("topic from %s", *nick))
+	case 4:
+		// only succeeds for channel operators, which is fine
+		client.Send("MODE", channel, "+l-l", "100")
+	case 5:
+		client.Send("WHO", channel)
+	case 6:
+		client.Send("NAMES", channel)
+	case 7:
+		*nick = fmt.Sprintf("%s-%d", client.nick, round)
+		client.Send("NICK", *nick)
+	}
+	client.Send("PING", "sync")
+	client.Expect("PONG")
+}
+
+// checkMembershipConsistency checks that clients' lists of channels agree with
+// the channels' lists of members.
+func checkMembershipConsistency(t *testing.T, server *Server) {
+	for _, channel := range server.channels.Channels() {
+		for _, member := range channel.Members() {
+			found := false
+			for _, memberChannel := range member.Channels() {
+				if memberChannel == channel {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("%s is a member of %s, but doesn't know it", member.Nick(), channel.Name())
+			}
+		}
+	}
+	for _, client := range server.clients.AllClients() {
+		for _, channel := range client.Channels() {
+			if !channel.hasClient(client) {
+				t.Errorf("%s thinks it's in %s, but isn't a member", client.Nick(), channel.Name())
+			}
+			if server.channels.Get(channel.Name()) != channel {
+				t.Errorf("%s is in %s, which isn't registered with the channel manager", client.Nick(), channel.Name())
+			}
+		}
+	}
+}
+
+// TestStressChannelChurn has many clients concurrently join, part, talk in,
+// and modify a few shared channels, and change their nicknames, while the
+// configuration is reapplied in the background; then it checks that the
+// server's state is consistent, and that everything is cleaned up when the
+// clients disconnect.
+func TestStressChannelChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+	h := newTestHarness(t, nil, func(config *Config) {
+		config.Channels.JoinFlood.Enabled = false
+	})
+	defer h.Close()
+
+	clients := make([]*testClient, *stressClients)
+	for i := range clients {
+		clients[i] = h.Register(fmt.Sprintf("stress%d", i))
+	}
+
+	// reapplying the config takes the outermost mutexes, while the clients
+	// are taking the innermost ones
+	stop := make(chan struct{})
+	rehashDone := make(chan struct{})
+	go func() {
+		defer close(rehashDone)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			h.server.rehashMutex.Lock()
+			err := h.server.applyConfig(h.server.Config(), false)
+			h.server.rehashMutex.Unlock()
+			if err != nil {
+				t.Errorf("couldn't reapply config: %v", err)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *testClient) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			nick := client.nick
+			for round := 0; round < *stressIterations; round++ {
+				stressRound(client, r, &nick, round)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	close(stop)
+	<-rehashDone
+	if t.Failed() {
+		return
+	}
+
+	checkMembershipConsistency(t, h.server)
+	if count := h.server.clients.Count(); count != len(clients) {
+		t.Errorf("expected %d clients, got %d", len(clients), count)
+	}
+
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *testClient) {
+			defer wg.Done()
+			client.Send("QUIT")
+			client.Expect("ERROR")
+		}(client)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(harnessTimeout)
+	for (h.server.clients.Count() != 0 || len(h.server.channels.Channels()) != 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := h.server.clients.Count(); count != 0 {
+		t.Errorf("%d clients were not cleaned up", count)
+	}
+	if count := len(h.server.channels.Channels()); count != 0 {
+		t.Errorf("%d channels were not cleaned up", count)
+	}
+}
@@ -179,3 +179,20 @@ func Skeleton(name string) (string, error) {
 	// pass PRECIS --- we are just further canonicalizing the skeleton.
 	return cases.Lower(language.Und).String(name), nil
 }
+
+// ChannelSkeleton produces a canonicalized identifier for a channel name,
+// like Skeleton does for nicknames; the preceding #'s are left as-is.
+func ChannelSkeleton(name string) (string, error) {
+	var start int
+	for start = 0; start < len(name) && name[start] == '#'; start += 1 {
+	}
+	if start == 0 {
+		return "", errInvalidCharacter
+	}
+
+	skeleton, err := Skeleton(name[start:])
+	if err != nil {
+		return "", err
+	}
+	return name[:start] + skeleton, nil
+}
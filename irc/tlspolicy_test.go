@@ -0,0 +1,58 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	conf := TLSListenConfig{
+		MinVersion: "1.2",
+		Ciphers:    []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "tls_ecdhe_rsa_with_chacha20_poly1305"},
+		Curves:     []string{"x25519", "P256"},
+	}
+	var config tls.Config
+	if err := conf.applyPolicy(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("incorrect min version %x", config.MinVersion)
+	}
+	expectedCiphers := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305}
+	if !reflect.DeepEqual(config.CipherSuites, expectedCiphers) || !config.PreferServerCipherSuites {
+		t.Errorf("incorrect cipher suites %v", config.CipherSuites)
+	}
+	if !reflect.DeepEqual(config.CurvePreferences, []tls.CurveID{tls.X25519, tls.CurveP256}) {
+		t.Errorf("incorrect curves %v", config.CurvePreferences)
+	}
+	if config.ClientAuth != tls.RequestClientCert || requiresClientCert(&config) {
+		t.Errorf("client certificates should be requested but not required by default")
+	}
+
+	conf = TLSListenConfig{RequireClientCert: true}
+	config = tls.Config{}
+	if err := conf.applyPolicy(&config); err != nil {
+		t.Fatal(err)
+	}
+	if !requiresClientCert(&config) {
+		t.Errorf("client certificates should be required")
+	}
+}
+
+func TestTLSPolicyErrors(t *testing.T) {
+	bad := []TLSListenConfig{
+		{MinVersion: "1.4"},
+		{Ciphers: []string{"TLS_RSA_WITH_RC4_128_MD5"}},
+		{Curves: []string{"P224"}},
+		{ClientCA: "/nonexistent/ca.crt"},
+	}
+	for _, conf := range bad {
+		if err := conf.applyPolicy(&tls.Config{}); err == nil {
+			t.Errorf("invalid policy should be rejected: %#v", conf)
+		}
+	}
+}
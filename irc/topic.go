@@ -0,0 +1,81 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+// Channels remember their recent topics, so that operators can see who changed
+// the topic and undo vandalism. Registered channels can also lock their topic:
+// if someone without persistent operator access changes it, ChanServ puts the
+// previous topic back.
+
+const (
+	// how many topics are kept in a channel's topic history
+	topicHistoryLength = 10
+)
+
+// TopicHistoryItem is one of the recent topics of a channel.
+type TopicHistoryItem struct {
+	Topic   string
+	SetBy   string
+	SetTime time.Time
+}
+
+// recordTopic adds the current topic to the topic history.
+// channel.stateMutex must be held.
+func (channel *Channel) recordTopic() {
+	channel.topicHistory = append(channel.topicHistory, TopicHistoryItem{
+		Topic:   channel.topic,
+		SetBy:   channel.topicSetBy,
+		SetTime: channel.topicSetTime,
+	})
+	if topicHistoryLength < len(channel.topicHistory) {
+		channel.topicHistory = channel.topicHistory[len(channel.topicHistory)-topicHistoryLength:]
+	}
+}
+
+// TopicHistory returns the channel's recent topics, oldest first.
+func (channel *Channel) TopicHistory() (result []TopicHistoryItem) {
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	result = make([]TopicHistoryItem, len(channel.topicHistory))
+	copy(result, channel.topicHistory)
+	return
+}
+
+// hasTopicAccess returns whether a client may change a locked topic: this
+// requires persistent operator access (or channel registration privileges).
+func (channel *Channel) hasTopicAccess(client *Client) bool {
+	if client.HasRoleCapabs("chanreg") {
+		return true
+	}
+	account := client.Account()
+	if account == "" {
+		return false
+	}
+	channel.stateMutex.RLock()
+	defer channel.stateMutex.RUnlock()
+	mode := channel.accountToUMode[account]
+	return account == channel.registeredFounder || mode == modes.ChannelOperator || umodeGreaterThan(mode, modes.ChannelOperator)
+}
+
+// restoreTopic puts back a topic that was changed without sufficient access.
+func (channel *Channel) restoreTopic(previous TopicHistoryItem) {
+	channel.stateMutex.Lock()
+	channel.topic = previous.Topic
+	channel.topicSetBy = previous.SetBy
+	channel.topicSetTime = previous.SetTime
+	chname := channel.name
+	channel.stateMutex.Unlock()
+
+	chanservMask := fmt.Sprintf("ChanServ!services@%s", channel.server.name)
+	for _, member := range channel.Members() {
+		member.Send(nil, chanservMask, "TOPIC", chname, previous.Topic)
+	}
+}
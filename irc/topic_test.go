@@ -0,0 +1,57 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestTopicHistory(t *testing.T) {
+	channel := &Channel{}
+	for i := 0; i < topicHistoryLength+3; i++ {
+		channel.topic = fmt.Sprintf("topic %d", i)
+		channel.recordTopic()
+	}
+	topics := channel.TopicHistory()
+	if len(topics) != topicHistoryLength {
+		t.Fatalf("topic history should be bounded, got %d topics", len(topics))
+	}
+	if topics[0].Topic != "topic 3" || topics[len(topics)-1].Topic != fmt.Sprintf("topic %d", topicHistoryLength+2) {
+		t.Errorf("topic history should keep the most recent topics: %v", topics)
+	}
+	// the result is a copy
+	topics[0].Topic = "vandalism"
+	if channel.TopicHistory()[0].Topic != "topic 3" {
+		t.Errorf("topic history should not be modified through its copy")
+	}
+}
+
+func TestTopicAccess(t *testing.T) {
+	channel := &Channel{
+		registeredFounder: "founder",
+		accountToUMode: map[string]modes.Mode{
+			"founder": modes.ChannelFounder,
+			"admin":   modes.ChannelAdmin,
+			"op":      modes.ChannelOperator,
+			"halfop":  modes.Halfop,
+		},
+	}
+	cases := map[string]bool{
+		"founder": true,
+		"admin":   true,
+		"op":      true,
+		"halfop":  false,
+		"someone": false,
+		"":        false,
+	}
+	for account, hasAccess := range cases {
+		client := &Client{account: account}
+		if channel.hasTopicAccess(client) != hasAccess {
+			t.Errorf("incorrect topic access for %s", account)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+	"github.com/oragono/oragono/irc/modes"
+	"github.com/tidwall/buntdb"
+)
+
+// Ownership of a reserved nickname or a registered channel can be transferred
+// to another account (typically, when someone registers a new account and
+// wants to move their things to it). The current owner proposes the transfer,
+// and it only takes effect when the recipient accepts it; opers can transfer
+// ownership immediately. Channel transfers can be done with either NickServ
+// TRANSFER or ChanServ TRANSFER, and are logged with the "audit" log type.
+
+const (
+	// how long a proposed transfer waits to be accepted
+	transferTimeout = 24 * time.Hour
+)
+
+var (
+	errTransferNotOwned      = errors.New("You don't own that nick or channel")
+	errTransferPrimaryNick   = errors.New("Account names can't be transferred")
+	errTransferSameAccount   = errors.New("That account already owns it")
+	errTransferNotPending    = errors.New("No such transfer is pending")
+	errTransferNoSuchChannel = errors.New("No such channel")
+)
+
+// pendingTransfer is a proposed transfer that hasn't been accepted yet.
+type pendingTransfer struct {
+	name    string // the nick or channel, as it was given
+	from    string // casefolded account names
+	to      string
+	expires time.Time
+}
+
+// TransferManager keeps track of proposed transfers.
+type TransferManager struct {
+	lockorder.Tier1Mutex
+	// keyed by casefolded nick or channel name; at most one transfer of each
+	// can be pending at a time
+	pending map[string]pendingTransfer
+}
+
+// Propose records a transfer, replacing any previous proposal for the same name.
+func (tm *TransferManager) Propose(key, name, from, to string) {
+	tm.Lock()
+	defer tm.Unlock()
+	if tm.pending == nil {
+		tm.pending = make(map[string]pendingTransfer)
+	}
+	tm.pending[key] = pendingTransfer{
+		name:    name,
+		from:    from,
+		to:      to,
+		expires: time.Now().Add(transferTimeout),
+	}
+}
+
+// Remove removes the pending transfer of the given name, if the given account
+// is involved in it (as either the sender or the recipient), returning it.
+func (tm *TransferManager) Remove(key, account string, onlyRecipient bool) (transfer pendingTransfer, err error) {
+	tm.Lock()
+	defer tm.Unlock()
+	transfer, ok := tm.pending[key]
+	if !ok || time.Now().After(transfer.expires) {
+		delete(tm.pending, key)
+		return transfer, errTransferNotPending
+	}
+	if account != transfer.to && (onlyRecipient || account != transfer.from) {
+		return transfer, errTransferNotPending
+	}
+	delete(tm.pending, key)
+	return transfer, nil
+}
+
+// transferKey returns the key identifying a nick or channel name, and whether
+// it's a channel.
+func transferKey(name string) (key string, isChannel bool, err error) {
+	if strings.HasPrefix(name, "#") {
+		key, err = CasefoldChannel(name)
+		return key, true, err
+	}
+	key, err = CasefoldName(name)
+	return key, false, err
+}
+
+// TransferNick moves a reserved nick from one account to another.
+func (am *AccountManager) TransferNick(nick, from, to string) error {
+	cfnick, err := CasefoldName(nick)
+	if err != nil {
+		return errAccountNickReservationFailed
+	}
+	if cfnick == from {
+		return errTransferPrimaryNick
+	}
+
+	// the cache is in sync with the DB while we hold serialCacheUpdateMutex
+	am.serialCacheUpdateMutex.Lock()
+	defer am.serialCacheUpdateMutex.Unlock()
+
+	if am.NickToAccount(cfnick) != from {
+		return errTransferNotOwned
+	}
+
+	var skeleton string
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(fmt.Sprintf(keyAccountVerified, to)); err != nil {
+			return errAccountDoesNotExist
+		}
+
+		fromKey := fmt.Sprintf(keyAccountAdditionalNicks, from)
+		rawNicks, _ := tx.Get(fromKey)
+		var fromNicks []string
+		var originalNick string
+		for _, reservedNick := range unmarshalReservedNicks(rawNicks) {
+			if cfreservednick, _ := CasefoldName(reservedNick); cfreservednick == cfnick {
+				originalNick = reservedNick
+			} else {
+				fromNicks = append(fromNicks, reservedNick)
+			}
+		}
+		if originalNick == "" {
+			return errTransferNotOwned
+		}
+		skeleton, _ = Skeleton(originalNick)
+
+		// the recipient asked for (or an oper imposed) the nick, so the limit
+		// on additional nicks doesn't apply
+		toKey := fmt.Sprintf(keyAccountAdditionalNicks, to)
+		rawNicks, _ = tx.Get(toKey)
+		toNicks := append(unmarshalReservedNicks(rawNicks), originalNick)
+
+		if _, _, err := tx.Set(fromKey, marshalReservedNicks(fromNicks), nil); err != nil {
+			return err
+		}
+		_, _, err := tx.Set(toKey, marshalReservedNicks(toNicks), nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	am.Lock()
+	defer am.Unlock()
+	am.nickToAccount[cfnick] = to
+	am.skeletonToAccount[skeleton] = to
+	return nil
+}
+
+// Transfer makes another account the founder of the channel; the previous
+// founder loses their persistent founder status.
+func (channel *Channel) Transfer(from, to string) error {
+	channel.stateMutex.Lock()
+	defer channel.stateMutex.Unlock()
+
+	if from == "" || channel.registeredFounder != from {
+		return errTransferNotOwned
+	}
+	channel.registeredFounder = to
+	if channel.registeredSuccessor == to {
+		channel.registeredSuccessor = ""
+	}
+	delete(channel.accountToUMode, from)
+	channel.accountToUMode[to] = modes.ChannelFounder
+	return nil
+}
+
+// Transfer persists a change of the channel's founder.
+func (reg *ChannelRegistry) Transfer(channel *Channel, from string) {
+	if !reg.server.ChannelRegistrationEnabled() {
+		return
+	}
+
+	reg.Lock()
+	defer reg.Unlock()
+
+	key := channel.NameCasefolded()
+	info := channel.ExportRegistration(IncludeInitial | IncludeLists)
+	reg.server.store.Update(func(tx *buntdb.Tx) error {
+		moveRegisteredChannel(tx, key, from, info.Founder)
+		reg.saveChannel(tx, key, info, IncludeInitial|IncludeLists)
+		return nil
+	})
+}
+
+// performTransfer transfers a nick or channel between accounts.
+func (server *Server) performTransfer(name, from, to string) error {
+	key, isChannel, err := transferKey(name)
+	if err != nil {
+		return err
+	}
+	if !isChannel {
+		return server.accounts.TransferNick(key, from, to)
+	}
+	channel := server.channels.Get(key)
+	if channel == nil {
+		return server.channelRegistry.TransferStored(key, from, to)
+	}
+	if err := channel.Transfer(from, to); err != nil {
+		return err
+	}
+	server.channelRegistry.Transfer(channel, from)
+	channel.updateFounderModes(from, to)
+	return nil
+}
+
+// TransferStored makes another account the founder of a registered channel
+// that isn't loaded, by updating its stored registration.
+func (reg *ChannelRegistry) TransferStored(casefoldedName, from, to string) error {
+	info := reg.LoadChannel(casefoldedName)
+	if info == nil {
+		return errTransferNoSuchChannel
+	}
+	if from == "" || info.Founder != from {
+		return errTransferNotOwned
+	}
+	reg.setStoredFounder(casefoldedName, info, to)
+	return nil
+}
+
+// updateFounderModes moves the founder's channel mode from the members logged
+// into the previous founder's account to those logged into the new one's, and
+// announces the changes.
+func (channel *Channel) updateFounderModes(from, to string) {
+	founderMode := channel.server.Config().effectiveStatusMode(modes.ChannelFounder)
+	// the previous founder loses the mode before the new founder gets it
+	var removals, additions []modes.ModeChange
+	for _, member := range channel.Members() {
+		var op modes.ModeOp
+		switch member.Account() {
+		case from:
+			op = modes.Remove
+		case to:
+			op = modes.Add
+		default:
+			continue
+		}
+		if _, changed := channel.members.SetMode(member, founderMode, op == modes.Add); changed {
+			change := modes.ModeChange{Op: op, Mode: founderMode, Arg: member.Nick()}
+			if op == modes.Remove {
+				removals = append(removals, change)
+			} else {
+				additions = append(additions, change)
+			}
+		}
+	}
+	changes := append(removals, additions...)
+
+	if len(changes) == 0 {
+		return
+	}
+	channelName := channel.Name()
+	source := fmt.Sprintf("ChanServ!services@%s", channel.server.name)
+	for _, member := range channel.Members() {
+		for _, change := range changes {
+			args := append([]string{channelName}, strings.Split(change.String(), " ")...)
+			member.Send(nil, source, "MODE", args...)
+		}
+	}
+}
+
+// transferOwner returns the account that currently owns a nick or channel.
+func (server *Server) transferOwner(name string) (owner string, err error) {
+	key, isChannel, err := transferKey(name)
+	if err != nil {
+		return
+	}
+	if !isChannel {
+		return server.accounts.NickToAccount(key), nil
+	}
+	if channel := server.channels.Get(key); channel != nil {
+		return channel.Founder(), nil
+	}
+	// registered channels aren't necessarily loaded
+	info := server.channelRegistry.LoadChannel(key)
+	if info == nil {
+		return "", errTransferNoSuchChannel
+	}
+	return info.Founder, nil
+}
+
+// notifyAccount sends a notice from the service (e.g., NickServ) to every
+// client logged into an account.
+func (server *Server) notifyAccount(service, account string, message func(client *Client) string) {
+	messages := make(splitMessageCache)
+	for _, client := range server.accounts.AccountToClients(account) {
+		client.SendSplitMsgFromServer(service, "NOTICE", client.Nick(), messages.get(message(client)))
+	}
+}
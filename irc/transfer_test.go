@@ -0,0 +1,166 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oragono/oragono/irc/modes"
+)
+
+func TestTransferManager(t *testing.T) {
+	var tm TransferManager
+	tm.Propose("dan", "Dan", "old", "new")
+
+	// unrelated accounts can't see the transfer, and only the recipient can accept it
+	if _, err := tm.Remove("dan", "someone", false); err != errTransferNotPending {
+		t.Errorf("unrelated account should not be able to remove the transfer")
+	}
+	if _, err := tm.Remove("dan", "old", true); err != errTransferNotPending {
+		t.Errorf("sender should not be able to accept the transfer")
+	}
+	transfer, err := tm.Remove("dan", "new", true)
+	if err != nil || transfer.name != "Dan" || transfer.from != "old" || transfer.to != "new" {
+		t.Errorf("recipient should be able to accept the transfer: %#v, %v", transfer, err)
+	}
+	if _, err := tm.Remove("dan", "new", true); err != errTransferNotPending {
+		t.Errorf("transfers can only be accepted once")
+	}
+
+	// the sender can cancel
+	tm.Propose("#chan", "#chan", "old", "new")
+	if _, err := tm.Remove("#chan", "old", false); err != nil {
+		t.Errorf("sender should be able to cancel the transfer")
+	}
+
+	// a new proposal replaces the old one
+	tm.Propose("dan", "Dan", "old", "new")
+	tm.Propose("dan", "Dan", "old", "newer")
+	if _, err := tm.Remove("dan", "new", true); err != errTransferNotPending {
+		t.Errorf("replaced proposal should not be accepted")
+	}
+
+	// expired proposals can't be accepted
+	tm.Propose("dan", "Dan", "old", "new")
+	tm.pending["dan"] = pendingTransfer{name: "Dan", from: "old", to: "new", expires: time.Now().Add(-time.Minute)}
+	if _, err := tm.Remove("dan", "new", true); err != errTransferNotPending {
+		t.Errorf("expired proposal should not be accepted")
+	}
+}
+
+func TestChannelTransfer(t *testing.T) {
+	channel := &Channel{
+		registeredFounder:   "old",
+		registeredSuccessor: "new",
+		accountToUMode: map[string]modes.Mode{
+			"old":    modes.ChannelFounder,
+			"friend": modes.ChannelOperator,
+		},
+	}
+	if err := channel.Transfer("someone", "new"); err != errTransferNotOwned {
+		t.Errorf("only the founder's channels can be transferred")
+	}
+	if err := channel.Transfer("old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if channel.registeredFounder != "new" || channel.registeredSuccessor != "" {
+		t.Errorf("founder was not changed: %s, %s", channel.registeredFounder, channel.registeredSuccessor)
+	}
+	if _, ok := channel.accountToUMode["old"]; ok {
+		t.Errorf("previous founder should lose their founder status")
+	}
+	if channel.accountToUMode["new"] != modes.ChannelFounder || channel.accountToUMode["friend"] != modes.ChannelOperator {
+		t.Errorf("incorrect amodes after transfer: %v", channel.accountToUMode)
+	}
+}
+
+func TestChanServTransfer(t *testing.T) {
+	h := newTestHarness(t, nil, nil)
+	defer h.Close()
+
+	for _, account := range []string{"old", "new"} {
+		if err := h.server.accounts.Register(nil, account, "admin", "", "hunter2", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.server.accounts.Verify(nil, account, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expectNotice waits for a ChanServ notice containing the text
+	expectNotice := func(client *testClient, text string) {
+		for {
+			msg, ok := client.ExpectWithin("NOTICE", harnessTimeout)
+			if !ok {
+				t.Fatalf("%s didn't receive a notice containing %q", client.nick, text)
+			}
+			if strings.Contains(msg.Params[len(msg.Params)-1], text) {
+				return
+			}
+		}
+	}
+
+	founder := h.Register("old")
+	founder.Send("NS", "IDENTIFY", "old", "hunter2")
+	expectNotice(founder, "now logged in")
+	founder.Send("JOIN", "#chan")
+	founder.Expect(RPL_ENDOFNAMES)
+	founder.Send("CS", "REGISTER", "#chan")
+	expectNotice(founder, "registered")
+	recipient := h.Register("new")
+	recipient.Send("NS", "IDENTIFY", "new", "hunter2")
+	expectNotice(recipient, "now logged in")
+
+	recipient.Send("CS", "TRANSFER", "#chan", "new")
+	expectNotice(recipient, "founder")
+	founder.Send("CS", "TRANSFER", "#chan", "new")
+	expectNotice(founder, "when they accept")
+	expectNotice(recipient, "/CS TRANSFER ACCEPT #chan")
+	// the founder can't accept their own proposal
+	founder.Send("CS", "TRANSFER", "ACCEPT", "#chan")
+	expectNotice(founder, errTransferNotPending.Error())
+	if current := h.server.channels.Get("#chan").Founder(); current != "old" {
+		t.Errorf("channel shouldn't be transferred before acceptance, founder is %s", current)
+	}
+
+	recipient.Send("JOIN", "#chan")
+	recipient.Expect(RPL_ENDOFNAMES)
+	recipient.Send("CS", "TRANSFER", "ACCEPT", "#chan")
+	// the founder mode moves to the new founder
+	if msg := recipient.Expect("MODE"); len(msg.Params) != 3 || msg.Params[1] != "-q" || msg.Params[2] != "old" {
+		t.Errorf("previous founder should lose +q: %v", msg)
+	}
+	if msg := recipient.Expect("MODE"); len(msg.Params) != 3 || msg.Params[1] != "+q" || msg.Params[2] != "new" {
+		t.Errorf("new founder should get +q: %v", msg)
+	}
+	expectNotice(recipient, "You are now the founder")
+	expectNotice(founder, "now belongs to the account new")
+	if current := h.server.channels.Get("#chan").Founder(); current != "new" {
+		t.Errorf("channel should be transferred, founder is %s", current)
+	}
+
+	// registered channels that aren't loaded can be transferred too
+	recipient.Send("JOIN", "#unloaded")
+	recipient.Expect(RPL_ENDOFNAMES)
+	recipient.Send("CS", "REGISTER", "#unloaded")
+	expectNotice(recipient, "registered")
+	recipient.Send("PART", "#unloaded")
+	recipient.Expect("PART")
+	// registered channels are loaded when someone joins them, so after a
+	// restart, a channel that nobody has joined isn't loaded
+	h.server.channels.Lock()
+	delete(h.server.channels.chans, "#unloaded")
+	h.server.channels.Unlock()
+	recipient.Send("CS", "TRANSFER", "#unloaded", "old")
+	expectNotice(recipient, "when they accept")
+	founder.Send("CS", "TRANSFER", "ACCEPT", "#unloaded")
+	expectNotice(founder, "You are now the founder")
+	if info := h.server.channelRegistry.LoadChannel("#unloaded"); info == nil || info.Founder != "old" || info.AccountToUMode["old"] != modes.ChannelFounder {
+		t.Errorf("stored registration was not transferred: %v", info)
+	}
+	if channels := h.server.accounts.ChannelsForAccount("old"); len(channels) != 1 || channels[0] != "#unloaded" {
+		t.Errorf("incorrect channels for the new founder: %v", channels)
+	}
+}
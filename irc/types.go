@@ -5,8 +5,6 @@
 
 package irc
 
-import "github.com/oragono/oragono/irc/modes"
-
 // ClientSet is a set of clients.
 type ClientSet map[*Client]bool
 
@@ -25,34 +23,5 @@ func (clients ClientSet) Has(client *Client) bool {
 	return clients[client]
 }
 
-// MemberSet is a set of members with modes.
-type MemberSet map[*Client]*modes.ModeSet
-
-// Add adds the given client to this set.
-func (members MemberSet) Add(member *Client) {
-	members[member] = modes.NewModeSet()
-}
-
-// Remove removes the given client from this set.
-func (members MemberSet) Remove(member *Client) {
-	delete(members, member)
-}
-
-// Has returns true if the given client is in this set.
-func (members MemberSet) Has(member *Client) bool {
-	_, ok := members[member]
-	return ok
-}
-
-// AnyHasMode returns true if any of our clients has the given mode.
-func (members MemberSet) AnyHasMode(mode modes.Mode) bool {
-	for _, modes := range members {
-		if modes.HasMode(mode) {
-			return true
-		}
-	}
-	return false
-}
-
 // ChannelSet is a set of channels.
 type ChannelSet map[*Channel]bool
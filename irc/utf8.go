@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// UTF8OnlyConfig controls enforcement of UTF-8 on input from clients.
+type UTF8OnlyConfig struct {
+	Enabled bool
+	// if true, invalid sequences are replaced with U+FFFD instead of the line being rejected
+	Repair bool
+	// if set, invalid lines are assumed to be in this legacy encoding and are converted
+	FallbackEncoding string `yaml:"fallback-encoding"`
+	fallbackEncoding encoding.Encoding
+}
+
+// prepare looks up the fallback encoding, if any.
+func (conf *UTF8OnlyConfig) prepare() (err error) {
+	if conf.FallbackEncoding != "" {
+		conf.fallbackEncoding, err = htmlindex.Get(conf.FallbackEncoding)
+		if err != nil {
+			return fmt.Errorf("unknown fallback encoding %s: %s", conf.FallbackEncoding, err.Error())
+		}
+	}
+	return nil
+}
+
+// processLine checks a line of input from a client. It returns the line (possibly
+// converted or repaired) and whether it's acceptable.
+func (conf *UTF8OnlyConfig) processLine(line string) (result string, ok bool) {
+	if !conf.Enabled || utf8.ValidString(line) {
+		return line, true
+	}
+
+	if conf.fallbackEncoding != nil {
+		converted, err := conf.fallbackEncoding.NewDecoder().String(line)
+		if err == nil && utf8.ValidString(converted) {
+			return converted, true
+		}
+	}
+
+	if conf.Repair {
+		return repairUTF8(line), true
+	}
+	return line, false
+}
+
+// repairUTF8 replaces invalid UTF-8 sequences in a string with U+FFFD.
+func repairUTF8(str string) string {
+	var buf strings.Builder
+	buf.Grow(len(str))
+	for i := 0; i < len(str); {
+		r, size := utf8.DecodeRuneInString(str[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteRune(utf8.RuneError)
+		} else {
+			buf.WriteString(str[i : i+size])
+		}
+		i += size
+	}
+	return buf.String()
+}
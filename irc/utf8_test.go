@@ -0,0 +1,43 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package irc
+
+import (
+	"testing"
+)
+
+func TestUTF8OnlyProcessLine(t *testing.T) {
+	latin1Line := "PRIVMSG #chan :caf\xe9"
+
+	disabled := UTF8OnlyConfig{}
+	if line, ok := disabled.processLine(latin1Line); !ok || line != latin1Line {
+		t.Error("lines should be passed through when enforcement is disabled")
+	}
+
+	reject := UTF8OnlyConfig{Enabled: true}
+	if _, ok := reject.processLine(latin1Line); ok {
+		t.Error("invalid line should be rejected")
+	}
+	if line, ok := reject.processLine("PRIVMSG #chan :café"); !ok || line != "PRIVMSG #chan :café" {
+		t.Error("valid line should be accepted")
+	}
+
+	repair := UTF8OnlyConfig{Enabled: true, Repair: true}
+	if line, ok := repair.processLine(latin1Line); !ok || line != "PRIVMSG #chan :caf�" {
+		t.Errorf("invalid line should be repaired, got %q", line)
+	}
+
+	fallback := UTF8OnlyConfig{Enabled: true, FallbackEncoding: "latin1"}
+	if err := fallback.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := fallback.processLine(latin1Line); !ok || line != "PRIVMSG #chan :café" {
+		t.Errorf("invalid line should be converted, got %q", line)
+	}
+
+	bad := UTF8OnlyConfig{Enabled: true, FallbackEncoding: "not-an-encoding"}
+	if bad.prepare() == nil {
+		t.Error("unknown encoding should be an error")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package utils
+
+import (
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
+
+// Timer is a scheduled callback that can be cancelled.
+type Timer interface {
+	// Stop cancels the timer, returning false if it already fired or was stopped.
+	Stop() bool
+}
+
+// Clock is a source of time and timers; the server's timers take one so that
+// tests can substitute a FakeClock and drive them deterministically.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(duration time.Duration, callback func()) Timer
+}
+
+// WheelClock is a Clock backed by the real time and a TimerWheel.
+type WheelClock struct {
+	Wheel *TimerWheel
+}
+
+func (clock WheelClock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock WheelClock) AfterFunc(duration time.Duration, callback func()) Timer {
+	return clock.Wheel.AfterFunc(duration, callback)
+}
+
+// FakeClock is a Clock whose time only moves when Advance is called; timers
+// that come due are run synchronously, in the goroutine calling Advance.
+type FakeClock struct {
+	lockorder.Tier0Mutex // callbacks are never run with it held
+
+	now    time.Time
+	timers []*fakeTimer
+	seq    uint64
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	callback func()
+	// position in the clock's list of pending timers, or -1
+	index int
+	// breaks ties between timers with the same deadline, in order of creation
+	seq uint64
+}
+
+// NewFakeClock returns a FakeClock whose current time is `now`.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) AfterFunc(duration time.Duration, callback func()) Timer {
+	clock.Lock()
+	defer clock.Unlock()
+	clock.seq++
+	timer := &fakeTimer{
+		clock:    clock,
+		deadline: clock.now.Add(duration),
+		callback: callback,
+		index:    len(clock.timers),
+		seq:      clock.seq,
+	}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// Stop cancels the timer, returning false if it already fired or was stopped.
+func (timer *fakeTimer) Stop() bool {
+	clock := timer.clock
+	clock.Lock()
+	defer clock.Unlock()
+	if timer.index == -1 {
+		return false
+	}
+	clock.removeInternal(timer)
+	return true
+}
+
+func (clock *FakeClock) removeInternal(timer *fakeTimer) {
+	last := len(clock.timers) - 1
+	clock.timers[timer.index] = clock.timers[last]
+	clock.timers[timer.index].index = timer.index
+	clock.timers[last] = nil
+	clock.timers = clock.timers[:last]
+	timer.index = -1
+}
+
+// Pending returns the number of timers that have yet to fire.
+func (clock *FakeClock) Pending() int {
+	clock.Lock()
+	defer clock.Unlock()
+	return len(clock.timers)
+}
+
+// Advance moves the clock forward by `duration`, running the timers that come
+// due (including any that they schedule in turn) in order of their deadlines.
+func (clock *FakeClock) Advance(duration time.Duration) {
+	clock.Lock()
+	target := clock.now.Add(duration)
+	clock.Unlock()
+
+	for {
+		clock.Lock()
+		timer := clock.nextDueInternal(target)
+		if timer == nil {
+			clock.now = target
+			clock.Unlock()
+			return
+		}
+		clock.removeInternal(timer)
+		clock.now = timer.deadline
+		clock.Unlock()
+
+		timer.callback()
+	}
+}
+
+// nextDueInternal returns the earliest timer due by `target`, if any.
+func (clock *FakeClock) nextDueInternal(target time.Time) (next *fakeTimer) {
+	for _, timer := range clock.timers {
+		if timer.deadline.After(target) {
+			continue
+		}
+		if next == nil || timer.deadline.Before(next.deadline) || (timer.deadline.Equal(next.deadline) && timer.seq < next.seq) {
+			next = timer
+		}
+	}
+	return
+}
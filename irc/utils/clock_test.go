@@ -0,0 +1,56 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	var fired []string
+	clock.AfterFunc(3*time.Second, func() { fired = append(fired, "c") })
+	clock.AfterFunc(time.Second, func() {
+		fired = append(fired, "a")
+		// timers scheduled by callbacks run in the same Advance if they're due
+		clock.AfterFunc(time.Second, func() { fired = append(fired, "b") })
+	})
+	stopped := clock.AfterFunc(2*time.Second, func() { fired = append(fired, "stopped") })
+	late := clock.AfterFunc(time.Minute, func() { fired = append(fired, "late") })
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("Stop should succeed exactly once")
+	}
+	if clock.Pending() != 3 {
+		t.Errorf("incorrect count: %d", clock.Pending())
+	}
+
+	clock.Advance(5 * time.Second)
+	if !reflect.DeepEqual(fired, []string{"a", "b", "c"}) {
+		t.Errorf("timers fired out of order: %v", fired)
+	}
+	if !clock.Now().Equal(start.Add(5 * time.Second)) {
+		t.Errorf("incorrect time: %v", clock.Now())
+	}
+	if clock.Pending() != 1 {
+		t.Errorf("incorrect count: %d", clock.Pending())
+	}
+
+	clock.Advance(time.Minute)
+	if len(fired) != 4 || late.Stop() {
+		t.Errorf("timer did not fire: %v", fired)
+	}
+}
+
+func TestWheelClock(t *testing.T) {
+	wheel := newManualWheel(8)
+	var clock Clock = WheelClock{Wheel: wheel}
+	timer := clock.AfterFunc(time.Second, func() {})
+	if wheel.Len() != 1 || !timer.Stop() || wheel.Len() != 0 {
+		t.Errorf("WheelClock should schedule timers on the wheel")
+	}
+}
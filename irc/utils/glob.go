@@ -0,0 +1,195 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
+
+// CompileGlob compiles an IRC-style glob, where `*` matches any sequence of
+// characters and `?` matches any single character, into a regular expression
+// that matches the entire string.
+func CompileGlob(glob string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteByte('^')
+	for _, char := range glob {
+		switch char {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	buf.WriteByte('$')
+	return regexp.Compile(buf.String())
+}
+
+type globKind uint
+
+const (
+	globGeneral  globKind = iota
+	globLiteral           // no wildcards
+	globAll               // only `*`
+	globPrefix            // literal followed by `*`
+	globSuffix            // `*` followed by literal
+	globContains          // literal surrounded by `*`
+)
+
+// Glob is a compiled IRC-style glob, with the same semantics as CompileGlob.
+// Matching a Glob doesn't use regular expressions: the common shapes of glob
+// (no wildcards, or a literal with a leading and/or trailing `*`) are matched
+// with string comparisons, and the rest with a backtracking matcher (which,
+// unlike matching a regular expression, doesn't allocate).
+type Glob struct {
+	pattern string
+	kind    globKind
+	literal string
+}
+
+// NewGlob compiles a glob; most callers should use CompileGlobCached instead.
+func NewGlob(glob string) *Glob {
+	result := &Glob{pattern: glob}
+	trimmed := strings.Trim(glob, "*")
+	if trimmed == "" && glob != "" {
+		result.kind = globAll
+	} else if !strings.ContainsAny(trimmed, "*?") {
+		result.literal = trimmed
+		leading, trailing := strings.HasPrefix(glob, "*"), strings.HasSuffix(glob, "*")
+		if leading && trailing {
+			result.kind = globContains
+		} else if leading {
+			result.kind = globSuffix
+		} else if trailing {
+			result.kind = globPrefix
+		} else {
+			result.kind = globLiteral
+		}
+	}
+	return result
+}
+
+// MatchString returns whether the glob matches the entire string.
+func (glob *Glob) MatchString(str string) bool {
+	switch glob.kind {
+	case globLiteral:
+		return str == glob.literal
+	case globAll:
+		return true
+	case globPrefix:
+		return strings.HasPrefix(str, glob.literal)
+	case globSuffix:
+		return strings.HasSuffix(str, glob.literal)
+	case globContains:
+		return strings.Contains(str, glob.literal)
+	default:
+		return matchGlob(glob.pattern, str)
+	}
+}
+
+// String returns the glob as it was written.
+func (glob *Glob) String() string {
+	return glob.pattern
+}
+
+// matchGlob matches a glob against a string, backtracking to the most
+// recent `*` on a mismatch.
+func matchGlob(pattern, str string) bool {
+	var px, sx int
+	// where to resume after a mismatch: the most recent `*`, and the next
+	// position in str for it to try to absorb
+	retryPx, retrySx := 0, -1
+	for px < len(pattern) || sx < len(str) {
+		if px < len(pattern) {
+			switch char := pattern[px]; char {
+			case '*':
+				retryPx, retrySx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(str) {
+					_, size := utf8.DecodeRuneInString(str[sx:])
+					px++
+					sx += size
+					continue
+				}
+			default:
+				if sx < len(str) && str[sx] == char {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		// mismatch: let the most recent `*` absorb one more character
+		if 0 <= retrySx && retrySx < len(str) {
+			_, size := utf8.DecodeRuneInString(str[retrySx:])
+			retrySx += size
+			px, sx = retryPx+1, retrySx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// GlobCache caches compiled globs, so that a glob that's in use in many places
+// (e.g., a ban mask set in many channels) is only compiled once. Compiled
+// globs are immutable and can be shared freely.
+type GlobCache struct {
+	lockorder.Tier0Mutex
+	maxSize int
+	globs   map[string]*Glob
+}
+
+// NewGlobCache returns a cache holding at most maxSize globs.
+func NewGlobCache(maxSize int) *GlobCache {
+	return &GlobCache{
+		maxSize: maxSize,
+		globs:   make(map[string]*Glob),
+	}
+}
+
+// Compile returns the compiled glob, compiling it if it's not in the cache.
+func (cache *GlobCache) Compile(glob string) *Glob {
+	cache.Lock()
+	result, ok := cache.globs[glob]
+	cache.Unlock()
+	if ok {
+		return result
+	}
+
+	result = NewGlob(glob)
+
+	cache.Lock()
+	defer cache.Unlock()
+	// when the cache fills up, start over; globs in use stay referenced
+	// by their users, so this only costs recompilations
+	if cache.maxSize <= len(cache.globs) {
+		cache.globs = make(map[string]*Glob)
+	}
+	cache.globs[glob] = result
+	return result
+}
+
+// Len returns the number of globs in the cache.
+func (cache *GlobCache) Len() int {
+	cache.Lock()
+	defer cache.Unlock()
+	return len(cache.globs)
+}
+
+const defaultGlobCacheSize = 16384
+
+var defaultGlobCache = NewGlobCache(defaultGlobCacheSize)
+
+// CompileGlobCached compiles a glob using a process-wide cache.
+func CompileGlobCached(glob string) *Glob {
+	return defaultGlobCache.Compile(glob)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+	assertMatches := func(glob, str string, expected bool) {
+		re, err := CompileGlob(glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if re.MatchString(str) != expected {
+			t.Errorf("expected %v for %s matching %s", expected, glob, str)
+		}
+	}
+
+	assertMatches("#*irc*", "#oragono-irc", true)
+	assertMatches("#*irc*", "#irc", true)
+	assertMatches("#*irc*", "#oragono", false)
+	assertMatches("#?", "#a", true)
+	assertMatches("#?", "#ab", false)
+	assertMatches("#a.b", "#axb", false)
+	assertMatches("#[a]", "#[a]", true)
+}
+
+func TestGlob(t *testing.T) {
+	globs := []string{
+		"", "*", "**", "?", "abc", "abc*", "*abc", "*abc*", "a*c", "a?c", "*!*@example.com",
+		"dan!*@*", "*!~*@*.example.com", "*a*a*", "?*?", "*é", "?é", "#[a]*", "a.c",
+	}
+	strs := []string{
+		"", "a", "abc", "abcabc", "xabcx", "ac", "abbc", "axc", "a.c", "aa", "aaa",
+		"dan!~d@example.com", "dan!d@www.example.com", "x!~y@mail.example.com", "é", "aé", "#[a]b",
+	}
+	for _, glob := range globs {
+		re, err := CompileGlob(glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compiled := NewGlob(glob)
+		if compiled.String() != glob {
+			t.Errorf("glob %s was stored as %s", glob, compiled.String())
+		}
+		for _, str := range strs {
+			if expected := re.MatchString(str); compiled.MatchString(str) != expected {
+				t.Errorf("expected %v for %s matching %s", expected, glob, str)
+			}
+		}
+	}
+}
+
+func TestGlobCache(t *testing.T) {
+	cache := NewGlobCache(2)
+	glob := cache.Compile("*!*@example.com")
+	if cache.Compile("*!*@example.com") != glob {
+		t.Errorf("cached glob should be reused")
+	}
+	cache.Compile("dan!*@*")
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 cached globs, got %d", cache.Len())
+	}
+	// the cache starts over when it's full
+	cache.Compile("*!*@other.example.com")
+	if cache.Len() != 1 {
+		t.Errorf("expected 1 cached glob, got %d", cache.Len())
+	}
+	if !glob.MatchString("dan!~d@example.com") {
+		t.Errorf("evicted globs should still work")
+	}
+}
+
+const benchmarkGlob = "*!*@*.example.com"
+const benchmarkUserhost = "dan!~d@irc.example.org"
+
+func BenchmarkGlobMatch(b *testing.B) {
+	glob := NewGlob(benchmarkGlob)
+	for i := 0; i < b.N; i++ {
+		glob.MatchString(benchmarkUserhost)
+	}
+}
+
+func BenchmarkRegexpGlobMatch(b *testing.B) {
+	re, _ := CompileGlob(benchmarkGlob)
+	for i := 0; i < b.N; i++ {
+		re.MatchString(benchmarkUserhost)
+	}
+}
+
+func BenchmarkCompileGlob(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		CompileGlob(benchmarkGlob)
+	}
+}
+
+func BenchmarkCompileGlobCached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		CompileGlobCached(benchmarkGlob)
+	}
+}
@@ -3,7 +3,12 @@
 
 package utils
 
-import "bytes"
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
 
 // WordWrap wraps the given text into a series of lines that don't exceed lineWidth characters.
 func WordWrap(text string, lineWidth int) []string {
@@ -23,13 +28,18 @@ func WordWrap(text string, lineWidth int) []string {
 			cacheLine.Write(cacheWord.Bytes())
 			cacheLine.WriteRune(char)
 			cacheWord.Reset()
-		} else if lineWidth <= cacheLine.Len()+cacheWord.Len()+1 {
+		} else if lineWidth <= cacheLine.Len()+cacheWord.Len()+utf8.RuneLen(char) {
 			// time to wrap to next line
 			if cacheLine.Len() < (lineWidth / 2) {
 				// this word takes up more than half a line... just split in the middle of the word
 				cacheLine.Write(cacheWord.Bytes())
-				cacheLine.WriteRune(char)
 				cacheWord.Reset()
+				if cacheLine.Len()+utf8.RuneLen(char) <= lineWidth {
+					cacheLine.WriteRune(char)
+				} else {
+					// a multibyte character that doesn't fit starts the next line
+					cacheWord.WriteRune(char)
+				}
 			} else {
 				cacheWord.WriteRune(char)
 			}
@@ -50,32 +60,122 @@ func WordWrap(text string, lineWidth int) []string {
 	return lines
 }
 
+// TruncateUTF8Safe truncates `message` to at most `byteLimit` bytes, without
+// splitting any UTF-8 sequences.
+func TruncateUTF8Safe(message string, byteLimit int) string {
+	if len(message) <= byteLimit {
+		return message
+	}
+	message = message[:byteLimit]
+	for 0 < len(message) {
+		r, size := utf8.DecodeLastRuneInString(message)
+		if r != utf8.RuneError || 1 < size {
+			break
+		}
+		// a partial sequence was cut off at the end; drop its remaining bytes
+		message = message[:len(message)-1]
+	}
+	return message
+}
+
+// BuildTokenLines joins tokens into lines of at most `lineLen` bytes, separated by `delim`.
+// Tokens that wouldn't fit on a line by themselves are truncated (UTF-8-safely).
+func BuildTokenLines(lineLen int, tokens []string, delim string) (lines []string) {
+	var buffer bytes.Buffer
+	for _, token := range tokens {
+		token = TruncateUTF8Safe(token, lineLen)
+		if 0 < buffer.Len() && lineLen < buffer.Len()+len(delim)+len(token) {
+			lines = append(lines, buffer.String())
+			buffer.Reset()
+		}
+		if 0 < buffer.Len() {
+			buffer.WriteString(delim)
+		}
+		buffer.WriteString(token)
+	}
+	if 0 < buffer.Len() {
+		lines = append(lines, buffer.String())
+	}
+	return
+}
+
 type MessagePair struct {
 	Message string
 	Msgid   string
 }
 
 // SplitMessage represents a message that's been split for sending.
+// Wrapping is deferred until a recipient actually needs it (often, all the
+// recipients support long lines), and then cached, so that copies of the
+// SplitMessage (e.g., in history buffers) share the same wrapped lines and msgids.
 type SplitMessage struct {
 	MessagePair
-	Wrapped []MessagePair // if this is nil, `Message` didn't need wrapping and can be sent to anyone
+	// if this is nil, `Message` doesn't need wrapping and can be sent to anyone
+	wrapped *wrappedMessage
+}
+
+// wrappedMessage caches the wrappings of a message, by line width.
+type wrappedMessage struct {
+	lockorder.Tier0Mutex
+	byWidth map[int][]MessagePair
+}
+
+const (
+	// minimumLineWidth is the narrowest that messages are wrapped to, regardless
+	// of how much of the line the prefix and parameters take up; messages no
+	// longer than this are never wrapped
+	minimumLineWidth = 100
+)
+
+// Fits returns whether the message can be sent unwrapped on a line whose other
+// contents (prefix, command, and parameters) take up `overhead` bytes, to a client
+// that accepts `maxlenRest` bytes per line. Unwrapped messages are preferred
+// whenever they fit, since wrapping can change their meaning.
+func (sm *SplitMessage) Fits(overhead, maxlenRest int) bool {
+	return sm.wrapped == nil || overhead+len(sm.Message) <= maxlenRest
 }
 
-const defaultLineWidth = 400
+// Wrapped returns the message wrapped to lines of at most `lineWidth` bytes.
+// Each width is only computed once; recipients that need the same width
+// receive the same lines, with the same msgids.
+func (sm *SplitMessage) Wrapped(lineWidth int) []MessagePair {
+	if lineWidth < minimumLineWidth {
+		lineWidth = minimumLineWidth
+	}
+	if sm.wrapped == nil {
+		return []MessagePair{sm.MessagePair}
+	}
+
+	sm.wrapped.Lock()
+	defer sm.wrapped.Unlock()
+	if result, ok := sm.wrapped.byWidth[lineWidth]; ok {
+		return result
+	}
+	lines := WordWrap(sm.Message, lineWidth)
+	result := make([]MessagePair, len(lines))
+	for i, line := range lines {
+		result[i] = MessagePair{
+			Message: line,
+			Msgid:   GenerateSecretToken(),
+		}
+	}
+	if sm.wrapped.byWidth == nil {
+		sm.wrapped.byWidth = make(map[int][]MessagePair)
+	}
+	sm.wrapped.byWidth[lineWidth] = result
+	return result
+}
 
-func MakeSplitMessage(original string, origIs512 bool) (result SplitMessage) {
+// MakeSplitMessage prepares a message for sending. Since the sender's prefix
+// can be much longer than the parameters they sent, any message that isn't
+// short may need to be wrapped for some recipients, even if the sender was
+// limited to 512-byte lines.
+func MakeSplitMessage(original string) (result SplitMessage) {
 	result.Message = original
 	result.Msgid = GenerateSecretToken()
 
-	if !origIs512 && defaultLineWidth < len(original) {
-		wrapped := WordWrap(original, defaultLineWidth)
-		result.Wrapped = make([]MessagePair, len(wrapped))
-		for i, wrappedMessage := range wrapped {
-			result.Wrapped[i] = MessagePair{
-				Message: wrappedMessage,
-				Msgid:   GenerateSecretToken(),
-			}
-		}
+	if minimumLineWidth < len(original) {
+		result.wrapped = new(wrappedMessage)
 	}
 
 	return
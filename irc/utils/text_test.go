@@ -50,6 +50,9 @@ func TestWordWrap(t *testing.T) {
 
 	assertWrapCorrect(threeMusketeers, 40, true, t)
 	assertWrapCorrect(monteCristo, 20, false, t)
+	// multibyte characters must not push lines over the limit
+	assertWrapCorrect(strings.Repeat("\xf0\x9f\x92\xa9", 30), 10, true, t)
+	assertWrapCorrect(strings.Repeat("a\xe2\x98\x83 ", 30), 11, true, t)
 }
 
 func BenchmarkWordWrap(b *testing.B) {
@@ -58,3 +61,101 @@ func BenchmarkWordWrap(b *testing.B) {
 		WordWrap(monteCristo, 60)
 	}
 }
+
+func TestTruncateUTF8Safe(t *testing.T) {
+	cases := []struct {
+		input    string
+		limit    int
+		expected string
+	}{
+		{"abcdef", 10, "abcdef"},
+		{"abcdef", 3, "abc"},
+		{"abc\xe2\x98\x83", 4, "abc"},
+		{"abc\xe2\x98\x83", 5, "abc"},
+		{"abc\xe2\x98\x83", 6, "abc\xe2\x98\x83"},
+		{"\xf0\x9f\x92\xa9", 3, ""},
+	}
+	for _, c := range cases {
+		if result := TruncateUTF8Safe(c.input, c.limit); result != c.expected {
+			t.Errorf("truncating %q to %d: expected %q, got %q", c.input, c.limit, c.expected, result)
+		}
+	}
+}
+
+func TestBuildTokenLines(t *testing.T) {
+	lines := BuildTokenLines(10, []string{"ab", "cd", "ef", "gh"}, " ")
+	if !reflect.DeepEqual(lines, []string{"ab cd ef", "gh"}) {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+
+	lines = BuildTokenLines(5, []string{"ab", "abcdefgh", "cd"}, " ")
+	if !reflect.DeepEqual(lines, []string{"ab", "abcde", "cd"}) {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+
+	if lines = BuildTokenLines(5, nil, " "); lines != nil {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+
+	lines = BuildTokenLines(400, strings.Fields(monteCristo), " ")
+	for _, line := range lines {
+		if 400 < len(line) {
+			t.Errorf("line too long: %d", len(line))
+		}
+	}
+	if strings.Join(lines, " ") != strings.Join(strings.Fields(monteCristo), " ") {
+		t.Errorf("tokens were lost")
+	}
+}
+
+func TestSplitMessageFits(t *testing.T) {
+	short := MakeSplitMessage("hi")
+	if short.wrapped != nil || !short.Fits(500, 512) {
+		t.Errorf("short messages don't need wrapping")
+	}
+
+	long := MakeSplitMessage(strings.Repeat("hello ", 80))
+	if long.Fits(50, 512) {
+		t.Errorf("long message shouldn't fit in a 512-byte line")
+	}
+	if !long.Fits(50, 2048) {
+		t.Errorf("long message should fit in a 2048-byte line")
+	}
+
+	// a message that fit in the sender's 512-byte line may not fit once the
+	// sender's prefix is added
+	medium := MakeSplitMessage(strings.Repeat("hello ", 70))
+	if medium.Fits(200, 512) {
+		t.Errorf("message with a long prefix should need wrapping")
+	}
+}
+
+func TestSplitMessageWrapped(t *testing.T) {
+	message := MakeSplitMessage(strings.Repeat("hello ", 80))
+	if len(message.wrapped.byWidth) != 0 {
+		t.Errorf("message was wrapped eagerly")
+	}
+
+	// copies share the cached wrapping
+	messageCopy := message
+	lines := message.Wrapped(400)
+	copyLines := messageCopy.Wrapped(400)
+	if len(lines) != 2 || !reflect.DeepEqual(lines, copyLines) {
+		t.Errorf("inconsistent wrapping: %v %v", lines, copyLines)
+	}
+	for _, line := range lines {
+		if 400 < len(line.Message) || line.Msgid == "" || line.Msgid == message.Msgid {
+			t.Errorf("bad wrapped line: %v", line)
+		}
+	}
+
+	narrow := message.Wrapped(200)
+	if len(narrow) <= len(lines) || len(message.wrapped.byWidth) != 2 {
+		t.Errorf("widths should be wrapped separately")
+	}
+
+	unwrapped := MakeSplitMessage("hi")
+	if result := unwrapped.Wrapped(400); len(result) != 1 || result[0] != unwrapped.MessagePair {
+		t.Errorf("unwrappable message should be returned whole: %v", result)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
+)
+
+// TimerWheel is a hashed timer wheel: a replacement for time.AfterFunc for
+// large numbers of coarse-grained timers (e.g., one or two per client), that
+// are mostly stopped or reset before they fire. Instead of a runtime timer
+// per callback, a single goroutine advances the wheel once per tick; timers
+// are kept in doubly-linked lists, one per slot, so they can be added and
+// stopped in constant time. A timer fires no earlier than its duration and
+// less than two ticks after it.
+type TimerWheel struct {
+	lockorder.Tier0Mutex // callbacks are never run with it held
+
+	tick    time.Duration
+	slots   []*WheelTimer // sentinel nodes of circular lists
+	current int
+	count   int
+
+	startOnce sync.Once
+	// for testing: if true, the wheel is only advanced by explicit calls to advance()
+	manual bool
+}
+
+// WheelTimer is a callback scheduled on a TimerWheel.
+type WheelTimer struct {
+	wheel    *TimerWheel
+	callback func()
+	// how many more full revolutions of the wheel before the timer fires
+	rounds     int
+	prev, next *WheelTimer
+}
+
+// NewTimerWheel returns a new TimerWheel with the given resolution and number
+// of slots. Timers longer than `tick * size` are supported, at the cost of being
+// examined once per revolution of the wheel.
+func NewTimerWheel(tick time.Duration, size int) *TimerWheel {
+	wheel := &TimerWheel{
+		tick:  tick,
+		slots: make([]*WheelTimer, size),
+	}
+	for i := range wheel.slots {
+		sentinel := new(WheelTimer)
+		sentinel.prev, sentinel.next = sentinel, sentinel
+		wheel.slots[i] = sentinel
+	}
+	return wheel
+}
+
+// AfterFunc schedules `callback` to run in its own goroutine after `duration`;
+// it can be cancelled with the returned timer's Stop method.
+func (wheel *TimerWheel) AfterFunc(duration time.Duration, callback func()) *WheelTimer {
+	if !wheel.manual {
+		wheel.startOnce.Do(func() {
+			go wheel.run()
+		})
+	}
+
+	// the next tick can come at any moment, so the timer is scheduled one
+	// tick later than its duration (rounded up) to keep it from firing early
+	ticks := int((duration+wheel.tick-1)/wheel.tick) + 1
+	if ticks < 1 {
+		ticks = 1
+	}
+	timer := &WheelTimer{
+		wheel:    wheel,
+		callback: callback,
+		rounds:   (ticks - 1) / len(wheel.slots),
+	}
+
+	wheel.Lock()
+	defer wheel.Unlock()
+	sentinel := wheel.slots[(wheel.current+ticks)%len(wheel.slots)]
+	timer.prev, timer.next = sentinel.prev, sentinel
+	sentinel.prev.next = timer
+	sentinel.prev = timer
+	wheel.count++
+	return timer
+}
+
+// Stop cancels the timer, returning false if it already fired or was stopped.
+func (timer *WheelTimer) Stop() bool {
+	if timer == nil {
+		return false
+	}
+	wheel := timer.wheel
+	wheel.Lock()
+	defer wheel.Unlock()
+	if timer.next == nil {
+		return false
+	}
+	wheel.removeInternal(timer)
+	return true
+}
+
+// Len returns the number of pending timers.
+func (wheel *TimerWheel) Len() int {
+	wheel.Lock()
+	defer wheel.Unlock()
+	return wheel.count
+}
+
+func (wheel *TimerWheel) removeInternal(timer *WheelTimer) {
+	timer.prev.next = timer.next
+	timer.next.prev = timer.prev
+	timer.prev, timer.next = nil, nil
+	wheel.count--
+}
+
+func (wheel *TimerWheel) run() {
+	ticker := time.NewTicker(wheel.tick)
+	for range ticker.C {
+		wheel.advance()
+	}
+}
+
+// advance moves the wheel forward by one tick, running the timers that expired.
+func (wheel *TimerWheel) advance() {
+	var expired []func()
+
+	wheel.Lock()
+	wheel.current = (wheel.current + 1) % len(wheel.slots)
+	sentinel := wheel.slots[wheel.current]
+	for timer := sentinel.next; timer != sentinel; {
+		next := timer.next
+		if timer.rounds == 0 {
+			expired = append(expired, timer.callback)
+			wheel.removeInternal(timer)
+		} else {
+			timer.rounds--
+		}
+		timer = next
+	}
+	wheel.Unlock()
+
+	for _, callback := range expired {
+		go callback()
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Shivaram Lingamneni <slingamn@cs.stanford.edu>
+// released under the MIT license
+
+package utils
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newManualWheel(size int) *TimerWheel {
+	wheel := NewTimerWheel(time.Second, size)
+	wheel.manual = true
+	return wheel
+}
+
+// advanceAndWait advances the wheel and waits for the expired callbacks to run
+func advanceAndWait(wheel *TimerWheel, ticks int) {
+	for i := 0; i < ticks; i++ {
+		wheel.advance()
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestTimerWheel(t *testing.T) {
+	wheel := newManualWheel(8)
+	var fired [3]int32
+	wheel.AfterFunc(3*time.Second, func() { atomic.AddInt32(&fired[0], 1) })
+	// longer than one revolution of the wheel
+	wheel.AfterFunc(20*time.Second, func() { atomic.AddInt32(&fired[1], 1) })
+	// rounded up to the next tick
+	wheel.AfterFunc(2500*time.Millisecond, func() { atomic.AddInt32(&fired[2], 1) })
+	if wheel.Len() != 3 {
+		t.Errorf("incorrect count: %d", wheel.Len())
+	}
+
+	// the first tick can come immediately, so timers fire one tick late
+	advanceAndWait(wheel, 3)
+	if atomic.LoadInt32(&fired[0]) != 0 || atomic.LoadInt32(&fired[2]) != 0 {
+		t.Errorf("timers fired early")
+	}
+	advanceAndWait(wheel, 1)
+	if atomic.LoadInt32(&fired[0]) != 1 || atomic.LoadInt32(&fired[2]) != 1 {
+		t.Errorf("timers didn't fire")
+	}
+	advanceAndWait(wheel, 16)
+	if atomic.LoadInt32(&fired[1]) != 0 {
+		t.Errorf("long timer fired early")
+	}
+	advanceAndWait(wheel, 1)
+	if atomic.LoadInt32(&fired[1]) != 1 || atomic.LoadInt32(&fired[0]) != 1 {
+		t.Errorf("long timer didn't fire exactly once")
+	}
+	if wheel.Len() != 0 {
+		t.Errorf("expired timers weren't removed")
+	}
+}
+
+func TestTimerWheelStop(t *testing.T) {
+	wheel := newManualWheel(8)
+	var fired int32
+	timer := wheel.AfterFunc(time.Second, func() { atomic.AddInt32(&fired, 1) })
+	other := wheel.AfterFunc(time.Second, func() {})
+	if !timer.Stop() || timer.Stop() {
+		t.Errorf("incorrect results from Stop")
+	}
+	advanceAndWait(wheel, 2)
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Errorf("stopped timer fired")
+	}
+	if other.Stop() {
+		t.Errorf("Stop should fail on a timer that already fired")
+	}
+	var nilTimer *WheelTimer
+	nilTimer.Stop()
+}
+
+func BenchmarkTimerWheelReset(b *testing.B) {
+	wheel := newManualWheel(1024)
+	timer := wheel.AfterFunc(time.Minute, func() {})
+	for i := 0; i < b.N; i++ {
+		timer.Stop()
+		timer = wheel.AfterFunc(time.Minute, func() {})
+	}
+}
+
+func BenchmarkRuntimeTimerReset(b *testing.B) {
+	timer := time.AfterFunc(time.Minute, func() {})
+	for i := 0; i < b.N; i++ {
+		timer.Stop()
+		timer = time.AfterFunc(time.Minute, func() {})
+	}
+}
@@ -5,7 +5,9 @@
 package irc
 
 import (
-	"sync"
+	"time"
+
+	"github.com/oragono/oragono/irc/lockorder"
 )
 
 // WhoWasList holds our list of prior clients (for use with the WHOWAS command).
@@ -18,8 +20,10 @@ type WhoWasList struct {
 	// if entries exist, they go from `start` to `(end - 1) % length`
 	start int
 	end   int
+	// maximum number of entries to keep for any one nickname (0 for no limit)
+	maxPerNick int
 
-	accessMutex sync.RWMutex // tier 1
+	accessMutex lockorder.Tier1RWMutex
 }
 
 // NewWhoWasList returns a new WhoWasList
@@ -31,6 +35,14 @@ func NewWhoWasList(size int) *WhoWasList {
 	}
 }
 
+// SetMaxPerNick sets the maximum number of entries to keep for any one nickname
+// (0 for no limit). It applies to entries appended afterwards.
+func (list *WhoWasList) SetMaxPerNick(maxPerNick int) {
+	list.accessMutex.Lock()
+	defer list.accessMutex.Unlock()
+	list.maxPerNick = maxPerNick
+}
+
 // Append adds an entry to the WhoWasList.
 func (list *WhoWasList) Append(whowas WhoWas) {
 	list.accessMutex.Lock()
@@ -54,7 +66,35 @@ func (list *WhoWasList) Append(whowas WhoWas) {
 		list.start = list.end // advance start as well, overwriting first entry
 	}
 
+	if whowas.time.IsZero() {
+		whowas.time = time.Now()
+	}
 	list.buffer[pos] = whowas
+	list.expireExtraEntries(pos)
+}
+
+// expireExtraEntries enforces the per-nickname limit for the nickname of the
+// newly appended entry at `newPos`, by blanking out its oldest entries.
+func (list *WhoWasList) expireExtraEntries(newPos int) {
+	if list.maxPerNick == 0 {
+		return
+	}
+	cfnick := list.buffer[newPos].nickCasefolded
+	count := 0
+	pos := newPos
+	for {
+		if list.buffer[pos].nickCasefolded == cfnick {
+			count++
+			if list.maxPerNick < count {
+				// blank entries never match a search
+				list.buffer[pos] = WhoWas{}
+			}
+		}
+		if pos == list.start {
+			break
+		}
+		pos = list.prev(pos)
+	}
 }
 
 // Find tries to find an entry in our WhoWasList with the given details.
@@ -63,7 +103,25 @@ func (list *WhoWasList) Find(nickname string, limit int) (results []WhoWas) {
 	if err != nil {
 		return
 	}
+	return list.search(limit, func(whowas *WhoWas) bool {
+		return whowas.nickCasefolded == casefoldedNickname
+	})
+}
+
+// FindByAccount finds the entries for all the nicknames that were used while
+// logged into the given account, most recent first.
+func (list *WhoWasList) FindByAccount(account string, limit int) (results []WhoWas) {
+	casefoldedAccount, err := CasefoldName(account)
+	if err != nil {
+		return
+	}
+	return list.search(limit, func(whowas *WhoWas) bool {
+		return whowas.account == casefoldedAccount
+	})
+}
 
+// search returns (up to `limit`) entries matching `matches`, most recent first.
+func (list *WhoWasList) search(limit int, matches func(whowas *WhoWas) bool) (results []WhoWas) {
 	list.accessMutex.RLock()
 	defer list.accessMutex.RUnlock()
 
@@ -73,7 +131,7 @@ func (list *WhoWasList) Find(nickname string, limit int) (results []WhoWas) {
 	// iterate backwards through the ring buffer
 	pos := list.prev(list.end)
 	for limit == 0 || len(results) < limit {
-		if casefoldedNickname == list.buffer[pos].nickCasefolded {
+		if list.buffer[pos].nickCasefolded != "" && matches(&list.buffer[pos]) {
 			results = append(results, list.buffer[pos])
 		}
 		if pos == list.start {
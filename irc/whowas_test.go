@@ -99,3 +99,47 @@ func TestEmptyWhoWas(t *testing.T) {
 		t.Fatalf("incorrect whowas results: %v", results)
 	}
 }
+
+func TestWhoWasMaxPerNick(t *testing.T) {
+	wwl := NewWhoWasList(8)
+	wwl.SetMaxPerNick(2)
+
+	for i := 0; i < 4; i++ {
+		wwl.Append(makeTestWhowas("dan-"))
+	}
+	wwl.Append(makeTestWhowas("slingamn"))
+
+	results := wwl.Find("dan-", 0)
+	if len(results) != 2 {
+		t.Fatalf("incorrect whowas results: %v", results)
+	}
+	results = wwl.Find("slingamn", 0)
+	if len(results) != 1 {
+		t.Fatalf("incorrect whowas results: %v", results)
+	}
+}
+
+func TestWhoWasFindByAccount(t *testing.T) {
+	wwl := NewWhoWasList(4)
+
+	entry := makeTestWhowas("dan-")
+	entry.account = "dan"
+	wwl.Append(entry)
+	wwl.Append(makeTestWhowas("slingamn"))
+	entry = makeTestWhowas("dan-away")
+	entry.account = "dan"
+	wwl.Append(entry)
+
+	results := wwl.FindByAccount("dan", 0)
+	if len(results) != 2 || results[0].nick != "dan-away" || results[1].nick != "dan-" {
+		t.Fatalf("incorrect whowas results: %v", results)
+	}
+	results = wwl.FindByAccount("dan", 1)
+	if len(results) != 1 || results[0].nick != "dan-away" {
+		t.Fatalf("incorrect whowas results: %v", results)
+	}
+	results = wwl.FindByAccount("nobody", 0)
+	if len(results) != 0 {
+		t.Fatalf("incorrect whowas results: %v", results)
+	}
+}
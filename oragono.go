@@ -44,6 +44,9 @@ func main() {
 Usage:
 	oragono initdb [--conf <filename>] [--quiet]
 	oragono upgradedb [--conf <filename>] [--quiet]
+	oragono checknames [--conf <filename>] [--quiet]
+	oragono exportdb <file> [--conf <filename>] [--quiet]
+	oragono importdb <file> [--conf <filename>] [--quiet]
 	oragono genpasswd [--conf <filename>] [--quiet]
 	oragono mkcerts [--conf <filename>] [--quiet]
 	oragono run [--conf <filename>] [--quiet]
@@ -109,6 +112,50 @@ Options:
 		if !arguments["--quiet"].(bool) {
 			log.Println("database upgraded: ", config.Datastore.Path)
 		}
+	} else if arguments["checknames"].(bool) {
+		problems, err := irc.CheckNames(config)
+		if err != nil {
+			log.Fatal("Error while checking names:", err.Error())
+		}
+		for _, problem := range problems {
+			log.Println(problem)
+		}
+		if !arguments["--quiet"].(bool) {
+			log.Printf("found %d problems with registered names in %s\n", len(problems), config.Datastore.Path)
+		}
+		if len(problems) != 0 {
+			os.Exit(1)
+		}
+	} else if arguments["exportdb"].(bool) {
+		filename := arguments["<file>"].(string)
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Fatal("Could not create export file: ", err.Error())
+		}
+		err = irc.ExportDB(config, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(filename)
+			log.Fatal("Error while exporting db: ", err.Error())
+		}
+		if !arguments["--quiet"].(bool) {
+			log.Println("database exported: ", filename)
+		}
+	} else if arguments["importdb"].(bool) {
+		file, err := os.Open(arguments["<file>"].(string))
+		if err != nil {
+			log.Fatal("Could not open export file: ", err.Error())
+		}
+		err = irc.ImportDB(config, file)
+		file.Close()
+		if err != nil {
+			log.Fatal("Error while importing db: ", err.Error())
+		}
+		if !arguments["--quiet"].(bool) {
+			log.Println("database imported: ", config.Datastore.Path)
+		}
 	} else if arguments["mkcerts"].(bool) {
 		if !arguments["--quiet"].(bool) {
 			log.Println("making self-signed certificates")